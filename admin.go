@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// adminControl returns the endpoints of the admin API that act on the
+// process: reloading it and shutting it down, as SIGHUP and SIGTERM do.
+func adminControl() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/_admin/reload":   adminSignal("reloading", reloadSignals, syscall.SIGHUP),
+		"/_admin/shutdown": adminSignal("shutting_down", stopSignals, syscall.SIGTERM),
+	}
+}
+
+// adminSignal returns the handler that sends sig to c for POST requests
+// and answers that the process is doing what key says.
+func adminSignal(key string, c chan<- os.Signal, sig os.Signal) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case c <- sig:
+		default: // one is under way already
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]bool{key: true})
+	})
+}
+
+// isLoopback reports whether addr, a host:port, only listens on the
+// loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminTLSConfig returns the configuration of an admin listener that
+// serves HTTPS with cert and only to clients with a certificate signed
+// by one of the CAs in the PEM file caFile.
+func adminTLSConfig(cert tls.Certificate, caFile string) (*tls.Config, error) {
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no PEM certificates", caFile)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// auditCommand defines the flags of the audit command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func auditCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s audit file\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Check the hash chain of the -audit-log file, with the key in AUDIT_KEY if the")
+		fmt.Fprintln(fset.Output(), "server had one, and exit non-zero naming the first line that was changed,")
+		fmt.Fprintln(fset.Output(), "removed or put in. Lines cut off the end leave a whole chain, so compare the")
+		fmt.Fprintln(fset.Output(), "last hash with a copy kept elsewhere.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() != 1 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		f, err := os.Open(fset.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		n, err := staticserver.VerifyAuditLog(f, []byte(os.Getenv("AUDIT_KEY")))
+		if err != nil {
+			log.Fatalf("%s: %v", fset.Arg(0), err)
+		}
+		fmt.Printf("%s: %d lines, the chain is intact\n", fset.Arg(0), n)
+	}
+}
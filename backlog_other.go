@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog is not supported on systems other than Unix.
+func setBacklog(ln net.Listener, n int) error {
+	return errors.New("-backlog is not supported on this system")
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setBacklog listens again on the socket of ln with the backlog n, which
+// replaces the one it was listened with.
+func setBacklog(ln net.Listener, n int) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return errors.New("-backlog: not a socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) { err = syscall.Listen(int(fd), n) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
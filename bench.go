@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// benchSize is a size of the files bench serves, and the share of the
+// requests for files of it.
+type benchSize struct {
+	size   int64
+	weight int
+}
+
+// parseBenchMix parses a mix such as 1K:60,64K:30,1M:10.
+func parseBenchMix(s string) ([]benchSize, error) {
+	var mix []benchSize
+	for _, part := range strings.Split(s, ",") {
+		size, weight, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			weight = "1"
+		}
+		n, err := staticserver.ParseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid weight %q, expected a positive number", weight)
+		}
+		mix = append(mix, benchSize{n, w})
+	}
+	return mix, nil
+}
+
+// benchReport is what a bench run measured.
+type benchReport struct {
+	Concurrency int           `json:"concurrency"`
+	Duration    time.Duration `json:"duration_ns"`
+	Requests    int           `json:"requests"`
+	Errors      int           `json:"errors"`
+	Bytes       int64         `json:"bytes"`
+	RequestsPS  float64       `json:"requests_per_second"`
+	BytesPS     float64       `json:"bytes_per_second"`
+	P50         time.Duration `json:"p50_ns"`
+	P90         time.Duration `json:"p90_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	Max         time.Duration `json:"max_ns"`
+}
+
+// benchCommand defines the flags of the bench command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func benchCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s bench [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Start the server on a loopback port against a temp dir of generated files, fetch")
+		fmt.Fprintln(fset.Output(), "them for -duration with -concurrency clients, and report the requests served,")
+		fmt.Fprintln(fset.Output(), "the throughput and the latency percentiles, to compare one release with another.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	concurrency := fset.Int("concurrency", 16, "fetch with `n` clients at once")
+	duration := fset.Duration("duration", 10*time.Second, "fetch for `duration`")
+	mixFlag := fset.String("mix", "1K:60,64K:30,1M:9,10M:1", "the comma separated `sizes` of the files and the share of the requests for each, as size:weight")
+	files := fset.Int("files", 10, "write `n` files of each size")
+	asJSON := fset.Bool("json", false, "print the report as JSON")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 0 || *concurrency < 1 || *files < 1 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		mix, err := parseBenchMix(*mixFlag)
+		if err != nil {
+			log.Fatalf("-mix: %v", err)
+		}
+		dir, err := os.MkdirTemp("", "static-server-bench")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		paths, err := writeBenchFiles(dir, mix, *files)
+		if err != nil {
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+
+		server, err := staticserver.New(staticserver.Config{Dir: dir})
+		if err != nil {
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+		defer server.Close()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+		srv := &http.Server{Handler: server}
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		report := runBench("http://"+ln.Addr().String(), paths, *concurrency, *duration)
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(report)
+			return
+		}
+		fmt.Printf("%d clients for %s, files of %s\n", report.Concurrency, report.Duration.Round(time.Millisecond), *mixFlag)
+		fmt.Printf("requests:   %d, %.1f/s, %d errors\n", report.Requests, report.RequestsPS, report.Errors)
+		fmt.Printf("throughput: %s/s\n", benchSize{size: int64(report.BytesPS)}.String())
+		fmt.Printf("latency:    p50 %s, p90 %s, p99 %s, max %s\n", report.P50, report.P90, report.P99, report.Max)
+	}
+}
+
+// String returns the size as a short human readable size such as 1.5M.
+func (s benchSize) String() string {
+	const units = "KMGTPE"
+	if s.size < 1024 {
+		return strconv.FormatInt(s.size, 10)
+	}
+	f, i := float64(s.size)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(f, 'f', 1, 64) + units[i:i+1]
+}
+
+// writeBenchFiles writes n files of each size of mix under dir, and
+// returns their paths, each as many times as the weight of its size.
+func writeBenchFiles(dir string, mix []benchSize, n int) ([][]string, error) {
+	block := make([]byte, 64<<10)
+	for i := range block {
+		block[i] = byte(rand.Uint32())
+	}
+	paths := make([][]string, len(mix))
+	for i, s := range mix {
+		for j := range n {
+			name := fmt.Sprintf("%d-%d.bin", s.size, j)
+			f, err := os.Create(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			for left := s.size; left > 0 && err == nil; left -= int64(len(block)) {
+				_, err = f.Write(block[:min(left, int64(len(block)))])
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+			paths[i] = append(paths[i], "/"+name)
+		}
+	}
+	// Pick a size by its weight, then one of its files.
+	var picks [][]string
+	for i, s := range mix {
+		for range s.weight {
+			picks = append(picks, paths[i])
+		}
+	}
+	return picks, nil
+}
+
+// runBench fetches the paths from base with concurrency clients until
+// duration is up.
+func runBench(base string, paths [][]string, concurrency int, duration time.Duration) benchReport {
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency, DisableCompression: true}}
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		report    = benchReport{Concurrency: concurrency}
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	deadline := start.Add(duration)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var own []time.Duration
+			var errs int
+			var bytes int64
+			for time.Now().Before(deadline) {
+				files := paths[rand.IntN(len(paths))]
+				t := time.Now()
+				resp, err := client.Get(base + files[rand.IntN(len(files))])
+				if err != nil {
+					errs++
+					continue
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				bytes += n
+				if err != nil || resp.StatusCode != http.StatusOK {
+					errs++
+					continue
+				}
+				own = append(own, time.Since(t))
+			}
+			mu.Lock()
+			latencies = append(latencies, own...)
+			report.Errors += errs
+			report.Bytes += bytes
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	report.Requests = len(latencies) + report.Errors
+	report.RequestsPS = float64(report.Requests) / report.Duration.Seconds()
+	report.BytesPS = float64(report.Bytes) / report.Duration.Seconds()
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		at := func(q float64) time.Duration { return latencies[int(q*float64(len(latencies)-1))] }
+		report.P50, report.P90, report.P99, report.Max = at(0.5), at(0.9), at(0.99), latencies[len(latencies)-1]
+	}
+	return report
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// scheme is the scheme of the URLs the server is reached by, https if
+// it serves TLS.
+var scheme = "http"
+
+// localURL returns the URL a browser on this machine can reach the
+// listener at addr by.
+func localURL(addr net.Addr) string {
+	host, port := "localhost", ""
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		port = strconv.Itoa(tcp.Port)
+		if !tcp.IP.IsUnspecified() {
+			host = tcp.IP.String()
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/"
+}
+
+// openBrowser opens url in the system's default browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// lanURLs returns the URLs other machines on the network can reach the
+// listener at addr by, one for each of its lanIPs.
+func lanURLs(addr net.Addr) []string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	var urls []string
+	for _, ip := range lanIPs(tcp) {
+		urls = append(urls, scheme+"://"+net.JoinHostPort(ip.String(), strconv.Itoa(tcp.Port))+"/")
+	}
+	return urls
+}
+
+// lanIPs returns the addresses other machines on the network can reach
+// the listener at tcp by: its own address if it is bound to one, and
+// otherwise those of the machine's interfaces that are up, IPv4 first,
+// leaving out loopback and link-local addresses.
+func lanIPs(tcp *net.TCPAddr) []net.IP {
+	if !tcp.IP.IsUnspecified() {
+		if tcp.IP.IsLoopback() {
+			return nil
+		}
+		return []net.IP{tcp.IP}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var v4, v6 []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.IsLoopback() || ipn.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipn.IP.To4() != nil {
+				v4 = append(v4, ipn.IP)
+			} else if tcp.IP.To4() == nil { // an IPv4 listener cannot be reached over IPv6
+				v6 = append(v6, ipn.IP)
+			}
+		}
+	}
+	return append(v4, v6...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A command is a subcommand of static-server, run with the arguments
+// after its name.
+type command struct {
+	name    string
+	summary string
+	args    string // the placeholder of its arguments: dir, file or other
+	// flags defines the flags of the command on fset and returns the
+	// function that runs it with its arguments.
+	flags func(fset *flag.FlagSet) func(args []string)
+}
+
+// commands are the subcommands, in the order they are listed.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "serve a dir, the embedded site or another source (the default)", "dir", serveCommand},
+		{"check", "check the configuration serve would run with, like serve -check", "dir", func(fset *flag.FlagSet) func(args []string) {
+			run := serveCommand(fset)
+			return func(args []string) { run(append([]string{"-check"}, args...)) }
+		}},
+		{"gen-cert", "write a self-signed certificate and key for serve -tls-cert", "", genCertCommand},
+		{"hash-password", "print the bcrypt hash of a password for serve -auth", "user", hashPasswordCommand},
+		{"share", "print an expiring link to a file or dir for serve -share-key", "path", shareCommand},
+		{"precompress", "write compressed siblings of the files of a dir", "", precompressCommand},
+		{"fingerprint", "copy assets to content-hashed names and rewrite references", "", fingerprintCommand},
+		{"mtimes", "record the modification times of files for serve -mtimes", "", mtimesCommand},
+		{"sri", "print or add the Subresource Integrity hashes of files", "file", sriCommand},
+		{"audit", "check that an -audit-log was not changed", "file", auditCommand},
+		{"bench", "load test the server against a temp dir of generated files", "", benchCommand},
+		{"service", "install the server as a service that runs at boot, or uninstall, start or stop it", "action", serviceCommand},
+		{"completion", "print a bash, zsh or fish completion script", "shell", completionCommand},
+	}
+}
+
+// run runs the command with args.
+func (c command) run(args []string) {
+	c.flags(flag.NewFlagSet(c.name, flag.ExitOnError))(args)
+}
+
+// printCommands lists the commands to w.
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		commands[0].run(args)
+		return
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return
+		}
+	}
+	if args[0] == "help" {
+		fmt.Printf("Usage: %s <command> [flags]\n\n", os.Args[0])
+		printCommands(os.Stdout)
+		return
+	}
+	// A dir to serve, as in static-server public, with any flags after it.
+	if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+		commands[0].run(append(args[1:], args[0]))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", os.Args[0], args[0])
+	printCommands(os.Stderr)
+	os.Exit(2)
+}
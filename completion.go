@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// completionShells are the shells completion writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// choicesValue is a flag.Value with a fixed set of values, which
+// completion scripts offer.
+type choicesValue struct {
+	flag.Value
+	choices []string
+}
+
+// String returns the value's String, and "" for the zero choicesValue
+// flag.PrintDefaults makes.
+func (v *choicesValue) String() string {
+	if v.Value == nil {
+		return ""
+	}
+	return v.Value.String()
+}
+
+// setChoices makes choices the values completion offers for the flag
+// name of fset, which must be defined.
+func setChoices(fset *flag.FlagSet, name string, choices []string) {
+	f := fset.Lookup(name)
+	f.Value = &choicesValue{f.Value, choices}
+}
+
+// flagSpec is a flag as the completion scripts see it.
+type flagSpec struct {
+	name        string
+	usage       string
+	placeholder string   // the name of its value, such as file or dir, or "" if it is a bool flag
+	choices     []string // its values, if it has a fixed set
+	repeatable  bool
+}
+
+// commandSpec is a command as the completion scripts see it.
+type commandSpec struct {
+	name    string
+	summary string
+	args    string
+	flags   []flagSpec
+}
+
+// commandSpecs returns the commands with the flags they define.
+func commandSpecs() []commandSpec {
+	var cmds []commandSpec
+	for _, c := range commands {
+		fset := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.flags(fset)
+		cc := commandSpec{name: c.name, summary: c.summary, args: c.args}
+		fset.VisitAll(func(f *flag.Flag) {
+			placeholder, usage := flag.UnquoteUsage(f)
+			cf := flagSpec{name: f.Name, usage: usage, placeholder: placeholder, repeatable: strings.Contains(usage, "(repeatable)")}
+			v := f.Value
+			if r, ok := v.(*recordedValue); ok {
+				v = r.Value
+			}
+			if ch, ok := v.(*choicesValue); ok {
+				cf.choices = ch.choices
+			}
+			if b, ok := v.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				cf.placeholder = ""
+			}
+			cc.flags = append(cc.flags, cf)
+		})
+		cmds = append(cmds, cc)
+	}
+	return cmds
+}
+
+// argChoices returns the values to offer for the arguments of c.
+func (c commandSpec) argChoices() []string {
+	switch c.args {
+	case "shell":
+		return completionShells
+	case "action":
+		return serviceActions
+	}
+	return nil
+}
+
+// completionCommand defines the flags of the completion command on fset
+// and returns the function that runs it with args, the arguments after
+// its name.
+func completionCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		prog := filepath.Base(os.Args[0])
+		fmt.Fprintf(fset.Output(), "Usage: %s completion %s\n\n", os.Args[0], strings.Join(completionShells, "|"))
+		fmt.Fprintln(fset.Output(), "Print a script that completes the commands, flags and flag values for the shell,")
+		fmt.Fprintln(fset.Output(), "to be loaded with one of")
+		fmt.Fprintln(fset.Output())
+		fmt.Fprintf(fset.Output(), "  source <(%s completion bash)\n", prog)
+		fmt.Fprintf(fset.Output(), "  %s completion zsh > \"${fpath[1]}/_%s\"\n", prog, prog)
+		fmt.Fprintf(fset.Output(), "  %s completion fish > ~/.config/fish/completions/%s.fish\n", prog, prog)
+	}
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() != 1 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		prog := filepath.Base(os.Args[0])
+		switch fset.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout, prog, commandSpecs())
+		case "zsh":
+			writeZshCompletion(os.Stdout, prog, commandSpecs())
+		case "fish":
+			writeFishCompletion(os.Stdout, prog, commandSpecs())
+		default:
+			fmt.Fprintf(os.Stderr, "unknown shell %q, expected %s\n", fset.Arg(0), strings.Join(completionShells, ", "))
+			os.Exit(2)
+		}
+	}
+}
+
+// nonIdent matches what cannot be in a shell function name.
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// commandNames returns the names of cmds.
+func commandNames(cmds []commandSpec) []string {
+	names := make([]string, len(cmds))
+	for i, c := range cmds {
+		names[i] = c.name
+	}
+	return names
+}
+
+// writeBashCompletion writes the bash completion script for prog.
+func writeBashCompletion(w io.Writer, prog string, cmds []commandSpec) {
+	fn := "_" + nonIdent.ReplaceAllString(prog, "_")
+	names := strings.Join(commandNames(cmds), " ")
+	fmt.Fprintf(w, "# bash completion for %s, written by %s completion bash.\n", prog, prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=serve")
+	fmt.Fprintln(w, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -d -- \"$cur\"))\n", names)
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase ${COMP_WORDS[1]} in")
+	fmt.Fprintf(w, "\t%s) cmd=${COMP_WORDS[1]} ;;\n", strings.ReplaceAll(names, " ", "|"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tcase $cmd in")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "\t%s)\n", c.name)
+		byAction := map[string][]string{}
+		var actions, flags []string
+		for _, f := range c.flags {
+			flags = append(flags, "-"+f.name)
+			if f.placeholder == "" {
+				continue
+			}
+			action := "return"
+			switch {
+			case f.choices != nil:
+				action = fmt.Sprintf("COMPREPLY=($(compgen -W %q -- \"$cur\")); return", strings.Join(f.choices, " "))
+			case f.placeholder == "dir":
+				action = "COMPREPLY=($(compgen -d -- \"$cur\")); return"
+			case f.placeholder == "file":
+				action = "COMPREPLY=($(compgen -f -- \"$cur\")); return"
+			}
+			if byAction[action] == nil {
+				actions = append(actions, action)
+			}
+			byAction[action] = append(byAction[action], "-"+f.name)
+		}
+		if len(actions) > 0 {
+			fmt.Fprintln(w, "\t\tcase $prev in")
+			for _, action := range actions {
+				fmt.Fprintf(w, "\t\t%s) %s ;;\n", strings.Join(byAction[action], "|"), action)
+			}
+			fmt.Fprintln(w, "\t\tesac")
+		}
+		fmt.Fprintln(w, "\t\tif [[ $cur == -* ]]; then")
+		fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(flags, " "))
+		switch {
+		case c.argChoices() != nil:
+			fmt.Fprintln(w, "\t\telse")
+			fmt.Fprintf(w, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(c.argChoices(), " "))
+		case c.args == "dir":
+			fmt.Fprintln(w, "\t\telse")
+			fmt.Fprintln(w, "\t\t\tCOMPREPLY=($(compgen -d -- \"$cur\"))")
+		case c.args == "file":
+			fmt.Fprintln(w, "\t\telse")
+			fmt.Fprintln(w, "\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))")
+		}
+		fmt.Fprintln(w, "\t\tfi")
+		fmt.Fprintln(w, "\t\t;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o filenames -F %s %s\n", fn, prog)
+}
+
+// zshQuote quotes s for a single-quoted zsh word.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshEscape escapes the characters special to the _arguments specs in s.
+var zshEscape = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+
+// writeZshCompletion writes the zsh completion script for prog.
+func writeZshCompletion(w io.Writer, prog string, cmds []commandSpec) {
+	fn := "_" + nonIdent.ReplaceAllString(prog, "_")
+	fmt.Fprintf(w, "#compdef %s\n", prog)
+	fmt.Fprintf(w, "# zsh completion for %s, written by %s completion zsh.\n\n", prog, prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, "\tlocal -a commands=(")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(zshEscape.Replace(c.name)+":"+c.summary))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\tlocal cmd=serve")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then")
+	fmt.Fprintln(w, "\t\t_describe -t commands command commands")
+	fmt.Fprintln(w, "\t\t_files -/")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tif (( ${commands[(I)${(b)words[2]}:*]} )); then")
+	fmt.Fprintln(w, "\t\tcmd=$words[2]")
+	fmt.Fprintln(w, "\t\tshift words")
+	fmt.Fprintln(w, "\t\t(( CURRENT-- ))")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase $cmd in")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "\t%s)\n", c.name)
+		fmt.Fprint(w, "\t\t_arguments")
+		for _, f := range c.flags {
+			spec := "-" + f.name + "[" + zshEscape.Replace(strings.ReplaceAll(f.usage, "\n", " ")) + "]"
+			if f.repeatable {
+				spec = "*" + spec
+			}
+			if f.placeholder != "" {
+				action := " "
+				switch {
+				case f.choices != nil:
+					action = "(" + strings.Join(f.choices, " ") + ")"
+				case f.placeholder == "dir":
+					action = "_files -/"
+				case f.placeholder == "file":
+					action = "_files"
+				}
+				spec += ":" + zshEscape.Replace(f.placeholder) + ":" + action
+			}
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote(spec))
+		}
+		switch {
+		case c.argChoices() != nil:
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote("1:"+c.args+":("+strings.Join(c.argChoices(), " ")+")"))
+		case c.args == "dir":
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote("1:dir:_files -/"))
+		case c.args == "file":
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote("*:file:_files"))
+		case c.args != "":
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote("1:"+c.args+": "))
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "\t\t;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	// Run from fpath, the file is the body of fn, which completes too;
+	// sourced, it registers fn.
+	fmt.Fprintf(w, "\nif [[ $funcstack[1] == %s ]]; then\n\t%s \"$@\"\nelse\n\tcompdef %s %s\nfi\n", fn, fn, fn, prog)
+}
+
+// fishQuote quotes s for a single-quoted fish word.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// writeFishCompletion writes the fish completion script for prog.
+func writeFishCompletion(w io.Writer, prog string, cmds []commandSpec) {
+	names := commandNames(cmds)
+	fmt.Fprintf(w, "# fish completion for %s, written by %s completion fish.\n", prog, prog)
+	fmt.Fprintf(w, "complete -c %s -f\n", prog)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", prog, c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a '(__fish_complete_directories)'\n", prog)
+	for _, c := range cmds {
+		cond := fishQuote("__fish_seen_subcommand_from " + c.name)
+		if c.name == "serve" {
+			// serve is the command run without one.
+			cond = fishQuote("not __fish_seen_subcommand_from " + strings.Join(names[1:], " "))
+		}
+		for _, f := range c.flags {
+			opts := ""
+			switch {
+			case f.placeholder == "":
+			case f.choices != nil:
+				opts = " -x -a " + fishQuote(strings.Join(f.choices, " "))
+			case f.placeholder == "dir":
+				opts = " -x -a '(__fish_complete_directories)'"
+			case f.placeholder == "file":
+				opts = " -r -F"
+			default:
+				opts = " -x"
+			}
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s%s -d %s\n", prog, cond, f.name, opts, fishQuote(strings.ReplaceAll(f.usage, "\n", " ")))
+		}
+		if c.name == "serve" {
+			continue // its dir is offered with the commands
+		}
+		switch {
+		case c.argChoices() != nil:
+			fmt.Fprintf(w, "complete -c %s -n %s -a %s\n", prog, cond, fishQuote(strings.Join(c.argChoices(), " ")))
+		case c.args == "dir":
+			fmt.Fprintf(w, "complete -c %s -n %s -a '(__fish_complete_directories)'\n", prog, cond)
+		case c.args == "file":
+			fmt.Fprintf(w, "complete -c %s -n %s -F\n", prog, cond)
+		}
+	}
+}
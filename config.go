@@ -0,0 +1,290 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// configSetting is a flag a config file sets, and its value.
+type configSetting struct {
+	flag, value string
+}
+
+// applyConfigFile sets the flags of fset that the TOML, YAML or JSON
+// file name sets and that are not given, so that flags and environment
+// variables override the file. Each key of the file is the name of a flag,
+// and the keys of a table are joined to its name with a dash, so that
+// timeout in a [cgi] table sets -cgi-timeout; an array sets a
+// repeatable flag once for each of its values. The keys of a table
+// named after a flag that are not flags themselves set it to key=value,
+// so that "/api" = "http://localhost:3000" in a [proxy] table is
+// -proxy /api=http://localhost:3000.
+func applyConfigFile(fset *flag.FlagSet, name string, given map[string]bool) error {
+	v, err := staticserver.ReadDataFile(name)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: expected a table of options", name)
+	}
+	var settings []configSetting
+	if err := flattenConfig(fset, m, "", &settings); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	settingSource = name
+	for _, s := range settings {
+		if given[s.flag] {
+			continue
+		}
+		if err := fset.Set(s.flag, s.value); err != nil {
+			return fmt.Errorf("%s: %s: invalid value %q: %v", name, s.flag, s.value, err)
+		}
+	}
+	return nil
+}
+
+// flattenConfig appends the settings of the table m, whose keys are
+// joined to prefix, to settings, in the order of their keys.
+func flattenConfig(fset *flag.FlagSet, m map[string]any, prefix string, settings *[]configSetting) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "-" + k
+		}
+		v := m[k]
+		switch table, isTable := v.(map[string]any); {
+		case name == "config":
+			return fmt.Errorf("a config file cannot name another")
+		case fset.Lookup(name) != nil && isTable:
+			if err := flattenConfig(fset, table, name, settings); err != nil {
+				return err
+			}
+		case fset.Lookup(name) != nil:
+			values, err := configValues(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			for _, s := range values {
+				*settings = append(*settings, configSetting{name, s})
+			}
+		case isTable:
+			if err := flattenConfig(fset, table, name, settings); err != nil {
+				return err
+			}
+		case prefix != "" && fset.Lookup(prefix) != nil:
+			values, err := configValues(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			for _, s := range values {
+				*settings = append(*settings, configSetting{prefix, k + "=" + s})
+			}
+		default:
+			return fmt.Errorf("unknown option %q", name)
+		}
+	}
+	return nil
+}
+
+// configValues returns the flag values of the config value v: one for
+// a scalar, and one for each value of an array.
+func configValues(v any) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []any:
+		var out []string
+		for _, e := range v {
+			if _, ok := e.([]any); ok {
+				return nil, fmt.Errorf("arrays cannot be nested")
+			}
+			s, err := configValues(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s...)
+		}
+		return out, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// envPrefix starts the names of the environment variables that set
+// flags.
+const envPrefix = "STATIC_SERVER_"
+
+// envName returns the name of the environment variable that sets the
+// flag name: -cgi-timeout is set by STATIC_SERVER_CGI_TIMEOUT.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags of fset that the variables of environ, as
+// os.Environ returns them, set and that are not given, and adds them to
+// given. A repeatable flag is set once for each variable named after it
+// with a number, such as STATIC_SERVER_PROXY_1 and STATIC_SERVER_PROXY_2,
+// in the order of the numbers. Variables with the prefix that name no
+// flag are reported.
+func applyEnv(fset *flag.FlagSet, environ []string, given map[string]bool) error {
+	flags := map[string]string{}
+	fset.VisitAll(func(f *flag.Flag) { flags[envName(f.Name)] = f.Name })
+	type envSetting struct {
+		flag  string
+		n     int // the number of a repeated variable, 0 if it is not
+		value string
+	}
+	var settings []envSetting
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, envPrefix) {
+			continue
+		}
+		name, n := flags[k], 0
+		if name == "" {
+			if i := strings.LastIndexByte(k, '_'); i > 0 {
+				if num, err := strconv.Atoi(k[i+1:]); err == nil && num > 0 {
+					name, n = flags[k[:i]], num
+				}
+			}
+		}
+		if name == "" {
+			log.Printf("warning: %s sets no flag", k)
+			continue
+		}
+		if !given[name] {
+			settings = append(settings, envSetting{name, n, v})
+		}
+	}
+	sort.SliceStable(settings, func(i, j int) bool {
+		if settings[i].flag != settings[j].flag {
+			return settings[i].flag < settings[j].flag
+		}
+		return settings[i].n < settings[j].n
+	})
+	settingSource = "the environment"
+	for _, s := range settings {
+		if err := fset.Set(s.flag, s.value); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %v", envName(s.flag), s.value, err)
+		}
+	}
+	for _, s := range settings {
+		given[s.flag] = true
+	}
+	return nil
+}
+
+// settingSource is where the flag values being set come from, for
+// -print-config.
+var settingSource = "the command line"
+
+// recordedValue is a flag.Value that remembers the values it is set to
+// and where they came from, so that the configuration can be printed
+// even for flags whose String is empty, as flag.Func's is.
+type recordedValue struct {
+	flag.Value
+	values []string
+	source string
+}
+
+func (v *recordedValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	v.values, v.source = append(v.values, s), settingSource
+	return nil
+}
+
+// String returns the value's String, and "" for the zero recordedValue
+// flag.PrintDefaults makes to find out whether a default is the zero one.
+func (v *recordedValue) String() string {
+	if v.Value == nil {
+		return ""
+	}
+	return v.Value.String()
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// recordFlags makes every flag of fset remember its values. It must be
+// called after the flags are defined and before they are parsed.
+func recordFlags(fset *flag.FlagSet) {
+	fset.VisitAll(func(f *flag.Flag) { f.Value = &recordedValue{Value: f.Value} })
+}
+
+// secretFlags are the flags whose values have passwords in them, which
+// are left out of the printed configuration.
+var secretFlags = map[string]bool{"auth": true}
+
+// printConfig writes every flag of fset but those that only act on the
+// configuration as a TOML config file, in the order of their names,
+// with a comment saying where each value came from; flags with no value
+// are commented out. Passwords, of accounts and in URLs, are replaced
+// with "REDACTED".
+func printConfig(w io.Writer, fset *flag.FlagSet) {
+	skip := map[string]bool{"config": true, "check": true, "print-config": true, "version": true}
+	fset.VisitAll(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		v, _ := f.Value.(*recordedValue)
+		source := "default"
+		values := []string{f.Value.String()}
+		if v != nil && len(v.values) > 0 {
+			source = v.source
+			if values[0] == "" || len(v.values) > 1 && values[0] == v.values[len(v.values)-1] {
+				values = v.values
+			}
+		}
+		if source == "default" && values[0] == "" {
+			fmt.Fprintf(w, "# %s = \"\" # default\n", f.Name)
+			return
+		}
+		if v != nil && v.IsBoolFlag() {
+			fmt.Fprintf(w, "%s = %s # %s\n", f.Name, values[0], source)
+			return
+		}
+		quoted := make([]string, len(values))
+		for i, s := range values {
+			if secretFlags[f.Name] {
+				user, _, _ := strings.Cut(s, ":")
+				s = user + ":REDACTED"
+			} else if u, err := url.Parse(s); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), "REDACTED")
+					s = u.String()
+				}
+			}
+			quoted[i] = strconv.Quote(s)
+		}
+		if len(quoted) == 1 {
+			fmt.Fprintf(w, "%s = %s # %s\n", f.Name, quoted[0], source)
+		} else {
+			fmt.Fprintf(w, "%s = [%s] # %s\n", f.Name, strings.Join(quoted, ", "), source)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// site is the site dir next to this file, baked in at build time.
+//
+//go:embed site
+var site embed.FS
+
+// embeddedSite returns the site baked into the binary, which is served
+// unless -dir is given. Build with
+//
+//	go build -tags embed
+//
+// after copying the site into a dir named site beside server.go.
+// Names starting with a period or an underscore are left out.
+func embeddedSite() fs.FS {
+	sub, err := fs.Sub(site, "site")
+	if err != nil {
+		panic(err) // the embed pattern guarantees the dir exists
+	}
+	return sub
+}
@@ -0,0 +1,11 @@
+//go:build !embed
+
+package main
+
+import "io/fs"
+
+// embeddedSite returns nil, as the binary was built without -tags embed
+// and so has no site baked in.
+func embeddedSite() fs.FS {
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// fingerprintCommand defines the flags of the fingerprint command on
+// fset and returns the function that runs it with args, the arguments
+// after its name.
+func fingerprintCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s fingerprint [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Copy the assets of a dir to names with the hash of their content in them, rewrite")
+		fmt.Fprintln(fset.Output(), "the references to them in its HTML and CSS, and write a manifest -manifest serves.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	dir := fset.String("dir", ".", "the `dir` whose assets are fingerprinted")
+	manifest := fset.String("manifest", "", "write the manifest to `file` instead of "+staticserver.ManifestName+" in -dir")
+	debug := fset.Bool("debug", false, "log each copy made and page rewritten")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 0 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		if *debug {
+			staticserver.SetLevel(staticserver.LevelDebug)
+		}
+		copies, err := staticserver.Fingerprint(*dir, *manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("fingerprinted %d assets\n", len(copies))
+	}
+}
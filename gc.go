@@ -0,0 +1,14 @@
+package main
+
+import "runtime/debug"
+
+// tuneGC sets the garbage collection target percent and the soft memory
+// limit, each if it is positive, overriding GOGC and GOMEMLIMIT.
+func tuneGC(percent int, limit int64) {
+	if percent > 0 {
+		debug.SetGCPercent(percent)
+	}
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// genCertCommand defines the flags of the gen-cert command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func genCertCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s gen-cert [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Write a self-signed certificate and its key, for serving HTTPS in development")
+		fmt.Fprintln(fset.Output(), "with serve -tls-cert and -tls-key. Browsers warn about it until it is trusted.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	hosts := fset.String("hosts", "localhost,127.0.0.1,::1", "the comma separated host `names` and IP addresses the certificate is for")
+	certFile := fset.String("cert", "cert.pem", "write the certificate to `file`")
+	keyFile := fset.String("key", "key.pem", "write the private key to `file`")
+	valid := fset.Duration("valid", 365*24*time.Hour, "how long the certificate is valid for")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 0 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		cert, key, err := selfSignedCert(strings.Split(*hosts, ","), *valid)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*certFile, cert, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*keyFile, key, 0o600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("wrote %s and %s for %s\n", *certFile, *keyFile, *hosts)
+	}
+}
+
+// selfSignedCert returns a PEM certificate for hosts, valid for valid
+// from now, signed by its own ECDSA P-256 key, and the PEM key.
+func selfSignedCert(hosts []string, valid time.Duration) (cert, key []byte, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"static-server"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(valid),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return cert, key, nil
+}
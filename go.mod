@@ -0,0 +1,3 @@
+module github.com/henderjon/static-server
+
+go 1.24
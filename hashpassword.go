@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// hashPasswordCommand defines the flags of the hash-password command on
+// fset and returns the function that runs it with args, the arguments
+// after its name.
+func hashPasswordCommand(fset *flag.FlagSet) func(args []string) {
+	digest := fset.Bool("sha256", false, "print the SHA-256 digest the server compares by instead, which is quicker to check but must be kept as private as the password")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s hash-password [-sha256] [user]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Read a password from the first line of standard input and print its bcrypt")
+		fmt.Fprintln(fset.Output(), "hash, as user:hash if a user is given, for serve -auth or a "+staticserver.DirPasswordName+" file.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 1 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "password: ")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			if err != nil {
+				log.Fatal("no password given")
+			}
+			log.Fatal("the password is empty")
+		}
+		hash := staticserver.HashPassword(password)
+		if !*digest {
+			if hash, err = staticserver.BcryptPassword(password); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if user := fset.Arg(0); user != "" {
+			hash = user + ":" + hash
+		}
+		fmt.Println(hash)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// idleSignal is sent to stopSignals when the server has been idle for
+// the duration -exit-after-idle gives.
+type idleSignal time.Duration
+
+func (s idleSignal) String() string { return fmt.Sprintf("idle for %v", time.Duration(s)) }
+func (s idleSignal) Signal()        {}
+
+// exitAfterIdle returns next with the process stopped, as SIGTERM stops
+// it, once no request has arrived for d and none is in flight.
+func exitAfterIdle(next http.Handler, d time.Duration) http.Handler {
+	var inFlight atomic.Int64
+	var last atomic.Int64 // the time the last request ended, in Unix nanoseconds
+	last.Store(time.Now().UnixNano())
+	go func() {
+		t := time.NewTicker(max(min(d/10, time.Second), time.Millisecond))
+		defer t.Stop()
+		for range t.C {
+			if inFlight.Load() == 0 && time.Since(time.Unix(0, last.Load())) >= d {
+				select {
+				case stopSignals <- idleSignal(d):
+				default:
+				}
+				return
+			}
+		}
+	}()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer func() {
+			last.Store(time.Now().UnixNano())
+			inFlight.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A listener the process listens on, by the name it is handed on to the
+// process that takes over from it on a reload.
+type namedListener struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	listenersMu sync.Mutex
+	listeners   []namedListener
+
+	inheritOnce sync.Once
+	inherited   map[string]*os.File
+)
+
+// reusePort is whether to listen with SO_REUSEPORT, so that another
+// process, such as a new version of the server started beside this one,
+// can listen on the same port before this one stops.
+var reusePort bool
+
+var (
+	// tcpKeepAlive is the period of the TCP keep-alive probes of the
+	// connections accepted, 0 for the default or negative for none.
+	tcpKeepAlive time.Duration
+	// noDelay is whether the connections accepted send small writes at
+	// once rather than wait to coalesce them.
+	noDelay = true
+	// backlog is the length of the queue of the connections waiting to
+	// be accepted, or 0 for the system's default.
+	backlog int
+)
+
+// maxConns is the most connections served at once, or 0 for no limit.
+var maxConns int
+
+// limitListener accepts a connection only while fewer than the capacity
+// of sem are open, leaving the others waiting in the backlog.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func (l limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: sync.OnceFunc(func() { <-l.sem })}, nil
+}
+
+// limitConn is a connection of a limitListener, which frees its place
+// once closed.
+type limitConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// ReadFrom copies r to the connection with its own ReadFrom, so that a
+// TCP connection still sends files with sendfile.
+func (c *limitConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+// reloadEnv returns the variables to add to the environment of the
+// process a reload starts, to carry over state the flags do not hold.
+var reloadEnv func() []string
+
+// inheritedFiles returns the files the process was handed, by name, as
+// systemd socket activation and a reload hand them on: from fd 3 on, as
+// many as LISTEN_FDS says, named by LISTEN_FDNAMES. They are only taken
+// if LISTEN_PID is this process or unset, and the variables are removed,
+// so that child processes do not take them too.
+func inheritedFiles() map[string]*os.File {
+	inheritOnce.Do(func() {
+		inherited = map[string]*os.File{}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if pid := os.Getenv("LISTEN_PID"); err != nil || pid != "" && pid != strconv.Itoa(os.Getpid()) {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := range n {
+			name := "main"
+			if i < len(names) && names[i] != "" && names[i] != "unknown" {
+				name = names[i]
+			}
+			inherited[name] = os.NewFile(uintptr(3+i), name)
+		}
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	return inherited
+}
+
+// listen listens on addr, or takes over the listener named name the
+// process was handed, and remembers it so that it can be handed on.
+func listen(addr, name string) (net.Listener, error) {
+	var ln net.Listener
+	var err error
+	if f, ok := inheritedFiles()[name]; ok {
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		var lc net.ListenConfig
+		if reusePort {
+			lc.Control = setReusePort
+		}
+		ln, err = lc.Listen(context.Background(), "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if backlog > 0 {
+		if err := setBacklog(ln, backlog); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	listenersMu.Lock()
+	listeners = append(listeners, namedListener{name, ln})
+	listenersMu.Unlock()
+	if tcp, ok := ln.(*net.TCPListener); ok && (tcpKeepAlive != 0 || !noDelay) {
+		return tunedListener{tcp}, nil
+	}
+	return ln, nil
+}
+
+// tunedListener sets the TCP keep-alive period and Nagle's algorithm of
+// the connections it accepts as tcpKeepAlive and noDelay say.
+type tunedListener struct {
+	*net.TCPListener
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	c, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	if tcpKeepAlive < 0 {
+		c.SetKeepAlive(false)
+	} else if tcpKeepAlive > 0 {
+		c.SetKeepAlivePeriod(tcpKeepAlive)
+	}
+	c.SetNoDelay(noDelay)
+	return c, nil
+}
+
+// signalReady tells the process that is reloading, if it started this
+// one, that this one is serving and it can stop, or else the service
+// manager that the process is ready.
+func signalReady() {
+	if f, ok := inheritedFiles()["ready"]; ok {
+		f.Write([]byte{1})
+		f.Close()
+		return
+	}
+	sdNotify("READY=1")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// logFile is an io.Writer that appends to a named file, rotating it when
+// it grows past maxSize bytes or gets older than maxAge, and keeping the
+// keep most recent rotated files. A zero maxSize or maxAge disables that
+// trigger. Rotated files are named after the original with the time of
+// rotation appended. reopen lets external tools such as logrotate move
+// the file aside and have writing carry on in a fresh one.
+type logFile struct {
+	name    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// logFiles is every logFile opened, so that all of them can be reopened
+// at once.
+var logFiles struct {
+	sync.Mutex
+	all []*logFile
+}
+
+// openLogFile opens the named log file with the rotation settings of o.
+func openLogFile(name string, o logRotation) (*logFile, error) {
+	l := &logFile{name: name, maxSize: o.maxSize, maxAge: o.maxAge, keep: o.keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	logFiles.Lock()
+	logFiles.all = append(logFiles.all, l)
+	logFiles.Unlock()
+	return l, nil
+}
+
+// logRotation holds the rotation settings given by the -log-* flags.
+type logRotation struct {
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+}
+
+// open opens l.name for appending. It must be called with l.mu held.
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write appends b to the file, rotating it first if it is due.
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if (l.maxSize > 0 && l.size+int64(len(b)) > l.maxSize && l.size > 0) ||
+		(l.maxAge > 0 && time.Since(l.opened) >= l.maxAge) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside, starts a new one and removes
+// rotated files beyond the newest keep. It must be called with l.mu held.
+func (l *logFile) rotate() error {
+	l.f.Close()
+	// To the nanosecond, and numbered if need be, so that rotations close
+	// together do not overwrite each other. The names sort by age.
+	rotated := l.name + "." + time.Now().Format("20060102T150405.000000000")
+	for i, base := 1, rotated; ; i++ {
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = base + "-" + strconv.Itoa(i)
+	}
+	if err := os.Rename(l.name, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if l.keep > 0 {
+		old, _ := filepath.Glob(l.name + ".[0-9]*")
+		sort.Strings(old)
+		for len(old) > l.keep {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+	return nil
+}
+
+// reopen closes the file and opens it again by name.
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.f.Close()
+	return l.open()
+}
+
+// reopenLogFiles reopens every log file, as when one has been moved aside.
+func reopenLogFiles() {
+	logFiles.Lock()
+	defer logFiles.Unlock()
+	for _, l := range logFiles.all {
+		if err := l.reopen(); err != nil {
+			os.Stderr.WriteString("reopening log: " + err.Error() + "\n")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// verbosity is the level set by -quiet, -verbose or -debug.
+var verbosity = staticserver.LevelNormal
+
+// announcef prints a startup message to standard output unless -quiet.
+func announcef(format string, v ...any) {
+	if verbosity >= staticserver.LevelNormal {
+		fmt.Printf(format+"\n", v...)
+	}
+}
+
+// infof logs a warning or notable event unless -quiet.
+func infof(format string, v ...any) {
+	if verbosity >= staticserver.LevelNormal {
+		log.Printf(format, v...)
+	}
+}
+
+// sizeFlag returns a flag.Func compatible function that parses its
+// argument with staticserver.ParseSize and stores the result in p.
+func sizeFlag(p *int64) func(string) error {
+	return func(s string) (err error) {
+		*p, err = staticserver.ParseSize(s)
+		return err
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+// toggleMaintenanceOnSignal does nothing on systems without SIGUSR2;
+// maintenance mode is still turned on and off at /_maintenance.
+func toggleMaintenanceOnSignal(s interface {
+	Maintenance() bool
+	SetMaintenance(bool)
+}) {
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// toggleMaintenanceOnSignal turns the maintenance mode of s on or off
+// each time the process receives SIGUSR2.
+func toggleMaintenanceOnSignal(s interface {
+	Maintenance() bool
+	SetMaintenance(bool)
+}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	go func() {
+		for range c {
+			s.SetMaintenance(!s.Maintenance())
+		}
+	}()
+}
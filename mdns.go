@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file holds a small mDNS responder, enough to advertise the server
+// on the LAN as a DNS-SD service: it answers the queries for the service
+// type, the instance and the host name, and announces them on start. It
+// does not probe for names taken by other hosts.
+
+// The DNS types and classes of the records answered with.
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+
+	dnsClassIN    = 1
+	dnsCacheFlush = 0x8000 // set on records only this host answers for
+)
+
+// mdnsTTL is the time to live of the records answered with.
+const mdnsTTL = 120
+
+var (
+	mdnsGroup4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// dnsName is a domain name by its labels, such as
+// {"files", "_http", "_tcp", "local"}, so that an instance name may hold
+// dots.
+type dnsName []string
+
+func (n dnsName) equal(m dnsName) bool {
+	if len(n) != len(m) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], m[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// dnsRecord is a resource record with its data encoded.
+type dnsRecord struct {
+	name  dnsName
+	typ   uint16
+	class uint16
+	data  []byte
+}
+
+// mdnsResponder advertises a service over mDNS until it is closed.
+type mdnsResponder struct {
+	service  dnsName // such as _http._tcp.local
+	instance dnsName // the service's name, such as files._http._tcp.local
+	host     dnsName // such as myhost.local
+	port     uint16
+	ips      []net.IP
+	txt      []string
+
+	conns     []*net.UDPConn
+	closeOnce sync.Once
+}
+
+// advertiseMDNS advertises the listener at addr on the LAN as the
+// instance name of the DNS-SD service type service, such as _http._tcp,
+// with the TXT record txt.
+func advertiseMDNS(addr net.Addr, name, service string, txt []string) (*mdnsResponder, error) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("mdns: not a TCP listener")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	if name == "" {
+		name = hostname
+	}
+	if len(name) > 63 {
+		return nil, errors.New("mdns: the instance name is longer than 63 bytes")
+	}
+	r := &mdnsResponder{
+		service: append(strings.Split(service, "."), "local"),
+		host:    dnsName{hostname, "local"},
+		port:    uint16(tcp.Port),
+		ips:     lanIPs(tcp),
+		txt:     txt,
+	}
+	r.instance = append(dnsName{name}, r.service...)
+	if len(r.ips) == 0 {
+		return nil, errors.New("mdns: the listener has no network address")
+	}
+	for _, g := range []struct {
+		network string
+		addr    *net.UDPAddr
+	}{{"udp4", mdnsGroup4}, {"udp6", mdnsGroup6}} {
+		if c, err := net.ListenMulticastUDP(g.network, nil, g.addr); err == nil {
+			setMulticastLoop(c)
+			r.conns = append(r.conns, c)
+		} else if g.network == "udp4" {
+			return nil, err
+		}
+	}
+	for _, c := range r.conns {
+		go r.serve(c)
+	}
+	go func() {
+		// Announce twice, a second apart, as RFC 6762 asks.
+		for range 2 {
+			if !r.send(r.answers(mdnsTTL)) {
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	return r, nil
+}
+
+// Close says goodbye, so that the records are dropped from caches, and
+// stops answering.
+func (r *mdnsResponder) Close() {
+	r.closeOnce.Do(func() {
+		r.send(r.answers(0))
+		for _, c := range r.conns {
+			c.Close()
+		}
+	})
+}
+
+// answers returns every record of the service, with the time to live
+// ttl.
+func (r *mdnsResponder) answers(ttl uint32) []byte {
+	return encodeDNSResponse(ttl, r.records(dnsTypeANY, r.instance), r.records(dnsTypeANY, r.host))
+}
+
+// send sends the response msg to the group of each connection, and
+// reports whether the responder is still open.
+func (r *mdnsResponder) send(msg []byte) bool {
+	open := false
+	for _, c := range r.conns {
+		group := mdnsGroup4
+		if c.LocalAddr().(*net.UDPAddr).IP.To4() == nil {
+			group = mdnsGroup6
+		}
+		_, err := c.WriteToUDP(msg, group)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("mdns: %v", err)
+		}
+		open = open || !errors.Is(err, net.ErrClosed)
+	}
+	return open
+}
+
+// serve answers the queries c receives until it is closed.
+func (r *mdnsResponder) serve(c *net.UDPConn) {
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("mdns: %v", err)
+			}
+			return
+		}
+		questions, err := parseDNSQuery(buf[:n])
+		if err != nil {
+			continue
+		}
+		var answers, extra []dnsRecord
+		for _, q := range questions {
+			rs := r.records(q.typ, q.name)
+			answers = append(answers, rs...)
+			if len(rs) > 0 && !q.name.equal(r.host) {
+				extra = append(extra, r.records(dnsTypeANY, r.host)...)
+				if q.name.equal(r.service) {
+					extra = append(extra, r.records(dnsTypeANY, r.instance)[1:]...)
+				}
+			}
+		}
+		if len(answers) > 0 {
+			r.send(encodeDNSResponse(mdnsTTL, answers, extra))
+		}
+	}
+}
+
+// records returns the records of r a question for typ records of name
+// is answered with. Questions for the instance are answered with the
+// PTR of the service too.
+func (r *mdnsResponder) records(typ uint16, name dnsName) []dnsRecord {
+	match := func(t uint16) bool { return typ == t || typ == dnsTypeANY }
+	var rs []dnsRecord
+	switch {
+	case name.equal(dnsName{"_services", "_dns-sd", "_udp", "local"}) && match(dnsTypePTR):
+		rs = append(rs, dnsRecord{name, dnsTypePTR, dnsClassIN, encodeDNSName(nil, r.service)})
+	case name.equal(r.service) && match(dnsTypePTR):
+		rs = append(rs, dnsRecord{r.service, dnsTypePTR, dnsClassIN, encodeDNSName(nil, r.instance)})
+	case name.equal(r.instance):
+		if typ == dnsTypeANY {
+			rs = append(rs, dnsRecord{r.service, dnsTypePTR, dnsClassIN, encodeDNSName(nil, r.instance)})
+		}
+		if match(dnsTypeSRV) {
+			srv := binary.BigEndian.AppendUint16(make([]byte, 4), r.port) // priority and weight 0
+			rs = append(rs, dnsRecord{r.instance, dnsTypeSRV, dnsClassIN | dnsCacheFlush, encodeDNSName(srv, r.host)})
+		}
+		if match(dnsTypeTXT) {
+			var txt []byte
+			for _, s := range r.txt {
+				txt = append(append(txt, byte(len(s))), s...)
+			}
+			if txt == nil {
+				txt = []byte{0}
+			}
+			rs = append(rs, dnsRecord{r.instance, dnsTypeTXT, dnsClassIN | dnsCacheFlush, txt})
+		}
+	case name.equal(r.host):
+		for _, ip := range r.ips {
+			if ip4 := ip.To4(); ip4 != nil && match(dnsTypeA) {
+				rs = append(rs, dnsRecord{r.host, dnsTypeA, dnsClassIN | dnsCacheFlush, ip4})
+			} else if ip4 == nil && match(dnsTypeAAAA) {
+				rs = append(rs, dnsRecord{r.host, dnsTypeAAAA, dnsClassIN | dnsCacheFlush, ip.To16()})
+			}
+		}
+	}
+	return rs
+}
+
+// encodeDNSName appends name to b in the wire format, uncompressed.
+func encodeDNSName(b []byte, name dnsName) []byte {
+	for _, l := range name {
+		b = append(append(b, byte(len(l))), l...)
+	}
+	return append(b, 0)
+}
+
+// encodeDNSResponse returns an authoritative response holding answers
+// and the additional records extra, each with the time to live ttl.
+func encodeDNSResponse(ttl uint32, answers, extra []dnsRecord) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[2:], 0x8400) // a response, authoritative
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(extra)))
+	for _, rr := range append(answers, extra...) {
+		b = encodeDNSName(b, rr.name)
+		b = binary.BigEndian.AppendUint16(b, rr.typ)
+		b = binary.BigEndian.AppendUint16(b, rr.class)
+		b = binary.BigEndian.AppendUint32(b, ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rr.data)))
+		b = append(b, rr.data...)
+	}
+	return b
+}
+
+// dnsQuestion is a question of a query.
+type dnsQuestion struct {
+	name dnsName
+	typ  uint16
+}
+
+var errDNSFormat = errors.New("mdns: malformed message")
+
+// parseDNSQuery returns the questions of the query msg, and an error if
+// it is malformed or a response.
+func parseDNSQuery(msg []byte) ([]dnsQuestion, error) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return nil, errDNSFormat
+	}
+	n := int(binary.BigEndian.Uint16(msg[4:]))
+	off := 12
+	var qs []dnsQuestion
+	for range n {
+		name, next, err := parseDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errDNSFormat
+		}
+		qs = append(qs, dnsQuestion{name, binary.BigEndian.Uint16(msg[next:])})
+		off = next + 4 // the type and the class
+	}
+	return qs, nil
+}
+
+// parseDNSName parses the name at off in msg, following compression
+// pointers, and returns it with the offset after it.
+func parseDNSName(msg []byte, off int) (dnsName, int, error) {
+	var name dnsName
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errDNSFormat
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return name, end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return nil, 0, errDNSFormat
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case l > 63 || off+1+l > len(msg):
+			return nil, 0, errDNSFormat
+		default:
+			name = append(name, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// setMulticastLoop leaves the loopback of multicast packets off on
+// systems other than Unix.
+func setMulticastLoop(c *net.UDPConn) {}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// dnsQuery returns a query with a question for typ records of each name.
+func dnsQuery(typ uint16, names ...dnsName) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(names)))
+	for _, n := range names {
+		b = encodeDNSName(b, n)
+		b = binary.BigEndian.AppendUint16(b, typ)
+		b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	}
+	return b
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	service := dnsName{"_http", "_tcp", "local"}
+	instance := dnsName{"files", "_http", "_tcp", "local"}
+
+	// A second question whose name is "files" and a pointer to the first.
+	compressed := dnsQuery(dnsTypePTR, service)
+	binary.BigEndian.PutUint16(compressed[4:], 2)
+	compressed = append(compressed, 5, 'f', 'i', 'l', 'e', 's', 0xc0, 12, 0, dnsTypeSRV, 0, 1)
+
+	loop := make([]byte, 12)
+	binary.BigEndian.PutUint16(loop[4:], 1)
+	loop = append(loop, 0xc0, 12, 0, 1, 0, 1)
+
+	response := dnsQuery(dnsTypePTR, service)
+	response[2] |= 0x80
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    []dnsQuestion
+		wantErr bool
+	}{
+		{"one question", dnsQuery(dnsTypePTR, service), []dnsQuestion{{service, dnsTypePTR}}, false},
+		{"two questions", dnsQuery(dnsTypeANY, service, instance), []dnsQuestion{{service, dnsTypeANY}, {instance, dnsTypeANY}}, false},
+		{"no questions", dnsQuery(dnsTypeA), nil, false},
+		{"root name", dnsQuery(dnsTypeA, dnsName{}), []dnsQuestion{{nil, dnsTypeA}}, false},
+		{"compressed", compressed, []dnsQuestion{{service, dnsTypePTR}, {instance, dnsTypeSRV}}, false},
+		{"pointer loop", loop, nil, true},
+		{"response", response, nil, true},
+		{"short header", make([]byte, 11), nil, true},
+		{"missing question", dnsQuery(dnsTypeA, service)[:12+5], nil, true},
+		{"missing type", dnsQuery(dnsTypeA, service)[:12+len(encodeDNSName(nil, service))+2], nil, true},
+		{"label too long", append(dnsQuery(dnsTypeA)[:4:4], 0, 1, 0, 0, 0, 0, 0, 0, 64), nil, true},
+		{"truncated pointer", append(dnsQuery(dnsTypeA)[:4:4], 0, 1, 0, 0, 0, 0, 0, 0, 0xc0), nil, true},
+		{"pointer past the end", append(dnsQuery(dnsTypeA)[:4:4], 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0xff, 0, 1, 0, 1), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDNSQuery(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDNSQuery(% x) = %v, %v, want error %v", tt.msg, got, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDNSQuery(% x) = %v, want %v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSNameEqual(t *testing.T) {
+	tests := []struct {
+		a, b dnsName
+		want bool
+	}{
+		{dnsName{"files", "local"}, dnsName{"FILES", "Local"}, true},
+		{dnsName{"a.b", "local"}, dnsName{"a", "b", "local"}, false},
+		{dnsName{"local"}, dnsName{"files", "local"}, false},
+		{nil, dnsName{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.equal(tt.b); got != tt.want {
+			t.Errorf("%v.equal(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// parseDNSResponse returns the names and types of the records of msg.
+func parseDNSResponse(t *testing.T, msg []byte) (answers, extra int, records []dnsQuestion) {
+	t.Helper()
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:]) != 0x8400 {
+		t.Fatalf("response header % x, want an authoritative response", msg[:min(len(msg), 12)])
+	}
+	answers, extra = int(binary.BigEndian.Uint16(msg[6:])), int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for range answers + extra {
+		name, next, err := parseDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			t.Fatalf("record at %d: %v", off, err)
+		}
+		records = append(records, dnsQuestion{name, binary.BigEndian.Uint16(msg[next:])})
+		off = next + 10 + int(binary.BigEndian.Uint16(msg[next+8:]))
+	}
+	if off != len(msg) {
+		t.Fatalf("%d bytes after the records", len(msg)-off)
+	}
+	return answers, extra, records
+}
+
+func TestMDNSRecords(t *testing.T) {
+	r := &mdnsResponder{
+		service:  dnsName{"_http", "_tcp", "local"},
+		instance: dnsName{"my.files", "_http", "_tcp", "local"},
+		host:     dnsName{"box", "local"},
+		port:     8080,
+		ips:      []net.IP{net.IPv4(192, 168, 1, 10), net.ParseIP("fe80::1")},
+		txt:      []string{"path=/"},
+	}
+	tests := []struct {
+		name  string
+		typ   uint16
+		qname dnsName
+		want  []uint16 // the types answered with
+	}{
+		{"service enumeration", dnsTypePTR, dnsName{"_services", "_dns-sd", "_udp", "local"}, []uint16{dnsTypePTR}},
+		{"service", dnsTypePTR, dnsName{"_HTTP", "_tcp", "local"}, []uint16{dnsTypePTR}},
+		{"service of another type", dnsTypeA, dnsName{"_http", "_tcp", "local"}, nil},
+		{"instance", dnsTypeANY, dnsName{"my.files", "_http", "_tcp", "local"}, []uint16{dnsTypePTR, dnsTypeSRV, dnsTypeTXT}},
+		{"instance srv", dnsTypeSRV, dnsName{"my.files", "_http", "_tcp", "local"}, []uint16{dnsTypeSRV}},
+		{"instance txt", dnsTypeTXT, dnsName{"my.files", "_http", "_tcp", "local"}, []uint16{dnsTypeTXT}},
+		{"instance split at its dot", dnsTypeANY, dnsName{"my", "files", "_http", "_tcp", "local"}, nil},
+		{"host", dnsTypeANY, dnsName{"box", "local"}, []uint16{dnsTypeA, dnsTypeAAAA}},
+		{"host a", dnsTypeA, dnsName{"box", "local"}, []uint16{dnsTypeA}},
+		{"host aaaa", dnsTypeAAAA, dnsName{"box", "local"}, []uint16{dnsTypeAAAA}},
+		{"another host", dnsTypeA, dnsName{"other", "local"}, nil},
+	}
+	for _, tt := range tests {
+		var got []uint16
+		for _, rr := range r.records(tt.typ, tt.qname) {
+			got = append(got, rr.typ)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: answered with types %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	srv := r.records(dnsTypeSRV, r.instance)[0].data
+	if port := binary.BigEndian.Uint16(srv[4:]); port != 8080 {
+		t.Errorf("SRV port %d, want 8080", port)
+	}
+	if host, _, err := parseDNSName(srv, 6); err != nil || !host.equal(r.host) {
+		t.Errorf("SRV target %v, %v, want %v", host, err, r.host)
+	}
+	if txt := r.records(dnsTypeTXT, r.instance)[0].data; string(txt) != "\x06path=/" {
+		t.Errorf("TXT data %q, want %q", txt, "\x06path=/")
+	}
+	r.txt = nil
+	if txt := r.records(dnsTypeTXT, r.instance)[0].data; string(txt) != "\x00" {
+		t.Errorf("empty TXT data %q, want a single empty string", txt)
+	}
+
+	answers, extra, records := parseDNSResponse(t, r.answers(mdnsTTL))
+	if answers != 3 || extra != 2 {
+		t.Errorf("announcement of %d answers and %d extra, want 3 and 2: %v", answers, extra, records)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastLoop turns back on the loopback of the multicast packets
+// c sends, which net.ListenMulticastUDP turns off, so that browsers on
+// the same machine see the service too.
+func setMulticastLoop(c *net.UDPConn) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) {
+		if c.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		} else {
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// mtimesCommand defines the flags of the mtimes command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func mtimesCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s mtimes [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Record the modification times and hashes of the files of a dir in a manifest")
+		fmt.Fprintln(fset.Output(), "-mtimes serves them with. Run it after each build with the last manifest: files")
+		fmt.Fprintln(fset.Output(), "that did not change keep the times they had, whatever the deploy sets them to.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	dir := fset.String("dir", ".", "the `dir` whose files are recorded")
+	manifest := fset.String("manifest", "", "write the manifest to `file` instead of "+staticserver.MtimesName+" in -dir")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 0 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		n, kept, err := staticserver.Mtimes(*dir, *manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("recorded %d files, %d unchanged\n", n, kept)
+	}
+}
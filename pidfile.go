@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// writePIDFile writes the ID of the process to the file name, for init
+// scripts to signal the process by. It replaces the file at once, so
+// that it never holds a partial ID.
+func writePIDFile(name string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".pid-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// removePIDFile removes the file name if it still holds the ID of the
+// process, and not that of a process that took over on a reload.
+func removePIDFile(name string) {
+	b, err := os.ReadFile(name)
+	if err == nil && string(bytes.TrimSpace(b)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(name)
+	}
+}
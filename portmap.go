@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file asks the router for a port mapping, over UPnP or NAT-PMP,
+// so that the server can be reached from outside the network for a
+// while.
+
+// portMapLease is how long a mapping is asked for. It is renewed at
+// half of that while the server runs.
+const portMapLease = time.Hour
+
+// portMapper asks a router to forward a TCP port to this machine.
+type portMapper interface {
+	// mapPort forwards an external port to the internal port for lease,
+	// and returns the external address and port.
+	mapPort(port int, lease time.Duration) (net.IP, int, error)
+	// unmapPort removes the mapping of the internal port.
+	unmapPort(port int) error
+	String() string
+}
+
+// portMapping is a mapping kept until it is closed.
+type portMapping struct {
+	mapper   portMapper
+	port     int
+	external net.IP
+	extPort  int
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// mapPortOut asks the router, over UPnP and else NAT-PMP, to forward a
+// port to the listener at addr, and renews the mapping until it is
+// closed.
+func mapPortOut(addr net.Addr) (*portMapping, error) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("port map: not a TCP listener")
+	}
+	var errs []string
+	for _, discover := range []func() (portMapper, error){discoverUPnP, discoverNATPMP} {
+		mapper, err := discover()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		ip, ext, err := mapper.mapPort(tcp.Port, portMapLease)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", mapper, err))
+			continue
+		}
+		m := &portMapping{mapper: mapper, port: tcp.Port, external: ip, extPort: ext, stop: make(chan struct{})}
+		go m.renew()
+		return m, nil
+	}
+	return nil, fmt.Errorf("port map: %s", strings.Join(errs, "; "))
+}
+
+// URL returns the URL the server is reached by from outside.
+func (m *portMapping) URL() string {
+	return scheme + "://" + net.JoinHostPort(m.external.String(), strconv.Itoa(m.extPort)) + "/"
+}
+
+// renew asks for the mapping again at half its lease, until m is
+// closed.
+func (m *portMapping) renew() {
+	t := time.NewTicker(portMapLease / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+			if _, _, err := m.mapper.mapPort(m.port, portMapLease); err != nil {
+				log.Printf("port map: renewing over %v: %v", m.mapper, err)
+			}
+		}
+	}
+}
+
+// Close removes the mapping.
+func (m *portMapping) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		if err := m.mapper.unmapPort(m.port); err != nil {
+			log.Printf("port map: removing over %v: %v", m.mapper, err)
+		}
+	})
+}
+
+// upnpMapper maps ports with the WANIPConnection or WANPPPConnection
+// service of an Internet Gateway Device.
+type upnpMapper struct {
+	control     string // the URL of the service's control endpoint
+	serviceType string
+	local       net.IP // this machine's address on the router's network
+}
+
+func (u *upnpMapper) String() string { return "UPnP" }
+
+// upnpDevice is a device of a UPnP device description.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService returns the service of d or its devices that maps ports.
+func (d upnpDevice) findService() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for _, sub := range d.Devices {
+		if t, u := sub.findService(); t != "" {
+			return t, u
+		}
+	}
+	return "", ""
+}
+
+// discoverUPnP finds the router's Internet Gateway Device with SSDP.
+func discoverUPnP() (portMapper, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ssdp := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	req := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(req), ssdp); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, errors.New("UPnP: no Internet Gateway Device answered")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.Header.Get("Location") == "" {
+			continue
+		}
+		u, err := upnpService(resp.Header.Get("Location"))
+		if err != nil {
+			log.Printf("port map: UPnP: %v", err)
+			continue
+		}
+		u.local = localIPTo(from.IP)
+		return u, nil
+	}
+}
+
+// upnpService reads the device description at location and returns the
+// mapper of its service that maps ports.
+func upnpService(location string) (*upnpMapper, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	serviceType, control := root.Device.findService()
+	if serviceType == "" {
+		return nil, fmt.Errorf("%s: no WANIPConnection service", location)
+	}
+	base, _ := url.Parse(location)
+	c, err := base.Parse(control)
+	if err != nil {
+		return nil, err
+	}
+	return &upnpMapper{control: c.String(), serviceType: serviceType}, nil
+}
+
+// upnpError is the fault a UPnP action failed with.
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e *upnpError) Error() string { return fmt.Sprintf("error %d: %s", e.code, e.description) }
+
+// call invokes the action of the service with args, given in order as
+// name and value pairs, and returns the value of the element result of
+// the response, if it is set.
+func (u *upnpMapper) call(action string, args []string, result string) (string, error) {
+	var body strings.Builder
+	fmt.Fprintf(&body, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%s xmlns:u="%s">`, action, u.serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&body, "<%s>", args[i])
+		xml.EscapeText(&body, []byte(args[i+1]))
+		fmt.Fprintf(&body, "</%s>", args[i])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+	req, err := http.NewRequest(http.MethodPost, u.control, strings.NewReader(body.String()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	values := xmlValues(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		code, _ := strconv.Atoi(values["errorCode"])
+		return "", &upnpError{code, cmp.Or(values["errorDescription"], resp.Status)}
+	}
+	return values[result], nil
+}
+
+// xmlValues returns the text of the elements of the XML document r, by
+// their local names.
+func xmlValues(r io.Reader) map[string]string {
+	values := map[string]string{}
+	d := xml.NewDecoder(r)
+	var name string
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return values
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.CharData:
+			if name != "" {
+				values[name] += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
+
+func (u *upnpMapper) mapPort(port int, lease time.Duration) (net.IP, int, error) {
+	if u.local == nil {
+		return nil, 0, errors.New("no local address on the router's network")
+	}
+	p := strconv.Itoa(port)
+	add := func(lease time.Duration) error {
+		_, err := u.call("AddPortMapping", []string{
+			"NewRemoteHost", "", "NewExternalPort", p, "NewProtocol", "TCP", "NewInternalPort", p,
+			"NewInternalClient", u.local.String(), "NewEnabled", "1", "NewPortMappingDescription", "static-server",
+			"NewLeaseDuration", strconv.Itoa(int(lease.Seconds())),
+		}, "")
+		return err
+	}
+	err := add(lease)
+	if e := (*upnpError)(nil); errors.As(err, &e) && e.code == 725 { // only permanent leases are supported
+		err = add(0)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	s, err := u.call("GetExternalIPAddress", nil, "NewExternalIPAddress")
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid external address %q", s)
+	}
+	return ip, port, nil
+}
+
+func (u *upnpMapper) unmapPort(port int) error {
+	_, err := u.call("DeletePortMapping", []string{"NewRemoteHost", "", "NewExternalPort", strconv.Itoa(port), "NewProtocol", "TCP"}, "")
+	return err
+}
+
+// natpmpMapper maps ports with NAT-PMP, RFC 6886.
+type natpmpMapper struct {
+	gateway net.IP
+}
+
+func (n *natpmpMapper) String() string { return "NAT-PMP" }
+
+// discoverNATPMP returns the mapper of the default gateway, if it
+// answers NAT-PMP.
+func discoverNATPMP() (portMapper, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, fmt.Errorf("NAT-PMP: %v", err)
+	}
+	n := &natpmpMapper{gateway: gw}
+	if _, err := n.externalIP(); err != nil {
+		return nil, fmt.Errorf("NAT-PMP: %s: %v", gw, err)
+	}
+	return n, nil
+}
+
+// request sends the request req to the gateway, retrying as RFC 6886
+// asks, and returns the response of the opcode op.
+func (n *natpmpMapper) request(req []byte, op byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: n.gateway, Port: 5351})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buf := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for range 4 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		wait *= 2
+		for {
+			m, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if m < 8 || buf[0] != 0 || buf[1] != op {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+				return nil, fmt.Errorf("result code %d", code)
+			}
+			return buf[:m], nil
+		}
+	}
+	return nil, errors.New("no answer")
+}
+
+// externalIP returns the external address of the gateway.
+func (n *natpmpMapper) externalIP() (net.IP, error) {
+	resp, err := n.request([]byte{0, 0}, 128)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 12 {
+		return nil, errors.New("short response")
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+func (n *natpmpMapper) mapPort(port int, lease time.Duration) (net.IP, int, error) {
+	req := []byte{0, 2, 0, 0}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = binary.BigEndian.AppendUint32(req, uint32(lease.Seconds()))
+	resp, err := n.request(req, 130)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp) < 16 {
+		return nil, 0, errors.New("short response")
+	}
+	ip, err := n.externalIP()
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, int(binary.BigEndian.Uint16(resp[10:])), nil
+}
+
+func (n *natpmpMapper) unmapPort(port int) error {
+	req := []byte{0, 2, 0, 0}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = append(req, 0, 0, 0, 0, 0, 0) // external port and lifetime 0
+	_, err := n.request(req, 130)
+	return err
+}
+
+// defaultGateway returns the address of the IPv4 default gateway, from
+// the routing table on Linux and otherwise guessed to be the first
+// address of the network of the machine's first LAN address.
+func defaultGateway() (net.IP, error) {
+	if b, err := os.ReadFile("/proc/net/route"); err == nil {
+		for _, line := range strings.Split(string(b), "\n")[1:] {
+			f := strings.Fields(line)
+			if len(f) < 3 || f[1] != "00000000" {
+				continue
+			}
+			gw, err := hex.DecodeString(f[2])
+			if err != nil || len(gw) != 4 {
+				continue
+			}
+			return net.IPv4(gw[3], gw[2], gw[1], gw[0]), nil // little-endian
+		}
+	}
+	for _, ip := range lanIPs(&net.TCPAddr{IP: net.IPv4zero}) {
+		if ip4 := ip.To4(); ip4 != nil {
+			return net.IPv4(ip4[0], ip4[1], ip4[2], 1), nil
+		}
+	}
+	return nil, errors.New("no default gateway")
+}
+
+// localIPTo returns the address of this machine that packets to ip are
+// sent from.
+func localIPTo(ip net.IP) net.IP {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: 1900})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// precompressCommand defines the flags of the precompress command on
+// fset and returns the function that runs it with args, the arguments
+// after its name.
+func precompressCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s precompress [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Write compressed siblings of the text files of a dir, such as app.js.gz, for")
+		fmt.Fprintln(fset.Output(), "-precompressed to serve. Siblings that are up to date are left alone.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	dir := fset.String("dir", ".", "the `dir` whose files are compressed")
+	formats := fset.String("formats", strings.Join(staticserver.PrecompressFormats, ","), "the comma separated `formats` to write; br and zst need the brotli and zstd commands")
+	setChoices(fset, "formats", staticserver.PrecompressFormats)
+	workers := fset.Int("workers", 0, "compress `n` files at once, as many as there are CPUs if 0")
+	var rules staticserver.PrecompressRules
+	fset.Func("min-size", "compress no files smaller than `size` (default 256)", sizeFlag(&rules.MinSize))
+	fset.Func("skip", "compress no files matching `rule`, a path pattern like /vendor/, an extension like .svg or a type like image/*, and remove their siblings (repeatable)", func(s string) error {
+		rules.Skip = append(rules.Skip, s)
+		return nil
+	})
+	debug := fset.Bool("debug", false, "log each sibling written")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() > 0 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		if *debug {
+			staticserver.SetLevel(staticserver.LevelDebug)
+		}
+		n, err := staticserver.Precompress(*dir, strings.Split(*formats, ","), *workers, rules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("wrote %d compressed files\n", n)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// registration describes the instance registered with a service
+// registry.
+type registration struct {
+	name   string   // the name of the service
+	id     string   // the name of this instance
+	addr   string   // the address the instance is reached at
+	port   int      // the port the instance is reached at
+	tags   []string // the tags of the instance
+	health string   // the path its health is checked at over HTTP, or "" for a TCP check
+}
+
+// registryTTL is the time to live of an etcd registration, which is
+// kept alive at a third of it.
+const registryTTL = 30 * time.Second
+
+// registry is a service registry the instance is registered with until
+// it is closed.
+type registry struct {
+	client    http.Client
+	base      *url.URL
+	kind      string // consul or etcd
+	reg       registration
+	stop      chan struct{}
+	mu        sync.Mutex
+	lease     string // the etcd lease, granted again if it is lost
+	closeOnce sync.Once
+}
+
+// parseRegistry parses the -register URL, consul://host:port or
+// etcd://host:port, or with +https after the scheme for a registry
+// served over HTTPS.
+func parseRegistry(s string) (*url.URL, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, "", err
+	}
+	kind, tls := strings.CutSuffix(u.Scheme, "+https")
+	if (kind != "consul" && kind != "etcd") || u.Host == "" {
+		return nil, "", fmt.Errorf("register: invalid registry %q, expected consul://host:port or etcd://host:port", s)
+	}
+	base := &url.URL{Scheme: "http", Host: u.Host, User: u.User}
+	if tls {
+		base.Scheme = "https"
+	}
+	return base, kind, nil
+}
+
+// register registers the listener at addr as reg says with the registry
+// at the -register URL spec, and keeps it registered until it is
+// closed. reg.addr defaults to the first of the listener's lanIPs.
+func register(spec string, addr net.Addr, reg registration) (*registry, error) {
+	base, kind, err := parseRegistry(spec)
+	if err != nil {
+		return nil, err
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("register: not a TCP listener")
+	}
+	reg.port = tcp.Port
+	if reg.addr == "" {
+		ips := lanIPs(tcp)
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("register: the listener has no network address, set -register-addr")
+		}
+		reg.addr = ips[0].String()
+	}
+	if reg.id == "" {
+		host, _ := os.Hostname()
+		reg.id = reg.name + "-" + host + "-" + strconv.Itoa(reg.port)
+	}
+	r := &registry{client: http.Client{Timeout: 10 * time.Second}, base: base, kind: kind, reg: reg, stop: make(chan struct{})}
+	if kind == "consul" {
+		err = r.registerConsul()
+	} else {
+		err = r.registerEtcd()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("register: %s: %v", kind, err)
+	}
+	return r, nil
+}
+
+// call sends v as JSON to the registry's path with method, and decodes
+// the response into out if it is not nil.
+func (r *registry) call(method, path string, v, out any) error {
+	var body io.Reader
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, r.base.JoinPath(path).String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" && r.kind == "consul" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// registerConsul registers the instance with the local Consul agent,
+// with a check of its health that deregisters it if it fails for long.
+func (r *registry) registerConsul() error {
+	hostPort := net.JoinHostPort(r.reg.addr, strconv.Itoa(r.reg.port))
+	check := map[string]any{"Interval": "10s", "Timeout": "5s", "DeregisterCriticalServiceAfter": "10m"}
+	if r.reg.health != "" {
+		check["HTTP"] = scheme + "://" + hostPort + r.reg.health
+		check["TLSSkipVerify"] = true
+	} else {
+		check["TCP"] = hostPort
+	}
+	return r.call(http.MethodPut, "/v1/agent/service/register", map[string]any{
+		"ID": r.reg.id, "Name": r.reg.name, "Address": r.reg.addr, "Port": r.reg.port, "Tags": r.reg.tags, "Check": check,
+	}, nil)
+}
+
+// registerEtcd puts the instance at /services/name/id in etcd, as the
+// JSON object {"addr": "host:port", "tags": [...]}, under a lease that
+// is kept alive until the registry is closed, so that the key goes away
+// if the process dies. A lease that etcd no longer has, as after the
+// registry was unreachable for longer than registryTTL, is replaced
+// with a new one and the key put again.
+func (r *registry) registerEtcd() error {
+	if err := r.putEtcd(); err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(registryTTL / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-t.C:
+				if err := r.keepAliveEtcd(); err != nil {
+					log.Printf("register: etcd: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// putEtcd grants a lease and puts the key of the instance under it.
+func (r *registry) putEtcd() error {
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	if err := r.call(http.MethodPost, "/v3/lease/grant", map[string]any{"TTL": int(registryTTL.Seconds())}, &lease); err != nil {
+		return err
+	}
+	tags := r.reg.tags
+	if tags == nil {
+		tags = []string{}
+	}
+	value, err := json.Marshal(map[string]any{"addr": net.JoinHostPort(r.reg.addr, strconv.Itoa(r.reg.port)), "tags": tags})
+	if err != nil {
+		return err
+	}
+	key := "/services/" + r.reg.name + "/" + r.reg.id
+	b64 := base64.StdEncoding.EncodeToString
+	if err := r.call(http.MethodPost, "/v3/kv/put", map[string]string{"key": b64([]byte(key)), "value": b64(value), "lease": lease.ID}, nil); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.lease = lease.ID
+	r.mu.Unlock()
+	return nil
+}
+
+// keepAliveEtcd renews the lease, or registers the instance again if
+// etcd no longer has it.
+func (r *registry) keepAliveEtcd() error {
+	r.mu.Lock()
+	id := r.lease
+	r.mu.Unlock()
+	var resp struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := r.call(http.MethodPost, "/v3/lease/keepalive", map[string]string{"ID": id}, &resp); err != nil {
+		return err
+	}
+	// etcd answers for a lease it does not have with no TTL left.
+	if ttl, _ := strconv.Atoi(resp.Result.TTL); ttl > 0 {
+		return nil
+	}
+	log.Printf("register: etcd: lease %s was lost, registering again", id)
+	return r.putEtcd()
+}
+
+// Close deregisters the instance.
+func (r *registry) Close() {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		var err error
+		if r.kind == "consul" {
+			err = r.call(http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(r.reg.id), nil, nil)
+		} else {
+			r.mu.Lock()
+			id := r.lease
+			r.mu.Unlock()
+			err = r.call(http.MethodPost, "/v3/lease/revoke", map[string]string{"ID": id}, nil)
+		}
+		if err != nil {
+			log.Printf("register: %s: %v", r.kind, err)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// notifyReload does nothing on systems without SIGHUP.
+func notifyReload(c chan<- os.Signal) {}
+
+// reload is not supported on systems without SIGHUP.
+func reload(background bool) error {
+	return errors.New("reloading and detaching are not supported on this system")
+}
@@ -0,0 +1,112 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// notifyReload sends SIGHUP, the signal to reload on, to c.
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// reload starts the binary again with the same arguments, handing it
+// the listeners so that no connection is refused while it starts up,
+// and waits until it is serving, so that this process can stop. The
+// new process reads the flags, environment, config file and the files
+// they name again. If it fails to start, it is reported and this one
+// goes on serving. If background is set, the new process is detached
+// instead: it runs in a session of its own, with its standard input and
+// output on the null device.
+func reload(background bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	listenersMu.Lock()
+	var files []*os.File
+	var names []string
+	for _, l := range listeners {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			listenersMu.Unlock()
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+		names = append(names, l.name)
+	}
+	listenersMu.Unlock()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	files = append(files, w)
+	names = append(names, "ready")
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	if reloadEnv != nil {
+		env = append(env, reloadEnv()...)
+	}
+	env = append(env, "LISTEN_FDS="+strconv.Itoa(len(files)), "LISTEN_FDNAMES="+strings.Join(names, ":"))
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if background {
+		null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		defer null.Close()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = null, null, null
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return err
+	}
+	w.Close()
+	// Handing the files on put the listeners in blocking mode, which
+	// they share with them, so that closing them would wait for an
+	// Accept blocked in the kernel.
+	for _, f := range files[:len(files)-1] {
+		syscall.SetNonblock(int(f.Fd()), true)
+	}
+	// The new process writes a byte once it is serving; the pipe closes
+	// without one if it exits first.
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n == 1 {
+		// The service manager watches the new process from now on.
+		sdNotify("MAINPID=" + strconv.Itoa(cmd.Process.Pid) + "\nREADY=1")
+		if background {
+			infof("detached as process %d", cmd.Process.Pid)
+		} else {
+			infof("reloaded as process %d", cmd.Process.Pid)
+		}
+		return nil
+	}
+	if err := cmd.Wait(); err != nil && !errors.As(err, new(*exec.ExitError)) {
+		return err
+	}
+	return fmt.Errorf("the new process exited before serving: %v", cmd.ProcessState)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// reopenOnSignal does nothing on systems without SIGUSR1; log files
+// are still rotated by size and age.
+func reopenOnSignal() {}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reopenOnSignal reopens every log file each time the process receives
+// SIGUSR1, the signal logrotate and similar tools send after rotating.
+func reopenOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			reopenLogFiles()
+		}
+	}()
+}
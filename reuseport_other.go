@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setReusePort fails on systems without SO_REUSEPORT.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("-reuse-port is not supported on this system")
+}
@@ -1,101 +1,871 @@
 package main
 
 import (
+	"cmp"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/henderjon/static-server/staticserver"
 )
 
-// isDotF reports whether name contains a path element starting with a period.
-// The name is assumed to be a delimited by forward slashes, as guaranteed
-// by the http.FileSystem interface.
-func isDotF(name string) bool {
-	parts := strings.Split(name, "/")
-	for _, part := range parts {
-		if strings.HasPrefix(part, ".") {
-			return true
+// serveCommand defines the flags of the serve command on fset and
+// returns the function that runs it with args, the arguments after its
+// name: it serves the dir given as its one argument or -dir, or the
+// embedded site, until it is stopped.
+func serveCommand(fset *flag.FlagSet) func(args []string) {
+	cfg := staticserver.Config{
+		Dir:        ".",
+		VHosts:     staticserver.VHosts{},
+		Proxies:    staticserver.Proxies{},
+		CGI:        staticserver.CGIDirs{},
+		Auth:       staticserver.Accounts{},
+		Delay:      staticserver.Delays{},
+		GeoMirrors: staticserver.GeoMirrors{},
+	}
+	cfg.FS = embeddedSite()
+	source := "the embedded site"
+	dirSet := false
+	fset.Func("dir", "the `dir` to serve", func(s string) error {
+		cfg.Dir, cfg.FS, dirSet = s, nil, true
+		return nil
+	})
+	var overlays []string
+	fset.Func("overlay", "serve `dir` as a layer above the ones after it and -dir or another source, so its files shadow theirs (repeatable)", func(s string) error {
+		overlays = append(overlays, s)
+		return nil
+	})
+	fset.Func("zip", "serve the files in the zip archive `file` instead of -dir", func(s string) (err error) {
+		cfg.FS, err = staticserver.OpenZip(s)
+		source = fmt.Sprintf("%q", s)
+		return err
+	})
+	fset.Func("tar", "serve the files in the tar or tar.gz archive `file`, read from standard input if it is -, instead of -dir", func(s string) (err error) {
+		in := os.Stdin
+		if s != "-" {
+			if in, err = os.Open(s); err != nil {
+				return err
+			}
+			defer in.Close()
+			source = fmt.Sprintf("%q", s)
+		} else {
+			source = "the archive from standard input"
+		}
+		cfg.FS, err = staticserver.OpenTar(in)
+		return err
+	})
+	var sqliteDB string
+	fset.Func("sqlite", "serve the files stored in the table of the SQLite database `file` instead of -dir, from its path, content, mtime and content_type columns", func(s string) error {
+		sqliteDB, source = s, fmt.Sprintf("%q", s)
+		return nil
+	})
+	sqliteTable := fset.String("sqlite-table", "files", "the `table` of -sqlite the files are stored in")
+	var bucket staticserver.S3
+	fset.Func("s3", "serve the objects at `s3://bucket/prefix` instead of -dir, signing requests with the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY in the environment", func(s string) error {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme != "s3" || u.Host == "" {
+			return fmt.Errorf("invalid bucket %q, expected s3://bucket/prefix", s)
+		}
+		bucket.Bucket, bucket.Prefix = u.Host, u.Path
+		source = s
+		return nil
+	})
+	fset.StringVar(&bucket.Endpoint, "s3-endpoint", "", "reach -s3 at the `url` of a compatible service such as MinIO instead of AWS")
+	var gcsBucket staticserver.GCS
+	fset.Func("gcs", "serve the objects at `gs://bucket/prefix` instead of -dir, with the application default credentials", func(s string) error {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme != "gs" || u.Host == "" {
+			return fmt.Errorf("invalid bucket %q, expected gs://bucket/prefix", s)
+		}
+		gcsBucket.Bucket, gcsBucket.Prefix = u.Host, u.Path
+		source = s
+		return nil
+	})
+	var container staticserver.Azure
+	fset.Func("azure", "serve the blobs at `account/container/prefix` instead of -dir, signing requests with the AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN in the environment", func(s string) error {
+		parts := strings.SplitN(s, "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid container %q, expected account/container/prefix", s)
 		}
+		container.Account, container.Container = parts[0], parts[1]
+		if len(parts) == 3 {
+			container.Prefix = parts[2]
+		}
+		source = s
+		return nil
+	})
+	fset.StringVar(&container.Endpoint, "azure-endpoint", "", "reach -azure at the blob service `url`, such as Azurite's, instead of Azure")
+	var gitRepo, gitRef string
+	fset.Func("git", "serve the tree of `repo@ref`, a branch, tag or commit of a repository path or URL, instead of -dir, without checking it out", func(s string) error {
+		gitRepo, gitRef = splitGitRef(s)
+		source = s
+		return nil
+	})
+	var gitPull time.Duration
+	fset.DurationVar(&gitPull, "git-pull", 0, "look for new commits on the -git ref every `interval`, fetching them if the repository is a URL")
+	var remote staticserver.SFTP
+	fset.Func("sftp", "serve the files in the directory at `[user@]host:dir` or sftp://[user@]host[:port]/dir instead of -dir, connecting with ssh", func(s string) error {
+		if u, err := url.Parse(s); err == nil && u.Scheme == "sftp" {
+			remote.Host, remote.Path = u.Hostname(), strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				remote.Host = u.User.Username() + "@" + remote.Host
+			}
+			if u.Port() != "" {
+				remote.SSHArgs = []string{"-p", u.Port()}
+			}
+		} else {
+			remote.Host, remote.Path, _ = strings.Cut(s, ":")
+		}
+		if remote.Host == "" {
+			return fmt.Errorf("invalid directory %q, expected [user@]host:dir", s)
+		}
+		source = s
+		return nil
+	})
+	var objectCache staticserver.ObjectCache
+	fset.StringVar(&objectCache.Dir, "object-cache", "", "keep copies of the files read from -s3, -gcs, -azure or -sftp in `dir`, to serve them from disk")
+	fset.Func("object-cache-size", "keep at most `size` of copies in the -object-cache, dropping the least recently used", sizeFlag(&objectCache.MaxSize))
+	addr := ":8080"
+	fset.StringVar(&addr, "addr", addr, "listen on `host:port`, on every interface if host is empty or 0.0.0.0")
+	fset.Func("vhost", "serve `host=dir` for requests to host instead of -dir (repeatable)", cfg.VHosts.Set)
+	fset.BoolVar(&cfg.Versions, "versions", false, "serve the newest of the version dirs of -dir, named by dates or release numbers, and the others at /_v/id/ or with ?version=id")
+	fset.Func("canary", "serve a share of the clients, kept by a cookie, from another build with `dir=share`, such as ./site-v2=10%", cfg.Canary.Set)
+	fset.StringVar(&cfg.UserDirs, "userdirs", "", "serve the public_html dir of each user dir under `dir`, such as /home, at /~user/")
+	fset.StringVar(&cfg.UserDirName, "userdir-name", "public_html", "serve the `name` dir of each -userdirs user")
+	fset.StringVar(&cfg.UserHost, "userdir-host", "", "serve each -userdirs user at user.`host` too")
+	fset.Func("proxy", "forward requests under `prefix=url` to the backend at url (repeatable)", cfg.Proxies.Set)
+	fset.Func("cgi", "run the CGI scripts in dir for requests under `prefix=dir`, as /prefix/script/path/info (repeatable)", cfg.CGI.Set)
+	fset.DurationVar(&cfg.CGITimeout, "cgi-timeout", 30*time.Second, "give up on -cgi scripts that take longer than `duration` to respond, and kill them")
+	fset.Func("cgi-env", "pass the environment variable `name` on to -cgi scripts, which only get the CGI ones and a fixed PATH otherwise (repeatable)", func(s string) error {
+		cfg.CGIEnv = append(cfg.CGIEnv, s)
+		return nil
+	})
+	fset.BoolVar(&cfg.CodeView, "code-view", false, "show .go, .py, .js and other source files to browsers as highlighted pages with line numbers, unless asked for with ?raw=1")
+	fset.BoolVar(&cfg.Preview, "preview", false, "show .json files to browsers as collapsible trees and .csv and .tsv files as sortable tables, unless asked for with ?raw=1")
+	fset.BoolVar(&cfg.Checksums, "checksums", false, "answer ?checksum=sha256, sha512, sha1 or md5 with the sum of a file, and send its Repr-Digest and Digest headers")
+	fset.BoolVar(&cfg.StatAPI, "stat-api", false, "answer ?stat=1 with the size, modification time, content type and SHA-256 of a file as JSON")
+	fset.BoolVar(&cfg.ListingQR, "listing-qr", false, "offer a QR code of each file in listings, of a day's share link with -share-key, and answer ?qr=1 with it")
+	fset.BoolVar(&cfg.ZipDownloads, "zip-downloads", false, "let listings download the files chosen in them as one zip, streamed as it is made, and answer POSTs of a JSON list of names to ?zip=1 on a dir with one")
+	fset.BoolVar(&cfg.DiskUsage, "du", false, "show the size and number of the files under each listed dir, and serve them as JSON at /_api/du?path=")
+	fset.BoolVar(&cfg.TreeAPI, "tree-api", false, "answer ?tree=1 on a dir with the tree of the files under it as nested JSON, limited by &depth=n and &limit=n entries")
+	fset.DurationVar(&cfg.Reindex, "reindex", 0, "make the -sitemap, -search index, -checksums and -precompressed siblings again from the files every `duration`, give or take a tenth")
+	fset.BoolVar(&cfg.Search, "search", false, "index the text, HTML and Markdown files served, kept up to date as they change, and answer searches at /_search?q= and from listings")
+	fset.BoolVar(&cfg.PrecompressWatch, "precompress-watch", false, "with -precompressed, write the siblings of files again as soon as they change on disk, and remove those of files removed")
+	fset.Func("precompress-min-size", "with -reindex or -precompress-watch, write no siblings of files smaller than `size` (default 256)", sizeFlag(&cfg.PrecompressMin))
+	fset.Func("precompress-skip", "with -reindex or -precompress-watch, write no siblings of the files matching `rule`, a path pattern like /vendor/, an extension like .svg or a type like image/*, and remove those they have (repeatable)", func(s string) error {
+		cfg.PrecompressSkip = append(cfg.PrecompressSkip, s)
+		return nil
+	})
+	fset.BoolVar(&cfg.Precompressed, "precompressed", false, "serve files with the .br, .zst or .gz siblings the precompress subcommand writes, to clients that accept them")
+	fset.BoolVar(&cfg.Suggest, "suggest", false, "offer the files whose names differ in case, extension or by a typo from a path that is not found, on its 404 page and in Link headers")
+	fset.BoolVar(&cfg.Sitemap, "sitemap", false, "serve a /sitemap.xml of the HTML and PDF files served, kept up to date as they change, unless the site has its own")
+	fset.Func("sitemap-exclude", "leave the paths matching `pattern`, like /drafts/*, out of the -sitemap (repeatable)", func(s string) error {
+		cfg.SitemapExclude = append(cfg.SitemapExclude, s)
+		return nil
+	})
+	fset.StringVar(&cfg.SitemapBase, "sitemap-base", "", "list the -sitemap pages under `url`, such as https://example.com, instead of the host of each request")
+	fset.StringVar(&cfg.Feed, "feed", "", "serve an Atom feed of the newest files under the dir at URL `path`, such as /releases, at /_feed.xml")
+	fset.IntVar(&cfg.FeedSize, "feed-size", 20, "list the newest `n` files in the -feed")
+	fset.BoolVar(&cfg.IgnorePasswords, "no-dir-passwords", false, "serve the dirs with a "+staticserver.DirPasswordName+" file without asking for its password")
+	fset.BoolVar(&cfg.IgnoreDirConfig, "no-dir-config", false, "ignore the "+staticserver.DirConfigName+" files that set the listing, index names, headers and accounts of the dirs they are in")
+	fset.BoolVar(&cfg.Maintenance, "maintenance", false, "start in maintenance mode, answering every request with 503 and the -maintenance-page until SIGUSR2 or a DELETE to /_maintenance turns it off")
+	fset.StringVar(&cfg.MaintenancePage, "maintenance-page", "", "serve the page in `file` in maintenance mode instead of a plain one")
+	fset.DurationVar(&cfg.MaintenanceRetry, "maintenance-retry", 5*time.Minute, "ask clients to come back after `duration` in maintenance mode")
+	fset.BoolVar(&cfg.Markdown, "markdown", false, "render .md files as HTML pages, unless asked for with ?raw=1")
+	fset.StringVar(&cfg.MarkdownTemplate, "markdown-template", "", "render -markdown files in the html/template in `file`, given .Title, .Path, .Raw and .Body")
+	fset.BoolVar(&cfg.SSI, "ssi", false, "process the <!--#include virtual=\"...\" --> and other server-side includes of .shtml and .html files")
+	fset.StringVar(&cfg.PHP, "php", "", "run .php files under -dir with the FastCGI server at `addr`, such as php-fpm's unix:/run/php-fpm.sock or 127.0.0.1:9000")
+	fset.BoolVar(&cfg.Templates, "templates", false, "execute .tmpl files as html/template pages, given .Method, .Host, .Path, .Query, .Header and .Site")
+	fset.StringVar(&cfg.ManifestResolve, "manifest-resolve", "", "answer requests for the assets in -manifest with their copies, and `serve` them in place or redirect to them")
+	setChoices(fset, "manifest-resolve", staticserver.ManifestResolves)
+	fset.StringVar(&cfg.Manifest, "manifest", "", "serve the fingerprinted copies in the manifest `file` the fingerprint subcommand wrote as never changing, and let -templates pages find them with asset")
+	fset.StringVar(&cfg.Mtimes, "mtimes", "", "send the modification times in the manifest `file` the mtimes subcommand wrote for the files that have not changed since, in place of those on disk")
+	fset.StringVar(&cfg.TemplateData, "template-data", "", "give -templates pages the JSON, YAML or TOML `file` as .Site, read again when it changes")
+	fset.StringVar(&cfg.Mirror, "mirror", "", "fetch files that are not in -dir from the `url` of an upstream, storing them there to serve from then on")
+	fset.Var(&cfg.CacheControl, "cache-control", "send the Cache-Control `pattern=directives`, like /assets/*=max-age=60, stale-while-revalidate=600, for the paths matching pattern, which also say how long -mirror keeps copies (repeatable)")
+	fset.StringVar(&cfg.Fallback, "fallback-proxy", "", "forward requests for files that do not exist to the `url` of an upstream origin")
+	fset.Func("try", "resolve requests under `prefix=candidate ...` to the first candidate that exists, with $path as the request path (repeatable)", cfg.Try.Set)
+	fset.BoolVar(&cfg.CaseInsensitive, "case-insensitive", false, "resolve paths that do not exist exactly without regard to case")
+	fset.Func("alias", "serve the content of `/old=/new` at the old path (repeatable)", cfg.Aliases.Parse(false))
+	fset.Func("redirect", "permanently redirect `/old=/new` to the new path (repeatable)", cfg.Aliases.Parse(true))
+	fset.Func("gone", "respond 410 Gone for the paths listed one per line in `file`", func(s string) (err error) {
+		cfg.Gone, err = staticserver.LoadGoneList(s)
+		return err
+	})
+	fset.BoolVar(&cfg.NoIndex, "noindex", false, "keep every page out of search engines with an X-Robots-Tag: noindex, nofollow header")
+	fset.Func("noindex-path", "only tag the paths matching `pattern`, like /drafts/* or /staging/ and everything under it, with -noindex (repeatable)", func(s string) error {
+		cfg.RobotsPaths = append(cfg.RobotsPaths, s)
+		return nil
+	})
+	fset.StringVar(&cfg.RobotsTag, "robots-tag", "", "send `directives` such as noindex, noarchive as the X-Robots-Tag of -noindex")
+	fset.Func("gone-body", "send the contents of `file` as the body of 410 responses", func(s string) (err error) {
+		cfg.GoneBody, err = os.ReadFile(s)
+		return err
+	})
+	fset.StringVar(&cfg.WWW, "www", "", "redirect to the canonical host by `add`ing or stripping a leading www.")
+	setChoices(fset, "www", staticserver.WWWModes)
+	fset.BoolVar(&cfg.HTTPSRedirect, "https-redirect", false, "redirect plain http requests to https, as reported by X-Forwarded-Proto")
+	fset.BoolVar(&cfg.UnicodeNormalize, "unicode-normalize", false, "resolve paths that do not exist exactly by comparing names in Unicode NFD")
+	fset.StringVar(&cfg.LangRoot, "lang-root", "", "redirect / to the language dir, such as /de/, that a lang cookie or Accept-Language asks for, or else to /`lang`/")
+	fset.StringVar(&cfg.Lang, "lang", "", "serve localized files such as index.`lang`.html by Accept-Language, defaulting to lang")
+	fset.StringVar(&cfg.Form, "form", "", "accept form submissions at `path`")
+	fset.StringVar(&cfg.FormRedirect, "form-redirect", "", "redirect clients to `url` after a form submission")
+	fset.StringVar(&cfg.FormSave, "form-save", "", "append form submissions to `file`, as CSV if it ends in .csv and JSON lines otherwise")
+	fset.StringVar(&cfg.FormWebhook, "form-webhook", "", "forward form submissions as JSON to `url`")
+	fset.Func("form-max-size", "reject form submissions larger than `size` (default 1MB)", sizeFlag(&cfg.FormMaxSize))
+	fset.Func("auth", "allow the account `user:password` to write files (repeatable)", cfg.Auth.Set)
+	fset.BoolVar(&cfg.Put, "put", false, "write the body of PUT requests to the file at their path, requires -auth")
+	fset.BoolVar(&cfg.Delete, "delete", false, "remove the file or empty directory at the path of DELETE requests, requires -auth")
+	fset.Func("max-upload", "reject writes of files larger than `size`", sizeFlag(&cfg.MaxUpload))
+	fset.Func("quota", "reject writes that would take the files in a dir past `size` in total", sizeFlag(&cfg.Quota))
+	fset.StringVar(&cfg.WriteWebhook, "write-webhook", "", "POST a JSON description of every file written or deleted to `url`")
+	fset.Func("scan-cmd", "check written files by running `command` with the file appended, rejecting them unless it exits 0", func(s string) error {
+		cfg.ScanCmd = strings.Fields(s)
+		return nil
+	})
+	fset.StringVar(&cfg.ScanURL, "scan-url", "", "check written files by POSTing them to `url`, rejecting them unless it responds 2xx")
+	fset.StringVar(&cfg.Quarantine, "quarantine", "", "move rejected files to `dir` instead of deleting them")
+	fset.DurationVar(&cfg.ScanTimeout, "scan-timeout", 5*time.Minute, "reject files that take longer than `duration` to check")
+	fset.Func("upload-ext", "only accept writes of files with the comma separated `extensions`", func(s string) error {
+		cfg.UploadExt = strings.Split(s, ",")
+		return nil
+	})
+	fset.StringVar(&cfg.Upload, "upload", "", "accept browser uploads at /upload into `dir`")
+	fset.StringVar(&cfg.UploadOverwrite, "upload-overwrite", "rename", "when an uploaded file exists, `replace` it, rename the upload or deny it")
+	setChoices(fset, "upload-overwrite", staticserver.UploadOverwrites)
+	fset.StringVar(&cfg.WebDAV, "webdav", "", "serve the tree over WebDAV at the URL `path`")
+	fset.StringVar(&cfg.WebDAVRoot, "webdav-root", "", "serve `dir` over WebDAV instead of -dir")
+	fset.BoolVar(&cfg.WebDAVReadOnly, "webdav-readonly", false, "refuse WebDAV writes")
+	fset.StringVar(&cfg.Tus, "tus", "", "accept resumable tus uploads into the -upload dir at the URL `path`, from -auth accounts")
+	accessLogFile := ""
+	fset.StringVar(&accessLogFile, "access-log", "", "log every request to `file`, to standard output if it is - or to -syslog if it is syslog")
+	fset.StringVar(&cfg.AuditLog, "audit-log", "", "append a hash-chained line for every request made with a user name to `file`, hashed with the key in AUDIT_KEY if it is set")
+	fset.Func("log-status", "only log requests answered with the comma separated status `codes`, which may be classes like 4xx", func(s string) error {
+		cfg.LogStatus = append(cfg.LogStatus, strings.Split(s, ",")...)
+		return nil
+	})
+	fset.Func("log-exclude", "do not log requests for paths matching the `pattern`, like /favicon.ico or /assets/* (repeatable)", func(s string) error {
+		cfg.LogExclude = append(cfg.LogExclude, s)
+		return nil
+	})
+	fset.StringVar(&cfg.LogFormat, "log-format", "combined", "write the access log in the `format` common, combined or json")
+	setChoices(fset, "log-format", staticserver.LogFormats)
+	logFileName := ""
+	rotation := logRotation{keep: 7}
+	fset.StringVar(&logFileName, "log-file", "", "write the server's own log to `file` instead of standard error")
+	fset.StringVar(&pidFile, "pidfile", "", "write the process ID to `file` once serving, and remove it on exit")
+	keepAlives := true
+	fset.BoolVar(&keepAlives, "keep-alives", true, "serve further requests on a connection after the first; -keep-alives=false closes each after its response")
+	idleTimeout, readHeaderTimeout, maxHeaderBytes := time.Duration(0), time.Duration(0), int64(0)
+	fset.DurationVar(&idleTimeout, "idle-timeout", 0, "close connections that wait for their next request longer than `duration` (default no limit, or 30s with -low-memory)")
+	fset.DurationVar(&readHeaderTimeout, "read-header-timeout", 0, "close connections that take longer than `duration` to send the headers of a request (default no limit, or 10s with -low-memory)")
+	fset.Func("max-header-bytes", "refuse requests whose headers are larger than `size` (default 1M, or 16K with -low-memory)", sizeFlag(&maxHeaderBytes))
+	fset.BoolVar(&noDelay, "tcp-nodelay", true, "send small writes at once, with Nagle's algorithm off; -tcp-nodelay=false coalesces them")
+	fset.DurationVar(&tcpKeepAlive, "tcp-keepalive", 0, "send TCP keep-alive probes on idle connections every `duration`, or turn them off with -1s (default 15s)")
+	fset.IntVar(&backlog, "backlog", 0, "queue up to `n` connections waiting to be accepted (default the system's limit, such as net.core.somaxconn)")
+	fset.IntVar(&cfg.PerIPRequests, "per-ip-requests", 0, "serve at most `n` requests at once to a client IP, or IPv6 /64, answering those past it with 429")
+	fset.DurationVar(&cfg.PerIPWait, "per-ip-wait", 0, "let requests past -per-ip-requests wait up to `duration` for their turn before answering them with 429")
+	fset.DurationVar(&cfg.PerIPRetryAfter, "per-ip-retry-after", 0, "tell clients refused by -per-ip-requests to retry after `duration` (default -per-ip-wait, or 1s)")
+	fset.Func("per-ip-page", "send the HTML, JSON or other `file` as the body of 429 responses to the clients that accept its type (repeatable)", func(s string) error {
+		cfg.PerIPPages = append(cfg.PerIPPages, s)
+		return nil
+	})
+	fset.BoolVar(&cfg.PerIPChallenge, "per-ip-challenge", false, "answer browsers past -per-ip-requests with a proof-of-work page, and let those who pass it past the limit for an hour")
+	fset.IntVar(&cfg.ChallengeBits, "challenge-bits", 16, "make the proof of work of -per-ip-challenge find a hash beginning with `n` zero bits")
+	fset.StringVar(&cfg.ChallengePage, "challenge-page", "", "execute the html/template `file` as the -per-ip-challenge page, such as one with a CAPTCHA widget")
+	fset.StringVar(&cfg.ChallengeVerify, "challenge-verify", "", "check the CAPTCHA responses of -challenge-page at the siteverify `url`, with the secret key in CHALLENGE_SECRET")
+	fset.DurationVar(&cfg.MaxRequestDuration, "max-request-duration", 0, "abort requests still being served after `duration`, slow downloads included")
+	fset.IntVar(&maxConns, "max-conns", 0, "serve at most `n` connections at once, leaving the others waiting (default no limit, or 32 with -low-memory)")
+	lowMemory, gogc, memLimit := false, 0, int64(0)
+	fset.BoolVar(&lowMemory, "low-memory", false, "serve in as little memory as it can, for routers and single-board computers: no caches, -max-conns 32, small header limits and -gogc 50")
+	fset.IntVar(&gogc, "gogc", 0, "collect garbage when the heap has grown by `percent` since the last collection, as GOGC does (default 100, or 50 with -low-memory)")
+	fset.Func("memory-limit", "a soft limit on the memory the server uses, such as 24M, as GOMEMLIMIT sets", sizeFlag(&memLimit))
+	fset.BoolVar(&reusePort, "reuse-port", false, "listen with SO_REUSEPORT, so that another server, such as a new version, can listen on the same ports before this one is stopped")
+	fset.BoolVar(&detach, "detach", false, "once listening, go on serving in the background, with no terminal, and exit; see -log-file and -pidfile")
+	fset.Func("log-max-size", "rotate log files when they grow past `size`", sizeFlag(&rotation.maxSize))
+	fset.DurationVar(&rotation.maxAge, "log-max-age", 0, "rotate log files once they are older than `duration`")
+	fset.IntVar(&rotation.keep, "log-keep", rotation.keep, "keep the newest `n` rotated log files, or all of them if 0")
+	syslogCfg := syslogConfig{facility: syslogFacilities["daemon"], tag: "static-server"}
+	fset.StringVar(&syslogCfg.addr, "syslog", "", "send the server's log to syslog at `addr`: local, udp://host:port or tcp://host:port")
+	fset.Func("syslog-facility", "the syslog `facility` to log as (default daemon)", syslogCfg.setFacility)
+	setChoices(fset, "syslog-facility", syslogFacilityNames())
+	fset.StringVar(&syslogCfg.tag, "syslog-tag", syslogCfg.tag, "the `name` to log to syslog as")
+	adminAddr := ""
+	fset.BoolVar(&cfg.Metrics, "metrics", false, "serve Prometheus metrics at /metrics")
+	fset.BoolVar(&cfg.RequestID, "request-id", false, "tag every request with an X-Request-Id, keeping the client's if it sent one")
+	fset.BoolVar(&cfg.Pprof, "pprof", false, "serve runtime profiles at /debug/pprof/, on -admin-addr if it is set")
+	fset.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", "", "export a trace span per request to the OTLP/HTTP traces `url`, such as http://localhost:4318/v1/traces")
+	fset.StringVar(&cfg.TraceService, "trace-service", "static-server", "export spans with their service.name set to `name`")
+	fset.BoolVar(&cfg.Stats, "stats", false, "serve a live statistics page at /_stats to the -auth accounts")
+	fset.StringVar(&cfg.Hits, "hits", "", "count complete downloads of every path in the JSON `file`, and serve the counts at /_hits")
+	fset.StringVar(&cfg.Alert.Webhook, "alert-webhook", "", "POST Slack-compatible alerts to `url` when an -alert threshold is reached")
+	fset.DurationVar(&cfg.Alert.Window, "alert-window", time.Minute, "the `duration` -alert thresholds are counted over")
+	fset.IntVar(&cfg.Alert.ServerError, "alert-5xx", 0, "alert when `n` server errors are served within the -alert-window")
+	fset.IntVar(&cfg.Alert.NotFound, "alert-404", 0, "alert when `n` requests are not found within the -alert-window")
+	quiet, verbose, debug := false, false, false
+	fset.BoolVar(&quiet, "quiet", false, "log errors only")
+	fset.BoolVar(&verbose, "verbose", false, "also log every request, when there is no -access-log")
+	fset.BoolVar(&debug, "debug", false, "log like -verbose and explain how every path was resolved")
+	fset.Func("delay", "hold back every response by `duration`, or those under a path with /prefix=duration (repeatable)", cfg.Delay.Set)
+	fset.DurationVar(&cfg.DelayJitter, "delay-jitter", 0, "add a random delay of up to `duration` to every response")
+	fset.Func("chaos", "fail a `share` of requests, such as 10% or 0.1, as -chaos-modes says", func(s string) (err error) {
+		cfg.Chaos, err = staticserver.ParseShare(s)
+		return err
+	})
+	fset.Func("chaos-modes", "fail requests with one of the comma separated `modes`: error statuses or truncate (default 500,503,truncate)", func(s string) error {
+		cfg.ChaosModes = strings.Split(s, ",")
+		return nil
+	})
+	fset.StringVar(&cfg.Shadow, "shadow", "", "send copies of requests to the backend at `url` in the background, ignoring its responses, to try it against real traffic")
+	fset.Func("shadow-rate", "copy a `share` of requests, such as 10% or 0.1, to the -shadow backend (default all)", func(s string) (err error) {
+		cfg.ShadowRate, err = staticserver.ParseShare(s)
+		return err
+	})
+	fset.Func("simulate", "serve as if over a slow network given by `profile`: 2g, slow-3g, 3g, 4g, dsl or latency/rate like 300ms/64K", cfg.Simulate.Set)
+	setChoices(fset, "simulate", staticserver.NetProfileNames())
+	fset.BoolVar(&cfg.Prod, "prod", false, "refuse to serve source maps, .ts sources, package manifests and other build leftovers, answering 404")
+	fset.Func("prod-block", "refuse to serve the files and dirs whose names match `pattern`, like *.psd, with -prod too (repeatable)", func(s string) error {
+		cfg.ProdBlock = append(cfg.ProdBlock, s)
+		return nil
+	})
+	fset.BoolVar(&cfg.Dev, "dev", false, "allow requests from any origin and turn off caching, for frontend development")
+	fset.BoolVar(&cfg.COI, "coi", false, "send the Cross-Origin-Opener-Policy, -Embedder-Policy and -Resource-Policy headers that make pages cross-origin isolated, for WebAssembly threads and SharedArrayBuffer")
+	fset.BoolVar(&cfg.PWA, "pwa", false, "serve web app manifests and service workers with their types and Cache-Control: no-cache, so installed apps update reliably")
+	fset.BoolVar(&cfg.PWAWorker, "pwa-worker", false, "with -pwa, serve a generated service worker at /sw.js that keeps the files for offline use, unless the site has one, and register it from pages")
+	fset.BoolVar(&cfg.Media, "media", false, "serve HLS and DASH streams, such as ffmpeg writes: the types of .m3u8, .mpd, .ts and .m4s files, CORS for players, segments uncompressed and playlists cached for a second")
+	fset.BoolVar(&cfg.Echo, "echo", false, "respond to requests for /_echo with a JSON description of the request")
+	openURL := false
+	fset.BoolVar(&openURL, "open", false, "open the server in the default browser once it is listening")
+	showQR := false
+	fset.BoolVar(&showQR, "qr", false, "print a QR code of the server's network URL, for opening it on a phone")
+	mdns, mdnsName := false, ""
+	fset.BoolVar(&mdns, "mdns", false, "advertise the server on the LAN over mDNS as an _http._tcp service, so that other devices find it by name")
+	fset.StringVar(&mdnsName, "mdns-name", "", "advertise the server as `name` with -mdns (default the host name)")
+	tunnelSpec := ""
+	fset.StringVar(&tunnelSpec, "tunnel", "", "expose the server publicly through `provider`, ngrok, cloudflared or localhost.run, or an SSH remote forward to ssh://[user@]host[:port], and print the public URL")
+	registryURL, reg := "", registration{}
+	fset.StringVar(&registryURL, "register", "", "register the server with the Consul agent or etcd at `url`, consul://host:8500 or etcd://host:2379, until it shuts down")
+	fset.StringVar(&reg.name, "register-name", "static-server", "register the server as the service `name`")
+	fset.StringVar(&reg.id, "register-id", "", "register the server as the instance `id` (default the name, host name and port)")
+	fset.StringVar(&reg.addr, "register-addr", "", "register the server as reached at `host` (default its first network address)")
+	fset.Func("register-tag", "register the server with the `tag` (repeatable)", func(s string) error {
+		reg.tags = append(reg.tags, s)
+		return nil
+	})
+	portMap := false
+	fset.BoolVar(&portMap, "port-map", false, "ask the router over UPnP or NAT-PMP to forward the port from outside the network, and print the external URL")
+	fset.BoolVar(&cfg.Watch, "watch", false, "watch the served dirs and recount -quota usage as soon as files change on disk")
+	fset.BoolVar(&cfg.Events, "events", false, "stream changes to the files served as server-sent events at /_events")
+	fset.BoolVar(&cfg.LiveReload, "live-reload", false, "reload pages in the browser when the files served change")
+	fset.StringVar(&cfg.InjectBodyEnd, "inject-body-end", "", "insert the snippet in `file`, such as an analytics script or a cookie banner, before the </body> of HTML pages")
+	showVersion, versionEndpoint := false, false
+	fset.BoolVar(&showVersion, "version", false, "print the version and exit")
+	fset.BoolVar(&versionEndpoint, "version-endpoint", false, "serve the version as JSON at /_version")
+	fset.BoolVar(&cfg.Summary, "summary", false, "print a summary of the traffic served on shutdown")
+	grace := 10 * time.Second
+	fset.DurationVar(&grace, "shutdown-timeout", grace, "on SIGINT or SIGTERM, wait up to `duration` for requests in flight")
+	exitIdle := time.Duration(0)
+	fset.DurationVar(&exitIdle, "exit-after-idle", 0, "shut down once no request has arrived for `duration`, such as 10m")
+	fset.DurationVar(&cfg.SlowLog, "slow-log", 0, "log a warning for every request that takes longer than `duration`")
+	fset.BoolVar(&cfg.AnonymizeIP, "log-anonymize-ip", false, "log client IPs with the last octet, or all but the /64 of IPv6, zeroed")
+	fset.StringVar(&cfg.GeoIP, "geoip", "", "locate clients in the MaxMind DB `file` for JSON access logs, metrics and -geo-allow")
+	fset.Func("geo-allow", "only serve clients the -geoip DB locates in the comma separated `countries`, such as US,CA", func(s string) error {
+		cfg.GeoAllow = append(cfg.GeoAllow, strings.Split(s, ",")...)
+		return nil
+	})
+	fset.Func("geo-deny", "refuse clients the -geoip DB locates in the comma separated `countries`", func(s string) error {
+		cfg.GeoDeny = append(cfg.GeoDeny, strings.Split(s, ",")...)
+		return nil
+	})
+	fset.IntVar(&cfg.GeoStatus, "geo-status", http.StatusForbidden, "answer clients refused by -geo-allow or -geo-deny with the status `code`, such as 451")
+	fset.StringVar(&cfg.GeoPage, "geo-page", "", "send `file` as the body of -geo-allow and -geo-deny refusals")
+	fset.Func("geo-mirror", "redirect the downloads of clients the -geoip DB locates in `codes=url`, countries such as DE,AT or continents such as @EU, to the mirror at url (repeatable)", cfg.GeoMirrors.Set)
+	fset.Func("geo-mirror-path", "only redirect the paths matching `pattern`, such as /releases/, to -geo-mirror mirrors (repeatable)", func(s string) error {
+		cfg.GeoMirrorPaths = append(cfg.GeoMirrorPaths, s)
+		return nil
+	})
+	fset.BoolVar(&cfg.Health, "health", false, "answer liveness probes at /healthz and readiness probes at /readyz")
+	fset.StringVar(&adminAddr, "admin-addr", "", "serve /metrics and other admin endpoints on `addr` instead of the main listener")
+	fset.BoolVar(&cfg.AdminAPI, "admin-api", false, "serve the admin API, for bans, cache purges, stats, reload and shutdown, on -admin-addr")
+	adminClientCA := ""
+	fset.StringVar(&adminClientCA, "admin-client-ca", "", "serve -admin-addr over HTTPS with -tls-cert to clients with a certificate signed by the PEM CAs in `file` only")
+	fset.Func("ban", "refuse the requests of the IP address or network `addr`, such as 203.0.113.0/24 (repeatable)", func(s string) error {
+		cfg.Bans = append(cfg.Bans, s)
+		return nil
+	})
+	shareKeyFile := ""
+	fset.StringVar(&shareKeyFile, "share-key", "", "serve the links the share command signs with the key in `file`, past passwords, and mint them at -admin-api")
+	fset.BoolVar(&cfg.SharePreviews, "share-previews", false, "answer the bots that preview -share-key links in chats with an Open Graph page, with a thumbnail of an image or an icon")
+	fset.StringVar(&cfg.BanFile, "ban-file", "", "keep the bans in `file`, one a line, so that those made at the admin API outlast the process")
+	fset.Func("tarpit-path", "hold the clients that ask for a path matching `pattern`, like /wp-login.php or /.env, and all their requests for an hour, with a response that trickles out for half an hour (repeatable)", func(s string) error {
+		cfg.TarpitPaths = append(cfg.TarpitPaths, s)
+		return nil
+	})
+	fset.Func("tarpit-agent", "hold the clients whose User-Agent contains `word`, like sqlmap or nikto, as -tarpit-path does (repeatable)", func(s string) error {
+		cfg.TarpitAgents = append(cfg.TarpitAgents, s)
+		return nil
+	})
+	fset.IntVar(&cfg.TarpitConns, "tarpit-conns", 64, "hold at most `n` clients at once, refusing those past it with 403")
+	check := fset.Bool("check", false, "check the configuration, that the dirs and files it names exist and its upstreams can be reached, and exit non-zero if it has problems")
+	configFile := fset.String("config", "", "read options from the TOML, YAML or JSON `file`, whose keys are flag names; flags and environment variables given override it")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s [serve] [flags] [dir]\n\n", os.Args[0])
+		printCommands(fset.Output())
+		fmt.Fprintln(fset.Output(), "\nThe flags of serve:")
+		fset.PrintDefaults()
+		fmt.Fprintln(fset.Output(), "\nEvery flag can be set by an environment variable instead, such as STATIC_SERVER_CGI_TIMEOUT")
+		fmt.Fprintln(fset.Output(), "for -cgi-timeout, and a repeatable one by numbered ones such as STATIC_SERVER_PROXY_1.")
+		fmt.Fprintln(fset.Output(), "Flags override environment variables, which override -config.")
 	}
-	return false
-}
+	printCfg := fset.Bool("print-config", false, "print the configuration merged from the defaults, -config, the environment and the flags as a config file, and exit")
+	tlsCert, tlsKey := "", ""
+	fset.StringVar(&tlsCert, "tls-cert", "", "serve HTTPS with the PEM certificate chain in `file`, such as one gen-cert wrote, requires -tls-key")
+	fset.StringVar(&tlsKey, "tls-key", "", "the PEM private key `file` of -tls-cert")
+	recordFlags(fset)
+	return func(args []string) {
+		fset.Parse(args)
+		switch fset.NArg() {
+		case 0:
+		case 1:
+			if err := fset.Set("dir", fset.Arg(0)); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			fset.Usage()
+			os.Exit(2)
+		}
+		given := map[string]bool{}
+		fset.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		if err := applyEnv(fset, os.Environ(), given); err != nil {
+			log.Fatal(err)
+		}
+		if *configFile != "" {
+			if err := applyConfigFile(fset, *configFile, given); err != nil {
+				log.Fatal(err)
+			}
+		}
 
-// noDotF is the http.File use in noDotFS.
-// It is used to wrap the Readdir method of http.File so that we can
-// remove files and directories that start with a period from its output.
-type noDotF struct {
-	http.File
-}
+		if *printCfg {
+			printConfig(os.Stdout, fset)
+			return
+		}
+		if showVersion {
+			fmt.Println(currentBuild())
+			return
+		}
+		switch {
+		case debug:
+			verbosity = staticserver.LevelDebug
+		case verbose:
+			verbosity = staticserver.LevelVerbose
+		case quiet:
+			verbosity = staticserver.LevelQuiet
+		}
+		staticserver.SetLevel(verbosity)
 
-// Readdir is a wrapper around the Readdir method of the embedded File
-// that filters out all files that start with a period in their name.
-func (f noDotF) Readdir(n int) (fis []os.FileInfo, err error) {
-	files, err := f.File.Readdir(n)
-	for _, file := range files { // Filters out the dot files
-		if !strings.HasPrefix(file.Name(), ".") {
-			fis = append(fis, file)
+		if syslogCfg.addr != "" {
+			w, err := newSyslogWriter(syslogCfg, syslogNotice)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.SetFlags(0)
+			log.SetOutput(w)
+		} else if logFileName != "" {
+			f, err := openLogFile(logFileName, rotation)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.SetOutput(f)
 		}
-	}
-	return
-}
+		reopenOnSignal()
 
-// noDotFS is an http.FileSystem that hides
-// hidden "dot files" from being served.
-type noDotFS struct {
-	http.FileSystem
-}
+		switch accessLogFile {
+		case "":
+		case "-":
+			cfg.AccessLog = os.Stdout
+		case "syslog":
+			if syslogCfg.addr == "" {
+				log.Fatal("-access-log syslog requires -syslog")
+			}
+			w, err := newSyslogWriter(syslogCfg, syslogInfo)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.AccessLog = w
+		default:
+			f, err := openLogFile(accessLogFile, rotation)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.AccessLog = f
+		}
+		if cfg.ChallengeVerify != "" {
+			cfg.ChallengeSecret = os.Getenv("CHALLENGE_SECRET")
+		}
+		if cfg.AuditLog != "" {
+			if key := os.Getenv("AUDIT_KEY"); key != "" {
+				cfg.AuditKey = []byte(key)
+			}
+		}
+		if bucket.Bucket != "" {
+			bucket.Region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+			bucket.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+			bucket.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			bucket.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+			bucket.Cache = objectCache
+			var err error
+			if cfg.FS, err = staticserver.NewS3FS(bucket); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if gcsBucket.Bucket != "" {
+			if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+				gcsBucket.Endpoint = "http://" + host
+			}
+			gcsBucket.Cache = objectCache
+			var err error
+			if cfg.FS, err = staticserver.NewGCSFS(gcsBucket); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if container.Account != "" {
+			container.Key = os.Getenv("AZURE_STORAGE_KEY")
+			container.SAS = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+			container.Cache = objectCache
+			var err error
+			if cfg.FS, err = staticserver.NewAzureFS(container); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if remote.Host != "" {
+			remote.Cache = objectCache
+			var err error
+			if cfg.FS, err = staticserver.NewSFTPFS(remote); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if sqliteDB != "" {
+			var err error
+			if cfg.FS, err = staticserver.OpenSQLite(sqliteDB, *sqliteTable); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if gitRepo != "" {
+			g, err := staticserver.OpenGit(gitRepo, gitRef)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if gitPull > 0 {
+				g.PullEvery(gitPull)
+			}
+			source = fmt.Sprintf("%s at %.12s", source, g.Commit())
+			cfg.FS = g
+		}
+		if len(overlays) > 0 {
+			var layers staticserver.OverlayFS
+			var names []string
+			for _, dir := range overlays {
+				layers = append(layers, os.DirFS(dir))
+				names = append(names, fmt.Sprintf("%q", dir))
+			}
+			if cfg.FS != nil {
+				layers = append(layers, cfg.FS)
+				names = append(names, source)
+			} else if dirSet {
+				layers = append(layers, os.DirFS(cfg.Dir))
+				names = append(names, fmt.Sprintf("%q", cfg.Dir))
+			}
+			source = strings.Join(names, " over ")
+			cfg.FS = layers
+		}
+		if _, ok := inheritedFiles()["ready"]; ok {
+			detach = false // started by a process that detached or reloaded
+		}
+		if lowMemory {
+			cfg.LowMemory = true
+			if maxConns == 0 {
+				maxConns = 32
+			}
+			if gogc == 0 && os.Getenv("GOGC") == "" {
+				gogc = 50
+			}
+		}
+		tuneGC(gogc, memLimit)
+		cfg.SeparateAdmin = adminAddr != ""
+		if cfg.Pprof && adminAddr == "" {
+			infof("warning: -pprof without -admin-addr exposes profiles on the public listener")
+		}
+		if cfg.AdminAPI {
+			if adminAddr == "" {
+				log.Fatal("-admin-api requires -admin-addr")
+			}
+			if !isLoopback(adminAddr) && adminClientCA == "" {
+				log.Fatalf("-admin-api on %s, which is not a loopback address, requires -admin-client-ca", adminAddr)
+			}
+			cfg.AdminEndpoints = adminControl()
+		}
+		if shareKeyFile != "" {
+			key, err := readShareKey(shareKeyFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.ShareKey = key
+		}
+		if registryURL != "" {
+			if _, _, err := parseRegistry(registryURL); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if tunnelSpec != "" {
+			if _, _, err := tunnelCommand(tunnelSpec, 0); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if adminClientCA != "" && tlsCert == "" {
+			log.Fatal("-admin-client-ca requires -tls-cert and -tls-key")
+		}
+		if versionEndpoint {
+			if cfg.AdminEndpoints == nil {
+				cfg.AdminEndpoints = map[string]http.Handler{}
+			}
+			cfg.AdminEndpoints["/_version"] = http.HandlerFunc(versionHandler)
+		}
 
-// Open is a wrapper around the Open method of the embedded FileSystem
-// that serves a 403 permission error when name has a file or directory
-// with whose name starts with a period in its path.
-func (fs noDotFS) Open(name string) (http.File, error) {
-	if isDotF(name) { // If dot file, return 403 response
-		return nil, os.ErrPermission
-	}
+		if *check {
+			errs := staticserver.Check(cfg)
+			if tlsCert != "" || tlsKey != "" {
+				cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("tls: %v", err))
+				} else if adminClientCA != "" {
+					if _, err := adminTLSConfig(cert, adminClientCA); err != nil {
+						errs = append(errs, fmt.Errorf("admin-client-ca: %v", err))
+					}
+				}
+			}
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+			fmt.Println("the configuration is valid")
+			return
+		}
+		server, err := staticserver.New(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		toggleMaintenanceOnSignal(server)
+		// A reload keeps the maintenance mode it was in.
+		reloadEnv = func() []string {
+			return []string{"STATIC_SERVER_MAINTENANCE=" + strconv.FormatBool(server.Maintenance())}
+		}
 
-	file, err := fs.FileSystem.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	return noDotF{file}, err
-}
+		// create the server
+		srv := &http.Server{
+			Addr:    addr,
+			Handler: server,
+		}
+		if lowMemory {
+			srv.MaxHeaderBytes = 16 << 10
+			srv.ReadHeaderTimeout = 10 * time.Second
+			srv.IdleTimeout = 30 * time.Second
+		}
+		if idleTimeout > 0 {
+			srv.IdleTimeout = idleTimeout
+		}
+		if readHeaderTimeout > 0 {
+			srv.ReadHeaderTimeout = readHeaderTimeout
+		}
+		if maxHeaderBytes > 0 {
+			srv.MaxHeaderBytes = int(maxHeaderBytes)
+		}
+		srv.SetKeepAlivesEnabled(keepAlives)
+		if tlsCert != "" || tlsKey != "" {
+			cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			scheme = "https"
+		}
+		if exitIdle > 0 {
+			srv.Handler = exitAfterIdle(srv.Handler, exitIdle)
+		}
+		srv.RegisterOnShutdown(func() { server.Close() })
 
-func main() {
-	dir := "."
-	flag.Func("dir", "the dir to serve", func(s string) error {
-		dir = s
-		return nil
-	})
-	flag.Parse()
+		if cfg.FS != nil {
+			announcef("serving %s on %s", source, srv.Addr)
+		} else {
+			announcef("serving \"%s\" on %s", cfg.Dir, srv.Addr)
+		}
+		for host, d := range cfg.VHosts {
+			announcef("serving \"%s\" for %s", d, host)
+		}
+		if cfg.Canary.Dir != "" {
+			announcef("serving \"%s\" to %g%% of clients", cfg.Canary.Dir, cfg.Canary.Share*100)
+		}
+		if cfg.Form != "" {
+			announcef("accepting forms at %s", cfg.Form)
+		}
+		if cfg.Upload != "" {
+			announcef("accepting uploads at /upload into \"%s\"", cfg.Upload)
+			if len(cfg.Auth) == 0 {
+				infof("warning: uploads are open to anyone, use -auth to require an account")
+			}
+		}
+		if cfg.Tus != "" {
+			announcef("accepting tus uploads at %s/", strings.TrimSuffix(cfg.Tus, "/"))
+		}
+		if cfg.WebDAV != "" {
+			davRoot := cfg.WebDAVRoot
+			if davRoot == "" {
+				davRoot = cfg.Dir
+			}
+			announcef("serving \"%s\" over WebDAV at %s/", davRoot, strings.TrimSuffix(cfg.WebDAV, "/"))
+		}
+		for prefix, target := range cfg.Proxies {
+			announcef("proxying %s/ to %s", prefix, target)
+		}
+		if cfg.PHP != "" {
+			announcef("running .php files with %s", cfg.PHP)
+		}
+		for prefix, dir := range cfg.CGI {
+			announcef("running the CGI scripts in \"%s\" at %s/", dir, prefix)
+		}
+		if adminAddr != "" {
+			announcef("serving admin endpoints on %s", adminAddr)
+			ln, err := listen(adminAddr, "admin")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if adminClientCA != "" {
+				conf, err := adminTLSConfig(srv.TLSConfig.Certificates[0], adminClientCA)
+				if err != nil {
+					log.Fatal(err)
+				}
+				ln = tls.NewListener(ln, conf)
+			}
+			go func() {
+				log.Fatal(http.Serve(ln, server.Admin()))
+			}()
+		}
+		var responder *mdnsResponder
+		var mapping *portMapping
+		var tun *tunnel
+		var registered *registry
+		ready := func(addr net.Addr) {
+			if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsUnspecified() {
+				for _, u := range lanURLs(addr) {
+					announcef("  %s", u)
+				}
+			}
+			if showQR {
+				if urls := lanURLs(addr); len(urls) == 0 {
+					infof("warning: -qr: no network address to show")
+				} else if err := staticserver.PrintQR(os.Stdout, urls[0]); err != nil {
+					log.Println("qr:", err)
+				} else {
+					fmt.Println(urls[0])
+				}
+			}
+			if openURL {
+				if err := openBrowser(localURL(addr)); err != nil {
+					log.Println("open:", err)
+				}
+			}
+			if mdns && !detach {
+				if r, err := advertiseMDNS(addr, mdnsName, "_"+scheme+"._tcp", []string{"path=/"}); err != nil {
+					log.Println(err)
+				} else {
+					announcef("advertising %q over mDNS", r.instance[0])
+					responder = r
+				}
+			}
+			if tunnelSpec != "" && !detach {
+				if t, err := startTunnel(tunnelSpec, addr); err != nil {
+					log.Println(err)
+				} else {
+					tun = t
+				}
+			}
+			if registryURL != "" && !detach {
+				if cfg.Health && !cfg.SeparateAdmin {
+					reg.health = "/healthz"
+				}
+				if r, err := register(registryURL, addr, reg); err != nil {
+					log.Println(err)
+				} else {
+					announcef("registered as %s with %s", r.reg.id, r.kind)
+					registered = r
+				}
+			}
+			if portMap && !detach {
+				if m, err := mapPortOut(addr); err != nil {
+					log.Println(err)
+				} else {
+					announcef("reachable from outside the network at %s, mapped over %v", m.URL(), m.mapper)
+					mapping = m
+				}
+			}
+		}
+		if err := serveUntilSignal(srv, grace, ready); err != nil {
+			log.Fatal(err)
+		}
+		if responder != nil && !reloaded {
+			responder.Close()
+		}
+		if mapping != nil && !reloaded {
+			mapping.Close()
+		}
+		if tun != nil {
+			tun.Close()
+		}
+		if registered != nil && !reloaded {
+			registered.Close()
+		}
+		if err := server.Close(); err != nil {
+			log.Println(err)
+		}
+		if c, ok := cfg.FS.(io.Closer); ok {
+			c.Close()
+		}
+		server.WriteSummary(os.Stdout)
 
-	fs := noDotFS{http.Dir(dir)}
-	staticMux := http.NewServeMux()
-	staticMux.Handle("/", http.FileServer(fs))
-	staticMux.Handle("/post", http.HandlerFunc(redir))
+		// Simple static webserver:
+		// dir, _ := os.Getwd()
+		// log.Fatal(http.ListenAndServe(":8080", http.FileServer(http.Dir(dir))))
 
-	// create the server
-	srv := &http.Server{
-		Addr: `:8080`,
+		// To serve a directory on disk (/tmp) under an alternate URL
+		// path (/tmpfiles/), use StripPrefix to modify the request
+		// URL's path before the FileServer sees it:
+		// http.Handle("/tmpfiles/", http.StripPrefix("/tmpfiles/", http.FileServer(http.Dir("/tmp"))))
 	}
-
-	srv.Handler = staticMux
-	fmt.Printf("serving \"%s\" on %s\n", dir, srv.Addr)
-	log.Fatal(srv.ListenAndServe())
-
-	// Simple static webserver:
-	// dir, _ := os.Getwd()
-	// log.Fatal(http.ListenAndServe(":8080", http.FileServer(http.Dir(dir))))
-
-	// To serve a directory on disk (/tmp) under an alternate URL
-	// path (/tmpfiles/), use StripPrefix to modify the request
-	// URL's path before the FileServer sees it:
-	// http.Handle("/tmpfiles/", http.StripPrefix("/tmpfiles/", http.FileServer(http.Dir("/tmp"))))
 }
 
-func redir(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	log.Println(r.Form)
-	http.Redirect(w, r, "https://httpbin.org/get", http.StatusSeeOther)
+// splitGitRef splits repo@ref at the last @ that is not part of the
+// user in a URL, leaving the ref empty if there is none.
+func splitGitRef(s string) (repo, ref string) {
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = len(s)
+		if j := strings.Index(s[i+3:], "/"); j >= 0 {
+			start = i + 3 + j
+		}
+	}
+	i := strings.LastIndex(s, "@")
+	if i < start || i == 0 || strings.Contains(s[i+1:], ":") {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// serviceSpec is a server installed as a service of the system, to run
+// at boot.
+type serviceSpec struct {
+	name string   // the name of the service
+	exe  string   // the absolute path of the executable
+	dir  string   // the working dir it runs in
+	args []string // the arguments it runs with, the serve flags
+}
+
+// serviceActions are the actions of the service command.
+var serviceActions = []string{"install", "uninstall", "start", "stop"}
+
+// servicePastTense is what is printed once each action is done.
+var servicePastTense = map[string]string{"install": "installed", "uninstall": "uninstalled", "start": "started", "stop": "stopped"}
+
+// serviceCommand defines the flags of the service command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func serviceCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s service [flags] install [serve flags] [dir]\n", os.Args[0])
+		fmt.Fprintf(fset.Output(), "       %s service [flags] uninstall|start|stop\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Install the server as a service that runs at boot, serving with the flags")
+		fmt.Fprintln(fset.Output(), "after install from the current dir: a systemd unit on Linux, a launchd")
+		fmt.Fprintln(fset.Output(), "daemon on macOS or a Windows service. Run as root, or as an administrator,")
+		fmt.Fprintln(fset.Output(), "for a system service; otherwise systemd and launchd get one of the user's.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	name := fset.String("name", "static-server", "the `name` of the service")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() == 0 {
+			fset.Usage()
+			os.Exit(2)
+		}
+		action, rest := fset.Arg(0), fset.Args()[1:]
+		if !validServiceName(*name) {
+			log.Fatalf("invalid service name %q, expected letters, digits, dots, dashes and underscores", *name)
+		}
+		var err error
+		switch action {
+		case "install":
+			var s serviceSpec
+			if s, err = newServiceSpec(*name, rest); err == nil {
+				err = installService(s)
+			}
+		case "uninstall", "start", "stop":
+			if len(rest) > 0 {
+				fset.Usage()
+				os.Exit(2)
+			}
+			switch action {
+			case "uninstall":
+				err = uninstallService(*name)
+			case "start":
+				err = startService(*name)
+			case "stop":
+				err = stopService(*name)
+			}
+		case "run":
+			// How the service manager of Windows starts the server: run
+			// dir serve flags.
+			if len(rest) == 0 {
+				fset.Usage()
+				os.Exit(2)
+			}
+			if err := os.Chdir(rest[0]); err != nil {
+				log.Fatal(err)
+			}
+			err = runService(*name, func() { commands[0].run(rest[1:]) })
+		default:
+			log.Fatalf("unknown action %q, expected one of %s", action, strings.Join(serviceActions, ", "))
+		}
+		if err != nil {
+			log.Fatalf("service %s: %v", action, err)
+		}
+		if done, ok := servicePastTense[action]; ok {
+			fmt.Printf("%s: %s\n", *name, done)
+		}
+	}
+}
+
+// newServiceSpec returns the service name that serves with the flags
+// args from the current dir, once they are checked.
+func newServiceSpec(name string, args []string) (serviceSpec, error) {
+	check := flag.NewFlagSet("serve", flag.ContinueOnError)
+	serveCommand(check)
+	check.SetOutput(io.Discard)
+	if err := check.Parse(args); err != nil {
+		return serviceSpec{}, err
+	}
+	if check.NArg() > 1 {
+		return serviceSpec{}, fmt.Errorf("too many arguments: %s", strings.Join(check.Args(), " "))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return serviceSpec{}, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return serviceSpec{}, err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return serviceSpec{}, err
+	}
+	return serviceSpec{name: name, exe: exe, dir: dir, args: slices.Clone(args)}, nil
+}
+
+// validServiceName reports whether name may name a unit, daemon and
+// Windows service alike.
+func validServiceName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
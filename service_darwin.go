@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// userService reports whether services are the user's agents, run once
+// the user logs in, rather than daemons of the system.
+func userService() bool {
+	return os.Geteuid() != 0
+}
+
+// plistFile returns the path of the property list of the service name.
+func plistFile(name string) (string, error) {
+	if !userService() {
+		return filepath.Join("/Library/LaunchDaemons", name+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", name+".plist"), nil
+}
+
+// serviceLog returns the file the service name logs to.
+func serviceLog(name string) string {
+	if !userService() {
+		return filepath.Join("/var/log", name+".log")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "Logs", name+".log")
+}
+
+// launchctl runs launchctl with args.
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// plistString writes s as a string of a property list to b.
+func plistString(b *bytes.Buffer, s string) {
+	b.WriteString("<string>")
+	xml.EscapeText(b, []byte(s))
+	b.WriteString("</string>")
+}
+
+// installService writes the property list of s and loads it, which
+// starts it at boot, or at login for a user's agent.
+func installService(s serviceSpec) error {
+	file, err := plistFile(s.name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s exists, uninstall it first", file)
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n\t<key>Label</key>")
+	plistString(&b, s.name)
+	b.WriteString("\n\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range append([]string{s.exe}, s.args...) {
+		b.WriteString("\t\t")
+		plistString(&b, a)
+		b.WriteString("\n")
+	}
+	b.WriteString("\t</array>\n\t<key>WorkingDirectory</key>")
+	plistString(&b, s.dir)
+	b.WriteString("\n\t<key>StandardOutPath</key>")
+	plistString(&b, serviceLog(s.name))
+	b.WriteString("\n\t<key>StandardErrorPath</key>")
+	plistString(&b, serviceLog(s.name))
+	b.WriteString("\n\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("</dict>\n</plist>\n")
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := launchctl("load", "-w", file); err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
+
+// uninstallService unloads the service name, which stops it, and
+// removes its property list.
+func uninstallService(name string) error {
+	file, err := plistFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", name)
+	}
+	if err := launchctl("unload", "-w", file); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// startService starts the service name.
+func startService(name string) error {
+	return launchctl("start", name)
+}
+
+// stopService stops the service name, which launchd starts again only
+// if it was stopped by an error.
+func stopService(name string) error {
+	return launchctl("stop", name)
+}
+
+// runService runs serve, which launchd needs no help with.
+func runService(name string, serve func()) error {
+	serve()
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// serviceUnit is the systemd unit of a service; the server tells
+// systemd when it is ready, as Type=notify expects.
+const serviceUnit = `[Unit]
+Description=static-server %s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+WorkingDirectory=%s
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+
+[Install]
+WantedBy=%s
+`
+
+// userService reports whether services are the user's, run by the
+// user's systemd, rather than the system's.
+func userService() bool {
+	return os.Geteuid() != 0
+}
+
+// unitFile returns the path of the unit of the service name.
+func unitFile(name string) (string, error) {
+	if !userService() {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+// systemctl runs systemctl with args, for the user's systemd if the
+// services are the user's.
+func systemctl(args ...string) error {
+	if userService() {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// systemdQuote quotes s as a word of a unit's command line.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// installService writes the unit of s and enables it.
+func installService(s serviceSpec) error {
+	file, err := unitFile(s.name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s exists, uninstall it first", file)
+	}
+	words := []string{systemdQuote(s.exe)}
+	for _, a := range s.args {
+		words = append(words, systemdQuote(a))
+	}
+	target := "multi-user.target"
+	if userService() {
+		target = "default.target"
+	}
+	unit := fmt.Sprintf(serviceUnit, s.name, strings.ReplaceAll(s.dir, "%", "%%"), strings.Join(words, " "), target)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(unit), 0o644); err != nil {
+		return err
+	}
+	err = systemctl("daemon-reload")
+	if err == nil {
+		err = systemctl("enable", s.name+".service")
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	if userService() {
+		fmt.Fprintf(os.Stderr, "note: a user's services start at boot only with loginctl enable-linger %s\n", os.Getenv("USER"))
+	}
+	return nil
+}
+
+// uninstallService stops and disables the service name and removes its
+// unit.
+func uninstallService(name string) error {
+	file, err := unitFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", name)
+	}
+	if err := systemctl("disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+// startService starts the service name.
+func startService(name string) error {
+	return systemctl("start", name+".service")
+}
+
+// stopService stops the service name.
+func stopService(name string) error {
+	return systemctl("stop", name+".service")
+}
+
+// runService runs serve, which systemd needs no help with.
+func runService(name string, serve func()) error {
+	serve()
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// errNoServices is returned by the service actions on systems there is
+// no service manager support for.
+var errNoServices = errors.New("services are not supported on " + runtime.GOOS)
+
+func installService(s serviceSpec) error { return errNoServices }
+func uninstallService(name string) error { return errNoServices }
+func startService(name string) error     { return errNoServices }
+func stopService(name string) error      { return errNoServices }
+func runService(name string, serve func()) error {
+	serve()
+	return nil
+}
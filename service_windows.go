@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The service control manager API of advapi32.dll, which package
+// syscall leaves out.
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	// errorFailedServiceControllerConnect is the error of the dispatcher
+	// of a process the service control manager did not start.
+	errorFailedServiceControllerConnect = syscall.Errno(1063)
+)
+
+// serviceStatus is the SERVICE_STATUS of a service.
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// serviceTableEntry is a SERVICE_TABLE_ENTRYW.
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// runningService is the service being run, for the callbacks of the
+// service control manager, which take no state of their own.
+var runningService struct {
+	name   *uint16
+	status uintptr // the handle its status is set with
+	state  uint32  // the state last reported
+	serve  func()
+}
+
+// sc runs sc.exe with args.
+func sc(args ...string) error {
+	out, err := exec.Command("sc.exe", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sc %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installService creates the service of s, started at boot and again
+// if it fails. The service manager starts it as service run, which
+// reports to it.
+func installService(s serviceSpec) error {
+	words := []string{syscall.EscapeArg(s.exe), "service", "-name", s.name, "run", syscall.EscapeArg(s.dir)}
+	for _, a := range s.args {
+		words = append(words, syscall.EscapeArg(a))
+	}
+	if err := sc("create", s.name, "binPath=", strings.Join(words, " "), "start=", "auto", "DisplayName=", "static-server "+s.name); err != nil {
+		return err
+	}
+	sc("description", s.name, "Serves "+s.dir+" over HTTP")
+	return sc("failure", s.name, "reset=", "86400", "actions=", "restart/5000")
+}
+
+// uninstallService stops and deletes the service name.
+func uninstallService(name string) error {
+	sc("stop", name)
+	return sc("delete", name)
+}
+
+// startService starts the service name.
+func startService(name string) error {
+	return sc("start", name)
+}
+
+// stopService stops the service name.
+func stopService(name string) error {
+	return sc("stop", name)
+}
+
+// runService runs serve as the service name, telling the service
+// control manager it is running and stopping it when asked to. Started
+// another way, it just runs serve.
+func runService(name string, serve func()) error {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	runningService.name, runningService.serve = p, serve
+	table := []serviceTableEntry{{p, syscall.NewCallback(serviceMain)}, {nil, 0}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		if errors.Is(err, errorFailedServiceControllerConnect) {
+			serve()
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// setServiceStatus reports state to the service control manager.
+func setServiceStatus(state uint32) {
+	runningService.state = state
+	st := serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state}
+	switch state {
+	case serviceRunning:
+		st.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStopPending:
+		st.waitHint = 30000 // the requests in flight get the grace period
+	}
+	procSetServiceStatus.Call(runningService.status, uintptr(unsafe.Pointer(&st)))
+}
+
+// serviceMain is the ServiceMain of the service: it serves until the
+// server stops.
+func serviceMain(argc, argv uintptr) uintptr {
+	h, _, _ := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(runningService.name)), syscall.NewCallback(serviceHandler), 0)
+	if h == 0 {
+		return 0
+	}
+	runningService.status = h
+	setServiceStatus(serviceRunning)
+	runningService.serve()
+	setServiceStatus(serviceStopped)
+	return 0
+}
+
+// serviceHandler is the HandlerEx of the service, which stops the
+// server as its stop signal would.
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending)
+		select {
+		case stopSignals <- syscall.SIGTERM:
+		default:
+		}
+	case serviceControlInterrogate:
+		setServiceStatus(runningService.state)
+	}
+	return 0
+}
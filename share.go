@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// readShareKey returns the key in the named file, which serve -share-key
+// and share both read.
+func readShareKey(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if b = bytes.TrimSpace(b); len(b) == 0 {
+		return nil, errors.New(name + ": the share key is empty")
+	}
+	return b, nil
+}
+
+// shareCommand defines the flags of the share command on fset and
+// returns the function that runs it with args, the arguments after its
+// name.
+func shareCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s share -key file [flags] path\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Print a link to the file at path, or with a trailing slash the dir and")
+		fmt.Fprintln(fset.Output(), "everything under it, that a server run with the same -share-key serves")
+		fmt.Fprintln(fset.Output(), "past its passwords until it expires.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	keyFile := fset.String("key", "", "sign the link with the key in `file`, the server's -share-key")
+	expires := fset.Duration("expires", 24*time.Hour, "let the link be used for `duration`")
+	base := fset.String("base", "http://localhost:8080", "the `url` of the server")
+	showQR := fset.Bool("qr", false, "print the link as a QR code too")
+	return func(args []string) {
+		fset.Parse(args)
+		if fset.NArg() != 1 || *keyFile == "" {
+			fset.Usage()
+			os.Exit(2)
+		}
+		if *expires <= 0 {
+			log.Fatal("-expires must be positive")
+		}
+		key, err := readShareKey(*keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		link := strings.TrimSuffix(*base, "/") + staticserver.ShareLink(key, fset.Arg(0), time.Now().Add(*expires))
+		if *showQR {
+			if err := staticserver.PrintQR(os.Stdout, link); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Println(link)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// stopSignals and reloadSignals receive the signals to stop and to
+// reload on, and are also sent them by the admin API.
+var stopSignals, reloadSignals = make(chan os.Signal, 1), make(chan os.Signal, 1)
+
+var (
+	// reloaded is set once another process has taken over on a reload.
+	reloaded bool
+	// detach is whether to hand the listeners to a process started in
+	// the background once listening, and stop.
+	detach bool
+	// pidFile is the file to write the process ID to once serving.
+	pidFile string
+)
+
+// serveUntilSignal serves srv until the process is interrupted or
+// terminated, or has reloaded on SIGHUP, then stops accepting
+// connections and gives the requests in flight up to grace to finish.
+// Once it is listening it calls ready, if it is not nil, with the
+// address listened on. If detach is set, it then hands the listener to
+// a process in the background instead of serving; otherwise it writes
+// the pidFile, if set, before serving.
+func serveUntilSignal(srv *http.Server, grace time.Duration, ready func(net.Addr)) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := listen(addr, "main")
+	if err != nil {
+		return err
+	}
+	if detach {
+		if ready != nil {
+			ready(ln.Addr())
+		}
+		// The process in the background takes over the listeners, and
+		// this one exits without serving.
+		if err := reload(true); err != nil {
+			return fmt.Errorf("detaching: %v", err)
+		}
+		return nil
+	}
+	if maxConns > 0 {
+		ln = limitListener{ln, make(chan struct{}, maxConns)}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+	if ready != nil {
+		ready(ln.Addr())
+	}
+	if pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			return err
+		}
+		defer removePIDFile(pidFile)
+	}
+	signalReady()
+	startWatchdog()
+
+	stop, hup := stopSignals, reloadSignals
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	notifyReload(hup)
+wait:
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-stop:
+			infof("%s: shutting down", sig)
+			sdNotify("STOPPING=1")
+			break wait
+		case <-hup:
+			sdNotify("RELOADING=1")
+			if err := reload(false); err != nil {
+				log.Printf("reload: %v", err)
+				sdNotify("READY=1")
+				continue
+			}
+			reloaded = true
+			break wait
+		}
+	}
+	signal.Stop(stop)
+	signal.Stop(hup)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/henderjon/static-server/staticserver"
+)
+
+// sriCommand defines the flags of the sri command on fset and returns
+// the function that runs it with args, the arguments after its name.
+func sriCommand(fset *flag.FlagSet) func(args []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: %s sri [flags] [file ...]\n\n", os.Args[0])
+		fmt.Fprintln(fset.Output(), "Print the Subresource Integrity hash of each file, or with -rewrite give the")
+		fmt.Fprintln(fset.Output(), "script and stylesheet tags of the HTML files of a dir integrity attributes.")
+		fmt.Fprintln(fset.Output())
+		fset.PrintDefaults()
+	}
+	dir := fset.String("dir", ".", "the `dir` whose HTML files -rewrite rewrites")
+	rewrite := fset.Bool("rewrite", false, "add integrity attributes to the tags of the HTML files in -dir for the files in it")
+	return func(args []string) {
+		fset.Parse(args)
+		if *rewrite == (fset.NArg() > 0) {
+			fset.Usage()
+			os.Exit(2)
+		}
+		if *rewrite {
+			n, err := staticserver.AddIntegrity(*dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("rewrote %d files\n", n)
+			return
+		}
+		for _, name := range fset.Args() {
+			f, err := os.Open(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sum, err := staticserver.Integrity(f)
+			f.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(sum, name)
+		}
+	}
+}
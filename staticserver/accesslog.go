@@ -0,0 +1,161 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFormats are the values of Config.LogFormat.
+var LogFormats = []string{"common", "combined", "json"}
+
+// accessLog writes a line for each request served to w, in the Common
+// or Combined Log Format used by Apache and most log tooling, or as a
+// JSON object with stable field names for log ingestion pipelines.
+type accessLog struct {
+	format   string   // "common", "combined" or "json"
+	statuses []string // the status codes or classes, like 4xx, to log, or all if empty
+	exclude  []string // path patterns not to log
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// setFormat validates and stores the -log-format.
+func (l *accessLog) setFormat(s string) error {
+	if slices.Contains(LogFormats, s) {
+		l.format = s
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, expected common, combined or json", s)
+}
+
+// setStatuses validates and stores the -log-status codes.
+func (l *accessLog) setStatuses(statuses []string) error {
+	for _, st := range statuses {
+		st = strings.ToLower(strings.TrimSpace(st))
+		if len(st) != 3 || st[0] < '1' || st[0] > '5' ||
+			!(st[1:] == "xx" || st[1] >= '0' && st[1] <= '9' && st[2] >= '0' && st[2] <= '9') {
+			return fmt.Errorf("invalid status %q, expected a code like 404 or a class like 4xx", st)
+		}
+		l.statuses = append(l.statuses, st)
+	}
+	return nil
+}
+
+// addExclude adds a -log-exclude pattern.
+func (l *accessLog) addExclude(s string) error {
+	if _, err := path.Match(s, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", s, err)
+	}
+	l.exclude = append(l.exclude, s)
+	return nil
+}
+
+// wants reports whether rr passes the -log-status and -log-exclude
+// filters.
+func (l *accessLog) wants(rr *requestRecord) bool {
+	for _, pattern := range l.exclude {
+		if ok, _ := path.Match(pattern, rr.Path); ok {
+			return false
+		}
+	}
+	if len(l.statuses) == 0 {
+		return true
+	}
+	code := strconv.Itoa(rr.Status)
+	for _, st := range l.statuses {
+		if st == code || st[1:] == "xx" && st[0] == code[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonAccessLine is the JSON form of an access log line.
+type jsonAccessLine struct {
+	TS      string  `json:"ts"`
+	IP      string  `json:"ip"`
+	User    string  `json:"user,omitempty"`
+	Method  string  `json:"method"`
+	Path    string  `json:"path"`
+	Query   string  `json:"query,omitempty"`
+	Host    string  `json:"host"`
+	Status  int     `json:"status"`
+	Bytes   int64   `json:"bytes"`
+	DurMS   float64 `json:"dur_ms"`
+	Referer string  `json:"referer,omitempty"`
+	UA      string  `json:"ua"`
+	ID      string  `json:"request_id,omitempty"`
+	Country string  `json:"country,omitempty"`
+	City    string  `json:"city,omitempty"`
+}
+
+// log writes the line for rr. It is an observer for observeHandler.
+func (l *accessLog) log(rr *requestRecord) {
+	if !l.wants(rr) {
+		return
+	}
+	line := l.line(rr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line+"\n")
+}
+
+// line formats the line for rr, without a trailing newline.
+func (l *accessLog) line(rr *requestRecord) string {
+	if l.format == "json" {
+		_, query, _ := strings.Cut(rr.URI, "?")
+		b, _ := json.Marshal(jsonAccessLine{
+			TS:      rr.Start.UTC().Format(time.RFC3339Nano),
+			IP:      rr.IP,
+			User:    rr.User,
+			Method:  rr.Method,
+			Path:    rr.Path,
+			Query:   query,
+			Host:    rr.Host,
+			Status:  rr.Status,
+			Bytes:   rr.Bytes,
+			DurMS:   float64(rr.Duration.Microseconds()) / 1000,
+			Referer: rr.Referer,
+			UA:      rr.UA,
+			ID:      rr.ID,
+			Country: rr.Country,
+			City:    rr.City,
+		})
+		return string(b)
+	}
+
+	user := rr.User
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if rr.Bytes > 0 {
+		size = strconv.FormatInt(rr.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		rr.IP, user, rr.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(rr.Method+" "+rr.URI+" "+rr.Proto), rr.Status, size)
+	if l.format == "combined" {
+		line += " " + strconv.Quote(orDash(rr.Referer)) + " " + strconv.Quote(orDash(rr.UA))
+		if rr.ID != "" {
+			line += " " + strconv.Quote(rr.ID)
+		}
+	}
+	return line
+}
+
+// orDash returns s, or "-" if s is empty, as log formats expect.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The endpoints of the admin API.
+const (
+	adminBansPath  = "/_admin/bans"
+	adminPurgePath = "/_admin/purge"
+	adminStatsPath = "/_admin/stats"
+	adminSharePath = "/_admin/share"
+)
+
+// caches holds the functions that empty the caches of a Server, or the
+// entries of those kept by path for the paths a match func reports.
+type caches struct {
+	mu     sync.Mutex
+	purges []func(match func(name string) bool) error
+}
+
+// add adds a function that empties a cache, which every purge runs,
+// whatever its paths.
+func (c *caches) add(purge func() error) {
+	c.addPaths(func(func(string) bool) error { return purge() })
+}
+
+// addPaths adds a function that empties the entries of a cache for the
+// paths match reports true for, or every entry if match is nil.
+func (c *caches) addPaths(purge func(match func(name string) bool) error) {
+	c.mu.Lock()
+	c.purges = append(c.purges, purge)
+	c.mu.Unlock()
+}
+
+// purge empties every cache, and returns the first error.
+func (c *caches) purge() error {
+	return c.purgePaths(nil)
+}
+
+// purgePaths empties the entries for the paths match reports true for,
+// or every entry if match is nil, and returns the first error.
+func (c *caches) purgePaths(match func(name string) bool) error {
+	c.mu.Lock()
+	purges := c.purges
+	c.mu.Unlock()
+	var first error
+	for _, purge := range purges {
+		if err := purge(match); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// adminAPI serves the admin API, for the admin listener alone: the ban
+// list at adminBansPath, cache purges at adminPurgePath and the traffic
+// at adminStatsPath, each as JSON, and with a share key share links at
+// adminSharePath.
+type adminAPI struct {
+	bans     *banList
+	caches   *caches
+	dash     *dashboard
+	maint    *maintenance
+	shareKey []byte
+}
+
+// register adds the endpoints of a to mux.
+func (a *adminAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc(adminBansPath, a.serveBans)
+	mux.HandleFunc(adminPurgePath, a.servePurge)
+	mux.HandleFunc(adminStatsPath, a.serveStats)
+	if a.shareKey != nil {
+		mux.HandleFunc(adminSharePath, a.serveShare)
+	}
+}
+
+// serveBans lists the bans to GET requests, and adds the addr of POST
+// and removes that of DELETE requests, an IP address or a network.
+func (a *adminAPI) serveBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodDelete:
+		p, err := parseBan(r.FormValue("addr"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		changed := false
+		if r.Method == http.MethodPost {
+			if changed = a.bans.add(p); changed {
+				infof("admin: banned %s", banString(p))
+			}
+		} else if changed = a.bans.remove(p); changed {
+			infof("admin: lifted the ban of %s", banString(p))
+		}
+		if changed {
+			if err := a.bans.save(); err != nil {
+				log.Printf("admin: saving the bans: %v", err)
+				http.Error(w, "Error saving the bans", http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, map[string][]string{"bans": a.bans.list()})
+}
+
+// servePurge empties the caches for POST requests, or with path
+// patterns, such as /docs/*, just their entries for the paths matching
+// them.
+func (a *adminAPI) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	patterns := r.Form["path"]
+	for _, p := range patterns {
+		if !validPathPattern(p) {
+			http.Error(w, fmt.Sprintf("invalid path %q, expected /path, /dir/ or a pattern like /docs/*", p), http.StatusBadRequest)
+			return
+		}
+	}
+	var match func(string) bool
+	if len(patterns) > 0 {
+		match = func(name string) bool { return matchPaths(patterns, name) }
+	}
+	if err := a.caches.purgePaths(match); err != nil {
+		log.Printf("admin: purging the caches: %v", err)
+		http.Error(w, "Error purging the caches", http.StatusInternalServerError)
+		return
+	}
+	if len(patterns) > 0 {
+		infof("admin: purged the caches of %s", strings.Join(patterns, ", "))
+		writeAdminJSON(w, map[string]any{"purged": true, "paths": patterns})
+		return
+	}
+	infof("admin: purged the caches")
+	writeAdminJSON(w, map[string]bool{"purged": true})
+}
+
+// serveShare mints a share link to the path of POST requests, valid
+// for their expires duration or a day, prefixed with their base URL if
+// they give one.
+func (a *adminAPI) serveShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := r.FormValue("path")
+	if p == "" {
+		http.Error(w, "no path to share", http.StatusBadRequest)
+		return
+	}
+	valid := 24 * time.Hour
+	if s := r.FormValue("expires"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid expires duration", http.StatusBadRequest)
+			return
+		}
+		valid = d
+	}
+	expires := time.Now().Add(valid)
+	link := strings.TrimSuffix(r.FormValue("base"), "/") + ShareLink(a.shareKey, p, expires)
+	infof("admin: shared %s until %s", p, expires.Format(time.RFC3339))
+	writeAdminJSON(w, map[string]string{"url": link, "expires": expires.UTC().Format(time.RFC3339)})
+}
+
+// serveStats serves the traffic counted by the dashboard, and whether
+// maintenance mode is on.
+func (a *adminAPI) serveStats(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, struct {
+		dashboardSnapshot
+		Maintenance bool `json:"maintenance"`
+		Bans        int  `json:"bans"`
+	}{a.dash.snapshot(), a.maint.on.Load(), len(a.bans.list())})
+}
+
+// writeAdminJSON writes v as the JSON response of an admin endpoint.
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(v)
+}
@@ -0,0 +1,85 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// alerter posts a Slack-compatible message to a webhook when requests
+// of some kind, such as server errors, reach a threshold within a
+// window. Each kind alerts at most once per window.
+type alerter struct {
+	webhook string
+	window  time.Duration
+	rules   []*alertRule
+	host    string
+}
+
+// alertRule counts the requests it matches in the current window.
+type alertRule struct {
+	what      string // describes the requests counted, in the plural
+	threshold int
+	match     func(*requestRecord) bool
+
+	mu    sync.Mutex
+	since time.Time
+	count int
+}
+
+// newAlerter returns an alerter that posts to webhook.
+func newAlerter(webhook string, window time.Duration) *alerter {
+	host, _ := os.Hostname()
+	return &alerter{webhook: webhook, window: window, host: host}
+}
+
+// add alerts when threshold requests that match are served within the
+// window. A threshold of 0 adds nothing.
+func (a *alerter) add(what string, threshold int, match func(*requestRecord) bool) {
+	if threshold > 0 {
+		a.rules = append(a.rules, &alertRule{what: what, threshold: threshold, match: match})
+	}
+}
+
+// observe counts rr against every rule. It is an observer for
+// observeHandler.
+func (a *alerter) observe(rr *requestRecord) {
+	for _, rule := range a.rules {
+		if !rule.match(rr) {
+			continue
+		}
+		rule.mu.Lock()
+		if rr.Start.Sub(rule.since) > a.window {
+			rule.since, rule.count = rr.Start, 0
+		}
+		rule.count++
+		fire := rule.count == rule.threshold
+		rule.mu.Unlock()
+		if fire {
+			a.send(fmt.Sprintf("static-server on %s: %d %s within %s, the latest %s %s from %s",
+				a.host, rule.threshold, rule.what, a.window, rr.Method, rr.Path, rr.IP))
+		}
+	}
+}
+
+// send posts text to the webhook in the background.
+func (a *alerter) send(text string) {
+	body, _ := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	go func() {
+		resp, err := webhookClient.Post(a.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("alert webhook:", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("alert webhook: %s responded %s", a.webhook, resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// alias maps a legacy path to its new location. A from path ending in a
+// slash matches everything beneath it, with the remainder of the request
+// path appended to to. When redirect is set the client is sent to the new
+// location with a 301; otherwise the new location is served in place.
+type alias struct {
+	from, to string
+	redirect bool
+}
+
+// Aliases is the set of mappings given by repeated -alias and -redirect flags.
+type Aliases []alias
+
+// Parse returns a flag.Func compatible function that adds mappings of the
+// form /old=/new, redirecting to them when redirect is set.
+func (a *Aliases) Parse(redirect bool) func(string) error {
+	return func(s string) error {
+		from, to, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") {
+			return fmt.Errorf("invalid alias %q, expected /old=/new", s)
+		}
+		*a = append(*a, alias{from: from, to: to, redirect: redirect})
+		return nil
+	}
+}
+
+// resolve returns the mapping for p and the path it maps to. Exact
+// mappings take precedence over the longest matching prefix mapping.
+func (a Aliases) resolve(p string) (*alias, string) {
+	var best *alias
+	for i, m := range a {
+		if m.from == p {
+			return &a[i], m.to
+		}
+		if strings.HasSuffix(m.from, "/") && strings.HasPrefix(p, m.from) && (best == nil || len(m.from) > len(best.from)) {
+			best = &a[i]
+		}
+	}
+	if best == nil {
+		return nil, ""
+	}
+	return best, best.to + strings.TrimPrefix(p, best.from)
+}
+
+// aliasHandler rewrites or redirects requests matching one of its aliases
+// before they reach next.
+type aliasHandler struct {
+	aliases Aliases
+	next    http.Handler
+}
+
+// ServeHTTP serves or redirects r to its new location, if it has one.
+func (h aliasHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m, to := h.aliases.resolve(r.URL.Path)
+	switch {
+	case m == nil:
+		h.next.ServeHTTP(w, r)
+	case m.redirect:
+		debugf("alias: %s: redirecting to %s", r.URL.Path, to)
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusMovedPermanently)
+	default:
+		debugf("alias: %s: serving %s", r.URL.Path, to)
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = to, ""
+		h.next.ServeHTTP(w, r2)
+	}
+}
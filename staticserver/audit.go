@@ -0,0 +1,202 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the audit log. Hash is the SHA-256, or the
+// HMAC-SHA256 with the audit key, of Prev, the hash of the line before,
+// and the line without its hash, so that a line changed, removed or
+// put in breaks the chain from there on. Lines cut off the end leave
+// a chain that is whole, which only a copy of the last hash kept
+// elsewhere shows.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Claimed bool      `json:"claimed,omitempty"` // User is only the name of refused credentials
+	IP      string    `json:"ip"`
+	Action  string    `json:"action"` // read, list, write, delete or the method
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Status  int       `json:"status"`
+	Result  string    `json:"result"` // ok, denied or failed
+	Prev    string    `json:"prev"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// sum returns the hash of e, which has no Hash yet.
+func (e auditEntry) sum(key []byte) string {
+	b, _ := json.Marshal(e)
+	if len(key) > 0 {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(b)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditAction returns the action of a request with method.
+func auditAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case "PROPFIND":
+		return "list"
+	case http.MethodPut, http.MethodPost, http.MethodPatch, "MKCOL", "COPY", "MOVE", "PROPPATCH":
+		return "write"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return strings.ToLower(method)
+}
+
+// auditResult returns the result of a request answered with status.
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status < 400:
+		return "ok"
+	}
+	return "failed"
+}
+
+// auditLog appends a line for every request made by a user, or with
+// the credentials of one that were refused, to a file kept apart from
+// the access log, with each line hashed with the
+// one before it. It carries on the chain of a file it is opened on
+// again.
+type auditLog struct {
+	key []byte
+
+	mu   sync.Mutex
+	f    *os.File
+	prev string
+	err  error // the last write error, logged once
+}
+
+// newAuditLog opens the audit log name, creating it if need be, and
+// reads the hash of its last line to carry on from.
+func newAuditLog(name string, key []byte) (*auditLog, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{key: key, f: f}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	var last []byte
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit log %s: %v", name, err)
+	}
+	if last != nil {
+		var e auditEntry
+		if err := json.Unmarshal(last, &e); err != nil || e.Hash == "" {
+			f.Close()
+			return nil, fmt.Errorf("audit log %s: the last line is not an audit entry", name)
+		}
+		a.prev = e.Hash
+	}
+	return a, nil
+}
+
+// observe appends the entry of rr, if it was made by a user or with
+// the credentials of one.
+func (a *auditLog) observe(rr *requestRecord) {
+	if rr.User == "" && rr.Claimed == "" {
+		return
+	}
+	e := auditEntry{
+		Time:   rr.Start.UTC(),
+		User:   rr.User,
+		IP:     rr.IP,
+		Action: auditAction(rr.Method),
+		Method: rr.Method,
+		Path:   rr.Path,
+		Status: rr.Status,
+		Result: auditResult(rr.Status),
+	}
+	if rr.User == "" {
+		e.User, e.Claimed = rr.Claimed, true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e.Prev = a.prev
+	e.Hash = e.sum(a.key)
+	line, _ := json.Marshal(e)
+	// One write, so that a line is never split by another writer.
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		if a.err == nil {
+			log.Println("audit:", err)
+		}
+		a.err = err
+		return
+	}
+	a.err = nil
+	a.prev = e.Hash
+}
+
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.f.Close()
+}
+
+// VerifyAuditLog checks the hash chain of the audit log read from r,
+// hashed with key if the server had one, and returns the number of
+// lines it holds, or an error naming the first line that was changed,
+// removed or put in. Lines cut off the end are not found, as what is
+// left is a whole chain.
+func VerifyAuditLog(r io.Reader, key []byte) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	n, prev := 0, ""
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		var e auditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n - 1, fmt.Errorf("line %d: %v", n, err)
+		}
+		if e.Prev != prev {
+			return n - 1, fmt.Errorf("line %d: does not follow the line before it", n)
+		}
+		hash := e.Hash
+		e.Hash = ""
+		if !hmac.Equal([]byte(hash), []byte(e.sum(key))) {
+			return n - 1, fmt.Errorf("line %d: the hash does not match", n)
+		}
+		prev = hash
+	}
+	if err := sc.Err(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, errors.New("no audit entries")
+	}
+	return n, nil
+}
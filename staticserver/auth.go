@@ -0,0 +1,104 @@
+package staticserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Accounts maps user names to what their passwords are checked with.
+// It is populated by repeated -auth flags of the form user:password,
+// user:sha256:digest with the hex digest HashPassword returns, or
+// user:hash with a $2a$, $2b$ or $2y$ bcrypt hash, as BcryptPassword
+// and htpasswd -nB write them. A password that is a well formed digest
+// or bcrypt hash is taken as one.
+type Accounts map[string]account
+
+// account is what the password of an account is checked with: its
+// SHA-256 digest, or its bcrypt hash and the digests of the passwords
+// found to match it, so that each request does not pay for bcrypt.
+type account struct {
+	digest [sha256.Size]byte
+	bcrypt string
+	known  *sync.Map
+}
+
+// Set parses a single user:password, user:sha256:digest or user:hash
+// pair and adds it to the map. It has the signature expected by
+// flag.Func.
+func (a Accounts) Set(s string) error {
+	user, pass, ok := strings.Cut(s, ":")
+	if !ok || user == "" || pass == "" {
+		return fmt.Errorf("invalid auth %q, expected user:password", s)
+	}
+	if digest, ok := strings.CutPrefix(pass, "sha256:"); ok {
+		if b, err := hex.DecodeString(digest); err == nil && len(b) == sha256.Size {
+			a[user] = account{digest: [sha256.Size]byte(b)}
+			return nil
+		}
+	}
+	if strings.HasPrefix(pass, "$2") {
+		if _, _, _, err := parseBcrypt(pass); err == nil {
+			a[user] = account{bcrypt: pass, known: &sync.Map{}}
+			return nil
+		}
+	}
+	a[user] = account{digest: sha256.Sum256([]byte(pass))}
+	return nil
+}
+
+// HashPassword returns the sha256:digest form of password that Set
+// takes, so that it need not be written down. It is the digest the
+// server keeps, not a slow password hash, so it must be kept as private
+// as the password; BcryptPassword makes one that need not be.
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// check reports whether pass is the password of the account.
+func (acct account) check(pass string) bool {
+	got := sha256.Sum256([]byte(pass))
+	if acct.bcrypt == "" {
+		return subtle.ConstantTimeCompare(acct.digest[:], got[:]) == 1
+	}
+	if _, ok := acct.known.Load(got); ok {
+		return true
+	}
+	if ok, _ := checkBcrypt(acct.bcrypt, pass); !ok {
+		return false
+	}
+	acct.known.Store(got, true)
+	return true
+}
+
+// user returns the name of the account whose HTTP Basic credentials r
+// carries, or false if it carries none or they are wrong.
+func (a Accounts) user(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	acct, known := a[user]
+	if !acct.check(pass) || !known {
+		setUser(r, user, false)
+		return "", false
+	}
+	setUser(r, user, true)
+	return user, true
+}
+
+// authorize reports whether r carries the credentials of one of the
+// accounts. If it does not, a 401 challenge is sent and false returned.
+func (a Accounts) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := a.user(r); ok {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="static-server", charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return false
+}
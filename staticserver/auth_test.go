@@ -0,0 +1,68 @@
+package staticserver
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccounts(t *testing.T) {
+	const bcryptABC = "$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"
+	tests := []struct {
+		name, auth     string
+		user, password string
+		wantSetErr, ok bool
+	}{
+		{"plain", "alice:secret", "alice", "secret", false, true},
+		{"plain wrong", "alice:secret", "alice", "secrets", false, false},
+		{"password with colons", "alice:a:b:c", "alice", "a:b:c", false, true},
+		{"digest", "alice:" + HashPassword("secret"), "alice", "secret", false, true},
+		{"digest is not the password", "alice:" + HashPassword("secret"), "alice", HashPassword("secret"), false, false},
+		{"plain that starts like a digest", "alice:sha256:hunter2", "alice", "sha256:hunter2", false, true},
+		{"bcrypt 2a", "alice:" + bcryptABC, "alice", "abc", false, true},
+		{"bcrypt 2b", "alice:$2b" + bcryptABC[3:], "alice", "abc", false, true},
+		{"bcrypt wrong", "alice:" + bcryptABC, "alice", "abd", false, false},
+		{"bcrypt is not the password", "alice:" + bcryptABC, "alice", bcryptABC, false, false},
+		{"plain that starts like bcrypt", "alice:$2a$pass", "alice", "$2a$pass", false, true},
+		{"unknown user", "alice:secret", "bob", "secret", false, false},
+		{"no password", "alice:", "", "", true, false},
+		{"no user", ":secret", "", "", true, false},
+		{"no colon", "alice", "", "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Accounts{}
+			if err := a.Set(tt.auth); (err != nil) != tt.wantSetErr {
+				t.Fatalf("Set(%q) = %v, want error %v", tt.auth, err, tt.wantSetErr)
+			}
+			if tt.wantSetErr {
+				return
+			}
+			// Twice, as a bcrypt match is remembered.
+			for range 2 {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.SetBasicAuth(tt.user, tt.password)
+				user, ok := a.user(r)
+				if ok != tt.ok || ok && user != tt.user {
+					t.Errorf("user = %q, %v, want %q, %v", user, ok, tt.user, tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a := Accounts{}
+	a.Set("alice:secret")
+	w := httptest.NewRecorder()
+	if a.authorize(w, httptest.NewRequest("GET", "/", nil)) {
+		t.Error("authorized a request without credentials")
+	}
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("sent %d with WWW-Authenticate %q, want a 401 challenge", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	if !a.authorize(httptest.NewRecorder(), r) {
+		t.Error("did not authorize alice")
+	}
+}
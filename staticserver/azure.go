@@ -0,0 +1,221 @@
+package staticserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Azure says where the blobs of an Azure Storage container are and how
+// to authorize requests for them: with the account key, a shared
+// access signature, or neither for a public container.
+type Azure struct {
+	Account   string
+	Container string
+	Prefix    string // the name prefix of the blobs to serve, if any
+	Endpoint  string // the URL of the account's blob service, such as Azurite's, or Azure's if empty
+	Key       string // the base64 account key requests are signed with, if any
+	SAS       string // a shared access signature to add to requests, if there is no Key
+	Cache     ObjectCache
+}
+
+// NewAzureFS returns an fs.FS of the blobs in the container, to be set
+// as the FS of a Config.
+func NewAzureFS(a Azure) (fs.FS, error) {
+	if a.Account == "" || a.Container == "" {
+		return nil, errors.New("azure: no account or container")
+	}
+	store := &azureStore{account: a.Account}
+	base := "https://" + a.Account + ".blob.core.windows.net"
+	if a.Endpoint != "" {
+		u, err := parseUpstream(a.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		base = strings.TrimSuffix(u.String(), "/")
+	}
+	u, err := url.Parse(base + "/" + url.PathEscape(a.Container) + "/")
+	if err != nil {
+		return nil, err
+	}
+	store.base = u
+	if a.Key != "" {
+		if store.key, err = base64.StdEncoding.DecodeString(a.Key); err != nil {
+			return nil, fmt.Errorf("azure: invalid account key: %v", err)
+		}
+	} else if a.SAS != "" {
+		if store.sas, err = url.ParseQuery(strings.TrimPrefix(a.SAS, "?")); err != nil {
+			return nil, fmt.Errorf("azure: invalid shared access signature: %v", err)
+		}
+	}
+	return newObjectFS(store, a.Prefix, a.Cache)
+}
+
+// azureVersion is the version of the Blob service REST API used.
+const azureVersion = "2021-08-06"
+
+// azureStore is the objectStore of an Azure Storage container.
+type azureStore struct {
+	account string
+	base    *url.URL   // the URL of the container, ending in a slash
+	key     []byte     // the account key, if requests are signed
+	sas     url.Values // the shared access signature, if there is no key
+}
+
+// do sends an authorized request for the blob, or the container if blob
+// is empty, and returns the response if its status is one of ok.
+func (s *azureStore) do(method, blob string, query url.Values, header http.Header, ok ...int) (*http.Response, error) {
+	u := *s.base
+	u.Path += blob
+	u.RawPath = s.base.EscapedPath() + escapeKey(blob)
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	for k, v := range s.sas {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Ms-Version", azureVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if s.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+signSharedKey(req, s.account, s.key))
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusForbidden:
+		return nil, fs.ErrPermission
+	}
+	var e struct{ Code, Message string }
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Header.Get("X-Ms-Error-Code")
+	}
+	return nil, fmt.Errorf("azure: %s %s: %s %s", method, u.Path, resp.Status, e.Code)
+}
+
+func (s *azureStore) stat(blob string) (objectInfo, error) {
+	resp, err := s.do(http.MethodHead, blob, nil, nil, http.StatusOK)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{key: blob, size: resp.ContentLength, modTime: modTime}, nil
+}
+
+func (s *azureStore) get(blob string, off int64) (io.ReadCloser, error) {
+	h := http.Header{}
+	if off > 0 {
+		h.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-")
+	}
+	resp, err := s.do(http.MethodGet, blob, nil, h, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azureStore) list(prefix string) (objects []objectInfo, prefixes []string, err error) {
+	q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		resp, err := s.do(http.MethodGet, "", q, nil, http.StatusOK)
+		if err != nil {
+			return nil, nil, err
+		}
+		var page struct {
+			Blobs struct {
+				Blob []struct {
+					Name       string
+					Properties struct {
+						Size         int64  `xml:"Content-Length"`
+						LastModified string `xml:"Last-Modified"`
+					}
+				}
+				BlobPrefix []struct{ Name string }
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("azure: listing %s: %v", prefix, err)
+		}
+		for _, b := range page.Blobs.Blob {
+			modTime, _ := http.ParseTime(b.Properties.LastModified)
+			objects = append(objects, objectInfo{key: b.Name, size: b.Properties.Size, modTime: modTime})
+		}
+		for _, p := range page.Blobs.BlobPrefix {
+			prefixes = append(prefixes, p.Name)
+		}
+		if page.NextMarker == "" {
+			return objects, prefixes, nil
+		}
+		q.Set("marker", page.NextMarker)
+	}
+}
+
+// signSharedKey returns the Shared Key signature of req, which has an
+// empty body, for the account.
+func signSharedKey(req *http.Request, account string, key []byte) string {
+	var headers []string
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k)
+		}
+	}
+	sort.Strings(headers)
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	// Content-Encoding, -Language, -Length, -MD5, -Type, Date, If-Modified-Since,
+	// If-Match, If-None-Match and If-Unmodified-Since are never sent.
+	b.WriteString(strings.Repeat("\n", 10))
+	b.WriteString(req.Header.Get("Range") + "\n")
+	for _, k := range headers {
+		b.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	b.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := query[k]
+		sort.Strings(v)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(v, ","))
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,171 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// parseBan parses a banned address, an IP address or a network in CIDR
+// notation such as 203.0.113.0/24.
+func parseBan(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid ban %q, expected an IP address or a network like 203.0.113.0/24", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ban %q, expected an IP address or a network like 203.0.113.0/24", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// banList refuses the requests of the clients in its networks with 403
+// Forbidden. Its networks are kept in file, one a line, if it is set,
+// so that the bans made at the admin API outlast the process.
+type banList struct {
+	file string
+
+	mu   sync.RWMutex
+	nets []netip.Prefix
+}
+
+// newBanList returns the banList of bans and of those listed in file,
+// if it is set and exists, where blank lines and those starting with #
+// are left out.
+func newBanList(bans []string, file string) (*banList, error) {
+	b := &banList{file: file}
+	for _, s := range bans {
+		p, err := parseBan(s)
+		if err != nil {
+			return nil, err
+		}
+		b.add(p)
+	}
+	if file == "" {
+		return b, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := parseBan(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		b.add(p)
+	}
+	return b, nil
+}
+
+// add bans p, and reports whether it was not banned already.
+func (b *banList) add(p netip.Prefix) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if slices.Contains(b.nets, p) {
+		return false
+	}
+	b.nets = append(b.nets, p)
+	slices.SortFunc(b.nets, func(x, y netip.Prefix) int { return strings.Compare(x.String(), y.String()) })
+	return true
+}
+
+// remove lifts the ban of p, and reports whether it was banned.
+func (b *banList) remove(p netip.Prefix) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.Index(b.nets, p)
+	if i < 0 {
+		return false
+	}
+	b.nets = slices.Delete(b.nets, i, i+1)
+	return true
+}
+
+// list returns the banned networks.
+func (b *banList) list() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	s := make([]string, len(b.nets))
+	for i, p := range b.nets {
+		s[i] = banString(p)
+	}
+	return s
+}
+
+// banString returns p as parseBan parses it, without the prefix length
+// of a single address.
+func banString(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// banned reports whether the client address ip is banned.
+func (b *banList) banned(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, p := range b.nets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the banned networks to the file, if there is one.
+func (b *banList) save() error {
+	if b.file == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.file), ".bans-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, s := range b.list() {
+		fmt.Fprintln(tmp, s)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.file)
+}
+
+// handler returns next with the requests of banned clients refused.
+func (b *banList) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); b.banned(ip) {
+			debugf("ban: refusing %s", ip)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
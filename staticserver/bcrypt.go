@@ -0,0 +1,165 @@
+package staticserver
+
+//go:generate go run gen_blowfish.go
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The costs of bcrypt hashes: BcryptPassword hashes with bcryptCost,
+// and hashes of more than bcryptMaxCost are refused, so that a hash
+// file cannot make every login take minutes.
+const (
+	bcryptMinCost = 4
+	bcryptCost    = 10
+	bcryptMaxCost = 16
+)
+
+// bcryptEncoding is the base64 alphabet of bcrypt hashes.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// BcryptPassword returns the $2b$ bcrypt hash of password, as htpasswd
+// -B writes them, with a random salt.
+func BcryptPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return bcryptHash([]byte(password), salt, bcryptCost, "2b")
+}
+
+// parseBcrypt returns the version, the cost and the salt of hash, a
+// $2a$, $2b$ or $2y$ bcrypt hash, or an error if it is not one.
+func parseBcrypt(hash string) (version string, cost int, salt []byte, err error) {
+	version, rest, ok := strings.Cut(strings.TrimPrefix(hash, "$"), "$")
+	if !ok || version != "2a" && version != "2b" && version != "2y" {
+		return "", 0, nil, errors.New("not a bcrypt hash")
+	}
+	costStr, rest, ok := strings.Cut(rest, "$")
+	cost, err = strconv.Atoi(costStr)
+	if !ok || err != nil || len(rest) != 53 {
+		return "", 0, nil, errors.New("malformed bcrypt hash")
+	}
+	if cost < bcryptMinCost || cost > bcryptMaxCost {
+		return "", 0, nil, fmt.Errorf("bcrypt cost %d is not between %d and %d", cost, bcryptMinCost, bcryptMaxCost)
+	}
+	salt, err = bcryptEncoding.DecodeString(rest[:22])
+	if err != nil {
+		return "", 0, nil, errors.New("malformed bcrypt salt")
+	}
+	if _, err := bcryptEncoding.DecodeString(rest[22:]); err != nil {
+		return "", 0, nil, errors.New("malformed bcrypt digest")
+	}
+	return version, cost, salt, nil
+}
+
+// checkBcrypt reports whether password is the one hash, a $2a$, $2b$
+// or $2y$ bcrypt hash, was made from.
+func checkBcrypt(hash, password string) (bool, error) {
+	version, cost, salt, err := parseBcrypt(hash)
+	if err != nil {
+		return false, err
+	}
+	got, err := bcryptHash([]byte(password), salt, cost, version)
+	if err != nil {
+		return false, err
+	}
+	// Only the digests are compared, as the last character of a salt
+	// holds bits that are ignored.
+	return subtle.ConstantTimeCompare([]byte(got[len(got)-31:]), []byte(hash[len(hash)-31:])) == 1, nil
+}
+
+// bcryptHash returns the bcrypt hash of password with the 16 byte salt.
+func bcryptHash(password, salt []byte, cost int, version string) (string, error) {
+	if len(salt) != 16 {
+		return "", errors.New("bcrypt salts are 16 bytes")
+	}
+	// The key is the password and its terminating NUL, of at most 72
+	// bytes.
+	key := append(password[:len(password):len(password)], 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+	var c blowfish
+	c.p, c.s = blowfishP, blowfishS
+	c.expandKey(key, salt)
+	for range 1 << cost {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+	ctext := []byte("OrpheanBeholderScryDoubt")
+	for i := 0; i < len(ctext); i += 8 {
+		l, r := binary.BigEndian.Uint32(ctext[i:]), binary.BigEndian.Uint32(ctext[i+4:])
+		for range 64 {
+			l, r = c.encrypt(l, r)
+		}
+		binary.BigEndian.PutUint32(ctext[i:], l)
+		binary.BigEndian.PutUint32(ctext[i+4:], r)
+	}
+	return fmt.Sprintf("$%s$%02d$%s%s", version, cost, bcryptEncoding.EncodeToString(salt), bcryptEncoding.EncodeToString(ctext[:23])), nil
+}
+
+// blowfish is the state of the expensive key schedule of bcrypt.
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+// f is the round function of Blowfish.
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+// encrypt encrypts the block l, r.
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i <= 16; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	return r ^ c.p[17], l
+}
+
+// expandKey mixes key into the P-array and then replaces the P-array
+// and S-boxes by encrypting, each block XORed with salt if it is not
+// nil.
+func (c *blowfish) expandKey(key, salt []byte) {
+	j := 0
+	for i := range c.p {
+		c.p[i] ^= streamWord(key, &j)
+	}
+	var l, r uint32
+	k := 0
+	next := func(p *uint32, q *uint32) {
+		if salt != nil {
+			l ^= streamWord(salt, &k)
+			r ^= streamWord(salt, &k)
+		}
+		l, r = c.encrypt(l, r)
+		*p, *q = l, r
+	}
+	for i := 0; i < len(c.p); i += 2 {
+		next(&c.p[i], &c.p[i+1])
+	}
+	for s := range c.s {
+		for i := 0; i < len(c.s[s]); i += 2 {
+			next(&c.s[s][i], &c.s[s][i+1])
+		}
+	}
+}
+
+// streamWord returns the next four bytes of b from *j as a word, going
+// around to the start of b as it runs out.
+func streamWord(b []byte, j *int) uint32 {
+	var w uint32
+	for range 4 {
+		w = w<<8 | uint32(b[*j])
+		*j = (*j + 1) % len(b)
+	}
+	return w
+}
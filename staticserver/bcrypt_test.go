@@ -0,0 +1,91 @@
+package staticserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckBcrypt(t *testing.T) {
+	// The test vectors of jBCrypt.
+	tests := []struct{ password, hash string }{
+		{"", "$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s."},
+		{"a", "$2a$06$m0CrhHm10qJ3lXRY.5zDGO3rS2KdeeWLuGmsfGlMfOxih58VYVfxe"},
+		{"abc", "$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"},
+		{"abcdefghijklmnopqrstuvwxyz", "$2a$06$.rCVZVOThsIa97pEDOxvGuRRgzG64bvtJ0938xuqzv18d3ZpQhstC"},
+		{"~!@#$%^&*()      ~!@#$%^&*()PNBFRD", "$2a$06$fPIsBO8qRqkjj273rfaOI.HtSV9jLDpTbZn782DC6/t7qT67P6FfO"},
+	}
+	for _, tt := range tests {
+		ok, err := checkBcrypt(tt.hash, tt.password)
+		if err != nil || !ok {
+			t.Errorf("checkBcrypt(%q, %q) = %v, %v, want true", tt.hash, tt.password, ok, err)
+		}
+		if ok, _ := checkBcrypt(tt.hash, tt.password+"x"); ok {
+			t.Errorf("checkBcrypt(%q, %q) = true, want false", tt.hash, tt.password+"x")
+		}
+		// $2b$ and $2y$ hash the same for passwords under 255 bytes.
+		for _, v := range []string{"$2b$", "$2y$"} {
+			if ok, err := checkBcrypt(v+tt.hash[4:], tt.password); err != nil || !ok {
+				t.Errorf("checkBcrypt(%q, %q) = %v, %v, want true", v+tt.hash[4:], tt.password, ok, err)
+			}
+		}
+	}
+}
+
+func TestCheckBcryptLong(t *testing.T) {
+	// Only the first 72 bytes of a password count.
+	hash, err := bcryptHash([]byte(strings.Repeat("p", 72)), make([]byte, 16), bcryptMinCost, "2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := checkBcrypt(hash, strings.Repeat("p", 80)); !ok {
+		t.Error("a password longer than 72 bytes did not match its first 72")
+	}
+	if ok, _ := checkBcrypt(hash, strings.Repeat("p", 71)); ok {
+		t.Error("a password of 71 bytes matched one of 72")
+	}
+}
+
+func TestParseBcrypt(t *testing.T) {
+	good := "$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s."
+	tests := []struct {
+		name, hash string
+		ok         bool
+	}{
+		{"good", good, true},
+		{"2b", "$2b" + good[3:], true},
+		{"2y", "$2y" + good[3:], true},
+		{"unknown version", "$2x" + good[3:], false},
+		{"md5 crypt", "$1$abc$def", false},
+		{"plain", "password", false},
+		{"empty", "", false},
+		{"cost too low", "$2a$03" + good[6:], false},
+		{"cost too high", "$2a$17" + good[6:], false},
+		{"cost not a number", "$2a$xx" + good[6:], false},
+		{"short", good[:len(good)-1], false},
+		{"long", good + "x", false},
+		{"bad salt", "$2a$06$DCq7YPn5Rq63x1Lad4cl!" + good[29:], false},
+		{"bad digest", good[:len(good)-1] + "!", false},
+	}
+	for _, tt := range tests {
+		if _, _, _, err := parseBcrypt(tt.hash); (err == nil) != tt.ok {
+			t.Errorf("%s: parseBcrypt(%q) = %v, want ok %v", tt.name, tt.hash, err, tt.ok)
+		}
+	}
+}
+
+func TestBcryptPassword(t *testing.T) {
+	a, err := BcryptPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := BcryptPassword("secret")
+	if a == b {
+		t.Error("two hashes of a password are the same, want random salts")
+	}
+	if !strings.HasPrefix(a, "$2b$10$") || len(a) != 60 {
+		t.Errorf("BcryptPassword = %q, want a $2b$10$ hash of 60 characters", a)
+	}
+	if ok, err := checkBcrypt(a, "secret"); err != nil || !ok {
+		t.Errorf("checkBcrypt(%q, %q) = %v, %v, want true", a, "secret", ok, err)
+	}
+}
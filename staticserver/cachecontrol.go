@@ -0,0 +1,146 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheRule sets the Cache-Control header of the paths matching its
+// pattern.
+type CacheRule struct {
+	Pattern string // a path.Match pattern such as /css/*.css, or a dir ending in a slash for everything beneath it
+	Value   string // the Cache-Control value, such as max-age=60, stale-while-revalidate=600
+}
+
+// CacheRules are the Cache-Control headers of paths, the first rule
+// matching a path setting its header. They are also how long a mirror
+// keeps its copies: one whose rule gives a max-age or s-maxage is
+// fetched again once it is that old, served stale while it is being
+// fetched for up to stale-while-revalidate seconds more, and served
+// stale when upstream fails for up to stale-if-error seconds more.
+type CacheRules []CacheRule
+
+// cacheDeltas are the directives whose value is a number of seconds.
+var cacheDeltas = map[string]bool{
+	"max-age":                true,
+	"s-maxage":               true,
+	"stale-while-revalidate": true,
+	"stale-if-error":         true,
+}
+
+// Set adds the rule pattern=value, as a flag.Value.
+func (c *CacheRules) Set(s string) error {
+	pattern, value, ok := strings.Cut(s, "=")
+	if !ok || value == "" {
+		return fmt.Errorf("invalid cache rule %q, expected pattern=directives", s)
+	}
+	if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("invalid cache pattern %q, expected /path, /dir/ or a pattern like /css/*.css", pattern)
+	}
+	if _, err := parseCacheControl(value); err != nil {
+		return err
+	}
+	*c = append(*c, CacheRule{Pattern: pattern, Value: strings.TrimSpace(value)})
+	return nil
+}
+
+func (c CacheRules) String() string {
+	s := make([]string, len(c))
+	for i, r := range c {
+		s[i] = r.Pattern + "=" + r.Value
+	}
+	return strings.Join(s, " ")
+}
+
+// lookup returns the Cache-Control value of p, or "" if no rule matches.
+func (c CacheRules) lookup(p string) string {
+	for _, r := range c {
+		if strings.HasSuffix(r.Pattern, "/") && strings.HasPrefix(p, r.Pattern) {
+			return r.Value
+		}
+		if ok, _ := path.Match(r.Pattern, p); ok {
+			return r.Value
+		}
+	}
+	return ""
+}
+
+// parseCacheControl parses the directives of a Cache-Control value,
+// checking that those taking seconds have them.
+func parseCacheControl(v string) (map[string]string, error) {
+	d := map[string]string{}
+	for _, dir := range strings.Split(v, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		name, val, _ := strings.Cut(dir, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		if cacheDeltas[name] {
+			if n, err := strconv.Atoi(val); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid cache directive %q, expected %s=seconds", dir, name)
+			}
+		}
+		d[name] = val
+	}
+	if len(d) == 0 {
+		return nil, fmt.Errorf("invalid cache directives %q", v)
+	}
+	return d, nil
+}
+
+// cacheLifetime is how a Cache-Control value says a shared cache may
+// keep a copy.
+type cacheLifetime struct {
+	fresh           time.Duration // how long the copy is served as is
+	whileRevalidate time.Duration // how much longer it is served while being fetched again
+	ifError         time.Duration // how much longer it is served when fetching it fails
+}
+
+// lifetime returns the lifetime of the copies of p, and false if no
+// rule gives p a max-age or s-maxage, as they are then kept for good.
+func (c CacheRules) lifetime(p string) (cacheLifetime, bool) {
+	v := c.lookup(p)
+	if v == "" {
+		return cacheLifetime{}, false
+	}
+	d, _ := parseCacheControl(v)
+	seconds := func(name string) time.Duration {
+		n, _ := strconv.Atoi(d[name])
+		return time.Duration(n) * time.Second
+	}
+	l := cacheLifetime{whileRevalidate: seconds("stale-while-revalidate"), ifError: seconds("stale-if-error")}
+	_, noCache := d["no-cache"]
+	_, shared := d["s-maxage"]
+	_, maxAge := d["max-age"]
+	switch {
+	case noCache:
+	case shared:
+		l.fresh = seconds("s-maxage")
+	case maxAge:
+		l.fresh = seconds("max-age")
+	default:
+		return cacheLifetime{}, false
+	}
+	return l, true
+}
+
+// cacheHandler sets the Cache-Control header its rules give the paths
+// of requests.
+type cacheHandler struct {
+	rules CacheRules
+	next  http.Handler
+}
+
+// ServeHTTP serves r with next, with the Cache-Control of its path.
+func (h cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if v := h.rules.lookup(r.URL.Path); v != "" {
+		w.Header().Set("Cache-Control", v)
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,73 @@
+package staticserver
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// canaryCookie holds the bucket a client was put in, which keeps it
+	// on the same build from one visit to the next.
+	canaryCookie = "canary"
+	// canaryBuckets is the number of buckets; the canary serves those
+	// below its share of them.
+	canaryBuckets = 10000
+	// canaryTTL is how long a client keeps its bucket.
+	canaryTTL = 30 * 24 * time.Hour
+)
+
+// Canary is a second build of the site, served in place of Dir to a
+// share of the clients.
+type Canary struct {
+	Dir   string
+	Share float64 // the share of clients to serve from Dir, from 0 to 1
+}
+
+// Set parses a -canary of the form dir=share, such as ./site-v2=10%.
+func (c *Canary) Set(s string) error {
+	i := strings.LastIndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("invalid canary %q, expected dir=share like ./site-v2=10%%", s)
+	}
+	share, err := ParseShare(s[i+1:])
+	if err != nil {
+		return err
+	}
+	c.Dir, c.Share = s[:i], share
+	return nil
+}
+
+// canaryHandler serves a share of the clients from the canary build and
+// the others from the stable one. Each client is put in a random bucket
+// kept in a cookie, so that it sees the same build on every request and,
+// as the share grows, the clients already on the canary stay on it.
+type canaryHandler struct {
+	share  float64
+	stable http.Handler
+	canary http.Handler
+}
+
+// ServeHTTP serves r from the build of its client's bucket.
+func (h canaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Cookie")
+	bucket := -1
+	if c, err := r.Cookie(canaryCookie); err == nil {
+		if n, err := strconv.Atoi(c.Value); err == nil && n >= 0 && n < canaryBuckets {
+			bucket = n
+		}
+	}
+	if bucket < 0 {
+		bucket = rand.IntN(canaryBuckets)
+		http.SetCookie(w, &http.Cookie{Name: canaryCookie, Value: strconv.Itoa(bucket), Path: "/", MaxAge: int(canaryTTL.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	}
+	if float64(bucket) < h.share*canaryBuckets {
+		debugf("canary: %s from the canary, bucket %d", r.URL.Path, bucket)
+		h.canary.ServeHTTP(w, r)
+		return
+	}
+	h.stable.ServeHTTP(w, r)
+}
@@ -0,0 +1,58 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// canonicalHandler permanently redirects requests to the canonical form
+// of their URL, collapsing www and apex hosts into one and, when https
+// is set, plain http requests into https. The scheme of a request is
+// taken from X-Forwarded-Proto when a TLS-terminating proxy supplies it.
+type canonicalHandler struct {
+	www   string // "add" or "strip" a leading www. from the host, or ""
+	https bool
+	next  http.Handler
+}
+
+// WWWModes are the values of Config.WWW.
+var WWWModes = []string{"add", "strip"}
+
+// setWWW validates and stores the -www mode.
+func (h *canonicalHandler) setWWW(s string) error {
+	if !slices.Contains(WWWModes, s) {
+		return fmt.Errorf("invalid www mode %q, expected add or strip", s)
+	}
+	h.www = s
+	return nil
+}
+
+// ServeHTTP redirects r if its URL is not canonical and serves it otherwise.
+func (h canonicalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+
+	host, wantScheme := r.Host, scheme
+	switch {
+	case h.www == "strip" && strings.HasPrefix(strings.ToLower(host), "www."):
+		host = host[len("www."):]
+	case h.www == "add" && !strings.HasPrefix(strings.ToLower(host), "www."):
+		host = "www." + host
+	}
+	if h.https {
+		wantScheme = "https"
+	}
+
+	if host == r.Host && wantScheme == scheme {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	http.Redirect(w, r, wantScheme+"://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
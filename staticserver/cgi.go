@@ -0,0 +1,173 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CGIDirs maps a URL path prefix to the dir of the CGI scripts run for
+// it. It is populated by repeated -cgi flags of the form prefix=dir.
+type CGIDirs map[string]string
+
+// Set parses a single prefix=dir pair and adds it to the map.
+// It has the signature expected by flag.Func.
+func (c CGIDirs) Set(s string) error {
+	prefix, dir, ok := strings.Cut(s, "=")
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !ok || !strings.HasPrefix(prefix, "/") || dir == "" {
+		return fmt.Errorf("invalid cgi dir %q, expected /prefix=dir", s)
+	}
+	c[prefix] = dir
+	return nil
+}
+
+// cgiPath is the PATH scripts run with, unless the host's is inherited.
+const cgiPath = "/usr/local/bin:/usr/bin:/bin"
+
+// cgiWaitDelay is how long a script that has been killed, or a child it
+// left holding its output open, is waited for before giving up on it.
+const cgiWaitDelay = time.Second
+
+// cgiHandler runs the scripts in dir for the requests under prefix: the
+// first element of the path after prefix names the script, and the rest
+// is its PATH_INFO. Only executable files that are not dot files are
+// run. Scripts get the CGI variables, a fixed PATH and the variables of
+// the server's environment named in env, and never the Authorization
+// header, as Apache keeps it from them too. The output of a script is
+// sent as it comes, and a script still running after timeout is killed
+// along with the processes it started.
+type cgiHandler struct {
+	prefix  string
+	dir     string
+	env     []string
+	timeout time.Duration
+}
+
+// ServeHTTP runs the script r names.
+func (h cgiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, h.prefix+"/")
+	name, info, _ := strings.Cut(rest, "/")
+	if name == "" || name == ".." || strings.HasPrefix(name, ".") || rest == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if info != "" {
+		info = "/" + info
+	}
+	script := filepath.Join(h.dir, name)
+	fi, err := os.Stat(script)
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if fi.Mode().Perm()&0o111 == 0 {
+		debugf("cgi: %s is not executable", script)
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+	body := io.Reader(r.Body)
+	length := r.ContentLength
+	if length < 0 {
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, fcgiMaxBody))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, length = bytes.NewReader(b), int64(len(b))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = h.dir
+	cmd.Env = h.environ(r, script, h.prefix+"/"+name, info, length)
+	cmd.Stdin = body
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = cgiWaitDelay
+	killGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		infof("warning: cgi: %s: %v", script, err)
+		return
+	}
+	debugf("cgi: %s runs %s", r.URL.Path, script)
+	if err := cmd.Start(); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		infof("warning: cgi: %s: %v", script, err)
+		return
+	}
+	// A child the script left behind can hold its output open once it
+	// is killed, so the output is only read for so long after that.
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(cgiWaitDelay, func() { stdout.Close() })
+	})
+	defer stop()
+	started, err := writeCGIResponse(w, bufio.NewReader(stdout))
+	if !started {
+		if ctx.Err() == context.DeadlineExceeded {
+			http.Error(w, "The script took too long to respond.", http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		}
+	}
+	if err != nil && r.Context().Err() == nil {
+		infof("warning: cgi: %s: %v", script, err)
+	}
+	if err != nil {
+		// The rest of the output goes unread, so the script must not be
+		// left waiting to write it.
+		cancel()
+	}
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		infof("warning: cgi: %s: %v", script, err)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		infof("warning: cgi: %s killed after %s", script, h.timeout)
+	}
+}
+
+// environ returns the environment the script is run with for r, at the
+// URL path name with the path info after it and a body of length bytes.
+func (h cgiHandler) environ(r *http.Request, script, name, info string, length int64) []string {
+	vars := cgiVars(r, h.dir, script, name, info, length)
+	delete(vars, "HTTP_AUTHORIZATION")
+	vars["PATH"] = cgiPath
+	for _, k := range h.env {
+		if v, ok := os.LookupEnv(k); ok {
+			vars[k] = v
+		}
+	}
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// register mounts a handler on mux for every prefix in c, which kills
+// the scripts still running after timeout.
+func (c CGIDirs) register(mux *http.ServeMux, timeout time.Duration, env []string) error {
+	for prefix, dir := range c {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Stat(abs); err != nil {
+			return err
+		} else if !fi.IsDir() {
+			return fmt.Errorf("cgi dir %s is not a directory", dir)
+		}
+		mux.Handle(prefix+"/", cgiHandler{prefix: prefix, dir: abs, env: env, timeout: timeout})
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package staticserver
+
+import "os/exec"
+
+// killGroup leaves cmd to be killed on its own when its context is done,
+// as there are no process groups to kill it with.
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,113 @@
+//go:build unix
+
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cgiScript writes an executable shell script with the body given to
+// dir.
+func cgiScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCGIHandler(t *testing.T) {
+	dir := t.TempDir()
+	cgiScript(t, dir, "env", "printf 'Content-Type: text/plain\\n\\n'\necho \"$SCRIPT_NAME|$PATH_INFO|$QUERY_STRING|$HTTP_AUTHORIZATION|$PATH\"\n")
+	cgiScript(t, dir, "status", "printf 'Status: 418 Teapot\\n\\n'\n")
+	cgiScript(t, dir, "broken", "echo not a header\n")
+	cgiScript(t, dir, ".hidden", "printf '\\n'\n")
+	if err := os.WriteFile(filepath.Join(dir, "plain"), []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := cgiHandler{prefix: "/cgi-bin", dir: dir, timeout: 5 * time.Second}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/cgi-bin/env/a/b?x=1", http.StatusOK, "/cgi-bin/env|/a/b|x=1||" + cgiPath + "\n"},
+		{"/cgi-bin/status", 418, ""},
+		{"/cgi-bin/broken", http.StatusBadGateway, ""},
+		{"/cgi-bin/.hidden", http.StatusNotFound, ""},
+		{"/cgi-bin/plain", http.StatusForbidden, ""},
+		{"/cgi-bin/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Header.Set("Authorization", "Basic c2VjcmV0")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("GET %s: body %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestCGITimeout(t *testing.T) {
+	dir := t.TempDir()
+	pidfile := filepath.Join(dir, "child.pid")
+	// The script leaves a child behind that would outlive it.
+	cgiScript(t, dir, "slow", "sleep 60 &\necho $! > "+pidfile+"\nsleep 60\n")
+	h := cgiHandler{prefix: "/cgi-bin", dir: dir, timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/cgi-bin/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s to give up on the script", d)
+	}
+	b, err := os.ReadFile(pidfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strings.TrimSpace(string(b))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// A killed child that is not reaped yet is a zombie.
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child %s of the script is still running", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCGIStreams(t *testing.T) {
+	dir := t.TempDir()
+	cgiScript(t, dir, "stream", "printf 'Content-Type: text/plain\\n\\nfirst\\n'\nsleep 60\n")
+	h := cgiHandler{prefix: "/cgi-bin", dir: dir, timeout: 10 * time.Second}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(srv.URL + "/cgi-bin/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b := make([]byte, len("first\n"))
+	if _, err := resp.Body.Read(b); err != nil || string(b) != "first\n" {
+		t.Errorf("read %q, %v before the script ended, want %q", b, err, "first\n")
+	}
+}
@@ -0,0 +1,281 @@
+package staticserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// challengePath is where the answers to challenges are posted.
+	challengePath = "/_challenge"
+	// challengeCookie holds the proof that a client passed a challenge.
+	challengeCookie = "challenge"
+	// challengeTTL is how long a challenge may take to answer.
+	challengeTTL = 10 * time.Minute
+	// challengePass is how long a client that passed a challenge has its
+	// limit raised.
+	challengePass = time.Hour
+	// challengeRaise is how many times the limit a client that passed a
+	// challenge may have in flight.
+	challengeRaise = 4
+	// defaultChallengeBits is the difficulty of proofs of work: about
+	// 65,000 hashes, a fraction of a second in a browser.
+	defaultChallengeBits = 16
+)
+
+// challengeClient checks the responses to CAPTCHAs.
+var challengeClient = &http.Client{Timeout: 10 * time.Second}
+
+// challengePage is the proof-of-work challenge: a script finds the
+// proof whose SHA-256 with the nonce begins with Bits zero bits, and
+// posts it. The hash is written out since crypto.subtle is only there
+// for HTTPS pages.
+var challengePage = template.Must(template.New("challenge").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>One moment</title>
+<style>body { font-family: sans-serif; margin: 1em auto; max-width: 50em; padding: 0 1em; }</style>
+<h1>One moment</h1>
+{{if .Wrong}}<p>That did not work, trying again.</p>
+{{end}}<p id="status">Checking your browser before letting it in, which takes a second or two.</p>
+<noscript><p>This needs JavaScript. Or wait a while and try again.</p></noscript>
+<form method="post" action="{{.Action}}" id="challenge">
+<input type="hidden" name="return" value="{{.Return}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="proof">
+</form>
+<script>
+(function () {
+	var H = [], K = [], n = 2;
+	function frac(x) { return (x - Math.floor(x)) * 4294967296 | 0; }
+	for (var c = 2; K.length < 64; c++) {
+		var prime = true;
+		for (var d = 2; d * d <= c; d++) if (c % d == 0) prime = false;
+		if (!prime) continue;
+		if (H.length < 8) H.push(frac(Math.sqrt(c)));
+		K.push(frac(Math.cbrt(c)));
+	}
+	// sha256 returns the first word of the SHA-256 of the ASCII s.
+	function sha256(s) {
+		var m = [], h = H.slice(), w = [], i, j;
+		for (i = 0; i < s.length; i++) m.push(s.charCodeAt(i));
+		m.push(0x80);
+		while (m.length % 64 != 56) m.push(0);
+		m.push(0, 0, 0, 0, s.length >>> 21 & 255, s.length >>> 13 & 255, s.length >>> 5 & 255, s.length << 3 & 255);
+		for (j = 0; j < m.length; j += 64) {
+			for (i = 0; i < 64; i++) {
+				if (i < 16) {
+					w[i] = m[j + 4 * i] << 24 | m[j + 4 * i + 1] << 16 | m[j + 4 * i + 2] << 8 | m[j + 4 * i + 3];
+				} else {
+					var x = w[i - 15], y = w[i - 2];
+					w[i] = w[i - 16] + ((x >>> 7 | x << 25) ^ (x >>> 18 | x << 14) ^ x >>> 3) + w[i - 7] + ((y >>> 17 | y << 15) ^ (y >>> 19 | y << 13) ^ y >>> 10) | 0;
+				}
+			}
+			var a = h[0], b = h[1], c = h[2], d = h[3], e = h[4], f = h[5], g = h[6], k = h[7];
+			for (i = 0; i < 64; i++) {
+				var t1 = k + ((e >>> 6 | e << 26) ^ (e >>> 11 | e << 21) ^ (e >>> 25 | e << 7)) + (e & f ^ ~e & g) + K[i] + w[i] | 0;
+				var t2 = ((a >>> 2 | a << 30) ^ (a >>> 13 | a << 19) ^ (a >>> 22 | a << 10)) + (a & b ^ a & c ^ b & c) | 0;
+				k = g; g = f; f = e; e = d + t1 | 0; d = c; c = b; b = a; a = t1 + t2 | 0;
+			}
+			h = [h[0] + a | 0, h[1] + b | 0, h[2] + c | 0, h[3] + d | 0, h[4] + e | 0, h[5] + f | 0, h[6] + g | 0, h[7] + k | 0];
+		}
+		return h[0] >>> 0;
+	}
+	var form = document.getElementById("challenge"), nonce = form.nonce.value, limit = Math.pow(2, 32 - {{.Bits}}), proof = 0;
+	function work() {
+		for (var end = proof + 20000; proof < end; proof++) {
+			if (sha256(nonce + ":" + proof) < limit) {
+				form.proof.value = proof;
+				form.submit();
+				return;
+			}
+		}
+		setTimeout(work, 0);
+	}
+	work();
+})();
+</script>
+`))
+
+// challenge stands between the clients past a limit and a refusal: it
+// asks browsers for a proof of work, or with a page and a verify URL of
+// its own for a CAPTCHA, and raises the limit of those who answer it
+// challengeRaise times for challengePass with a signed cookie. Clients are told apart by
+// clientKey, so that a cookie is no use to another.
+type challenge struct {
+	key    []byte // the key nonces and cookies are signed with
+	bits   int    // the leading zero bits of proofs of work
+	page   *template.Template
+	verify string // the siteverify URL of the CAPTCHA, or "" for proofs of work
+	secret string // the secret key of the CAPTCHA
+}
+
+// newChallenge returns the challenge of cfg, with its page parsed now.
+func newChallenge(cfg Config) (*challenge, error) {
+	c := &challenge{key: make([]byte, 32), bits: cfg.ChallengeBits, page: challengePage, verify: cfg.ChallengeVerify, secret: cfg.ChallengeSecret}
+	rand.Read(c.key)
+	if c.bits == 0 {
+		c.bits = defaultChallengeBits
+	} else if c.bits < 1 || c.bits > 32 {
+		return nil, fmt.Errorf("challenge bits %d out of range, expected 1 to 32", c.bits)
+	}
+	if c.verify != "" {
+		if _, err := parseUpstream(c.verify); err != nil {
+			return nil, err
+		}
+		if cfg.ChallengePage == "" {
+			return nil, errors.New("a CAPTCHA verify URL requires a challenge page")
+		}
+	}
+	if cfg.ChallengePage != "" {
+		page, err := template.ParseFiles(cfg.ChallengePage)
+		if err != nil {
+			return nil, err
+		}
+		c.page = page
+	}
+	return c, nil
+}
+
+// sign returns the signature of parts.
+func (c *challenge) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// signed reports whether the value exp.rest.sig, or exp.sig if rest is
+// absent, is signed for the client key with kind and has not expired.
+func (c *challenge) signed(kind, value, key string) bool {
+	i, j := strings.IndexByte(value, '.'), strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return false
+	}
+	exp, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(value[j+1:]), []byte(c.sign(kind, value[:j], key)))
+}
+
+// passed reports whether the client of r answered a challenge.
+func (c *challenge) passed(r *http.Request) bool {
+	cookie, err := r.Cookie(challengeCookie)
+	return err == nil && c.signed("pass", cookie.Value, clientKey(clientIP(r)))
+}
+
+// nonce returns a new nonce for the proof of work of the client key.
+func (c *challenge) nonce(key string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	v := strconv.FormatInt(time.Now().Add(challengeTTL).Unix(), 10) + "." + hex.EncodeToString(b)
+	return v + "." + c.sign("nonce", v, key)
+}
+
+// ask answers r with the challenge, with status.
+func (c *challenge) ask(w http.ResponseWriter, r *http.Request, status int, wrong bool, ret string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := c.page.Execute(w, struct {
+		Action, Return, Nonce string
+		Bits                  int
+		Wrong                 bool
+	}{challengePath, ret, c.nonce(clientKey(clientIP(r))), c.bits, wrong})
+	if err != nil {
+		log.Println("challenge:", err)
+	}
+}
+
+// answer checks the answer to a challenge posted with r and, if it is
+// right, sets the cookie that lets its client past the limit and sends
+// it back to where it was going; if not, it asks again.
+func (c *challenge) answer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	ret := r.PostFormValue("return")
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.HasPrefix(ret, "/\\") {
+		ret = "/"
+	}
+	ip := clientIP(r)
+	key := clientKey(ip)
+	var ok bool
+	if c.verify != "" {
+		ok = c.checkCaptcha(r, ip)
+	} else {
+		nonce := r.PostFormValue("nonce")
+		sum := sha256.Sum256([]byte(nonce + ":" + r.PostFormValue("proof")))
+		ok = c.signed("nonce", nonce, key) && leadingZeros(sum[:]) >= c.bits
+	}
+	if !ok {
+		debugf("challenge: wrong answer from %s", ip)
+		c.ask(w, r, http.StatusForbidden, true, ret)
+		return
+	}
+	debugf("challenge: %s passed", ip)
+	v := strconv.FormatInt(time.Now().Add(challengePass).Unix(), 10)
+	http.SetCookie(w, &http.Cookie{Name: challengeCookie, Value: v + "." + c.sign("pass", v, key), Path: "/", MaxAge: int(challengePass.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, ret, http.StatusSeeOther)
+}
+
+// checkCaptcha reports whether the verify URL accepts the CAPTCHA
+// response posted with r by the client ip. The field is "response" or
+// the one the widgets of Turnstile, hCaptcha and reCAPTCHA post.
+func (c *challenge) checkCaptcha(r *http.Request, ip string) bool {
+	var response string
+	for _, field := range []string{"response", "cf-turnstile-response", "h-captcha-response", "g-recaptcha-response"} {
+		if response = r.PostFormValue(field); response != "" {
+			break
+		}
+	}
+	if response == "" {
+		return false
+	}
+	resp, err := challengeClient.PostForm(c.verify, url.Values{"secret": {c.secret}, "response": {response}, "remoteip": {ip}})
+	if err != nil {
+		log.Println("challenge:", err)
+		return false
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Printf("challenge: %s: %v", c.verify, err)
+		return false
+	}
+	return result.Success
+}
+
+// leadingZeros returns the number of leading zero bits of b.
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package staticserver
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// chaosHandler fails a share of the requests it serves, so clients'
+// retry and error handling can be tested against a local server.
+type chaosHandler struct {
+	rate  float64  // the share of requests to fail, from 0 to 1
+	modes []string // how to fail: a status code such as 503, or truncate
+	next  http.Handler
+}
+
+// ParseShare parses a share of requests, such as the -chaos rate,
+// given either as a fraction or a percentage.
+func ParseShare(s string) (float64, error) {
+	pct, isPct := strings.CutSuffix(s, "%")
+	v, err := strconv.ParseFloat(pct, 64)
+	if isPct {
+		v /= 100
+	}
+	if err != nil || v < 0 || v > 1 {
+		return 0, fmt.Errorf("invalid share %q, expected a percentage like 10%% or a fraction like 0.1", s)
+	}
+	return v, nil
+}
+
+// setModes validates and stores the -chaos-modes.
+func (h *chaosHandler) setModes(modes []string) error {
+	h.modes = nil
+	for _, m := range modes {
+		m = strings.TrimSpace(m)
+		if code, err := strconv.Atoi(m); m != "truncate" && (err != nil || code < 400 || code > 599) {
+			return fmt.Errorf("invalid chaos mode %q, expected an error status or truncate", m)
+		}
+		h.modes = append(h.modes, m)
+	}
+	return nil
+}
+
+// ServeHTTP serves r with h.next, or fails it.
+func (h chaosHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rand.Float64() >= h.rate {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	mode := h.modes[rand.N(len(h.modes))]
+	debugf("chaos: %s %s: %s", r.Method, r.URL.Path, mode)
+	if mode != "truncate" {
+		code, _ := strconv.Atoi(mode)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	h.next.ServeHTTP(&truncateWriter{ResponseWriter: w}, r)
+}
+
+// truncateWriter sends the headers of a response as they are, its body
+// up to a random point, and then drops the connection.
+type truncateWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	limit       int64 // bytes of body to send, once the headers are out
+	sent        int64
+}
+
+// WriteHeader sends the status and picks where to cut the body.
+func (w *truncateWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.wroteHeader = true
+		w.limit = 512
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && n > 0 {
+			w.limit = rand.Int64N(n)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends part of the body, aborting the response at the limit.
+func (w *truncateWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.sent+int64(len(b)) > w.limit {
+		n, _ := w.ResponseWriter.Write(b[:w.limit-w.sent])
+		w.sent += int64(n)
+		http.NewResponseController(w.ResponseWriter).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.sent += int64(n)
+	return n, err
+}
+
+// ReadFrom copies through Write, so the cut happens where it should.
+func (w *truncateWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, r)
+}
+
+// writerOnly hides every method of a writer but Write.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,125 @@
+package staticserver
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// checkDialTimeout is how long Check waits to connect to an upstream.
+const checkDialTimeout = 3 * time.Second
+
+// Check reports the problems a Server made from cfg would run into:
+// dirs and files it names that do not exist, upstreams that are not
+// URLs or cannot be connected to, commands that are not found, and
+// whatever New rejects, such as rules that do not compile. A Server is
+// made and closed again to find the last, so Check has the same side
+// effects as New. It returns nil if it finds none.
+func Check(cfg Config) []error {
+	var errs []error
+	report := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	isDir := func(what, dir string) {
+		if fi, err := os.Stat(dir); err != nil {
+			report("%s: %v", what, err)
+		} else if !fi.IsDir() {
+			report("%s: %s is not a dir", what, dir)
+		}
+	}
+	isFile := func(what, name string) {
+		if fi, err := os.Stat(name); err != nil {
+			report("%s: %v", what, err)
+		} else if fi.IsDir() {
+			report("%s: %s is a dir", what, name)
+		}
+	}
+	dial := func(what, network, addr string) {
+		c, err := net.DialTimeout(network, addr, checkDialTimeout)
+		if err != nil {
+			report("%s: %v", what, err)
+			return
+		}
+		c.Close()
+	}
+	upstream := func(what string, u *url.URL) {
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		}
+		dial(what, "tcp", net.JoinHostPort(u.Hostname(), port))
+	}
+
+	if cfg.FS == nil {
+		isDir("dir", cmp.Or(cfg.Dir, "."))
+	}
+	for _, host := range slices.Sorted(maps.Keys(cfg.VHosts)) {
+		isDir("vhost "+host, cfg.VHosts[host])
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(cfg.CGI)) {
+		isDir("cgi "+prefix, cfg.CGI[prefix])
+	}
+	for what, dir := range map[string]string{"user dirs": cfg.UserDirs, "upload": cfg.Upload, "webdav root": cfg.WebDAVRoot, "quarantine": cfg.Quarantine, "canary": cfg.Canary.Dir} {
+		if dir != "" {
+			isDir(what, dir)
+		}
+	}
+	for what, name := range map[string]string{"markdown template": cfg.MarkdownTemplate, "template data": cfg.TemplateData, "manifest": cfg.Manifest, "mtimes": cfg.Mtimes, "geoip": cfg.GeoIP, "maintenance page": cfg.MaintenancePage, "geo page": cfg.GeoPage} {
+		if name != "" {
+			isFile(what, name)
+		}
+	}
+	for _, name := range cfg.PerIPPages {
+		isFile("per-ip page", name)
+	}
+	for what, name := range map[string]string{"form save": cfg.FormSave, "hits": cfg.Hits, "ban file": cfg.BanFile} {
+		if name != "" {
+			isDir(what, filepath.Dir(name))
+		}
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(cfg.Proxies)) {
+		upstream("proxy "+prefix, cfg.Proxies[prefix])
+	}
+	for what, s := range map[string]string{
+		"fallback": cfg.Fallback, "mirror": cfg.Mirror, "form webhook": cfg.FormWebhook, "write webhook": cfg.WriteWebhook,
+		"scan url": cfg.ScanURL, "otlp endpoint": cfg.OTLPEndpoint, "alert webhook": cfg.Alert.Webhook, "shadow": cfg.Shadow,
+	} {
+		if s == "" {
+			continue
+		}
+		if u, err := parseUpstream(s); err != nil {
+			report("%s: %v", what, err)
+		} else {
+			upstream(what, u)
+		}
+	}
+	if cfg.PHP != "" {
+		if f, err := parseFastCGI(cfg.PHP); err != nil {
+			report("php: %v", err)
+		} else {
+			dial("php", f.network, f.addr)
+		}
+	}
+	if len(cfg.ScanCmd) > 0 {
+		if _, err := exec.LookPath(cfg.ScanCmd[0]); err != nil {
+			report("scan command: %v", err)
+		}
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		report("%v", err)
+	} else if err := s.Close(); err != nil {
+		report("%v", err)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
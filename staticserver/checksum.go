@@ -0,0 +1,197 @@
+package staticserver
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestAlgorithms are the algorithms files can be checksummed with, by
+// the names ?checksum= takes. The weak ones are there for checking old
+// downloads against the sums published for them.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// errNotFile is returned for a directory, which has no checksum.
+var errNotFile = errors.New("not a file")
+
+// maxDigests is how many files a digestCache holds the sums of.
+const maxDigests = 4096
+
+// digestCache holds the sums of the files of an FS, so that a file is
+// read to checksum it once until it changes.
+type digestCache struct {
+	max  int // how many sums it holds, or 0 to hold none
+	mu   sync.Mutex
+	sums map[digestKey][]byte
+}
+
+type digestKey struct {
+	name, algorithm string
+	modTime         time.Time
+	size            int64
+}
+
+func newDigestCache(max int) *digestCache {
+	return &digestCache{max: max, sums: map[digestKey][]byte{}}
+}
+
+// purge forgets every sum.
+func (c *digestCache) purge() {
+	c.mu.Lock()
+	clear(c.sums)
+	c.mu.Unlock()
+}
+
+// purgePaths forgets the sums of the files whose names match reports
+// true for.
+func (c *digestCache) purgePaths(match func(name string) bool) {
+	c.mu.Lock()
+	maps.DeleteFunc(c.sums, func(k digestKey, _ []byte) bool { return match(k.name) })
+	c.mu.Unlock()
+}
+
+// sum returns the digest with algorithm of the file name in fs.
+func (c *digestCache) sum(fs http.FileSystem, name, algorithm string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, errNotFile
+	}
+	key := digestKey{name, algorithm, fi.ModTime(), fi.Size()}
+	c.mu.Lock()
+	sum, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	h := digestAlgorithms[algorithm]()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum = h.Sum(nil)
+	if c.max == 0 {
+		return sum, nil
+	}
+	c.mu.Lock()
+	if len(c.sums) >= c.max {
+		// Start over rather than track which sums are used.
+		clear(c.sums)
+	}
+	c.sums[key] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// checksumHandler answers requests for ?checksum=algorithm with the sum
+// of the file in the format sha256sum and its kin print, which they can
+// check with -c, and passes every other request to next.
+type checksumHandler struct {
+	fs   http.FileSystem
+	sums *digestCache
+	next http.Handler
+}
+
+// ServeHTTP answers r with a checksum, or serves it with next.
+func (h checksumHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	algorithm := strings.ToLower(r.URL.Query().Get("checksum"))
+	if algorithm == "" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if digestAlgorithms[algorithm] == nil {
+		names := make([]string, 0, len(digestAlgorithms))
+		for name := range digestAlgorithms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		http.Error(w, fmt.Sprintf("unknown checksum algorithm %q, expected one of %s", algorithm, strings.Join(names, ", ")), http.StatusBadRequest)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	sum, err := h.sums.sum(h.fs, name, algorithm)
+	switch {
+	case errors.Is(err, errNotFile):
+		http.Error(w, "a directory has no checksum", http.StatusBadRequest)
+		return
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		infof("warning: checksum: %s: %v", name, err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum), path.Base(name))
+}
+
+// digestHandler adds the Repr-Digest header of RFC 9530 and the older
+// Digest header of RFC 3230 to the files next serves, with the SHA-256
+// of each file, or the SHA-512 as well if the client asks for it with
+// Want-Repr-Digest or Want-Digest.
+type digestHandler struct {
+	fs   http.FileSystem
+	sums *digestCache
+	next http.Handler
+}
+
+// ServeHTTP adds the digest headers of the file of r and serves it with
+// next.
+func (h digestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		name := path.Clean("/" + r.URL.Path)
+		want := strings.ToLower(r.Header.Get("Want-Repr-Digest") + "," + r.Header.Get("Want-Digest"))
+		var repr, legacy []string
+		for _, a := range []struct{ algorithm, field, legacy string }{
+			{"sha256", "sha-256", "SHA-256"},
+			{"sha512", "sha-512", "SHA-512"},
+		} {
+			if a.algorithm == "sha512" && !strings.Contains(want, "sha-512") {
+				continue
+			}
+			sum, err := h.sums.sum(h.fs, name, a.algorithm)
+			if err != nil {
+				break
+			}
+			b64 := base64.StdEncoding.EncodeToString(sum)
+			repr = append(repr, a.field+"=:"+b64+":")
+			legacy = append(legacy, a.legacy+"="+b64)
+		}
+		if len(repr) > 0 {
+			w.Header().Set("Repr-Digest", strings.Join(repr, ", "))
+			w.Header().Set("Digest", strings.Join(legacy, ","))
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
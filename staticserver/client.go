@@ -0,0 +1,32 @@
+package staticserver
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// clientIP returns the IP address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// anonymizeIP zeroes the host part of ip: the last octet of an IPv4
+// address or all but the /64 network of an IPv6 one. Anything that is
+// not an IP address is returned unchanged.
+func anonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	bits := 64
+	if addr.Is4() || addr.Is4In6() {
+		addr, bits = addr.Unmap(), 24
+	}
+	p, _ := addr.Prefix(bits)
+	return p.Addr().String()
+}
@@ -0,0 +1,115 @@
+package staticserver
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// codeViewPage is the page source files are shown in, given the Path of
+// the file, the Raw URL of the file as it is, its line Numbers and its
+// highlighted Code.
+var codeViewPage = template.Must(template.New("code").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+header { background: #f4f4f4; border-bottom: 1px solid #ddd; padding: .5em 1em; }
+#raw { float: right; }
+table { border-collapse: collapse; } td { padding: 0; vertical-align: top; }
+pre { font-size: .9em; line-height: 1.4; margin: 0; padding: .5em 1em; }
+.ln pre { color: #999; text-align: right; user-select: none; } .ln a { color: inherit; text-decoration: none; }
+.ln a:target { background: #ffc; color: #000; }
+.kw { color: #a626a4; } .str { color: #50a14f; } .com { color: #a0a1a7; font-style: italic; } .num { color: #986801; }
+</style>
+<header><a id="raw" href="{{.Raw}}">raw</a>{{.Path}}</header>
+<table><tr><td class="ln"><pre>{{.Numbers}}</pre></td><td><pre><code>{{.Code}}</code></pre></td></tr></table>`))
+
+// maxCodeView is the size of the largest file shown highlighted; larger
+// ones, such as minified scripts, are served as they are.
+const maxCodeView = 1 << 20
+
+// codeViewHandler shows the source files of the languages highlight
+// knows as HTML pages with line numbers to browsers, and passes every
+// other request, and those asking for the raw file with ?raw=1, to
+// next. Only requests that accept text/html are shown a page, so that
+// scripts and data that pages load are served as they are.
+type codeViewHandler struct {
+	fs   http.FileSystem
+	next http.Handler
+}
+
+// codeLang returns the language of the file name for highlight, or ""
+// if it is not one highlight knows.
+func codeLang(name string) string {
+	lang := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if alias, ok := mdLangAliases[lang]; ok {
+		lang = alias
+	}
+	if mdLangs[lang] == nil {
+		return ""
+	}
+	return lang
+}
+
+// ServeHTTP shows the requested source file or serves r with next.
+func (h codeViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	lang := codeLang(name)
+	if lang != "" {
+		w.Header().Add("Vary", "Accept")
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || lang == "" || r.URL.Query().Get("raw") == "1" ||
+		!strings.Contains(r.Header.Get("Accept"), "text/html") {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() || fi.Size() > maxCodeView {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	src, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	if bytes.IndexByte(src, 0) >= 0 {
+		// Not text, whatever its name says.
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	code := strings.ReplaceAll(strings.TrimSuffix(string(src), "\n"), "\r\n", "\n")
+	var numbers, b strings.Builder
+	for n := 1; n <= strings.Count(code, "\n")+1; n++ {
+		fmt.Fprintf(&numbers, "<a id=\"L%d\" href=\"#L%[1]d\">%[1]d</a>\n", n)
+	}
+	highlight(&b, code, lang)
+	raw := *r.URL
+	q := raw.Query()
+	q.Set("raw", "1")
+	raw.RawQuery = q.Encode()
+	var out bytes.Buffer
+	err = codeViewPage.Execute(&out, struct {
+		Path, Raw     string
+		Numbers, Code template.HTML
+	}{name, raw.RequestURI(), template.HTML(numbers.String()), template.HTML(b.String())})
+	if err != nil {
+		infof("warning: code view: %s: %v", name, err)
+		http.Error(w, "Error rendering file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(out.Bytes()))
+}
@@ -0,0 +1,26 @@
+package staticserver
+
+import "net/http"
+
+// coiHandler makes the pages it serves cross-origin isolated, which
+// browsers require before they give a page SharedArrayBuffer, and so
+// WebAssembly threads: pages open no window of another origin and load
+// only the resources that allow it, which the files it serves do for
+// the pages of their own origin, or of any with cors.
+type coiHandler struct {
+	cors bool // the files may be loaded by other origins, as with Dev
+	next http.Handler
+}
+
+// ServeHTTP serves r with h.next with the isolation headers set.
+func (h coiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	hdr.Set("Cross-Origin-Opener-Policy", "same-origin")
+	hdr.Set("Cross-Origin-Embedder-Policy", "require-corp")
+	if h.cors {
+		hdr.Set("Cross-Origin-Resource-Policy", "cross-origin")
+	} else {
+		hdr.Set("Cross-Origin-Resource-Policy", "same-origin")
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,232 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dashboardPage is the /_stats page. It renders the snapshots streamed
+// from the events URL.
+var dashboardPage = template.Must(template.New("stats").Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>static-server stats</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+td, th { padding: .2em .5em; text-align: left; } td.n, th.n { text-align: right; white-space: nowrap; }
+.big { font-size: 2em; margin-right: 1em; } .err { color: #a00; }
+</style>
+<h1>static-server</h1>
+<p><span class="big" id="rate">-</span>requests/s
+<p>Total <b id="total">-</b>, client errors <b id="e4" class="err">-</b>, server errors <b id="e5" class="err">-</b>, up <b id="uptime">-</b>
+<h2>Top files</h2>
+<table id="top"></table>
+<h2>Recent requests</h2>
+<table id="recent"></table>
+<script>
+(function() {
+	function cell(row, text, cls) {
+		var td = row.insertCell();
+		td.textContent = text;
+		if (cls) { td.className = cls; }
+	}
+	new EventSource({{.}}).onmessage = function(e) {
+		var s = JSON.parse(e.data), top = document.getElementById("top"), recent = document.getElementById("recent");
+		document.getElementById("rate").textContent = s.rate.toFixed(1);
+		document.getElementById("total").textContent = s.requests;
+		document.getElementById("e4").textContent = s.client_errors;
+		document.getElementById("e5").textContent = s.server_errors;
+		document.getElementById("uptime").textContent = s.uptime;
+		top.innerHTML = "";
+		s.top.forEach(function(t) { var r = top.insertRow(); cell(r, t.path); cell(r, t.hits, "n"); });
+		recent.innerHTML = "";
+		s.recent.forEach(function(q) {
+			var r = recent.insertRow();
+			cell(r, q.time); cell(r, q.ip); cell(r, q.method + " " + q.path);
+			cell(r, q.status, q.status >= 400 ? "n err" : "n"); cell(r, q.bytes, "n"); cell(r, q.ms + "ms", "n");
+		});
+	};
+})();
+</script>
+`))
+
+// dashboardRecent is how many recent requests the dashboard shows.
+const dashboardRecent = 25
+
+// dashboard serves a small live statistics page for people who would
+// rather not set up a monitoring stack.
+type dashboard struct {
+	prefix string
+	auth   Accounts
+	start  time.Time
+	done   chan struct{} // closed when the server shuts down
+
+	mu           sync.Mutex
+	requests     int
+	clientErrors int
+	serverErrors int
+	seconds      [10]int   // requests per second, ring indexed by Unix time
+	secondAt     [10]int64 // the Unix time each slot of seconds counts
+	recent       []dashboardRequest
+	paths        map[string]int
+}
+
+// dashboardRequest is a recent request, as sent to the page.
+type dashboardRequest struct {
+	Time   string `json:"time"`
+	IP     string `json:"ip"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	Bytes  int64  `json:"bytes"`
+	MS     int64  `json:"ms"`
+}
+
+// dashboardSnapshot is the state of the dashboard sent in each event.
+type dashboardSnapshot struct {
+	Rate         float64            `json:"rate"`
+	Requests     int                `json:"requests"`
+	ClientErrors int                `json:"client_errors"`
+	ServerErrors int                `json:"server_errors"`
+	Uptime       string             `json:"uptime"`
+	Top          []dashboardPath    `json:"top"`
+	Recent       []dashboardRequest `json:"recent"`
+}
+
+type dashboardPath struct {
+	Path string `json:"path"`
+	Hits int    `json:"hits"`
+}
+
+// newDashboard returns a dashboard served under prefix to the accounts.
+func newDashboard(prefix string, auth Accounts) *dashboard {
+	return &dashboard{prefix: prefix, auth: auth, start: time.Now(), done: make(chan struct{}), paths: map[string]int{}}
+}
+
+// observe counts rr. It is an observer for observeHandler. Requests for
+// the dashboard itself are not counted.
+func (d *dashboard) observe(rr *requestRecord) {
+	if rr.Path == d.prefix || rr.Path == d.prefix+"/events" {
+		return
+	}
+	now := rr.Start.Add(rr.Duration).Unix()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	switch {
+	case rr.Status >= 500:
+		d.serverErrors++
+	case rr.Status >= 400:
+		d.clientErrors++
+	}
+	i := now % int64(len(d.seconds))
+	if d.secondAt[i] != now {
+		d.secondAt[i], d.seconds[i] = now, 0
+	}
+	d.seconds[i]++
+	if _, ok := d.paths[rr.Path]; ok || len(d.paths) < maxSummaryPaths {
+		d.paths[rr.Path]++
+	}
+	d.recent = append(d.recent, dashboardRequest{
+		Time:   rr.Start.Format("15:04:05"),
+		IP:     rr.IP,
+		Method: rr.Method,
+		Path:   rr.Path,
+		Status: rr.Status,
+		Bytes:  rr.Bytes,
+		MS:     rr.Duration.Milliseconds(),
+	})
+	if len(d.recent) > dashboardRecent {
+		d.recent = d.recent[len(d.recent)-dashboardRecent:]
+	}
+}
+
+// snapshot returns the current state of the dashboard.
+func (d *dashboard) snapshot() dashboardSnapshot {
+	now := time.Now().Unix()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := dashboardSnapshot{
+		Requests:     d.requests,
+		ClientErrors: d.clientErrors,
+		ServerErrors: d.serverErrors,
+		Uptime:       time.Since(d.start).Round(time.Second).String(),
+		Recent:       make([]dashboardRequest, 0, len(d.recent)),
+		Top:          make([]dashboardPath, 0, len(d.paths)),
+	}
+	// The rate is over the last complete seconds, not the one under way.
+	for i, at := range d.secondAt {
+		if at < now && at >= now-int64(len(d.seconds)-1) {
+			s.Rate += float64(d.seconds[i])
+		}
+	}
+	s.Rate /= float64(len(d.seconds) - 1)
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		s.Recent = append(s.Recent, d.recent[i])
+	}
+	for p, n := range d.paths {
+		s.Top = append(s.Top, dashboardPath{p, n})
+	}
+	sort.Slice(s.Top, func(i, j int) bool {
+		if s.Top[i].Hits != s.Top[j].Hits {
+			return s.Top[i].Hits > s.Top[j].Hits
+		}
+		return s.Top[i].Path < s.Top[j].Path
+	})
+	if len(s.Top) > 10 {
+		s.Top = s.Top[:10]
+	}
+	return s
+}
+
+// ServeHTTP serves the page at d.prefix and its event stream under it.
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !d.auth.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.URL.Path {
+	case d.prefix:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		dashboardPage.Execute(w, d.prefix+"/events")
+	case d.prefix + "/events":
+		d.events(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// close ends the event streams, so they do not hold up a shutdown.
+func (d *dashboard) close() {
+	close(d.done)
+}
+
+// events streams a snapshot every second as server-sent events until
+// the client goes away.
+func (d *dashboard) events(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		b, _ := json.Marshal(d.snapshot())
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.done:
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package staticserver
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// deadlineHandler serves requests with next, aborting each one that is
+// still being served after limit: its context is canceled, reads of its
+// body and writes of its response fail from then on, and once next returns
+// the connection is reset rather than the response ended. A handler
+// that neither watches its context nor reads or writes cannot be stopped,
+// but is logged when it returns. Event streams, the responses sent as
+// text/event-stream, are meant to stay open and are left alone once they
+// start.
+type deadlineHandler struct {
+	limit time.Duration
+	next  http.Handler
+}
+
+// ServeHTTP serves r with h.next within h.limit.
+func (h deadlineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	deadline := start.Add(h.limit)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var expired atomic.Bool
+	timer := time.AfterFunc(h.limit, func() {
+		expired.Store(true)
+		cancel()
+	})
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+	dw := &deadlineWriter{ResponseWriter: w, lift: func() {
+		if timer.Stop() {
+			rc.SetReadDeadline(time.Time{})
+			rc.SetWriteDeadline(time.Time{})
+		}
+	}}
+	h.next.ServeHTTP(dw, r.WithContext(ctx))
+	timer.Stop()
+	if !expired.Load() {
+		// The server does not clear a write deadline between requests.
+		rc.SetWriteDeadline(time.Time{})
+		return
+	}
+	log.Printf("deadline: %s %s from %s aborted after %s, over the limit of %s",
+		r.Method, r.URL.Path, clientIP(r), time.Since(start).Round(time.Millisecond), h.limit)
+	if conn, _, err := rc.Hijack(); err == nil {
+		resetConn(conn)
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// deadlineWriter calls lift when the response it starts is an event
+// stream.
+type deadlineWriter struct {
+	http.ResponseWriter
+	lift    func()
+	started bool
+}
+
+// start calls w.lift once if the response is sent as text/event-stream.
+func (w *deadlineWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if t, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); t == "text/event-stream" {
+		w.lift()
+	}
+}
+
+// WriteHeader sends the response header.
+func (w *deadlineWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.start()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends part of the response body.
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom sends the response body from r, keeping sendfile available.
+func (w *deadlineWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.start()
+	return readFrom(w.ResponseWriter, r)
+}
+
+// Flush sends what is buffered of the response.
+func (w *deadlineWriter) Flush() {
+	w.start()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// resetConn closes conn with a reset, discarding what is still queued
+// to be sent rather than letting the client drain it.
+func resetConn(conn net.Conn) {
+	c := conn
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -0,0 +1,67 @@
+package staticserver
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Delays maps path prefixes to how long to hold back responses for
+// requests under them, so loading states can be tested locally.
+type Delays map[string]time.Duration
+
+// Set parses a delay of the form "duration" for every request or
+// "/prefix=duration" for those under prefix, and adds it.
+// It has the signature expected by flag.Func.
+func (d Delays) Set(s string) error {
+	prefix, v, ok := strings.Cut(s, "=")
+	if !ok {
+		prefix, v = "/", s
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil || dur < 0 || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("invalid delay %q, expected duration or /prefix=duration", s)
+	}
+	d[prefix] = dur
+	return nil
+}
+
+// match returns the delay with the longest prefix matching p.
+func (d Delays) match(p string) time.Duration {
+	best, dur := "", time.Duration(0)
+	for prefix, v := range d {
+		if strings.HasPrefix(p, prefix) && len(prefix) > len(best) {
+			best, dur = prefix, v
+		}
+	}
+	return dur
+}
+
+// delayHandler waits the delay matching each request, plus up to
+// jitter more at random, before serving it with next.
+type delayHandler struct {
+	delays Delays
+	jitter time.Duration
+	next   http.Handler
+}
+
+// ServeHTTP serves r with h.next after its delay, unless the client
+// gives up first.
+func (h delayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := h.delays.match(r.URL.Path)
+	if h.jitter > 0 {
+		d += rand.N(h.jitter)
+	}
+	if d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,31 @@
+package staticserver
+
+import "net/http"
+
+// devHandler makes the server predictable while a frontend is being
+// worked on: any origin may fetch from it, preflight requests are
+// answered, and nothing is cached or revalidated.
+type devHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP serves r with h.next with CORS open and caching off.
+func (h devHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	hdr.Set("Access-Control-Allow-Origin", "*")
+	hdr.Set("Access-Control-Expose-Headers", "*")
+	hdr.Set("Cache-Control", "no-store")
+	if r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		hdr.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+		if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+			hdr.Set("Access-Control-Allow-Headers", h)
+		}
+		hdr.Set("Access-Control-Max-Age", "0")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Always send the whole file, never a 304.
+	r.Header.Del("If-Modified-Since")
+	r.Header.Del("If-None-Match")
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,417 @@
+package staticserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirConfigName is the name of the per-dir config files, which set the
+// listing, index names, headers and accounts of their dir and the dirs
+// under it. It is a TOML file such as
+//
+//	listing = false
+//	index = ["index.htm", "README.md"]
+//	auth = ["alice:sha256:..."]
+//
+//	[headers]
+//	Cache-Control = "no-cache"
+//
+// A file in a dir under another overrides the keys it sets and adds to
+// the headers; auth = [] lets anyone in again.
+const DirConfigName = ".staticserver"
+
+// dirConfigCheck is how often a dir's config file is looked at again.
+const dirConfigCheck = time.Second
+
+// dirConfig is what the config files of a dir and the dirs above it
+// set. A nil field is not set.
+type dirConfig struct {
+	listing *bool             // whether dirs with no index are listed
+	index   []string          // the names of the files served for a dir, tried before index.html
+	headers map[string]string // set on every response
+	auth    Accounts          // the accounts allowed in, or anyone if empty
+}
+
+// dirConfigTypes describe the values of the keys of config files.
+var dirConfigTypes = map[string]string{
+	"listing": "true or false",
+	"index":   "an array of file names",
+	"headers": "a table of strings",
+	"auth":    "an array of user:password strings",
+}
+
+// parseDirConfig parses the config file name.
+func parseDirConfig(name string, b []byte) (*dirConfig, error) {
+	v, err := parseTOML(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	c := &dirConfig{}
+	for key, val := range v {
+		err := fmt.Errorf("%s: %s: expected %s", name, key, dirConfigTypes[key])
+		switch key {
+		case "listing":
+			b, ok := val.(bool)
+			if !ok {
+				return nil, err
+			}
+			c.listing = &b
+		case "index":
+			names, ok := stringList(val)
+			if !ok {
+				return nil, err
+			}
+			for _, n := range names {
+				if n == "" || strings.ContainsRune(n, '/') || strings.HasPrefix(n, ".") {
+					return nil, fmt.Errorf("%s: index: invalid file name %q", name, n)
+				}
+			}
+			c.index = names
+		case "headers":
+			t, ok := val.(map[string]any)
+			if !ok {
+				return nil, err
+			}
+			c.headers = map[string]string{}
+			for k, hv := range t {
+				s, ok := hv.(string)
+				if !ok {
+					return nil, err
+				}
+				c.headers[http.CanonicalHeaderKey(k)] = s
+			}
+		case "auth":
+			accounts, ok := stringList(val)
+			if !ok {
+				return nil, err
+			}
+			c.auth = Accounts{}
+			for _, a := range accounts {
+				if err := c.auth.Set(a); err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown key %q, expected listing, index, headers or auth", name, key)
+		}
+	}
+	return c, nil
+}
+
+// stringList returns v as a []string if it is an array of strings.
+func stringList(v any) ([]string, bool) {
+	a, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	s := make([]string, len(a))
+	for i, e := range a {
+		if s[i], ok = e.(string); !ok {
+			return nil, false
+		}
+	}
+	return s, true
+}
+
+// merge returns c with the keys set by child overriding its own.
+func (c dirConfig) merge(child *dirConfig) dirConfig {
+	if child.listing != nil {
+		c.listing = child.listing
+	}
+	if child.index != nil {
+		c.index = child.index
+	}
+	if child.headers != nil {
+		h := maps.Clone(c.headers)
+		if h == nil {
+			h = map[string]string{}
+		}
+		maps.Copy(h, child.headers)
+		c.headers = h
+	}
+	if child.auth != nil {
+		c.auth = child.auth
+	}
+	return c
+}
+
+// dirConfigs reads the config files of the dirs of an FS, keeping what
+// each holds for dirConfigCheck before looking at it again.
+type dirConfigs struct {
+	fsys http.FileSystem
+	mu   sync.Mutex
+	m    map[string]*dirConfigEntry
+}
+
+type dirConfigEntry struct {
+	checked time.Time
+	modTime time.Time
+	size    int64
+	config  *dirConfig // nil if the dir has none
+	err     error
+}
+
+// purge forgets what every file held, so that they are read again.
+func (d *dirConfigs) purge() {
+	d.mu.Lock()
+	clear(d.m)
+	d.mu.Unlock()
+}
+
+// get returns the config of the file in the dir dir, or nil.
+func (d *dirConfigs) get(dir string) (*dirConfig, error) {
+	d.mu.Lock()
+	e := d.m[dir]
+	d.mu.Unlock()
+	if e != nil && time.Since(e.checked) < dirConfigCheck {
+		return e.config, e.err
+	}
+	next := &dirConfigEntry{checked: time.Now()}
+	name := path.Join(dir, DirConfigName)
+	if f, err := d.fsys.Open(name); err == nil {
+		fi, err := f.Stat()
+		switch {
+		case err != nil:
+			next.err = err
+		case fi.IsDir():
+		case e != nil && e.config != nil && fi.ModTime().Equal(e.modTime) && fi.Size() == e.size:
+			next.modTime, next.size, next.config = e.modTime, e.size, e.config
+		default:
+			next.modTime, next.size = fi.ModTime(), fi.Size()
+			b, err := io.ReadAll(f)
+			if err == nil {
+				next.config, err = parseDirConfig(name, b)
+			}
+			if next.err = err; err == nil {
+				debugf("dirconfig: read %s", name)
+			}
+		}
+		f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		next.err = err
+	}
+	d.mu.Lock()
+	d.m[dir] = next
+	d.mu.Unlock()
+	return next.config, next.err
+}
+
+// dirsOf returns the dir dir and the dirs it is in, from the root down.
+func dirsOf(dir string) []string {
+	dirs := []string{"/"}
+	if dir != "/" {
+		p := ""
+		for _, elem := range strings.Split(dir[1:], "/") {
+			p += "/" + elem
+			dirs = append(dirs, p)
+		}
+	}
+	return dirs
+}
+
+// lookup returns the config of the file or dir name, merged from the
+// config files of its dirs from the root down.
+func (d *dirConfigs) lookup(name string, isDir bool) (dirConfig, error) {
+	dir := name
+	if !isDir {
+		dir = path.Dir(name)
+	}
+	var c dirConfig
+	for _, p := range dirsOf(dir) {
+		child, err := d.get(p)
+		if err != nil {
+			return c, err
+		}
+		if child != nil {
+			c = c.merge(child)
+		}
+	}
+	return c, nil
+}
+
+// dirFileError refuses a request whose dir has a name file that could
+// not be read, logged under prefix: with 403 Forbidden if the dir may
+// not be opened, such as one a symlink out of a site leads to, and with
+// 500 Internal Server Error otherwise.
+func dirFileError(w http.ResponseWriter, prefix, name string, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		debugf("%s: %v", prefix, err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	log.Printf("%s: %v", prefix, err)
+	http.Error(w, "Error reading "+name, http.StatusInternalServerError)
+}
+
+// dirConfigHandler applies the config files of the dirs of an FS to the
+// requests for their files: it asks for the accounts they name, sets
+// their headers, serves the first of their index names that exists for
+// a dir and refuses to list a dir they turn listing off for. A file
+// that cannot be parsed fails the requests under it rather than leaving
+// them open. Share links are let past the accounts. Paths that -try
+// rules and aliases map into a dir are served as the dir configures the
+// path asked for.
+type dirConfigHandler struct {
+	configs *dirConfigs
+	next    http.Handler
+}
+
+// newDirConfigs returns the config files of the dirs of fsys, which must
+// not hide dot files.
+func newDirConfigs(fsys http.FileSystem) *dirConfigs {
+	return &dirConfigs{fsys: fsys, m: map[string]*dirConfigEntry{}}
+}
+
+// ServeHTTP serves r as the config of its dir says, with next.
+func (h dirConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	isDir := false
+	if f, err := h.configs.fsys.Open(name); err == nil {
+		if fi, err := f.Stat(); err == nil {
+			isDir = fi.IsDir()
+		}
+		f.Close()
+	}
+	c, err := h.configs.lookup(name, isDir)
+	if err != nil {
+		dirFileError(w, "dirconfig", DirConfigName, err)
+		return
+	}
+	if len(c.auth) > 0 && !isShared(r) && !c.auth.authorize(w, r) {
+		return
+	}
+	for k, v := range c.headers {
+		w.Header().Set(k, v)
+	}
+	if isDir && strings.HasSuffix(r.URL.Path, "/") {
+		for _, index := range c.index {
+			f, err := h.configs.fsys.Open(path.Join(name, index))
+			if err != nil {
+				continue
+			}
+			fi, err := f.Stat()
+			f.Close()
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			if index == "index.html" {
+				break // the file server serves it
+			}
+			debugf("dirconfig: %s: serving %s", name, index)
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = path.Join(name, index)
+			h.next.ServeHTTP(w, r2)
+			return
+		}
+		if c.listing != nil && !*c.listing && isListing(h.configs.fsys, r) {
+			debugf("dirconfig: %s: not listing", name)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// guardFS is an FS as the endpoints that walk it see it, without what
+// the config and password files of its dirs keep from those who are not
+// asked for an account or a password: the dirs they protect are left
+// out, as if they did not exist, and the dirs config files turn listing
+// off for cannot be read. Only what is asked for below root is kept
+// to, as what root and the dirs above it ask for was given before the
+// walk.
+type guardFS struct {
+	http.FileSystem
+	configs   *dirConfigs   // the config files of the dirs, if they apply
+	passwords *dirPasswords // the password files of the dirs, if they apply
+	root      string        // the dir walked by a request let into it, or "" for anyone
+}
+
+// hidden reports whether the dir dir is only served with an account or
+// a password asked for below g.root. A file that cannot be read hides
+// it.
+func (g guardFS) hidden(dir string) bool {
+	var auth Accounts
+	below, setBelow := g.root == "", false
+	for _, p := range dirsOf(dir) {
+		if g.configs != nil {
+			c, err := g.configs.get(p)
+			if err != nil {
+				return true
+			}
+			if c != nil && c.auth != nil {
+				auth, setBelow = c.auth, below
+			}
+		}
+		if g.passwords != nil && below {
+			if hash, err := g.passwords.get(p); err != nil || hash != "" {
+				return true
+			}
+		}
+		if p == g.root {
+			below = true
+		}
+	}
+	return len(auth) > 0 && setBelow
+}
+
+// listed reports whether the entries of the dir dir may be listed.
+func (g guardFS) listed(dir string) bool {
+	if g.configs == nil {
+		return true
+	}
+	c, err := g.configs.lookup(dir, true)
+	return err == nil && (c.listing == nil || *c.listing)
+}
+
+// Open opens name, as if it did not exist if it is in a hidden dir.
+func (g guardFS) Open(name string) (http.File, error) {
+	f, err := g.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := path.Clean("/" + name)
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		dir = path.Dir(dir)
+	}
+	if g.hidden(dir) {
+		f.Close()
+		debugf("dirconfig: hiding %s", name)
+		return nil, fs.ErrNotExist
+	}
+	return guardF{File: f, fs: g, dir: path.Clean("/" + name)}, nil
+}
+
+// guardF is a file of a guardFS, whose dir listings leave out the dirs
+// it hides.
+type guardF struct {
+	http.File
+	fs  guardFS
+	dir string
+}
+
+// Readdir lists the dir but for the dirs it hides, unless its entries
+// may not be listed.
+func (f guardF) Readdir(n int) ([]fs.FileInfo, error) {
+	if !f.fs.listed(f.dir) {
+		debugf("dirconfig: %s: not listing", f.dir)
+		return nil, fs.ErrPermission
+	}
+	fis, err := f.File.Readdir(n)
+	shown := fis[:0]
+	for _, fi := range fis {
+		if fi.IsDir() && f.fs.hidden(path.Join(f.dir, fi.Name())) {
+			continue
+		}
+		shown = append(shown, fi)
+	}
+	return shown, err
+}
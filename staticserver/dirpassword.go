@@ -0,0 +1,294 @@
+package staticserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirPasswordName is the name of the files that protect their dir and
+// the dirs under it with a password. The first line of one is a bcrypt
+// hash, such as hash-password or htpasswd -nB prints, with or
+// without the user: htpasswd puts first.
+const DirPasswordName = ".password"
+
+// The wrong passwords a client may give in a window before it is told
+// to wait for the window to end, and the most clients counted.
+const (
+	dirPasswordTries   = 5
+	dirPasswordWindow  = time.Minute
+	dirPasswordClients = 10000
+)
+
+// dirPasswordPage asks for the password of a dir.
+var dirPasswordPage = template.Must(template.New("password").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Password required</title>
+<style>body { font-family: sans-serif; margin: 1em auto; max-width: 50em; padding: 0 1em; }</style>
+<h1>Password required</h1>
+{{if .Wrong}}<p>That password is not the right one.</p>
+{{end}}<form method="post">
+<input type="password" name="password" autofocus required>
+<button>Enter</button>
+</form>
+`))
+
+// dirPasswords reads the password files of the dirs of an FS, keeping
+// what each holds for dirConfigCheck before looking at it again.
+type dirPasswords struct {
+	*dirPasswordKeys
+	fsys http.FileSystem
+	mu   sync.Mutex
+	m    map[string]*dirPasswordEntry
+}
+
+// dirPasswordKeys is what the password files of the FSs of a server
+// share: the key their session cookies are signed with, so that a
+// password given for a dir holds at every endpoint that serves it, and
+// the wrong passwords of each client, so that it has one count of them.
+type dirPasswordKeys struct {
+	secret []byte
+	mu     sync.Mutex
+	wrong  map[string]*wrongPasswords // by clientKey
+}
+
+// newDirPasswordKeys returns the keys of a server, with a new secret.
+func newDirPasswordKeys() *dirPasswordKeys {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &dirPasswordKeys{secret: secret, wrong: map[string]*wrongPasswords{}}
+}
+
+// wrongPasswords counts the wrong passwords of a client in the window
+// that began with the first.
+type wrongPasswords struct {
+	start time.Time
+	n     int
+}
+
+// newDirPasswords returns the password files of the dirs of fsys, which
+// must not hide dot files, with the keys of the server.
+func newDirPasswords(fsys http.FileSystem, keys *dirPasswordKeys) *dirPasswords {
+	return &dirPasswords{dirPasswordKeys: keys, fsys: fsys, m: map[string]*dirPasswordEntry{}}
+}
+
+// wait returns how long the client key must wait before it may try a
+// password again, or 0 if it may now.
+func (d *dirPasswordKeys) wait(key string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.wrong[key]
+	if c == nil || c.n < dirPasswordTries {
+		return 0
+	}
+	return max(0, dirPasswordWindow-time.Since(c.start))
+}
+
+// tried counts a password of the client key, forgetting its wrong ones
+// if it was right.
+func (d *dirPasswordKeys) tried(key string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ok {
+		delete(d.wrong, key)
+		return
+	}
+	c := d.wrong[key]
+	if c == nil || time.Since(c.start) >= dirPasswordWindow {
+		if len(d.wrong) >= dirPasswordClients {
+			for k, c := range d.wrong {
+				if time.Since(c.start) >= dirPasswordWindow {
+					delete(d.wrong, k)
+				}
+			}
+		}
+		c = &wrongPasswords{start: time.Now()}
+		d.wrong[key] = c
+	}
+	c.n++
+}
+
+type dirPasswordEntry struct {
+	checked time.Time
+	modTime time.Time
+	size    int64
+	hash    string // empty if the dir has no file
+	err     error
+}
+
+// purge forgets what every file held, so that they are read again.
+func (d *dirPasswords) purge() {
+	d.mu.Lock()
+	clear(d.m)
+	d.mu.Unlock()
+}
+
+// get returns the hash in the password file of the dir dir, or "".
+func (d *dirPasswords) get(dir string) (string, error) {
+	d.mu.Lock()
+	e := d.m[dir]
+	d.mu.Unlock()
+	if e != nil && time.Since(e.checked) < dirConfigCheck {
+		return e.hash, e.err
+	}
+	next := &dirPasswordEntry{checked: time.Now()}
+	name := path.Join(dir, DirPasswordName)
+	if f, err := d.fsys.Open(name); err == nil {
+		fi, err := f.Stat()
+		switch {
+		case err != nil:
+			next.err = err
+		case fi.IsDir():
+		case e != nil && e.hash != "" && fi.ModTime().Equal(e.modTime) && fi.Size() == e.size:
+			next.modTime, next.size, next.hash = e.modTime, e.size, e.hash
+		default:
+			next.modTime, next.size = fi.ModTime(), fi.Size()
+			line, _ := bufio.NewReader(f).ReadString('\n')
+			hash := strings.TrimSpace(line)
+			if i := strings.LastIndexByte(hash, ':'); i >= 0 {
+				hash = hash[i+1:]
+			}
+			if !strings.HasPrefix(hash, "$2") {
+				next.err = errors.New(name + ": expected a bcrypt hash")
+			} else {
+				next.hash = hash
+				debugf("dirpassword: read %s", name)
+			}
+		}
+		f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		next.err = err
+	}
+	d.mu.Lock()
+	d.m[dir] = next
+	d.mu.Unlock()
+	return next.hash, next.err
+}
+
+// dirPassword is the password a dir is protected with.
+type dirPassword struct {
+	dir  string
+	hash string
+}
+
+// lookup returns the passwords protecting the file or dir name, those of
+// the dirs above it first.
+func (d *dirPasswords) lookup(name string, isDir bool) ([]dirPassword, error) {
+	dir := name
+	if !isDir {
+		dir = path.Dir(name)
+	}
+	var passwords []dirPassword
+	for p := dir; ; p = path.Dir(p) {
+		hash, err := d.get(p)
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" {
+			passwords = append([]dirPassword{{p, hash}}, passwords...)
+		}
+		if p == "/" {
+			return passwords, nil
+		}
+	}
+}
+
+// cookie returns the name and value of the session cookie of p, which
+// change with its hash, so that a new password ends the sessions of
+// the old one.
+func (d *dirPasswords) cookie(p dirPassword) (string, string) {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte(p.dir + "\x00" + p.hash))
+	sum := mac.Sum(nil)
+	return "pw-" + hex.EncodeToString(sum[:6]), base64.RawURLEncoding.EncodeToString(sum)
+}
+
+// dirPasswordHandler asks for the passwords of the password files of
+// the dirs of an FS before serving the files under them with next. A
+// password given is remembered with a cookie for as long as the browser
+// runs and the server does; each file is asked for in turn, the one
+// nearest the root first. A client that gives dirPasswordTries wrong
+// passwords is told to wait for the rest of dirPasswordWindow before it
+// may try again. Share links are let in without.
+type dirPasswordHandler struct {
+	passwords *dirPasswords
+	next      http.Handler
+}
+
+// ServeHTTP serves r with next once its client has given every password
+// of its dirs.
+func (h dirPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isShared(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	isDir := false
+	if f, err := h.passwords.fsys.Open(name); err == nil {
+		if fi, err := f.Stat(); err == nil {
+			isDir = fi.IsDir()
+		}
+		f.Close()
+	}
+	passwords, err := h.passwords.lookup(name, isDir)
+	if err != nil {
+		dirFileError(w, "dirpassword", DirPasswordName, err)
+		return
+	}
+	for _, p := range passwords {
+		cookieName, value := h.passwords.cookie(p)
+		// The user is whoever knows the password of the dir.
+		user := path.Join(p.dir, DirPasswordName)
+		if c, err := r.Cookie(cookieName); err == nil && hmac.Equal([]byte(c.Value), []byte(value)) {
+			setUser(r, user, true)
+			continue
+		}
+		wrong := false
+		if r.Method == http.MethodPost && r.PostFormValue("password") != "" {
+			key := clientKey(clientIP(r))
+			if wait := h.passwords.wait(key); wait > 0 {
+				infof("dirpassword: %s gave too many wrong passwords, refusing another for %s", clientIP(r), p.dir)
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(wait.Round(time.Second).Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			ok, err := checkBcrypt(p.hash, r.PostFormValue("password"))
+			if err != nil {
+				log.Printf("dirpassword: %s: %v", path.Join(p.dir, DirPasswordName), err)
+			}
+			h.passwords.tried(key, ok)
+			if ok {
+				setUser(r, user, true)
+				infof("dirpassword: %s let into %s", clientIP(r), p.dir)
+				http.SetCookie(w, &http.Cookie{Name: cookieName, Value: value, Path: "/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+				http.Redirect(w, r, r.RequestURI, http.StatusSeeOther)
+				return
+			}
+			setUser(r, user, false)
+			infof("dirpassword: wrong password for %s from %s", p.dir, clientIP(r))
+			wrong = true
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusUnauthorized)
+		if r.Method != http.MethodHead {
+			dirPasswordPage.Execute(w, struct{ Wrong bool }{wrong})
+		}
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
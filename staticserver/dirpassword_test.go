@@ -0,0 +1,209 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passwordRoot returns a dir whose dir locked has the password secret,
+// locked/inner the password inner too and bad a password file that holds
+// no hash, with a file a.txt in each and in open.
+func passwordRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	hash := func(password string) string {
+		h, err := bcryptHash([]byte(password), make([]byte, 16), bcryptMinCost, "2b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	files := map[string]string{
+		"open/a.txt":                      "open",
+		"locked/a.txt":                    "locked",
+		"locked/" + DirPasswordName:       "alice:" + hash("secret") + "\n",
+		"locked/inner/a.txt":              "inner",
+		"locked/inner/" + DirPasswordName: hash("inner") + "\n",
+		"bad/a.txt":                       "bad",
+		"bad/" + DirPasswordName:          "not a hash\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// passwordRequest returns a request for target from the IP ip, POSTing
+// password if it is not empty, with cookies.
+func passwordRequest(target, ip, password string, cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	if password != "" {
+		r = httptest.NewRequest("POST", target, strings.NewReader(url.Values{"password": {password}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.RemoteAddr = ip + ":1234"
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// record serves r with h and returns the response.
+func record(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// newPasswordHandler returns a dirPasswordHandler for root, with keys,
+// in front of a file server.
+func newPasswordHandler(root string, keys *dirPasswordKeys) dirPasswordHandler {
+	return dirPasswordHandler{passwords: newDirPasswords(http.Dir(root), keys), next: http.FileServer(http.Dir(root))}
+}
+
+func TestDirPasswordHandler(t *testing.T) {
+	root := passwordRoot(t)
+	h := newPasswordHandler(root, newDirPasswordKeys())
+
+	tests := []struct {
+		target   string
+		password string
+		status   int
+		body     string
+	}{
+		{"/open/a.txt", "", http.StatusOK, "open"},
+		{"/locked/a.txt", "", http.StatusUnauthorized, "Password required"},
+		{"/locked/", "", http.StatusUnauthorized, "Password required"},
+		{"/locked/inner/a.txt", "", http.StatusUnauthorized, "Password required"},
+		{"/locked/a.txt", "wrong", http.StatusUnauthorized, "not the right one"},
+		{"/locked/inner/a.txt", "inner", http.StatusUnauthorized, "not the right one"},
+		{"/bad/a.txt", "", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		w := record(h, passwordRequest(tt.target, "192.0.2.1", tt.password, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s with %q = %d, want %d", tt.target, tt.password, w.Code, tt.status)
+		}
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s with %q: body %q, want it to contain %q", tt.target, tt.password, w.Body.String(), tt.body)
+		}
+	}
+
+	// The password of a dir lets its files in, but not those of a dir
+	// under it with a password of its own, which is asked for next.
+	w := record(h, passwordRequest("/locked/a.txt", "192.0.2.2", "secret", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("right password = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("right password set cookies %v, want one HttpOnly cookie", cookies)
+	}
+	if w := record(h, passwordRequest("/locked/a.txt", "192.0.2.2", "", cookies)); w.Code != http.StatusOK || w.Body.String() != "locked" {
+		t.Errorf("with the cookie = %d %q, want 200 %q", w.Code, w.Body.String(), "locked")
+	}
+	if w := record(h, passwordRequest("/locked/inner/a.txt", "192.0.2.2", "", cookies)); w.Code != http.StatusUnauthorized {
+		t.Errorf("inner with the outer cookie = %d, want 401", w.Code)
+	}
+	w = record(h, passwordRequest("/locked/inner/a.txt", "192.0.2.2", "inner", cookies))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("inner password = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	both := append(cookies, w.Result().Cookies()...)
+	if w := record(h, passwordRequest("/locked/inner/a.txt", "192.0.2.2", "", both)); w.Code != http.StatusOK {
+		t.Errorf("inner with both cookies = %d, want 200", w.Code)
+	}
+
+	// A cookie holds for the same dir at another endpoint of the server,
+	// but not at another server.
+	if w := record(newPasswordHandler(root, h.passwords.dirPasswordKeys), passwordRequest("/locked/a.txt", "192.0.2.2", "", cookies)); w.Code != http.StatusOK {
+		t.Errorf("cookie at another endpoint = %d, want 200", w.Code)
+	}
+	if w := record(newPasswordHandler(root, newDirPasswordKeys()), passwordRequest("/locked/a.txt", "192.0.2.2", "", cookies)); w.Code != http.StatusUnauthorized {
+		t.Errorf("cookie at another server = %d, want 401", w.Code)
+	}
+
+	// A new password ends the sessions of the old one.
+	hash, err := bcryptHash([]byte("changed"), make([]byte, 16), bcryptMinCost, "2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "locked", DirPasswordName), []byte(hash+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h.passwords.purge()
+	if w := record(h, passwordRequest("/locked/a.txt", "192.0.2.2", "", cookies)); w.Code != http.StatusUnauthorized {
+		t.Errorf("old cookie after the password changed = %d, want 401", w.Code)
+	}
+}
+
+func TestDirPasswordLockout(t *testing.T) {
+	root := passwordRoot(t)
+	keys := newDirPasswordKeys()
+	h := newPasswordHandler(root, keys)
+	other := newPasswordHandler(root, keys)
+
+	for i := range dirPasswordTries {
+		// The wrong ones count against the client at every endpoint.
+		hh := h
+		if i%2 == 1 {
+			hh = other
+		}
+		if w := record(hh, passwordRequest("/locked/a.txt", "192.0.2.1", "wrong", nil)); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d = %d, want 401", i+1, w.Code)
+		}
+	}
+	w := record(h, passwordRequest("/locked/a.txt", "192.0.2.1", "secret", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("password after %d wrong ones = %d, want 429", dirPasswordTries, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	if w := record(other, passwordRequest("/locked/inner/a.txt", "192.0.2.1", "inner", nil)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("password for another dir at another endpoint = %d, want 429", w.Code)
+	}
+	// Requests without a password are still asked for one.
+	if w := record(h, passwordRequest("/locked/a.txt", "192.0.2.1", "", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("request without a password = %d, want 401", w.Code)
+	}
+	// Other clients are not held to it, but the addresses of one IPv6
+	// network are one client.
+	if w := record(h, passwordRequest("/locked/a.txt", "192.0.2.9", "secret", nil)); w.Code != http.StatusSeeOther {
+		t.Errorf("right password from another client = %d, want 303", w.Code)
+	}
+	for i := range dirPasswordTries {
+		ip := "2001:db8::" + string(rune('1'+i))
+		record(h, passwordRequest("/locked/a.txt", ip, "wrong", nil))
+	}
+	if w := record(h, passwordRequest("/locked/a.txt", "2001:db8::ff", "secret", nil)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("password from the same /64 = %d, want 429", w.Code)
+	}
+
+	// Once the window is over the client may try again, and the right
+	// password forgets the wrong ones.
+	keys.mu.Lock()
+	keys.wrong[clientKey("192.0.2.1")].start = time.Now().Add(-dirPasswordWindow)
+	keys.mu.Unlock()
+	if w := record(h, passwordRequest("/locked/a.txt", "192.0.2.1", "secret", nil)); w.Code != http.StatusSeeOther {
+		t.Errorf("password after the window = %d, want 303", w.Code)
+	}
+	keys.mu.Lock()
+	_, counted := keys.wrong[clientKey("192.0.2.1")]
+	keys.mu.Unlock()
+	if counted {
+		t.Error("the right password did not forget the wrong ones")
+	}
+}
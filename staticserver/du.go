@@ -0,0 +1,151 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// duPath is where the disk usage of dirs is served.
+const duPath = "/_api/du"
+
+// duCheck is how long the usage of a dir is kept before it is counted
+// again.
+const duCheck = 10 * time.Second
+
+// maxWalkDepth bounds how deep the walks of an FS go, so that a symlink
+// loop ends.
+const maxWalkDepth = 64
+
+// errNotDir is returned for the usage of a file.
+var errNotDir = errors.New("not a dir")
+
+// dirUsage is how much is stored under a dir, dot files left out.
+type dirUsage struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`  // the bytes of every file under it
+	Files int    `json:"files"` // the number of files under it
+	Dirs  int    `json:"dirs"`  // the number of dirs under it
+	// Entries is the number of entries of the dir itself.
+	Entries int `json:"entries"`
+}
+
+type duEntry struct {
+	checked time.Time
+	usage   dirUsage
+}
+
+// diskUsage counts what the dirs of an FS hold, when first asked and
+// again once duCheck has passed, reusing the counts of the dirs under
+// a dir to count it.
+type diskUsage struct {
+	fs http.FileSystem
+
+	mu sync.Mutex
+	m  map[string]*duEntry
+}
+
+func newDiskUsage(fs http.FileSystem) *diskUsage {
+	return &diskUsage{fs: fs, m: map[string]*duEntry{}}
+}
+
+// purge forgets every count.
+func (d *diskUsage) purge() {
+	d.mu.Lock()
+	clear(d.m)
+	d.mu.Unlock()
+}
+
+// usage returns the usage of the dir name.
+func (d *diskUsage) usage(name string) (dirUsage, error) {
+	return d.count(path.Clean("/"+name), 0)
+}
+
+func (d *diskUsage) count(name string, depth int) (dirUsage, error) {
+	d.mu.Lock()
+	e := d.m[name]
+	d.mu.Unlock()
+	if e != nil && time.Since(e.checked) < duCheck {
+		return e.usage, nil
+	}
+	f, err := d.fs.Open(name)
+	if err != nil {
+		return dirUsage{}, err
+	}
+	fi, err := f.Stat()
+	if err == nil && !fi.IsDir() {
+		err = errNotDir
+	}
+	var fis []os.FileInfo
+	if err == nil {
+		fis, err = f.Readdir(-1)
+	}
+	f.Close()
+	if err != nil {
+		return dirUsage{}, err
+	}
+	u := dirUsage{Path: name, Entries: len(fis)}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			u.Size += fi.Size()
+			u.Files++
+			continue
+		}
+		u.Dirs++
+		if depth == maxWalkDepth {
+			continue
+		}
+		sub, err := d.count(path.Join(name, fi.Name()), depth+1)
+		if err != nil {
+			debugf("du: %s: %v", path.Join(name, fi.Name()), err)
+			continue
+		}
+		u.Size += sub.Size
+		u.Files += sub.Files
+		u.Dirs += sub.Dirs
+	}
+	d.mu.Lock()
+	d.m[name] = &duEntry{checked: time.Now(), usage: u}
+	d.mu.Unlock()
+	return u, nil
+}
+
+// ServeHTTP answers with the usage of the dir in the path parameter, or
+// of the root, as JSON.
+func (d *diskUsage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + r.URL.Query().Get("path"))
+	u, err := d.usage(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	case errors.Is(err, errNotDir):
+		http.Error(w, "only the usage of a dir is counted", http.StatusBadRequest)
+		return
+	case err != nil:
+		infof("warning: du: %s: %v", name, err)
+		http.Error(w, "Error reading dir", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(u)
+}
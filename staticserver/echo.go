@@ -0,0 +1,65 @@
+package staticserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// echoPath is where requests are echoed back.
+const echoPath = "/_echo"
+
+// maxEchoBody is the most request body echoed back.
+const maxEchoBody = 1 << 20
+
+// echoResponse describes a request, as sent back by echoHandler.
+type echoResponse struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	Remote     string              `json:"remote"`
+	Query      url.Values          `json:"query"`
+	Headers    map[string][]string `json:"headers"`
+	Form       url.Values          `json:"form,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"` // instead of Body, when it is not UTF-8
+	Truncated  bool                `json:"truncated,omitempty"`
+}
+
+// echoHandler responds to any request with a JSON description of it,
+// for debugging forms and fetch calls without a service like httpbin.
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	resp := echoResponse{
+		Method:  r.Method,
+		URL:     r.URL.String(),
+		Proto:   r.Proto,
+		Host:    r.Host,
+		Remote:  r.RemoteAddr,
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxEchoBody+1))
+	if len(body) > maxEchoBody {
+		body, resp.Truncated = body[:maxEchoBody], true
+	}
+	if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/x-www-form-urlencoded") && !resp.Truncated {
+		resp.Form, _ = url.ParseQuery(string(body))
+	}
+	if utf8.Valid(body) {
+		resp.Body = string(body)
+	} else {
+		resp.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(resp)
+}
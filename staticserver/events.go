@@ -0,0 +1,96 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// eventsPath is where file changes are streamed.
+const eventsPath = "/_events"
+
+// fileEvents streams the changes a dirWatcher finds to clients as
+// server-sent events, one JSON fileEvent per change.
+type fileEvents struct {
+	hosts map[string]string // the vhost, or "" for -dir, whose files are in each dir to report
+	done  chan struct{}
+
+	mu      sync.Mutex
+	clients map[chan []fileChange]bool
+}
+
+// fileEvent is a change as sent to clients.
+type fileEvent struct {
+	Event string `json:"event"` // created, modified or deleted
+	Path  string `json:"path"`
+	Host  string `json:"host,omitempty"` // the vhost the file is served for, if any
+}
+
+// newFileEvents returns a fileEvents with no clients, that reports
+// changes in the dirs of hosts as changes for that host and ignores
+// changes anywhere else.
+func newFileEvents(hosts map[string]string) *fileEvents {
+	return &fileEvents{hosts: hosts, done: make(chan struct{}), clients: map[chan []fileChange]bool{}}
+}
+
+// changed passes changes on to the clients, dropping them for any that
+// are too far behind. It is a dirWatcher subscriber.
+func (e *fileEvents) changed(changes []fileChange) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.clients {
+		select {
+		case c <- changes:
+		default:
+		}
+	}
+}
+
+// close ends the event streams, so they do not hold up a shutdown.
+func (e *fileEvents) close() {
+	close(e.done)
+}
+
+// ServeHTTP streams the changes found from now on until the client goes
+// away.
+func (e *fileEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := make(chan []fileChange, 16)
+	e.mu.Lock()
+	e.clients[c] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.clients, c)
+		e.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		var changes []fileChange
+		select {
+		case <-r.Context().Done():
+			return
+		case <-e.done:
+			return
+		case changes = <-c:
+		}
+		for _, ch := range changes {
+			host, ok := e.hosts[ch.Dir]
+			if !ok {
+				continue
+			}
+			b, _ := json.Marshal(fileEvent{Event: ch.Event, Path: ch.Path, Host: host})
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
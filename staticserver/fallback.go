@@ -0,0 +1,31 @@
+package staticserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+)
+
+// fallbackHandler serves files from fs and defers to upstream for
+// any request whose path does not exist there. Other errors, such as
+// the permission error for dot files, are left to files to report.
+type fallbackHandler struct {
+	fs       http.FileSystem
+	files    http.Handler
+	upstream http.Handler
+}
+
+// ServeHTTP serves r from files when its path exists and from upstream otherwise.
+func (h fallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, err := h.fs.Open(path.Clean("/" + r.URL.Path))
+	if errors.Is(err, fs.ErrNotExist) {
+		debugf("fallback: %s does not exist, forwarding upstream", r.URL.Path)
+		h.upstream.ServeHTTP(w, r)
+		return
+	}
+	if err == nil {
+		f.Close()
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,388 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The FastCGI record types and the responder role, from the spec.
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiResponder    = 1
+)
+
+// fcgiMaxBody is the size of the largest request body of unknown length
+// that is read to send on, as FastCGI needs its length up front.
+const fcgiMaxBody = 32 << 20
+
+// fastCGI is the address of a FastCGI server, such as php-fpm.
+type fastCGI struct {
+	network, addr string
+}
+
+// parseFastCGI parses s as unix:/path/to.sock, tcp:host:port or
+// host:port.
+func parseFastCGI(s string) (fastCGI, error) {
+	switch {
+	case strings.HasPrefix(s, "unix:"):
+		return fastCGI{"unix", strings.TrimPrefix(s, "unix:")}, nil
+	case strings.HasPrefix(s, "tcp:"):
+		s = strings.TrimPrefix(s, "tcp:")
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return fastCGI{}, fmt.Errorf("invalid fastcgi address %q, expected unix:/path or host:port", s)
+	}
+	return fastCGI{"tcp", s}, nil
+}
+
+func (f fastCGI) String() string {
+	return f.network + ":" + f.addr
+}
+
+// phpHandler runs the .php files under dir with a FastCGI server, and
+// passes every other request to next. A directory is run as its
+// index.php when it has one, and a path can go on past the script, as
+// /form.php/sent, in PATH_INFO. The extension is matched in any case,
+// as a file system that ignores case opens /INDEX.PHP as index.php.
+type phpHandler struct {
+	dir  string // absolute, as the server is told where scripts are
+	fcgi fastCGI
+	fold *foldFS // resolves the names of scripts that do not exist exactly, if non-nil
+	next http.Handler
+}
+
+// script returns the URL path of the script r runs with the path info
+// after it, or "" if it runs none.
+func (h phpHandler) script(r *http.Request) (name, info string) {
+	p := path.Clean("/" + r.URL.Path)
+	if isDotF(p) {
+		return "", ""
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		if name, ok := h.file(path.Join(p, "index.php")); ok {
+			return name, ""
+		}
+		return "", ""
+	}
+	for end := len(".php"); end <= len(p); end++ {
+		if !strings.EqualFold(p[end-len(".php"):end], ".php") || end < len(p) && p[end] != '/' {
+			continue
+		}
+		if name, ok := h.file(p[:end]); ok {
+			return name, p[end:]
+		}
+	}
+	return "", ""
+}
+
+// file returns the URL path of the regular file in dir that p names,
+// folded if it does not exist exactly, and whether there is one.
+func (h phpHandler) file(p string) (string, bool) {
+	fi, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(p)))
+	if err != nil && h.fold != nil {
+		if resolved, ok := h.fold.resolve(p); ok {
+			p = resolved
+			fi, err = os.Stat(filepath.Join(h.dir, filepath.FromSlash(p)))
+		}
+	}
+	return p, err == nil && fi.Mode().IsRegular()
+}
+
+// phpSourceFS is an FS whose .php files, in any case, do not exist, so
+// that with PHP on no handler serves the source of a script it does
+// not run.
+type phpSourceFS struct {
+	http.FileSystem
+}
+
+// Open opens name, as if it did not exist if it is a PHP file.
+func (f phpSourceFS) Open(name string) (http.File, error) {
+	file, err := f.FileSystem.Open(name)
+	if err != nil || !isPHP(name) {
+		return file, err
+	}
+	if fi, err := file.Stat(); err == nil && fi.IsDir() {
+		return file, nil
+	}
+	file.Close()
+	debugf("php: not serving the source of %s", name)
+	return nil, os.ErrNotExist
+}
+
+// isPHP reports whether name has the extension of PHP files, in any
+// case and with the dots and spaces Windows ignores at the end.
+func isPHP(name string) bool {
+	return strings.EqualFold(path.Ext(strings.TrimRight(name, ". ")), ".php")
+}
+
+// ServeHTTP runs the script of r, or serves r with next.
+func (h phpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, info := h.script(r)
+	if name == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	body := io.Reader(r.Body)
+	length := r.ContentLength
+	if length < 0 {
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, fcgiMaxBody))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, length = bytes.NewReader(b), int64(len(b))
+	}
+	params := h.params(r, name, info, length)
+	debugf("php: %s runs %s", r.URL.Path, name)
+	if err := h.fcgi.serve(w, r, params, body); err != nil {
+		infof("warning: php: %s: %v", name, err)
+	}
+}
+
+// params returns the CGI variables the script name is run with.
+func (h phpHandler) params(r *http.Request, name, info string, length int64) map[string]string {
+	p := cgiVars(r, h.dir, filepath.Join(h.dir, filepath.FromSlash(name)), name, info, length)
+	p["PHP_SELF"] = name + info
+	p["REDIRECT_STATUS"] = "200"
+	return p
+}
+
+// cgiVars returns the CGI variables of r for the script file script
+// under root, at the URL path name, with the path info after it and a
+// body of length bytes.
+func cgiVars(r *http.Request, root, script, name, info string, length int64) map[string]string {
+	p := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "static-server",
+		"SERVER_PROTOCOL":   r.Proto,
+		"REQUEST_METHOD":    r.Method,
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"QUERY_STRING":      r.URL.RawQuery,
+		"DOCUMENT_ROOT":     root,
+		"SCRIPT_FILENAME":   script,
+		"SCRIPT_NAME":       name,
+		"PATH_INFO":         info,
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    strconv.FormatInt(length, 10),
+	}
+	if info != "" {
+		p["PATH_TRANSLATED"] = filepath.Join(root, filepath.FromSlash(info))
+	}
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		p["REMOTE_ADDR"], p["REMOTE_PORT"] = host, port
+	}
+	p["SERVER_NAME"], p["SERVER_PORT"] = r.Host, "80"
+	if r.TLS != nil {
+		p["HTTPS"], p["SERVER_PORT"] = "on", "443"
+	}
+	if host, port, err := net.SplitHostPort(r.Host); err == nil {
+		p["SERVER_NAME"], p["SERVER_PORT"] = host, port
+	}
+	for k, v := range r.Header {
+		k = strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		if k == "PROXY" {
+			continue // https://httpoxy.org
+		}
+		sep := ", "
+		if k == "COOKIE" {
+			sep = "; "
+		}
+		p["HTTP_"+k] = strings.Join(v, sep)
+	}
+	return p
+}
+
+// serve sends the request with params and body to the server, and
+// writes the response the script gives to w.
+func (f fastCGI) serve(w http.ResponseWriter, r *http.Request, params map[string]string, body io.Reader) error {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(r.Context(), f.network, f.addr)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return err
+	}
+	defer conn.Close()
+	// A client that goes away stops the request.
+	stop := context.AfterFunc(r.Context(), func() { conn.Close() })
+	defer stop()
+
+	go func() {
+		// The server may answer before it has read all of the body, so
+		// it is sent while the answer is read.
+		bw := bufio.NewWriter(conn)
+		writeFCGIRecord(bw, fcgiBeginRequest, []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0})
+		var pairs []byte
+		for k, v := range params {
+			pairs = appendFCGIPair(pairs, k, v)
+		}
+		writeFCGIStream(bw, fcgiParams, pairs)
+		writeFCGIRecord(bw, fcgiParams, nil)
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				writeFCGIStream(bw, fcgiStdin, buf[:n])
+			}
+			if err != nil {
+				break
+			}
+		}
+		writeFCGIRecord(bw, fcgiStdin, nil)
+		bw.Flush()
+	}()
+
+	out := bufio.NewReader(&fcgiStdoutReader{r: bufio.NewReader(conn)})
+	started, err := writeCGIResponse(w, out)
+	if !started {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+	if err != nil && r.Context().Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// writeCGIResponse writes the CGI response out, its headers and then its
+// body as it comes, to w. It reports whether it got as far as sending
+// the headers, as the caller must answer for it otherwise.
+func writeCGIResponse(w http.ResponseWriter, out *bufio.Reader) (started bool, err error) {
+	header, err := textproto.NewReader(out).ReadMIMEHeader()
+	if err != nil {
+		return false, fmt.Errorf("reading the response headers: %w", err)
+	}
+	status := http.StatusOK
+	if s := header.Get("Status"); s != "" {
+		if status, err = strconv.Atoi(strings.Fields(s + " ")[0]); err != nil || status < 100 || status > 999 {
+			return false, fmt.Errorf("invalid status %q", s)
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := out.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return true, err
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return true, err
+		}
+	}
+}
+
+// appendFCGIPair appends the name-value pair k, v to b in the encoding
+// of FastCGI params.
+func appendFCGIPair(b []byte, k, v string) []byte {
+	for _, n := range []int{len(k), len(v)} {
+		if n < 128 {
+			b = append(b, byte(n))
+		} else {
+			b = binary.BigEndian.AppendUint32(b, uint32(n)|1<<31)
+		}
+	}
+	return append(append(b, k...), v...)
+}
+
+// writeFCGIStream writes data as the records of a stream of type t,
+// which are at most 65535 bytes each.
+func writeFCGIStream(w *bufio.Writer, t byte, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), 0xffff)
+		if err := writeFCGIRecord(w, t, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// writeFCGIRecord writes a record of type t for request 1.
+func writeFCGIRecord(w *bufio.Writer, t byte, content []byte) error {
+	pad := -len(content) & 7
+	w.Write([]byte{1, t, 0, 1, byte(len(content) >> 8), byte(len(content)), byte(pad), 0})
+	w.Write(content)
+	_, err := w.Write(make([]byte, pad))
+	return err
+}
+
+// fcgiStdoutReader reads the stdout stream of the response of a FastCGI
+// server, logging its stderr stream, up to the end of the request.
+type fcgiStdoutReader struct {
+	r    *bufio.Reader
+	left int // what is left of the content of the record being read
+	pad  int
+	done bool
+}
+
+func (s *fcgiStdoutReader) Read(p []byte) (int, error) {
+	for s.left == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if _, err := s.r.Discard(s.pad); err != nil {
+			return 0, err
+		}
+		var h [8]byte
+		if _, err := io.ReadFull(s.r, h[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		n, pad := int(h[4])<<8|int(h[5]), int(h[6])
+		switch h[1] {
+		case fcgiStdout:
+			s.left, s.pad = n, pad
+		case fcgiStderr:
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(s.r, msg); err != nil {
+				return 0, err
+			}
+			if msg := strings.TrimSpace(string(msg)); msg != "" {
+				infof("php: %s", msg)
+			}
+			s.pad = pad
+		case fcgiEndRequest:
+			s.done, s.pad = true, 0
+			s.r.Discard(n + pad)
+		default:
+			return 0, errors.New("unexpected fastcgi record type " + strconv.Itoa(int(h[1])))
+		}
+	}
+	n, err := s.r.Read(p[:min(len(p), s.left)])
+	s.left -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // in the middle of a record
+	}
+	return n, err
+}
@@ -0,0 +1,363 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFastCGI(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    fastCGI
+		wantErr bool
+	}{
+		{"unix:/run/php/fpm.sock", fastCGI{"unix", "/run/php/fpm.sock"}, false},
+		{"tcp:127.0.0.1:9000", fastCGI{"tcp", "127.0.0.1:9000"}, false},
+		{"localhost:9000", fastCGI{"tcp", "localhost:9000"}, false},
+		{"[::1]:9000", fastCGI{"tcp", "[::1]:9000"}, false},
+		{"localhost", fastCGI{}, true},
+		{"tcp:", fastCGI{}, true},
+		{"", fastCGI{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFastCGI(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseFastCGI(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// readFCGIRecord reads a record, returning its type and content.
+func readFCGIRecord(r io.Reader) (byte, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	if h[0] != 1 || binary.BigEndian.Uint16(h[2:]) != 1 {
+		return 0, nil, errors.New("not version 1 for request 1")
+	}
+	b := make([]byte, int(binary.BigEndian.Uint16(h[4:]))+int(h[6]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return h[1], b[:binary.BigEndian.Uint16(h[4:])], nil
+}
+
+// parseFCGIPairs decodes the name-value pairs of FastCGI params.
+func parseFCGIPairs(b []byte) (map[string]string, error) {
+	m := map[string]string{}
+	length := func() (int, error) {
+		if len(b) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if b[0] < 128 {
+			n := int(b[0])
+			b = b[1:]
+			return n, nil
+		}
+		if len(b) < 4 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return n, nil
+	}
+	for len(b) > 0 {
+		kn, err := length()
+		if err != nil {
+			return nil, err
+		}
+		vn, err := length()
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < kn+vn {
+			return nil, io.ErrUnexpectedEOF
+		}
+		m[string(b[:kn])] = string(b[kn : kn+vn])
+		b = b[kn+vn:]
+	}
+	return m, nil
+}
+
+func TestAppendFCGIPair(t *testing.T) {
+	long := strings.Repeat("v", 300)
+	tests := []struct {
+		k, v string
+		head []byte // the encoded lengths
+	}{
+		{"A", "b", []byte{1, 1}},
+		{"EMPTY", "", []byte{5, 0}},
+		{"K", strings.Repeat("v", 127), []byte{1, 127}},
+		{"K", strings.Repeat("v", 128), []byte{1, 0x80, 0, 0, 128}},
+		{"LONG", long, []byte{4, 0x80, 0, 1, 44}},
+		{strings.Repeat("k", 200), "v", []byte{0x80, 0, 0, 200, 1}},
+	}
+	for _, tt := range tests {
+		b := appendFCGIPair(nil, tt.k, tt.v)
+		if !bytes.HasPrefix(b, tt.head) || len(b) != len(tt.head)+len(tt.k)+len(tt.v) {
+			t.Errorf("appendFCGIPair(%.10q, %.10q) = % x..., want lengths % x", tt.k, tt.v, b[:min(len(b), 8)], tt.head)
+			continue
+		}
+		m, err := parseFCGIPairs(b)
+		if err != nil || m[tt.k] != tt.v {
+			t.Errorf("appendFCGIPair(%.10q, %.10q) decodes to %v, %v", tt.k, tt.v, m, err)
+		}
+	}
+}
+
+func TestWriteFCGIStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		sizes []int // of the records written
+	}{
+		{"empty", 0, nil},
+		{"padded", 5, []int{5}},
+		{"aligned", 16, []int{16}},
+		{"largest record", 0xffff, []int{0xffff}},
+		{"split", 0xffff + 10, []int{0xffff, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{'x'}, tt.size)
+			var buf bytes.Buffer
+			bw := bufio.NewWriter(&buf)
+			if err := writeFCGIStream(bw, fcgiStdin, data); err != nil {
+				t.Fatal(err)
+			}
+			bw.Flush()
+			var sizes []int
+			var got []byte
+			for buf.Len() > 0 {
+				if buf.Len()%8 != 0 {
+					t.Fatalf("%d bytes left, want records padded to 8", buf.Len())
+				}
+				typ, content, err := readFCGIRecord(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if typ != fcgiStdin {
+					t.Fatalf("record type %d, want %d", typ, fcgiStdin)
+				}
+				sizes = append(sizes, len(content))
+				got = append(got, content...)
+			}
+			if !reflect.DeepEqual(sizes, tt.sizes) || !bytes.Equal(got, data) {
+				t.Errorf("wrote records of %v, want %v", sizes, tt.sizes)
+			}
+		})
+	}
+}
+
+// fcgiRecords returns the records given as a type and content each.
+func fcgiRecords(records ...any) []byte {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	for i := 0; i < len(records); i += 2 {
+		writeFCGIRecord(bw, records[i].(byte), []byte(records[i+1].(string)))
+	}
+	bw.Flush()
+	return buf.Bytes()
+}
+
+func TestFCGIStdoutReader(t *testing.T) {
+	end := string(make([]byte, 8))
+	tests := []struct {
+		name    string
+		in      []byte
+		want    string
+		wantErr bool
+	}{
+		{"one record", fcgiRecords(byte(fcgiStdout), "hello", byte(fcgiStdout), "", byte(fcgiEndRequest), end), "hello", false},
+		{"several records", fcgiRecords(byte(fcgiStdout), "a", byte(fcgiStdout), "bc", byte(fcgiEndRequest), end), "abc", false},
+		{"stderr between", fcgiRecords(byte(fcgiStdout), "a", byte(fcgiStderr), "warning", byte(fcgiStdout), "b", byte(fcgiEndRequest), end), "ab", false},
+		{"nothing after the end", append(fcgiRecords(byte(fcgiStdout), "a", byte(fcgiEndRequest), end), fcgiRecords(byte(fcgiStdout), "b")...), "a", false},
+		{"no end", fcgiRecords(byte(fcgiStdout), "a"), "a", true},
+		{"truncated record", fcgiRecords(byte(fcgiStdout), "hello")[:10], "", true},
+		{"unknown record", fcgiRecords(byte(99), "x"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(&fcgiStdoutReader{r: bufio.NewReader(bytes.NewReader(tt.in))})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("read %q, %v, want error %v", got, err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeFastCGI serves one request on l: it sends the params and the body
+// it was given on params, and answers with out as the script's output.
+func fakeFastCGI(t *testing.T, l net.Listener, out string, params chan<- map[string]string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var pairs, stdin []byte
+	for {
+		typ, content, err := readFCGIRecord(conn)
+		if err != nil {
+			t.Errorf("fake fastcgi: %v", err)
+			close(params)
+			return
+		}
+		if typ == fcgiParams {
+			pairs = append(pairs, content...)
+		}
+		if typ == fcgiStdin {
+			if len(content) == 0 {
+				break
+			}
+			stdin = append(stdin, content...)
+		}
+	}
+	m, err := parseFCGIPairs(pairs)
+	if err != nil {
+		t.Errorf("fake fastcgi: params: %v", err)
+	}
+	m["stdin"] = string(stdin)
+	params <- m
+	conn.Write(fcgiRecords(byte(fcgiStdout), out, byte(fcgiStderr), "a notice", byte(fcgiStdout), "", byte(fcgiEndRequest), string(make([]byte, 8))))
+}
+
+func TestPHPHandler(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"index.php", "form.php", "sub/index.php"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte("<?php"), 0o644)
+	}
+	os.WriteFile(filepath.Join(dir, ".hidden.php"), []byte("<?php"), 0o644)
+	tests := []struct {
+		path, script, info string
+	}{
+		{"/", "/index.php", ""},
+		{"/sub/", "/sub/index.php", ""},
+		{"/form.php", "/form.php", ""},
+		{"/form.php/sent/ok", "/form.php", "/sent/ok"},
+		{"/form.phpx", "", ""},
+		{"/missing.php", "", ""},
+		{"/sub", "", ""},
+		{"/.hidden.php", "", ""},
+		{"/sub/../form.php", "/form.php", ""},
+		{"/FORM.PHP", "/FORM.PHP", ""},
+		{"/Form.Php/x", "/Form.Php", "/x"},
+	}
+	h := phpHandler{dir: dir, fold: newFoldFS(http.Dir(dir), strings.ToLower)}
+	for _, tt := range tests {
+		script, info := h.script(httptest.NewRequest("GET", tt.path, nil))
+		// A file system that ignores case opens the name as asked.
+		if script != tt.script && script != strings.ToLower(tt.script) || info != tt.info {
+			t.Errorf("script(%q) = %q, %q, want %q, %q", tt.path, script, info, tt.script, tt.info)
+		}
+	}
+
+	tests2 := []struct {
+		name, out, status, header, body string
+	}{
+		{"default status", "Content-Type: text/plain\r\n\r\nhello", "200", "text/plain", "hello"},
+		{"status header", "Status: 201 Created\r\nContent-Type: text/x\r\n\r\nmade", "201", "text/x", "made"},
+		{"redirect", "Location: /there\r\n\r\n", "302", "", ""},
+		{"bad status", "Status: nope\r\n\r\n", "502", "", ""},
+		{"no header end", "Content-Type: text/plain", "502", "", ""},
+	}
+	for _, tt := range tests2 {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Skip(err)
+			}
+			defer l.Close()
+			params := make(chan map[string]string, 1)
+			go fakeFastCGI(t, l, tt.out, params)
+			h := phpHandler{dir: dir, fcgi: fastCGI{"tcp", l.Addr().String()}, next: http.NotFoundHandler()}
+			r := httptest.NewRequest("POST", "/form.php/sent?a=1", strings.NewReader("x=1"))
+			r.Header.Set("Proxy", "http://evil")
+			r.Header.Add("Cookie", "a=1")
+			r.Header.Add("Cookie", "b=2")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			p := <-params
+			for k, want := range map[string]string{
+				"REQUEST_METHOD":  "POST",
+				"SCRIPT_NAME":     "/form.php",
+				"SCRIPT_FILENAME": filepath.Join(dir, "form.php"),
+				"PATH_INFO":       "/sent",
+				"QUERY_STRING":    "a=1",
+				"CONTENT_LENGTH":  "3",
+				"HTTP_COOKIE":     "a=1; b=2",
+				"stdin":           "x=1",
+			} {
+				if p[k] != want {
+					t.Errorf("%s = %q, want %q", k, p[k], want)
+				}
+			}
+			if _, ok := p["HTTP_PROXY"]; ok {
+				t.Error("HTTP_PROXY was passed on")
+			}
+			if got := w.Result().Status[:3]; got != tt.status {
+				t.Errorf("status %s, want %s", got, tt.status)
+			}
+			if tt.status[0] == '5' {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.header {
+				t.Errorf("Content-Type %q, want %q", got, tt.header)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestPHPSource(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.php"), []byte("<?php echo 1; ?>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "page.html"), []byte("page"), 0o644)
+	os.Mkdir(filepath.Join(dir, "lib.php"), 0o755)
+	for _, name := range []string{"/index.php", "/INDEX.PHP", "/index.php.", "/index.php "} {
+		if f, err := (phpSourceFS{http.Dir(dir)}).Open(name); err == nil {
+			f.Close()
+			t.Errorf("phpSourceFS opened %q", name)
+		}
+	}
+	for _, name := range []string{"/page.html", "/lib.php"} {
+		f, err := (phpSourceFS{http.Dir(dir)}).Open(name)
+		if err != nil {
+			t.Errorf("phpSourceFS did not open %q: %v", name, err)
+			continue
+		}
+		f.Close()
+	}
+
+	// No FastCGI server listens, so a script that is run fails with 502.
+	s, err := New(Config{Dir: dir, PHP: "127.0.0.1:1", CaseInsensitive: true, Try: TryRules{{prefix: "/x/", candidates: []string{"/INDEX.PHP"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, p := range []string{"/INDEX.PHP", "/Index.php", "/index.PHP/info", "/x/y"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		if strings.Contains(w.Body.String(), "<?php") {
+			t.Errorf("GET %s sent the source of the script with %d", p, w.Code)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedPath is where the feed is served.
+const feedPath = "/_feed.xml"
+
+// The default number of files a feed lists, and the most files looked
+// through for them.
+const (
+	feedSize     = 20
+	maxFeedFiles = 100000
+)
+
+// fileFeed serves an Atom feed of the newest files under a prefix of
+// an FS, each linking to the file, so that a release or report dir can
+// be subscribed to. Dot files are left out.
+type fileFeed struct {
+	fsys   fs.FS
+	prefix string // the URL path of the dir, "/" for all of the FS
+	size   int
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type feedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// newest returns the newest files under the prefix, newest first.
+func (f fileFeed) newest() []feedFile {
+	root := strings.Trim(f.prefix, "/")
+	if root == "" {
+		root = "."
+	}
+	var files []feedFile
+	seen := 0
+	fs.WalkDir(f.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if seen++; seen > maxFeedFiles {
+			return fs.SkipAll
+		}
+		fi, err := d.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		files = append(files, feedFile{"/" + p, fi.Size(), fi.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.After(files[j].modTime)
+		}
+		return files[i].path < files[j].path
+	})
+	return files[:min(len(files), f.size)]
+}
+
+// ServeHTTP serves the feed.
+func (f fileFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := requestBase(r)
+	abs := func(p string) string {
+		u := *base
+		u.Path = p
+		return u.String()
+	}
+	files := f.newest()
+	feed := atomFeed{
+		NS:     "http://www.w3.org/2005/Atom",
+		Title:  "New files in " + f.prefix,
+		ID:     abs(feedPath),
+		Author: base.Host,
+		Links: []atomLink{
+			{Rel: "self", Href: abs(feedPath)},
+			{Href: abs(strings.TrimSuffix(f.prefix, "/") + "/")},
+		},
+	}
+	var updated time.Time
+	for _, file := range files {
+		id := abs(file.path) + "#" + file.modTime.UTC().Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   path.Base(file.path),
+			ID:      id,
+			Updated: file.modTime.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: abs(file.path)},
+			Summary: file.path + " (" + formatSize(file.size) + ")",
+		})
+		if file.modTime.After(updated) {
+			updated = file.modTime
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	enc := xml.NewEncoder(&out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		infof("warning: feed: %v", err)
+		http.Error(w, "Error rendering feed", http.StatusInternalServerError)
+		return
+	}
+	out.WriteString("\n")
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Add("Vary", "Host")
+	// A file removed leaves no newer time, so there is no Last-Modified.
+	http.ServeContent(w, r, feedPath, time.Time{}, bytes.NewReader(out.Bytes()))
+}
@@ -0,0 +1,369 @@
+package staticserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ManifestName is the name of the manifest Fingerprint writes, in the
+// dir it fingerprints, unless it is told another.
+const ManifestName = "asset-manifest.json"
+
+// fingerprintExts are the extensions of the assets Fingerprint makes
+// hashed copies of.
+var fingerprintExts = map[string]bool{
+	".css": true, ".js": true, ".mjs": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp3": true, ".mp4": true, ".webm": true, ".ogg": true,
+}
+
+var (
+	// htmlRef matches the src and href attributes of HTML.
+	htmlRef = regexp.MustCompile(`(?i)(\s(?:src|href)\s*=\s*)("[^"]*"|'[^']*'|[^\s>"']+)`)
+	// cssRef matches the url()s and the quoted @imports of CSS.
+	cssRef = regexp.MustCompile(`(?i)(url\(\s*|@import\s+)("[^"]*"|'[^']*'|[^\s)"']+)`)
+	// hashedName matches the names Fingerprint gives the copies it makes.
+	hashedName = regexp.MustCompile(`^(.*)\.[0-9a-f]{8}(\.[^./]+)$`)
+)
+
+// Fingerprint gives every asset in dir, such as a stylesheet, script,
+// image or font, a copy whose name has the hash of its content in it,
+// so that app.css is copied to app.3f2a1b9c.css, and rewrites the
+// references to the assets in the HTML files, and in the stylesheets'
+// copies, to the copies. The assets themselves are left as they are.
+// The manifest, a JSON object of the slash separated paths of the
+// assets to those of their copies, is written to the file manifest,
+// ManifestName in dir if it is empty, and returned.
+//
+// It can be run again after the assets change: the references made the
+// last time, which the manifest it was run with finds, are rewritten to
+// the new copies. Copies no longer referenced are not removed, so that
+// cached pages still find theirs.
+func Fingerprint(dir, manifest string) (map[string]string, error) {
+	if manifest == "" {
+		manifest = filepath.Join(dir, ManifestName)
+	}
+	// What the last run made each copy from.
+	made := map[string]string{}
+	if b, err := os.ReadFile(manifest); err == nil {
+		var last map[string]string
+		if err := json.Unmarshal(b, &last); err != nil {
+			return nil, fmt.Errorf("%s: %v", manifest, err)
+		}
+		for orig, copied := range last {
+			made[copied] = orig
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	manifestPath, _ := filepath.Rel(dir, manifest)
+	var assets, pages []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		rel = filepath.ToSlash(rel)
+		if rel != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || rel == filepath.ToSlash(manifestPath) || made[rel] != "" {
+			return nil
+		}
+		// Copies from runs before the last are no longer in the manifest,
+		// but are still named after an asset.
+		if sub := hashedName.FindStringSubmatch(rel); sub != nil {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(sub[1]+sub[2]))); err == nil {
+				return nil
+			}
+		}
+		switch ext := strings.ToLower(path.Ext(rel)); {
+		case ext == ".html" || ext == ".htm":
+			pages = append(pages, rel)
+		case fingerprintExts[ext]:
+			assets = append(assets, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(assets)
+	f := fingerprinter{dir: dir, assets: assets, made: made, copies: map[string]string{}, doing: map[string]bool{}}
+	for _, a := range f.assets {
+		if _, err := f.copy(a); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range pages {
+		if err := f.rewritePage(p); err != nil {
+			return nil, err
+		}
+	}
+	b, err := json.MarshalIndent(f.copies, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(manifest, append(b, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return f.copies, nil
+}
+
+// fingerprinter is the state of a run of Fingerprint.
+type fingerprinter struct {
+	dir    string
+	assets []string          // sorted
+	made   map[string]string // the copies of the last run, to what they were made from
+	copies map[string]string // the assets copied, to their copies
+	doing  map[string]bool   // the stylesheets being copied, which may import each other
+}
+
+// copy makes the hashed copy of the asset name, if there is none, and
+// returns its path. A stylesheet's references are rewritten first, as
+// they are part of what is hashed.
+func (f *fingerprinter) copy(name string) (string, error) {
+	if c, ok := f.copies[name]; ok {
+		return c, nil
+	}
+	full := filepath.Join(f.dir, filepath.FromSlash(name))
+	b, err := os.ReadFile(full)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(path.Ext(name), ".css") {
+		f.doing[name] = true
+		b, err = f.rewrite(name, b, cssRef)
+		delete(f.doing, name)
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(b)
+	ext := path.Ext(name)
+	c := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+	cfull := filepath.Join(f.dir, filepath.FromSlash(c))
+	if _, err := os.Stat(cfull); errors.Is(err, fs.ErrNotExist) {
+		if err := os.WriteFile(cfull, b, 0o644); err != nil {
+			return "", err
+		}
+		debugf("fingerprint: %s -> %s", name, c)
+	} else if err != nil {
+		return "", err
+	}
+	f.copies[name] = c
+	return c, nil
+}
+
+// rewritePage rewrites the references of the page name, and writes it
+// back if any changed.
+func (f *fingerprinter) rewritePage(name string) error {
+	full := filepath.Join(f.dir, filepath.FromSlash(name))
+	b, err := os.ReadFile(full)
+	if err != nil {
+		return err
+	}
+	out, err := f.rewrite(name, b, htmlRef)
+	if err != nil {
+		return err
+	}
+	// Style elements and attributes have references of their own.
+	if out, err = f.rewrite(name, out, cssRef); err != nil {
+		return err
+	}
+	if string(out) == string(b) {
+		return nil
+	}
+	fi, err := os.Stat(full)
+	if err != nil {
+		return err
+	}
+	debugf("fingerprint: rewrote %s", name)
+	return os.WriteFile(full, out, fi.Mode().Perm())
+}
+
+// rewrite returns b, the file name, with the references re matches
+// that are to assets, or to the copies of the last run, changed to the
+// copies of the assets.
+func (f *fingerprinter) rewrite(name string, b []byte, re *regexp.Regexp) ([]byte, error) {
+	var failed error
+	out := re.ReplaceAllFunc(b, func(m []byte) []byte {
+		sub := re.FindSubmatch(m)
+		prefix, ref := string(sub[1]), string(sub[2])
+		quote := ""
+		if ref[0] == '"' || ref[0] == '\'' {
+			quote, ref = ref[:1], ref[1:len(ref)-1]
+		}
+		target, rest := f.resolve(name, ref)
+		if target == "" || f.doing[target] {
+			return m
+		}
+		c, err := f.copy(target)
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			return m
+		}
+		file := strings.TrimSuffix(ref, rest)
+		file = file[:strings.LastIndex(file, "/")+1] + path.Base(c)
+		return []byte(prefix + quote + file + rest + quote)
+	})
+	return out, failed
+}
+
+// resolve returns the asset the reference ref in the file name is to,
+// or "" if it is to none, and the query or fragment that follows the
+// path of ref.
+func (f *fingerprinter) resolve(name, ref string) (asset, rest string) {
+	p, rest, ok := localRef(name, ref)
+	if !ok {
+		return "", ""
+	}
+	if orig, ok := f.made[p]; ok {
+		p = orig
+	}
+	i := sort.SearchStrings(f.assets, p)
+	if i == len(f.assets) || f.assets[i] != p {
+		return "", ""
+	}
+	return p, rest
+}
+
+// localRef returns the slash separated path, from the top of the site,
+// of the file the reference ref in the file name is to, and the query
+// or fragment that follows the path of ref. It reports false for a
+// reference to another site, or to no file.
+func localRef(name, ref string) (p, rest string, ok bool) {
+	if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") {
+		return "", "", false
+	}
+	p = ref
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p, rest = p[:i], p[i:]
+	}
+	if strings.HasPrefix(p, "/") {
+		p = path.Clean(p)[1:]
+	} else {
+		p = path.Join(path.Dir(name), p)
+	}
+	if p == "" || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", "", false
+	}
+	return p, rest, true
+}
+
+// assetManifest is a manifest Fingerprint wrote, read again when it
+// changes, that finds the copies of assets for .tmpl pages and marks
+// the copies as never changing.
+type assetManifest struct {
+	data *siteData
+}
+
+// loadManifest reads the manifest file name.
+func loadManifest(name string) (*assetManifest, error) {
+	d, err := loadSiteData(name)
+	if err != nil {
+		return nil, err
+	}
+	d.kind = "manifest"
+	return &assetManifest{d}, nil
+}
+
+// lookup returns the URL path of the copy of the asset at the URL path
+// p, or p if the manifest has none.
+func (m *assetManifest) lookup(p string) string {
+	if m == nil {
+		return p
+	}
+	copies, _ := m.data.get().(map[string]any)
+	rel := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if c, ok := copies[rel].(string); ok {
+		if strings.HasPrefix(p, "/") {
+			return "/" + c
+		}
+		return path.Join(path.Dir(p), path.Base(c))
+	}
+	return p
+}
+
+// isCopy reports whether the URL path p is of a copy the manifest has.
+func (m *assetManifest) isCopy(p string) bool {
+	sub := hashedName.FindStringSubmatch(p)
+	if sub == nil {
+		return false
+	}
+	copies, _ := m.data.get().(map[string]any)
+	rel := strings.TrimPrefix(p, "/")
+	c, _ := copies[strings.TrimPrefix(sub[1]+sub[2], "/")].(string)
+	return c == rel
+}
+
+// immutableHandler serves the copies a manifest has to be cached for a
+// year without being checked again, as their content is in their names,
+// and passes every request to next.
+type immutableHandler struct {
+	manifest *assetManifest
+	next     http.Handler
+}
+
+// ServeHTTP serves r with next, as never changing if it is for a copy.
+func (h immutableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.manifest.isCopy(path.Clean("/" + r.URL.Path)) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// ManifestResolves are the values of Config.ManifestResolve.
+var ManifestResolves = []string{"serve", "redirect"}
+
+// resolveHandler answers the requests for the assets a manifest has a
+// copy of with the copy: served in place, checked again on every use as
+// the asset's name stays the same from one build to the next, or with a
+// redirect to the copy, which is cached for good. Every other request is
+// passed to next.
+type resolveHandler struct {
+	manifest *assetManifest
+	redirect bool
+	next     http.Handler
+}
+
+// ServeHTTP serves r with the copy of its asset, if it has one.
+func (h resolveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	c := h.manifest.lookup(name)
+	if c == name || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if h.redirect {
+		debugf("manifest: %s: redirecting to %s", name, c)
+		to := path.Join(path.Dir(r.URL.Path), path.Base(c))
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusFound)
+		return
+	}
+	debugf("manifest: %s: serving %s", name, c)
+	r2 := r.Clone(r.Context())
+	r2.URL.Path, r2.URL.RawPath = c, ""
+	h.next.ServeHTTP(w, r2)
+}
@@ -0,0 +1,100 @@
+package staticserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// foldFS is an http.FileSystem that resolves names that do not exist
+// exactly as given by comparing each path element with the entries of
+// its directory after passing both through fold. Folding with
+// strings.ToLower, for example, lets /ReadMe.HTML be served from
+// readme.html. Directory scans are cached and rescanned whenever a
+// directory's modification time changes.
+type foldFS struct {
+	http.FileSystem
+	fold func(string) string
+
+	mu   sync.Mutex
+	dirs map[string]foldDir
+}
+
+// foldDir is a cached scan of a directory, mapping each folded entry
+// name to the name as it exists on disk.
+type foldDir struct {
+	modTime time.Time
+	names   map[string]string
+}
+
+// newFoldFS returns a foldFS wrapping fsys that compares names with fold.
+func newFoldFS(fsys http.FileSystem, fold func(string) string) *foldFS {
+	return &foldFS{FileSystem: fsys, fold: fold, dirs: map[string]foldDir{}}
+}
+
+// Open opens name exactly if possible, and otherwise opens the file whose
+// path matches name when compared after folding.
+func (c *foldFS) Open(name string) (http.File, error) {
+	f, err := c.FileSystem.Open(name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	resolved, ok := c.resolve(name)
+	if !ok {
+		return nil, err
+	}
+	debugf("fold: %s: opening %s", name, resolved)
+	return c.FileSystem.Open(resolved)
+}
+
+// resolve returns the path of the file whose path matches name when
+// compared after folding, and whether there is one.
+func (c *foldFS) resolve(name string) (string, bool) {
+	resolved := "/"
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		actual, ok := c.lookup(resolved, part)
+		if !ok {
+			return "", false
+		}
+		resolved = path.Join(resolved, actual)
+	}
+	return resolved, true
+}
+
+// lookup returns the on-disk name of the entry in dir whose folded
+// name matches the folded name.
+func (c *foldFS) lookup(dir, name string) (string, bool) {
+	d, err := c.FileSystem.Open(dir)
+	if err != nil {
+		return "", false
+	}
+	defer d.Close()
+	fi, err := d.Stat()
+	if err != nil || !fi.IsDir() {
+		return "", false
+	}
+
+	c.mu.Lock()
+	cached, ok := c.dirs[dir]
+	c.mu.Unlock()
+	if !ok || !cached.modTime.Equal(fi.ModTime()) {
+		entries, err := d.Readdir(-1)
+		if err != nil {
+			return "", false
+		}
+		cached = foldDir{modTime: fi.ModTime(), names: make(map[string]string, len(entries))}
+		for _, e := range entries {
+			cached.names[c.fold(e.Name())] = e.Name()
+		}
+		c.mu.Lock()
+		c.dirs[dir] = cached
+		c.mu.Unlock()
+	}
+
+	actual, ok := cached.names[c.fold(name)]
+	return actual, ok
+}
@@ -0,0 +1,146 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// formHandler accepts form submissions POSTed as url-encoded or multipart
+// bodies of at most maxSize bytes. Each one is logged, forwarded to the
+// webhook and appended to the save file when those are set, and then the
+// client is redirected to next. The save file is written as CSV when its
+// name ends in .csv and as one JSON object per line otherwise.
+type formHandler struct {
+	next    string // the URL clients are redirected to after submitting
+	save    string // the file submissions are appended to, if any
+	webhook string // the URL submissions are POSTed to as JSON, if any
+	maxSize int64
+
+	mu sync.Mutex // serializes appends to save
+}
+
+// submission is the JSON form of a form submission.
+type submission struct {
+	Time   string              `json:"time"`
+	Remote string              `json:"remote"`
+	Form   map[string][]string `json:"form"`
+}
+
+// webhookClient is used to forward submissions to webhooks.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// ServeHTTP records the submitted form and redirects to h.next.
+func (h *formHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize)
+	var err error
+	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
+	case "application/x-www-form-urlencoded":
+		err = r.ParseForm()
+	case "multipart/form-data":
+		err = r.ParseMultipartForm(h.maxSize)
+	default:
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	infof("form: %v", r.PostForm)
+
+	sub := submission{time.Now().UTC().Format(time.RFC3339), r.RemoteAddr, r.PostForm}
+	if h.webhook != "" {
+		if err := h.forward(sub); err != nil {
+			log.Println("forwarding form:", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+	}
+	if h.save != "" {
+		if err := h.append(sub); err != nil {
+			log.Println("saving form:", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.next == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Thank you, your submission has been received.\n"))
+		return
+	}
+	http.Redirect(w, r, h.next, http.StatusSeeOther)
+}
+
+// forward POSTs sub to h.webhook as JSON.
+func (h *formHandler) forward(sub submission) error {
+	body, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(h.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// append writes sub to the end of h.save.
+// CSV rows hold the time and client address followed by each field
+// name and value in turn, with the fields sorted by name.
+func (h *formHandler) append(sub submission) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.save, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if filepath.Ext(h.save) != ".csv" {
+		return json.NewEncoder(f).Encode(sub)
+	}
+
+	names := make([]string, 0, len(sub.Form))
+	for name := range sub.Form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	row := []string{sub.Time, sub.Remote}
+	for _, name := range names {
+		for _, v := range sub.Form[name] {
+			row = append(row, name, v)
+		}
+	}
+	cw := csv.NewWriter(f)
+	cw.Write(row)
+	cw.Flush()
+	return cw.Error()
+}
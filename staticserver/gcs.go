@@ -0,0 +1,358 @@
+package staticserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCS says where the objects of a Google Cloud Storage bucket are.
+// Requests are authorized with Google's application default
+// credentials: the file named by GOOGLE_APPLICATION_CREDENTIALS, the
+// one gcloud auth application-default login writes, or the metadata
+// server of the instance the server runs on, whichever is found first.
+// Requests are anonymous if there are none.
+type GCS struct {
+	Bucket   string
+	Prefix   string // the name prefix of the objects to serve, if any
+	Endpoint string // the URL of an emulator or other compatible service, or Google if empty
+	Cache    ObjectCache
+}
+
+// NewGCSFS returns an fs.FS of the objects in the bucket, to be set as
+// the FS of a Config.
+func NewGCSFS(g GCS) (fs.FS, error) {
+	if g.Bucket == "" {
+		return nil, errors.New("gcs: no bucket")
+	}
+	store := &gcsStore{bucket: g.Bucket, base: "https://storage.googleapis.com"}
+	if g.Endpoint != "" {
+		u, err := parseUpstream(g.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		store.base = strings.TrimSuffix(u.String(), "/")
+	}
+	var err error
+	if store.token, err = findGoogleCredentials(); err != nil {
+		return nil, err
+	}
+	return newObjectFS(store, g.Prefix, g.Cache)
+}
+
+// gcsStore is the objectStore of a GCS bucket, read with the JSON API.
+type gcsStore struct {
+	bucket string
+	base   string        // the URL of the API, without a trailing slash
+	token  *googleTokens // authorizes requests, if non-nil
+}
+
+// gcsObject is the JSON resource of an object.
+type gcsObject struct {
+	Name    string
+	Size    string // an int64, encoded as a string
+	Updated time.Time
+}
+
+func (o gcsObject) info() objectInfo {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return objectInfo{key: o.Name, size: size, modTime: o.Updated}
+}
+
+// do sends an authorized GET for the API path, with query, and returns
+// the response if its status is one of ok.
+func (s *gcsStore) do(apiPath string, query url.Values, header http.Header, ok ...int) (*http.Response, error) {
+	u := s.base + apiPath
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.token != nil {
+		token, err := s.token.get()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return nil, fs.ErrPermission
+	}
+	var e struct{ Error struct{ Message string } }
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	return nil, fmt.Errorf("gcs: GET %s: %s %s", apiPath, resp.Status, e.Error.Message)
+}
+
+// objectPath returns the API path of the object named key.
+func (s *gcsStore) objectPath(key string) string {
+	return "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(key)
+}
+
+func (s *gcsStore) stat(key string) (objectInfo, error) {
+	resp, err := s.do(s.objectPath(key), url.Values{"fields": {"name,size,updated"}}, nil, http.StatusOK)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	defer resp.Body.Close()
+	var o gcsObject
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return objectInfo{}, fmt.Errorf("gcs: %s: %v", key, err)
+	}
+	return o.info(), nil
+}
+
+func (s *gcsStore) get(key string, off int64) (io.ReadCloser, error) {
+	h := http.Header{}
+	if off > 0 {
+		h.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-")
+	}
+	resp, err := s.do(s.objectPath(key), url.Values{"alt": {"media"}}, h, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *gcsStore) list(prefix string) (objects []objectInfo, prefixes []string, err error) {
+	q := url.Values{"prefix": {prefix}, "delimiter": {"/"}, "fields": {"items(name,size,updated),prefixes,nextPageToken"}}
+	for {
+		resp, err := s.do("/storage/v1/b/"+url.PathEscape(s.bucket)+"/o", q, nil, http.StatusOK)
+		if err != nil {
+			return nil, nil, err
+		}
+		var page struct {
+			Items         []gcsObject
+			Prefixes      []string
+			NextPageToken string
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("gcs: listing %s: %v", prefix, err)
+		}
+		for _, o := range page.Items {
+			objects = append(objects, o.info())
+		}
+		prefixes = append(prefixes, page.Prefixes...)
+		if page.NextPageToken == "" {
+			return objects, prefixes, nil
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// gcsScope is the OAuth scope of read-only access to GCS.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// googleTokens hands out OAuth access tokens, fetching a new one with
+// fetch shortly before the last expires.
+type googleTokens struct {
+	fetch func() (token string, expiresIn int64, err error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// get returns a current access token.
+func (t *googleTokens) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expiry) {
+		return t.token, nil
+	}
+	token, expiresIn, err := t.fetch()
+	if err != nil {
+		return "", fmt.Errorf("gcs: fetching an access token: %v", err)
+	}
+	t.token, t.expiry = token, time.Now().Add(time.Duration(expiresIn)*time.Second-time.Minute)
+	return token, nil
+}
+
+// googleCredentials is the JSON of a credentials file, of either a
+// service account or a user.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// findGoogleCredentials returns the token source of the application
+// default credentials, or nil if there are none.
+func findGoogleCredentials() (*googleTokens, error) {
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if file == "" {
+		// Where gcloud auth application-default login writes them.
+		name := filepath.Join(os.Getenv("HOME"), ".config", "gcloud", "application_default_credentials.json")
+		if runtime.GOOS == "windows" {
+			name = filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+		}
+		if _, err := os.Stat(name); err == nil {
+			file = name
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var c googleCredentials
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("gcs: %s: %v", file, err)
+		}
+		return c.tokens()
+	}
+	if host := metadataHost(); host != "" {
+		return &googleTokens{fetch: func() (string, int64, error) {
+			req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+			req.Header.Set("Metadata-Flavor", "Google")
+			return fetchToken(req)
+		}}, nil
+	}
+	return nil, nil
+}
+
+// metadataHost returns the address of the instance metadata server, or
+// "" if the server is not running on Google Cloud.
+func metadataHost() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return host
+	}
+	conn, err := net.DialTimeout("tcp", "metadata.google.internal:80", time.Second)
+	if err != nil {
+		return ""
+	}
+	conn.Close()
+	return "metadata.google.internal"
+}
+
+// tokens returns the token source of the credentials.
+func (c googleCredentials) tokens() (*googleTokens, error) {
+	switch c.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(c.PrivateKey))
+		if block == nil {
+			return nil, errors.New("gcs: service account has no private key")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("gcs: service account key: %v", err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("gcs: service account key is not RSA")
+		}
+		tokenURI := c.TokenURI
+		if tokenURI == "" {
+			tokenURI = "https://oauth2.googleapis.com/token"
+		}
+		return &googleTokens{fetch: func() (string, int64, error) {
+			assertion, err := signJWT(key, c.ClientEmail, tokenURI)
+			if err != nil {
+				return "", 0, err
+			}
+			return postToken(tokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}}, nil
+	case "authorized_user":
+		return &googleTokens{fetch: func() (string, int64, error) {
+			return postToken("https://oauth2.googleapis.com/token", url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {c.ClientID},
+				"client_secret": {c.ClientSecret},
+				"refresh_token": {c.RefreshToken},
+			})
+		}}, nil
+	}
+	return nil, fmt.Errorf("gcs: unsupported credentials type %q", c.Type)
+}
+
+// signJWT returns the RS256 signed assertion a service account exchanges
+// for an access token at aud.
+func signJWT(key *rsa.PrivateKey, email, aud string) (string, error) {
+	now := time.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{"iss": email, "scope": gcsScope, "aud": aud, "iat": now, "exp": now + 3600})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// postToken exchanges form for an access token at the OAuth token URL.
+func postToken(tokenURL string, form url.Values) (string, int64, error) {
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(req)
+}
+
+// fetchToken sends req and decodes the access token it responds with.
+func fetchToken(req *http.Request) (string, int64, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s responded %s", req.URL.Host, resp.Status)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, err
+	}
+	return t.AccessToken, t.ExpiresIn, nil
+}
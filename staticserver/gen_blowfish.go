@@ -0,0 +1,93 @@
+//go:build ignore
+
+// This program generates blowfish_tables.go, the initial P-array and
+// S-boxes of Blowfish used by bcrypt, which are the hex digits of the
+// fraction of pi. Run it from this directory with:
+//
+//	go run gen_blowfish.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"math/big"
+	"os"
+)
+
+func main() {
+	const words = 18 + 4*256
+	bits := uint(32*words + 64) // the guard bits absorb the rounding of the series
+	// pi = 16 atan(1/5) - 4 atan(1/239), by Machin's formula.
+	pi := new(big.Int).Mul(big.NewInt(16), atanInv(5, bits))
+	pi.Sub(pi, new(big.Int).Mul(big.NewInt(4), atanInv(239, bits)))
+	frac := pi.Sub(pi, new(big.Int).Lsh(big.NewInt(3), bits))
+	frac.Rsh(frac, 64)
+	w := make([]uint32, words)
+	mask := big.NewInt(0xffffffff)
+	for i := words - 1; i >= 0; i-- {
+		w[i] = uint32(new(big.Int).And(frac, mask).Uint64())
+		frac.Rsh(frac, 32)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by gen_blowfish.go; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package staticserver")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// blowfishP is the initial P-array of Blowfish.")
+	table(&buf, "blowfishP", "[18]uint32", w[:18])
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// blowfishS are the initial S-boxes of Blowfish.")
+	fmt.Fprintln(&buf, "var blowfishS = [4][256]uint32{")
+	for i := range 4 {
+		fmt.Fprintln(&buf, "{")
+		rows(&buf, w[18+256*i:18+256*(i+1)])
+		fmt.Fprintln(&buf, "},")
+	}
+	fmt.Fprintln(&buf, "}")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("blowfish_tables.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// atanInv returns atan(1/x) in fixed point with bits fraction bits.
+func atanInv(x int64, bits uint) *big.Int {
+	sum := new(big.Int)
+	xx := big.NewInt(x * x)
+	power := new(big.Int).Lsh(big.NewInt(1), bits)
+	power.Quo(power, big.NewInt(x)) // 1/x^(2k+1)
+	term := new(big.Int)
+	for k := int64(0); power.Sign() > 0; k++ {
+		term.Quo(power, big.NewInt(2*k+1))
+		if k%2 == 0 {
+			sum.Add(sum, term)
+		} else {
+			sum.Sub(sum, term)
+		}
+		power.Quo(power, xx)
+	}
+	return sum
+}
+
+func table(buf *bytes.Buffer, name, typ string, w []uint32) {
+	fmt.Fprintf(buf, "var %s = %s{\n", name, typ)
+	rows(buf, w)
+	fmt.Fprintln(buf, "}")
+}
+
+func rows(buf *bytes.Buffer, w []uint32) {
+	for i, v := range w {
+		fmt.Fprintf(buf, "%#08x,", v)
+		if i%6 == 5 || i == len(w)-1 {
+			fmt.Fprintln(buf)
+		} else {
+			fmt.Fprint(buf, " ")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//go:build ignore
+
+// This program generates unicode_tables.go, the canonical decomposition
+// and combining class tables used by nfd, from the Unicode Character
+// Database. Run it from this directory with:
+//
+//	go run gen_unicode.go UnicodeData.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run gen_unicode.go UnicodeData.txt")
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	decomp := map[rune][]rune{}
+	classes := map[rune]int{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ";")
+		if len(fields) < 6 {
+			continue
+		}
+		r := parseRune(fields[0])
+		if ccc, _ := strconv.Atoi(fields[3]); ccc != 0 {
+			classes[r] = ccc
+		}
+		// Decompositions starting with a <tag> are compatibility
+		// mappings and play no part in canonical equivalence.
+		if d := fields[5]; d != "" && !strings.HasPrefix(d, "<") {
+			for _, cp := range strings.Fields(d) {
+				decomp[r] = append(decomp[r], parseRune(cp))
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	runes := make([]rune, 0, len(decomp))
+	for r := range decomp {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by gen_unicode.go; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package staticserver")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// decompositions maps runes to their full canonical decomposition.")
+	fmt.Fprintln(&buf, "var decompositions = map[rune]string{")
+	for _, r := range runes {
+		fmt.Fprintf(&buf, "%#04x: %+q,\n", r, string(expand(decomp, r)))
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+
+	runes = runes[:0]
+	for r := range classes {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	fmt.Fprintln(&buf, "// combiningClasses maps combining runes to their canonical combining class.")
+	fmt.Fprintln(&buf, "var combiningClasses = map[rune]uint8{")
+	for _, r := range runes {
+		fmt.Fprintf(&buf, "%#04x: %d,\n", r, classes[r])
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("unicode_tables.go", src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseRune parses a hexadecimal code point.
+func parseRune(s string) rune {
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		log.Fatalf("invalid code point %q", s)
+	}
+	return rune(n)
+}
+
+// expand returns the decomposition of r with every rune in it
+// recursively decomposed in turn.
+func expand(decomp map[rune][]rune, r rune) []rune {
+	d, ok := decomp[r]
+	if !ok {
+		return []rune{r}
+	}
+	var out []rune
+	for _, c := range d {
+		out = append(out, expand(decomp, c)...)
+	}
+	return out
+}
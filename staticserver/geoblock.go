@@ -0,0 +1,93 @@
+package staticserver
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// geoBlock refuses the requests of clients outside the allowed countries
+// or inside the denied ones, as located in a MaxMind DB. Clients with
+// loopback, private or link-local addresses are never located, and are
+// always served.
+type geoBlock struct {
+	geo         *geoDB
+	allow       []string // the country codes served, or every one if empty
+	deny        []string // the country codes refused
+	status      int
+	page        []byte
+	contentType string
+}
+
+// newGeoBlock returns the restriction of cfg on the clients geo locates,
+// with its page read now.
+func newGeoBlock(cfg Config, geo *geoDB) (*geoBlock, error) {
+	g := &geoBlock{geo: geo, status: cfg.GeoStatus, contentType: "text/plain; charset=utf-8"}
+	for _, c := range cfg.GeoAllow {
+		g.allow = append(g.allow, strings.ToUpper(strings.TrimSpace(c)))
+	}
+	for _, c := range cfg.GeoDeny {
+		g.deny = append(g.deny, strings.ToUpper(strings.TrimSpace(c)))
+	}
+	if g.status == 0 {
+		g.status = http.StatusForbidden
+	} else if g.status < 400 || g.status > 599 {
+		return nil, fmt.Errorf("geo status %d is not an error status", g.status)
+	}
+	g.page = []byte(http.StatusText(g.status) + "\n")
+	if cfg.GeoPage != "" {
+		b, err := os.ReadFile(cfg.GeoPage)
+		if err != nil {
+			return nil, err
+		}
+		g.page = b
+		if ct := mime.TypeByExtension(filepath.Ext(cfg.GeoPage)); ct != "" {
+			g.contentType = ct
+		} else {
+			g.contentType = http.DetectContentType(b)
+		}
+	}
+	return g, nil
+}
+
+// refused reports whether the client address ip is refused, and the
+// country it is located in.
+func (g *geoBlock) refused(ip string) (bool, string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, ""
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() {
+		return false, ""
+	}
+	country, _ := g.geo.lookup(addr.String())
+	if len(g.allow) > 0 && !slices.Contains(g.allow, country) {
+		return true, country
+	}
+	return slices.Contains(g.deny, country), country
+}
+
+// handler returns next with the requests of refused clients answered
+// with the status and page.
+func (g *geoBlock) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if refused, country := g.refused(ip); refused {
+			debugf("geo: refusing %s in %q", ip, country)
+			w.Header().Set("Content-Type", g.contentType)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(g.status)
+			if r.Method != http.MethodHead {
+				w.Write(g.page)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,319 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// geoDB looks up the location of IP addresses in a MaxMind DB file, such
+// as GeoLite2-City.mmdb or GeoLite2-Country.mmdb. The whole file is read
+// into memory; lookups walk the binary search tree and decode only the
+// record found.
+type geoDB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint // bits per record: 24, 28 or 32
+	ipVersion  uint
+	dataStart  uint // offset of the data section in buf
+	ipv4Start  uint // the node reached by the 96 leading zero bits of an IPv4 address
+}
+
+// mmdbMetadataMarker precedes the metadata map at the end of the file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// openGeoDB reads the MaxMind DB in the named file.
+func openGeoDB(name string) (*geoDB, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", name)
+	}
+	d := mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %v", name, err)
+	}
+	meta, _ := v.(map[string]any)
+	db := &geoDB{
+		buf:        buf,
+		nodeCount:  uint(mmdbUint(meta["node_count"])),
+		recordSize: uint(mmdbUint(meta["record_size"])),
+		ipVersion:  uint(mmdbUint(meta["ip_version"])),
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s: unsupported record size %d", name, db.recordSize)
+	}
+	db.dataStart = db.nodeCount*db.recordSize/4 + 16
+	if db.nodeCount > uint(i) || db.dataStart > uint(i) {
+		return nil, fmt.Errorf("%s: search tree is larger than the file", name)
+	}
+	if db.ipVersion == 6 {
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *geoDB) record(node, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the ISO country code and English city name recorded for
+// ip, either of which may be empty.
+func (db *geoDB) lookup(ip string) (country, city string) {
+	rec := db.locate(ip)
+	country, _ = mmdbPath(rec, "country", "iso_code").(string)
+	city, _ = mmdbPath(rec, "city", "names", "en").(string)
+	return country, city
+}
+
+// region returns the ISO country code and the continent code, such as
+// EU, recorded for ip, either of which may be empty.
+func (db *geoDB) region(ip string) (country, continent string) {
+	rec := db.locate(ip)
+	country, _ = mmdbPath(rec, "country", "iso_code").(string)
+	continent, _ = mmdbPath(rec, "continent", "code").(string)
+	return country, continent
+}
+
+// locate returns the record of ip, or nil if there is none.
+func (db *geoDB) locate(ip string) map[string]any {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	node, bits := uint(0), addr.AsSlice()
+	if addr.Is4() {
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil
+	}
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return nil // no data for the address
+	}
+	d := mmdbDecoder{buf: db.buf[db.dataStart:]}
+	v, _, err := d.decode(node - db.nodeCount - 16)
+	if err != nil {
+		return nil
+	}
+	rec, _ := v.(map[string]any)
+	return rec
+}
+
+// mmdbPath follows keys through nested maps.
+func mmdbPath(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// mmdbUint returns v as an integer if it is one of the unsigned types the
+// decoder produces.
+func mmdbUint(v any) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
+
+// mmdbDecoder decodes values from a MaxMind DB data section. As the
+// pointers of a corrupt or hostile file can loop, or refer again and
+// again to the same big map, it gives up past maxMMDBDepth levels of
+// nesting or maxMMDBValues values.
+type mmdbDecoder struct {
+	buf    []byte
+	values int // the values decoded so far
+}
+
+const (
+	maxMMDBDepth  = 32
+	maxMMDBValues = 1 << 16
+)
+
+var errMMDBCorrupt = errors.New("corrupt MaxMind DB data")
+
+// decode returns the value at off and the offset just past it.
+func (d *mmdbDecoder) decode(off uint) (any, uint, error) {
+	return d.value(off, 0)
+}
+
+// value decodes the value at off, nested depth levels deep.
+func (d *mmdbDecoder) value(off uint, depth int) (any, uint, error) {
+	if d.values++; d.values > maxMMDBValues || depth > maxMMDBDepth {
+		return nil, 0, errMMDBCorrupt
+	}
+	if off >= uint(len(d.buf)) {
+		return nil, 0, errMMDBCorrupt
+	}
+	ctrl := d.buf[off]
+	off++
+	typ := uint(ctrl >> 5)
+	if typ == 1 { // pointer
+		ptr, next, err := d.pointer(ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.value(ptr, depth+1)
+		return v, next, err
+	}
+	if typ == 0 { // extended
+		if off >= uint(len(d.buf)) {
+			return nil, 0, errMMDBCorrupt
+		}
+		typ = 7 + uint(d.buf[off])
+		off++
+	}
+	size, off, err := d.size(ctrl, off)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Every entry takes a byte at least, which bounds what to allocate.
+	room := uint(len(d.buf)) - min(off, uint(len(d.buf)))
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, min(size, room))
+		for ; size > 0; size-- {
+			k, next, err := d.value(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := d.value(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, _ := k.(string)
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case 11: // array
+		a := make([]any, 0, min(size, room))
+		for ; size > 0; size-- {
+			v, next, err := d.value(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case 14: // boolean, stored in the size
+		return size != 0, off, nil
+	}
+
+	if off+size > uint(len(d.buf)) {
+		return nil, 0, errMMDBCorrupt
+	}
+	b := d.buf[off : off+size]
+	off += size
+	switch typ {
+	case 2: // UTF-8 string
+		return string(b), off, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 8: // int32
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(n)), off, nil
+		}
+		return int64(n), off, nil
+	default: // bytes, uint128 and anything unknown
+		return b, off, nil
+	}
+}
+
+// size returns the payload size encoded in ctrl and the bytes after it.
+func (d *mmdbDecoder) size(ctrl byte, off uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, off, nil
+	}
+	n := size - 28
+	if off+n > uint(len(d.buf)) {
+		return 0, 0, errMMDBCorrupt
+	}
+	var v uint
+	for _, c := range d.buf[off : off+n] {
+		v = v<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		return 29 + v, off + n, nil
+	case 30:
+		return 285 + v, off + n, nil
+	default:
+		return 65821 + v, off + n, nil
+	}
+}
+
+// pointer returns the offset a pointer refers to and the offset just
+// past it.
+func (d *mmdbDecoder) pointer(ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3&3) + 1
+	if off+n > uint(len(d.buf)) {
+		return 0, 0, errMMDBCorrupt
+	}
+	var v uint
+	if n < 4 {
+		v = uint(ctrl & 7)
+	}
+	for _, c := range d.buf[off : off+n] {
+		v = v<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, off + n, nil
+}
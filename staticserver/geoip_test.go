@@ -0,0 +1,243 @@
+package staticserver
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// mmdbCtrl returns the control byte and size bytes of a value of typ
+// with a payload of size.
+func mmdbCtrl(typ, size int) []byte {
+	var b []byte
+	first := byte(typ << 5)
+	if typ > 7 {
+		first = 0
+	}
+	switch {
+	case size < 29:
+		b = []byte{first | byte(size)}
+	case size < 285:
+		b = []byte{first | 29, byte(size - 29)}
+	case size < 65821:
+		b = []byte{first | 30, byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		n := size - 65821
+		b = []byte{first | 31, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	if typ > 7 {
+		b = slices.Insert(b, 1, byte(typ-7))
+	}
+	return b
+}
+
+// mmdbEncode encodes v as the data section of a MaxMind DB does.
+func mmdbEncode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbCtrl(2, len(v)), v...)
+	case float64:
+		return binary.BigEndian.AppendUint64(mmdbCtrl(3, 8), math.Float64bits(v))
+	case []byte:
+		return append(mmdbCtrl(4, len(v)), v...)
+	case uint16:
+		return binary.BigEndian.AppendUint16(mmdbCtrl(5, 2), v)
+	case uint32:
+		return binary.BigEndian.AppendUint32(mmdbCtrl(6, 4), v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		b := mmdbCtrl(7, len(v))
+		for _, k := range keys {
+			b = append(b, mmdbEncode(k)...)
+			b = append(b, mmdbEncode(v[k])...)
+		}
+		return b
+	case int32:
+		return binary.BigEndian.AppendUint32(mmdbCtrl(8, 4), uint32(v))
+	case uint64:
+		return binary.BigEndian.AppendUint64(mmdbCtrl(9, 8), v)
+	case []any:
+		b := mmdbCtrl(11, len(v))
+		for _, e := range v {
+			b = append(b, mmdbEncode(e)...)
+		}
+		return b
+	case bool:
+		if v {
+			return mmdbCtrl(14, 1)
+		}
+		return mmdbCtrl(14, 0)
+	}
+	panic("mmdbEncode: unsupported type")
+}
+
+func TestMMDBDecode(t *testing.T) {
+	long := string(make([]byte, 300))
+	tests := []struct {
+		name string
+		buf  []byte
+		want any // nil for an error
+	}{
+		{"string", mmdbEncode("Berlin"), "Berlin"},
+		{"empty string", mmdbEncode(""), ""},
+		{"long string", mmdbEncode(long), long},
+		{"double", mmdbEncode(2.5), 2.5},
+		{"float", []byte{0x04, 8, 0x3f, 0xc0, 0, 0}, 1.5},
+		{"uint16", mmdbEncode(uint16(443)), uint64(443)},
+		{"uint32", mmdbEncode(uint32(1 << 31)), uint64(1 << 31)},
+		{"uint64", mmdbEncode(uint64(math.MaxUint64)), uint64(math.MaxUint64)},
+		{"short uint32", []byte{0xc1, 7}, uint64(7)},
+		{"int32", mmdbEncode(int32(-5)), int64(-5)},
+		{"short int32", []byte{1, 1, 0xff}, int64(255)},
+		{"true", mmdbEncode(true), true},
+		{"false", mmdbEncode(false), false},
+		{"bytes", mmdbEncode([]byte{1, 2}), []byte{1, 2}},
+		{"array", mmdbEncode([]any{"a", uint16(1)}), []any{"a", uint64(1)}},
+		{"map", mmdbEncode(map[string]any{"a": map[string]any{"b": "c"}}), map[string]any{"a": map[string]any{"b": "c"}}},
+		// A pointer (0x20) to the string after it, at offset 2.
+		{"pointer", append([]byte{0x20, 2}, mmdbEncode("x")...), "x"},
+		{"empty", nil, nil},
+		{"truncated string", []byte{0x45, 'a', 'b'}, nil},
+		{"truncated size", []byte{0x5d}, nil},
+		{"truncated extended type", []byte{0x01}, nil},
+		{"truncated pointer", []byte{0x28, 0}, nil},
+		{"pointer past the end", []byte{0x20, 9}, nil},
+		{"pointer to itself", []byte{0x20, 0}, nil},
+		{"map missing its values", append(mmdbCtrl(7, 2), mmdbEncode("a")...), nil},
+		{"huge array", mmdbCtrl(11, 1<<24), nil},
+		{"double of the wrong size", []byte{0x64, 0, 0, 0, 0}, nil},
+		{"float of the wrong size", []byte{0x02, 8, 0, 0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := mmdbDecoder{buf: tt.buf}
+			got, _, err := d.decode(0)
+			switch {
+			case tt.want == nil && err == nil:
+				t.Errorf("decode(% x) = %#v, want an error", tt.buf, got)
+			case tt.want != nil && err != nil:
+				t.Errorf("decode(% x): %v", tt.buf, err)
+			case tt.want != nil && !reflect.DeepEqual(got, tt.want):
+				t.Errorf("decode(% x) = %#v, want %#v", tt.buf, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMMDBDecodeBlowup(t *testing.T) {
+	// Each array holds 16 pointers to the one before it, so that the
+	// last stands for 16^8 values held in a few hundred bytes.
+	buf := mmdbEncode("x")
+	prev := 0
+	for range 8 {
+		start := len(buf)
+		buf = append(buf, mmdbCtrl(11, 16)...)
+		for range 16 {
+			buf = append(buf, 0x28, byte(prev>>8), byte(prev))
+		}
+		prev = start
+	}
+	d := mmdbDecoder{buf: buf}
+	if _, _, err := d.decode(uint(prev)); err == nil {
+		t.Error("decoded 16^8 values, want an error")
+	}
+}
+
+// writeTestGeoDB writes a MaxMind DB of IPv4 addresses with recordSize
+// bits per record, in which 0.0.0.0/1 has rec and 128.0.0.0/1 nothing.
+func writeTestGeoDB(t *testing.T, recordSize int, rec map[string]any) string {
+	t.Helper()
+	const nodes = 1
+	data := nodes + 16 // the record that points at the data section
+	var tree []byte
+	switch recordSize {
+	case 24:
+		tree = []byte{byte(data >> 16), byte(data >> 8), byte(data), 0, 0, nodes}
+	case 28:
+		tree = []byte{byte(data >> 16), byte(data >> 8), byte(data), 0, 0, 0, nodes}
+	case 32:
+		tree = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(data)), nodes)
+	}
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, mmdbEncode(rec)...)
+	buf = append(buf, mmdbMetadataMarker...)
+	buf = append(buf, mmdbEncode(map[string]any{
+		"node_count":  uint32(nodes),
+		"record_size": uint16(recordSize),
+		"ip_version":  uint16(4),
+	})...)
+	name := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(name, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestGeoDBLookup(t *testing.T) {
+	rec := map[string]any{
+		"city":      map[string]any{"names": map[string]any{"en": "Berlin"}},
+		"continent": map[string]any{"code": "EU"},
+		"country":   map[string]any{"iso_code": "DE"},
+	}
+	for _, size := range []int{24, 28, 32} {
+		db, err := openGeoDB(writeTestGeoDB(t, size, rec))
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		tests := []struct {
+			ip, country, city string
+		}{
+			{"10.1.2.3", "DE", "Berlin"},
+			{"::ffff:10.1.2.3", "DE", "Berlin"},
+			{"127.255.255.255", "DE", "Berlin"},
+			{"128.0.0.1", "", ""},
+			{"2001:db8::1", "", ""},
+			{"not an ip", "", ""},
+		}
+		for _, tt := range tests {
+			country, city := db.lookup(tt.ip)
+			if country != tt.country || city != tt.city {
+				t.Errorf("record size %d: lookup(%q) = %q, %q, want %q, %q", size, tt.ip, country, city, tt.country, tt.city)
+			}
+		}
+		if country, continent := db.region("10.0.0.1"); country != "DE" || continent != "EU" {
+			t.Errorf("record size %d: region = %q, %q, want DE, EU", size, country, continent)
+		}
+	}
+}
+
+func TestOpenGeoDBErrors(t *testing.T) {
+	dir := t.TempDir()
+	meta := func(m map[string]any) []byte {
+		return append(append(make([]byte, 32), mmdbMetadataMarker...), mmdbEncode(m)...)
+	}
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"no metadata", []byte("not a database")},
+		{"corrupt metadata", append(append([]byte(nil), mmdbMetadataMarker...), 0x5f)},
+		{"record size", meta(map[string]any{"node_count": uint32(1), "record_size": uint16(20), "ip_version": uint16(4)})},
+		{"tree larger than the file", meta(map[string]any{"node_count": uint32(100), "record_size": uint16(24), "ip_version": uint16(4)})},
+		{"node count overflows", meta(map[string]any{"node_count": uint64(1 << 62), "record_size": uint16(32), "ip_version": uint16(6)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, "test.mmdb")
+			if err := os.WriteFile(name, tt.buf, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := openGeoDB(name); err == nil {
+				t.Error("openGeoDB succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// GeoMirrors maps an ISO country code, or a continent code after an @
+// such as @EU, to the base URL of the regional mirror of the files.
+// It is populated by repeated -geo-mirror flags of the form
+// codes=url, where codes are comma separated.
+type GeoMirrors map[string]*url.URL
+
+// Set parses a single codes=url pair and adds it to the map.
+// It has the signature expected by flag.Func.
+func (m GeoMirrors) Set(s string) error {
+	codes, target, ok := strings.Cut(s, "=")
+	if !ok || codes == "" {
+		return fmt.Errorf("invalid geo mirror %q, expected codes=url, such as DE,AT=https://eu.example.org", s)
+	}
+	u, err := parseUpstream(target)
+	if err != nil {
+		return err
+	}
+	for _, code := range strings.Split(codes, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(strings.TrimPrefix(code, "@")) != 2 {
+			return fmt.Errorf("invalid geo mirror code %q, expected a country such as DE or a continent such as @EU", code)
+		}
+		m[code] = u
+	}
+	return nil
+}
+
+// mirror returns the mirror of the clients in country, or else of those
+// in continent, or nil if there is neither.
+func (m GeoMirrors) mirror(country, continent string) *url.URL {
+	if u, ok := m[country]; ok && country != "" {
+		return u
+	}
+	if u, ok := m["@"+continent]; ok && continent != "" {
+		return u
+	}
+	return nil
+}
+
+// geoMirrorHandler redirects the downloads of clients to the mirror of
+// their country or continent, as located in a MaxMind DB. Pages, dirs,
+// requests with a query and the files that do not exist are served
+// locally, as are the clients with no mirror and those with loopback,
+// private or link-local addresses.
+type geoMirrorHandler struct {
+	fs      http.FileSystem
+	geo     *geoDB
+	mirrors GeoMirrors
+	paths   []string // the path patterns redirected, or every path if empty
+	next    http.Handler
+}
+
+// ServeHTTP redirects r to its mirror, if it has one, or serves it with
+// next.
+func (h geoMirrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u := h.target(r); u != "" {
+		debugf("geo: redirecting %s to %s", clientIP(r), u)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// target returns the URL of r on the mirror of its client, or "" if r is
+// served locally.
+func (h geoMirrorHandler) target(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.URL.RawQuery != "" {
+		return ""
+	}
+	p := path.Clean("/" + r.URL.Path)
+	if ext := strings.ToLower(path.Ext(p)); ext == ".html" || ext == ".htm" {
+		return ""
+	}
+	if len(h.paths) > 0 && !matchPaths(h.paths, p) {
+		return ""
+	}
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() {
+		return ""
+	}
+	u := h.mirrors.mirror(h.geo.region(addr.String()))
+	if u == nil {
+		return ""
+	}
+	f, err := h.fs.Open(p)
+	if err != nil {
+		return ""
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil || fi.IsDir() {
+		return ""
+	}
+	target := *u
+	target.Path = strings.TrimSuffix(u.Path, "/") + p
+	target.RawPath = ""
+	return target.String()
+}
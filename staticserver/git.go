@@ -0,0 +1,278 @@
+package staticserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitFS is an fs.FS of the tree of a commit in a git repository, read
+// with the git command without checking it out. Repositories named by
+// URL are fetched into a temporary bare repository.
+type GitFS struct {
+	repo   string // the repository git is run in
+	remote string // the URL ref is fetched from, if it is not local
+	ref    string
+
+	mu     sync.RWMutex
+	tree   *memTree
+	commit string
+
+	catMu sync.Mutex
+	cat   *gitCat
+
+	stop chan struct{} // closed by Close
+}
+
+// OpenGit opens the tree of ref, a branch, tag or commit, in repo, a
+// path or a URL that git can fetch from.
+func OpenGit(repo, ref string) (*GitFS, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	g := &GitFS{repo: repo, ref: ref, stop: make(chan struct{})}
+	if isGitURL(repo) {
+		dir, err := os.MkdirTemp("", "static-server-git-")
+		if err != nil {
+			return nil, err
+		}
+		g.repo, g.remote = dir, repo
+		if _, err := g.git("init", "--bare", "--quiet"); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	if _, err := g.Update(); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// isGitURL reports whether repo is a URL rather than a path, either
+// with a scheme or in the scp-like user@host:path form.
+func isGitURL(repo string) bool {
+	if strings.Contains(repo, "://") {
+		return true
+	}
+	host, _, ok := strings.Cut(repo, ":")
+	return ok && len(host) > 1 && !strings.ContainsAny(host, `/\`)
+}
+
+// git runs git in the repository and returns what it prints.
+func (g *GitFS) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Update fetches the ref again if the repository is remote, and serves
+// the tree of the commit it now names. It reports whether that is a
+// different commit than was served before.
+func (g *GitFS) Update() (bool, error) {
+	rev := g.ref
+	if g.remote != "" {
+		if _, err := g.git("fetch", "--quiet", "--depth=1", g.remote, g.ref); err != nil {
+			return false, err
+		}
+		rev = "FETCH_HEAD"
+	}
+	out, err := g.git("rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return false, fmt.Errorf("git: no commit %s in %s", g.ref, g.source())
+	}
+	commit := strings.TrimSpace(string(out))
+	g.mu.RLock()
+	same := commit == g.commit
+	g.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	out, err = g.git("show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return false, err
+	}
+	secs, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	modTime := time.Unix(secs, 0)
+	out, err = g.git("ls-tree", "-r", "-l", "-z", commit)
+	if err != nil {
+		return false, err
+	}
+	tree := newMemTree(g.open)
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> SP <size> TAB <path>
+		meta, name, ok := strings.Cut(string(rec), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 || fields[1] != "blob" || fields[0] == "120000" {
+			continue // submodules and symlinks are left out
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		mode := fs.FileMode(0o644)
+		if fields[0] == "100755" {
+			mode = 0o755
+		}
+		tree.file(name, &memEntry{size: size, mode: mode, modTime: modTime, src: fields[2]})
+	}
+	for _, e := range tree.entries {
+		if e.IsDir() {
+			e.modTime = modTime
+		}
+	}
+	g.mu.Lock()
+	g.tree, g.commit = tree, commit
+	g.mu.Unlock()
+	return true, nil
+}
+
+// source describes where the repository is, for messages.
+func (g *GitFS) source() string {
+	if g.remote != "" {
+		return g.remote
+	}
+	return g.repo
+}
+
+// Commit returns the hash of the commit served.
+func (g *GitFS) Commit() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.commit
+}
+
+// Open opens the named file in the tree served.
+func (g *GitFS) Open(name string) (fs.File, error) {
+	g.mu.RLock()
+	tree := g.tree
+	g.mu.RUnlock()
+	return tree.Open(name)
+}
+
+// PullEvery calls Update every interval until Close, logging the
+// commits it moves to and the errors it meets.
+func (g *GitFS) PullEvery(interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-t.C:
+			}
+			if changed, err := g.Update(); err != nil {
+				infof("warning: %v", err)
+			} else if changed {
+				infof("git: serving %s of %s at %.12s", g.ref, g.source(), g.Commit())
+			}
+		}
+	}()
+}
+
+// Close stops pulling and reading blobs, and removes the temporary
+// repository of a remote one.
+func (g *GitFS) Close() error {
+	close(g.stop)
+	g.catMu.Lock()
+	if g.cat != nil {
+		g.cat.close()
+	}
+	g.catMu.Unlock()
+	if g.remote != "" {
+		return os.RemoveAll(g.repo)
+	}
+	return nil
+}
+
+// open reads the blob of e.
+func (g *GitFS) open(e *memEntry) (io.ReadSeeker, error) {
+	g.catMu.Lock()
+	defer g.catMu.Unlock()
+	if g.cat == nil {
+		cat, err := startGitCat(g.repo)
+		if err != nil {
+			return nil, err
+		}
+		g.cat = cat
+	}
+	data, err := g.cat.blob(e.src.(string))
+	if err != nil {
+		// The process may have died; start another next time.
+		g.cat.close()
+		g.cat = nil
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// gitCat is a git cat-file --batch process that blobs are read with.
+type gitCat struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// startGitCat starts a cat-file process in repo.
+func startGitCat(repo string) (*gitCat, error) {
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &gitCat{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// blob returns the content of the blob with the hash.
+func (c *gitCat) blob(hash string) ([]byte, error) {
+	if _, err := io.WriteString(c.in, hash+"\n"); err != nil {
+		return nil, err
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	header, err := c.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[1] != "blob" {
+		return nil, fmt.Errorf("git: blob %s: %s", hash, strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("git: blob %s: %s", hash, strings.TrimSpace(header))
+	}
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(c.out, data); err != nil {
+		return nil, err
+	}
+	if data[size] != '\n' {
+		return nil, errors.New("git: cat-file is out of step")
+	}
+	return data[:size], nil
+}
+
+// close stops the process.
+func (c *gitCat) close() {
+	c.in.Close()
+	c.cmd.Wait()
+}
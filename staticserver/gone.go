@@ -0,0 +1,70 @@
+package staticserver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// goneHandler responds 410 Gone for paths that have been permanently
+// removed, so crawlers drop them instead of retrying a 404 forever.
+// Paths ending in a slash mark everything beneath them as gone.
+type goneHandler struct {
+	paths map[string]bool
+	body  []byte // sent with each 410, if non-nil
+	next  http.Handler
+}
+
+// LoadGoneList reads the paths listed one per line in the named file.
+// Blank lines and lines starting with # are ignored.
+func LoadGoneList(name string) (map[string]bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			return nil, fmt.Errorf("%s: invalid path %q, paths must start with /", name, line)
+		}
+		paths[line] = true
+	}
+	return paths, sc.Err()
+}
+
+// isGone reports whether p, or a directory containing it, is listed.
+func (h goneHandler) isGone(p string) bool {
+	if h.paths[p] {
+		return true
+	}
+	for i := len(p) - 1; i > 0; i-- {
+		if p[i-1] == '/' && h.paths[p[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP responds 410 for gone paths and passes the rest to next.
+func (h goneHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.isGone(r.URL.Path) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if h.body == nil {
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(h.body))
+	w.WriteHeader(http.StatusGone)
+	w.Write(h.body)
+}
@@ -0,0 +1,46 @@
+package staticserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// healthHandler answers liveness probes: the process is up and serving.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler answers readiness probes. It is ready when every document
+// root can be read.
+type readyHandler []string
+
+func (dirs readyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	for _, dir := range dirs {
+		if err := readable(dir); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "%s: %v\n", dir, err)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readable reports why dir cannot be listed, if it cannot.
+func readable(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
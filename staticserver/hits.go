@@ -0,0 +1,123 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hitsPath is where the counts are served.
+const hitsPath = "/_hits"
+
+// hitCounter counts the complete GETs of every path served, keeping the
+// counts in a JSON file so they survive restarts. It makes a simple
+// download counter for release artifacts.
+type hitCounter struct {
+	file string
+
+	mu      sync.Mutex
+	hits    map[string]uint64
+	changes uint64 // counted since the start
+	saved   uint64 // of changes, those in the file
+}
+
+// openHitCounter loads the counts kept in file, if it exists, and saves
+// them back every interval while they change.
+func openHitCounter(file string, interval time.Duration) (*hitCounter, error) {
+	c := &hitCounter{file: file, hits: map[string]uint64{}}
+	b, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &c.hits); err != nil {
+			return nil, err
+		}
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := c.save(); err != nil {
+				log.Println("hits:", err)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// observe counts rr if it served a whole file. It is an observer for
+// observeHandler.
+func (c *hitCounter) observe(rr *requestRecord) {
+	if rr.Method != http.MethodGet || rr.Status != http.StatusOK || rr.Path == hitsPath {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits[rr.Path]++
+	c.changes++
+}
+
+// save writes the counts to the file if they have changed, replacing it
+// atomically. Counts a failed write left out are written the next time.
+func (c *hitCounter) save() error {
+	c.mu.Lock()
+	if c.changes == c.saved {
+		c.mu.Unlock()
+		return nil
+	}
+	b, err := json.MarshalIndent(c.hits, "", "\t")
+	changes := c.changes
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := c.write(b); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.saved = changes
+	c.mu.Unlock()
+	return nil
+}
+
+// write replaces the file with b.
+func (c *hitCounter) write(b []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), ".hits-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// ServeHTTP responds with the counts as a JSON object, or with the count
+// of the single path given in the query parameter path.
+func (c *hitCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	var v any = c.hits
+	if p := r.URL.Query().Get("path"); p != "" {
+		v = map[string]uint64{p: c.hits[normalizePath(p)]}
+	}
+	b, err := json.Marshal(v)
+	c.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(append(b, '\n'))
+}
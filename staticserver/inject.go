@@ -0,0 +1,145 @@
+package staticserver
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// bodyEnd is the tag snippets are inserted before.
+const bodyEnd = "</body>"
+
+// injectHandler inserts a snippet, such as an analytics script or a
+// cookie banner, before the </body> of the HTML pages next serves, or at
+// their end if they have none. Pages stream through as they are written,
+// with only the bytes that may begin the tag held back.
+type injectHandler struct {
+	snippet []byte
+	next    http.Handler
+}
+
+// ServeHTTP serves r with next, adding the snippet to HTML pages.
+func (h injectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// A part of a page is not a part of the page with the snippet, and a
+	// precompressed page cannot have it added. Other files keep both.
+	if ext := strings.ToLower(path.Ext(r.URL.Path)); ext == "" || ext == ".html" || ext == ".htm" {
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+		r.Header.Del("Accept-Encoding")
+	}
+	sw := &snippetWriter{ResponseWriter: w, snippet: h.snippet, head: r.Method == http.MethodHead}
+	h.next.ServeHTTP(sw, r)
+	sw.finish()
+}
+
+// snippetWriter passes the body of an HTML response through with the
+// snippet inserted before its first </body>. Other responses are passed
+// straight through.
+type snippetWriter struct {
+	http.ResponseWriter
+	snippet []byte
+	head    bool // the response has no body
+	wrote   bool
+	inject  bool
+	done    bool   // the snippet was written
+	held    []byte // the end of what was written, which may begin the tag
+}
+
+// WriteHeader decides whether the response is to be injected.
+func (w *snippetWriter) WriteHeader(status int) {
+	if w.wrote || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wrote = true
+	ct := w.Header().Get("Content-Type")
+	w.inject = status == http.StatusOK && strings.HasPrefix(ct, "text/html") && w.Header().Get("Content-Encoding") == ""
+	if w.inject {
+		for _, k := range []string{"Content-Length", "Accept-Ranges", "ETag", "Repr-Digest", "Digest"} {
+			w.Header().Del(k)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends part of the body, holding back the bytes that may begin
+// </body> until the next.
+func (w *snippetWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.inject || w.done {
+		return w.ResponseWriter.Write(b)
+	}
+	buf := append(w.held, b...)
+	if i := indexFold(buf, bodyEnd); i >= 0 {
+		w.held, w.done = nil, true
+		if _, err := w.ResponseWriter.Write(buf[:i]); err != nil {
+			return 0, err
+		}
+		if _, err := w.ResponseWriter.Write(w.snippet); err != nil {
+			return 0, err
+		}
+		_, err := w.ResponseWriter.Write(buf[i:])
+		return len(b), err
+	}
+	keep := min(len(buf), len(bodyEnd)-1)
+	w.held = append([]byte(nil), buf[len(buf)-keep:]...)
+	if _, err := w.ResponseWriter.Write(buf[:len(buf)-keep]); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom sends the body from r, keeping sendfile available for the
+// responses passed through.
+func (w *snippetWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.wrote && (!w.inject || w.done) {
+		return readFrom(w.ResponseWriter, r)
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *snippetWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends what was held back, with the snippet at the end of a page
+// that had no </body>.
+func (w *snippetWriter) finish() {
+	if !w.inject || w.done || w.head {
+		return
+	}
+	w.ResponseWriter.Write(append(w.held, w.snippet...))
+}
+
+// indexFold returns the index of the first instance of the lower case
+// ASCII sep in b, regardless of the case of b, or -1.
+func indexFold(b []byte, sep string) int {
+	for i := 0; i+len(sep) <= len(b); i++ {
+		j := 0
+		for j < len(sep) {
+			c := b[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != sep[j] {
+				break
+			}
+			j++
+		}
+		if j == len(sep) {
+			return i
+		}
+	}
+	return -1
+}
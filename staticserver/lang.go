@@ -0,0 +1,197 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// langHandler serves localized variants of files, choosing between
+// siblings such as index.en.html and index.de.html by the request's
+// Accept-Language header, falling back to def. Requests for which no
+// variant exists are passed on to next.
+type langHandler struct {
+	fs   http.FileSystem
+	def  string
+	next http.Handler
+}
+
+// ServeHTTP serves the best matching variant of the requested file.
+func (h langHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for _, lang := range append(acceptedLanguages(r.Header.Get("Accept-Language")), h.def) {
+		f, err := h.fs.Open(base + "." + lang + ext)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			f.Close()
+			continue
+		}
+		defer f.Close()
+		debugf("lang: %s: serving %s", name, base+"."+lang+ext)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", lang)
+		http.ServeContent(w, r, name, fi.ModTime(), f)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// acceptedLanguages returns the lowercased language tags of an
+// Accept-Language header in order of preference. Each regional tag is
+// followed by its primary language, so en-us also matches en.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 || strings.ContainsAny(tag, "/.") {
+			continue
+		}
+		tags = append(tags, weighted{strings.ToLower(tag), q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	var langs []string
+	for _, t := range tags {
+		langs = append(langs, t.tag)
+		if primary, _, ok := strings.Cut(t.tag, "-"); ok {
+			langs = append(langs, primary)
+		}
+	}
+	return langs
+}
+
+const (
+	// langCookie is the cookie that holds the language a client chose,
+	// which rules over its Accept-Language.
+	langCookie = "lang"
+	// langCookieTTL is how long the choice is kept.
+	langCookieTTL = 365 * 24 * time.Hour
+)
+
+// isLangTag reports whether name looks like a language tag, such as de
+// or pt-BR: two or three letters, and subtags of letters and digits.
+func isLangTag(name string) bool {
+	subs := strings.Split(name, "-")
+	if len(subs[0]) < 2 || len(subs[0]) > 3 || strings.Trim(strings.ToLower(subs[0]), "abcdefghijklmnopqrstuvwxyz") != "" {
+		return false
+	}
+	for _, sub := range subs[1:] {
+		if sub == "" || len(sub) > 8 || strings.Trim(strings.ToLower(sub), "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// langRootHandler redirects requests for / to the dir of a language of
+// the site, such as /en/, /de/ or /pt-BR/: the one of the lang cookie, if
+// the site has it, or else the one Accept-Language likes best, matching
+// de-AT to de and de to de-DE if need be, or else def. /?lang=de sets
+// the cookie and redirects to /de/, so that a link can choose for good.
+// Every other request is passed on to next.
+type langRootHandler struct {
+	fs   http.FileSystem
+	def  string
+	next http.Handler
+}
+
+// ServeHTTP redirects r to a language dir, or serves it with next.
+func (h langRootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	langs := h.langs()
+	q := r.URL.Query()
+	lang := ""
+	if chosen := q.Get("lang"); chosen != "" {
+		if lang = matchLang(langs, []string{strings.ToLower(chosen)}); lang != "" {
+			http.SetCookie(w, &http.Cookie{Name: langCookie, Value: lang, Path: "/", MaxAge: int(langCookieTTL.Seconds()), Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+		}
+		q.Del("lang")
+	}
+	if c, err := r.Cookie(langCookie); lang == "" && err == nil {
+		lang = matchLang(langs, []string{strings.ToLower(c.Value)})
+	}
+	if lang == "" {
+		lang = matchLang(langs, acceptedLanguages(r.Header.Get("Accept-Language")))
+	}
+	if lang == "" {
+		lang = matchLang(langs, []string{strings.ToLower(h.def)})
+	}
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	if lang == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	u := &url.URL{Path: "/" + lang + "/", RawQuery: q.Encode()}
+	debugf("lang: redirecting / to %s", u)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// langs returns the names of the language dirs of the root.
+func (h langRootHandler) langs() []string {
+	f, err := h.fs.Open("/")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		return nil
+	}
+	var langs []string
+	for _, fi := range fis {
+		if fi.IsDir() && isLangTag(fi.Name()) {
+			langs = append(langs, fi.Name())
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// matchLang returns the first of langs the lowercased tags, in order,
+// name, or else the first whose primary language is that of one of
+// them, or "" if there is none.
+func matchLang(langs, tags []string) string {
+	for _, tag := range tags {
+		for _, l := range langs {
+			if strings.EqualFold(l, tag) {
+				return l
+			}
+		}
+	}
+	for _, tag := range tags {
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, l := range langs {
+			if p, _, _ := strings.Cut(l, "-"); strings.EqualFold(p, primary) {
+				return l
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package staticserver
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listingPage is the template for directory listings.
+var listingPage = template.Must(template.New("listing").Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: .2em .5em; } td.n { text-align: right; white-space: nowrap; }
+tr:hover { background: #f4f4f4; }
+#drop { border: 2px dashed #aaa; border-radius: .5em; margin: 1em 0; padding: 1em; text-align: center; }
+#drop.over { background: #eef; border-color: #66a; }
+progress { width: 100%; }
+#search { float: right; margin-top: .5em; }
+details.qr { display: inline-block; margin-left: .5em; } details.qr summary { color: #66a; cursor: pointer; font-size: smaller; }
+details.qr img { background: #fff; box-shadow: 0 0 .5em #888; display: block; height: 12em; position: absolute; width: 12em; }
+p.du { color: #666; font-size: smaller; text-align: right; }
+td.pick { width: 1em; }
+</style>
+{{with .Search}}<form id="search" action="{{.}}"><input type="search" name="q" placeholder="Search"></form>
+{{end}}<h1>{{.Path}}</h1>
+{{if .Zip}}<form method="post" action="?zip=1">
+{{end}}<table>
+{{if ne .Path "/"}}<tr>{{if .Zip}}<td></td>{{end}}<td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr>{{if $.Zip}}<td class="pick">{{if .Size}}<input type="checkbox" name="path" value="{{.Name}}" aria-label="Choose {{.Name}}">{{end}}</td>{{end}}<td><a href="{{.Href}}">{{.Name}}</a>{{if and $.QR .Size}}<details class="qr"><summary>QR</summary><img src="{{.Href}}?qr=1" alt="QR code of {{.Name}}" loading="lazy"></details>{{end}}</td><td class="n">{{.Size}}</td><td class="n">{{.ModTime}}</td></tr>
+{{end}}</table>
+{{if .Zip}}<p><button>Download the files chosen as a zip</button></p>
+</form>
+{{end}}{{with .Usage}}<p class="du">{{.Entries}} entries, {{.Files}} files in {{.Dirs}} dirs, {{$.UsageSize}} in all</p>
+{{end}}{{with .Upload}}<div id="drop">Drop files here to upload them, or <input type="file" multiple></div>
+<div id="progress"></div>
+<script>
+(function() {
+	var drop = document.getElementById("drop"), list = document.getElementById("progress");
+	function upload(files) {
+		var pending = files.length;
+		Array.prototype.forEach.call(files, function(file) {
+			var row = document.createElement("div"), bar = document.createElement("progress");
+			row.textContent = file.name + " ";
+			row.appendChild(bar);
+			list.appendChild(row);
+			var body = new FormData(), xhr = new XMLHttpRequest();
+			body.append("file", file);
+			xhr.upload.onprogress = function(e) { if (e.lengthComputable) { bar.max = e.total; bar.value = e.loaded; } };
+			xhr.onloadend = function() {
+				row.textContent = file.name + (xhr.status >= 200 && xhr.status < 300 ? " uploaded" : " failed: " + (xhr.statusText || "network error"));
+				if (--pending === 0) { setTimeout(function() { location.reload(); }, 1000); }
+			};
+			xhr.open("POST", {{.}});
+			xhr.send(body);
+		});
+	}
+	drop.addEventListener("dragover", function(e) { e.preventDefault(); drop.className = "over"; });
+	drop.addEventListener("dragleave", function() { drop.className = ""; });
+	drop.addEventListener("drop", function(e) { e.preventDefault(); drop.className = ""; upload(e.dataTransfer.files); });
+	drop.querySelector("input").addEventListener("change", function(e) { upload(e.target.files); });
+})();
+</script>
+{{end}}`))
+
+// listingEntry is a single row of a directory listing.
+type listingEntry struct {
+	Name, Href, Size, ModTime string
+}
+
+// listingHandler serves its own listing of directories that have no
+// index.html, in place of the plain one of http.FileServer. When upload
+// is set the listing of a dir uploads also have offers a drop zone that
+// sends files to it, to be written into that dir. Every
+// other request is passed on to next. When search is set the listing
+// has a search box that sends queries to it, and when qr is set each
+// file has a QR code of its URL a click away. When du is set the footer
+// tells how much is stored under the dir. When zip is set files can be
+// chosen to download as one zip.
+type listingHandler struct {
+	fs      http.FileSystem
+	upload  string     // the path of the upload endpoint, if uploads are enabled
+	uploads *store     // the store uploads are written to
+	search  string     // the path of the search endpoint, if search is enabled
+	qr      bool       // offer the QR code of each file
+	zip     bool       // offer to download the files chosen as a zip
+	du      *diskUsage // counts the usage shown, if any
+	next    http.Handler
+}
+
+// ServeHTTP lists the requested directory or serves r with next.
+func (h listingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if !isListing(h.fs, r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		log.Println("listing:", err)
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+
+	data := struct {
+		Path      string
+		Entries   []listingEntry
+		Upload    string
+		Search    string
+		QR        bool
+		Zip       bool
+		Usage     *dirUsage
+		UsageSize string // Usage.Size, for people
+	}{Path: name, Search: h.search, QR: h.qr, Zip: h.zip}
+	if h.upload != "" && h.uploads.isDir(name) {
+		data.Upload = h.upload + "?dir=" + url.QueryEscape(name)
+	}
+	if h.du != nil {
+		if u, err := h.du.usage(name); err == nil {
+			data.Usage, data.UsageSize = &u, formatSize(u.Size)
+		} else {
+			debugf("du: %s: %v", name, err)
+		}
+	}
+	for _, fi := range fis {
+		e := listingEntry{
+			Name: fi.Name(),
+			Href: (&url.URL{Path: fi.Name()}).String(),
+		}
+		if !fi.ModTime().IsZero() { // embedded files have no time
+			e.ModTime = fi.ModTime().Format(time.DateTime)
+		}
+		if fi.IsDir() {
+			e.Name += "/"
+			e.Href += "/"
+		} else {
+			e.Size = formatSize(fi.Size())
+		}
+		data.Entries = append(data.Entries, e)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	listingPage.Execute(w, data)
+}
+
+// isListing reports whether r is a GET or HEAD request for a directory,
+// with the trailing slash, that has no index.html to serve instead.
+func isListing(fs http.FileSystem, r *http.Request) bool {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	name := path.Clean("/" + r.URL.Path)
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	if index, err := fs.Open(path.Join(name, "index.html")); err == nil {
+		index.Close()
+		return false
+	}
+	return true
+}
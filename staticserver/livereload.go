@@ -0,0 +1,183 @@
+package staticserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// liveReloadPath is where browsers listen for reload events.
+const liveReloadPath = "/_livereload"
+
+// liveReloadScript is injected into HTML pages to reload them when the
+// files served change.
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").onmessage = function() { location.reload(); };</script>`
+
+// liveReload tells every page listening on liveReloadPath to reload
+// when the files served change.
+type liveReload struct {
+	done chan struct{}
+
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+// newLiveReload returns a liveReload with no pages listening.
+func newLiveReload() *liveReload {
+	return &liveReload{done: make(chan struct{}), clients: map[chan struct{}]bool{}}
+}
+
+// changed notifies the pages listening. It is a dirWatcher subscriber.
+func (l *liveReload) changed([]fileChange) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends the event streams, so they do not hold up a shutdown.
+func (l *liveReload) close() {
+	close(l.done)
+}
+
+// ServeHTTP streams a reload event to the client each time the files
+// change.
+func (l *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[c] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, c)
+		l.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-l.done:
+			return
+		case <-c:
+		}
+		if _, err := fmt.Fprint(w, "data: reload\n\n"); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// liveReloadHandler serves requests with next, injecting the live
+// reload script into every HTML page.
+type liveReloadHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP serves r, buffering HTML responses to add the script.
+func (h liveReloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// Pages must come back whole, and not as 304s, to be injected.
+	for _, k := range []string{"Range", "If-Modified-Since", "If-None-Match", "If-Range"} {
+		r.Header.Del(k)
+	}
+	iw := &injectWriter{ResponseWriter: w}
+	h.next.ServeHTTP(iw, r)
+	iw.finish()
+}
+
+// injectWriter holds back the body of an HTML response until it is
+// complete and then sends it with the live reload script added.
+// Other responses are passed straight through.
+type injectWriter struct {
+	http.ResponseWriter
+	wrote  bool
+	inject bool
+	buf    bytes.Buffer
+}
+
+// WriteHeader decides whether the response is to be injected.
+func (w *injectWriter) WriteHeader(status int) {
+	if w.wrote || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wrote = true
+	ct := w.Header().Get("Content-Type")
+	w.inject = status == http.StatusOK && strings.HasPrefix(ct, "text/html") && w.Header().Get("Content-Encoding") == ""
+	if w.inject {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Accept-Ranges")
+		w.Header().Del("Repr-Digest")
+		w.Header().Del("Digest")
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends or holds back part of the body.
+func (w *injectWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.inject {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom sends or holds back the body from r, keeping sendfile
+// available for the responses passed through.
+func (w *injectWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.wrote && !w.inject {
+		return readFrom(w.ResponseWriter, r)
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *injectWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends a held back page, with the script before </body> or, if
+// it has none, at the end.
+func (w *injectWriter) finish() {
+	if !w.inject {
+		return
+	}
+	page := w.buf.Bytes()
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		i = len(page)
+	}
+	out := make([]byte, 0, len(page)+len(liveReloadScript))
+	out = append(out, page[:i]...)
+	out = append(out, liveReloadScript...)
+	out = append(out, page[i:]...)
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.ResponseWriter.Write(out)
+}
@@ -0,0 +1,47 @@
+package staticserver
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Level sets how much a Server logs to the standard logger.
+type Level int
+
+const (
+	LevelQuiet   Level = iota // errors only
+	LevelNormal               // plus warnings and notable events
+	LevelVerbose              // plus a line per request, when there is no AccessLog
+	LevelDebug                // plus how every path was resolved
+)
+
+// level is the level set by SetLevel. It is read by handlers while it
+// may be set again.
+var level atomic.Int32
+
+func init() { level.Store(int32(LevelNormal)) }
+
+// SetLevel sets how much every Server in the process logs, as they
+// share the standard logger. The default is LevelNormal.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// verbosity returns the level set by SetLevel.
+func verbosity() Level {
+	return Level(level.Load())
+}
+
+// infof logs a warning or notable event unless quiet.
+func infof(format string, v ...any) {
+	if verbosity() >= LevelNormal {
+		log.Printf(format, v...)
+	}
+}
+
+// debugf logs a decision made while resolving a request, at LevelDebug.
+func debugf(format string, v ...any) {
+	if verbosity() >= LevelDebug {
+		log.Printf("debug: "+format, v...)
+	}
+}
@@ -0,0 +1,120 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maintenancePath is the admin endpoint maintenance mode is turned on
+// and off at.
+const maintenancePath = "/_maintenance"
+
+// defaultMaintenanceRetry is the Retry-After of maintenance responses
+// when the Config gives none.
+const defaultMaintenanceRetry = 5 * time.Minute
+
+// maintenancePage is served in maintenance mode when the Config names
+// no page of its own.
+const maintenancePage = `<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Down for maintenance</title>
+<style>body { font-family: sans-serif; margin: 1em auto; max-width: 50em; padding: 0 1em; }</style>
+<h1>Down for maintenance</h1>
+<p>This site is being updated and will be back shortly.</p>
+`
+
+// maintenance answers every request with its page and 503 Service
+// Unavailable while it is on, so that the files served can be swapped
+// without clients seeing them half changed. Requests for the admin
+// endpoints are still served, so that it can be turned off again.
+type maintenance struct {
+	on          atomic.Bool
+	page        []byte
+	contentType string
+	retry       time.Duration
+	admin       *http.ServeMux // the admin endpoints served alongside, if any
+	auth        Accounts       // the accounts allowed to turn it on and off
+}
+
+// newMaintenance returns the maintenance mode of cfg, with its page read
+// now, so that it does not change with the files.
+func newMaintenance(cfg Config, auth Accounts) (*maintenance, error) {
+	m := &maintenance{page: []byte(maintenancePage), contentType: "text/html; charset=utf-8", retry: cfg.MaintenanceRetry, auth: auth}
+	if m.retry <= 0 {
+		m.retry = defaultMaintenanceRetry
+	}
+	if cfg.MaintenancePage != "" {
+		b, err := os.ReadFile(cfg.MaintenancePage)
+		if err != nil {
+			return nil, err
+		}
+		m.page = b
+		if ct := mime.TypeByExtension(filepath.Ext(cfg.MaintenancePage)); ct != "" {
+			m.contentType = ct
+		}
+	}
+	m.on.Store(cfg.Maintenance)
+	return m, nil
+}
+
+// set turns maintenance mode on or off.
+func (m *maintenance) set(on bool) {
+	if m.on.Swap(on) != on {
+		if on {
+			infof("maintenance mode on")
+		} else {
+			infof("maintenance mode off")
+		}
+	}
+}
+
+// handler returns next with requests answered by m while it is on.
+func (m *maintenance) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.on.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.admin != nil {
+			if _, pattern := m.admin.Handler(r); pattern != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", m.contentType)
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retry.Seconds())))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if r.Method != http.MethodHead {
+			w.Write(m.page)
+		}
+	})
+}
+
+// ServeHTTP reports whether maintenance mode is on to GET requests, and
+// turns it on for POST and off for DELETE, for the Auth accounts.
+func (m *maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !m.auth.authorize(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		m.set(true)
+	case http.MethodDelete:
+		m.set(false)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"maintenance": m.on.Load()})
+}
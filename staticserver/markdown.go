@@ -0,0 +1,1060 @@
+package staticserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MarkdownPage is the default template Markdown files are rendered in.
+// A template given instead is executed with the same data: the Title
+// of the page, from its first heading, the Path of the file, the
+// rendered Body and the Raw URL of the file as it is.
+const MarkdownPage = `<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; line-height: 1.5; margin: 1em auto; max-width: 50em; padding: 0 1em; }
+pre, code { background: #f4f4f4; border-radius: .2em; font-size: .9em; }
+pre { overflow: auto; padding: .5em; } code { padding: 0 .2em; } pre code { padding: 0; }
+blockquote { border-left: .25em solid #ddd; color: #555; margin-left: 0; padding-left: 1em; }
+table { border-collapse: collapse; } th, td { border: 1px solid #ddd; padding: .2em .5em; }
+img { max-width: 100%; }
+.kw { color: #a626a4; } .str { color: #50a14f; } .com { color: #a0a1a7; font-style: italic; } .num { color: #986801; }
+#raw { float: right; font-size: .8em; }
+</style>
+<a id="raw" href="{{.Raw}}">raw</a>
+{{.Body}}`
+
+// markdownHandler renders Markdown files as HTML pages with page, and
+// passes every other request, and those asking for the raw file with
+// ?raw=1, to next.
+type markdownHandler struct {
+	fs   http.FileSystem
+	page *template.Template
+	next http.Handler
+}
+
+// ServeHTTP renders the requested Markdown file or serves r with next.
+func (h markdownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || path.Ext(name) != ".md" || r.URL.Query().Get("raw") == "1" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	src, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	body, title := renderMarkdown(src)
+	if title == "" {
+		title = path.Base(name)
+	}
+	raw := *r.URL
+	q := raw.Query()
+	q.Set("raw", "1")
+	raw.RawQuery = q.Encode()
+	var out bytes.Buffer
+	err = h.page.Execute(&out, struct {
+		Title, Path, Raw string
+		Body             template.HTML
+	}{title, name, raw.RequestURI(), template.HTML(body)})
+	if err != nil {
+		infof("warning: markdown: %s: %v", name, err)
+		http.Error(w, "Error rendering file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(out.Bytes()))
+}
+
+// renderMarkdown renders the Markdown document src as HTML, returning
+// the text of its first heading as its title. It covers CommonMark's
+// blocks and inlines and GitHub's tables, task lists, strikethrough and
+// bare links, if not every corner of them. HTML in src is kept as is.
+func renderMarkdown(src []byte) (body, title string) {
+	text := strings.ReplaceAll(strings.ReplaceAll(string(src), "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	m := &mdRenderer{refs: map[string]mdLink{}, ids: map[string]int{}}
+	blocks := m.blocks(lines)
+	var b strings.Builder
+	m.render(&b, blocks, false)
+	return b.String(), m.title
+}
+
+// expandTabs replaces the tabs in the indentation of line with spaces,
+// to tab stops of four.
+func expandTabs(line string) string {
+	col := 0
+	for i, c := range line {
+		switch c {
+		case ' ':
+			col++
+		case '\t':
+			col += 4 - col%4
+		default:
+			if strings.IndexByte(line[:i], '\t') < 0 {
+				return line
+			}
+			return strings.Repeat(" ", col) + line[i:]
+		}
+	}
+	return strings.Repeat(" ", col)
+}
+
+// mdRenderer renders a document, keeping the link reference
+// definitions and heading ids it has seen.
+type mdRenderer struct {
+	refs  map[string]mdLink
+	ids   map[string]int
+	title string
+}
+
+// mdLink is the destination of a reference link.
+type mdLink struct {
+	url, title string
+}
+
+// mdKind is the kind of an mdBlock.
+type mdKind int
+
+const (
+	mdParagraph mdKind = iota
+	mdHeading
+	mdCode
+	mdQuote
+	mdList
+	mdItem
+	mdRule
+	mdHTML
+	mdTable
+)
+
+// mdBlock is a block of a document. Inline text is rendered only once
+// every block is parsed, so links may refer to definitions after them.
+type mdBlock struct {
+	kind     mdKind
+	level    int    // of a heading
+	text     string // the inlines of a paragraph or heading, the text of code, or HTML
+	lang     string // of fenced code
+	children []*mdBlock
+
+	ordered bool // of a list
+	start   int  // the number of the first item of an ordered list
+	loose   bool // a list whose items are separated by blank lines
+	task    int  // of an item: 1 for an unchecked task, 2 for a checked one
+
+	align []string   // of table columns: "", "left", "center" or "right"
+	rows  [][]string // of a table, the first being the header
+}
+
+var (
+	mdATX      = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRuleLine = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdFence    = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	mdBullet   = regexp.MustCompile(`^( {0,3})([-*+])( +|$)`)
+	mdOrdered  = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( +|$)`)
+	mdRefDef   = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?[ \t]*$`)
+	mdHTMLTag  = regexp.MustCompile(`^ {0,3}<(?:!--|/?(?i:address|article|aside|blockquote|details|dialog|div|dl|fieldset|figcaption|figure|footer|form|h[1-6]|header|hr|iframe|li|main|nav|ol|p|pre|script|section|style|summary|table|tbody|td|tfoot|th|thead|tr|ul)(?:[\s/>]|$))`)
+	mdTableSep = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// isBlank reports whether line has nothing but spaces.
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentOf returns the number of spaces line starts with.
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// listItem reports whether line starts a list item, and returns its
+// marker and the column its content starts at.
+func listItem(line string) (marker string, ordered bool, start, content int, ok bool) {
+	if m := mdBullet.FindStringSubmatch(line); m != nil && !mdRuleLine.MatchString(line) {
+		return m[2], false, 0, itemContent(line, len(m[1])+1, m[3]), true
+	}
+	if m := mdOrdered.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return m[3], true, n, itemContent(line, len(m[1])+len(m[2])+1, m[4]), true
+	}
+	return "", false, 0, 0, false
+}
+
+// itemContent returns the column the content of a list item starts at,
+// after its marker ending at col and the spaces after it.
+func itemContent(line string, col int, spaces string) int {
+	if len(spaces) == 0 || len(spaces) > 4 || col+len(spaces) >= len(line) {
+		return col + 1
+	}
+	return col + len(spaces)
+}
+
+// interrupts reports whether line starts a block that ends a paragraph.
+func interrupts(line string) bool {
+	if mdATX.MatchString(line) || mdRuleLine.MatchString(line) || mdFence.MatchString(line) || mdHTMLTag.MatchString(line) {
+		return true
+	}
+	if strings.HasPrefix(strings.TrimLeft(line, " "), ">") && indentOf(line) < 4 {
+		return true
+	}
+	if _, ordered, start, content, ok := listItem(line); ok && content < len(line) && (!ordered || start == 1) {
+		return true
+	}
+	return false
+}
+
+// blocks parses lines into blocks.
+func (m *mdRenderer) blocks(lines []string) []*mdBlock {
+	var blocks []*mdBlock
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case indentOf(line) >= 4:
+			var code []string
+			for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, &mdBlock{kind: mdCode, text: strings.Join(code, "\n") + "\n"})
+
+		case mdFence.MatchString(line):
+			f := mdFence.FindStringSubmatch(line)
+			indent, fence := len(f[1]), f[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				l := strings.TrimLeft(lines[i], " ")
+				if indentOf(lines[i]) < 4 && strings.HasPrefix(l, fence) && strings.Trim(l, fence[:1]+" \t") == "" {
+					i++
+					break
+				}
+				code = append(code, strings.TrimPrefix(lines[i], strings.Repeat(" ", min(indent, indentOf(lines[i])))))
+			}
+			text := strings.Join(code, "\n")
+			if len(code) > 0 {
+				text += "\n"
+			}
+			blocks = append(blocks, &mdBlock{kind: mdCode, text: text, lang: strings.ToLower(f[3])})
+
+		case mdATX.MatchString(line):
+			h := mdATX.FindStringSubmatch(line)
+			blocks = append(blocks, &mdBlock{kind: mdHeading, level: len(h[1]), text: h[2]})
+			i++
+
+		case mdRuleLine.MatchString(line):
+			blocks = append(blocks, &mdBlock{kind: mdRule})
+			i++
+
+		case strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				l := strings.TrimLeft(lines[i], " ")
+				if strings.HasPrefix(l, ">") {
+					l = strings.TrimPrefix(l[1:], " ")
+				} else if interrupts(lines[i]) {
+					break
+				}
+				quoted = append(quoted, l)
+			}
+			blocks = append(blocks, &mdBlock{kind: mdQuote, children: m.blocks(quoted)})
+
+		case mdHTMLTag.MatchString(line):
+			var raw []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				raw = append(raw, lines[i])
+			}
+			blocks = append(blocks, &mdBlock{kind: mdHTML, text: strings.Join(raw, "\n") + "\n"})
+
+		default:
+			if _, _, _, _, ok := listItem(line); ok {
+				var list *mdBlock
+				list, i = m.list(lines, i)
+				blocks = append(blocks, list)
+				continue
+			}
+			if i+1 < len(lines) && strings.Contains(line, "|") && mdTableSep.MatchString(lines[i+1]) {
+				var table *mdBlock
+				table, i = m.table(lines, i)
+				blocks = append(blocks, table)
+				continue
+			}
+			var para []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if len(para) > 0 && strings.Trim(lines[i], " =") == "" {
+					blocks = append(blocks, &mdBlock{kind: mdHeading, level: 1, text: strings.Join(para, "\n")})
+					para = nil
+					i++
+					break
+				}
+				if len(para) > 0 && strings.Trim(lines[i], " -") == "" && indentOf(lines[i]) < 4 && !strings.Contains(strings.TrimSpace(lines[i]), " ") {
+					blocks = append(blocks, &mdBlock{kind: mdHeading, level: 2, text: strings.Join(para, "\n")})
+					para = nil
+					i++
+					break
+				}
+				if len(para) > 0 && interrupts(lines[i]) {
+					break
+				}
+				if len(para) == 0 {
+					if d := mdRefDef.FindStringSubmatch(lines[i]); d != nil {
+						label := normalizeLabel(d[1])
+						if _, ok := m.refs[label]; !ok {
+							m.refs[label] = mdLink{url: d[2], title: d[3] + d[4] + d[5]}
+						}
+						continue
+					}
+				}
+				para = append(para, strings.TrimLeft(lines[i], " "))
+			}
+			if len(para) > 0 {
+				blocks = append(blocks, &mdBlock{kind: mdParagraph, text: strings.Join(para, "\n")})
+			}
+		}
+	}
+	return blocks
+}
+
+// list parses the list starting at lines[i], returning it and the index
+// of the line after it.
+func (m *mdRenderer) list(lines []string, i int) (*mdBlock, int) {
+	marker, ordered, start, _, _ := listItem(lines[i])
+	list := &mdBlock{kind: mdList, ordered: ordered, start: start}
+	blankBetween := false
+	for i < len(lines) {
+		mk, ord, _, content, ok := listItem(lines[i])
+		if !ok || mk != marker || ord != ordered {
+			break
+		}
+		item := []string{lines[i][min(content, len(lines[i])):]}
+		blank := false
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if isBlank(l) {
+				blank = true
+				item = append(item, "")
+				continue
+			}
+			if indentOf(l) >= content {
+				if blank {
+					blankBetween = true
+				}
+				blank = false
+				item = append(item, l[content:])
+				continue
+			}
+			if _, _, _, _, ok := listItem(l); ok || blank || interrupts(l) {
+				break
+			}
+			item = append(item, l) // a lazy continuation of a paragraph
+		}
+		for len(item) > 0 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+		}
+		if blank && i < len(lines) {
+			if mk, ord, _, _, ok := listItem(lines[i]); ok && mk == marker && ord == ordered {
+				blankBetween = true
+			}
+		}
+		it := &mdBlock{kind: mdItem}
+		if len(item) > 0 {
+			switch first := item[0]; {
+			case strings.HasPrefix(first, "[ ] "):
+				it.task, item[0] = 1, first[4:]
+			case strings.HasPrefix(first, "[x] "), strings.HasPrefix(first, "[X] "):
+				it.task, item[0] = 2, first[4:]
+			}
+		}
+		it.children = m.blocks(item)
+		list.children = append(list.children, it)
+	}
+	list.loose = blankBetween
+	return list, i
+}
+
+// table parses the table starting at lines[i], returning it and the
+// index of the line after it.
+func (m *mdRenderer) table(lines []string, i int) (*mdBlock, int) {
+	t := &mdBlock{kind: mdTable, rows: [][]string{splitRow(lines[i])}}
+	for _, cell := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			t.align = append(t.align, "center")
+		case left:
+			t.align = append(t.align, "left")
+		case right:
+			t.align = append(t.align, "right")
+		default:
+			t.align = append(t.align, "")
+		}
+	}
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|"); i++ {
+		t.rows = append(t.rows, splitRow(lines[i]))
+	}
+	return t, i
+}
+
+// splitRow splits a table row into its cells at the pipes that are not
+// escaped.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(strings.ReplaceAll(line[start:i], `\|`, "|")))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(strings.ReplaceAll(line[start:], `\|`, "|")))
+}
+
+// normalizeLabel returns the label of a link reference as it is
+// matched: case-folded, with runs of spaces collapsed.
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// render writes blocks as HTML. In a tight list, paragraphs are written
+// without their tags.
+func (m *mdRenderer) render(b *strings.Builder, blocks []*mdBlock, tight bool) {
+	for i, bl := range blocks {
+		switch bl.kind {
+		case mdParagraph:
+			if tight {
+				m.inline(b, bl.text)
+				if i < len(blocks)-1 {
+					b.WriteString("\n")
+				}
+			} else {
+				b.WriteString("<p>")
+				m.inline(b, bl.text)
+				b.WriteString("</p>\n")
+			}
+		case mdHeading:
+			var h strings.Builder
+			m.inline(&h, bl.text)
+			plain := stripTags(h.String())
+			if m.title == "" {
+				m.title = html.UnescapeString(plain)
+			}
+			fmt.Fprintf(b, "<h%d id=\"%s\">%s</h%d>\n", bl.level, m.headingID(plain), h.String(), bl.level)
+		case mdCode:
+			b.WriteString("<pre><code")
+			if bl.lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(bl.lang) + `"`)
+			}
+			b.WriteString(">")
+			highlight(b, bl.text, bl.lang)
+			b.WriteString("</code></pre>\n")
+		case mdQuote:
+			b.WriteString("<blockquote>\n")
+			m.render(b, bl.children, false)
+			b.WriteString("</blockquote>\n")
+		case mdList:
+			tag := "ul"
+			if bl.ordered {
+				tag = "ol"
+			}
+			b.WriteString("<" + tag)
+			if bl.ordered && bl.start != 1 {
+				fmt.Fprintf(b, ` start="%d"`, bl.start)
+			}
+			b.WriteString(">\n")
+			for _, it := range bl.children {
+				b.WriteString("<li>")
+				switch it.task {
+				case 1:
+					b.WriteString(`<input type="checkbox" disabled> `)
+				case 2:
+					b.WriteString(`<input type="checkbox" checked disabled> `)
+				}
+				m.render(b, it.children, !bl.loose)
+				b.WriteString("</li>\n")
+			}
+			b.WriteString("</" + tag + ">\n")
+		case mdRule:
+			b.WriteString("<hr>\n")
+		case mdHTML:
+			b.WriteString(bl.text)
+		case mdTable:
+			b.WriteString("<table>\n")
+			for r, row := range bl.rows {
+				cell := "td"
+				if r == 0 {
+					cell = "th"
+					b.WriteString("<thead>\n")
+				} else if r == 1 {
+					b.WriteString("<tbody>\n")
+				}
+				b.WriteString("<tr>")
+				for c := range bl.align {
+					b.WriteString("<" + cell)
+					if bl.align[c] != "" {
+						b.WriteString(` style="text-align: ` + bl.align[c] + `"`)
+					}
+					b.WriteString(">")
+					if c < len(row) {
+						m.inline(b, row[c])
+					}
+					b.WriteString("</" + cell + ">")
+				}
+				b.WriteString("</tr>\n")
+				if r == 0 {
+					b.WriteString("</thead>\n")
+				}
+			}
+			if len(bl.rows) > 1 {
+				b.WriteString("</tbody>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+	}
+}
+
+// headingID returns an id for a heading with the text, unique in the
+// document, as GitHub makes them.
+func (m *mdRenderer) headingID(text string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			return unicode.ToLower(r)
+		case r == ' ':
+			return '-'
+		}
+		return -1
+	}, html.UnescapeString(text))
+	n := m.ids[id]
+	m.ids[id]++
+	if n > 0 {
+		id += "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+var (
+	mdTagRe    = regexp.MustCompile(`<[^>]*>`)
+	mdInlineRe = regexp.MustCompile(`^(?:<!--[\s\S]*?-->|</?[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][\w.:-]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*\s*/?>)`)
+	mdAutoRe   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*|[\w.!#$%&'*+/=?^{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?)>`)
+	mdEntityRe = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	mdBareRe   = regexp.MustCompile(`^https?://[^\s<]*[^\s<.,:;!?"')\]]`)
+)
+
+// stripTags returns rendered inline HTML as plain text, still escaped.
+func stripTags(s string) string {
+	return mdTagRe.ReplaceAllString(s, "")
+}
+
+// inline renders the inline Markdown of s.
+func (m *mdRenderer) inline(b *strings.Builder, s string) {
+	ends := map[int][2]int{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+		case c == '`':
+			n := runLength(s, i)
+			end := findRun(s, i+n, n) - (i + n)
+			if end < 0 {
+				b.WriteString(s[i : i+n])
+				i += n
+				break
+			}
+			code := strings.ReplaceAll(s[i+n:i+n+end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i += n + end + n
+		case c == '*' || c == '_' || c == '~':
+			i = m.emphasis(b, s, i, ends)
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if next, ok := m.link(b, s, i+1, true); ok {
+				i = next
+			} else {
+				b.WriteString("!")
+				i++
+			}
+		case c == '[':
+			if next, ok := m.link(b, s, i, false); ok {
+				i = next
+			} else {
+				b.WriteString("[")
+				i++
+			}
+		case c == '<':
+			if a := mdAutoRe.FindStringSubmatch(s[i:]); a != nil {
+				href := a[1]
+				if !strings.Contains(href, ":") {
+					href = "mailto:" + href
+				}
+				b.WriteString(`<a href="` + html.EscapeString(safeURL(href)) + `">` + html.EscapeString(a[1]) + "</a>")
+				i += len(a[0])
+			} else if tag := mdInlineRe.FindString(s[i:]); tag != "" {
+				b.WriteString(tag)
+				i += len(tag)
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+		case c == '&':
+			if e := mdEntityRe.FindString(s[i:]); e != "" {
+				b.WriteString(e)
+				i += len(e)
+			} else {
+				b.WriteString("&amp;")
+				i++
+			}
+		case c == 'h' && (i == 0 || !isWordByte(s[i-1])) && mdBareRe.MatchString(s[i:]):
+			u := mdBareRe.FindString(s[i:])
+			b.WriteString(`<a href="` + html.EscapeString(u) + `">` + html.EscapeString(u) + "</a>")
+			i += len(u)
+		case c == ' ' && strings.HasPrefix(s[i:], "  \n"):
+			b.WriteString("<br>\n")
+			i += 3
+		case c == '\n':
+			b.WriteString("\n")
+			i++
+		case c == '>' || c == '"':
+			b.WriteString(html.EscapeString(s[i : i+1]))
+			i++
+		default:
+			j := i + 1
+			for j < len(s) && strings.IndexByte("\\`*_~![<&h \n>\"", s[j]) < 0 {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+		}
+	}
+}
+
+// findRun returns the index of the next run of exactly n backticks in
+// s from i, or -1 if there is none.
+func findRun(s string, i, n int) int {
+	for i < len(s) {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := runLength(s, i)
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// runLength returns the number of times the byte at s[i] repeats from i.
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// emphasis renders the emphasis, strong emphasis or strikethrough that
+// the delimiter run at s[i] opens, or the run itself if it opens none,
+// returning the index after what it rendered.
+func (m *mdRenderer) emphasis(b *strings.Builder, s string, i int, ends map[int][2]int) int {
+	width, end := emphasisEnd(s, i, ends)
+	if end < 0 {
+		n := runLength(s, i)
+		b.WriteString(s[i : i+n])
+		return i + n
+	}
+	tag := "em"
+	switch {
+	case s[i] == '~':
+		tag = "del"
+	case width == 2:
+		tag = "strong"
+	}
+	b.WriteString("<" + tag + ">")
+	m.inline(b, s[i+width:end])
+	b.WriteString("</" + tag + ">")
+	return end + width
+}
+
+// opensEmphasis reports whether the delimiter run of n at s[i] can open
+// emphasis: it is not followed by a space, and an underscore is not in
+// the middle of a word.
+func opensEmphasis(s string, i, n int) bool {
+	return i+n < len(s) && s[i+n] != ' ' && s[i+n] != '\n' && (s[i] != '_' || i == 0 || !isWordByte(s[i-1]))
+}
+
+// emphasisEnd finds what closes the delimiter run at s[i], returning
+// how many delimiters it takes and where the closing ones start, or -1
+// if nothing does. Runs that open emphasis of their own on the way are
+// skipped with what they enclose. ends memoizes the runs looked at.
+func emphasisEnd(s string, i int, ends map[int][2]int) (width, end int) {
+	if e, ok := ends[i]; ok {
+		return e[0], e[1]
+	}
+	ends[i] = [2]int{0, -1} // until found, so that nothing loops
+	c, n := s[i], runLength(s, i)
+	if !opensEmphasis(s, i, n) {
+		return 0, -1
+	}
+	widths := []int{2, 1}
+	if c == '~' {
+		widths = []int{2}
+	}
+	for _, w := range widths {
+		if n < w {
+			continue
+		}
+		for j := i + w; j < len(s); j++ {
+			switch {
+			case s[j] == '\\':
+				j++
+				continue
+			case s[j] == '`':
+				r := runLength(s, j)
+				if k := findRun(s, j+r, r); k >= 0 {
+					j = k + r - 1
+				} else {
+					j += r - 1
+				}
+				continue
+			case s[j] != c:
+				continue
+			}
+			run := runLength(s, j)
+			closes := s[j-1] != ' ' && s[j-1] != '\n' && (c != '_' || j+run >= len(s) || !isWordByte(s[j+run]))
+			if closes && run >= w && !(w == 1 && run == 2) && j > i+w {
+				ends[i] = [2]int{w, j + run - w}
+				return w, j + run - w
+			}
+			if j > i+w && opensEmphasis(s, j, run) {
+				if nw, e := emphasisEnd(s, j, ends); e >= 0 {
+					j = e + nw - 1
+					continue
+				}
+			}
+			j += run - 1
+		}
+	}
+	return 0, -1
+}
+
+// link renders the link, or image, whose text opens with the bracket at
+// s[i], returning the index after it, or false if there is no link.
+func (m *mdRenderer) link(b *strings.Builder, s string, i int, image bool) (int, bool) {
+	depth, end := 0, -1
+	for j := i; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			if k := strings.IndexByte(s[j+1:], '`'); k >= 0 {
+				j += k + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				end = j
+			}
+		}
+	}
+	if end < 0 {
+		return 0, false
+	}
+	text := s[i+1 : end]
+	var dest mdLink
+	next := end + 1
+	switch {
+	case next < len(s) && s[next] == '(':
+		d, after, ok := parseDestination(s, next+1)
+		if !ok {
+			return 0, false
+		}
+		dest, next = d, after
+	case next < len(s) && s[next] == '[':
+		close := strings.IndexByte(s[next:], ']')
+		if close < 0 {
+			return 0, false
+		}
+		label := s[next+1 : next+close]
+		if label == "" {
+			label = text
+		}
+		d, ok := m.refs[normalizeLabel(label)]
+		if !ok {
+			return 0, false
+		}
+		dest, next = d, next+close+1
+	default:
+		d, ok := m.refs[normalizeLabel(text)]
+		if !ok {
+			return 0, false
+		}
+		dest = d
+	}
+	href := html.EscapeString(safeURL(dest.url))
+	title := ""
+	if dest.title != "" {
+		title = ` title="` + html.EscapeString(dest.title) + `"`
+	}
+	var t strings.Builder
+	m.inline(&t, text)
+	if image {
+		b.WriteString(`<img src="` + href + `" alt="` + html.EscapeString(html.UnescapeString(stripTags(t.String()))) + `"` + title + ">")
+	} else {
+		b.WriteString(`<a href="` + href + `"` + title + ">" + t.String() + "</a>")
+	}
+	return next, true
+}
+
+// parseDestination parses the destination and title of an inline link
+// from s[i], after its opening parenthesis, returning the index after
+// its closing one.
+func parseDestination(s string, i int) (mdLink, int, bool) {
+	skip := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+			i++
+		}
+	}
+	var d mdLink
+	skip()
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexAny(s[i+1:], ">\n")
+		if end < 0 || s[i+1+end] != '>' {
+			return d, 0, false
+		}
+		d.url, i = s[i+1:i+1+end], i+end+2
+	} else {
+		start, depth := i, 0
+		for ; i < len(s) && s[i] != ' ' && s[i] != '\n'; i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		d.url = s[start:min(i, len(s))]
+	}
+	skip()
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := strings.IndexByte(s[i+1:], closer)
+		if end < 0 {
+			return d, 0, false
+		}
+		d.title, i = s[i+1:i+1+end], i+end+2
+		skip()
+	}
+	if i >= len(s) || s[i] != ')' {
+		return d, 0, false
+	}
+	return d, i + 1, true
+}
+
+// safeURL returns u, unless it is a URL that runs script. The scheme is
+// compared as browsers read it: with the tabs and newlines in it dropped
+// and the controls and spaces around it trimmed.
+func safeURL(u string) string {
+	norm := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, u)
+	norm = strings.ToLower(strings.TrimLeft(norm, "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x0b\x0c\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f "))
+	scheme, _, ok := strings.Cut(norm, ":")
+	if ok && (scheme == "javascript" || scheme == "vbscript" || scheme == "data" && !strings.HasPrefix(norm, "data:image/")) {
+		return "#"
+	}
+	return u
+}
+
+// mdLang says how to highlight code in a language.
+type mdLang struct {
+	line     []string  // the starts of comments to the end of the line
+	block    [2]string // the start and end of block comments, if any
+	quotes   string    // the characters strings are quoted with
+	keywords map[string]bool
+	fold     bool // keywords are matched without regard to case
+}
+
+func newLang(line []string, block [2]string, quotes string, fold bool, keywords string) *mdLang {
+	l := &mdLang{line: line, block: block, quotes: quotes, fold: fold, keywords: map[string]bool{}}
+	for _, k := range strings.Fields(keywords) {
+		l.keywords[k] = true
+	}
+	return l
+}
+
+var (
+	cComments  = [2]string{"/*", "*/"}
+	cKeywords  = "auto break case char const continue default do double else enum extern float for goto if inline int long register restrict return short signed sizeof static struct switch typedef union unsigned void volatile while NULL true false bool"
+	jsKeywords = "async await break case catch class const continue debugger default delete do else enum export extends false finally for from function if implements import in instanceof interface let new null of return static super switch this throw true try type typeof undefined var void while with yield"
+
+	mdLangs = map[string]*mdLang{
+		"go":     newLang([]string{"//"}, cComments, "\"'`", false, "break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota any error string int int64 uint8 byte rune bool float64"),
+		"js":     newLang([]string{"//"}, cComments, "\"'`", false, jsKeywords),
+		"ts":     newLang([]string{"//"}, cComments, "\"'`", false, jsKeywords+" string number boolean any unknown never readonly private public protected"),
+		"python": newLang([]string{"#"}, [2]string{}, "\"'", false, "and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self"),
+		"sh":     newLang([]string{"#"}, [2]string{}, "\"'", false, "if then else elif fi case esac for while until do done in function return local export set unset echo exit"),
+		"c":      newLang([]string{"//"}, cComments, "\"'", false, cKeywords),
+		"cpp":    newLang([]string{"//"}, cComments, "\"'", false, cKeywords+" class namespace template typename public private protected virtual override new delete this using nullptr try catch throw auto constexpr"),
+		"java":   newLang([]string{"//"}, cComments, "\"'", false, "abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch synchronized this throw throws try void volatile while true false null var record"),
+		"rust":   newLang([]string{"//"}, cComments, "\"", false, "as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while Some None Ok Err"),
+		"ruby":   newLang([]string{"#"}, [2]string{}, "\"'", false, "alias and begin break case class def do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield require"),
+		"sql":    newLang([]string{"--"}, cComments, "'\"", true, "select from where and or not null is in as on join left right inner outer full cross insert into values update set delete create table view index drop alter add primary key foreign references unique default order by group having limit offset union all distinct case when then else end begin commit rollback"),
+		"json":   newLang(nil, [2]string{}, "\"", false, "true false null"),
+		"yaml":   newLang([]string{"#"}, [2]string{}, "\"'", false, "true false null yes no on off"),
+	}
+	mdLangAliases = map[string]string{
+		"golang": "go", "javascript": "js", "jsx": "js", "typescript": "ts", "tsx": "ts", "py": "python",
+		"bash": "sh", "shell": "sh", "zsh": "sh", "console": "sh", "h": "c", "c++": "cpp", "cc": "cpp", "hpp": "cpp",
+		"rs": "rust", "rb": "ruby", "yml": "yaml",
+	}
+)
+
+// highlight writes code, marking up the keywords, strings, comments and
+// numbers of lang, or writes it plainly if lang is not known.
+func highlight(b *strings.Builder, code, lang string) {
+	if alias, ok := mdLangAliases[lang]; ok {
+		lang = alias
+	}
+	l := mdLangs[lang]
+	if l == nil {
+		b.WriteString(html.EscapeString(code))
+		return
+	}
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">` + html.EscapeString(text) + "</span>")
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if l.block[0] != "" && strings.HasPrefix(rest, l.block[0]) {
+			end := strings.Index(rest[len(l.block[0]):], l.block[1])
+			n := len(rest)
+			if end >= 0 {
+				n = len(l.block[0]) + end + len(l.block[1])
+			}
+			span("com", rest[:n])
+			i += n
+			continue
+		}
+		if lineComment(l, rest, i == 0 || code[i-1] == ' ' || code[i-1] == '\t' || code[i-1] == '\n') {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span("com", rest[:n])
+			i += n
+			continue
+		}
+		c := code[i]
+		switch {
+		case strings.IndexByte(l.quotes, c) >= 0:
+			n := 1
+			for n < len(rest) && rest[n] != c && (rest[n] != '\n' || c == '`') {
+				if rest[n] == '\\' && c != '`' {
+					n++
+				}
+				n++
+			}
+			n = min(n+1, len(rest))
+			span("str", rest[:n])
+			i += n
+		case c >= '0' && c <= '9' && (i == 0 || !isWordByte(code[i-1])):
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("num", rest[:n])
+			i += n
+		case isWordByte(c):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			key := word
+			if l.fold {
+				key = strings.ToLower(word)
+			}
+			if l.keywords[key] && (i == 0 || code[i-1] != '.') {
+				span("kw", word)
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			i += n
+		default:
+			b.WriteString(html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+}
+
+// lineComment reports whether rest starts a comment of l to the end of
+// the line. A # starts one only after a space or at the start of a
+// line, so that it is not taken from words like $#.
+func lineComment(l *mdLang, rest string, afterSpace bool) bool {
+	for _, start := range l.line {
+		if strings.HasPrefix(rest, start) && (start != "#" || afterSpace) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package staticserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"paragraph and inlines", "para *em* **strong** `code` ~~del~~",
+			"<p>para <em>em</em> <strong>strong</strong> <code>code</code> <del>del</del></p>\n"},
+		{"nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"intraword underscore", "a_b_c _em_", "<p>a_b_c <em>em</em></p>\n"},
+		{"unclosed emphasis", "**unclosed", "<p>**unclosed</p>\n"},
+		{"escapes", "a\\*b\\_c", "<p>a*b_c</p>\n"},
+		{"code escapes html", "`a<b`", "<p><code>a&lt;b</code></p>\n"},
+		{"atx headings with ids", "# A\n## A #", "<h1 id=\"a\">A</h1>\n<h2 id=\"a-1\">A</h2>\n"},
+		{"setext headings", "Title\n=====\n\nSub\n---", "<h1 id=\"title\">Title</h1>\n<h2 id=\"sub\">Sub</h2>\n"},
+		{"rule", "***", "<hr>\n"},
+		{"hard breaks", "line  \nbreak\\\nagain", "<p>line<br>\nbreak<br>\nagain</p>\n"},
+		{"blockquote", "> quote", "<blockquote>\n<p>quote</p>\n</blockquote>\n"},
+		{"lists", "- a\n- b\n\n3. c\n4. d",
+			"<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol start=\"3\">\n<li>c</li>\n<li>d</li>\n</ol>\n"},
+		{"loose list", "- a\n\n  para\n- b", "<ul>\n<li><p>a</p>\n<p>para</p>\n</li>\n<li><p>b</p>\n</li>\n</ul>\n"},
+		{"task list", "- [ ] todo\n- [x] done",
+			"<ul>\n<li><input type=\"checkbox\" disabled> todo</li>\n<li><input type=\"checkbox\" checked disabled> done</li>\n</ul>\n"},
+		{"table", "| a | b |\n|:--|--:|\n| 1 | 2 | 3 |",
+			"<table>\n<thead>\n<tr><th style=\"text-align: left\">a</th><th style=\"text-align: right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align: left\">1</td><td style=\"text-align: right\">2</td></tr>\n</tbody>\n</table>\n"},
+		{"indented code", "    code <b>\n    more", "<pre><code>code &lt;b&gt;\nmore\n</code></pre>\n"},
+		{"fenced code", "```\n<tag>\n```", "<pre><code>&lt;tag&gt;\n</code></pre>\n"},
+		{"highlighted code", "```go\nfunc main() {} // hi\n```",
+			"<pre><code class=\"language-go\"><span class=\"kw\">func</span> main() {} <span class=\"com\">// hi</span>\n</code></pre>\n"},
+		{"links", "[a](/u 'T') ![alt *em*](/i.png \"t\")",
+			"<p><a href=\"/u\" title=\"T\">a</a> <img src=\"/i.png\" alt=\"alt em\" title=\"t\"></p>\n"},
+		{"reference link", "[ref]\n\n[Ref]: http://x.com \"T\"", "<p><a href=\"http://x.com\" title=\"T\">ref</a></p>\n"},
+		{"autolinks", "<https://x.org> <me@x.org>",
+			"<p><a href=\"https://x.org\">https://x.org</a> <a href=\"mailto:me@x.org\">me@x.org</a></p>\n"},
+		{"bare link", "see https://example.com/a?b=1 now",
+			"<p>see <a href=\"https://example.com/a?b=1\">https://example.com/a?b=1</a> now</p>\n"},
+		{"entities", "&copy; & &#65; &bogus", "<p>&copy; &amp; &#65; &amp;bogus</p>\n"},
+		{"quote in destination", "[x](\"onmouseover=alert(1))", "<p><a href=\"&#34;onmouseover=alert(1)\">x</a></p>\n"},
+		{"html block kept", "<div>\n*raw*\n</div>", "<div>\n*raw*\n</div>\n"},
+		{"crlf", "a\r\nb\r\n", "<p>a\nb</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := renderMarkdown([]byte(tt.src)); got != tt.want {
+				t.Errorf("renderMarkdown(%q) =\n%q, want\n%q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownTitle(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"no heading", ""},
+		{"# First\n# Second", "First"},
+		{"## I'm *here*", "I'm here"},
+		{"Setext\n===", "Setext"},
+	}
+	for _, tt := range tests {
+		if _, got := renderMarkdown([]byte(tt.src)); got != tt.want {
+			t.Errorf("renderMarkdown(%q) title = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdownUnsafeLinks(t *testing.T) {
+	tests := []string{
+		"[x](javascript:alert(1))",
+		"[x](JaVaScRiPt:alert(1))",
+		"[x](<javascript:alert(1)>)",
+		"[x](java\tscript:alert(1))",
+		"[x](\x01javascript:alert(1))",
+		"[x](vbscript:msgbox)",
+		"[x](data:text/html,<script>)",
+		"![x](javascript:alert(1))",
+		"[x]\n\n[x]: <javascript:alert(1)>",
+	}
+	for _, src := range tests {
+		got, _ := renderMarkdown([]byte(src))
+		if !strings.Contains(got, `="#"`) || strings.Contains(strings.ToLower(got), "script:") {
+			t.Errorf("renderMarkdown(%q) = %q, want the link made harmless", src, got)
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://example.com/", "https://example.com/"},
+		{"/relative?q=javascript:x", "/relative?q=javascript:x"},
+		{"mailto:me@example.com", "mailto:me@example.com"},
+		{"data:image/png;base64,AAAA", "data:image/png;base64,AAAA"},
+		{"javascript:alert(1)", "#"},
+		{" JAVASCRIPT:alert(1)", "#"},
+		{"java\nscript:alert(1)", "#"},
+		{"jav\r\nascript:alert(1)", "#"},
+		{"\x00\x1fjavascript:alert(1)", "#"},
+		{"vbscript:x", "#"},
+		{"data:text/html;base64,PHNjcmlwdD4=", "#"},
+		{"Data:image/svg+xml,<svg>", "Data:image/svg+xml,<svg>"},
+	}
+	for _, tt := range tests {
+		if got := safeURL(tt.in); got != tt.want {
+			t.Errorf("safeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdownPathological(t *testing.T) {
+	// None of these should take long or run out of stack.
+	tests := []string{
+		strings.Repeat("[", 5000),
+		strings.Repeat("*", 5000),
+		strings.Repeat("_a ", 2000),
+		strings.Repeat(">", 5000) + "x",
+		strings.Repeat("- ", 1000) + "x",
+		strings.Repeat("1. ", 1000) + "x",
+		strings.Repeat("`", 5000),
+		strings.Repeat("[a](", 2000),
+		strings.Repeat("![", 2000),
+		strings.Repeat("<", 5000),
+		strings.Repeat("|a", 2000) + "\n" + strings.Repeat("|-", 2000) + "\n" + strings.Repeat("|b", 2000),
+	}
+	for _, src := range tests {
+		start := time.Now()
+		renderMarkdown([]byte(src))
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("renderMarkdown(%.20q...) took %v", src, d)
+		}
+	}
+}
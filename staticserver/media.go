@@ -0,0 +1,87 @@
+package staticserver
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// playlistCache is the Cache-Control of playlists, which a live
+	// stream rewrites every few seconds.
+	playlistCache = "max-age=1"
+	// segmentCache is the Cache-Control of segments, which are never
+	// rewritten, a live stream writing new ones under new names.
+	segmentCache = "max-age=86400"
+)
+
+// mediaTypes are the types of the files of HLS and DASH streams, which
+// the types of the system often lack or get wrong: .ts is TypeScript to
+// some.
+var mediaTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m3u":  "audio/mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".cmfv": "video/mp4",
+	".cmfa": "audio/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt; charset=utf-8",
+}
+
+// isPlaylist reports whether ext is that of an HLS or DASH playlist.
+func isPlaylist(ext string) bool {
+	return ext == ".m3u8" || ext == ".m3u" || ext == ".mpd"
+}
+
+// mediaHandler serves the output of a packager such as ffmpeg so that
+// players on any origin can stream it: the playlists and segments get
+// their types, and most of their ranges and lengths to any origin, and
+// the segments, which are compressed already, are sent as they are.
+// Playlists are cached for a moment and segments for a day, unless a
+// Cache-Control rule says otherwise.
+type mediaHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP serves r with next, as a part of a stream if it is one.
+func (h mediaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Only the files of streams are shared with other origins.
+	ext := strings.ToLower(path.Ext(r.URL.Path))
+	ct, ok := mediaTypes[ext]
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	hdr := w.Header()
+	hdr.Set("Access-Control-Allow-Origin", "*")
+	hdr.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+	if r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		hdr.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		hdr.Set("Access-Control-Allow-Headers", "Range")
+		if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+			hdr.Set("Access-Control-Allow-Headers", h)
+		}
+		hdr.Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	hdr.Set("Content-Type", ct)
+	if hdr.Get("Cache-Control") == "" {
+		if isPlaylist(ext) {
+			hdr.Set("Cache-Control", playlistCache)
+		} else {
+			hdr.Set("Cache-Control", segmentCache)
+		}
+	}
+	if !isPlaylist(ext) {
+		// Not a precompressed sibling: ranges of a segment are ranges of
+		// the segment.
+		r.Header.Del("Accept-Encoding")
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,133 @@
+package staticserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"time"
+)
+
+// memTree is an fs.FS of files indexed in memory, for sources such as
+// archives and repositories whose files cannot be looked up in place.
+// open reads the data of a file from the source.
+type memTree struct {
+	entries map[string]*memEntry
+	open    func(e *memEntry) (io.ReadSeeker, error)
+}
+
+// memEntry is a file or directory in a memTree. It is its own FileInfo.
+type memEntry struct {
+	name    string // the base name
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	src     any      // where the source keeps the data of a file
+	names   []string // the names of the entries in a directory, sorted
+}
+
+// newMemTree returns a memTree of an empty root directory.
+func newMemTree(open func(e *memEntry) (io.ReadSeeker, error)) *memTree {
+	return &memTree{entries: map[string]*memEntry{".": {name: ".", mode: fs.ModeDir | 0o755}}, open: open}
+}
+
+// dir returns the directory entry for name, adding it and the
+// directories above it if they have not been added yet.
+func (t *memTree) dir(name string) *memEntry {
+	if e, ok := t.entries[name]; ok {
+		return e
+	}
+	e := &memEntry{name: path.Base(name), mode: fs.ModeDir | 0o755}
+	t.entries[name] = e
+	t.dir(path.Dir(name)).add(e.name)
+	return e
+}
+
+// file adds the file e at name, and the directories above it.
+func (t *memTree) file(name string, e *memEntry) {
+	e.name = path.Base(name)
+	t.dir(path.Dir(name)).add(e.name)
+	t.entries[name] = e
+}
+
+// add adds name to the entries of the directory e, if it is new.
+func (e *memEntry) add(name string) {
+	if i, found := slices.BinarySearch(e.names, name); !found {
+		e.names = slices.Insert(e.names, i, name)
+	}
+}
+
+// Open opens the named file.
+func (t *memTree) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.IsDir() {
+		return &memDir{t: t, path: name, e: e}, nil
+	}
+	r, err := t.open(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memFile{ReadSeeker: r, e: e}, nil
+}
+
+func (e *memEntry) Name() string       { return e.name }
+func (e *memEntry) Size() int64        { return e.size }
+func (e *memEntry) Mode() fs.FileMode  { return e.mode }
+func (e *memEntry) ModTime() time.Time { return e.modTime }
+func (e *memEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *memEntry) Sys() any           { return nil }
+
+// memFile is an open file in a memTree.
+type memFile struct {
+	io.ReadSeeker
+	e *memEntry
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.e, nil }
+
+func (f *memFile) Close() error {
+	if c, ok := f.ReadSeeker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// memDir is an open directory in a memTree.
+type memDir struct {
+	t    *memTree
+	path string
+	e    *memEntry
+	read int // how many entries ReadDir has returned
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.e, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory, or all that are
+// left if n <= 0.
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.e.names[d.read:]
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		names = names[:min(n, len(names))]
+	}
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(d.t.entries[path.Join(d.path, name)])
+	}
+	d.read += len(names)
+	return entries, nil
+}
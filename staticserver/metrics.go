@@ -0,0 +1,147 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request
+// duration histogram.
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// knownMethods are the request methods given their own label value.
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true, "OPTIONS": true,
+	"PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true, "MOVE": true, "LOCK": true, "UNLOCK": true,
+}
+
+// metrics collects request statistics and serves them in the Prometheus
+// text exposition format.
+type metrics struct {
+	start         time.Time
+	inFlight      atomic.Int64
+	precompressed *precompressStats // the responses sent with compressed siblings, if they are
+
+	mu       sync.Mutex
+	requests map[[2]string]uint64 // by method and status code
+	country  map[string]uint64    // by client country, with -geoip
+	bytes    uint64
+	buckets  []uint64 // cumulative counts, one per latencyBuckets bound
+	count    uint64
+	sum      float64
+}
+
+// newMetrics returns an empty metrics.
+func newMetrics() *metrics {
+	return &metrics{
+		start:    time.Now(),
+		requests: map[[2]string]uint64{},
+		country:  map[string]uint64{},
+		buckets:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// track returns a handler that serves requests with next while counting
+// them as in flight.
+func (m *metrics) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observe counts rr. It is an observer for observeHandler.
+func (m *metrics) observe(rr *requestRecord) {
+	secs := rr.Duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	method := rr.Method
+	if !knownMethods[method] {
+		method = "other" // keep label cardinality bounded
+	}
+	m.requests[[2]string{method, strconv.Itoa(rr.Status)}]++
+	if rr.Country != "" {
+		m.country[rr.Country]++
+	}
+	m.bytes += uint64(rr.Bytes)
+	m.count++
+	m.sum += secs
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the current metrics.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	keys := make([][2]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	fmt.Fprintln(w, "# HELP static_server_requests_total Requests served, by method and status code.")
+	fmt.Fprintln(w, "# TYPE static_server_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "static_server_requests_total{method=%q,code=%q} %d\n", k[0], k[1], m.requests[k])
+	}
+	if len(m.country) > 0 {
+		countries := make([]string, 0, len(m.country))
+		for c := range m.country {
+			countries = append(countries, c)
+		}
+		sort.Strings(countries)
+		fmt.Fprintln(w, "# HELP static_server_requests_by_country_total Requests served, by client country.")
+		fmt.Fprintln(w, "# TYPE static_server_requests_by_country_total counter")
+		for _, c := range countries {
+			fmt.Fprintf(w, "static_server_requests_by_country_total{country=%q} %d\n", c, m.country[c])
+		}
+	}
+	fmt.Fprintln(w, "# HELP static_server_response_bytes_total Response body bytes sent.")
+	fmt.Fprintln(w, "# TYPE static_server_response_bytes_total counter")
+	fmt.Fprintf(w, "static_server_response_bytes_total %d\n", m.bytes)
+	fmt.Fprintln(w, "# HELP static_server_request_duration_seconds Time taken to serve requests.")
+	fmt.Fprintln(w, "# TYPE static_server_request_duration_seconds histogram")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "static_server_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "static_server_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "static_server_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "static_server_request_duration_seconds_count %d\n", m.count)
+	m.mu.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintln(w, "# HELP static_server_requests_in_flight Requests currently being served.")
+	fmt.Fprintln(w, "# TYPE static_server_requests_in_flight gauge")
+	fmt.Fprintf(w, "static_server_requests_in_flight %d\n", m.inFlight.Load())
+	if p := m.precompressed; p != nil {
+		fmt.Fprintln(w, "# HELP static_server_precompressed_responses_total Responses sent in full with a precompressed sibling.")
+		fmt.Fprintln(w, "# TYPE static_server_precompressed_responses_total counter")
+		fmt.Fprintf(w, "static_server_precompressed_responses_total %d\n", p.responses.Load())
+		fmt.Fprintln(w, "# HELP static_server_precompressed_saved_bytes_total Bytes not sent thanks to precompressed siblings.")
+		fmt.Fprintln(w, "# TYPE static_server_precompressed_saved_bytes_total counter")
+		fmt.Fprintf(w, "static_server_precompressed_saved_bytes_total %d\n", p.saved.Load())
+	}
+	fmt.Fprintln(w, "# HELP process_start_time_seconds Start time of the process since the Unix epoch.")
+	fmt.Fprintln(w, "# TYPE process_start_time_seconds gauge")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", m.start.Unix())
+	fmt.Fprintln(w, "# HELP go_goroutines Number of goroutines that currently exist.")
+	fmt.Fprintln(w, "# TYPE go_goroutines gauge")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	fmt.Fprintln(w, "# HELP go_memstats_alloc_bytes Bytes of allocated heap objects.")
+	fmt.Fprintln(w, "# TYPE go_memstats_alloc_bytes gauge")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", mem.HeapAlloc)
+}
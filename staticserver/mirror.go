@@ -0,0 +1,354 @@
+package staticserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorClient fetches files from the upstream of a mirror, following
+// no redirects, which are passed on. It has no overall timeout, as
+// release archives can take long to download, but upstream must start
+// answering within mirrorHeaderTimeout.
+var mirrorClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	Transport:     &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: mirrorHeaderTimeout, IdleConnTimeout: 90 * time.Second},
+}
+
+const (
+	// mirrorHeaderTimeout is how long upstream has to answer a fetch.
+	mirrorHeaderTimeout = 30 * time.Second
+	// mirrorRefreshTimeout is how long a fetch in the background may
+	// take, so that one of a hung upstream does not hold up the requests
+	// for its path when the copy turns too old to serve.
+	mirrorRefreshTimeout = 10 * time.Minute
+	// mirrorFetched is the dir in the dir of a mirror whose empty files
+	// record, in their modification times, when the copies at the same
+	// paths were fetched.
+	mirrorFetched = ".mirror"
+)
+
+// mirrorHandler serves a dir that is a pull-through cache of upstream:
+// a file that is not in dir is fetched from upstream, stored there, and
+// then served by next like any other. Files are kept until removed,
+// or for as long as the cache rules of their paths let them: a file
+// that is too old is fetched again before it is served, or in the
+// background while the stale copy is served if the rule allows it.
+// When each was fetched is kept in mirrorFetched, so that it outlasts
+// the process.
+type mirrorHandler struct {
+	dir      string
+	upstream *url.URL
+	rules    CacheRules
+	next     http.Handler
+
+	mu       sync.Mutex
+	fetching map[string]chan struct{} // closed when the fetch of a path is done
+}
+
+func newMirrorHandler(dir string, upstream *url.URL, rules CacheRules, next http.Handler) *mirrorHandler {
+	return &mirrorHandler{dir: dir, upstream: upstream, rules: rules, next: next, fetching: map[string]chan struct{}{}}
+}
+
+// ServeHTTP fetches the file of r if it is missing or too old, and
+// passes r to next.
+func (h *mirrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remote := path.Clean("/" + r.URL.Path)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || isDotF(remote) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	p := remote
+	if strings.HasSuffix(r.URL.Path, "/") {
+		// A directory that is not in dir is fetched as the index page
+		// upstream serves for it.
+		if h.isDir(remote) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		p, remote = path.Join(remote, "index.html"), strings.TrimSuffix(remote, "/")+"/"
+	}
+	name := filepath.Join(h.dir, filepath.FromSlash(p))
+	l, keep := h.rules.lifetime(r.URL.Path)
+	stale := false
+	if fi, err := os.Stat(name); err == nil {
+		age := h.age(p, fi)
+		switch {
+		case !keep || age <= l.fresh:
+			h.next.ServeHTTP(w, r)
+			return
+		case age <= l.fresh+l.whileRevalidate:
+			debugf("mirror: %s is stale, fetching it in the background", p)
+			go h.refresh(remote, p, name)
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		stale = age <= l.fresh+l.ifError
+	}
+
+	done, mine := h.claim(p)
+	if !mine {
+		// Another request is fetching it; serve what it stored, or ask
+		// upstream again if it stored nothing.
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+		if fi, err := os.Stat(name); err == nil && (!keep || stale || h.age(p, fi) <= l.fresh) {
+			h.next.ServeHTTP(w, r)
+			return
+		}
+	} else {
+		defer h.release(p, done)
+	}
+	err := h.fetch(r.Context(), remote, p, name)
+	var status *upstreamStatus
+	isStatus := errors.As(err, &status)
+	switch {
+	case err == nil:
+		h.next.ServeHTTP(w, r)
+	case stale && (!isStatus || status.status >= 500):
+		infof("warning: mirror: %s: %v, serving the stale copy", p, err)
+		h.next.ServeHTTP(w, r)
+	case isStatus:
+		status.write(w, r)
+	default:
+		infof("warning: mirror: %v", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
+
+// purge removes the copies of the paths match reports true for, so
+// that they are fetched again before they are served, or if match is
+// nil has every copy asked for again of upstream.
+func (h *mirrorHandler) purge(match func(name string) bool) error {
+	return filepath.WalkDir(h.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != h.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(h.dir, name)
+		if err != nil {
+			return err
+		}
+		p := "/" + filepath.ToSlash(rel)
+		if isDotF(p) {
+			return nil
+		}
+		if match == nil {
+			return h.setFetched(p, time.Unix(0, 0))
+		}
+		if !match(p) {
+			return nil
+		}
+		if err := h.remove(p, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		debugf("mirror: purged %s", p)
+		return nil
+	})
+}
+
+// claim marks p as being fetched and returns true, or returns false if
+// another request is fetching it; done is closed when that fetch is.
+func (h *mirrorHandler) claim(p string) (done chan struct{}, mine bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if done, ok := h.fetching[p]; ok {
+		return done, false
+	}
+	done = make(chan struct{})
+	h.fetching[p] = done
+	return done, true
+}
+
+// release marks the fetch of p claimed with done as done.
+func (h *mirrorHandler) release(p string, done chan struct{}) {
+	h.mu.Lock()
+	delete(h.fetching, p)
+	h.mu.Unlock()
+	close(done)
+}
+
+// fetchedName returns the name of the file recording when p was fetched.
+func (h *mirrorHandler) fetchedName(p string) string {
+	return filepath.Join(h.dir, mirrorFetched, filepath.FromSlash(p))
+}
+
+// age returns how long ago the copy of p, whose file fi describes, was
+// fetched or, if that was not recorded, last modified, which was no
+// later.
+func (h *mirrorHandler) age(p string, fi fs.FileInfo) time.Duration {
+	t := fi.ModTime()
+	if st, err := os.Stat(h.fetchedName(p)); err == nil {
+		t = st.ModTime()
+	}
+	return time.Since(t)
+}
+
+// setFetched records that p was fetched at t.
+func (h *mirrorHandler) setFetched(p string, t time.Time) error {
+	name := h.fetchedName(p)
+	if err := os.Chtimes(name, t, t); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, nil, 0o644); err != nil {
+		return err
+	}
+	return os.Chtimes(name, t, t)
+}
+
+// remove removes the copy of p at name, and the record of its fetch.
+func (h *mirrorHandler) remove(p, name string) error {
+	os.Remove(h.fetchedName(p))
+	return os.Remove(name)
+}
+
+// refresh fetches p again in the background, unless a request already is.
+func (h *mirrorHandler) refresh(remote, p, name string) {
+	done, mine := h.claim(p)
+	if !mine {
+		return
+	}
+	defer h.release(p, done)
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorRefreshTimeout)
+	defer cancel()
+	if err := h.fetch(ctx, remote, p, name); err != nil {
+		infof("warning: mirror: refreshing %s: %v", p, err)
+	}
+}
+
+// isDir reports whether the directory p exists in dir.
+func (h *mirrorHandler) isDir(p string) bool {
+	fi, err := os.Stat(filepath.Join(h.dir, filepath.FromSlash(p)))
+	return err == nil && fi.IsDir()
+}
+
+// upstreamStatus is a response of upstream other than 200 OK or 304 Not
+// Modified, which is passed on to the client.
+type upstreamStatus struct {
+	status      int
+	contentType string
+	location    string // where a redirect points
+	body        []byte
+}
+
+func (e *upstreamStatus) Error() string { return "upstream: " + http.StatusText(e.status) }
+
+// write passes the response on to w.
+func (e *upstreamStatus) write(w http.ResponseWriter, r *http.Request) {
+	if e.contentType != "" {
+		w.Header().Set("Content-Type", e.contentType)
+	}
+	if e.location != "" {
+		w.Header().Set("Location", e.location)
+	}
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// fetch gets the path remote from upstream and stores it at name as p,
+// asking only for a newer copy than one already there. A response that
+// is not 200 OK or 304 Not Modified is an *upstreamStatus, and a copy
+// upstream says is not found or gone is removed.
+func (h *mirrorHandler) fetch(ctx context.Context, remote, p, name string) error {
+	u := *h.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + remote
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(name); err == nil {
+		req.Header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		debugf("mirror: %s is unchanged upstream", p)
+		h.stored(p)
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		debugf("mirror: %s upstream: %s", p, resp.Status)
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			h.remove(p, name)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		st := &upstreamStatus{status: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), body: body}
+		if resp.StatusCode/100 == 3 {
+			st.location = h.local(resp.Header.Get("Location"))
+		}
+		return st
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".mirror-")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tmp, resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(name, time.Now(), t)
+	}
+	h.stored(p)
+	infof("mirror: stored %s (%d bytes)", p, n)
+	return nil
+}
+
+// local returns the Location of a redirect of upstream, made a path of
+// the mirror if it points into upstream.
+func (h *mirrorHandler) local(loc string) string {
+	u, err := h.upstream.Parse(loc)
+	if err != nil || u.Scheme != h.upstream.Scheme || u.Host != h.upstream.Host {
+		return loc
+	}
+	prefix := strings.TrimSuffix(h.upstream.Path, "/")
+	if p, ok := strings.CutPrefix(u.Path, prefix); ok && strings.HasPrefix(p, "/") {
+		u.Scheme, u.Host, u.User, u.Path, u.RawPath = "", "", nil, p, ""
+		return u.String()
+	}
+	return loc
+}
+
+// stored records that p was just fetched.
+func (h *mirrorHandler) stored(p string) {
+	if err := h.setFetched(p, time.Now()); err != nil {
+		infof("warning: mirror: %v", err)
+	}
+}
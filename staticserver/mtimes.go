@@ -0,0 +1,212 @@
+package staticserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MtimesName is the name of the manifest Mtimes writes, in the dir it
+// records, unless it is told another.
+const MtimesName = "mtimes.json"
+
+// maxMtimeSums is how many files an mtimeFS holds the sums of. It is
+// more than maxDigests, as listings check every file they list.
+const maxMtimeSums = 1 << 16
+
+// mtimeEntry is what a manifest Mtimes wrote holds of a file.
+type mtimeEntry struct {
+	Mtime  time.Time `json:"mtime"`
+	SHA256 string    `json:"sha256"`
+}
+
+// Mtimes records the modification time and the SHA-256 of every file in
+// dir but the dot files in the manifest, a JSON object of their slash
+// separated paths, which is written to the file manifest, MtimesName in
+// dir if it is empty. A server with the manifest sends those times in
+// Last-Modified, and makes its ETags from them, in place of the times
+// the files have once rsync or a container build has reset them.
+//
+// It is meant to be run at build time, again after every build with the
+// manifest of the last: a file whose content has not changed keeps the
+// time it had, so only the files that did change look new to caches.
+// It returns how many files it recorded, and how many of those kept
+// their times.
+func Mtimes(dir, manifest string) (n, kept int, err error) {
+	if manifest == "" {
+		manifest = filepath.Join(dir, MtimesName)
+	}
+	last := map[string]mtimeEntry{}
+	if b, err := os.ReadFile(manifest); err == nil {
+		if err := json.Unmarshal(b, &last); err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", manifest, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, err
+	}
+	self, _ := filepath.Abs(manifest)
+	files := map[string]mtimeEntry{}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if abs, _ := filepath.Abs(p); abs == self {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sum := fileSHA256(p)
+		if sum == "" {
+			return fmt.Errorf("%s: cannot be read", p)
+		}
+		e := mtimeEntry{Mtime: fi.ModTime().UTC(), SHA256: sum}
+		if l, ok := last[rel]; ok && l.SHA256 == sum {
+			e.Mtime = l.Mtime
+			kept++
+		}
+		files[rel] = e
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.WriteFile(manifest, append(b, '\n'), 0o644); err != nil {
+		return 0, 0, err
+	}
+	return len(files), kept, nil
+}
+
+// mtimeManifest is a manifest Mtimes wrote, read again when it changes.
+type mtimeManifest struct {
+	data *siteData
+}
+
+// loadMtimes reads the manifest file name.
+func loadMtimes(name string) (*mtimeManifest, error) {
+	d, err := loadSiteData(name)
+	if err != nil {
+		return nil, err
+	}
+	d.kind = "mtimes"
+	if _, ok := d.value.(map[string]any); !ok {
+		return nil, fmt.Errorf("%s: expected an object of paths, as the mtimes subcommand writes", name)
+	}
+	return &mtimeManifest{d}, nil
+}
+
+// lookup returns the time and the hex SHA-256 the manifest has for the
+// slash separated path rel, and whether it has them.
+func (m *mtimeManifest) lookup(rel string) (time.Time, string, bool) {
+	files, _ := m.data.get().(map[string]any)
+	e, _ := files[rel].(map[string]any)
+	s, _ := e["mtime"].(string)
+	sum, _ := e["sha256"].(string)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || sum == "" {
+		return time.Time{}, "", false
+	}
+	return t, sum, true
+}
+
+// mtimeFS is an http.FileSystem whose files have the times their
+// manifest has for them, as long as they still hold what it recorded:
+// a file changed since, by a write or a deploy of a build without a new
+// manifest, has its own time, so that caches do not keep a stale copy.
+// The sums it checks the files with are kept until the files change.
+type mtimeFS struct {
+	http.FileSystem
+	m    *mtimeManifest
+	sums *digestCache
+}
+
+func newMtimeFS(fsys http.FileSystem, m *mtimeManifest) mtimeFS {
+	return mtimeFS{FileSystem: fsys, m: m, sums: newDigestCache(maxMtimeSums)}
+}
+
+// info returns fi, which describes the file name, with the time of the
+// manifest if it has one for the file.
+func (fs mtimeFS) info(name string, fi os.FileInfo) os.FileInfo {
+	if !fi.Mode().IsRegular() {
+		return fi
+	}
+	t, want, ok := fs.m.lookup(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if !ok || t.Equal(fi.ModTime()) {
+		return fi
+	}
+	sum, err := fs.sums.sum(fs.FileSystem, name, "sha256")
+	if err != nil || hex.EncodeToString(sum) != want {
+		return fi
+	}
+	return mtimeInfo{fi, t}
+}
+
+// Open opens name, with the time of the manifest.
+func (fs mtimeFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return mtimeF{File: f, fs: fs, name: name}, nil
+}
+
+// mtimeF is a file of an mtimeFS, which it and the files it lists have
+// the times of the manifest of.
+type mtimeF struct {
+	http.File
+	fs   mtimeFS
+	name string
+}
+
+// Stat describes the file, with the time of the manifest.
+func (f mtimeF) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return f.fs.info(f.name, fi), nil
+}
+
+// Readdir lists the dir, with the times of the manifest.
+func (f mtimeF) Readdir(n int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(n)
+	for i, fi := range fis {
+		fis[i] = f.fs.info(path.Join(f.name, fi.Name()), fi)
+	}
+	return fis, err
+}
+
+// mtimeInfo is the os.FileInfo of a file with the time of a manifest.
+type mtimeInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (fi mtimeInfo) ModTime() time.Time { return fi.modTime }
@@ -0,0 +1,41 @@
+package staticserver
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// normalizeHandler rewrites the path of every request to its normal form
+// before it reaches next, so that every later check and lookup sees the
+// same path for /a//b, /a/./b and /a/%2E/b.
+type normalizeHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP normalizes the path of r and serves it with h.next. A path
+// with a NUL in it names no file and is refused.
+func (h normalizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.IndexByte(r.URL.Path, 0) >= 0 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if p := normalizePath(r.URL.Path); p != r.URL.Path || r.URL.RawPath != "" {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = p, ""
+		r = r2
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// normalizePath returns the decoded path p with backslashes treated as
+// separators, empty and dot segments removed and .. segments resolved,
+// keeping any trailing slash. Segments can never climb above the root.
+func normalizePath(p string) string {
+	p = strings.ReplaceAll(p, `\`, "/")
+	clean := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
@@ -0,0 +1,69 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"/a//b", "/a/b"},
+		{"/a/./b/", "/a/b/"},
+		{"/a/../b", "/b"},
+		{"/..", "/"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"/a/../../..//etc/", "/etc/"},
+		{`\..\..\etc\passwd`, "/etc/passwd"},
+		{`/a\..\..\b`, "/b"},
+		{`/a\b\`, "/a/b/"},
+		{"..", "/"},
+		{"../x", "/x"},
+		{"/.../x", "/.../x"},
+		{"/a/..b", "/a/..b"},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.in); got != tt.want {
+			t.Errorf("normalizePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHandler(t *testing.T) {
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/a/b", http.StatusOK, "/a/b"},
+		{"/%2e%2e/%2e%2e/etc/passwd", http.StatusOK, "/etc/passwd"},
+		{"/a/%2E%2E/%2e%2E/b", http.StatusOK, "/b"},
+		{"/a/%2e/b", http.StatusOK, "/a/b"},
+		{"/a%2f..%2f..%2fetc", http.StatusOK, "/etc"},
+		{"/a%2F%2E%2E%2F%2E%2E%2Fetc%2F", http.StatusOK, "/etc/"},
+		{"/a%5c..%5c..%5cetc", http.StatusOK, "/etc"},
+		{`/a\..\..\etc`, http.StatusOK, "/etc"},
+		{"/%2e%2e%5c%2e%2e%5cetc", http.StatusOK, "/etc"},
+		{"/a%00.txt", http.StatusBadRequest, ""},
+		{"/a/%00/../b", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		var got, raw string
+		h := normalizeHandler{next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, raw = r.URL.Path, r.URL.RawPath
+		})}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, tt.status)
+		}
+		if got != tt.want || raw != "" {
+			t.Errorf("GET %s: next saw %q (raw %q), want %q", tt.target, got, raw, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// writeEvent describes a change made through one of the write endpoints.
+// It is POSTed as JSON to the -write-webhook URL.
+type writeEvent struct {
+	Time   string `json:"time"`
+	Event  string `json:"event"` // created, replaced or deleted
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Client string `json:"client"`
+}
+
+// notify POSTs ev to the store's webhook, if it has one, in the
+// background. Failures are logged and otherwise ignored.
+func (s *store) notify(ev writeEvent) {
+	if s.webhook == "" {
+		return
+	}
+	ev.Time = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Println("write webhook:", err)
+		return
+	}
+	go func() {
+		resp, err := webhookClient.Post(s.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("write webhook:", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("write webhook: %s responded %s", s.webhook, resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package staticserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ObjectCache says where to keep copies of the objects read from a
+// bucket, so objects that are asked for again are served from disk.
+// The copies are checked against the size and modification time of
+// the object each time it is served.
+type ObjectCache struct {
+	Dir     string // no objects are cached if empty
+	MaxSize int64  // the most bytes of copies to keep, dropping the least recently used, or 0 for no limit
+}
+
+// newObjectCache returns the cache c describes, or nil if it describes
+// none.
+func newObjectCache(c ObjectCache) (*objectCache, error) {
+	if c.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &objectCache{dir: c.Dir, maxSize: c.MaxSize}, nil
+}
+
+// objectCache keeps copies of objects in dir, one file per version of
+// an object, whose modification times record when they were last used.
+type objectCache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex // serializes evictions
+}
+
+// path returns the name of the copy of this version of the object.
+func (c *objectCache) path(info objectInfo) string {
+	sum := sha256.Sum256([]byte(info.key + "\x00" + strconv.FormatInt(info.size, 10) + "\x00" + info.modTime.UTC().String()))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16]))
+}
+
+// open returns the copy of the object from off, if the cache has one.
+func (c *objectCache) open(info objectInfo, off int64) (io.ReadCloser, bool) {
+	name := c.path(info)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		f.Close()
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return f, true
+}
+
+// fill returns body, copying what is read from it to the cache. The
+// copy is kept once the whole object has been read.
+func (c *objectCache) fill(info objectInfo, body io.ReadCloser) io.ReadCloser {
+	if c.maxSize > 0 && info.size > c.maxSize {
+		return body
+	}
+	tmp, err := os.CreateTemp(c.dir, ".fill-")
+	if err != nil {
+		return body
+	}
+	return &cacheFill{body: body, tmp: tmp, c: c, info: info}
+}
+
+// purge removes every copy.
+func (c *objectCache) purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && e.Name()[0] != '.' {
+			if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evict removes the least recently used copies until they fit in
+// maxSize.
+func (c *objectCache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type copyFile struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var copies []copyFile
+	var total int64
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() || e.Name()[0] == '.' {
+			continue
+		}
+		copies = append(copies, copyFile{e.Name(), fi.Size(), fi.ModTime()})
+		total += fi.Size()
+	}
+	sort.Slice(copies, func(i, j int) bool { return copies[i].used.Before(copies[j].used) })
+	for _, cf := range copies {
+		if total <= c.maxSize {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, cf.name)) == nil {
+			total -= cf.size
+		}
+	}
+}
+
+// cacheFill copies an object to a temporary file as it is read, and
+// moves it into the cache if all of it is.
+type cacheFill struct {
+	body io.ReadCloser
+	tmp  *os.File
+	c    *objectCache
+	info objectInfo
+	n    int64
+	err  error // the first error writing tmp, after which it is abandoned
+}
+
+func (f *cacheFill) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	if n > 0 && f.err == nil {
+		_, f.err = f.tmp.Write(p[:n])
+		f.n += int64(n)
+	}
+	return n, err
+}
+
+func (f *cacheFill) Close() error {
+	err := f.body.Close()
+	f.tmp.Close()
+	if f.err == nil && f.n == f.info.size && os.Rename(f.tmp.Name(), f.c.path(f.info)) == nil {
+		f.c.evict()
+		return err
+	}
+	os.Remove(f.tmp.Name())
+	return err
+}
@@ -0,0 +1,228 @@
+package staticserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// objectStore is a bucket of objects named by keys, as cloud storage
+// services keep them. Directories are implied by slashes in the keys.
+type objectStore interface {
+	// stat describes the object at key, or fails with fs.ErrNotExist.
+	stat(key string) (objectInfo, error)
+	// list returns the objects directly under prefix and the prefixes,
+	// each ending in a slash, of the keys further beneath it.
+	list(prefix string) (objects []objectInfo, prefixes []string, err error)
+	// get reads the object at key from off to its end.
+	get(key string, off int64) (io.ReadCloser, error)
+}
+
+// objectInfo describes an object.
+type objectInfo struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// objectFS is an fs.FS of the objects in a store under prefix, which
+// is empty or ends in a slash. Objects are read as they are served,
+// with ranged reads when clients ask for parts of them.
+type objectFS struct {
+	store  objectStore
+	prefix string
+	cache  *objectCache // keeps copies of the objects read, if non-nil
+}
+
+// newObjectFS returns the objectFS of the objects in store under
+// prefix, cached as c says.
+func newObjectFS(store objectStore, prefix string, c ObjectCache) (objectFS, error) {
+	cache, err := newObjectCache(c)
+	if err != nil {
+		return objectFS{}, err
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return objectFS{store: store, prefix: prefix, cache: cache}, nil
+}
+
+// get reads the object from off to its end, from the cache if it has
+// a copy and caching what is read if it does not.
+func (o objectFS) get(info objectInfo, off int64) (io.ReadCloser, error) {
+	if o.cache == nil {
+		return o.store.get(info.key, off)
+	}
+	if r, ok := o.cache.open(info, off); ok {
+		return r, nil
+	}
+	body, err := o.store.get(info.key, off)
+	if err != nil || off > 0 {
+		return body, err
+	}
+	return o.cache.fill(info, body), nil
+}
+
+// Purge removes the copies of the objects kept in the cache, if any.
+func (o objectFS) Purge() error {
+	if o.cache == nil {
+		return nil
+	}
+	return o.cache.purge()
+}
+
+// Open opens the object, or the directory of objects, at name.
+func (o objectFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &objectDir{fs: o, info: objectInfo{key: o.prefix}}, nil
+	}
+	info, err := o.store.stat(o.prefix + name)
+	if err == nil {
+		return &objectFile{fs: o, info: info}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	objects, prefixes, err := o.store.list(o.prefix + name + "/")
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &objectDir{fs: o, info: objectInfo{key: o.prefix + name + "/"}, objects: objects, prefixes: prefixes, listed: true}, nil
+}
+
+// objectFileInfo is the fs.FileInfo of an object or directory.
+type objectFileInfo struct {
+	info objectInfo
+	dir  bool
+}
+
+func (fi objectFileInfo) Name() string       { return path.Base(strings.TrimSuffix(fi.info.key, "/")) }
+func (fi objectFileInfo) Size() int64        { return fi.info.size }
+func (fi objectFileInfo) ModTime() time.Time { return fi.info.modTime }
+func (fi objectFileInfo) IsDir() bool        { return fi.dir }
+func (fi objectFileInfo) Sys() any           { return nil }
+
+func (fi objectFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// objectFile is an open object. It reads from the store lazily and
+// seeks by starting a new ranged read.
+type objectFile struct {
+	fs   objectFS
+	info objectInfo
+	pos  int64
+
+	body    io.ReadCloser // the read in progress, if any
+	bodyPos int64         // where body has read to
+}
+
+func (f *objectFile) Stat() (fs.FileInfo, error) {
+	return objectFileInfo{info: f.info}, nil
+}
+
+func (f *objectFile) Read(p []byte) (int, error) {
+	if f.pos >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body != nil && f.bodyPos != f.pos {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		body, err := f.fs.get(f.info, f.pos)
+		if err != nil {
+			return 0, err
+		}
+		f.body, f.bodyPos = body, f.pos
+	}
+	n, err := f.body.Read(p)
+	f.bodyPos += int64(n)
+	f.pos = f.bodyPos
+	return n, err
+}
+
+func (f *objectFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *objectFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// objectDir is an open directory of objects, listed on first read.
+type objectDir struct {
+	fs       objectFS
+	info     objectInfo
+	objects  []objectInfo
+	prefixes []string
+	listed   bool
+	entries  []fs.DirEntry // those left to return from ReadDir
+}
+
+func (d *objectDir) Stat() (fs.FileInfo, error) {
+	return objectFileInfo{info: d.info, dir: true}, nil
+}
+
+func (d *objectDir) Read([]byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *objectDir) Close() error { return nil }
+
+// ReadDir returns the next n entries of the directory, or all that are
+// left if n <= 0.
+func (d *objectDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		var err error
+		if d.objects, d.prefixes, err = d.fs.store.list(d.info.key); err != nil {
+			return nil, err
+		}
+		d.listed = true
+	}
+	if d.entries == nil {
+		d.entries = []fs.DirEntry{}
+		for _, p := range d.prefixes {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(objectFileInfo{info: objectInfo{key: p}, dir: true}))
+		}
+		for _, o := range d.objects {
+			if o.key != d.info.key { // the marker some tools create for a directory
+				d.entries = append(d.entries, fs.FileInfoToDirEntry(objectFileInfo{info: o}))
+			}
+		}
+	}
+	entries := d.entries
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	d.entries = d.entries[len(entries):]
+	return entries, nil
+}
@@ -0,0 +1,175 @@
+package staticserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// requestRecord describes a request once it has been served.
+type requestRecord struct {
+	ID       string // the request ID, if -request-id is set
+	Start    time.Time
+	Duration time.Duration
+	IP       string
+	User     string // the user the request was authenticated as, if any
+	Claimed  string // the user name of credentials that were refused, if any
+	Method   string
+	URI      string // the request URI as sent by the client
+	Path     string // the normalized path that was served
+	Proto    string
+	Host     string
+	Status   int
+	Bytes    int64
+	Referer  string
+	UA       string
+	Country  string // the client's ISO country code, if -geoip is set
+	City     string // the client's city, if -geoip is set and knows it
+}
+
+// requestUser is who a request was made by, as the handlers that check
+// its credentials find out, for the record of the request.
+type requestUser struct {
+	name    string // the user authenticated, if any
+	claimed string // the user of the credentials refused, if any
+}
+
+type requestUserKey struct{}
+
+// setUser records that r was made by user, if ok, or with the refused
+// credentials of user if not. A user authenticated is kept over one
+// claimed later, such as for a dir whose auth the user is not in.
+func setUser(r *http.Request, user string, ok bool) {
+	u, _ := r.Context().Value(requestUserKey{}).(*requestUser)
+	switch {
+	case u == nil:
+	case ok:
+		u.name = user
+	case u.name == "":
+		u.claimed = user
+	}
+}
+
+// observeHandler serves requests with next and passes a record of each
+// one, once it has been served, to every observer in turn.
+type observeHandler struct {
+	observers []func(*requestRecord)
+	geo       *geoDB // locates clients, if not nil
+	anonymize bool   // record only the network part of client IPs
+	next      http.Handler
+}
+
+// ServeHTTP serves r with h.next and reports it to the observers.
+func (h observeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &responseRecorder{ResponseWriter: w}
+	start := time.Now()
+	uri := r.RequestURI
+	u := &requestUser{}
+	r = r.WithContext(context.WithValue(r.Context(), requestUserKey{}, u))
+	h.next.ServeHTTP(rec, r)
+
+	if u.name == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		// A client certificate checked by the listener.
+		u.name = "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	rr := &requestRecord{
+		ID:       r.Header.Get(requestIDHeader),
+		Start:    start,
+		Duration: time.Since(start),
+		IP:       clientIP(r),
+		User:     u.name,
+		Claimed:  u.claimed,
+		Method:   r.Method,
+		URI:      uri,
+		Path:     normalizePath(r.URL.Path),
+		Proto:    r.Proto,
+		Host:     r.Host,
+		Status:   rec.Status(),
+		Bytes:    rec.bytes,
+		Referer:  r.Referer(),
+		UA:       r.UserAgent(),
+	}
+	if h.geo != nil {
+		rr.Country, rr.City = h.geo.lookup(rr.IP)
+	}
+	if h.anonymize {
+		rr.IP = anonymizeIP(rr.IP)
+	}
+	for _, observe := range h.observers {
+		observe(rr)
+	}
+}
+
+// responseRecorder is an http.ResponseWriter that records the status
+// and number of body bytes of the response written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Status returns the status of the response, which is 200 if the
+// handler wrote a body without calling WriteHeader.
+func (w *responseRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// WriteHeader records and sends the status of the response.
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records and sends part of the response body.
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom sends the response body from r, letting the underlying
+// writer use sendfile and the like where it can.
+func (w *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := readFrom(w.ResponseWriter, r)
+	w.bytes += n
+	return n, err
+}
+
+// Flush sends any buffered response data to the client.
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.status = http.StatusSwitchingProtocols
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
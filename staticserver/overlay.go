@@ -0,0 +1,124 @@
+package staticserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// OverlayFS is an fs.FS of layers stacked in order: a file is opened
+// from the first layer that has it, and the listing of a directory
+// merges its entries in every layer, so an override directory can
+// shadow the files of a shared base.
+type OverlayFS []fs.FS
+
+// Open opens the named file from the first layer that has it.
+func (o OverlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	var dir *overlayDir
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			if dir != nil {
+				dir.Close()
+			}
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			if dir != nil {
+				dir.Close()
+			}
+			return nil, err
+		}
+		switch {
+		case !fi.IsDir() && dir == nil:
+			return f, nil
+		case !fi.IsDir():
+			f.Close() // shadowed by a directory above it
+		case dir == nil:
+			dir = &overlayDir{File: f, name: name, layers: []fs.File{f}}
+		default:
+			dir.layers = append(dir.layers, f)
+		}
+	}
+	if dir == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return dir, nil
+}
+
+// overlayDir is a directory open in one or more layers, the first of
+// which it is stated as.
+type overlayDir struct {
+	fs.File
+	name    string
+	layers  []fs.File
+	entries []fs.DirEntry // merged on the first ReadDir
+	read    int           // how many entries ReadDir has returned
+	merged  bool
+}
+
+// ReadDir returns the next n entries of the directory, or all that are
+// left if n <= 0. An entry in a layer hides entries of the same name in
+// the layers below it.
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.merged {
+		seen := map[string]bool{}
+		for _, f := range d.layers {
+			rd, ok := f.(fs.ReadDirFile)
+			if !ok {
+				return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: errors.ErrUnsupported}
+			}
+			entries, err := rd.ReadDir(-1)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !seen[e.Name()] {
+					seen[e.Name()] = true
+					d.entries = append(d.entries, e)
+				}
+			}
+		}
+		slices.SortFunc(d.entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+		d.merged = true
+	}
+	entries := d.entries[d.read:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	d.read += len(entries)
+	return entries, nil
+}
+
+// Close closes the directory in every layer.
+func (d *overlayDir) Close() error {
+	var errs []error
+	for _, f := range d.layers {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the layers that need it, such as archives.
+func (o OverlayFS) Close() error {
+	var errs []error
+	for _, layer := range o {
+		if c, ok := layer.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,193 @@
+package staticserver
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// perIPLimit caps the requests in flight from each client, so that one
+// client with many connections cannot starve the others. Clients are
+// told apart by their IPv4 address or the /64 network of their IPv6 one,
+// which a single host often holds many addresses of.
+type perIPLimit struct {
+	max        int           // the most requests in flight from a client
+	wait       time.Duration // how long a request over max waits for its turn before 429
+	retryAfter time.Duration // the Retry-After of 429 responses
+	pages      []limitPage   // the bodies of 429 responses, by type
+	challenge  *challenge    // asks browsers over max to show they are not bots, if non-nil
+
+	mu      sync.Mutex
+	clients map[string]*clientSlots
+}
+
+// clientSlots holds the places of the requests of a client in flight.
+type clientSlots struct {
+	sem   chan struct{}
+	users int // the requests in flight or waiting, the slots are forgotten at 0
+}
+
+// limitPage is a body of 429 responses.
+type limitPage struct {
+	contentType string
+	body        []byte
+}
+
+// newPerIPLimit returns the limit of cfg, with its pages read now.
+func newPerIPLimit(cfg Config) (*perIPLimit, error) {
+	l := &perIPLimit{max: cfg.PerIPRequests, wait: cfg.PerIPWait, retryAfter: cfg.PerIPRetryAfter, clients: map[string]*clientSlots{}}
+	if l.retryAfter <= 0 {
+		l.retryAfter = max(l.wait, time.Second)
+	}
+	for _, name := range cfg.PerIPPages {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		ct := mime.TypeByExtension(filepath.Ext(name))
+		if ct == "" {
+			ct = http.DetectContentType(b)
+		}
+		l.pages = append(l.pages, limitPage{ct, b})
+	}
+	if cfg.PerIPChallenge {
+		c, err := newChallenge(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l.challenge = c
+	}
+	return l, nil
+}
+
+// page returns the body of a 429 response to r: the first page of a
+// type r accepts, else the first page, else a JSON object to clients
+// that accept JSON and plain text to the others.
+func (l *perIPLimit) page(r *http.Request) limitPage {
+	accept := r.Header.Get("Accept")
+	for _, p := range l.pages {
+		mediaType, _, _ := mime.ParseMediaType(p.contentType)
+		if mediaType != "" && strings.Contains(accept, mediaType) {
+			return p
+		}
+	}
+	if len(l.pages) > 0 {
+		return l.pages[0]
+	}
+	retry := l.retryAfterSeconds()
+	if strings.Contains(accept, "application/json") {
+		return limitPage{"application/json", []byte(fmt.Sprintf(`{"error":"too many requests","retry_after":%d}`+"\n", retry))}
+	}
+	return limitPage{"text/plain; charset=utf-8", []byte(http.StatusText(http.StatusTooManyRequests) + "\n")}
+}
+
+// retryAfterSeconds returns the Retry-After of 429 responses.
+func (l *perIPLimit) retryAfterSeconds() int {
+	return max(1, int(l.retryAfter.Round(time.Second).Seconds()))
+}
+
+// clientKey returns what the requests of the client ip are counted by.
+func clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	if addr = addr.Unmap(); addr.Is4() {
+		return addr.String()
+	}
+	p, _ := addr.Prefix(64)
+	return p.String()
+}
+
+// acquire takes one of n places for a request of client key, waiting up
+// to l.wait or until done is closed, and reports whether it got one.
+func (l *perIPLimit) acquire(key string, n int, done <-chan struct{}) (*clientSlots, bool) {
+	l.mu.Lock()
+	c := l.clients[key]
+	if c == nil {
+		c = &clientSlots{sem: make(chan struct{}, n)}
+		l.clients[key] = c
+	}
+	c.users++
+	l.mu.Unlock()
+
+	select {
+	case c.sem <- struct{}{}:
+		return c, true
+	default:
+	}
+	if l.wait > 0 {
+		t := time.NewTimer(l.wait)
+		defer t.Stop()
+		select {
+		case c.sem <- struct{}{}:
+			return c, true
+		case <-t.C:
+		case <-done:
+		}
+	}
+	l.leave(key, c)
+	return nil, false
+}
+
+// release frees the place a request of client key took.
+func (l *perIPLimit) release(key string, c *clientSlots) {
+	<-c.sem
+	l.leave(key, c)
+}
+
+// leave forgets the slots of client key once no request uses them.
+func (l *perIPLimit) leave(key string, c *clientSlots) {
+	l.mu.Lock()
+	if c.users--; c.users == 0 {
+		delete(l.clients, key)
+	}
+	l.mu.Unlock()
+}
+
+// handler returns next with the requests over the limit answered with
+// 429 Too Many Requests, or the challenge for browsers if there is one.
+// The clients that passed it are counted apart, with challengeRaise
+// times the places, and get the 429 past those.
+func (l *perIPLimit) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed := false
+		if l.challenge != nil {
+			if r.URL.Path == challengePath {
+				l.challenge.answer(w, r)
+				return
+			}
+			passed = l.challenge.passed(r)
+		}
+		key, n := clientKey(clientIP(r)), l.max
+		if passed {
+			key, n = key+" passed", l.max*challengeRaise
+		}
+		c, ok := l.acquire(key, n, r.Context().Done())
+		if !ok {
+			debugf("perip: %s has %d requests in flight, refusing %s", key, n, r.URL.Path)
+			if l.challenge != nil && !passed && (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				l.challenge.ask(w, r, http.StatusTooManyRequests, false, r.URL.RequestURI())
+				return
+			}
+			p := l.page(r)
+			w.Header().Set("Retry-After", strconv.Itoa(l.retryAfterSeconds()))
+			w.Header().Set("Content-Type", p.contentType)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusTooManyRequests)
+			if r.Method != http.MethodHead {
+				w.Write(p.body)
+			}
+			return
+		}
+		defer l.release(key, c)
+		next.ServeHTTP(w, r)
+	})
+}
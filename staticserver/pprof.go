@@ -0,0 +1,16 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof mounts the runtime profiling endpoints under
+// /debug/pprof/ on mux.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
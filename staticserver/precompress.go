@@ -0,0 +1,590 @@
+package staticserver
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// minPrecompress is the size of the smallest file worth compressing.
+const minPrecompress = 256
+
+// compressibleExts are the extensions of the files that are compressed:
+// text and the binary formats that are not compressed already.
+var compressibleExts = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true, ".json": true, ".map": true,
+	".xml": true, ".svg": true, ".txt": true, ".md": true, ".csv": true, ".webmanifest": true,
+	".wasm": true, ".ico": true, ".ttf": true, ".otf": true, ".eot": true,
+}
+
+// PrecompressRules leave out of precompression files that would be
+// compressed for their type, so no time is spent on those that gain
+// little: the small ones, and those of the Skip rules, each a path
+// pattern like /vendor/ or /*.min.js, an extension like .svg or a media
+// type like image/*.
+type PrecompressRules struct {
+	MinSize int64 // the size of the smallest file compressed, 256 bytes if 0
+	Skip    []string
+}
+
+// Check reports the first rule that is not a path pattern, extension or
+// media type.
+func (rules PrecompressRules) Check() error {
+	if rules.MinSize < 0 {
+		return fmt.Errorf("precompress min size %d out of range, expected a positive size", rules.MinSize)
+	}
+	for _, rule := range rules.Skip {
+		var err error
+		switch {
+		case strings.HasPrefix(rule, "/"):
+			if !validPathPattern(rule) {
+				err = errors.New("bad pattern")
+			}
+		case strings.HasPrefix(rule, "."):
+			if strings.Contains(rule, "/") {
+				err = errors.New("bad extension")
+			}
+		case strings.Contains(rule, "/"):
+			_, err = path.Match(rule, "")
+		default:
+			err = errors.New("unknown rule")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid precompress skip rule %q, expected a path pattern like /vendor/, an extension like .svg or a type like image/*", rule)
+		}
+	}
+	return nil
+}
+
+// skips reports whether the rules leave out the file at the slash
+// separated path p, with a leading slash, of size bytes.
+func (rules PrecompressRules) skips(p string, size int64) bool {
+	if size < cmp.Or(rules.MinSize, minPrecompress) {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(p))
+	typ, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	for _, rule := range rules.Skip {
+		switch {
+		case strings.HasPrefix(rule, "/"):
+			if matchPaths([]string{rule}, p) {
+				return true
+			}
+		case strings.HasPrefix(rule, "."):
+			if strings.EqualFold(rule, ext) {
+				return true
+			}
+		case typ != "":
+			if ok, _ := path.Match(strings.ToLower(rule), strings.TrimSpace(typ)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// precompression is a format of the compressed siblings of files.
+type precompression struct {
+	ext      string // of the sibling, after the file's own
+	encoding string // the Content-Encoding it is served with
+	command  []string
+}
+
+// precompressions are the formats, best first. Brotli and Zstandard have
+// no package in the standard library, so they are made by their commands.
+var precompressions = []precompression{
+	{".br", "br", []string{"brotli", "-c", "-q", "11"}},
+	{".zst", "zstd", []string{"zstd", "-c", "-q", "-19"}},
+	{".gz", "gzip", nil},
+}
+
+// PrecompressFormats are the names of the formats Precompress can write.
+var PrecompressFormats = []string{"gz", "br", "zst"}
+
+// availablePrecompressFormats returns the PrecompressFormats that can
+// be written, those whose commands are found.
+func availablePrecompressFormats() []string {
+	var formats []string
+	for _, p := range precompressions {
+		if p.command != nil {
+			if _, err := exec.LookPath(p.command[0]); err != nil {
+				continue
+			}
+		}
+		formats = append(formats, strings.TrimPrefix(p.ext, "."))
+	}
+	return formats
+}
+
+// Precompress writes a compressed sibling of each compressible file in
+// dir in each of formats, such as app.js.gz, app.js.br and app.js.zst
+// for "gz", "br" and "zst", with workers files compressed at once, or as
+// many as there are CPUs if workers is 0. A sibling is given the mtime of
+// its file, and one that has it already is up to date and left alone;
+// one that would not be smaller than its file is not written, and
+// removed if it was. The br and zst formats need the brotli and zstd
+// commands; a format whose command is not found is skipped with a
+// warning. Dot files, and the files rules skip, are left out, and the
+// siblings the latter had are removed. It returns the number of siblings
+// written.
+func Precompress(dir string, formats []string, workers int, rules PrecompressRules) (int, error) {
+	if err := rules.Check(); err != nil {
+		return 0, err
+	}
+	use, err := usePrecompressions(formats)
+	if err != nil {
+		return 0, err
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	names := make(chan [2]string)
+	var (
+		mu      sync.Mutex
+		written int
+		failed  error
+		wg      sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				n, err := precompressFile(name[0], name[1], use, rules)
+				mu.Lock()
+				written += n
+				if err != nil && failed == nil {
+					failed = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	err = walkCompressible(dir, func(name, rel string) { names <- [2]string{name, rel} })
+	close(names)
+	wg.Wait()
+	if err != nil {
+		return written, err
+	}
+	return written, failed
+}
+
+// usePrecompressions returns the precompressions of the formats, with
+// those whose commands are not found left out.
+func usePrecompressions(formats []string) ([]precompression, error) {
+	var use []precompression
+	for _, name := range formats {
+		var p *precompression
+		for i := range precompressions {
+			if precompressions[i].ext == "."+name {
+				p = &precompressions[i]
+			}
+		}
+		if p == nil {
+			return nil, fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(PrecompressFormats, ", "))
+		}
+		if p.command != nil {
+			if _, err := exec.LookPath(p.command[0]); err != nil {
+				infof("warning: precompress: skipping %s, as there is no %s command", name, p.command[0])
+				continue
+			}
+		}
+		use = append(use, *p)
+	}
+	return use, nil
+}
+
+// walkCompressible calls f with the name of every compressible file in
+// dir, and its slash separated path under dir with a leading slash,
+// leaving dot files out.
+func walkCompressible(dir string, f func(name, rel string)) error {
+	return filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && compressibleExts[strings.ToLower(filepath.Ext(name))] {
+			rel, err := filepath.Rel(dir, name)
+			if err != nil {
+				return err
+			}
+			f(name, "/"+filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+// remakePrecompressed removes the siblings of the compressible files in
+// dir whose paths under it match reports true for, and writes them
+// again, so that siblings a deploy left with the mtimes of their files
+// are not served for content that changed. It returns the number of
+// siblings written.
+func remakePrecompressed(dir string, formats []string, rules PrecompressRules, match func(name string) bool) (int, error) {
+	use, err := usePrecompressions(formats)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	var failed error
+	err = walkCompressible(dir, func(name, rel string) {
+		if !match(rel) {
+			return
+		}
+		for _, p := range use {
+			os.Remove(name + p.ext)
+		}
+		n, err := precompressFile(name, rel, use, rules)
+		written += n
+		if err != nil && failed == nil {
+			failed = err
+		}
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, failed
+}
+
+// precompressWatcher keeps the siblings of the files of the dirs it
+// is told the changes of up to date in the background: those of a file
+// changed are written again, and those of a file removed are removed, so
+// that none is left behind for an editor to find. The siblings it writes
+// are changes to the dirs too, and are passed over.
+type precompressWatcher struct {
+	dirs    []string
+	formats []precompression
+	rules   PrecompressRules
+	wake    chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	pending map[fileKey]bool // the files changed
+}
+
+// newPrecompressWatcher returns a watcher that writes the siblings of
+// the files of dirs in the formats and starts it, with the siblings of
+// every file in dirs brought up to date first. The files rules skip are
+// left out.
+func newPrecompressWatcher(dirs, formats []string, rules PrecompressRules) (*precompressWatcher, error) {
+	use, err := usePrecompressions(formats)
+	if err != nil {
+		return nil, err
+	}
+	p := &precompressWatcher{
+		dirs:    dirs,
+		formats: use,
+		rules:   rules,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: map[fileKey]bool{},
+	}
+	go p.run()
+	return p, nil
+}
+
+// isSibling reports whether name is the name of the sibling of a file in
+// one of the formats.
+func (p *precompressWatcher) isSibling(name string) bool {
+	for _, f := range p.formats {
+		if base, ok := strings.CutSuffix(name, f.ext); ok && compressibleExts[strings.ToLower(path.Ext(base))] {
+			return true
+		}
+	}
+	return false
+}
+
+// changed notes the compressible files of changes, to be seen to.
+func (p *precompressWatcher) changed(changes []fileChange) {
+	p.mu.Lock()
+	for _, c := range changes {
+		if !compressibleExts[strings.ToLower(path.Ext(c.Path))] || p.isSibling(c.Path) {
+			continue
+		}
+		p.pending[fileKey{c.Dir, c.Path}] = true
+	}
+	n := len(p.pending)
+	p.mu.Unlock()
+	if n > 0 {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close stops the watcher.
+func (p *precompressWatcher) close() {
+	close(p.done)
+}
+
+// run brings the siblings of the dirs up to date, and then those of the
+// files changed as they are, until the watcher is closed.
+func (p *precompressWatcher) run() {
+	for _, dir := range p.dirs {
+		err := walkCompressible(dir, func(name, rel string) {
+			if _, err := precompressFile(name, rel, p.formats, p.rules); err != nil {
+				infof("warning: precompress: %v", err)
+			}
+		})
+		if err != nil {
+			infof("warning: precompress: %v", err)
+		}
+	}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+		}
+		p.mu.Lock()
+		pending := p.pending
+		p.pending = map[fileKey]bool{}
+		p.mu.Unlock()
+		for k := range pending {
+			p.update(k)
+		}
+	}
+}
+
+// update writes the siblings of the file k again, or removes them if the
+// file is gone. A sibling that is not up to date is removed first, so
+// that one is not left behind when the file is now too small to be
+// worth compressing or no longer gets smaller.
+func (p *precompressWatcher) update(k fileKey) {
+	name := filepath.Join(k.dir, filepath.FromSlash(k.path))
+	fi, err := os.Stat(name)
+	for _, f := range p.formats {
+		ofi, serr := os.Stat(name + f.ext)
+		if serr == nil && (err != nil || !fi.Mode().IsRegular() || !ofi.ModTime().Equal(fi.ModTime())) {
+			debugf("precompress: removing %s", name+f.ext)
+			os.Remove(name + f.ext)
+		}
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	if _, err := precompressFile(name, k.path, p.formats, p.rules); err != nil {
+		infof("warning: precompress: %v", err)
+	}
+}
+
+// precompressFile writes the siblings of the file name, at the path rel
+// under its dir, that are not up to date, and returns how many it wrote.
+// If rules skip the file, it removes the siblings instead.
+func precompressFile(name, rel string, formats []precompression, rules PrecompressRules) (int, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	if rules.skips(rel, fi.Size()) {
+		for _, p := range formats {
+			if os.Remove(name+p.ext) == nil {
+				debugf("precompress: removed %s, as %s is skipped", name+p.ext, rel)
+			}
+		}
+		return 0, nil
+	}
+	var src []byte
+	written := 0
+	for _, p := range formats {
+		out := name + p.ext
+		if ofi, err := os.Stat(out); err == nil && ofi.ModTime().Equal(fi.ModTime()) {
+			continue
+		}
+		if src == nil {
+			if src, err = os.ReadFile(name); err != nil {
+				return written, err
+			}
+		}
+		b, err := p.compress(src)
+		if err != nil {
+			return written, fmt.Errorf("%s: %v", out, err)
+		}
+		if len(b) >= len(src) {
+			debugf("precompress: %s is no smaller as %s", name, p.encoding)
+			os.Remove(out)
+			continue
+		}
+		if err := writeFileAtomic(out, b, fi); err != nil {
+			return written, err
+		}
+		debugf("precompress: wrote %s, %d%% of %s", out, len(b)*100/len(src), name)
+		written++
+	}
+	return written, nil
+}
+
+// compress returns b compressed in the format.
+func (p precompression) compress(b []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if p.command == nil {
+		zw, _ := gzip.NewWriterLevel(&out, gzip.BestCompression)
+		zw.Write(b)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(b), &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", p.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), nil
+}
+
+// writeFileAtomic writes b to name through a temporary file, so that it
+// is never served half written, and gives it the mode and mtime of fi.
+func writeFileAtomic(name string, b []byte, fi os.FileInfo) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// precompressStats counts the responses sent with a compressed sibling
+// in full, and the bytes they saved, for the metrics.
+type precompressStats struct {
+	responses atomic.Uint64
+	saved     atomic.Uint64 // the bytes of the files less those of their siblings
+}
+
+// precompressedHandler serves a file with its compressed sibling, as
+// Precompress writes them, in the best encoding the client accepts, and
+// passes every other request to next. A sibling is only served while it
+// has the mtime of its file, so one left behind by an edit is not.
+type precompressedHandler struct {
+	fs    http.FileSystem
+	stats *precompressStats // counts what the siblings saved, if non-nil
+	next  http.Handler
+}
+
+// ServeHTTP serves r with a compressed sibling, or with next.
+func (h precompressedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || !compressibleExts[strings.ToLower(path.Ext(name))] {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	accept := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	for _, p := range precompressions {
+		if !accept[p.encoding] {
+			continue
+		}
+		f, fi, size, ok := h.sibling(name, p.ext)
+		if !ok {
+			continue
+		}
+		defer f.Close()
+		ct := mime.TypeByExtension(path.Ext(name))
+		if ct == "" {
+			ct = "text/plain; charset=utf-8"
+		}
+		debugf("precompress: %s: serving %s", name, name+p.ext)
+		// The digests and entity tag are of the file, not of what is sent.
+		w.Header().Del("Repr-Digest")
+		w.Header().Del("Digest")
+		w.Header().Del("ETag")
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("Content-Encoding", p.encoding)
+		if h.stats == nil {
+			http.ServeContent(w, r, name, fi.ModTime(), f)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		http.ServeContent(rec, r, name, fi.ModTime(), f)
+		if rec.Status() == http.StatusOK && r.Method == http.MethodGet {
+			h.stats.responses.Add(1)
+			h.stats.saved.Add(uint64(max(size-rec.bytes, 0)))
+		}
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// sibling opens the sibling of the file name with the extension ext, if
+// it is up to date, and returns the size of the file too.
+func (h precompressedHandler) sibling(name, ext string) (http.File, os.FileInfo, int64, bool) {
+	orig, err := h.fs.Open(name)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	ofi, err := orig.Stat()
+	orig.Close()
+	if err != nil || ofi.IsDir() {
+		return nil, nil, 0, false
+	}
+	f, err := h.fs.Open(name + ext)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() || !fi.ModTime().Equal(ofi.ModTime()) {
+		f.Close()
+		return nil, nil, 0, false
+	}
+	return f, fi, ofi.Size(), true
+}
+
+// acceptedEncodings returns the content codings an Accept-Encoding
+// header accepts.
+func acceptedEncodings(header string) map[string]bool {
+	accept := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accept[strings.ToLower(coding)] = q > 0
+	}
+	if accept["*"] {
+		for _, p := range precompressions {
+			if _, ok := accept[p.encoding]; !ok {
+				accept[p.encoding] = true
+			}
+		}
+	}
+	return accept
+}
@@ -0,0 +1,115 @@
+package staticserver
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileETag returns the strong entity tag of the file fi describes,
+// which changes whenever the file is rewritten.
+func fileETag(fi fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// conditional reports whether r carries a precondition on the current
+// state of its file.
+func conditional(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// checkPreconditions evaluates the If-Match, If-None-Match and
+// If-Unmodified-Since headers of the write r against fi, the file it
+// would replace or remove, which is nil if there is none. It reports
+// whether the write may go ahead, and otherwise answers it with 412 so
+// that a writer working from a stale copy does not clobber a newer one.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, fi fs.FileInfo) bool {
+	if fi != nil && fi.IsDir() {
+		fi = nil // dirs have no entity tag
+	}
+	ok := true
+	if im := r.Header.Get("If-Match"); im != "" {
+		ok = fi != nil && matchETag(im, fileETag(fi))
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && fi != nil {
+		if t, err := http.ParseTime(ius); err == nil {
+			ok = !fi.ModTime().Truncate(time.Second).After(t)
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); ok && inm != "" && fi != nil {
+		ok = !matchETag(inm, fileETag(fi))
+	}
+	if !ok {
+		if fi != nil {
+			w.Header().Set("ETag", fileETag(fi))
+		}
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+	}
+	return ok
+}
+
+// matchETag reports whether the list of entity tags in a precondition
+// header holds "*" or etag, compared strongly: weak tags never match.
+func matchETag(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition checks the preconditions of the write r to name in s as
+// checkPreconditions does. While r has any, it holds the lock of name
+// until done is called, so that no other conditional write to name
+// lands between the check and the write.
+func (s *store) precondition(w http.ResponseWriter, r *http.Request, name string) (ok bool, done func()) {
+	if !conditional(r) {
+		return true, func() {}
+	}
+	unlock := s.lockPath(name)
+	return checkPreconditions(w, r, s.stat(name)), unlock
+}
+
+// pathLock is the lock of a name in a store, shared by the writes
+// waiting for it.
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPath locks name in s, and returns the function that unlocks it.
+func (s *store) lockPath(name string) (unlock func()) {
+	name = path.Clean("/" + name)
+	s.condMu.Lock()
+	if s.conds == nil {
+		s.conds = map[string]*pathLock{}
+	}
+	l := s.conds[name]
+	if l == nil {
+		l = &pathLock{}
+		s.conds[name] = l
+	}
+	l.refs++
+	s.condMu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.condMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.conds, name)
+		}
+		s.condMu.Unlock()
+	}
+}
+
+// setETag sets the ETag header of the response for name in s, if it is
+// a file.
+func (s *store) setETag(w http.ResponseWriter, name string) {
+	if fi := s.stat(name); fi != nil && fi.Mode().IsRegular() {
+		w.Header().Set("ETag", fileETag(fi))
+	}
+}
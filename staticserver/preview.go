@@ -0,0 +1,249 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// previewPage is the page data files are previewed in, given the Path
+// of the file, the Raw URL of the file as it is and the rendered Body.
+var previewPage = template.Must(template.New("preview").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+header { background: #f4f4f4; border-bottom: 1px solid #ddd; padding: .5em 1em; }
+main { padding: .5em 1em; } #raw { float: right; }
+.tree, .tree ul { font-family: monospace; list-style: none; margin: 0; padding-left: 1.2em; }
+.tree { padding-left: 0; } summary { cursor: pointer; } .count { color: #999; }
+.key { color: #4078f2; } .str { color: #50a14f; } .num { color: #986801; } .kw { color: #a626a4; }
+table { border-collapse: collapse; font-size: .9em; } th, td { border: 1px solid #ddd; padding: .2em .5em; }
+th { background: #f4f4f4; cursor: pointer; position: sticky; top: 0; user-select: none; }
+th[aria-sort=ascending]::after { content: " \25b2"; } th[aria-sort=descending]::after { content: " \25bc"; }
+</style>
+<header><a id="raw" href="{{.Raw}}">raw</a>{{.Path}}</header>
+<main>{{.Body}}</main>
+<script>
+document.querySelectorAll("th").forEach(function (th, col) {
+	th.onclick = function () {
+		var asc = th.getAttribute("aria-sort") != "ascending";
+		th.parentNode.querySelectorAll("th").forEach(function (h) { h.removeAttribute("aria-sort"); });
+		th.setAttribute("aria-sort", asc ? "ascending" : "descending");
+		var body = th.closest("table").tBodies[0], rows = Array.from(body.rows);
+		var cell = function (r) { return r.cells[col] ? r.cells[col].textContent : ""; };
+		rows.sort(function (a, b) {
+			var x = cell(a), y = cell(b), nx = parseFloat(x), ny = parseFloat(y);
+			var c = !isNaN(nx) && !isNaN(ny) && isFinite(x) && isFinite(y) ? nx - ny : x.localeCompare(y);
+			return asc ? c : -c;
+		});
+		rows.forEach(function (r) { body.appendChild(r); });
+	};
+});
+</script>`))
+
+// maxPreview is the size of the largest data file previewed; larger
+// ones are served as they are.
+const maxPreview = 4 << 20
+
+// previewHandler shows .json files as collapsible trees and .csv and
+// .tsv files as sortable tables to browsers, and passes every other
+// request, and those asking for the raw file with ?raw=1, to next. As
+// with the code view, only requests that accept text/html are shown a
+// page, so that pages fetching data get it as it is. A file that does
+// not parse is served as it is too.
+type previewHandler struct {
+	fs   http.FileSystem
+	next http.Handler
+}
+
+// previewed reports whether the file name is previewed.
+func previewed(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".csv", ".tsv":
+		return true
+	}
+	return false
+}
+
+// ServeHTTP shows the requested data file or serves r with next.
+func (h previewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if !previewed(name) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.URL.Query().Get("raw") == "1" ||
+		!strings.Contains(r.Header.Get("Accept"), "text/html") {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() || fi.Size() > maxPreview {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	src, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	var body strings.Builder
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		err = renderJSONTree(&body, src)
+	case ".csv":
+		err = renderTable(&body, src, ',')
+	case ".tsv":
+		err = renderTable(&body, src, '\t')
+	}
+	if err != nil {
+		debugf("preview: %s: %v", name, err)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	raw := *r.URL
+	q := raw.Query()
+	q.Set("raw", "1")
+	raw.RawQuery = q.Encode()
+	var out bytes.Buffer
+	err = previewPage.Execute(&out, struct {
+		Path, Raw string
+		Body      template.HTML
+	}{name, raw.RequestURI(), template.HTML(body.String())})
+	if err != nil {
+		infof("warning: preview: %s: %v", name, err)
+		http.Error(w, "Error rendering file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(out.Bytes()))
+}
+
+// renderJSONTree writes the JSON document src as a tree of nested lists
+// whose objects and arrays fold, keeping the order of keys. The top two
+// levels start unfolded.
+func renderJSONTree(b *strings.Builder, src []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	b.WriteString(`<ul class="tree"><li>`)
+	if err := renderJSONValue(b, dec, 0); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("data after the JSON value")
+	}
+	b.WriteString("</li></ul>")
+	return nil
+}
+
+// renderJSONValue writes the next value of dec, at depth.
+func renderJSONValue(b *strings.Builder, dec *json.Decoder, depth int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		var items strings.Builder
+		n := 0
+		for ; dec.More(); n++ {
+			items.WriteString("<li>")
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				items.WriteString(`<span class="key">` + html.EscapeString(jsonQuote(key.(string))) + "</span>: ")
+			}
+			if err := renderJSONValue(&items, dec, depth+1); err != nil {
+				return err
+			}
+			items.WriteString("</li>")
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		open, end, unit := "{", "}", "key"
+		if t == '[' {
+			open, end, unit = "[", "]", "item"
+		}
+		if n != 1 {
+			unit += "s"
+		}
+		if n == 0 {
+			b.WriteString(open + end)
+			return nil
+		}
+		attr := ""
+		if depth < 2 {
+			attr = " open"
+		}
+		fmt.Fprintf(b, `<details%s><summary>%s <span class="count">%d %s</span></summary><ul>%s</ul>%s</details>`, attr, open, n, unit, items.String(), end)
+	case string:
+		b.WriteString(`<span class="str">` + html.EscapeString(jsonQuote(t)) + "</span>")
+	case json.Number:
+		b.WriteString(`<span class="num">` + html.EscapeString(t.String()) + "</span>")
+	case bool:
+		fmt.Fprintf(b, `<span class="kw">%t</span>`, t)
+	case nil:
+		b.WriteString(`<span class="kw">null</span>`)
+	}
+	return nil
+}
+
+// jsonQuote returns s as a JSON string, without the escapes for HTML
+// that json.Marshal adds, as the page escapes it.
+func jsonQuote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// renderTable writes the delimited records of src as a table whose
+// first record is the header.
+func renderTable(b *strings.Builder, src []byte, comma rune) error {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(src, []byte("\ufeff"))))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("no records")
+	}
+	b.WriteString("<table><thead><tr>")
+	for _, field := range records[0] {
+		b.WriteString("<th>" + html.EscapeString(field) + "</th>")
+	}
+	b.WriteString("</tr></thead><tbody>")
+	for _, record := range records[1:] {
+		b.WriteString("<tr>")
+		for _, field := range record {
+			b.WriteString("<td>" + html.EscapeString(field) + "</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</tbody></table>")
+	return nil
+}
@@ -0,0 +1,121 @@
+package staticserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultProdBlock are the names of the build leftovers and development
+// files that Prod refuses to serve: source maps, the sources compiled
+// to JavaScript and CSS, package manifests, and backup and swap files.
+var DefaultProdBlock = []string{
+	"*.map", "*.ts", "*.tsx", "*.jsx", "*.scss", "*.sass", "*.less",
+	"node_modules", "package.json", "package-lock.json", "tsconfig.json",
+	"*.bak", "*.orig", "*.swp", "*~",
+}
+
+// prodFS is an http.FileSystem that does not have the files, or the dirs
+// and all they hold, with names matching its patterns. A .ts file is
+// hidden only if it is not an MPEG transport stream, so that the
+// segments of HLS video are still served.
+type prodFS struct {
+	http.FileSystem
+	block []string
+}
+
+// prodBlock returns the name patterns Prod hides, DefaultProdBlock and
+// more.
+func prodBlock(more []string) ([]string, error) {
+	for _, p := range more {
+		if _, err := path.Match(p, ""); err != nil || strings.Contains(p, "/") {
+			return nil, fmt.Errorf("invalid prod block pattern %q, expected a name pattern like *.map", p)
+		}
+	}
+	return append(slices.Clip(DefaultProdBlock), more...), nil
+}
+
+// hidden reports whether name, or a dir it is in, is blocked. f is the
+// file name is open as, if it is, to tell transport streams apart.
+func (fs prodFS) hidden(name string, f http.File) bool {
+	elems := strings.Split(strings.Trim(name, "/"), "/")
+	for i, elem := range elems {
+		for _, p := range fs.block {
+			if ok, _ := path.Match(p, elem); !ok {
+				continue
+			}
+			if p == "*.ts" && i == len(elems)-1 && f != nil && isTransportStream(f) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// isTransportStream reports whether f starts like an MPEG transport
+// stream, with a sync byte at the start of each of its first packets.
+func isTransportStream(f http.File) bool {
+	defer f.Seek(0, io.SeekStart)
+	b := make([]byte, 2*188+1)
+	n, _ := io.ReadFull(f, b)
+	if n == 0 {
+		return false
+	}
+	for i := 0; i < n; i += 188 {
+		if b[i] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// Open opens name, as if it did not exist if it is blocked.
+func (fs prodFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		if fs.hidden(name, nil) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	if fs.hidden(name, f) {
+		f.Close()
+		debugf("prod: hiding %s", name)
+		return nil, os.ErrNotExist
+	}
+	return prodF{File: f, fs: fs, dir: name}, nil
+}
+
+// prodF is a file of a prodFS, whose dir listings leave out the files
+// it hides.
+type prodF struct {
+	http.File
+	fs  prodFS
+	dir string
+}
+
+// Readdir lists the dir but for the files it hides.
+func (f prodF) Readdir(n int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(n)
+	shown := fis[:0]
+	for _, fi := range fis {
+		name := path.Join(f.dir, fi.Name())
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".ts") {
+			// Whether a .ts file is hidden depends on what it holds.
+			g, err := f.fs.Open(name)
+			if err != nil {
+				continue
+			}
+			g.Close()
+		} else if f.fs.hidden(name, nil) {
+			continue
+		}
+		shown = append(shown, fi)
+	}
+	return shown, err
+}
@@ -0,0 +1,64 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// Proxies maps a URL path prefix to the backend that serves it.
+// It is populated by repeated -proxy flags of the form prefix=url.
+type Proxies map[string]*url.URL
+
+// Set parses a single prefix=url pair and adds it to the map.
+// It has the signature expected by flag.Func.
+func (p Proxies) Set(s string) error {
+	prefix, target, ok := strings.Cut(s, "=")
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("invalid proxy %q, expected /prefix=url", s)
+	}
+	u, err := parseUpstream(target)
+	if err != nil {
+		return err
+	}
+	p[prefix] = u
+	return nil
+}
+
+// register mounts a reverse proxy on mux for every prefix in p.
+// Both the prefix itself and everything beneath it are forwarded.
+func (p Proxies) register(mux *http.ServeMux) {
+	for prefix, target := range p {
+		rp := newReverseProxy(target)
+		mux.Handle(prefix, rp)
+		mux.Handle(prefix+"/", rp)
+	}
+}
+
+// parseUpstream parses s as the absolute http(s) URL of a backend.
+func parseUpstream(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q, expected an http(s) URL", s)
+	}
+	return u, nil
+}
+
+// newReverseProxy returns a proxy that forwards requests to target,
+// joining the request path onto the target's path. The outbound Host
+// header is the target's and the X-Forwarded-* headers are set from
+// the inbound request so the backend can see the original client.
+func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+	}
+}
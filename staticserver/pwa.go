@@ -0,0 +1,229 @@
+package staticserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// swPath is where the generated service worker is served.
+	swPath = "/sw.js"
+	// swCheck is how long the file list of the service worker is kept
+	// before the files are walked again.
+	swCheck = 10 * time.Second
+	// maxPrecacheFiles, maxPrecacheSize and maxPrecacheTotal bound what
+	// the service worker keeps offline: the first files, none larger,
+	// and no more than the total, so that installing the app does not
+	// fill a phone.
+	maxPrecacheFiles = 1000
+	maxPrecacheSize  = 2 << 20
+	maxPrecacheTotal = 50 << 20
+)
+
+// noPrecacheExts are the extensions of the media and archives the
+// service worker does not keep offline, whatever their size: they are
+// downloaded or streamed when wanted, not browsed.
+var noPrecacheExts = map[string]bool{
+	".mp4": true, ".m4v": true, ".webm": true, ".mkv": true, ".mov": true, ".avi": true, ".ogv": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".oga": true, ".opus": true, ".flac": true, ".wav": true,
+	".m3u8": true, ".mpd": true, ".ts": true, ".m4s": true,
+	".zip": true, ".tar": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".iso": true, ".dmg": true, ".exe": true, ".msi": true, ".deb": true, ".rpm": true, ".apk": true,
+}
+
+// swRegister is the script injected into pages to register the
+// generated service worker.
+const swRegister = `<script>if ("serviceWorker" in navigator) navigator.serviceWorker.register("` + swPath + `");</script>`
+
+// serviceWorkerScript is the generated service worker, given the name of
+// its cache and the JSON list of the files it keeps. Pages come from the
+// network while there is one, and the rest from the cache; a new list
+// makes a new cache, and the old ones are deleted once it is in use.
+const serviceWorkerScript = `// Generated by static-server.
+const CACHE = %q;
+const FILES = %s;
+
+self.addEventListener("install", (e) => {
+	e.waitUntil(caches.open(CACHE)
+		.then((c) => Promise.all(FILES.map((f) => c.add(f).catch(() => {}))))
+		.then(() => self.skipWaiting()));
+});
+
+self.addEventListener("activate", (e) => {
+	e.waitUntil(caches.keys()
+		.then((keys) => Promise.all(keys.filter((k) => k.startsWith("static-server-") && k !== CACHE).map((k) => caches.delete(k))))
+		.then(() => self.clients.claim()));
+});
+
+self.addEventListener("fetch", (e) => {
+	const req = e.request;
+	if (req.method !== "GET" || new URL(req.url).origin !== location.origin) return;
+	if (req.mode === "navigate") {
+		e.respondWith(fetch(req).then((resp) => {
+			if (resp.ok) {
+				const copy = resp.clone();
+				caches.open(CACHE).then((c) => c.put(req, copy));
+			}
+			return resp;
+		}).catch(() => caches.match(req).then((resp) => resp || caches.match("/"))));
+		return;
+	}
+	e.respondWith(caches.match(req).then((resp) => resp || fetch(req)));
+});
+`
+
+// isManifest reports whether p names a web app manifest.
+func isManifest(p string) bool {
+	base := path.Base(p)
+	return path.Ext(base) == ".webmanifest" || base == "manifest.json"
+}
+
+// isServiceWorker reports whether p names a service worker.
+func isServiceWorker(p string) bool {
+	switch path.Base(p) {
+	case "sw.js", "service-worker.js", "serviceworker.js":
+		return true
+	}
+	return false
+}
+
+// pwaHandler serves the web app manifests and service workers of an app
+// with their types and revalidated every time, so that an installed app
+// picks up a new version as soon as there is one. With a worker it
+// serves it at swPath for a site that has none of its own.
+type pwaHandler struct {
+	worker *serviceWorker
+	next   http.Handler
+}
+
+// ServeHTTP serves r with next, or with the generated worker.
+func (h pwaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case isManifest(r.URL.Path):
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.Header().Set("Cache-Control", "no-cache")
+	case isServiceWorker(r.URL.Path):
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		if h.worker != nil && r.URL.Path == swPath && !h.worker.ownWorker() {
+			h.worker.ServeHTTP(w, r)
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// serviceWorker generates the offline-caching service worker of the
+// files of an FS, walking them again once swCheck has passed.
+type serviceWorker struct {
+	fs http.FileSystem
+
+	mu      sync.Mutex
+	checked time.Time
+	script  []byte
+	own     bool // the site has a worker of its own at swPath
+}
+
+func newServiceWorker(fs http.FileSystem) *serviceWorker {
+	return &serviceWorker{fs: fs}
+}
+
+// purge forgets the file list, so that the files are walked again.
+func (s *serviceWorker) purge() {
+	s.mu.Lock()
+	s.checked = time.Time{}
+	s.mu.Unlock()
+}
+
+// ownWorker reports whether the site has a worker of its own at swPath.
+func (s *serviceWorker) ownWorker() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	return s.own
+}
+
+// refresh walks the files again if swCheck has passed. s.mu is held.
+func (s *serviceWorker) refresh() {
+	if time.Since(s.checked) < swCheck {
+		return
+	}
+	s.checked = time.Now()
+	if f, err := s.fs.Open(swPath); err == nil {
+		f.Close()
+		s.own = true
+		return
+	}
+	s.own = false
+	var files []string
+	var total int64
+	sum := sha256.New()
+	s.walk("/", 0, &files, &total, sum)
+	list, _ := json.Marshal(files)
+	s.script = fmt.Appendf(nil, serviceWorkerScript, "static-server-"+hex.EncodeToString(sum.Sum(nil)[:6]), list)
+	debugf("pwa: service worker keeps %d files", len(files))
+}
+
+// walk adds the URLs of the files under dir to files, and their sizes to
+// total, writing what makes them a new version to sum.
+func (s *serviceWorker) walk(dir string, depth int, files *[]string, total *int64, sum io.Writer) {
+	f, err := s.fs.Open(dir)
+	if err != nil {
+		return
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return
+	}
+	slices.SortFunc(fis, func(a, b os.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, fi := range fis {
+		if len(*files) == maxPrecacheFiles {
+			return
+		}
+		name := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if depth < maxWalkDepth {
+				s.walk(name, depth+1, files, total, sum)
+			}
+			continue
+		}
+		if fi.Size() > maxPrecacheSize || *total+fi.Size() > maxPrecacheTotal || name == swPath || noPrecacheExts[strings.ToLower(path.Ext(name))] {
+			continue
+		}
+		*total += fi.Size()
+		fmt.Fprintf(sum, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+		if fi.Name() == "index.html" {
+			// Served at its dir, to which it redirects.
+			name = strings.TrimSuffix(name, "index.html")
+		}
+		*files = append(*files, (&url.URL{Path: name}).EscapedPath())
+	}
+}
+
+// ServeHTTP serves the worker.
+func (s *serviceWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	s.refresh()
+	script := s.script
+	s.mu.Unlock()
+	if r.Method != http.MethodHead {
+		w.Write(script)
+	}
+}
@@ -0,0 +1,449 @@
+package staticserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// This file holds a small QR code encoder, enough to show the server's
+// URL in a terminal and the links of listings as SVG: byte mode, error correction level L and versions 1
+// to 10, which hold up to 271 bytes.
+
+// qrVersions describes the versions supported, indexed by version - 1.
+var qrVersions = []struct {
+	ecPerBlock int   // error correction codewords in each block
+	blocks     []int // data codewords in each block
+	align      []int // the row and column centres of the alignment patterns
+}{
+	{7, []int{19}, nil},
+	{10, []int{34}, []int{6, 18}},
+	{15, []int{55}, []int{6, 22}},
+	{20, []int{80}, []int{6, 26}},
+	{26, []int{108}, []int{6, 30}},
+	{18, []int{68, 68}, []int{6, 34}},
+	{20, []int{78, 78}, []int{6, 22, 38}},
+	{24, []int{97, 97}, []int{6, 24, 42}},
+	{30, []int{116, 116}, []int{6, 26, 46}},
+	{18, []int{68, 68, 69, 69}, []int{6, 28, 50}},
+}
+
+var errQRTooLong = errors.New("qr: data too long")
+
+// qrCode is a QR code symbol: modules[y][x] is true for dark modules.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // modules that are part of a fixed pattern
+}
+
+// encodeQR returns the QR code of data.
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for v, info := range qrVersions {
+		capacity := 0
+		for _, n := range info.blocks {
+			capacity += n
+		}
+		countBits := 8
+		if v+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*capacity {
+			version = v + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	info := qrVersions[version-1]
+
+	// Encode the data in byte mode, then terminate and pad it.
+	capacity := 0
+	for _, n := range info.blocks {
+		capacity += n
+	}
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, 8*capacity-bits.n))
+	bits.append(0, (8-bits.n%8)%8)
+	for pad := 0xec; len(bits.b) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	// Split into blocks, add error correction and interleave.
+	divisor := rsDivisor(info.ecPerBlock)
+	var blocks, ecs [][]byte
+	rest := bits.b
+	for _, n := range info.blocks {
+		blocks = append(blocks, rest[:n])
+		ecs = append(ecs, rsRemainder(rest[:n], divisor))
+		rest = rest[n:]
+	}
+	var codewords []byte
+	for i := 0; i < info.blocks[len(info.blocks)-1]; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				codewords = append(codewords, b[i])
+			}
+		}
+	}
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			codewords = append(codewords, ec[i])
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(codewords)
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // undo
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+// qrBits accumulates a big-endian bit stream.
+type qrBits struct {
+	b []byte
+	n int // bits used
+}
+
+func (q *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if q.n%8 == 0 {
+			q.b = append(q.b, 0)
+		}
+		if v>>i&1 == 1 {
+			q.b[len(q.b)-1] |= 0x80 >> (q.n % 8)
+		}
+		q.n++
+	}
+}
+
+// newQRCode returns a symbol of version with its fixed patterns drawn.
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					q.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	align := qrVersions[version-1].align
+	for i, x := range align {
+		for j, y := range align {
+			last := len(align) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // under a finder pattern
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormat(0) // reserve the format areas
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bits>>i&1 == 1)
+			q.set(b, a, bits>>i&1 == 1)
+		}
+	}
+	return q
+}
+
+// set draws a function module.
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFormat draws the two copies of the format information for level
+// L and mask.
+func (q *qrCode) drawFormat(mask int) {
+	data := 1<<3 | mask // level L
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true) // always dark
+}
+
+// drawCodewords fills the data area in the zigzag order of the standard.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert // upward
+				}
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask. Applying the same
+// mask again undoes it.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to read, by the four rules of
+// the standard. Lower is better.
+func (q *qrCode) penalty() int {
+	p, dark := 0, 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 0
+			for x := 0; x < q.size; x++ {
+				if x > 0 && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					if run == 5 {
+						p += 3
+					} else if run > 5 {
+						p++
+					}
+				} else {
+					run = 1
+				}
+				// A finder-like 1:1:3:1:1 pattern with four light modules on a side.
+				if x+10 < q.size {
+					var s strings.Builder
+					for k := 0; k < 11; k++ {
+						if at(x+k, y, vertical) {
+							s.WriteByte('1')
+						} else {
+							s.WriteByte('0')
+						}
+					}
+					if v := s.String(); v == "10111010000" || v == "00001011101" {
+						p += 40
+					}
+				}
+			}
+		}
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	p += abs(dark*20-total*10) / total * 10
+	return p
+}
+
+// print draws the symbol with a quiet zone to w using half block
+// characters, two rows to a line, forcing black on white so it reads
+// on light and dark terminals alike.
+func (q *qrCode) print(w io.Writer) {
+	const quiet = 2
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x >= 0 && x < q.size && y >= 0 && y < q.size && q.modules[y][x]
+	}
+	var b strings.Builder
+	for y := 0; y < q.size+2*quiet; y += 2 {
+		b.WriteString("\x1b[30;47m")
+		for x := 0; x < q.size+2*quiet; x++ {
+			switch top, bottom := dark(x, y), dark(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	io.WriteString(w, b.String())
+}
+
+// svg draws the symbol with a quiet zone to w as an SVG image, one unit
+// to a module.
+func (q *qrCode) svg(w io.Writer) {
+	const quiet = 4
+	var b strings.Builder
+	n := q.size + 2*quiet
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range q.modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>` + "\n")
+	io.WriteString(w, b.String())
+}
+
+// PrintQR prints the QR code of data to w as text for a terminal.
+func PrintQR(w io.Writer, data string) error {
+	q, err := encodeQR([]byte(data))
+	if err != nil {
+		return err
+	}
+	q.print(w)
+	return nil
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of degree,
+// highest coefficient first, without its leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,236 @@
+package staticserver
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// The data and error correction codewords of HELLO WORLD at version
+	// 1 and level M, from the worked example of the standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+func TestGFMul(t *testing.T) {
+	tests := []struct{ x, y, want byte }{
+		{0, 7, 0},
+		{1, 0x53, 0x53},
+		{2, 0x80, 0x1d},
+		{0x80, 0x80, 0x13},
+		{0xff, 0xff, 0xe2},
+	}
+	for _, tt := range tests {
+		if got := gfMul(tt.x, tt.y); got != tt.want {
+			t.Errorf("gfMul(%#x, %#x) = %#x, want %#x", tt.x, tt.y, got, tt.want)
+		}
+		if got := gfMul(tt.y, tt.x); got != tt.want {
+			t.Errorf("gfMul(%#x, %#x) = %#x, want %#x", tt.y, tt.x, got, tt.want)
+		}
+	}
+}
+
+// decodeQR reads q back as a reader would: it checks the format and
+// version information, removes the mask, checks the error correction of
+// every block and returns the bytes encoded.
+func decodeQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+	version := (q.size - 17) / 4
+	dark := func(x, y int) int {
+		if q.modules[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Both copies of the format information must agree, and be level L.
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= dark(8, i) << i
+	}
+	first |= dark(8, 7)<<6 | dark(8, 8)<<7 | dark(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		first |= dark(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= dark(q.size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= dark(8, q.size-15+i) << i
+	}
+	if first != second {
+		t.Fatalf("format information %015b and %015b differ", first, second)
+	}
+	format := first ^ 0x5412
+	rem := format >> 10
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	if rem&0x3ff != format&0x3ff {
+		t.Fatalf("format information %015b has a bad checksum", first)
+	}
+	if level := format >> 13; level != 1 {
+		t.Fatalf("error correction level bits %02b, want 01 for L", level)
+	}
+	mask := format >> 10 & 7
+	if dark(8, q.size-8) != 1 {
+		t.Error("the dark module is light")
+	}
+	if version >= 7 {
+		var bits int
+		for i := 0; i < 18; i++ {
+			bits |= dark(q.size-11+i%3, i/3) << i
+		}
+		if bits>>12 != version {
+			t.Errorf("version information %018b, want version %d", bits, version)
+		}
+	}
+
+	// Finder patterns and timing patterns.
+	for _, c := range [][2]int{{0, 0}, {q.size - 7, 0}, {0, q.size - 7}} {
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 7; x++ {
+				d := max(abs(x-3), abs(y-3))
+				if want := d != 2; q.modules[c[1]+y][c[0]+x] != want {
+					t.Fatalf("finder pattern at %v is wrong at %d,%d", c, x, y)
+				}
+			}
+		}
+	}
+	for i := 8; i < q.size-8; i++ {
+		if q.modules[6][i] != (i%2 == 0) || q.modules[i][6] != (i%2 == 0) {
+			t.Fatalf("timing pattern is wrong at %d", i)
+		}
+	}
+
+	// Unmask a copy and read the codewords in zigzag order.
+	c := newQRCode(version)
+	for y := range c.modules {
+		copy(c.modules[y], q.modules[y])
+	}
+	c.applyMask(mask)
+	var bits qrBits
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if !c.function[y][x] {
+					b := 0
+					if c.modules[y][x] {
+						b = 1
+					}
+					bits.append(b, 1)
+				}
+			}
+		}
+	}
+
+	// Take the blocks apart and check their error correction.
+	info := qrVersions[version-1]
+	blocks := make([][]byte, len(info.blocks))
+	ecs := make([][]byte, len(info.blocks))
+	i := 0
+	for k := 0; k < info.blocks[len(info.blocks)-1]; k++ {
+		for b, n := range info.blocks {
+			if k < n {
+				blocks[b] = append(blocks[b], bits.b[i])
+				i++
+			}
+		}
+	}
+	for k := 0; k < info.ecPerBlock; k++ {
+		for b := range ecs {
+			ecs[b] = append(ecs[b], bits.b[i])
+			i++
+		}
+	}
+	var data []byte
+	divisor := rsDivisor(info.ecPerBlock)
+	for b := range blocks {
+		if want := rsRemainder(blocks[b], divisor); !bytes.Equal(ecs[b], want) {
+			t.Fatalf("block %d has error correction %v, want %v", b, ecs[b], want)
+		}
+		data = append(data, blocks[b]...)
+	}
+
+	// Byte mode, a count and the bytes.
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode %04b, want 0100 for bytes", data[0]>>4)
+	}
+	var n, start int
+	if version >= 10 {
+		n, start = int(data[0]&0xf)<<12|int(data[1])<<4|int(data[2]>>4), 2
+	} else {
+		n, start = int(data[0]&0xf)<<4|int(data[1]>>4), 1
+	}
+	out := make([]byte, n)
+	for k := range out {
+		out[k] = data[start+k]<<4 | data[start+k+1]>>4
+	}
+	return out
+}
+
+func TestEncodeQR(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+	}{
+		{"empty", "", 1},
+		{"url", "http://192.168.1.10:8080/", 2},
+		{"version 1 full", strings.Repeat("a", 17), 1},
+		{"version 2", strings.Repeat("a", 18), 2},
+		{"version 6, two blocks", strings.Repeat("b", 120), 6},
+		{"version 7, version information", strings.Repeat("c", 150), 7},
+		{"version 10, four blocks and a long count", strings.Repeat("d", 271), 10},
+		{"binary", "\x00\xff\x80\n\x1b", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := encodeQR([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := 17 + 4*tt.version; q.size != want {
+				t.Fatalf("size %d, want %d for version %d", q.size, want, tt.version)
+			}
+			if got := decodeQR(t, q); string(got) != tt.data {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeQRTooLong(t *testing.T) {
+	if _, err := encodeQR(make([]byte, 272)); !errors.Is(err, errQRTooLong) {
+		t.Errorf("encodeQR of 272 bytes: %v, want %v", err, errQRTooLong)
+	}
+}
+
+func TestQROutput(t *testing.T) {
+	q, err := encodeQR([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	q.print(&b)
+	if lines := strings.Count(b.String(), "\n"); lines != (q.size+4+1)/2 {
+		t.Errorf("print wrote %d lines, want %d", lines, (q.size+4+1)/2)
+	}
+	b.Reset()
+	q.svg(&b)
+	if s := b.String(); !strings.HasPrefix(s, "<svg ") || !strings.Contains(s, `viewBox="0 0 29 29"`) {
+		t.Errorf("svg = %.80q..., want a 29 by 29 image", s)
+	}
+}
@@ -0,0 +1,75 @@
+package staticserver
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// qrShareFor is how long the share links of QR codes are valid.
+const qrShareFor = 24 * time.Hour
+
+// qrHandler answers GET requests for ?qr=1 with an SVG QR code of the
+// URL of the file they are for, and passes every other request to next.
+// With a share key the code is of a share link to the file, valid for
+// qrShareFor, so that a phone gets past the passwords the browser
+// already got past; a request that came by a share link gets its own.
+type qrHandler struct {
+	fs       http.FileSystem
+	shareKey []byte
+	next     http.Handler
+}
+
+// ServeHTTP draws the QR code of r's file, or serves r with next.
+func (h qrHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("qr") != "1" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	f, err := h.fs.Open(name)
+	if err == nil {
+		f.Close()
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		infof("warning: qr: %s: %v", name, err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	// The path asked for, before any alias or share link mapped it.
+	link := name
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		link = u.EscapedPath()
+	}
+	if h.shareKey != nil && !isShared(r) {
+		link = ShareLink(h.shareKey, name, time.Now().Add(qrShareFor))
+	}
+	qr, err := encodeQR([]byte(scheme + "://" + r.Host + link))
+	if err != nil {
+		http.Error(w, "The URL is too long for a QR code", http.StatusRequestURITooLong)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodHead {
+		qr.svg(w)
+	}
+}
@@ -0,0 +1,99 @@
+package staticserver
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// isSymlink reports whether dir is a symlink, such as the current link
+// of a deploy that swaps releases by pointing it at another.
+func isSymlink(dir string) bool {
+	fi, err := os.Lstat(dir)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+// releases serves the release a symlinked root points at. It resolves
+// the link on every request and serves the whole request from the dir
+// it pointed at then, so a response is never put together from files of
+// two releases, such as a listing of one and the sums of another, even
+// while the link is swapped. Every release gets caches of its own, made
+// when it is first served and dropped for the next.
+type releases struct {
+	link string
+	opts serveOptions
+
+	mu      sync.Mutex
+	target  string
+	current *release
+}
+
+// release is the handler of the files of one release, with the caches
+// and the jobs that are only good for it.
+type release struct {
+	handler http.Handler
+	caches  *caches
+	sched   *scheduler
+}
+
+func newReleases(o serveOptions, link string) *releases {
+	rs := &releases{link: link, opts: o}
+	o.caches.addPaths(func(match func(string) bool) error {
+		if rel := rs.pinned(); rel != nil {
+			return rel.caches.purgePaths(match)
+		}
+		return nil
+	})
+	if o.sched != nil {
+		o.sched.add("release", func() error {
+			if rel := rs.pinned(); rel != nil {
+				rel.sched.run()
+			}
+			return nil
+		})
+	}
+	return rs
+}
+
+// pinned returns the release last served, or nil if none was.
+func (rs *releases) pinned() *release {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.current
+}
+
+// resolve returns the release the link points at now.
+func (rs *releases) resolve() *release {
+	target, err := filepath.EvalSymlinks(rs.link)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err != nil {
+		// Mid-swap, as ln -sfn removes the link before making it again:
+		// keep serving the last release, if there was one.
+		if rs.current != nil {
+			return rs.current
+		}
+		target = rs.link
+	}
+	if rs.current == nil || target != rs.target {
+		if rs.current != nil {
+			infof("release: %s now points at %s", rs.link, target)
+		}
+		rel := &release{caches: &caches{}}
+		o := rs.opts
+		o.caches, o.sched = rel.caches, nil
+		if rs.opts.sched != nil {
+			rel.sched = newScheduler(rs.opts.sched.interval)
+			o.sched = rel.sched
+		}
+		rel.handler = o.dirFiles(target)
+		rs.target, rs.current = target, rel
+	}
+	return rs.current
+}
+
+// ServeHTTP serves r from the release the link points at.
+func (rs *releases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.resolve().handler.ServeHTTP(w, r)
+}
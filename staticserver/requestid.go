@@ -0,0 +1,95 @@
+package staticserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// requestIDHeader carries the ID of a request to upstreams and back to
+// the client.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDHandler gives every request an ID, keeping the one the client
+// or a load balancer sent in X-Request-Id if it is sensible. The ID is
+// returned in the response, forwarded to proxied backends, recorded in
+// the access log and appended to plain text error pages.
+type requestIDHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP tags r with its ID and serves it with h.next.
+func (h requestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	r.Header.Set(requestIDHeader, id)
+	w.Header().Set(requestIDHeader, id)
+
+	ew := &errorIDWriter{ResponseWriter: w}
+	h.next.ServeHTTP(ew, r)
+	if ew.annotate && r.Method != http.MethodHead {
+		fmt.Fprintf(w, "request id: %s\n", id)
+	}
+}
+
+// validRequestID reports whether id is short and printable enough to be
+// trusted in logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128 bit ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// errorIDWriter notes whether the response is a plain text error page
+// of unstated length, which the request ID can safely be appended to.
+type errorIDWriter struct {
+	http.ResponseWriter
+	wrote    bool
+	annotate bool
+}
+
+// WriteHeader sends the status, noting whether it starts an error page.
+func (w *errorIDWriter) WriteHeader(status int) {
+	if !w.wrote && status >= 200 {
+		w.wrote = true
+		h := w.Header()
+		w.annotate = status >= 400 && h.Get("Content-Length") == "" &&
+			strings.HasPrefix(h.Get("Content-Type"), "text/plain")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends part of the response body.
+func (w *errorIDWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom sends the response body from r, keeping sendfile available.
+func (w *errorIDWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wrote = true
+	return readFrom(w.ResponseWriter, r)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *errorIDWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultRobotsTag is the X-Robots-Tag that keeps pages out of search
+// engines.
+const defaultRobotsTag = "noindex, nofollow"
+
+// robotsHandler sets an X-Robots-Tag on the responses for the paths
+// matching its patterns, or for every path if it has none, so that
+// crawlers that ignore robots.txt still do not index them. A pattern
+// ending in a slash matches everything beneath it.
+type robotsHandler struct {
+	tag      string
+	patterns []string
+	next     http.Handler
+}
+
+func newRobotsHandler(tag string, patterns []string, next http.Handler) (robotsHandler, error) {
+	for _, p := range patterns {
+		if !validPathPattern(p) {
+			return robotsHandler{}, fmt.Errorf("invalid robots pattern %q, expected /path, /dir/ or a pattern like /drafts/*", p)
+		}
+	}
+	if tag == "" {
+		tag = defaultRobotsTag
+	}
+	return robotsHandler{tag: tag, patterns: patterns, next: next}, nil
+}
+
+// matches reports whether the tag is set for p.
+func (h robotsHandler) matches(p string) bool {
+	return len(h.patterns) == 0 || matchPaths(h.patterns, p)
+}
+
+// validPathPattern reports whether pattern is a path, a dir ending in a
+// slash or a pattern of path.Match beginning with a slash.
+func validPathPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil && strings.HasPrefix(pattern, "/")
+}
+
+// matchPaths reports whether p matches one of patterns, where a pattern
+// ending in a slash matches everything beneath it.
+func matchPaths(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(p, pattern) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP serves r with next, tagged if its path matches.
+func (h robotsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.matches(r.URL.Path) {
+		w.Header().Set("X-Robots-Tag", h.tag)
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,233 @@
+package staticserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 says where the objects of an S3 bucket, on AWS or a compatible
+// service such as MinIO, are and how to sign requests for them.
+type S3 struct {
+	Bucket       string
+	Prefix       string // the key prefix of the objects to serve, if any
+	Region       string // us-east-1 if empty
+	Endpoint     string // the URL of a compatible service, with buckets as paths, or AWS if empty
+	AccessKey    string // requests are anonymous if empty
+	SecretKey    string
+	SessionToken string // for temporary credentials, if any
+	Cache        ObjectCache
+}
+
+// NewS3FS returns an fs.FS of the objects in the bucket, to be set as
+// the FS of a Config. Directory listings come from ListObjectsV2.
+func NewS3FS(s S3) (fs.FS, error) {
+	if s.Bucket == "" {
+		return nil, fmt.Errorf("s3: no bucket")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	store := &s3Store{cfg: s}
+	if s.Endpoint == "" {
+		store.base = &url.URL{Scheme: "https", Host: s.Bucket + ".s3." + s.Region + ".amazonaws.com", Path: "/"}
+	} else {
+		u, err := parseUpstream(s.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		store.base = u.JoinPath(s.Bucket)
+		store.base.Path += "/"
+	}
+	return newObjectFS(store, s.Prefix, s.Cache)
+}
+
+// objectClient is used to talk to object stores. Bodies are streamed to clients, so
+// it has no overall timeout.
+var objectClient = &http.Client{}
+
+// s3Store is the objectStore of an S3 bucket.
+type s3Store struct {
+	cfg  S3
+	base *url.URL // the URL of the bucket, ending in a slash
+}
+
+// emptySHA256 is the hex SHA-256 of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a signed request for the object at key, or the bucket if key
+// is empty, and returns the response if its status is one of ok.
+func (s *s3Store) do(method, key string, query url.Values, header http.Header, ok ...int) (*http.Response, error) {
+	u := *s.base
+	u.Path += key
+	u.RawPath = s.base.EscapedPath() + escapeKey(key)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.cfg.AccessKey != "" {
+		signV4(req, s.cfg, time.Now())
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, fs.ErrNotExist
+	case http.StatusForbidden:
+		return nil, fs.ErrPermission
+	}
+	var e struct{ Code, Message string }
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s %s", method, u.Path, e.Code, e.Message)
+}
+
+func (s *s3Store) stat(key string) (objectInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, http.StatusOK)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{key: key, size: resp.ContentLength, modTime: modTime}, nil
+}
+
+func (s *s3Store) get(key string, off int64) (io.ReadCloser, error) {
+	h := http.Header{}
+	if off > 0 {
+		h.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-")
+	}
+	resp, err := s.do(http.MethodGet, key, nil, h, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) list(prefix string) (objects []objectInfo, prefixes []string, err error) {
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		resp, err := s.do(http.MethodGet, "", q, nil, http.StatusOK)
+		if err != nil {
+			return nil, nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			CommonPrefixes []struct{ Prefix string }
+			IsTruncated    bool
+			NextToken      string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("s3: listing %s: %v", prefix, err)
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, objectInfo{key: c.Key, size: c.Size, modTime: c.LastModified})
+		}
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !page.IsTruncated || page.NextToken == "" {
+			return objects, prefixes, nil
+		}
+		q.Set("continuation-token", page.NextToken)
+	}
+}
+
+// signV4 signs req for S3 with AWS Signature Version 4, as of now.
+// The body is always empty, which the request must say it is.
+func signV4(req *http.Request, cfg S3, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		emptySHA256,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	scope := amzDate[:8] + "/" + cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + cfg.SecretKey)
+	for _, part := range []string{amzDate[:8], cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key.
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// escapeKey percent-encodes every byte of key but the unreserved
+// characters and slashes, as signed requests must.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package staticserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errRejected is returned for files a scanner refused.
+var errRejected = errors.New("file rejected by scanner")
+
+// scanner checks written files before they become servable, by running
+// a command with the file's path as its last argument, for which a zero
+// exit status passes the file, and by POSTing the file to a URL, for
+// which a 2xx response passes it. Files that fail either check, or that
+// cannot be checked, are moved to the quarantine directory if there is
+// one and deleted otherwise.
+type scanner struct {
+	cmd        []string
+	url        string
+	quarantine string
+	timeout    time.Duration
+}
+
+// scanClient is used to send files to scanning services.
+var scanClient = &http.Client{}
+
+// check scans the file at the file system path p, which will be served
+// as name, and quarantines it if it does not pass.
+func (sc *scanner) check(p, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sc.timeout)
+	defer cancel()
+
+	err := sc.run(ctx, p)
+	if err == nil {
+		err = sc.post(ctx, p, name)
+	}
+	if err == nil {
+		return nil
+	}
+	infof("scan: rejected %s: %v", name, err)
+
+	if sc.quarantine != "" {
+		q := filepath.Join(sc.quarantine, time.Now().UTC().Format("20060102T150405Z")+"-"+filepath.Base(name))
+		err := os.MkdirAll(sc.quarantine, 0o700)
+		if err == nil {
+			err = os.Rename(p, q)
+		}
+		if err == nil {
+			return errRejected
+		}
+		log.Println("scan: quarantine:", err)
+	}
+	os.Remove(p)
+	return errRejected
+}
+
+// run runs the scan command, if any, on p.
+func (sc *scanner) run(ctx context.Context, p string) error {
+	if len(sc.cmd) == 0 {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, sc.cmd[0], append(sc.cmd[1:], p)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", sc.cmd[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// post sends p to the scan URL, if any.
+func (sc *scanner) post(ctx context.Context, p, name string) error {
+	if sc.url == "" {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sc.url, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-File-Name", name)
+	resp, err := scanClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded %s", sc.url, resp.Status)
+	}
+	return nil
+}
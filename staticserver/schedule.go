@@ -0,0 +1,131 @@
+package staticserver
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// scheduledJob is what a scheduler runs: the sitemap, the search index,
+// the checksums or the precompressed siblings of a tree made again.
+type scheduledJob struct {
+	name string
+	run  func() error
+}
+
+// scheduler runs its jobs every interval, give or take a tenth of it so
+// that servers started together do not walk their trees at once. The
+// jobs run one after another in a single goroutine, so only one walks
+// the tree at a time, and a run that takes longer than the interval
+// delays the next rather than overlapping it.
+type scheduler struct {
+	interval time.Duration
+	done     chan struct{}
+
+	mu   sync.Mutex
+	jobs []scheduledJob
+}
+
+func newScheduler(interval time.Duration) *scheduler {
+	return &scheduler{interval: interval, done: make(chan struct{})}
+}
+
+// add has the scheduler run f as name from its next run on.
+func (s *scheduler) add(name string, f func() error) {
+	s.mu.Lock()
+	s.jobs = append(s.jobs, scheduledJob{name, f})
+	s.mu.Unlock()
+}
+
+// start begins running the jobs in the background.
+func (s *scheduler) start() {
+	go s.loop()
+}
+
+// close stops the runs, once the one in progress is done.
+func (s *scheduler) close() {
+	close(s.done)
+}
+
+// next returns how long to wait for the next run.
+func (s *scheduler) next() time.Duration {
+	jitter := int64(s.interval / 10)
+	if jitter <= 0 {
+		return s.interval
+	}
+	return s.interval - time.Duration(jitter) + time.Duration(rand.Int64N(2*jitter+1))
+}
+
+func (s *scheduler) loop() {
+	for {
+		t := time.NewTimer(s.next())
+		select {
+		case <-s.done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		s.run()
+	}
+}
+
+// run runs every job, logging those that fail.
+func (s *scheduler) run() {
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+	start := time.Now()
+	for _, j := range jobs {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if err := j.run(); err != nil {
+			infof("warning: reindex: %s: %v", j.name, err)
+		}
+	}
+	debugf("reindex: ran %d jobs in %v", len(jobs), time.Since(start).Round(time.Millisecond))
+}
+
+// warmSums checksums the files of fs with SHA-256, dot files left out,
+// so that no download waits for its Repr-Digest. It stops once sums is
+// full, as more would only push out the first.
+func warmSums(fs http.FileSystem, sums *digestCache) error {
+	left := sums.max
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		f, err := fs.Open(dir)
+		if err != nil {
+			return err
+		}
+		fis, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			name := path.Join(dir, fi.Name())
+			switch {
+			case left <= 0:
+				return nil
+			case isDotF(name):
+			case fi.IsDir():
+				if depth < maxWalkDepth {
+					if err := walk(name, depth+1); err != nil {
+						debugf("reindex: %s: %v", name, err)
+					}
+				}
+			default:
+				left--
+				if _, err := sums.sum(fs, name, "sha256"); err != nil {
+					debugf("reindex: %s: %v", name, err)
+				}
+			}
+		}
+		return nil
+	}
+	return walk("/", 0)
+}
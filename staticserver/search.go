@@ -0,0 +1,352 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchPath is where the search endpoint is served.
+const searchPath = "/_search"
+
+// The limits of the search index and its answers.
+const (
+	maxSearchFile    = 2 << 20 // the largest file indexed
+	maxSearchResults = 50
+	searchSnippet    = 160 // the bytes of text around a match in a result
+)
+
+// searchExts are the extensions of the files indexed.
+var searchExts = map[string]bool{
+	".txt": true, ".text": true, ".html": true, ".htm": true, ".md": true, ".markdown": true,
+}
+
+var (
+	htmlTitle    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlH1       = regexp.MustCompile(`(?is)<h1[^>]*>(.*?)</h1>`)
+	htmlHidden   = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>|<!--.*?-->`)
+	htmlTag      = regexp.MustCompile(`(?s)<[^>]*>`)
+	markdownJunk = regexp.MustCompile("[#*_`>|~]+|\\]\\([^)]*\\)|!?\\[")
+	spaces       = regexp.MustCompile(`\s+`)
+)
+
+// searchDoc is a file in a searchIndex.
+type searchDoc struct {
+	path, title, text string
+	terms             int // the number of terms in text
+}
+
+// searchPosting is a doc a term is found in, and how often.
+type searchPosting struct {
+	doc, count int
+}
+
+// searchIndex is an inverted index of the text, HTML and Markdown files
+// of an FS, built in the background. Searches are answered from the
+// last index built while the next one is.
+type searchIndex struct {
+	fsys fs.FS
+
+	mu    sync.RWMutex
+	docs  []searchDoc
+	terms map[string][]searchPosting
+
+	buildMu  sync.Mutex
+	building bool
+	again    bool // files changed while the index was being built
+}
+
+func newSearchIndex(fsys fs.FS) *searchIndex {
+	return &searchIndex{fsys: fsys, terms: map[string][]searchPosting{}}
+}
+
+// rebuild builds the index again in the background. If it is already
+// being built, it is built once more when that is done, so that no
+// change is missed.
+func (x *searchIndex) rebuild() {
+	x.buildMu.Lock()
+	defer x.buildMu.Unlock()
+	if x.building {
+		x.again = true
+		return
+	}
+	x.building = true
+	go func() {
+		for {
+			x.build()
+			x.buildMu.Lock()
+			if !x.again {
+				x.building = false
+				x.buildMu.Unlock()
+				return
+			}
+			x.again = false
+			x.buildMu.Unlock()
+		}
+	}()
+}
+
+// changed rebuilds the index if any of changes is in dir.
+func (x *searchIndex) changed(dir string) func([]fileChange) {
+	return func(changes []fileChange) {
+		for _, c := range changes {
+			if c.Dir == dir && !isDotF(c.Path) {
+				x.rebuild()
+				return
+			}
+		}
+	}
+}
+
+// build indexes the files of the FS.
+func (x *searchIndex) build() {
+	start := time.Now()
+	var docs []searchDoc
+	terms := map[string][]searchPosting{}
+	fs.WalkDir(x.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(path.Ext(p))
+		if d.IsDir() || !searchExts[ext] {
+			return nil
+		}
+		if fi, err := d.Info(); err != nil || fi.Size() > maxSearchFile {
+			return nil
+		}
+		b, err := fs.ReadFile(x.fsys, p)
+		if err != nil || !utf8.Valid(b) {
+			return nil
+		}
+		doc := searchDoc{path: "/" + p}
+		switch ext {
+		case ".html", ".htm":
+			doc.title, doc.text = htmlText(b)
+			if path.Base(p) == "index.html" || path.Base(p) == "index.htm" {
+				doc.path = strings.TrimSuffix(doc.path, path.Base(p))
+			}
+		case ".md", ".markdown":
+			doc.title, doc.text = markdownText(b)
+		default:
+			doc.text = spaces.ReplaceAllString(string(b), " ")
+		}
+		if doc.title == "" {
+			doc.title = path.Base(p)
+		}
+		counts := map[string]int{}
+		for _, t := range searchTerms(doc.title + " " + doc.text) {
+			counts[t]++
+			doc.terms++
+		}
+		id := len(docs)
+		docs = append(docs, doc)
+		for t, n := range counts {
+			terms[t] = append(terms[t], searchPosting{id, n})
+		}
+		return nil
+	})
+	x.mu.Lock()
+	x.docs, x.terms = docs, terms
+	x.mu.Unlock()
+	debugf("search: indexed %d files in %v", len(docs), time.Since(start).Round(time.Millisecond))
+}
+
+// htmlText returns the title and the visible text of an HTML page.
+func htmlText(b []byte) (title, text string) {
+	if m := htmlTitle.FindSubmatch(b); m != nil {
+		title = html.UnescapeString(htmlTag.ReplaceAllString(string(m[1]), ""))
+	} else if m := htmlH1.FindSubmatch(b); m != nil {
+		title = html.UnescapeString(htmlTag.ReplaceAllString(string(m[1]), ""))
+	}
+	body := htmlHidden.ReplaceAll(b, []byte(" "))
+	body = htmlTag.ReplaceAll(body, []byte(" "))
+	return strings.TrimSpace(spaces.ReplaceAllString(title, " ")), strings.TrimSpace(spaces.ReplaceAllString(html.UnescapeString(string(body)), " "))
+}
+
+// markdownText returns the title, from the first heading, and the text
+// of a Markdown document, with most of its markup taken out.
+func markdownText(b []byte) (title, text string) {
+	for _, line := range strings.Split(string(b), "\n") {
+		if t, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+			title = strings.TrimSpace(t)
+			break
+		}
+	}
+	text = markdownJunk.ReplaceAllString(string(b), " ")
+	return title, strings.TrimSpace(spaces.ReplaceAllString(text, " "))
+}
+
+// searchTerms splits s into the lower case words it is indexed by.
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchResult is a file that matches a search.
+type searchResult struct {
+	Path    string  `json:"path"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+
+	// The snippet around the first match, for the results page.
+	Before, Match, After string `json:"-"`
+}
+
+// search returns the files that have every term of q, best first, and
+// the number of files searched.
+func (x *searchIndex) search(q string) ([]searchResult, int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return nil, len(x.docs)
+	}
+	scores := map[int]float64{}
+	for i, t := range terms {
+		postings := x.terms[t]
+		idf := math.Log(1 + float64(len(x.docs))/float64(1+len(postings)))
+		matched := map[int]float64{}
+		for _, p := range postings {
+			if _, ok := scores[p.doc]; i > 0 && !ok {
+				continue
+			}
+			doc := x.docs[p.doc]
+			s := idf * float64(p.count) / float64(doc.terms)
+			if strings.Contains(strings.ToLower(doc.title), t) {
+				s += idf
+			}
+			matched[p.doc] = scores[p.doc] + s
+		}
+		scores = matched
+	}
+	results := make([]searchResult, 0, len(scores))
+	for id, score := range scores {
+		doc := x.docs[id]
+		r := searchResult{Path: doc.path, Title: doc.title, Score: math.Round(score*1000) / 1000}
+		r.Before, r.Match, r.After = snippet(doc.text, terms)
+		r.Snippet = r.Before + r.Match + r.After
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, len(x.docs)
+}
+
+// snippet returns the text around the first of terms found in text,
+// split at the match.
+func snippet(text string, terms []string) (before, match, after string) {
+	lower := strings.ToLower(text)
+	at, n := -1, 0
+	for _, t := range terms {
+		// Lowering can change the length of some characters, so the
+		// index is only trusted when it lands on the same text.
+		if i := strings.Index(lower, t); i >= 0 && len(lower) == len(text) && (at < 0 || i < at) {
+			at, n = i, len(t)
+		}
+	}
+	if at < 0 {
+		end := min(len(text), searchSnippet)
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+		return text[:end], "", ""
+	}
+	start := max(0, at-searchSnippet/2)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := min(len(text), at+n+searchSnippet/2)
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	before, match, after = text[start:at], text[at:at+n], text[at+n:end]
+	if start > 0 {
+		before = "…" + before
+	}
+	if end < len(text) {
+		after += "…"
+	}
+	return before, match, after
+}
+
+// searchPage is the page of search results.
+var searchPage = template.Must(template.New("search").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{if .Query}}{{.Query}} - {{end}}Search</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 50em; padding: 0 1em; }
+input { font-size: 1em; padding: .3em; width: 70%; } li { margin-bottom: 1em; }
+.path { color: #080; font-size: .85em; } p { margin: .2em 0; } mark { background: #ffc; }
+</style>
+<form action="{{.Action}}"><input type="search" name="q" value="{{.Query}}" placeholder="Search" autofocus> <button>Search</button></form>
+{{if .Query}}<p>{{len .Results}} {{if eq (len .Results) 1}}result{{else}}results{{end}} in {{.Files}} files</p>
+<ol>{{range .Results}}<li><a href="{{.Path}}">{{.Title}}</a> <span class="path">{{.Path}}</span>
+<p>{{.Before}}<mark>{{.Match}}</mark>{{.After}}</p></li>
+{{end}}</ol>{{end}}`))
+
+// ServeHTTP answers the search in ?q= with a page of results, or with
+// JSON for clients that do not ask for HTML.
+func (x *searchIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	results, files := x.search(q)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "application/json")
+		if results == nil {
+			results = []searchResult{}
+		}
+		json.NewEncoder(w).Encode(struct {
+			Query   string         `json:"query"`
+			Files   int            `json:"files"`
+			Results []searchResult `json:"results"`
+		}{q, files, results})
+		return
+	}
+	var out bytes.Buffer
+	err := searchPage.Execute(&out, struct {
+		Action, Query string
+		Files         int
+		Results       []searchResult
+	}{searchPath, q, files, results})
+	if err != nil {
+		infof("warning: search: %v", err)
+		http.Error(w, "Error rendering results", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(out.Bytes())
+}
@@ -0,0 +1,51 @@
+package staticserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"syscall"
+)
+
+// The body of a plain file response is copied by io.CopyN, which hands
+// the writer an io.LimitedReader of the file. Past every writer that
+// passes ReadFrom on, the connection copies it with sendfile, without
+// the bytes going through the process, as long as the file under the
+// LimitedReader is a syscall.Conn. The files and writers wrapped on the
+// way pass both on.
+
+// errNoSyscallConn is returned for the raw connection of a file that
+// has none, such as one of an fs.FS.
+var errNoSyscallConn = errors.New("file has no raw connection")
+
+// fileSyscallConn returns the raw connection of f, if it has one.
+func fileSyscallConn(f http.File) (syscall.RawConn, error) {
+	if c, ok := f.(syscall.Conn); ok {
+		return c.SyscallConn()
+	}
+	return nil, errNoSyscallConn
+}
+
+// SyscallConn returns the raw connection of the file, for sendfile.
+func (f noDotF) SyscallConn() (syscall.RawConn, error) {
+	return fileSyscallConn(f.File)
+}
+
+// SyscallConn returns the raw connection of the file, for sendfile.
+func (f prodF) SyscallConn() (syscall.RawConn, error) {
+	return fileSyscallConn(f.File)
+}
+
+// SyscallConn returns the raw connection of the file, for sendfile.
+func (f mtimeF) SyscallConn() (syscall.RawConn, error) {
+	return fileSyscallConn(f.File)
+}
+
+// readFrom copies r to w, with w's ReadFrom if it has one, without the
+// method of the writer wrapping it, which would call readFrom again.
+func readFrom(w http.ResponseWriter, r io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
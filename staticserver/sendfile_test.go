@@ -0,0 +1,75 @@
+package staticserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// copyFS wraps the files of an http.FileSystem without passing their
+// SyscallConn on, as the wrappers did before they kept sendfile.
+type copyFS struct {
+	http.FileSystem
+}
+
+// Open opens name without its raw connection.
+func (fs copyFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ http.File }{f}, nil
+}
+
+// copyWriter wraps a response writer without passing its ReadFrom on.
+type copyWriter struct {
+	http.ResponseWriter
+}
+
+// BenchmarkSendfile serves a file through the wrapped writer and file,
+// with and without the ReadFrom and SyscallConn that keep sendfile
+// available.
+func BenchmarkSendfile(b *testing.B) {
+	const size = 8 << 20
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "big.bin"), make([]byte, size), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"passthrough", deadlineHandler{
+			limit: time.Minute,
+			next:  http.FileServer(noDotFS{http.Dir(dir)}),
+		}},
+		{"copy", deadlineHandler{
+			limit: time.Minute,
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.FileServer(copyFS{noDotFS{http.Dir(dir)}}).ServeHTTP(copyWriter{w}, r)
+			}),
+		}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			srv := httptest.NewServer(bm.handler)
+			defer srv.Close()
+			b.SetBytes(size)
+			for b.Loop() {
+				resp, err := http.Get(srv.URL + "/big.bin")
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n != size {
+					b.Fatalf("read %d bytes, %v, want %d", n, err, size)
+				}
+			}
+		})
+	}
+}
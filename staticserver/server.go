@@ -0,0 +1,1418 @@
+package staticserver
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isDotF reports whether name contains a path element starting with a period.
+// The name is assumed to be a delimited by forward slashes, as guaranteed
+// by the http.FileSystem interface. Any percent-encoding left in name is
+// decoded first, so an encoded period or slash cannot hide a dot file.
+func isDotF(name string) bool {
+	if decoded, err := url.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' })
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// noDotF is the http.File use in noDotFS.
+// It is used to wrap the Readdir method of http.File so that we can
+// remove files and directories that start with a period from its output.
+type noDotF struct {
+	http.File
+}
+
+// Readdir is a wrapper around the Readdir method of the embedded File
+// that filters out all files that start with a period in their name.
+func (f noDotF) Readdir(n int) (fis []os.FileInfo, err error) {
+	files, err := f.File.Readdir(n)
+	for _, file := range files { // Filters out the dot files
+		if !strings.HasPrefix(file.Name(), ".") {
+			fis = append(fis, file)
+		}
+	}
+	return
+}
+
+// noDotFS is an http.FileSystem that hides
+// hidden "dot files" from being served.
+type noDotFS struct {
+	http.FileSystem
+}
+
+// Open is a wrapper around the Open method of the embedded FileSystem
+// that serves a 403 permission error when name has a file or directory
+// with whose name starts with a period in its path.
+func (fs noDotFS) Open(name string) (http.File, error) {
+	if isDotF(name) { // If dot file, return 403 response
+		debugf("refusing dot file %s", name)
+		return nil, os.ErrPermission
+	}
+
+	file, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return noDotF{file}, err
+}
+
+// ioFS is the fs.FS of an http.FileSystem, for the endpoints that walk
+// the files as they are served.
+type ioFS struct {
+	http.FileSystem
+}
+
+// Open opens name, which has no leading slash, in the FileSystem.
+func (f ioFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	p := "/" + name
+	if name == "." {
+		p = "/"
+	}
+	file, err := f.FileSystem.Open(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return ioFile{file}, nil
+}
+
+// ioFile is a file of an ioFS, which lists its entries as fs.ReadDir
+// does.
+type ioFile struct {
+	http.File
+}
+
+func (f ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	fis, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(fis))
+	for i, fi := range fis {
+		entries[i] = fs.FileInfoToDirEntry(fi)
+	}
+	return entries, err
+}
+
+// serveOptions holds the settings that shape how every document root is served.
+type serveOptions struct {
+	fallback  http.Handler       // consulted when a file does not exist, if non-nil
+	try       TryRules           // try_files style resolution chains
+	caseless  bool               // resolve paths without regard to case
+	unicode   bool               // resolve paths without regard to Unicode normalization
+	lang      string             // default language of localized files, if any
+	langRoot  string             // redirect / to the language dir the client prefers, or this one, if set
+	upload    string             // the path of the upload endpoint, if uploads are enabled
+	uploads   *store             // the store uploads are written to, if they are enabled
+	search    string             // the path of the search endpoint listings link to, if any
+	codeView  bool               // show source files as highlighted HTML pages to browsers
+	preview   bool               // show JSON and CSV files as trees and tables to browsers
+	digests   bool               // answer ?checksum= and send the digests of files
+	stat      bool               // answer ?stat=1 with a JSON description of a file
+	tree      bool               // answer ?tree=1 with the JSON tree of a dir
+	suggest   bool               // offer near matches for paths that do not exist
+	precomp   bool               // serve the compressed siblings Precompress writes
+	precStats *precompressStats  // counts what the siblings saved, if non-nil
+	markdown  *template.Template // the page Markdown files are rendered in, if they are
+	ssi       bool               // process the server-side includes of .shtml and .html files
+	php       *fastCGI           // the FastCGI server .php files in dirs are run with, if any
+	tmpl      bool               // execute .tmpl files as html/template pages
+	siteData  *siteData          // the data .tmpl pages are executed with, if any
+	manifest  *assetManifest     // the manifest of the fingerprinted assets, if any
+	mtimes    *mtimeManifest     // the manifest of the times files are sent with, if any
+	resolve   string             // "serve" or "redirect" to answer requests for assets with their copies, if set
+	dirConf   bool               // apply the DirConfigName files of dirs
+	dirPass   bool               // ask for the passwords of the DirPasswordName files of dirs
+	passKeys  *dirPasswordKeys   // what the DirPasswordName files of every dir share
+	qr        bool               // answer ?qr=1 with the QR code of a file's URL and link it from listings
+	zip       bool               // answer POSTs to ?zip=1 on dirs with a zip of the files named, and offer it in listings
+	shareKey  []byte             // the key the links of QR codes are signed with, if any
+	unfurl    bool               // answer the bots previewing share links with Open Graph pages
+	du        bool               // show the disk usage of listed dirs
+	geo       *geoDB             // locates the clients redirected to mirrors, if any
+	mirrors   GeoMirrors         // the regional mirrors downloads are redirected to, if any
+	mirrored  []string           // the path patterns redirected to mirrors, or every one if empty
+	prodBlock []string           // the name patterns of the files not served, if any
+	caches    *caches            // the caches the admin API purges
+	sched     *scheduler         // runs the jobs that make things again from the files, if any
+	lowMem    bool               // keep no sums in memory
+
+	auth      Accounts        // the accounts allowed to write files
+	put       bool            // accept PUT requests that write files
+	delete    bool            // accept DELETE requests that remove files
+	anonymize bool            // log only the network part of the IPs of writers
+	maxUpload int64           // the largest file a write may create, or 0 for no limit
+	exts      map[string]bool // the extensions writes may create, or nil for any
+	quota     int64           // the most bytes the files in a written dir may total, or 0 for no limit
+	webhook   string          // the URL writes are reported to, if any
+	scan      *scanner        // checks written files before they are served, if non-nil
+	stores    map[string]*store
+}
+
+// fold returns the function used to compare names that do not exist
+// exactly as requested, or nil if they must match exactly.
+func (o serveOptions) fold() func(string) string {
+	switch {
+	case o.caseless && o.unicode:
+		return func(s string) string { return strings.ToLower(nfd(s)) }
+	case o.caseless:
+		return strings.ToLower
+	case o.unicode:
+		return nfd
+	}
+	return nil
+}
+
+// visible returns fs without the files that are never served: dot
+// files, and with Prod the build leftovers. Every endpoint that reads
+// or walks the tree reads it through this.
+func (o serveOptions) visible(fs http.FileSystem) http.FileSystem {
+	fs = noDotFS{fs}
+	if len(o.prodBlock) > 0 {
+		fs = prodFS{FileSystem: fs, block: o.prodBlock}
+	}
+	return fs
+}
+
+// walked returns fs as visible does, without what the config and
+// password files of its dirs keep from anyone not asked for an account
+// or a password, for the endpoints that walk it for anyone.
+func (o serveOptions) walked(fs http.FileSystem) http.FileSystem {
+	shown := o.visible(fs)
+	if !o.dirConf && !o.dirPass {
+		return shown
+	}
+	g := guardFS{FileSystem: shown}
+	if o.dirConf {
+		g.configs = newDirConfigs(fs)
+		o.caches.add(func() error { g.configs.purge(); return nil })
+	}
+	if o.dirPass {
+		g.passwords = newDirPasswords(fs, o.passKeys)
+		o.caches.add(func() error { g.passwords.purge(); return nil })
+	}
+	return g
+}
+
+// store returns the store that writes files under dir. Every write
+// endpoint for the same dir shares one store, and so one quota.
+func (o serveOptions) store(dir string) *store {
+	if s, ok := o.stores[dir]; ok {
+		return s
+	}
+	s := &store{root: dir, maxSize: o.maxUpload, exts: o.exts, quota: o.quota, webhook: o.webhook, scan: o.scan}
+	if o.mtimes != nil {
+		mfs := newMtimeFS(http.Dir(dir), o.mtimes)
+		s.mtimes = &mfs
+	}
+	o.stores[dir] = s
+	return s
+}
+
+// fileServer returns the handler that serves the files under dir, or
+// under the release it points at if it is a symlink.
+func (o serveOptions) fileServer(dir string) http.Handler {
+	var h http.Handler
+	if isSymlink(dir) {
+		h = newReleases(o, dir)
+	} else {
+		h = o.dirFiles(dir)
+	}
+	if o.put || o.delete {
+		h = writeHandler{store: o.store(dir), auth: o.auth, put: o.put, delete: o.delete, anonymize: o.anonymize, next: h}
+	}
+	return h
+}
+
+// dirFiles returns the handler that serves the files under dir, and runs
+// its PHP scripts.
+func (o serveOptions) dirFiles(dir string) http.Handler {
+	if o.php == nil {
+		return o.files(http.Dir(dir))
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	var folded *foldFS
+	if fold := o.fold(); fold != nil {
+		folded = newFoldFS(http.Dir(dir), fold)
+	}
+	return o.scriptFiles(http.Dir(dir), func(next http.Handler) http.Handler {
+		return phpHandler{dir: dir, fcgi: *o.php, fold: folded, next: next}
+	})
+}
+
+// files returns the handler that serves the files in fs, which cannot
+// be written to.
+func (o serveOptions) files(fs http.FileSystem) http.Handler {
+	return o.scriptFiles(fs, nil)
+}
+
+// scriptFiles returns files(fs) with the handler scripts returns, if it
+// is non-nil, in front of the files, so that the config files of the
+// dirs apply to the scripts run as they do to the files.
+func (o serveOptions) scriptFiles(fs http.FileSystem, scripts func(http.Handler) http.Handler) http.Handler {
+	if o.mtimes != nil {
+		fs = newMtimeFS(fs, o.mtimes)
+	}
+	if fold := o.fold(); fold != nil {
+		fs = newFoldFS(fs, fold)
+	}
+	dotFS := fs
+	var configs *dirConfigs
+	if o.dirConf {
+		configs = newDirConfigs(dotFS)
+		o.caches.add(func() error { configs.purge(); return nil })
+	}
+	var passwords *dirPasswords
+	if o.dirPass {
+		passwords = newDirPasswords(dotFS, o.passKeys)
+		o.caches.add(func() error { passwords.purge(); return nil })
+	}
+	fs = o.visible(fs)
+	scriptFS := fs
+	if o.php != nil {
+		fs = phpSourceFS{fs}
+	}
+	var h http.Handler = http.FileServer(fs)
+	if o.precomp {
+		h = precompressedHandler{fs: fs, stats: o.precStats, next: h}
+	}
+	if o.suggest {
+		h = suggestHandler{fs: fs, next: h}
+	}
+	var sums *digestCache
+	if o.digests || o.stat {
+		sums = newDigestCache(maxDigests)
+		if o.lowMem {
+			sums = newDigestCache(0)
+		}
+		o.caches.addPaths(func(match func(string) bool) error {
+			if match == nil {
+				sums.purge()
+			} else {
+				sums.purgePaths(match)
+			}
+			return nil
+		})
+	}
+	if o.digests {
+		h = digestHandler{fs: fs, sums: sums, next: h}
+		if o.sched != nil && sums.max > 0 {
+			o.sched.add("checksums", func() error { return warmSums(fs, sums) })
+		}
+	}
+	var du *diskUsage
+	if o.du {
+		du = newDiskUsage(fs)
+		o.caches.add(func() error { du.purge(); return nil })
+	}
+	h = listingHandler{fs: fs, upload: o.upload, uploads: o.uploads, search: o.search, qr: o.qr, zip: o.zip, du: du, next: h}
+	if o.codeView {
+		h = codeViewHandler{fs: fs, next: h}
+	}
+	if o.preview {
+		h = previewHandler{fs: fs, next: h}
+	}
+	if o.markdown != nil {
+		h = markdownHandler{fs: fs, page: o.markdown, next: h}
+	}
+	if o.ssi {
+		h = ssiHandler{fs: fs, next: h}
+	}
+	if o.tmpl {
+		h = templateHandler{fs: fs, data: o.siteData, manifest: o.manifest, next: h}
+	}
+	if o.fallback != nil {
+		h = fallbackHandler{fs: fs, files: h, upstream: o.fallback}
+	}
+	if len(o.try) > 0 && scripts == nil {
+		h = tryHandler{fs: fs, rules: o.try, next: h}
+	}
+	if o.lang != "" {
+		h = langHandler{fs: fs, def: o.lang, next: h}
+	}
+	if o.langRoot != "" {
+		h = langRootHandler{fs: fs, def: o.langRoot, next: h}
+	}
+	if o.digests {
+		h = checksumHandler{fs: fs, sums: sums, next: h}
+	}
+	if o.stat {
+		h = statHandler{fs: fs, sums: sums, next: h}
+	}
+	if o.tree {
+		h = treeHandler{fs: fs, configs: configs, passwords: passwords, next: h}
+	}
+	if o.zip {
+		h = zipDownloadHandler{fs: fs, next: h}
+	}
+	if o.qr {
+		h = qrHandler{fs: fs, shareKey: o.shareKey, next: h}
+	}
+	if o.unfurl {
+		thumbs := newThumbCache(maxThumbs)
+		o.caches.add(func() error { thumbs.purge(); return nil })
+		h = unfurlHandler{fs: fs, thumbs: thumbs, next: h}
+	}
+	if o.resolve != "" {
+		h = resolveHandler{manifest: o.manifest, redirect: o.resolve == "redirect", next: h}
+	}
+	if o.manifest != nil {
+		h = immutableHandler{manifest: o.manifest, next: h}
+	}
+	if len(o.mirrors) > 0 {
+		h = geoMirrorHandler{fs: fs, geo: o.geo, mirrors: o.mirrors, paths: o.mirrored, next: h}
+	}
+	if scripts != nil {
+		h = scripts(h)
+		if len(o.try) > 0 {
+			// A candidate may be a script, so they are tried before it runs.
+			h = tryHandler{fs: scriptFS, rules: o.try, next: h}
+		}
+	}
+	if configs != nil {
+		h = dirConfigHandler{configs: configs, next: h}
+	}
+	if passwords != nil {
+		h = dirPasswordHandler{passwords: passwords, next: h}
+	}
+	return h
+}
+
+// Config says what a Server serves and how. The zero value serves the
+// files in the current dir and nothing else; each field turns on the
+// feature of the command line flag it is named after.
+type Config struct {
+	Dir      string  // the dir to serve, "." if empty
+	FS       fs.FS   // the files to serve instead of Dir, such as an embed.FS, if non-nil
+	VHosts   VHosts  // the dirs served instead of Dir for their hosts
+	Canary   Canary  // a second build served instead of Dir to a share of the clients
+	Versions bool    // serve the newest version dir of Dir, such as a dated release, and the others at /_v/<id>/
+	Proxies  Proxies // the backends requests under a path prefix are forwarded to
+	CGI      CGIDirs // the dirs of the CGI scripts run for requests under a path prefix
+
+	UserDirs    string // the dir of the users whose public_html dirs are served at /~user/, if any
+	UserDirName string // the dir in a user's dir served, public_html if empty
+	UserHost    string // the host whose subdomains, like alice.users.example.com, serve the users' dirs too
+
+	CGITimeout time.Duration // how long a CGI script may take to respond, 30s if 0
+	CGIEnv     []string      // the variables of the environment CGI scripts inherit, beyond the CGI ones
+
+	Fallback         string   // the URL of the origin asked for files that do not exist, if any
+	Mirror           string   // the URL of the upstream that Dir is a pull-through cache of, if any
+	Try              TryRules // try_files style resolution chains
+	CaseInsensitive  bool     // resolve paths that do not exist exactly without regard to case
+	UnicodeNormalize bool     // resolve paths that do not exist exactly by comparing names in NFD
+	Lang             string   // the default language of localized files, if any
+	LangRoot         string   // redirect / to the dir of the language, such as /de/, a lang cookie or Accept-Language asks for, or of this one if none fits, if set
+	CodeView         bool     // show source files as highlighted HTML pages with line numbers to browsers
+	Preview          bool     // show .json files as collapsible trees and .csv and .tsv files as sortable tables to browsers
+	Checksums        bool     // answer ?checksum=sha256 and the like with the sum of a file, and send Repr-Digest headers
+	StatAPI          bool     // answer ?stat=1 with the size, modification time, type and SHA-256 of a file as JSON
+	TreeAPI          bool     // answer ?tree=1 with the tree of the files under a dir as nested JSON
+	ListingQR        bool     // offer a QR code of each file's URL, or share link with a ShareKey, in listings
+	ZipDownloads     bool     // let listings download the files chosen in them as one zip, as POSTs of their names to ?zip=1 on a dir do
+	DiskUsage        bool     // show the size and number of the files under listed dirs, and serve them as JSON at /_api/du
+	Suggest          bool     // offer the near matches of paths that do not exist on 404 pages and in Link headers
+	Precompressed    bool     // serve files with the .br, .zst or .gz siblings Precompress writes to clients that accept them
+	PrecompressWatch bool     // write the siblings of files again as soon as the files change on disk, and remove those of files removed
+	PrecompressMin   int64    // the size of the smallest file whose siblings Reindex and PrecompressWatch write, 256 bytes if 0
+	PrecompressSkip  []string // the path patterns, extensions like .svg and types like image/* of the files whose siblings they do not write but remove
+	Markdown         bool     // render .md files as HTML pages
+	MarkdownTemplate string   // the file of the html/template they are rendered in instead of MarkdownPage, if any
+	SSI              bool     // process the server-side includes of .shtml and .html files
+	PHP              string   // the address of the FastCGI server, such as php-fpm, .php files are run with, if any
+	Templates        bool     // execute .tmpl files as html/template pages
+	TemplateData     string   // the JSON, YAML or TOML file .tmpl pages see as .Site, if any
+	Manifest         string   // the manifest written by Fingerprint, whose copies are served as never changing, if any
+	ManifestResolve  string   // "serve" or "redirect" to answer requests for the assets of Manifest with their copies, if set
+	Mtimes           string   // the manifest written by Mtimes, whose times files are sent with in place of their own while they are unchanged, if any
+	IgnoreDirConfig  bool     // do not read the DirConfigName files of the dirs served
+	IgnorePasswords  bool     // do not ask for the passwords of the DirPasswordName files of the dirs served
+	ShareKey         []byte   // the key ShareLink links are signed with, which are served if it is non-nil
+	SharePreviews    bool     // answer the bots that preview share links in chats with Open Graph pages and thumbnails
+	LowMemory        bool     // keep no checksums in memory, computing them for every request instead
+	Aliases          Aliases  // paths served from, or redirected to, other paths
+	Gone             map[string]bool
+	GoneBody         []byte // sent with each 410 Gone, if non-nil
+	WWW              string // "add" or "strip" to redirect to the canonical host, if set
+	HTTPSRedirect    bool   // redirect plain http requests to https
+	Search           bool   // index the text, HTML and Markdown files served and answer searches at /_search
+	Sitemap          bool   // serve a /sitemap.xml of the pages served, if the site has none
+	SitemapExclude   []string
+	SitemapBase      string // the URL the sitemap's paths are under, from each request if empty
+	Feed             string // the path of the dir whose newest files /_feed.xml lists, if any
+	FeedSize         int    // how many files the feed lists, 20 if 0
+
+	Form         string // the path form submissions are accepted at, if any
+	FormRedirect string // the URL clients are redirected to after submitting
+	FormSave     string // the file submissions are appended to, if any
+	FormWebhook  string // the URL submissions are POSTed to as JSON, if any
+	FormMaxSize  int64  // the largest submission accepted, 1MB if 0
+
+	Auth            Accounts // the accounts allowed to write files
+	Put             bool     // accept PUT requests that write files
+	Delete          bool     // accept DELETE requests that remove files
+	MaxUpload       int64    // the largest file a write may create, or 0 for no limit
+	Quota           int64    // the most bytes the files in a written dir may total, or 0 for no limit
+	UploadExt       []string // the extensions writes may create, or nil for any
+	WriteWebhook    string   // the URL writes are reported to, if any
+	ScanCmd         []string // the command written files are checked with, if any
+	ScanURL         string   // the URL written files are checked by, if any
+	Quarantine      string   // the dir rejected files are moved to instead of deleted
+	ScanTimeout     time.Duration
+	Upload          string // the dir browser uploads to /upload go to, if any
+	UploadOverwrite string // "replace", "rename" or "deny" uploads of existing files, rename if empty
+	WebDAV          string // the path the tree is served over WebDAV at, if any
+	WebDAVRoot      string // the dir served over WebDAV instead of Dir
+	WebDAVReadOnly  bool
+	Tus             string // the path resumable uploads into Upload are accepted at, if any
+
+	AccessLog    io.Writer // where a line per request is written, if non-nil
+	AuditLog     string    // the file a hash-chained line per request made with a user name is appended to, if any
+	AuditKey     []byte    // the key the lines of AuditLog are hashed with, if any
+	LogFormat    string    // "common", "combined" or "json", combined if empty
+	LogStatus    []string  // the status codes or classes, like 4xx, to log, or all if empty
+	LogExclude   []string  // path patterns not to log
+	AnonymizeIP  bool      // log client IPs with their host part zeroed
+	GeoIP        string    // the MaxMind DB file clients are located in, if any
+	SlowLog      time.Duration
+	RequestID    bool   // tag every request with an X-Request-Id
+	OTLPEndpoint string // the OTLP/HTTP traces URL spans are exported to, if any
+	TraceService string // the service.name of exported spans
+	Summary      bool   // count traffic for WriteSummary
+	Alert        Alerts
+
+	Bans    []string // the client IP addresses and networks refused with 403
+	BanFile string   // the file of more bans, one a line, which the admin API saves them to, if any
+
+	TarpitPaths  []string // the honeypot path patterns whose clients are held in the tarpit
+	TarpitAgents []string // the User-Agent words, such as sqlmap, whose clients are held in the tarpit
+	TarpitConns  int      // the most clients held in the tarpit at once, 64 if 0
+
+	PerIPRequests   int           // the most requests served at once to a client IP, or 0 for no limit
+	PerIPWait       time.Duration // how long a request past PerIPRequests waits for its turn before 429 Too Many Requests
+	PerIPRetryAfter time.Duration // the Retry-After of 429 responses, PerIPWait or a second if 0
+	PerIPPages      []string      // the files sent as 429 bodies, each to the clients that accept its type
+	PerIPChallenge  bool          // answer browsers past PerIPRequests with a proof of work, or a CAPTCHA, and let those who pass it past the limit
+	ChallengeBits   int           // the leading zero bits of the proof of work, 16 if 0
+	ChallengePage   string        // the html/template file of the challenge page, a proof-of-work one if empty
+	ChallengeVerify string        // the siteverify URL the CAPTCHA responses posted by ChallengePage are checked with, if any
+	ChallengeSecret string        // the secret key of ChallengeVerify
+
+	MaxRequestDuration time.Duration // how long a request may take to serve before it is aborted, or 0 for no limit
+
+	GeoAllow  []string // the ISO country codes of the clients served, located with GeoIP, or every one if empty
+	GeoDeny   []string // the ISO country codes of the clients refused
+	GeoStatus int      // the status of refusals, 403 Forbidden if 0
+	GeoPage   string   // the file sent as the body of refusals, a plain one if empty
+
+	GeoMirrors     GeoMirrors // the regional mirrors the downloads of clients located with GeoIP are redirected to
+	GeoMirrorPaths []string   // the path patterns redirected to GeoMirrors, or every file but pages if empty
+
+	Prod      bool     // refuse to serve the build leftovers named by DefaultProdBlock, as if they did not exist
+	ProdBlock []string // the name patterns of more files Prod refuses to serve
+
+	NoIndex     bool     // keep the pages out of search engines with an X-Robots-Tag
+	RobotsTag   string   // the X-Robots-Tag of NoIndex, "noindex, nofollow" if empty
+	RobotsPaths []string // the path patterns NoIndex tags, or every path if empty
+
+	CacheControl CacheRules // the Cache-Control headers of paths, which also say how long Mirror keeps its copies
+
+	Maintenance      bool          // start in maintenance mode, which SetMaintenance and /_maintenance turn on and off
+	MaintenancePage  string        // the file served with every 503 in maintenance mode, a plain page if empty
+	MaintenanceRetry time.Duration // the Retry-After of those responses, 5 minutes if 0
+
+	Metrics        bool                    // serve Prometheus metrics at /metrics
+	Pprof          bool                    // serve runtime profiles at /debug/pprof/
+	Stats          bool                    // serve a live statistics page at /_stats to the Auth accounts
+	Hits           string                  // the JSON file complete downloads are counted in, if any
+	Health         bool                    // answer probes at /healthz and /readyz
+	SeparateAdmin  bool                    // serve the admin endpoints only from Admin
+	AdminAPI       bool                    // serve the /_admin/ API of bans, cache purges and stats from Admin, which must be separate
+	AdminEndpoints map[string]http.Handler // more handlers to serve with the admin endpoints, by pattern
+
+	Delay       Delays // how long to hold back responses
+	DelayJitter time.Duration
+	Chaos       float64  // the share of requests to fail, from 0 to 1
+	ChaosModes  []string // how to fail: error statuses or truncate, 500, 503 and truncate if empty
+	Shadow      string   // the URL of a backend to send copies of requests to, ignoring its responses
+	ShadowRate  float64  // the share of requests to copy to Shadow, all of them if 0
+	Simulate    NetProfile
+	Dev         bool // allow requests from any origin and turn off caching
+	COI         bool // isolate pages from other origins, so they may use SharedArrayBuffer
+	PWA         bool // serve web app manifests and service workers with their types, revalidated every time
+	PWAWorker   bool // serve a generated offline-caching service worker at /sw.js, unless the site has one, and register it from pages
+	Media       bool // serve HLS and DASH streams with their types, CORS for players on any origin, and short-lived playlists
+	Echo        bool // describe requests to /_echo as JSON
+	Watch       bool // recount Quota usage as soon as files change on disk
+	Events      bool // stream changes to the files served at /_events
+	LiveReload  bool // reload pages in the browser when the files served change
+
+	InjectBodyEnd string // the file of a snippet, such as an analytics script, inserted before the </body> of HTML pages, if any
+
+	Reindex time.Duration // how often the sitemap, search index, checksums and Precompressed siblings are made again from the files, or never if 0
+
+	// Middleware wraps everything the Server serves, after paths are
+	// normalized but before any other handling, so it can authenticate,
+	// set headers or rewrite requests. The first wraps the outermost.
+	Middleware []Middleware
+	// FileMiddleware wraps only the serving of files, inside routing
+	// to proxies, uploads and the other endpoints.
+	FileMiddleware []Middleware
+}
+
+// Middleware returns a handler that does its work around next, which it
+// calls to carry on serving the request, or not, if it answers itself.
+type Middleware func(next http.Handler) http.Handler
+
+// wrap returns h wrapped in each of m, the first outermost.
+func wrap(h http.Handler, m []Middleware) http.Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// Alerts configures the alerts POSTed to a Slack-compatible webhook
+// when too many requests fail within a window.
+type Alerts struct {
+	Webhook     string
+	Window      time.Duration // one minute if 0
+	ServerError int           // the number of 5xx responses to alert at, or 0 for never
+	NotFound    int           // the number of 404 responses to alert at, or 0 for never
+}
+
+// Server is an http.Handler that serves what its Config says.
+type Server struct {
+	handler   http.Handler
+	admin     *http.ServeMux
+	adminRoot http.Handler // admin, with its requests audited if it is served apart
+
+	spans   *spanExporter
+	hits    *hitCounter
+	summary *trafficSummary
+	maint   *maintenance
+	closers []func()
+	once    sync.Once
+}
+
+// New returns a Server for cfg, or an error if cfg is invalid or a
+// file it names cannot be opened. The Server starts any background
+// work cfg needs, such as watching dirs, which Close stops.
+func New(cfg Config) (*Server, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "."
+	}
+	opts := serveOptions{
+		try:       cfg.Try,
+		caseless:  cfg.CaseInsensitive,
+		unicode:   cfg.UnicodeNormalize,
+		lang:      cfg.Lang,
+		langRoot:  cfg.LangRoot,
+		codeView:  cfg.CodeView,
+		preview:   cfg.Preview,
+		digests:   cfg.Checksums,
+		stat:      cfg.StatAPI,
+		tree:      cfg.TreeAPI,
+		suggest:   cfg.Suggest,
+		precomp:   cfg.Precompressed,
+		ssi:       cfg.SSI,
+		tmpl:      cfg.Templates,
+		dirConf:   !cfg.IgnoreDirConfig,
+		dirPass:   !cfg.IgnorePasswords,
+		passKeys:  newDirPasswordKeys(),
+		qr:        cfg.ListingQR,
+		zip:       cfg.ZipDownloads,
+		du:        cfg.DiskUsage,
+		shareKey:  cfg.ShareKey,
+		unfurl:    cfg.SharePreviews,
+		caches:    &caches{},
+		lowMem:    cfg.LowMemory,
+		auth:      cfg.Auth,
+		put:       cfg.Put,
+		delete:    cfg.Delete,
+		anonymize: cfg.AnonymizeIP,
+		maxUpload: cfg.MaxUpload,
+		quota:     cfg.Quota,
+		webhook:   cfg.WriteWebhook,
+		stores:    map[string]*store{},
+	}
+	if opts.auth == nil {
+		opts.auth = Accounts{}
+	}
+	if cfg.Reindex > 0 {
+		opts.sched = newScheduler(cfg.Reindex)
+	}
+	precRules := PrecompressRules{MinSize: cfg.PrecompressMin, Skip: cfg.PrecompressSkip}
+	if precRules.MinSize != 0 || len(precRules.Skip) > 0 {
+		if !cfg.Precompressed {
+			return nil, errors.New("precompress rules require precompressed")
+		}
+		if err := precRules.Check(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Precompressed && cfg.Metrics {
+		opts.precStats = &precompressStats{}
+	}
+	if cfg.LangRoot != "" && !isLangTag(cfg.LangRoot) {
+		return nil, fmt.Errorf("invalid lang root %q, expected a language like en or pt-BR", cfg.LangRoot)
+	}
+	if cfg.SharePreviews && cfg.ShareKey == nil {
+		return nil, errors.New("share previews require a share key")
+	}
+	var geo *geoDB
+	if cfg.GeoIP != "" {
+		var err error
+		if geo, err = openGeoDB(cfg.GeoIP); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.GeoMirrors) > 0 {
+		if geo == nil {
+			return nil, errors.New("regional mirrors require a geoip DB")
+		}
+		for _, p := range cfg.GeoMirrorPaths {
+			if !validPathPattern(p) {
+				return nil, fmt.Errorf("invalid geo mirror path %q, expected /path, /dir/ or a pattern like /releases/*", p)
+			}
+		}
+		opts.geo, opts.mirrors, opts.mirrored = geo, cfg.GeoMirrors, cfg.GeoMirrorPaths
+	}
+	if cfg.Markdown {
+		page := template.New("markdown")
+		var err error
+		if cfg.MarkdownTemplate != "" {
+			page, err = template.ParseFiles(cfg.MarkdownTemplate)
+		} else {
+			page, err = page.Parse(MarkdownPage)
+		}
+		if err != nil {
+			return nil, err
+		}
+		opts.markdown = page
+	}
+	if cfg.TemplateData != "" {
+		if !cfg.Templates {
+			return nil, errors.New("template data requires templates")
+		}
+		d, err := loadSiteData(cfg.TemplateData)
+		if err != nil {
+			return nil, err
+		}
+		opts.siteData = d
+	}
+	if cfg.Manifest != "" {
+		m, err := loadManifest(cfg.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		opts.manifest = m
+	}
+	if cfg.ManifestResolve != "" {
+		switch {
+		case !slices.Contains(ManifestResolves, cfg.ManifestResolve):
+			return nil, fmt.Errorf("invalid manifest resolve %q, expected serve or redirect", cfg.ManifestResolve)
+		case opts.manifest == nil:
+			return nil, errors.New("resolving assets requires a manifest")
+		}
+		opts.resolve = cfg.ManifestResolve
+	}
+	if cfg.Mtimes != "" {
+		m, err := loadMtimes(cfg.Mtimes)
+		if err != nil {
+			return nil, err
+		}
+		opts.mtimes = m
+	}
+	if cfg.PHP != "" {
+		f, err := parseFastCGI(cfg.PHP)
+		if err != nil {
+			return nil, err
+		}
+		opts.php = &f
+	}
+	if cfg.Fallback != "" {
+		u, err := parseUpstream(cfg.Fallback)
+		if err != nil {
+			return nil, err
+		}
+		opts.fallback = newReverseProxy(u)
+	}
+	if cfg.UploadExt != nil {
+		opts.exts = map[string]bool{}
+		for _, ext := range cfg.UploadExt {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext != "" && !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			opts.exts[ext] = true
+		}
+	}
+	if len(cfg.ScanCmd) > 0 || cfg.ScanURL != "" {
+		opts.scan = &scanner{cmd: cfg.ScanCmd, url: cfg.ScanURL, quarantine: cfg.Quarantine, timeout: cfg.ScanTimeout}
+		if opts.scan.timeout == 0 {
+			opts.scan.timeout = 5 * time.Minute
+		}
+	}
+	if cfg.Upload != "" {
+		opts.upload, opts.uploads = "/upload", opts.store(cfg.Upload)
+	}
+	if cfg.Prod {
+		var err error
+		if opts.prodBlock, err = prodBlock(cfg.ProdBlock); err != nil {
+			return nil, err
+		}
+	} else if len(cfg.ProdBlock) > 0 {
+		return nil, errors.New("prod block patterns require prod mode")
+	}
+	switch {
+	case cfg.Tus != "" && cfg.Upload == "":
+		return nil, errors.New("tus uploads require an upload dir")
+	case cfg.Tus != "" && len(opts.auth) == 0:
+		return nil, errors.New("tus uploads require at least one auth account")
+	case cfg.WebDAV != "" && !cfg.WebDAVReadOnly && len(opts.auth) == 0:
+		return nil, errors.New("webdav requires at least one auth account unless it is read-only")
+	case (cfg.Put || cfg.Delete) && len(opts.auth) == 0:
+		return nil, errors.New("put and delete require at least one auth account")
+	case cfg.Stats && len(opts.auth) == 0:
+		return nil, errors.New("the stats page requires at least one auth account")
+	case cfg.FS != nil && cfg.PHP != "":
+		return nil, errors.New("php scripts must be in a dir, not an FS")
+	case cfg.FS != nil && cfg.Mirror != "":
+		return nil, errors.New("a mirror must be a dir, not an FS")
+	case cfg.FS != nil && cfg.Versions:
+		return nil, errors.New("versions must be dirs, not an FS")
+	case cfg.Versions && cfg.Mirror != "":
+		return nil, errors.New("versions cannot be mirrored")
+	case cfg.FS != nil && (cfg.Put || cfg.Delete):
+		return nil, errors.New("put and delete cannot write to an FS")
+	case cfg.FS != nil && cfg.WebDAV != "" && cfg.WebDAVRoot == "" && !cfg.WebDAVReadOnly:
+		return nil, errors.New("webdav of an FS must be read-only")
+	}
+
+	// The dirs on disk served, which an FS takes the place of.
+	var dirs []string
+	var root http.Handler
+	// The tree as the endpoints that walk it see it.
+	var tree http.FileSystem = http.Dir(dir)
+	if cfg.FS != nil {
+		tree = http.FS(cfg.FS)
+	}
+	tree = opts.walked(tree)
+	var index *searchIndex
+	if cfg.Search {
+		index = newSearchIndex(ioFS{tree})
+		index.rebuild()
+		opts.search = searchPath
+	}
+	if cfg.FS != nil {
+		root = opts.files(http.FS(cfg.FS))
+		if types, ok := cfg.FS.(contentTyper); ok {
+			root = typeHandler{types: types, next: root}
+		}
+	} else {
+		dirs = append(dirs, dir)
+		if cfg.Versions {
+			versions := newVersionsHandler(dir, opts.fileServer)
+			opts.caches.add(func() error { versions.purge(); return nil })
+			root = versions
+		} else {
+			root = opts.fileServer(dir)
+		}
+		if cfg.Mirror != "" {
+			u, err := parseUpstream(cfg.Mirror)
+			if err != nil {
+				return nil, err
+			}
+			m := newMirrorHandler(dir, u, cfg.CacheControl, root)
+			opts.caches.addPaths(m.purge)
+			root = m
+		}
+	}
+	if cfg.Canary.Dir != "" {
+		// Only the stable build is searched.
+		copts := opts
+		copts.search = ""
+		root = canaryHandler{share: cfg.Canary.Share, stable: root, canary: copts.fileServer(cfg.Canary.Dir)}
+	}
+	if len(cfg.VHosts) > 0 {
+		vh := vhostHandler{hosts: map[string]http.Handler{}, def: root}
+		// Only the default root is searched.
+		vopts := opts
+		vopts.search = ""
+		for host, d := range cfg.VHosts {
+			vh.hosts[host] = vopts.fileServer(d)
+		}
+		root = vh
+	}
+
+	s := &Server{}
+	if cfg.UserDirs != "" {
+		users := newUserDirs(cfg.UserDirs, cfg.UserDirName, cfg.UserHost, opts, root)
+		s.closers = append(s.closers, users.close)
+		root = users
+	} else if cfg.UserHost != "" {
+		return nil, errors.New("a user host requires user dirs")
+	}
+	staticMux := http.NewServeMux()
+	staticMux.Handle("/", wrap(root, cfg.FileMiddleware))
+	if cfg.Feed != "" {
+		if !strings.HasPrefix(cfg.Feed, "/") {
+			return nil, fmt.Errorf("invalid feed path %q, expected /path", cfg.Feed)
+		}
+		feed := fileFeed{fsys: ioFS{tree}, prefix: path.Clean(cfg.Feed), size: cfg.FeedSize}
+		if feed.size == 0 {
+			feed.size = feedSize
+		}
+		staticMux.Handle(feedPath, feed)
+	}
+	var smap *sitemap
+	if cfg.Sitemap {
+		var err error
+		if smap, err = newSitemap(ioFS{tree}, cfg.SitemapExclude, cfg.SitemapBase); err != nil {
+			return nil, err
+		}
+		smap.files = wrap(root, cfg.FileMiddleware)
+		staticMux.Handle(sitemapPath, smap)
+	}
+	if cfg.Form != "" {
+		form := &formHandler{next: cfg.FormRedirect, save: cfg.FormSave, webhook: cfg.FormWebhook, maxSize: cfg.FormMaxSize}
+		if form.maxSize == 0 {
+			form.maxSize = 1 << 20
+		}
+		staticMux.Handle(cfg.Form, form)
+	}
+	var uploads *store
+	if cfg.Upload != "" {
+		upload := &uploadHandler{auth: opts.auth, overwrite: "rename"}
+		if cfg.UploadOverwrite != "" {
+			if err := upload.setOverwrite(cfg.UploadOverwrite); err != nil {
+				return nil, err
+			}
+		}
+		upload.store = opts.uploads
+		uploads = upload.store
+		if opts.dirConf {
+			upload.configs = newDirConfigs(http.Dir(uploads.root))
+			opts.caches.add(func() error { upload.configs.purge(); return nil })
+		}
+		if opts.dirPass {
+			upload.passwords = newDirPasswords(http.Dir(uploads.root), opts.passKeys)
+			opts.caches.add(func() error { upload.passwords.purge(); return nil })
+		}
+		staticMux.Handle("/upload", upload)
+	}
+	if cfg.Tus != "" {
+		tus := newTusHandler(strings.TrimSuffix(cfg.Tus, "/")+"/", uploads, opts.auth)
+		if !strings.HasPrefix(tus.prefix, "/") || tus.prefix == "/" {
+			return nil, fmt.Errorf("invalid tus path %q, expected /path", cfg.Tus)
+		}
+		staticMux.Handle(tus.prefix, tus)
+		tus.start()
+		s.closers = append(s.closers, tus.close)
+	}
+	if cfg.WebDAV != "" {
+		dav := &davHandler{prefix: strings.TrimSuffix(cfg.WebDAV, "/"), auth: opts.auth, readOnly: cfg.WebDAVReadOnly}
+		if !strings.HasPrefix(dav.prefix, "/") {
+			return nil, fmt.Errorf("invalid webdav path %q, expected /path", cfg.WebDAV)
+		}
+		if davRoot := cfg.WebDAVRoot; davRoot != "" || cfg.FS == nil {
+			if davRoot == "" {
+				davRoot = dir
+			}
+			dav.store = opts.store(davRoot)
+			dav.fs = opts.walked(http.Dir(davRoot))
+			if dav.store.mtimes != nil {
+				dav.fs = opts.walked(*dav.store.mtimes)
+			}
+		} else {
+			dav.fs = tree
+		}
+		staticMux.Handle(dav.prefix, dav)
+		staticMux.Handle(dav.prefix+"/", dav)
+	}
+	cfg.Proxies.register(staticMux)
+	if index != nil {
+		staticMux.Handle(searchPath, index)
+	}
+	if cfg.DiskUsage {
+		du := newDiskUsage(tree)
+		opts.caches.add(func() error { du.purge(); return nil })
+		staticMux.Handle(duPath, du)
+	}
+	if len(cfg.CGI) > 0 {
+		timeout := cfg.CGITimeout
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+		if err := cfg.CGI.register(staticMux, timeout, cfg.CGIEnv); err != nil {
+			return nil, err
+		}
+	}
+
+	// Admin endpoints are served on their own when asked to be. Those
+	// served with the files are also kept in exempt, which maintenance
+	// mode lets through.
+	s.admin = staticMux
+	exempt := http.NewServeMux()
+	if cfg.SeparateAdmin {
+		s.admin = http.NewServeMux()
+	}
+	adminHandle := func(pattern string, h http.Handler) {
+		s.admin.Handle(pattern, h)
+		exempt.Handle(pattern, h)
+	}
+	var stats *metrics
+	if cfg.Metrics {
+		stats = newMetrics()
+		stats.precompressed = opts.precStats
+		adminHandle("/metrics", stats)
+	}
+	if cfg.Pprof {
+		registerPprof(s.admin)
+		registerPprof(exempt)
+	}
+	if cfg.Hits != "" {
+		var err error
+		if s.hits, err = openHitCounter(cfg.Hits, 10*time.Second); err != nil {
+			return nil, err
+		}
+		adminHandle(hitsPath, s.hits)
+	}
+	var dash *dashboard
+	if cfg.Stats || cfg.AdminAPI {
+		dash = newDashboard("/_stats", opts.auth)
+		s.closers = append(s.closers, dash.close)
+	}
+	if cfg.Stats {
+		adminHandle("/_stats", dash)
+		adminHandle("/_stats/", dash)
+	}
+	if cfg.Health {
+		roots := readyHandler(slices.Clone(dirs))
+		for _, d := range cfg.VHosts {
+			roots = append(roots, d)
+		}
+		if cfg.Canary.Dir != "" {
+			roots = append(roots, cfg.Canary.Dir)
+		}
+		adminHandle("/healthz", http.HandlerFunc(healthHandler))
+		adminHandle("/readyz", roots)
+	}
+	for pattern, h := range cfg.AdminEndpoints {
+		adminHandle(pattern, h)
+	}
+	var err error
+	if s.maint, err = newMaintenance(cfg, opts.auth); err != nil {
+		return nil, err
+	}
+	adminHandle(maintenancePath, s.maint)
+	if !cfg.SeparateAdmin {
+		s.maint.admin = exempt
+	}
+	bans, err := newBanList(cfg.Bans, cfg.BanFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AdminAPI {
+		if !cfg.SeparateAdmin {
+			return nil, errors.New("the admin API is only served apart from the files, with SeparateAdmin")
+		}
+		if p, ok := cfg.FS.(interface{ Purge() error }); ok {
+			opts.caches.addPaths(func(match func(string) bool) error {
+				if match != nil {
+					// A copy is kept per version of its object, so one
+					// of an object that changed is never served again.
+					return nil
+				}
+				return p.Purge()
+			})
+		}
+		api := &adminAPI{bans: bans, caches: opts.caches, dash: dash, maint: s.maint, shareKey: cfg.ShareKey}
+		api.register(s.admin)
+	}
+
+	var watcher *dirWatcher
+	if cfg.PrecompressWatch && !cfg.Precompressed {
+		return nil, errors.New("precompress watch requires precompressed")
+	}
+	if cfg.LiveReload || cfg.Watch || cfg.Events || cfg.PrecompressWatch || (index != nil || smap != nil) && len(dirs) > 0 {
+		watched := slices.Clone(dirs)
+		for _, d := range cfg.VHosts {
+			watched = append(watched, d)
+		}
+		if cfg.Canary.Dir != "" {
+			watched = append(watched, cfg.Canary.Dir)
+		}
+		for d := range opts.stores {
+			if !slices.Contains(watched, d) {
+				watched = append(watched, d)
+			}
+		}
+		watcher = newDirWatcher(watched, 500*time.Millisecond)
+	}
+	if cfg.Watch {
+		// Files changed behind the server's back throw out its counts.
+		watcher.subscribe(func([]fileChange) {
+			for _, st := range opts.stores {
+				st.recount()
+			}
+		})
+	}
+	if cfg.PrecompressWatch {
+		pw, err := newPrecompressWatcher(watcher.dirs, availablePrecompressFormats(), precRules)
+		if err != nil {
+			return nil, err
+		}
+		watcher.subscribe(pw.changed)
+		s.closers = append(s.closers, pw.close)
+	}
+	if index != nil && len(dirs) > 0 {
+		watcher.subscribe(index.changed(dir))
+	}
+	if smap != nil && len(dirs) > 0 {
+		watcher.subscribe(smap.changed(dir))
+	}
+	if cfg.LiveReload {
+		live := newLiveReload()
+		watcher.subscribe(live.changed)
+		staticMux.Handle(liveReloadPath, live)
+		s.closers = append(s.closers, live.close)
+	}
+	if cfg.Events {
+		hosts := map[string]string{}
+		for _, d := range dirs {
+			hosts[d] = ""
+		}
+		for host, d := range cfg.VHosts {
+			hosts[d] = host
+		}
+		events := newFileEvents(hosts)
+		watcher.subscribe(events.changed)
+		staticMux.Handle(eventsPath, events)
+		s.closers = append(s.closers, events.close)
+	}
+	if watcher != nil {
+		watcher.start()
+		s.closers = append(s.closers, watcher.close)
+	}
+	if opts.sched != nil {
+		if smap != nil {
+			opts.sched.add("sitemap", func() error { smap.build(); return nil })
+		}
+		if index != nil {
+			opts.sched.add("search", func() error { index.build(); return nil })
+		}
+		if cfg.Precompressed && len(dirs) > 0 {
+			formats := availablePrecompressFormats()
+			for _, d := range dirs {
+				opts.sched.add("precompress "+d, func() error {
+					_, err := Precompress(d, formats, 1, precRules)
+					return err
+				})
+				opts.caches.addPaths(func(match func(string) bool) error {
+					if match == nil {
+						// Writing every sibling again takes long; the
+						// schedule does it.
+						return nil
+					}
+					_, err := remakePrecompressed(d, formats, precRules, match)
+					return err
+				})
+			}
+		}
+		opts.sched.start()
+		s.closers = append(s.closers, opts.sched.close)
+	}
+
+	var handler http.Handler = staticMux
+	if cfg.Echo {
+		staticMux.HandleFunc(echoPath, echoHandler)
+	}
+	if cfg.LiveReload {
+		handler = liveReloadHandler{next: handler}
+	}
+	if cfg.InjectBodyEnd != "" {
+		snippet, err := os.ReadFile(cfg.InjectBodyEnd)
+		if err != nil {
+			return nil, err
+		}
+		handler = injectHandler{snippet: snippet, next: handler}
+	}
+	if len(cfg.Aliases) > 0 {
+		handler = aliasHandler{aliases: cfg.Aliases, next: handler}
+	}
+	if cfg.ShareKey != nil {
+		handler = shareHandler{key: cfg.ShareKey, next: handler}
+	}
+	if len(cfg.Gone) > 0 {
+		handler = goneHandler{paths: cfg.Gone, body: cfg.GoneBody, next: handler}
+	}
+	if cfg.NoIndex || len(cfg.RobotsPaths) > 0 {
+		robots, err := newRobotsHandler(cfg.RobotsTag, cfg.RobotsPaths, handler)
+		if err != nil {
+			return nil, err
+		}
+		handler = robots
+	}
+	if cfg.Media {
+		handler = mediaHandler{next: handler}
+	}
+	if cfg.PWAWorker && !cfg.PWA {
+		return nil, errors.New("a generated service worker requires pwa mode")
+	}
+	if cfg.PWA {
+		pwa := pwaHandler{next: handler}
+		if cfg.PWAWorker {
+			pwa.worker = newServiceWorker(tree)
+			opts.caches.add(func() error { pwa.worker.purge(); return nil })
+			handler = injectHandler{snippet: []byte(swRegister), next: handler}
+			pwa.next = handler
+		}
+		handler = pwa
+	}
+	if len(cfg.CacheControl) > 0 {
+		handler = cacheHandler{rules: cfg.CacheControl, next: handler}
+	}
+	if cfg.WWW != "" || cfg.HTTPSRedirect {
+		canonical := canonicalHandler{https: cfg.HTTPSRedirect, next: handler}
+		if cfg.WWW != "" {
+			if err := canonical.setWWW(cfg.WWW); err != nil {
+				return nil, err
+			}
+		}
+		handler = canonical
+	}
+	if cfg.Dev {
+		handler = devHandler{next: handler}
+	}
+	if cfg.COI {
+		handler = coiHandler{cors: cfg.Dev, next: handler}
+	}
+	if cfg.Simulate.rate > 0 {
+		handler = throttleHandler{profile: cfg.Simulate, next: handler}
+	}
+	if cfg.Chaos > 0 {
+		chaos := chaosHandler{rate: cfg.Chaos, modes: []string{"500", "503", "truncate"}, next: handler}
+		if len(cfg.ChaosModes) > 0 {
+			if err := chaos.setModes(cfg.ChaosModes); err != nil {
+				return nil, err
+			}
+		}
+		handler = chaos
+	}
+	if len(cfg.Delay) > 0 || cfg.DelayJitter > 0 {
+		handler = delayHandler{delays: cfg.Delay, jitter: cfg.DelayJitter, next: handler}
+	}
+	handler = wrap(s.maint.handler(handler), cfg.Middleware)
+	if len(cfg.GeoAllow) > 0 || len(cfg.GeoDeny) > 0 {
+		if geo == nil {
+			return nil, errors.New("restricting countries requires a geoip DB")
+		}
+		block, err := newGeoBlock(cfg, geo)
+		if err != nil {
+			return nil, err
+		}
+		handler = block.handler(handler)
+	}
+	if cfg.PerIPChallenge && cfg.PerIPRequests <= 0 {
+		return nil, errors.New("a per-ip challenge requires a per-ip request limit")
+	}
+	if cfg.PerIPRequests > 0 {
+		limit, err := newPerIPLimit(cfg)
+		if err != nil {
+			return nil, err
+		}
+		handler = limit.handler(handler)
+	}
+	if len(bans.list()) > 0 || cfg.AdminAPI {
+		handler = bans.handler(handler)
+	}
+	if len(cfg.TarpitPaths) > 0 || len(cfg.TarpitAgents) > 0 {
+		pit, err := newTarpit(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, pit.close)
+		handler = pit.handler(handler)
+	}
+	if cfg.MaxRequestDuration > 0 {
+		handler = deadlineHandler{limit: cfg.MaxRequestDuration, next: handler}
+	}
+	if cfg.Shadow != "" {
+		u, err := parseUpstream(cfg.Shadow)
+		if err != nil {
+			return nil, err
+		}
+		rate := cfg.ShadowRate
+		if rate == 0 {
+			rate = 1
+		}
+		handler = newShadowHandler(u, rate, handler)
+	} else if cfg.ShadowRate > 0 {
+		return nil, errors.New("a shadow rate requires a shadow URL")
+	}
+	handler = normalizeHandler{next: handler}
+
+	var observers []func(*requestRecord)
+	access := &accessLog{format: "combined", w: cfg.AccessLog}
+	if cfg.LogFormat != "" {
+		if err := access.setFormat(cfg.LogFormat); err != nil {
+			return nil, err
+		}
+	}
+	if err := access.setStatuses(cfg.LogStatus); err != nil {
+		return nil, err
+	}
+	for _, pattern := range cfg.LogExclude {
+		if err := access.addExclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	if access.w != nil {
+		observers = append(observers, access.log)
+	} else if verbosity() >= LevelVerbose {
+		observers = append(observers, func(rr *requestRecord) {
+			if access.wants(rr) {
+				log.Print(access.line(rr))
+			}
+		})
+	}
+	if stats != nil {
+		observers = append(observers, stats.observe)
+	}
+	if cfg.SlowLog > 0 {
+		observers = append(observers, slowLog(cfg.SlowLog))
+	}
+	if dash != nil {
+		observers = append(observers, dash.observe)
+	}
+	if s.hits != nil {
+		observers = append(observers, s.hits.observe)
+	}
+	if cfg.Alert.Webhook != "" {
+		window := cfg.Alert.Window
+		if window == 0 {
+			window = time.Minute
+		}
+		alerts := newAlerter(cfg.Alert.Webhook, window)
+		alerts.add("server errors", cfg.Alert.ServerError, func(rr *requestRecord) bool { return rr.Status >= 500 })
+		alerts.add("requests not found", cfg.Alert.NotFound, func(rr *requestRecord) bool { return rr.Status == 404 })
+		observers = append(observers, alerts.observe)
+	}
+	var audit *auditLog
+	if cfg.AuditLog != "" {
+		var err error
+		audit, err = newAuditLog(cfg.AuditLog, cfg.AuditKey)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, audit.close)
+		observers = append(observers, audit.observe)
+	} else if len(cfg.AuditKey) > 0 {
+		return nil, errors.New("an audit key requires an audit log")
+	}
+	if cfg.Summary {
+		s.summary = newTrafficSummary()
+		observers = append(observers, s.summary.observe)
+	}
+	if cfg.RequestID {
+		handler = requestIDHandler{next: handler}
+	}
+	if cfg.OTLPEndpoint != "" {
+		service := cfg.TraceService
+		if service == "" {
+			service = "static-server"
+		}
+		s.spans = newSpanExporter(cfg.OTLPEndpoint, service)
+		handler = traceHandler{exporter: s.spans, next: handler}
+	}
+	if len(observers) > 0 {
+		handler = observeHandler{observers: observers, geo: geo, anonymize: cfg.AnonymizeIP, next: handler}
+	}
+	s.adminRoot = s.admin
+	if audit != nil && cfg.SeparateAdmin {
+		s.adminRoot = observeHandler{observers: []func(*requestRecord){audit.observe}, anonymize: cfg.AnonymizeIP, next: s.admin}
+	}
+	if stats != nil {
+		handler = stats.track(handler)
+	}
+	s.handler = handler
+	return s, nil
+}
+
+// ServeHTTP serves r as the Config says.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on every
+// request but those for the admin endpoints is answered with 503 Service
+// Unavailable and the maintenance page.
+func (s *Server) SetMaintenance(on bool) {
+	s.maint.set(on)
+}
+
+// Maintenance reports whether maintenance mode is on.
+func (s *Server) Maintenance() bool {
+	return s.maint.on.Load()
+}
+
+// Admin returns the handler of the admin endpoints, such as /metrics.
+// Unless SeparateAdmin was set they are also served by s itself.
+func (s *Server) Admin() http.Handler {
+	return s.adminRoot
+}
+
+// Close ends the streams s is serving, such as live reload, and stops
+// watching dirs, so that http.Server.Shutdown is not held up by them.
+// It also exports any spans still queued and saves the hit counts.
+// Close may be called again once requests have drained, to export and
+// save what they added.
+func (s *Server) Close() error {
+	s.once.Do(func() {
+		for _, f := range s.closers {
+			f()
+		}
+	})
+	if s.spans != nil {
+		s.spans.flush()
+	}
+	if s.hits != nil {
+		if err := s.hits.save(); err != nil {
+			return fmt.Errorf("hits: %v", err)
+		}
+	}
+	return nil
+}
+
+// WriteSummary writes a summary of the traffic served to w, if the
+// Config asked for one.
+func (s *Server) WriteSummary(w io.Writer) {
+	if s.summary != nil {
+		s.summary.print(w)
+	}
+}
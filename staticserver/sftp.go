@@ -0,0 +1,515 @@
+package staticserver
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTP says which directory of another machine to serve over SFTP. The
+// ssh command connects, with the keys, agent and configuration it
+// would use for the host, and the host needs nothing but the SFTP
+// server that comes with its SSH server.
+type SFTP struct {
+	Host    string   // [user@]host, or an alias from the ssh configuration
+	Path    string   // the directory to serve, relative to the home directory unless absolute
+	SSHArgs []string // more arguments to ssh, such as -p 2222
+	Cache   ObjectCache
+}
+
+// NewSFTPFS connects to the host and returns an fs.FS of the files in
+// the directory, to be set as the FS of a Config. Files are read as
+// they are served, so an ObjectCache is worth setting.
+func NewSFTPFS(s SFTP) (fs.FS, error) {
+	if s.Host == "" {
+		return nil, errors.New("sftp: no host")
+	}
+	store := &sftpStore{host: s.Host, args: s.SSHArgs}
+	c, err := store.client()
+	if err != nil {
+		return nil, err
+	}
+	// Resolve the directory now, so that it does not move if the home
+	// directory is different when reconnecting.
+	if store.root, err = c.realpath(cmp.Or(s.Path, ".")); err != nil {
+		return nil, fmt.Errorf("sftp: %s:%s: %v", s.Host, s.Path, err)
+	}
+	return newObjectFS(store, "", s.Cache)
+}
+
+// The SFTP version 3 packets used, from draft-ietf-secsh-filexfer-02.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+
+	sftpOK         = 0
+	sftpEOF        = 1
+	sftpNoSuchFile = 2
+	sftpDenied     = 3
+
+	sftpReadSize = 32 << 10 // the largest read every server allows
+)
+
+// sftpStore is the objectStore of a directory served over SFTP, keys
+// being paths under root.
+type sftpStore struct {
+	host string
+	args []string
+	root string
+
+	mu sync.Mutex
+	c  *sftpClient // the connection, replaced when it fails
+}
+
+// client returns the connection, connecting again if it has failed.
+func (s *sftpStore) client() (*sftpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.c != nil && s.c.err() == nil {
+		return s.c, nil
+	}
+	c, err := dialSFTP(s.host, s.args)
+	if err != nil {
+		return nil, err
+	}
+	s.c = c
+	return c, nil
+}
+
+func (s *sftpStore) remote(key string) string {
+	return path.Join(s.root, key)
+}
+
+func (s *sftpStore) stat(key string) (objectInfo, error) {
+	c, err := s.client()
+	if err != nil {
+		return objectInfo{}, err
+	}
+	a, err := c.stat(s.remote(key))
+	if err != nil {
+		return objectInfo{}, err
+	}
+	if !a.regular() {
+		return objectInfo{}, fs.ErrNotExist // directories are listed instead
+	}
+	return objectInfo{key: key, size: a.size, modTime: a.modTime()}, nil
+}
+
+func (s *sftpStore) list(prefix string) (objects []objectInfo, prefixes []string, err error) {
+	c, err := s.client()
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := s.remote(prefix)
+	names, err := c.readDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, n := range names {
+		if n.name == "." || n.name == ".." {
+			continue
+		}
+		a := n.attrs
+		if a.symlink() {
+			if a, err = c.stat(path.Join(dir, n.name)); err != nil {
+				continue // dangling
+			}
+		}
+		switch {
+		case a.dir():
+			prefixes = append(prefixes, prefix+n.name+"/")
+		case a.regular():
+			objects = append(objects, objectInfo{key: prefix + n.name, size: a.size, modTime: a.modTime()})
+		}
+	}
+	return objects, prefixes, nil
+}
+
+func (s *sftpStore) get(key string, off int64) (io.ReadCloser, error) {
+	c, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	h, err := c.handle(sftpOpen, func(b *sftpPacket) {
+		b.putString(s.remote(key))
+		b.putUint32(1) // SSH_FXF_READ
+		b.putUint32(0) // no attributes
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReader{c: c, handle: h, off: off}, nil
+}
+
+// sftpReader reads an open remote file from off.
+type sftpReader struct {
+	c      *sftpClient
+	handle string
+	off    int64
+	buf    []byte
+	eof    bool
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 && !r.eof {
+		resp, err := r.c.call(sftpRead, func(b *sftpPacket) {
+			b.putString(r.handle)
+			b.putUint64(uint64(r.off))
+			b.putUint32(sftpReadSize)
+		})
+		if errors.Is(err, io.EOF) {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		} else if resp.typ != sftpData {
+			return 0, errors.New("sftp: unexpected response to read")
+		} else {
+			r.buf = resp.bytes()
+			r.off += int64(len(r.buf))
+		}
+	}
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *sftpReader) Close() error {
+	_, err := r.c.call(sftpClose, func(b *sftpPacket) { b.putString(r.handle) })
+	return err
+}
+
+// sftpClient is an SFTP session over an ssh process. Calls may be made
+// at once from many goroutines; responses are matched to them by id.
+type sftpClient struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+
+	wmu sync.Mutex // serializes writes to in
+
+	mu      sync.Mutex // guards the fields below
+	next    uint32
+	waiting map[uint32]chan *sftpPacket
+	failed  error // why the session ended, if it has
+}
+
+// dialSFTP starts ssh with the sftp subsystem on host, and starts the
+// session.
+func dialSFTP(host string, args []string) (*sftpClient, error) {
+	args = append(append([]string{"-o", "BatchMode=yes"}, args...), "-s", host, "sftp")
+	cmd := exec.Command("ssh", args...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &sftpClient{cmd: cmd, in: in, waiting: map[uint32]chan *sftpPacket{}}
+	r := bufio.NewReader(out)
+	init := &sftpPacket{}
+	init.putByte(sftpInit)
+	init.putUint32(3)
+	if _, err := in.Write(init.frame()); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("sftp: %s: %v", host, err)
+	}
+	resp, err := readSFTPPacket(r)
+	if err != nil || resp.typ != sftpVersion {
+		in.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("sftp: %s: %s", host, cmp.Or(strings.TrimSpace(stderr.String()), "no SFTP server"))
+	}
+	go c.receive(r)
+	return c, nil
+}
+
+// receive hands each response to the call waiting for it, until the
+// session ends.
+func (c *sftpClient) receive(r *bufio.Reader) {
+	for {
+		p, err := readSFTPPacket(r)
+		c.mu.Lock()
+		if err != nil {
+			c.failed = fmt.Errorf("sftp: connection lost: %v", err)
+			for id, ch := range c.waiting {
+				close(ch)
+				delete(c.waiting, id)
+			}
+			c.mu.Unlock()
+			c.in.Close()
+			c.cmd.Wait()
+			return
+		}
+		if ch, ok := c.waiting[p.id]; ok {
+			ch <- p
+			delete(c.waiting, p.id)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// err returns why the session ended, or nil if it has not.
+func (c *sftpClient) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
+// call sends a request of typ, its fields after the id written by
+// fill, and returns the response. A status other than OK is returned
+// as an error, io.EOF for end of file.
+func (c *sftpClient) call(typ byte, fill func(*sftpPacket)) (*sftpPacket, error) {
+	req := &sftpPacket{}
+	req.putByte(typ)
+	c.mu.Lock()
+	if c.failed != nil {
+		c.mu.Unlock()
+		return nil, c.failed
+	}
+	c.next++
+	id := c.next
+	req.putUint32(id)
+	fill(req)
+	ch := make(chan *sftpPacket, 1)
+	c.waiting[id] = ch
+	c.mu.Unlock()
+	// Not under mu, which receive needs to make room for the request
+	// by reading responses.
+	c.wmu.Lock()
+	_, err := c.in.Write(req.frame())
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.waiting, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	resp, ok := <-ch
+	if !ok {
+		return nil, c.err()
+	}
+	if resp.typ != sftpStatus {
+		return resp, nil
+	}
+	switch code := resp.uint32(); code {
+	case sftpOK:
+		return resp, nil
+	case sftpEOF:
+		return nil, io.EOF
+	case sftpNoSuchFile:
+		return nil, fs.ErrNotExist
+	case sftpDenied:
+		return nil, fs.ErrPermission
+	default:
+		return nil, fmt.Errorf("sftp: %s (status %d)", resp.text(), code)
+	}
+}
+
+// handle makes a call that opens a file or directory, returning its
+// handle.
+func (c *sftpClient) handle(typ byte, fill func(*sftpPacket)) (string, error) {
+	resp, err := c.call(typ, fill)
+	if err != nil {
+		return "", err
+	}
+	if resp.typ != sftpHandle {
+		return "", errors.New("sftp: unexpected response to open")
+	}
+	return resp.text(), nil
+}
+
+func (c *sftpClient) realpath(name string) (string, error) {
+	resp, err := c.call(sftpRealpath, func(b *sftpPacket) { b.putString(name) })
+	if err != nil {
+		return "", err
+	}
+	if resp.typ != sftpName || resp.uint32() < 1 {
+		return "", errors.New("sftp: unexpected response to realpath")
+	}
+	return resp.text(), nil
+}
+
+func (c *sftpClient) stat(name string) (sftpFileAttrs, error) {
+	resp, err := c.call(sftpStat, func(b *sftpPacket) { b.putString(name) })
+	if err != nil {
+		return sftpFileAttrs{}, err
+	}
+	if resp.typ != sftpAttrs {
+		return sftpFileAttrs{}, errors.New("sftp: unexpected response to stat")
+	}
+	return resp.attrs(), nil
+}
+
+// sftpEntry is an entry of a directory.
+type sftpEntry struct {
+	name  string
+	attrs sftpFileAttrs
+}
+
+// readDir returns the entries of the directory.
+func (c *sftpClient) readDir(dir string) ([]sftpEntry, error) {
+	h, err := c.handle(sftpOpendir, func(b *sftpPacket) { b.putString(dir) })
+	if err != nil {
+		return nil, err
+	}
+	defer c.call(sftpClose, func(b *sftpPacket) { b.putString(h) })
+	var names []sftpEntry
+	for {
+		resp, err := c.call(sftpReaddir, func(b *sftpPacket) { b.putString(h) })
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.typ != sftpName {
+			return nil, errors.New("sftp: unexpected response to readdir")
+		}
+		for n := resp.uint32(); n > 0 && resp.err == nil; n-- {
+			name := resp.text()
+			resp.text() // the long name, as ls -l prints it
+			names = append(names, sftpEntry{name: name, attrs: resp.attrs()})
+		}
+		if resp.err != nil {
+			return nil, resp.err
+		}
+	}
+}
+
+// sftpFileAttrs is the attributes of a file that are used.
+type sftpFileAttrs struct {
+	size  int64
+	perm  uint32 // the st_mode, with the type of the file
+	mtime uint32
+}
+
+func (a sftpFileAttrs) dir() bool          { return a.perm&0o170000 == 0o040000 }
+func (a sftpFileAttrs) regular() bool      { return a.perm&0o170000 == 0o100000 }
+func (a sftpFileAttrs) symlink() bool      { return a.perm&0o170000 == 0o120000 }
+func (a sftpFileAttrs) modTime() time.Time { return time.Unix(int64(a.mtime), 0) }
+
+// sftpPacket is a packet being built, or one received and being read
+// from the start of its fields. A field that is cut short sets err.
+type sftpPacket struct {
+	typ byte
+	id  uint32
+	b   []byte
+	err error
+}
+
+// readSFTPPacket reads a packet and its type and id.
+func readSFTPPacket(r io.Reader) (*sftpPacket, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size < 1 || size > 1<<20 {
+		return nil, fmt.Errorf("bad packet length %d", size)
+	}
+	p := &sftpPacket{b: make([]byte, size)}
+	if _, err := io.ReadFull(r, p.b); err != nil {
+		return nil, err
+	}
+	p.typ, p.b = p.b[0], p.b[1:]
+	if p.typ != sftpVersion {
+		p.id = p.uint32()
+	}
+	return p, p.err
+}
+
+func (p *sftpPacket) putByte(v byte)     { p.b = append(p.b, v) }
+func (p *sftpPacket) putUint32(v uint32) { p.b = binary.BigEndian.AppendUint32(p.b, v) }
+func (p *sftpPacket) putUint64(v uint64) { p.b = binary.BigEndian.AppendUint64(p.b, v) }
+
+func (p *sftpPacket) putString(s string) {
+	p.putUint32(uint32(len(s)))
+	p.b = append(p.b, s...)
+}
+
+// frame returns the packet with its length before it.
+func (p *sftpPacket) frame() []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(p.b))), p.b...)
+}
+
+// take returns the next n bytes of a received packet.
+func (p *sftpPacket) take(n int) []byte {
+	if p.err != nil || n > len(p.b) {
+		p.err = errors.New("sftp: packet cut short")
+		return make([]byte, n)
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *sftpPacket) uint32() uint32 { return binary.BigEndian.Uint32(p.take(4)) }
+func (p *sftpPacket) uint64() uint64 { return binary.BigEndian.Uint64(p.take(8)) }
+
+func (p *sftpPacket) bytes() []byte {
+	n := p.uint32()
+	if int64(n) > int64(len(p.b)) {
+		p.err = errors.New("sftp: packet cut short")
+		return nil
+	}
+	return p.take(int(n))
+}
+
+func (p *sftpPacket) text() string { return string(p.bytes()) }
+
+// attrs reads an ATTRS structure.
+func (p *sftpPacket) attrs() sftpFileAttrs {
+	var a sftpFileAttrs
+	flags := p.uint32()
+	if flags&0x1 != 0 { // SSH_FILEXFER_ATTR_SIZE
+		a.size = int64(p.uint64())
+	}
+	if flags&0x2 != 0 { // SSH_FILEXFER_ATTR_UIDGID
+		p.take(8)
+	}
+	if flags&0x4 != 0 { // SSH_FILEXFER_ATTR_PERMISSIONS
+		a.perm = p.uint32()
+	}
+	if flags&0x8 != 0 { // SSH_FILEXFER_ATTR_ACMODTIME
+		p.uint32()
+		a.mtime = p.uint32()
+	}
+	if flags&0x80000000 != 0 { // SSH_FILEXFER_ATTR_EXTENDED
+		for n := p.uint32(); n > 0 && p.err == nil; n-- {
+			p.bytes()
+			p.bytes()
+		}
+	}
+	return a
+}
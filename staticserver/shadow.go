@@ -0,0 +1,111 @@
+package staticserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maxShadowBody is the largest request body copied to the shadow;
+	// requests with larger bodies, or of unknown length, are not.
+	maxShadowBody = 1 << 20
+	// maxShadowRequests bounds the copies in flight, past which more are
+	// dropped rather than held for a slow shadow.
+	maxShadowRequests = 64
+)
+
+// shadowClient sends the copies of requests, following no redirects.
+var shadowClient = &http.Client{
+	Timeout:       30 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	Transport:     &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: maxShadowRequests, IdleConnTimeout: 90 * time.Second, DisableCompression: true},
+}
+
+// hopHeaders are the headers of a connection rather than of a request.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// shadowHandler sends a copy of a share of the requests it serves to a
+// second backend, in the background and ignoring its responses, so that
+// a new origin can be tried against real traffic before it takes over.
+// The copies are sent as the reverse proxy sends requests, with the
+// X-Forwarded-* headers of the client.
+type shadowHandler struct {
+	target *url.URL
+	rate   float64 // the share of requests to copy, from 0 to 1
+	slots  chan struct{}
+	next   http.Handler
+}
+
+func newShadowHandler(target *url.URL, rate float64, next http.Handler) shadowHandler {
+	return shadowHandler{target: target, rate: rate, slots: make(chan struct{}, maxShadowRequests), next: next}
+}
+
+// ServeHTTP serves r with next, copying it to the shadow if it is
+// sampled.
+func (h shadowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rand.Float64() >= h.rate || r.Header.Get("Upgrade") != "" || r.ContentLength > maxShadowBody || r.ContentLength < 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		debugf("shadow: dropping %s %s, %d copies in flight", r.Method, r.URL.Path, maxShadowRequests)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	var body []byte
+	if r.ContentLength > 0 {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, r.ContentLength)); err != nil {
+			<-h.slots
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	out := h.request(r, body)
+	go func() {
+		defer func() { <-h.slots }()
+		resp, err := shadowClient.Do(out)
+		if err != nil {
+			debugf("shadow: %s %s: %v", out.Method, out.URL.Path, err)
+			return
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		debugf("shadow: %s %s: %d", out.Method, out.URL.Path, resp.StatusCode)
+	}()
+	h.next.ServeHTTP(w, r)
+}
+
+// request returns the copy of r, with the body given, to send to the
+// shadow. It outlives r, so it has a context of its own.
+func (h shadowHandler) request(r *http.Request, body []byte) *http.Request {
+	out := r.Clone(context.Background())
+	out.RequestURI = ""
+	out.Close = false
+	out.Body, out.GetBody = nil, nil
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	for _, k := range strings.Split(r.Header.Get("Connection"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			out.Header.Del(k)
+		}
+	}
+	for _, k := range hopHeaders {
+		out.Header.Del(k)
+	}
+	pr := &httputil.ProxyRequest{In: r, Out: out}
+	pr.SetURL(h.target)
+	pr.SetXForwarded()
+	return out
+}
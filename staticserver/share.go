@@ -0,0 +1,97 @@
+package staticserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sharePrefix is the path share links are served under.
+const sharePrefix = "/_share/"
+
+// ShareLink returns the path of a link, signed with key, that serves the
+// file at p, or if p ends in a slash the dir and everything under it,
+// until expires. Its requests are let past the passwords and accounts
+// of the dirs they are in.
+func ShareLink(key []byte, p string, expires time.Time) string {
+	p = "/" + strings.TrimPrefix(p, "/")
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return (&url.URL{Path: sharePrefix + exp + "/" + shareSig(key, exp, p) + p}).EscapedPath()
+}
+
+// shareSig returns the signature of the link to p that expires at exp.
+func shareSig(key []byte, exp, p string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(exp + "\x00" + p))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sharedKey marks the context of the requests of valid share links.
+type sharedKey struct{}
+
+// isShared reports whether r came by a valid share link.
+func isShared(r *http.Request) bool {
+	shared, _ := r.Context().Value(sharedKey{}).(bool)
+	return shared
+}
+
+// shareHandler serves the requests of share links with next, as
+// requests for the paths they share, and refuses those whose signature
+// is wrong or whose time is up.
+type shareHandler struct {
+	key  []byte
+	next http.Handler
+}
+
+// ServeHTTP serves r, which may be a share link, with h.next.
+func (h shareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, sharePrefix)
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	exp, rest, _ := strings.Cut(rest, "/")
+	sig, p, ok := strings.Cut(rest, "/")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// A link for a dir must not reach out of it through its dot dots.
+	p = normalizePath("/" + p)
+	// The link is for p, for the dir p names without its slash or for
+	// one of the dirs it is in.
+	valid := !strings.HasSuffix(p, "/") && hmac.Equal([]byte(sig), []byte(shareSig(h.key, exp, p+"/")))
+	for scope := p; !valid; {
+		if hmac.Equal([]byte(sig), []byte(shareSig(h.key, exp, scope))) {
+			valid = true
+			break
+		}
+		if scope == "/" {
+			break
+		}
+		if scope = path.Dir(strings.TrimSuffix(scope, "/")); scope != "/" {
+			scope += "/"
+		}
+	}
+	switch {
+	case !valid:
+		debugf("share: bad signature for %s", p)
+		http.Error(w, "This link is not valid.", http.StatusForbidden)
+		return
+	case time.Now().Unix() > expires:
+		debugf("share: link for %s expired %s", p, time.Unix(expires, 0).Format(time.RFC3339))
+		http.Error(w, "This link has expired.", http.StatusGone)
+		return
+	}
+	r2 := r.Clone(context.WithValue(r.Context(), sharedKey{}, true))
+	r2.URL.Path, r2.URL.RawPath = p, ""
+	h.next.ServeHTTP(w, r2)
+}
@@ -0,0 +1,95 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareHandler(t *testing.T) {
+	key := []byte("the share key")
+	later := time.Now().Add(time.Hour)
+	file := ShareLink(key, "/reports/q3.pdf", later)
+	dir := ShareLink(key, "/reports/", later)
+	// sigOf returns the exp and sig elements of the link l.
+	sigOf := func(l string) string {
+		parts := strings.SplitN(strings.TrimPrefix(l, sharePrefix), "/", 3)
+		return parts[0] + "/" + parts[1]
+	}
+	exp := strconv.FormatInt(later.Unix(), 10)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		path   string // the path next serves, if it is reached
+	}{
+		{"file", file, http.StatusOK, "/reports/q3.pdf"},
+		{"not a link", "/reports/q3.pdf", http.StatusOK, "/reports/q3.pdf"},
+		{"file link for a sibling", sharePrefix + sigOf(file) + "/reports/q4.pdf", http.StatusForbidden, ""},
+		{"file link for its dir", sharePrefix + sigOf(file) + "/reports/", http.StatusForbidden, ""},
+		{"dir", dir, http.StatusOK, "/reports/"},
+		{"dir without its slash", sharePrefix + sigOf(dir) + "/reports", http.StatusOK, "/reports"},
+		{"file in dir", sharePrefix + sigOf(dir) + "/reports/q3.pdf", http.StatusOK, "/reports/q3.pdf"},
+		{"file deep in dir", sharePrefix + sigOf(dir) + "/reports/2024/q1.pdf", http.StatusOK, "/reports/2024/q1.pdf"},
+		{"dir with the same prefix", sharePrefix + sigOf(dir) + "/reports2/x.pdf", http.StatusForbidden, ""},
+		{"dot dot out of dir", sharePrefix + sigOf(dir) + "/reports/../secret.txt", http.StatusForbidden, ""},
+		{"dot dot to the root", sharePrefix + sigOf(dir) + "/reports/..", http.StatusForbidden, ""},
+		{"encoded dot dot", sharePrefix + sigOf(dir) + "/reports/%2e%2e/secret.txt", http.StatusForbidden, ""},
+		{"backslash dot dot", sharePrefix + sigOf(dir) + `/reports/..\secret.txt`, http.StatusForbidden, ""},
+		{"tampered sig", sharePrefix + exp + "/AAAAAAAAAAAAAAAAAAAAAA/reports/q3.pdf", http.StatusForbidden, ""},
+		{"tampered expiry", sharePrefix + strconv.FormatInt(later.Unix()+3600, 10) + "/" + strings.Split(sigOf(file), "/")[1] + "/reports/q3.pdf", http.StatusForbidden, ""},
+		{"other key", ShareLink([]byte("another key"), "/reports/q3.pdf", later), http.StatusForbidden, ""},
+		{"expired", ShareLink(key, "/reports/q3.pdf", time.Now().Add(-time.Minute)), http.StatusGone, ""},
+		{"bad expiry", sharePrefix + "soon/sig/reports/q3.pdf", http.StatusNotFound, ""},
+		{"no path", sharePrefix + exp, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		var got string
+		var shared bool
+		h := shareHandler{key: key, next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, shared = r.URL.Path, isShared(r)
+		})}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.target, w.Code, tt.status)
+		}
+		if got != tt.path {
+			t.Errorf("%s: next served %q, want %q", tt.name, got, tt.path)
+		}
+		if want := tt.path != "" && strings.HasPrefix(tt.target, sharePrefix); shared != want {
+			t.Errorf("%s: shared = %v, want %v", tt.name, shared, want)
+		}
+	}
+}
+
+func TestShareSig(t *testing.T) {
+	key := []byte("the share key")
+	a := shareSig(key, "100", "/a")
+	tests := []struct {
+		key  string
+		exp  string
+		p    string
+		same bool
+	}{
+		{"the share key", "100", "/a", true},
+		{"the share key", "101", "/a", false},
+		{"the share key", "100", "/b", false},
+		{"the share key", "100", "/a/", false},
+		{"the share kez", "100", "/a", false},
+		// The separator keeps the expiry and path from running together.
+		{"the share key", "10", "0/a", false},
+	}
+	for _, tt := range tests {
+		if got := shareSig([]byte(tt.key), tt.exp, tt.p); (got == a) != tt.same {
+			t.Errorf("shareSig(%q, %q, %q) == shareSig(key, 100, /a) is %v, want %v", tt.key, tt.exp, tt.p, got == a, tt.same)
+		}
+	}
+	if len(a) != 22 {
+		t.Errorf("signature %q is %d characters, want the 22 of 16 bytes", a, len(a))
+	}
+}
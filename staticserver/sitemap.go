@@ -0,0 +1,200 @@
+package staticserver
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sitemapPath is where the sitemap is served, unless the site has one.
+const sitemapPath = "/sitemap.xml"
+
+// maxSitemapURLs is the most URLs a sitemap may list.
+const maxSitemapURLs = 50000
+
+// sitemapExts are the extensions of the files a sitemap lists.
+var sitemapExts = map[string]bool{".html": true, ".htm": true, ".pdf": true}
+
+// sitemapURL is a page a sitemap lists.
+type sitemapURL struct {
+	path    string
+	modTime time.Time
+}
+
+// sitemap serves a sitemap.xml of the pages of an FS: its HTML files,
+// or the dirs they are the index.html of, and its PDFs, leaving out dot
+// files and the paths that match an exclude pattern. It is made again
+// when the files change. The URLs are made absolute with base, or with
+// the scheme and host of each request if base is nil. A site that has a
+// sitemap.xml of its own is served that instead, by files.
+type sitemap struct {
+	fsys    fs.FS
+	exclude []string
+	base    *url.URL
+	files   http.Handler
+
+	mu   sync.RWMutex
+	urls []sitemapURL
+}
+
+func newSitemap(fsys fs.FS, exclude []string, base string) (*sitemap, error) {
+	m := &sitemap{fsys: fsys, exclude: exclude}
+	for _, pattern := range exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if base != "" {
+		u, err := parseUpstream(base)
+		if err != nil {
+			return nil, err
+		}
+		m.base = u
+	}
+	m.build()
+	return m, nil
+}
+
+// excluded reports whether the URL path p matches an exclude pattern.
+func (m *sitemap) excluded(p string) bool {
+	for _, pattern := range m.exclude {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, strings.TrimSuffix(p, "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// build lists the pages of the FS.
+func (m *sitemap) build() {
+	var urls []sitemapURL
+	fs.WalkDir(m.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		urlPath := "/" + p
+		if p == "." {
+			urlPath = "/"
+		} else if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != "." && m.excluded(urlPath+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !sitemapExts[strings.ToLower(path.Ext(p))] {
+			return nil
+		}
+		if base := path.Base(urlPath); base == "index.html" || base == "index.htm" {
+			urlPath = strings.TrimSuffix(urlPath, base)
+		}
+		if m.excluded(urlPath) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		urls = append(urls, sitemapURL{urlPath, fi.ModTime()})
+		return nil
+	})
+	sort.Slice(urls, func(i, j int) bool { return urls[i].path < urls[j].path })
+	if len(urls) > maxSitemapURLs {
+		infof("warning: sitemap: listing the first %d of %d pages", maxSitemapURLs, len(urls))
+		urls = urls[:maxSitemapURLs]
+	}
+	m.mu.Lock()
+	m.urls = urls
+	m.mu.Unlock()
+	debugf("sitemap: listed %d pages", len(urls))
+}
+
+// changed makes the sitemap again if any of changes is in dir.
+func (m *sitemap) changed(dir string) func([]fileChange) {
+	return func(changes []fileChange) {
+		for _, c := range changes {
+			if c.Dir == dir && !isDotF(c.Path) {
+				m.build()
+				return
+			}
+		}
+	}
+}
+
+// requestBase returns the URL of the root of the site r was made to,
+// with the scheme a proxy in front may have taken it in with.
+func requestBase(r *http.Request) *url.URL {
+	u := &url.URL{Scheme: "http", Host: r.Host, Path: "/"}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		u.Scheme = "https"
+	}
+	return u
+}
+
+// ServeHTTP serves the sitemap.
+func (m *sitemap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := fs.Stat(m.fsys, strings.TrimPrefix(sitemapPath, "/")); err == nil {
+		m.files.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := m.base
+	if base == nil {
+		base = requestBase(r)
+	}
+	type entry struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+	set := struct {
+		XMLName xml.Name `xml:"urlset"`
+		NS      string   `xml:"xmlns,attr"`
+		URLs    []entry  `xml:"url"`
+	}{NS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	m.mu.RLock()
+	for _, u := range m.urls {
+		loc := *base
+		loc.Path = strings.TrimSuffix(base.Path, "/") + u.path
+		e := entry{Loc: loc.String()}
+		if !u.modTime.IsZero() {
+			e.LastMod = u.modTime.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, e)
+	}
+	m.mu.RUnlock()
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	enc := xml.NewEncoder(&out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		infof("warning: sitemap: %v", err)
+		http.Error(w, "Error rendering sitemap", http.StatusInternalServerError)
+		return
+	}
+	out.WriteString("\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Add("Vary", "Host")
+	// Pages that are removed leave no newer time, so there is no
+	// Last-Modified.
+	http.ServeContent(w, r, sitemapPath, time.Time{}, bytes.NewReader(out.Bytes()))
+}
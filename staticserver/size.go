@@ -0,0 +1,57 @@
+package staticserver
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte count such as 512, 64K, 10MB or 2GiB.
+// Units are powers of 1024 regardless of how they are spelled.
+func ParseSize(s string) (int64, error) {
+	invalid := fmt.Errorf("invalid size %q", s)
+	num, hadB := strings.CutSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	num, hadI := strings.CutSuffix(num, "I")
+	if hadI && !hadB {
+		return 0, invalid
+	}
+	mult := int64(1)
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			num = num[:n-1]
+		}
+	}
+	if hadI && mult == 1 {
+		return 0, invalid
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, invalid
+	}
+	return n * mult, nil
+}
+
+// formatSize returns n as a short human readable size such as 1.5M.
+func formatSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	f, i := float64(n)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(f, 'f', 1, 64) + units[i:i+1]
+}
@@ -0,0 +1,40 @@
+package staticserver
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"512", 512, true},
+		{"512B", 512, true},
+		{"64K", 64 << 10, true},
+		{"64k", 64 << 10, true},
+		{"10MB", 10 << 20, true},
+		{"2GiB", 2 << 30, true},
+		{"3T", 3 << 40, true},
+		{" 1 M ", 1 << 20, true},
+		{"0", 0, true},
+		{"8388607T", 8388607 << 40, true},
+		{"8388608T", 0, false},
+		{"99999999999T", 0, false},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775808", 0, false},
+		{"10IIB", 0, false},
+		{"10BB", 0, false},
+		{"10IB", 0, false},
+		{"10KI", 0, false},
+		{"10X", 0, false},
+		{"-1K", 0, false},
+		{"K", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
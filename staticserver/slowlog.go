@@ -0,0 +1,18 @@
+package staticserver
+
+import (
+	"log"
+	"time"
+)
+
+// slowLog returns an observer that logs a warning for every request that
+// takes longer than threshold to serve.
+func slowLog(threshold time.Duration) func(*requestRecord) {
+	return func(rr *requestRecord) {
+		if rr.Duration <= threshold {
+			return
+		}
+		log.Printf("warning: slow request: %s %s took %s for %d bytes, status %d, client %s",
+			rr.Method, rr.Path, rr.Duration.Round(time.Millisecond), rr.Bytes, rr.Status, rr.IP)
+	}
+}
@@ -0,0 +1,833 @@
+package staticserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLiteFS is an fs.FS of the files stored as rows of a table in a
+// SQLite database, with columns path and content and, optionally, mtime
+// in Unix seconds or as text such as datetime('now') returns, and
+// content_type. The database file is read directly, without SQLite, so
+// it must be UTF-8 and have no uncommitted write-ahead log. When the
+// file is replaced, such as by renaming a new one over it, or changed,
+// the files are read again, so a whole site can be swapped at once.
+type SQLiteFS struct {
+	name, table string
+
+	mu  sync.RWMutex
+	idx *sqliteIndex
+	bad sqliteStamp // the version of the file that could not be read, if any
+}
+
+// OpenSQLite opens the table, files if it is empty, in the database
+// file name.
+func OpenSQLite(name, table string) (*SQLiteFS, error) {
+	if table == "" {
+		table = "files"
+	}
+	idx, err := openSQLiteIndex(name, table)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteFS{name: name, table: table, idx: idx}, nil
+}
+
+// Open opens the named file. The database file it is read from stays
+// open until it is closed, even if the files are read again meanwhile.
+func (s *SQLiteFS) Open(name string) (fs.File, error) {
+	idx := s.current()
+	defer idx.db.release()
+	return idx.tree.Open(name)
+}
+
+// ContentType returns the content_type stored with the named file, if
+// any.
+func (s *SQLiteFS) ContentType(name string) string {
+	idx := s.current()
+	defer idx.db.release()
+	return idx.types[name]
+}
+
+// Close closes the database file, once the files open are.
+func (s *SQLiteFS) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idx.db.retire()
+}
+
+// current returns the index of the files, reading them again first if
+// the database file has changed since, with a reference to its database
+// that the caller releases.
+func (s *SQLiteFS) current() *sqliteIndex {
+	s.mu.RLock()
+	idx, bad := s.idx, s.bad
+	idx.db.ref()
+	s.mu.RUnlock()
+	stamp, err := idx.db.stampOf(s.name)
+	if err != nil || stamp.same(idx.db.stamp) || stamp.same(bad) {
+		return idx
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idx != idx {
+		// Read again by another request.
+		idx.db.release()
+		s.idx.db.ref()
+		return s.idx
+	}
+	next, err := openSQLiteIndex(s.name, s.table)
+	if err != nil {
+		infof("warning: %v; still serving the files read before", err)
+		s.bad = stamp
+		return idx
+	}
+	s.idx = next
+	idx.db.retire()
+	idx.db.release()
+	next.db.ref()
+	infof("sqlite: read %d files from %s again", len(next.types), s.name)
+	return next
+}
+
+// sqliteIndex is the files of a table in one version of a database.
+type sqliteIndex struct {
+	db    *sqliteDB
+	tree  *memTree
+	types map[string]string // the content types of the files, "" if none was stored
+}
+
+// openSQLiteIndex reads the rows of the table in the database file.
+func openSQLiteIndex(name, table string) (*sqliteIndex, error) {
+	db, err := openSQLiteDB(name)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := db.index(table)
+	if err != nil {
+		db.f.Close()
+		return nil, fmt.Errorf("sqlite: %s: %v", name, err)
+	}
+	return idx, nil
+}
+
+// index reads the rows of the table into an index.
+func (db *sqliteDB) index(table string) (*sqliteIndex, error) {
+	root, cols, err := db.findTable(table)
+	if err != nil {
+		return nil, err
+	}
+	col := func(name string) int {
+		for i, c := range cols {
+			if strings.EqualFold(c.name, name) {
+				return i
+			}
+		}
+		return -1
+	}
+	pathCol, contentCol, mtimeCol, typeCol := col("path"), col("content"), col("mtime"), col("content_type")
+	if pathCol < 0 || contentCol < 0 {
+		return nil, fmt.Errorf("table %s has no path or content column", table)
+	}
+	idx := &sqliteIndex{db: db, types: map[string]string{}}
+	idx.tree = newMemTree(func(e *memEntry) (io.ReadSeeker, error) {
+		b := e.src.(sqliteBlob)
+		db.ref()
+		return &sqliteReader{SectionReader: io.NewSectionReader(b.p, b.off, e.size), db: db}, nil
+	})
+	err = db.scan(root, 0, func(rowid int64, p *sqlitePayload) error {
+		vals, err := p.record()
+		if err != nil {
+			return err
+		}
+		value := func(i int) sqliteValue {
+			if i < 0 || i >= len(vals) {
+				return sqliteValue{} // NULL, as in rows written before the column was added
+			}
+			return vals[i]
+		}
+		name, err := p.text(value(pathCol))
+		if err != nil {
+			return err
+		}
+		name = path.Clean(strings.TrimPrefix(name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			return nil
+		}
+		content := value(contentCol)
+		if !content.isBlob() && !content.isText() && content.typ != 0 {
+			return fmt.Errorf("the content of %s is not a blob", name)
+		}
+		var modTime time.Time
+		switch {
+		case mtimeCol >= 0 && cols[mtimeCol].rowid:
+			modTime = time.Unix(rowid, 0)
+		case mtimeCol >= 0:
+			modTime = p.time(value(mtimeCol))
+		}
+		ctype := ""
+		if typeCol >= 0 {
+			ctype, _ = p.text(value(typeCol))
+		}
+		idx.tree.file(name, &memEntry{size: content.n, mode: 0o644, modTime: modTime, src: sqliteBlob{p, content.off}})
+		idx.types[name] = ctype
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.tree.entries {
+		if e.IsDir() {
+			e.modTime = db.stamp.fi.ModTime()
+		}
+	}
+	return idx, nil
+}
+
+// sqliteBlob is where the content of a file is, in the payload of its
+// row.
+type sqliteBlob struct {
+	p   *sqlitePayload
+	off int64
+}
+
+// sqliteReader reads the content of an open file, holding a reference
+// to its database until it is closed.
+type sqliteReader struct {
+	*io.SectionReader
+	db   *sqliteDB
+	once sync.Once
+}
+
+func (r *sqliteReader) Close() error {
+	r.once.Do(r.db.release)
+	return nil
+}
+
+// sqliteDB is an open SQLite database file.
+type sqliteDB struct {
+	f        *os.File
+	stamp    sqliteStamp
+	pageSize int64
+	usable   int64 // the bytes of a page that are not reserved
+	pages    uint32
+
+	mu      sync.Mutex
+	refs    int  // the requests and open files reading f
+	retired bool // f is closed once refs is 0
+}
+
+// ref adds a reference to db.
+func (db *sqliteDB) ref() {
+	db.mu.Lock()
+	db.refs++
+	db.mu.Unlock()
+}
+
+// release drops a reference to db, closing its file if it is retired
+// and it was the last.
+func (db *sqliteDB) release() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.refs--; db.refs == 0 && db.retired {
+		db.f.Close()
+	}
+}
+
+// retire has the file of db closed once no reference to it is left.
+func (db *sqliteDB) retire() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.retired = true
+	if db.refs == 0 {
+		return db.f.Close()
+	}
+	return nil
+}
+
+// sqliteStamp identifies a version of a database file.
+type sqliteStamp struct {
+	fi      os.FileInfo
+	counter uint32 // the file change counter, bumped by each transaction
+}
+
+func (a sqliteStamp) same(b sqliteStamp) bool {
+	return a.fi != nil && b.fi != nil && os.SameFile(a.fi, b.fi) &&
+		a.fi.ModTime().Equal(b.fi.ModTime()) && a.fi.Size() == b.fi.Size() && a.counter == b.counter
+}
+
+// stampOf returns the stamp of the file now at name.
+func (db *sqliteDB) stampOf(name string) (sqliteStamp, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return sqliteStamp{}, err
+	}
+	st := sqliteStamp{fi: fi}
+	f := db.f
+	if !os.SameFile(fi, db.stamp.fi) {
+		if f, err = os.Open(name); err != nil {
+			return sqliteStamp{}, err
+		}
+		defer f.Close()
+	}
+	var b [4]byte
+	if _, err := f.ReadAt(b[:], 24); err != nil {
+		return sqliteStamp{}, err
+	}
+	st.counter = binary.BigEndian.Uint32(b[:])
+	return st, nil
+}
+
+var errSQLiteCorrupt = errors.New("the database is corrupt")
+
+// openSQLiteDB opens the database file and reads its header.
+func openSQLiteDB(name string) (*sqliteDB, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var h [100]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil || string(h[:16]) != "SQLite format 3\x00" {
+		f.Close()
+		return nil, fmt.Errorf("sqlite: %s is not a SQLite database", name)
+	}
+	if enc := binary.BigEndian.Uint32(h[56:]); enc != 1 && enc != 0 {
+		f.Close()
+		return nil, fmt.Errorf("sqlite: %s is not UTF-8", name)
+	}
+	db := &sqliteDB{f: f, stamp: sqliteStamp{fi: fi, counter: binary.BigEndian.Uint32(h[24:])}}
+	db.pageSize = int64(binary.BigEndian.Uint16(h[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int64(h[20])
+	if db.pageSize < 512 || db.usable < 480 {
+		f.Close()
+		return nil, fmt.Errorf("sqlite: %s: %v", name, errSQLiteCorrupt)
+	}
+	db.pages = uint32(fi.Size() / db.pageSize)
+	return db, nil
+}
+
+// page reads page n, counting from 1.
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 || n > db.pages {
+		return nil, errSQLiteCorrupt
+	}
+	b := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(b, int64(n-1)*db.pageSize); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// sqliteColumn is a column of a table as it is declared.
+type sqliteColumn struct {
+	name  string
+	rowid bool // declared INTEGER PRIMARY KEY, an alias of the rowid
+}
+
+// findTable returns the root page and the columns of the table, from
+// the schema table on page 1.
+func (db *sqliteDB) findTable(table string) (root uint32, cols []sqliteColumn, err error) {
+	err = db.scan(1, 0, func(_ int64, p *sqlitePayload) error {
+		vals, err := p.record()
+		if err != nil || len(vals) < 5 {
+			return err
+		}
+		typ, _ := p.text(vals[0])
+		name, _ := p.text(vals[1])
+		if typ != "table" || !strings.EqualFold(name, table) {
+			return nil
+		}
+		page, ok := p.int(vals[3])
+		sql, _ := p.text(vals[4])
+		if !ok || page <= 0 || page > math.MaxUint32 {
+			return errSQLiteCorrupt
+		}
+		var withoutRowid bool
+		if cols, withoutRowid = parseSQLiteTable(sql); withoutRowid {
+			return fmt.Errorf("table %s is WITHOUT ROWID, which is not supported", table)
+		}
+		root = uint32(page)
+		return nil
+	})
+	if err == nil && root == 0 {
+		err = fmt.Errorf("no table %s", table)
+	}
+	return root, cols, err
+}
+
+// sqliteToken is a token of SQL: a keyword or bare name, a quoted name,
+// a string, or a punctuation character.
+type sqliteToken struct {
+	text   string // unquoted
+	word   bool   // a keyword or name, bare or quoted
+	quoted bool   // a quoted name or a string
+}
+
+// is reports whether t is the bare keyword or punctuation s.
+func (t sqliteToken) is(s string) bool {
+	return !t.quoted && strings.EqualFold(t.text, s)
+}
+
+// tokenizeSQL splits sql into tokens, leaving out spaces and comments.
+func tokenizeSQL(sql string) []sqliteToken {
+	var toks []sqliteToken
+	isWord := func(c byte) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += 2 + end + 2
+			} else {
+				i = len(sql)
+			}
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			// A quote is doubled to stand for itself, except in [].
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			var b strings.Builder
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] != closer {
+					b.WriteByte(sql[j])
+					j++
+					continue
+				}
+				if closer != ']' && j+1 < len(sql) && sql[j+1] == closer {
+					b.WriteByte(closer)
+					j += 2
+					continue
+				}
+				break
+			}
+			toks = append(toks, sqliteToken{text: b.String(), word: c != '\'', quoted: true})
+			i = j + 1
+		case isWord(c):
+			j := i
+			for j < len(sql) && isWord(sql[j]) {
+				j++
+			}
+			toks = append(toks, sqliteToken{text: sql[i:j], word: true})
+			i = j
+		default:
+			toks = append(toks, sqliteToken{text: sql[i : i+1]})
+			i++
+		}
+	}
+	return toks
+}
+
+// sqliteConstraints are the keywords column constraints start with.
+var sqliteConstraints = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "NOT": true, "NULL": true, "UNIQUE": true, "CHECK": true,
+	"DEFAULT": true, "COLLATE": true, "REFERENCES": true, "GENERATED": true, "AS": true,
+}
+
+// parseSQLiteTable returns the columns a CREATE TABLE statement
+// declares, in order, and whether the table is WITHOUT ROWID.
+func parseSQLiteTable(sql string) (cols []sqliteColumn, withoutRowid bool) {
+	toks := tokenizeSQL(sql)
+	open := slices.IndexFunc(toks, func(t sqliteToken) bool { return t.is("(") })
+	if open < 0 {
+		return nil, false
+	}
+	// The definitions, split at the commas outside parentheses.
+	var defs [][]sqliteToken
+	depth, start, end := 0, open+1, len(toks)
+	for i := start; i < len(toks); i++ {
+		switch {
+		case toks[i].is("("):
+			depth++
+		case toks[i].is(")") && depth == 0:
+			end = i
+		case toks[i].is(")"):
+			depth--
+		case toks[i].is(",") && depth == 0:
+			defs = append(defs, toks[start:i])
+			start = i + 1
+		}
+		if end < len(toks) {
+			break
+		}
+	}
+	defs = append(defs, toks[start:end])
+	for i := end + 1; i+1 < len(toks); i++ {
+		if toks[i].is("WITHOUT") && toks[i+1].is("ROWID") {
+			withoutRowid = true
+		}
+	}
+
+	var key string            // the one column of a PRIMARY KEY table constraint
+	integer := map[int]bool{} // the columns of type INTEGER
+	for _, def := range defs {
+		if len(def) > 2 && def[0].is("CONSTRAINT") {
+			def = def[2:]
+		}
+		// A string is taken for a name here, as SQLite does.
+		if len(def) == 0 || !def[0].word && !def[0].quoted {
+			continue
+		}
+		switch {
+		case def[0].is("PRIMARY"):
+			var names []string
+			for _, t := range def[2:] {
+				if t.is(")") {
+					break
+				}
+				if t.word && !t.is("ASC") && !t.is("DESC") {
+					names = append(names, t.text)
+				}
+			}
+			if len(names) == 1 {
+				key = names[0]
+			}
+			continue
+		case def[0].is("UNIQUE"), def[0].is("CHECK"), def[0].is("FOREIGN"):
+			continue
+		}
+		// The type is the words before the first constraint, and must be
+		// INTEGER alone for the column to alias the rowid.
+		var typ []string
+		i := 1
+		for ; i < len(def) && def[i].word && !sqliteConstraints[strings.ToUpper(def[i].text)]; i++ {
+			typ = append(typ, strings.ToUpper(def[i].text))
+		}
+		isInt := len(typ) == 1 && typ[0] == "INTEGER" && (i == len(def) || !def[i].is("("))
+		rowid := false
+		for ; i+1 < len(def); i++ {
+			if def[i].is("PRIMARY") && def[i+1].is("KEY") {
+				rowid = isInt && (i+2 == len(def) || !def[i+2].is("DESC"))
+			}
+		}
+		if isInt {
+			integer[len(cols)] = true
+		}
+		cols = append(cols, sqliteColumn{name: def[0].text, rowid: rowid})
+	}
+	for i := range cols {
+		if integer[i] && strings.EqualFold(cols[i].name, key) {
+			cols[i].rowid = true
+		}
+	}
+	return cols, withoutRowid
+}
+
+// scan calls fn with the rowid and payload of each row in the table
+// b-tree rooted at page, in order.
+func (db *sqliteDB) scan(page uint32, depth int, fn func(rowid int64, p *sqlitePayload) error) error {
+	if depth > 32 {
+		return errSQLiteCorrupt
+	}
+	b, err := db.page(page)
+	if err != nil {
+		return err
+	}
+	hdr := 0
+	if page == 1 {
+		hdr = 100 // after the database header
+	}
+	cells := int(binary.BigEndian.Uint16(b[hdr+3:]))
+	switch b[hdr] {
+	case 0x05: // interior: a child page and a rowid per cell, then the right-most child
+		ptrs := b[hdr+12:]
+		if 2*cells > len(ptrs) {
+			return errSQLiteCorrupt
+		}
+		for i := range cells {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off+4 > len(b) {
+				return errSQLiteCorrupt
+			}
+			if err := db.scan(binary.BigEndian.Uint32(b[off:]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return db.scan(binary.BigEndian.Uint32(b[hdr+8:]), depth+1, fn)
+	case 0x0d: // leaf: a payload size, rowid and payload per cell
+		ptrs := b[hdr+8:]
+		if 2*cells > len(ptrs) {
+			return errSQLiteCorrupt
+		}
+		for i := range cells {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off >= len(b) {
+				return errSQLiteCorrupt
+			}
+			size, n := sqliteVarint(b[off:])
+			rowid, m := sqliteVarint(b[min(off+n, len(b)):])
+			start := off + n + m
+			if n == 0 || m == 0 || size > 1<<40 {
+				return errSQLiteCorrupt
+			}
+			p := &sqlitePayload{db: db, page: page, off: int64(start), size: int64(size)}
+			p.local = db.localSize(p.size)
+			if int64(start)+p.local > int64(len(b)) {
+				return errSQLiteCorrupt
+			}
+			if p.local < p.size {
+				if int64(start)+p.local+4 > int64(len(b)) {
+					return errSQLiteCorrupt
+				}
+				if p.overflow, err = db.chain(binary.BigEndian.Uint32(b[int64(start)+p.local:]), p.size-p.local); err != nil {
+					return err
+				}
+			}
+			if err := fn(int64(rowid), p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errSQLiteCorrupt
+}
+
+// localSize returns how much of a payload of size bytes is kept in the
+// page of a table leaf cell, the rest spilling to overflow pages.
+func (db *sqliteDB) localSize(size int64) int64 {
+	u := db.usable
+	if size <= u-35 {
+		return size
+	}
+	m := (u-12)*32/255 - 23
+	if k := m + (size-m)%(u-4); k <= u-35 {
+		return k
+	}
+	return m
+}
+
+// chain returns the overflow pages holding n bytes, from first.
+func (db *sqliteDB) chain(first uint32, n int64) ([]uint32, error) {
+	var pages []uint32
+	var next [4]byte
+	for page := first; n > 0; n -= db.usable - 4 {
+		if page == 0 || page > db.pages || len(pages) > int(db.pages) {
+			return nil, errSQLiteCorrupt
+		}
+		pages = append(pages, page)
+		if _, err := db.f.ReadAt(next[:], int64(page-1)*db.pageSize); err != nil {
+			return nil, err
+		}
+		page = binary.BigEndian.Uint32(next[:])
+	}
+	return pages, nil
+}
+
+// sqlitePayload is the record of a row, local bytes of which are in the
+// page of its cell and the rest in overflow pages. It is an io.ReaderAt.
+type sqlitePayload struct {
+	db       *sqliteDB
+	page     uint32
+	off      int64 // where the payload starts in page
+	size     int64
+	local    int64
+	overflow []uint32
+}
+
+func (p *sqlitePayload) ReadAt(b []byte, off int64) (int, error) {
+	read := 0
+	for len(b) > 0 {
+		if off >= p.size {
+			return read, io.EOF
+		}
+		var pos, avail int64
+		if off < p.local {
+			pos, avail = int64(p.page-1)*p.db.pageSize+p.off+off, p.local-off
+		} else {
+			per := p.db.usable - 4
+			i, within := (off-p.local)/per, (off-p.local)%per
+			pos, avail = int64(p.overflow[i]-1)*p.db.pageSize+4+within, per-within
+		}
+		avail = min(avail, p.size-off, int64(len(b)))
+		n, err := p.db.f.ReadAt(b[:avail], pos)
+		read, off, b = read+n, off+int64(n), b[n:]
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// sqliteValue is a value in a record: its serial type and where it is.
+type sqliteValue struct {
+	typ    uint64
+	off, n int64
+}
+
+func (v sqliteValue) isBlob() bool { return v.typ >= 12 && v.typ%2 == 0 }
+func (v sqliteValue) isText() bool { return v.typ >= 13 && v.typ%2 == 1 }
+
+// record returns the values of the record.
+func (p *sqlitePayload) record() ([]sqliteValue, error) {
+	var head [9]byte
+	n, _ := p.ReadAt(head[:], 0)
+	size, k := sqliteVarint(head[:n])
+	if k == 0 || int64(size) > p.size || size > 1<<20 {
+		return nil, errSQLiteCorrupt
+	}
+	h := make([]byte, size)
+	if _, err := p.ReadAt(h, 0); err != nil {
+		return nil, err
+	}
+	var vals []sqliteValue
+	off := int64(size)
+	for pos := k; pos < len(h); {
+		typ, k := sqliteVarint(h[pos:])
+		if k == 0 {
+			return nil, errSQLiteCorrupt
+		}
+		pos += k
+		v := sqliteValue{typ: typ, off: off}
+		switch {
+		case typ <= 4:
+			v.n = int64(typ)
+		case typ == 5:
+			v.n = 6
+		case typ == 6 || typ == 7:
+			v.n = 8
+		case typ >= 12:
+			v.n = int64(typ-12) / 2
+		}
+		off += v.n
+		if off > p.size {
+			return nil, errSQLiteCorrupt
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// bytes returns the bytes of the value.
+func (p *sqlitePayload) bytes(v sqliteValue) ([]byte, error) {
+	b := make([]byte, v.n)
+	if _, err := p.ReadAt(b, v.off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+// text returns the value if it is text, "" if it is NULL.
+func (p *sqlitePayload) text(v sqliteValue) (string, error) {
+	if v.typ == 0 {
+		return "", nil
+	}
+	if !v.isText() {
+		return "", errors.New("not text")
+	}
+	b, err := p.bytes(v)
+	return string(b), err
+}
+
+// int returns the value if it is an integer.
+func (p *sqlitePayload) int(v sqliteValue) (int64, bool) {
+	switch {
+	case v.typ == 8:
+		return 0, true
+	case v.typ == 9:
+		return 1, true
+	case v.typ < 1 || v.typ > 6:
+		return 0, false
+	}
+	b, err := p.bytes(v)
+	if err != nil {
+		return 0, false
+	}
+	x := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		x = x<<8 | int64(c)
+	}
+	return x, true
+}
+
+// time returns the value as a time, from Unix seconds or text, or the
+// zero time if it is neither.
+func (p *sqlitePayload) time(v sqliteValue) time.Time {
+	if secs, ok := p.int(v); ok {
+		return time.Unix(secs, 0)
+	}
+	if v.typ == 7 {
+		if b, err := p.bytes(v); err == nil {
+			secs := math.Float64frombits(binary.BigEndian.Uint64(b))
+			return time.Unix(0, int64(secs*1e9))
+		}
+	}
+	s, err := p.text(v)
+	if err != nil {
+		return time.Time{}
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// sqliteVarint decodes the big-endian varint at the start of b, of up
+// to nine bytes, returning its length, or 0 if b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var x uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return x<<8 | uint64(b[i]), 9
+		}
+		x = x<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// contentTyper is an fs.FS that stores the content types of its files.
+type contentTyper interface {
+	ContentType(name string) string
+}
+
+// typeHandler sets the Content-Type of the files of types that have
+// one stored, before next serves them.
+type typeHandler struct {
+	types contentTyper
+	next  http.Handler
+}
+
+func (h typeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if ctype := h.types.ContentType(path.Clean(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	h.next.ServeHTTP(w, r)
+}
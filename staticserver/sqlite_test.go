@@ -0,0 +1,206 @@
+package staticserver
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSQLiteTable(t *testing.T) {
+	files := []sqliteColumn{{"path", false}, {"content", false}}
+	tests := []struct {
+		name, sql    string
+		want         []sqliteColumn
+		withoutRowid bool
+	}{
+		{"plain", "CREATE TABLE files (path TEXT PRIMARY KEY, content BLOB)", files, false},
+		{"rowid", "CREATE TABLE files (id INTEGER PRIMARY KEY, path TEXT, content BLOB)",
+			[]sqliteColumn{{"id", true}, {"path", false}, {"content", false}}, false},
+		{"rowid in lower case", "create table files (id integer primary key autoincrement, path, content)",
+			[]sqliteColumn{{"id", true}, {"path", false}, {"content", false}}, false},
+		{"rowid by table constraint", "CREATE TABLE files (mtime INTEGER, path TEXT, content BLOB, CONSTRAINT pk PRIMARY KEY (mtime) ON CONFLICT REPLACE)",
+			[]sqliteColumn{{"mtime", true}, {"path", false}, {"content", false}}, false},
+		{"not INTEGER", "CREATE TABLE files (id INT PRIMARY KEY, path, content)",
+			[]sqliteColumn{{"id", false}, {"path", false}, {"content", false}}, false},
+		{"INTEGER of a size", "CREATE TABLE files (id INTEGER(8) PRIMARY KEY, path, content)",
+			[]sqliteColumn{{"id", false}, {"path", false}, {"content", false}}, false},
+		{"PRIMARY KEY DESC", "CREATE TABLE files (id INTEGER PRIMARY KEY DESC, path, content)",
+			[]sqliteColumn{{"id", false}, {"path", false}, {"content", false}}, false},
+		{"key of two columns", "CREATE TABLE files (id INTEGER, path, content, PRIMARY KEY (id, path))",
+			[]sqliteColumn{{"id", false}, {"path", false}, {"content", false}}, false},
+		{"quoted default", "CREATE TABLE files (path TEXT DEFAULT 'a, b', content BLOB DEFAULT 'it''s (')", files, false},
+		{"check", "CREATE TABLE files (path TEXT CHECK (path IN ('a', 'b')), content BLOB, CHECK (length(path) > 0, 1))", files, false},
+		{"comments", "CREATE TABLE files (\n-- a, b)\npath TEXT, /* c, ) */ content BLOB)", files, false},
+		{"quoted names", "CREATE TABLE files (\"my \"\"path\"\"\" TEXT, [con tent] BLOB, `mtime` INT, 'content_type')",
+			[]sqliteColumn{{`my "path"`, false}, {"con tent", false}, {"mtime", false}, {"content_type", false}}, false},
+		{"column named like a keyword", "CREATE TABLE files (\"check\" TEXT, path, content)",
+			[]sqliteColumn{{"check", false}, {"path", false}, {"content", false}}, false},
+		{"without rowid", "CREATE TABLE files (path TEXT PRIMARY KEY, content BLOB) WITHOUT ROWID", files, true},
+		{"without rowid in a default", "CREATE TABLE files (path TEXT DEFAULT 'x) WITHOUT ROWID', content BLOB)", files, false},
+		{"no columns", "CREATE TABLE files AS SELECT 1", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, withoutRowid := parseSQLiteTable(tt.sql)
+			if !reflect.DeepEqual(cols, tt.want) || withoutRowid != tt.withoutRowid {
+				t.Errorf("parseSQLiteTable(%q) = %v, %v, want %v, %v", tt.sql, cols, withoutRowid, tt.want, tt.withoutRowid)
+			}
+		})
+	}
+}
+
+// appendSQLiteVarint appends the SQLite varint of v, which is under 2⁵⁶.
+func appendSQLiteVarint(b []byte, v uint64) []byte {
+	var groups []byte
+	for {
+		groups = append([]byte{byte(v & 0x7f)}, groups...)
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	for i := range len(groups) - 1 {
+		groups[i] |= 0x80
+	}
+	return append(b, groups...)
+}
+
+// sqliteRecord returns the record of the values, each nil, an int64,
+// a string or a []byte.
+func sqliteRecord(vals ...any) []byte {
+	var types, body []byte
+	for _, v := range vals {
+		switch v := v.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case int64:
+			types = appendSQLiteVarint(types, 6)
+			body = binary.BigEndian.AppendUint64(body, uint64(v))
+		case string:
+			types = appendSQLiteVarint(types, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			types = appendSQLiteVarint(types, uint64(12+2*len(v)))
+			body = append(body, v...)
+		}
+	}
+	// The size of the header counts itself; records here are small.
+	return append(append([]byte{byte(len(types) + 1)}, types...), body...)
+}
+
+// sqliteLeaf writes a table leaf page of the records, by rowid from 1,
+// into page, whose header is at hdr.
+func sqliteLeaf(page []byte, hdr int, records ...[]byte) {
+	page[hdr] = 0x0d
+	binary.BigEndian.PutUint16(page[hdr+3:], uint16(len(records)))
+	end := len(page)
+	for i, rec := range records {
+		cell := appendSQLiteVarint(nil, uint64(len(rec)))
+		cell = appendSQLiteVarint(cell, uint64(i+1))
+		cell = append(cell, rec...)
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[hdr+8+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[hdr+5:], uint16(end))
+}
+
+// writeSQLiteDB writes a database of the table files, created with sql,
+// of the rows, to name, renaming it over any there.
+func writeSQLiteDB(t *testing.T, name, sql string, counter uint32, rows ...[]any) {
+	t.Helper()
+	const pageSize = 4096
+	b := make([]byte, 2*pageSize)
+	copy(b, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(b[16:], pageSize)
+	b[18], b[19], b[21], b[22], b[23] = 1, 1, 64, 32, 32
+	binary.BigEndian.PutUint32(b[24:], counter)
+	binary.BigEndian.PutUint32(b[28:], 2)
+	binary.BigEndian.PutUint32(b[44:], 4)
+	binary.BigEndian.PutUint32(b[56:], 1)
+	sqliteLeaf(b[:pageSize], 100, sqliteRecord("table", "files", "files", int64(2), sql))
+	var records [][]byte
+	for _, row := range rows {
+		records = append(records, sqliteRecord(row...))
+	}
+	sqliteLeaf(b[pageSize:], 0, records...)
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteFS(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "site.db")
+	const schema = "CREATE TABLE files (id INTEGER PRIMARY KEY, path TEXT NOT NULL, content BLOB, content_type TEXT DEFAULT 'text/plain, charset=utf-8')"
+	writeSQLiteDB(t, name, schema, 1,
+		[]any{nil, "/index.html", []byte("<h1>one</h1>"), "text/html"},
+		[]any{nil, "docs/a.txt", "text content", nil},
+	)
+	s, err := OpenSQLite(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	read := func(f io.Reader) string {
+		t.Helper()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	f, err := s.Open("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.ContentType("index.html"); got != "text/html" {
+		t.Errorf("ContentType(index.html) = %q, want text/html", got)
+	}
+	a, err := s.Open("docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(a); got != "text content" {
+		t.Errorf("docs/a.txt = %q, want %q", got, "text content")
+	}
+	a.Close()
+	if fi, err := s.Open("docs"); err != nil {
+		t.Error(err)
+	} else if st, _ := fi.Stat(); !st.IsDir() {
+		t.Error("docs is not a dir")
+	}
+
+	// A file opened before the database is replaced is still read from
+	// the old one.
+	old := s.current()
+	old.db.release()
+	writeSQLiteDB(t, name, schema, 2, []any{nil, "index.html", []byte("<h1>two</h1>"), nil})
+	g, err := s.Open("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(g); got != "<h1>two</h1>" {
+		t.Errorf("index.html after the swap = %q, want the new one", got)
+	}
+	g.Close()
+	if _, err := s.Open("docs/a.txt"); err == nil {
+		t.Error("docs/a.txt is still there after the swap")
+	}
+	if got := read(f); got != "<h1>one</h1>" {
+		t.Errorf("index.html opened before the swap = %q, want the old one", got)
+	}
+	if _, err := old.db.f.Stat(); err != nil {
+		t.Errorf("the old database was closed with a file of it open: %v", err)
+	}
+	f.Close()
+	f.Close()
+	if _, err := old.db.f.Stat(); err == nil {
+		t.Error("the old database is still open with no file of it open")
+	}
+}
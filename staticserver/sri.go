@@ -0,0 +1,134 @@
+package staticserver
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// sriTag matches the script and link tags of HTML.
+	sriTag = regexp.MustCompile(`(?is)<(script|link)\b[^>]*>`)
+	// tagAttr matches an attribute of a tag.
+	tagAttr = regexp.MustCompile(`(?is)\s([a-z][a-z0-9-]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s>"']+))?`)
+	// integrityAttr matches an integrity attribute.
+	integrityAttr = regexp.MustCompile(`(?is)\sintegrity\s*=\s*("[^"]*"|'[^']*'|[^\s>"']+)`)
+)
+
+// Integrity returns the Subresource Integrity hash of the content of r,
+// as an integrity attribute has it: sha384- and the base64 digest.
+func Integrity(r io.Reader) (string, error) {
+	h := sha512.New384()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// AddIntegrity gives the script tags and the stylesheet, preload and
+// modulepreload link tags of the HTML files in dir whose files are in
+// dir an integrity attribute with the hash of the file, or updates the
+// one they have, so that a browser refuses a file that has been changed
+// by anyone but whoever ran it. Tags for other sites are left alone, as
+// their files cannot be read. It returns the number of files rewritten.
+func AddIntegrity(dir string) (int, error) {
+	rewritten := 0
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(name)); !d.Type().IsRegular() || ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, name)
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		out, err := addIntegrity(os.DirFS(dir), filepath.ToSlash(rel), b)
+		if err != nil || string(out) == string(b) {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		debugf("sri: rewrote %s", rel)
+		rewritten++
+		return os.WriteFile(name, out, fi.Mode().Perm())
+	})
+	return rewritten, err
+}
+
+// addIntegrity returns the page name of fsys, b, with the integrity
+// attributes of its tags made those of the files in fsys.
+func addIntegrity(fsys fs.FS, name string, b []byte) ([]byte, error) {
+	var failed error
+	out := sriTag.ReplaceAllFunc(b, func(tag []byte) []byte {
+		attrs := map[string]string{}
+		for _, m := range tagAttr.FindAllSubmatch(tag, -1) {
+			v := string(m[2])
+			if len(v) > 1 && (v[0] == '"' || v[0] == '\'') {
+				v = v[1 : len(v)-1]
+			}
+			attrs[strings.ToLower(string(m[1]))] = v
+		}
+		ref := attrs["src"]
+		if strings.EqualFold(string(tag[1:5]), "link") {
+			rel := " " + strings.ToLower(attrs["rel"]) + " "
+			if !strings.Contains(rel, " stylesheet ") && !strings.Contains(rel, " preload ") && !strings.Contains(rel, " modulepreload ") {
+				return tag
+			}
+			ref = attrs["href"]
+		}
+		p, _, ok := localRef(name, ref)
+		if !ok {
+			return tag
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			infof("warning: sri: %s: %v", name, err)
+			return tag
+		}
+		sum, err := Integrity(f)
+		f.Close()
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			return tag
+		}
+		s := integrityAttr.ReplaceAllString(string(tag), "")
+		head, tail := s[:len(s)-1], ">"
+		if strings.HasSuffix(s, "/>") {
+			head, tail = s[:len(s)-2], " />"
+		}
+		s = strings.TrimRight(head, " \t\r\n") + ` integrity="` + sum + `"` + tail
+		return []byte(s)
+	})
+	return out, failed
+}
+
+// integrity returns the Subresource Integrity hash of the file at the
+// URL path p of fs, for the integrity function of .tmpl pages.
+func integrity(fs http.FileSystem, p string) (string, error) {
+	f, err := fs.Open(path.Clean("/" + p))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Integrity(f)
+}
@@ -0,0 +1,169 @@
+package staticserver
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ssiError is written in place of a directive that fails, as Apache
+// writes it.
+const ssiError = "[an error occurred while processing this directive]"
+
+var (
+	ssiDirective = regexp.MustCompile(`<!--#([a-z]+)((?:\s+[a-z]+\s*=\s*"[^"]*")*)\s*-->`)
+	ssiParam     = regexp.MustCompile(`([a-z]+)\s*=\s*"([^"]*)"`)
+)
+
+// ssiHandler processes the server-side includes of .shtml and .html
+// files, and passes every other request to next. It knows the include
+// directive, with virtual paths from the root or relative ones, and
+// file paths relative to the page, and echo of the standard variables.
+type ssiHandler struct {
+	fs   http.FileSystem
+	next http.Handler
+}
+
+// isSSI reports whether the file is processed for includes.
+func isSSI(name string) bool {
+	ext := path.Ext(name)
+	return ext == ".shtml" || ext == ".html"
+}
+
+// ServeHTTP serves the requested page with its directives processed, or
+// serves r with next.
+func (h ssiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || !isSSI(name) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	page, modTime, err := h.read(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	var out bytes.Buffer
+	h.process(&out, page, name, ssiDoc{name, r.URL.Path, modTime}, 0)
+	if path.Ext(name) == ".shtml" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	// The includes may have changed since, so there is no Last-Modified.
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(out.Bytes()))
+}
+
+// read returns the content and modification time of the file.
+func (h ssiHandler) read(name string) ([]byte, time.Time, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if fi.IsDir() {
+		return nil, time.Time{}, errors.New("is a directory")
+	}
+	b, err := io.ReadAll(f)
+	return b, fi.ModTime(), err
+}
+
+// ssiDoc is the page requested, which the variables echo describe.
+type ssiDoc struct {
+	name, uri string
+	modTime   time.Time
+}
+
+// process writes page, the file name included in doc or doc itself,
+// with its directives replaced by what they produce. Included pages
+// are processed in turn, up to a depth that stops includes that loop.
+func (h ssiHandler) process(w *bytes.Buffer, page []byte, name string, doc ssiDoc, depth int) {
+	for len(page) > 0 {
+		loc := ssiDirective.FindSubmatchIndex(page)
+		if loc == nil {
+			w.Write(page)
+			return
+		}
+		w.Write(page[:loc[0]])
+		cmd := string(page[loc[2]:loc[3]])
+		params := ssiParam.FindAllStringSubmatch(string(page[loc[4]:loc[5]]), -1)
+		page = page[loc[1]:]
+		encode := true
+		for _, p := range params {
+			switch {
+			case cmd == "include" && (p[1] == "virtual" || p[1] == "file"):
+				target := p[2]
+				if p[1] == "file" && (path.IsAbs(target) || strings.Contains(target, "..")) {
+					debugf("ssi: %s: file %q must be relative and below the page", name, target)
+					w.WriteString(ssiError)
+					continue
+				}
+				if !path.IsAbs(target) {
+					target = path.Join(path.Dir(name), target)
+				}
+				target, _, _ = strings.Cut(target, "?")
+				target = path.Clean(target)
+				if depth >= 16 {
+					debugf("ssi: %s: including %s: nested too deeply", name, target)
+					w.WriteString(ssiError)
+					continue
+				}
+				included, _, err := h.read(target)
+				if err != nil {
+					debugf("ssi: %s: including %s: %v", name, target, err)
+					w.WriteString(ssiError)
+					continue
+				}
+				if isSSI(target) {
+					h.process(w, included, target, doc, depth+1)
+				} else {
+					w.Write(included)
+				}
+			case cmd == "echo" && p[1] == "encoding":
+				encode = p[2] != "none"
+			case cmd == "echo" && p[1] == "var":
+				v, ok := ssiVar(p[2], doc)
+				if !ok {
+					v = "(none)"
+				}
+				if encode {
+					v = html.EscapeString(v)
+				}
+				w.WriteString(v)
+			default:
+				debugf("ssi: %s: unknown directive %s %s", name, cmd, p[1])
+				w.WriteString(ssiError)
+			}
+		}
+	}
+}
+
+// ssiVar returns the value of a standard variable for echo.
+func ssiVar(v string, doc ssiDoc) (string, bool) {
+	const layout = "Monday, 02-Jan-2006 15:04:05 MST"
+	now := time.Now()
+	switch v {
+	case "DOCUMENT_NAME":
+		return path.Base(doc.name), true
+	case "DOCUMENT_URI":
+		return doc.uri, true
+	case "DATE_LOCAL":
+		return now.Format(layout), true
+	case "DATE_GMT":
+		return now.UTC().Format(layout), true
+	case "LAST_MODIFIED":
+		return doc.modTime.Format(layout), true
+	}
+	return "", false
+}
@@ -0,0 +1,244 @@
+package staticserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// fileStat is the JSON description of a file ?stat=1 answers with.
+type fileStat struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Dir         bool      `json:"dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	ContentType string    `json:"content_type,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Entries     *int      `json:"entries,omitempty"` // the number of entries of a dir
+}
+
+// statHandler answers requests for ?stat=1 with the fileStat of the file
+// they are for, and passes every other request to next. A file's type is
+// the one it would be served with, from its extension or its content.
+type statHandler struct {
+	fs   http.FileSystem
+	sums *digestCache
+	next http.Handler
+}
+
+// ServeHTTP describes the file of r, or serves r with next.
+func (h statHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("stat") != "1" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	st, err := h.stat(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		infof("warning: stat: %s: %v", name, err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(st)
+}
+
+// stat describes the file name.
+func (h statHandler) stat(name string) (*fileStat, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st := &fileStat{Path: name, Name: fi.Name(), Dir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime().UTC()}
+	if name == "/" {
+		st.Name = "/"
+	}
+	if fi.IsDir() {
+		entries, err := f.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, e := range entries {
+			if !isDotF(e.Name()) {
+				n++
+			}
+		}
+		st.Size, st.Entries = 0, &n
+		return st, nil
+	}
+	st.ContentType = mime.TypeByExtension(path.Ext(name))
+	if st.ContentType == "" {
+		var buf [512]byte
+		n, _ := io.ReadFull(f, buf[:])
+		st.ContentType = http.DetectContentType(buf[:n])
+	}
+	sum, err := h.sums.sum(h.fs, name, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	st.SHA256 = hex.EncodeToString(sum)
+	return st, nil
+}
+
+// The default and largest depth and number of entries of a ?tree=1
+// response.
+const (
+	treeDepth    = 16
+	maxTreeDepth = 64
+	treeLimit    = 10000
+	maxTreeLimit = 100000
+)
+
+// treeNode is an entry of the JSON tree ?tree=1 answers with.
+type treeNode struct {
+	Name     string      `json:"name"`
+	Dir      bool        `json:"dir,omitempty"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"mtime"`
+	Children []*treeNode `json:"children,omitempty"`
+	// Truncated is set on a dir whose entries were left out, as it is
+	// deeper than the depth asked for or the limit was reached.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// treeHandler answers requests for ?tree=1 on a dir with the tree of
+// the files under it as nested JSON, down to ?depth= levels and up to
+// ?limit= entries in all, and passes every other request to next.
+// Entries are sorted by name, and dot files are left out as they are
+// from listings, as are the dirs the config and password files of the
+// dirs keep from those let into the dir and the entries of those config
+// files turn listing off for. A dir whose listing is off is not made a
+// tree of.
+type treeHandler struct {
+	fs        http.FileSystem
+	configs   *dirConfigs   // the config files of the dirs, if they apply
+	passwords *dirPasswords // the password files of the dirs, if they apply
+	next      http.Handler
+}
+
+// ServeHTTP answers r with a tree, or serves it with next.
+func (h treeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("tree") != "1" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	depth, limit := treeDepth, treeLimit
+	for _, p := range []struct {
+		name     string
+		v        *int
+		min, max int
+	}{{"depth", &depth, 0, maxTreeDepth}, {"limit", &limit, 1, maxTreeLimit}} {
+		if s := q.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < p.min || n > p.max {
+				http.Error(w, fmt.Sprintf("%s must be a number from %d to %d", p.name, p.min, p.max), http.StatusBadRequest)
+				return
+			}
+			*p.v = n
+		}
+	}
+	name := path.Clean("/" + r.URL.Path)
+	fi, err := h.statDir(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		infof("warning: tree: %s: %v", name, err)
+		http.Error(w, "Error reading dir", http.StatusInternalServerError)
+		return
+	case !fi.IsDir():
+		http.Error(w, "a tree is only made of a dir", http.StatusBadRequest)
+		return
+	}
+	fsys := h.fs
+	if h.configs != nil || h.passwords != nil {
+		g := guardFS{FileSystem: h.fs, configs: h.configs, passwords: h.passwords, root: name}
+		if !g.listed(name) {
+			debugf("tree: %s: not listing", name)
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+		fsys = g
+	}
+	root := &treeNode{Name: path.Base(name), Dir: true, ModTime: fi.ModTime().UTC()}
+	h.walk(fsys, root, name, depth, &limit)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(root)
+}
+
+// statDir returns the FileInfo of name.
+func (h treeHandler) statDir(name string) (os.FileInfo, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// walk adds the entries of the dir name of fsys to n, and theirs, down
+// to depth more levels, taking each from what is left of limit.
+func (h treeHandler) walk(fsys http.FileSystem, n *treeNode, name string, depth int, limit *int) {
+	if depth == 0 || *limit == 0 {
+		n.Truncated = true
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		debugf("tree: %s: %v", name, err)
+		return
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		debugf("tree: %s: %v", name, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if *limit == 0 {
+			n.Truncated = true
+			return
+		}
+		*limit--
+		c := &treeNode{Name: e.Name(), Dir: e.IsDir(), ModTime: e.ModTime().UTC()}
+		if e.IsDir() {
+			h.walk(fsys, c, path.Join(name, e.Name()), depth-1, limit)
+		} else {
+			c.Size = e.Size()
+		}
+		n.Children = append(n.Children, c)
+	}
+}
@@ -0,0 +1,605 @@
+package staticserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errOutsideRoot is returned for names that would be written outside
+	// the root of a store, or that name a hidden dot file.
+	errOutsideRoot = errors.New("path not allowed")
+
+	// errTooLarge is returned for files larger than a store accepts.
+	errTooLarge = errors.New("file too large")
+
+	// errExtension is returned for files of a type a store does not accept.
+	errExtension = errors.New("file type not allowed")
+
+	// errQuota is returned for files that would take a store over its quota.
+	errQuota = errors.New("quota exceeded")
+)
+
+// store writes files into a document root on behalf of the HTTP write
+// endpoints. Files are written to a hidden temporary file beside their
+// destination and renamed into place once complete, so a partial upload
+// is never served.
+type store struct {
+	root    string
+	maxSize int64           // the largest file accepted, or 0 for no limit
+	exts    map[string]bool // the lowercased extensions accepted, or nil for any
+	quota   int64           // the most bytes all files may total, or 0 for no limit
+	webhook string          // the URL changes are reported to, if any
+	scan    *scanner        // checks files before they are served, if non-nil
+	mtimes  *mtimeFS        // gives files the times of a manifest, if non-nil
+
+	condMu sync.Mutex
+	conds  map[string]*pathLock // held by a conditional write to a name from its checks until it is done
+
+	reserveMu sync.Mutex // held from the check of a reservation until it is made
+
+	mu        sync.Mutex
+	used      int64     // the bytes used by all files, as of countedAt
+	countedAt time.Time // when used was last counted, or zero if never
+	reserved  int64     // the bytes promised to writes not yet made, such as the rest of tus uploads
+}
+
+// path returns the file system path of the slash separated name within
+// the store's root. It fails for names with dot file elements and for
+// names whose parent directory resolves, through symlinks, outside root.
+func (s *store) path(name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" || isDotF(name) {
+		return "", errOutsideRoot
+	}
+	root, err := filepath.Abs(s.root)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
+	p := filepath.Join(root, filepath.FromSlash(name))
+
+	// Resolve the deepest existing ancestor, which may be a symlink.
+	dir := filepath.Dir(p)
+	for {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+			break
+		}
+		if parent := filepath.Dir(dir); parent != dir {
+			dir = parent
+			continue
+		}
+		break
+	}
+	if rel, err := filepath.Rel(root, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return p, nil
+}
+
+// put writes the contents of body, sent by client, to name, creating any
+// missing parent directories. It reports whether the file was newly
+// created rather than replaced.
+func (s *store) put(name string, body io.Reader, client string) (created bool, err error) {
+	p, old, created, err := s.prepare(name)
+	if err != nil {
+		return false, err
+	}
+	n, sum, err := s.write(p, name, body, old)
+	if err != nil {
+		return false, err
+	}
+	s.grow(n - old)
+	s.changed(name, created, n, sum, client)
+	return created, nil
+}
+
+// write writes the contents of body to the file system path p, as the
+// file name replacing one of old bytes, subject to the limits of the
+// store. It returns the size of the file, and its hex encoded SHA-256
+// digest if the store has a webhook.
+func (s *store) write(p, name string, body io.Reader, old int64) (n int64, sum string, err error) {
+	limit, quotaBound, err := s.room(old)
+	if err != nil {
+		return 0, "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	var dst io.Writer = tmp
+	h := sha256.New()
+	if s.webhook != "" {
+		dst = io.MultiWriter(tmp, h)
+	}
+	n, err = io.Copy(dst, body)
+	if err == nil && limit > 0 && n > limit {
+		err = errTooLarge
+		if quotaBound {
+			err = errQuota
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	// Readable first, so that a scanner run as another user can read it.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return 0, "", err
+	}
+	if s.scan != nil {
+		if err := s.scan.check(tmp.Name(), name); err != nil {
+			return 0, "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return 0, "", err
+	}
+	if s.webhook != "" {
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	return n, sum, nil
+}
+
+// adopt moves the complete file at the file system path src under the
+// root, sent by client, into the store as name. Its bytes were counted
+// as they were written, against a reservation, so only the name is
+// checked as put checks it. It reports whether the file was newly
+// created rather than replaced.
+func (s *store) adopt(src, name, client string) (created bool, err error) {
+	p, old, created, err := s.prepare(name)
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Chmod(src, 0o644); err != nil {
+		return false, err
+	}
+	if s.scan != nil {
+		if err := s.scan.check(src, name); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Rename(src, p); err != nil {
+		return false, err
+	}
+	s.grow(-old)
+	if s.webhook != "" {
+		s.changed(name, created, fi.Size(), fileSHA256(p), client)
+	}
+	return created, nil
+}
+
+// changed reports the creation or replacement of the file name.
+func (s *store) changed(name string, created bool, size int64, sum, client string) {
+	ev := writeEvent{Event: "replaced", Path: path.Clean("/" + name), Size: size, SHA256: sum, Client: client}
+	if created {
+		ev.Event = "created"
+	}
+	s.notify(ev)
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the named file,
+// or "" if it cannot be read.
+func fileSHA256(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// prepare checks that a file may be written to name and creates its
+// parent directories. It returns the file's path, the size of the file
+// it would replace, and whether there is no such file.
+func (s *store) prepare(name string) (p string, old int64, created bool, err error) {
+	p, err = s.path(name)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if s.exts != nil && !s.exts[strings.ToLower(path.Ext(name))] {
+		return "", 0, false, errExtension
+	}
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		return "", 0, false, errOutsideRoot
+	} else if err == nil {
+		old = fi.Size()
+	} else {
+		created = true
+	}
+	return p, old, created, os.MkdirAll(filepath.Dir(p), 0o755)
+}
+
+// room returns the most bytes a file replacing one of old bytes may
+// hold, or 0 for no limit, and whether that limit is set by the quota
+// rather than by maxSize. It fails with errQuota if the quota is full.
+func (s *store) room(old int64) (limit int64, quotaBound bool, err error) {
+	limit = s.maxSize
+	if s.quota <= 0 {
+		return limit, false, nil
+	}
+	used, err := s.usage()
+	if err != nil {
+		return 0, false, err
+	}
+	s.mu.Lock()
+	used += s.reserved
+	s.mu.Unlock()
+	free := s.quota - used + old
+	if free <= 0 {
+		return 0, false, errQuota
+	}
+	if limit == 0 || free < limit {
+		return free, true, nil
+	}
+	return limit, false, nil
+}
+
+// fits checks that a file of n bytes replacing one of old bytes is
+// within maxSize and the quota.
+func (s *store) fits(n, old int64) error {
+	limit, quotaBound, err := s.room(old)
+	switch {
+	case err != nil:
+		return err
+	case limit > 0 && n > limit && quotaBound:
+		return errQuota
+	case limit > 0 && n > limit:
+		return errTooLarge
+	}
+	return nil
+}
+
+// reserve promises n bytes to a file to be written later, once it is
+// checked that they fit, so that other writes cannot take them first.
+// What is written of them is moved from the reservation to the bytes
+// used with grow and release, and what is not released.
+func (s *store) reserve(n int64) error {
+	s.reserveMu.Lock()
+	defer s.reserveMu.Unlock()
+	if err := s.fits(n, 0); err != nil {
+		return err
+	}
+	s.release(-n)
+	return nil
+}
+
+// release takes n bytes from the reservations.
+func (s *store) release(n int64) {
+	s.mu.Lock()
+	s.reserved -= n
+	s.mu.Unlock()
+}
+
+// usage returns the number of bytes used by the files under the root.
+// It is recounted at most once a minute and kept up to date in between
+// by grow, so changes made behind the server's back are noticed late.
+func (s *store) usage() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.countedAt.IsZero() && time.Since(s.countedAt) < time.Minute {
+		return s.used, nil
+	}
+	var used int64
+	err := filepath.WalkDir(s.root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			used += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.used, s.countedAt = used, time.Now()
+	return used, nil
+}
+
+// recount makes the next call to usage count the bytes used afresh.
+func (s *store) recount() {
+	s.mu.Lock()
+	s.countedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// grow adds n, which may be negative, to the bytes used by the store.
+func (s *store) grow(n int64) {
+	s.mu.Lock()
+	s.used += n
+	s.mu.Unlock()
+}
+
+// exists reports whether name exists in the store.
+func (s *store) exists(name string) bool {
+	p, err := s.path(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Lstat(p)
+	return err == nil
+}
+
+// isDir reports whether name is the root of the store or a dir in it.
+func (s *store) isDir(name string) bool {
+	if path.Clean("/"+name) == "/" {
+		return true
+	}
+	fi := s.stat(name)
+	return fi != nil && fi.IsDir()
+}
+
+// stat describes the file or dir name in the store, or returns nil if
+// there is none.
+func (s *store) stat(name string) fs.FileInfo {
+	p, err := s.path(name)
+	if err != nil {
+		return nil
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+	if s.mtimes != nil {
+		fi = s.mtimes.info(name, fi)
+	}
+	return fi
+}
+
+// unique returns name if it does not exist in the store, and otherwise
+// the first of name-1, name-2 and so on, numbered before the extension,
+// that does not.
+func (s *store) unique(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; s.exists(name); i++ {
+		name = base + "-" + strconv.Itoa(i) + ext
+	}
+	return name
+}
+
+// mkdir creates the directory name, whose parent must already exist.
+func (s *store) mkdir(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, 0o755)
+}
+
+// remove deletes the file or directory name at the request of client.
+// A directory must be empty unless all is set, in which case everything
+// beneath it is deleted too.
+func (s *store) remove(name string, all bool, client string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if all && fi.IsDir() {
+		err = os.RemoveAll(p)
+		s.recount()
+	} else if err = os.Remove(p); err == nil && fi.Mode().IsRegular() {
+		s.grow(-fi.Size())
+	}
+	if err == nil {
+		s.notify(writeEvent{Event: "deleted", Path: path.Clean("/" + name), Client: client})
+	}
+	return err
+}
+
+// move renames the file or directory from to to at the request of
+// client, replacing any file or directory already at to.
+func (s *store) move(from, to, client string) error {
+	src, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.path(to)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() && s.exts != nil && !s.exts[strings.ToLower(path.Ext(to))] {
+		return errExtension
+	}
+	replaced, err := os.Lstat(dst)
+	created := err != nil
+	if err := s.swap(src, dst); err != nil {
+		return err
+	}
+	switch {
+	case replaced == nil:
+	case replaced.IsDir():
+		s.recount()
+	case replaced.Mode().IsRegular():
+		s.grow(-replaced.Size())
+	}
+	size := fi.Size()
+	if fi.IsDir() {
+		size = 0
+	}
+	s.notify(writeEvent{Event: "deleted", Path: path.Clean("/" + from), Client: client})
+	s.changed(to, created, size, "", client)
+	return nil
+}
+
+// swap renames the file or directory at the file system path src to
+// dst. Whatever is at dst is moved aside first, and removed once src is
+// in its place or moved back if src cannot be, so that a failed move
+// loses nothing.
+func (s *store) swap(src, dst string) error {
+	fi, err := os.Lstat(dst)
+	if err != nil {
+		return os.Rename(src, dst)
+	}
+	if sfi, err := os.Lstat(src); err == nil && !sfi.IsDir() && fi.Mode().IsRegular() {
+		return os.Rename(src, dst) // a file is replaced by another at once
+	}
+	aside, err := os.MkdirTemp(filepath.Dir(dst), ".replaced-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(aside)
+	old := filepath.Join(aside, "old")
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if rerr := os.Rename(old, dst); rerr != nil {
+			infof("warning: store: %s is left at %s: %v", dst, old, rerr)
+			return err
+		}
+		return err
+	}
+	return nil
+}
+
+// copy copies the file or directory from in fsys, the files of the
+// store as they are served, to to at the request of client, with
+// everything beneath a directory when recursive is set, replacing any
+// file or directory already at to. The copy is made beside to under a
+// hidden name and renamed into place once it is complete, so that a
+// copy that fails leaves to as it was.
+func (s *store) copy(fsys http.FileSystem, from, to string, recursive bool, client string) error {
+	dst, err := s.path(to)
+	if err != nil {
+		return err
+	}
+	old := int64(0)
+	replaced, err := os.Lstat(dst)
+	if err == nil && replaced.Mode().IsRegular() {
+		old = replaced.Size()
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(dst), ".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	var copied []writeEvent
+	defer s.recount() // the staged files were counted as they were written
+	err = s.stage(fsys, from, to, filepath.Join(stage, "copy"), recursive, old, &copied)
+	if err == nil {
+		err = s.swap(filepath.Join(stage, "copy"), dst)
+	}
+	if err != nil {
+		return err
+	}
+	for _, ev := range copied {
+		s.changed(ev.Path, replaced == nil || ev.Path != path.Clean("/"+to), ev.Size, ev.SHA256, client)
+	}
+	return nil
+}
+
+// stage copies the file or directory from in fsys to the file system
+// path p, for to, recording each file written in copied. The first
+// file counts against the quota as replacing one of old bytes.
+func (s *store) stage(fsys http.FileSystem, from, to, p string, recursive bool, old int64, copied *[]writeEvent) error {
+	f, err := fsys.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if s.exts != nil && !s.exts[strings.ToLower(path.Ext(to))] {
+			return errExtension
+		}
+		n, sum, err := s.write(p, to, f, old)
+		if err != nil {
+			return err
+		}
+		s.grow(n)
+		*copied = append(*copied, writeEvent{Path: path.Clean("/" + to), Size: n, SHA256: sum})
+		return nil
+	}
+
+	if err := os.Mkdir(p, 0o755); err != nil {
+		return err
+	}
+	if !recursive {
+		return nil
+	}
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := s.stage(fsys, path.Join(from, e.Name()), path.Join(to, e.Name()), filepath.Join(p, e.Name()), true, 0, copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeErrorStatus returns the HTTP status that reports err from a store.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, errOutsideRoot), errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, errTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errExtension):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errQuota):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, errRejected):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
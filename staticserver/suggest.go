@@ -0,0 +1,229 @@
+package staticserver
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The most suggestions a 404 offers, and the most entries a dir may
+// have to be looked through for them.
+const (
+	maxSuggestions  = 3
+	maxSuggestEntry = 10000
+)
+
+// notFoundPage is the 404 page that offers Suggestions.
+var notFoundPage = template.Must(template.New("404").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>404 Not Found</title>
+<style>body { font-family: sans-serif; margin: 1em auto; max-width: 50em; padding: 0 1em; }</style>
+<h1>Not Found</h1>
+<p>There is nothing at {{.Path}}.</p>
+{{with .Suggestions}}<p>Did you mean:</p>
+<ul>{{range .}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>{{end}}`))
+
+// suggestHandler answers requests for paths that do not exist with
+// near matches that do: names that differ in case, in extension or by a
+// typo or two, looked for from the deepest dir of the path that exists.
+// They are listed on the 404 page and in Link headers. Every other
+// request is passed on to next.
+type suggestHandler struct {
+	fs   http.FileSystem
+	next http.Handler
+}
+
+// ServeHTTP answers r with suggestions when its path does not exist, or
+// serves it with next.
+func (h suggestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err == nil {
+		f.Close()
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	suggestions := h.suggest(name)
+	debugf("suggest: %s does not exist, suggesting %v", name, suggestions)
+	for _, s := range suggestions {
+		w.Header().Add("Link", "<"+(&url.URL{Path: s}).EscapedPath()+`>; rel="related"`)
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 page not found\n"))
+		for _, s := range suggestions {
+			w.Write([]byte("did you mean " + s + "\n"))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	notFoundPage.Execute(w, struct {
+		Path        string
+		Suggestions []string
+	}{r.URL.Path, suggestions})
+}
+
+// suggest returns the paths that exist nearest to name, best first.
+func (h suggestHandler) suggest(name string) []string {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	// Find the deepest dir that exists.
+	dir, i := "/", 0
+	for ; i < len(parts)-1; i++ {
+		next := path.Join(dir, parts[i])
+		if !h.isDir(next) {
+			break
+		}
+		dir = next
+	}
+	var out []string
+	for _, first := range h.near(dir, parts[i], maxSuggestions) {
+		p := path.Join(dir, first)
+		// The rest of the path is followed as it is where it can be, and
+		// by its nearest match where it cannot.
+		ok := true
+		for _, part := range parts[i+1:] {
+			if !h.isDir(p) {
+				ok = false
+				break
+			}
+			if h.exists(path.Join(p, part)) {
+				p = path.Join(p, part)
+				continue
+			}
+			best := h.near(p, part, 1)
+			if len(best) == 0 {
+				ok = false
+				break
+			}
+			p = path.Join(p, best[0])
+		}
+		if ok {
+			if h.isDir(p) {
+				p += "/"
+			}
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (h suggestHandler) exists(name string) bool {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func (h suggestHandler) isDir(name string) bool {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	return err == nil && fi.IsDir()
+}
+
+// near returns up to n names of the entries of dir that are near
+// enough to name, nearest first.
+func (h suggestHandler) near(dir, name string, n int) []string {
+	f, err := h.fs.Open(dir)
+	if err != nil {
+		return nil
+	}
+	entries, err := f.Readdir(maxSuggestEntry + 1)
+	f.Close()
+	if err != nil && len(entries) == 0 || len(entries) > maxSuggestEntry {
+		return nil
+	}
+	type candidate struct {
+		name string
+		d    int
+	}
+	var found []candidate
+	for _, e := range entries {
+		if d, ok := nameDistance(name, e.Name()); ok {
+			found = append(found, candidate{e.Name(), d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].d != found[j].d {
+			return found[i].d < found[j].d
+		}
+		return found[i].name < found[j].name
+	})
+	var names []string
+	for _, c := range found[:min(n, len(found))] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// nameDistance reports how far apart the names want and have are, and
+// whether they are near enough to suggest one for the other. Names that
+// differ only in case are nearest, then those that differ only in
+// extension, then those a few edits apart.
+func nameDistance(want, have string) (int, bool) {
+	w, h := strings.ToLower(want), strings.ToLower(have)
+	if w == h {
+		return 0, true
+	}
+	if stem(w) == stem(h) && stem(w) != "" {
+		return 1, true
+	}
+	d := editDistance(w, h)
+	limit := 1
+	if n := min(len([]rune(w)), len([]rune(h))); n >= 5 {
+		limit = 2 + n/10
+	}
+	return d + 1, d <= limit
+}
+
+// stem returns name without its extension.
+func stem(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// editDistance returns the Levenshtein distance between a and b, in
+// runes, counting a swap of two neighbours as one edit.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
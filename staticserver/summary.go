@@ -0,0 +1,82 @@
+package staticserver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSummaryPaths bounds how many distinct paths a trafficSummary counts
+// separately, so a crawler cannot make it grow without limit.
+const maxSummaryPaths = 10000
+
+// trafficSummary tallies the requests served, to be printed when the
+// server shuts down.
+type trafficSummary struct {
+	start time.Time
+
+	mu       sync.Mutex
+	requests int
+	bytes    int64
+	paths    map[string]int
+	others   int // requests for paths past maxSummaryPaths
+	statuses map[int]int
+}
+
+// newTrafficSummary returns an empty summary starting now.
+func newTrafficSummary() *trafficSummary {
+	return &trafficSummary{start: time.Now(), paths: map[string]int{}, statuses: map[int]int{}}
+}
+
+// observe counts rr. It is an observer for observeHandler.
+func (t *trafficSummary) observe(rr *requestRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	t.bytes += rr.Bytes
+	t.statuses[rr.Status]++
+	if _, ok := t.paths[rr.Path]; ok || len(t.paths) < maxSummaryPaths {
+		t.paths[rr.Path]++
+	} else {
+		t.others++
+	}
+}
+
+// print writes the summary to w.
+func (t *trafficSummary) print(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(w, "up %s, served %d requests and %s bytes\n",
+		time.Since(t.start).Round(time.Second), t.requests, formatSize(t.bytes))
+
+	codes := make([]int, 0, len(t.statuses))
+	for code := range t.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %d: %d\n", code, t.statuses[code])
+	}
+
+	paths := make([]string, 0, len(t.paths))
+	for p := range t.paths {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if t.paths[paths[i]] != t.paths[paths[j]] {
+			return t.paths[paths[i]] > t.paths[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > 10 {
+		paths = paths[:10]
+	}
+	if len(paths) > 0 {
+		fmt.Fprintln(w, "top paths:")
+	}
+	for _, p := range paths {
+		fmt.Fprintf(w, "  %6d %s\n", t.paths[p], p)
+	}
+}
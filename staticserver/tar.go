@@ -0,0 +1,85 @@
+package staticserver
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// TarFS is an fs.FS of the files in a tar archive. Tar archives cannot
+// be read out of order, so the files are copied to a temporary file as
+// the archive is read, and served from there by an in-memory index.
+type TarFS struct {
+	*memTree
+	f *os.File
+}
+
+// tarData is where the data of a file is in the temporary file.
+type tarData struct {
+	off int64
+}
+
+// OpenTar reads the tar archive from r, gzipped or not, into a TarFS
+// to be set as the FS of a Config. It reads until the end of the
+// archive, so r may be standard input.
+func OpenTar(r io.Reader) (*TarFS, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	} else {
+		r = br
+	}
+
+	f, err := os.CreateTemp("", "static-server-tar-")
+	if err != nil {
+		return nil, err
+	}
+	t := &TarFS{f: f}
+	t.memTree = newMemTree(func(e *memEntry) (io.ReadSeeker, error) {
+		return io.NewSectionReader(f, e.src.(tarData).off, e.size), nil
+	})
+	var off int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			t.dir(name).modTime = hdr.ModTime
+		case tar.TypeReg:
+			n, err := io.Copy(f, tr)
+			if err != nil {
+				t.Close()
+				return nil, err
+			}
+			t.file(name, &memEntry{size: n, mode: fs.FileMode(hdr.Mode).Perm(), modTime: hdr.ModTime, src: tarData{off}})
+			off += n
+		}
+	}
+	return t, nil
+}
+
+// Close removes the temporary file.
+func (t *TarFS) Close() error {
+	t.f.Close()
+	return os.Remove(t.f.Name())
+}
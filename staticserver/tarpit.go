@@ -0,0 +1,167 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tarpitDrip is how often a held client is sent another byte.
+	tarpitDrip = 10 * time.Second
+	// tarpitHold is how long a client is held before its connection is
+	// closed.
+	tarpitHold = 30 * time.Minute
+	// tarpitFlag is how long a client stays flagged after it was caught.
+	tarpitFlag = time.Hour
+	// defaultTarpitConns is how many clients are held at once by default.
+	defaultTarpitConns = 64
+	// maxTarpitFlagged bounds the clients remembered as flagged.
+	maxTarpitFlagged = 10000
+)
+
+// tarpit answers the scanners that ask for a honeypot path or send a
+// User-Agent of its list, and every request they make for tarpitFlag
+// after, with a response that trickles out a byte every tarpitDrip for
+// tarpitHold, so that they wait on it rather than moving on to the next
+// server. At most conns are held at once, and those past it are refused
+// at once as banned clients are. Clients are told apart by clientKey.
+type tarpit struct {
+	paths  []string // the honeypot path patterns
+	agents []string // the lower case User-Agent words of scanners
+	slots  chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	flagged map[string]time.Time // until when each client key is flagged
+}
+
+// newTarpit returns the tarpit of cfg.
+func newTarpit(cfg Config) (*tarpit, error) {
+	t := &tarpit{done: make(chan struct{}), flagged: map[string]time.Time{}}
+	for _, p := range cfg.TarpitPaths {
+		if !validPathPattern(p) {
+			return nil, fmt.Errorf("invalid tarpit path %q, expected /path, /dir/ or a pattern like /*.php", p)
+		}
+		t.paths = append(t.paths, p)
+	}
+	for _, a := range cfg.TarpitAgents {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			t.agents = append(t.agents, a)
+		}
+	}
+	conns := cfg.TarpitConns
+	if conns == 0 {
+		conns = defaultTarpitConns
+	} else if conns < 0 {
+		return nil, fmt.Errorf("tarpit conns %d out of range, expected a positive number", conns)
+	}
+	t.slots = make(chan struct{}, conns)
+	return t, nil
+}
+
+// caught reports whether r, from the client key, is a scanner's: it
+// asks for a honeypot path, sends a listed User-Agent, or comes from a
+// client caught before. A client caught now is flagged.
+func (t *tarpit) caught(r *http.Request, key string) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until, ok := t.flagged[key]; ok {
+		if now.Before(until) {
+			return true
+		}
+		delete(t.flagged, key)
+	}
+	why := ""
+	if matchPaths(t.paths, r.URL.Path) {
+		why = "asked for " + r.URL.Path
+	} else if ua := strings.ToLower(r.UserAgent()); ua != "" {
+		for _, a := range t.agents {
+			if strings.Contains(ua, a) {
+				why = "sent User-Agent " + r.UserAgent()
+				break
+			}
+		}
+	}
+	if why == "" {
+		return false
+	}
+	if len(t.flagged) >= maxTarpitFlagged {
+		for k, until := range t.flagged {
+			if now.After(until) {
+				delete(t.flagged, k)
+			}
+		}
+	}
+	if len(t.flagged) < maxTarpitFlagged {
+		t.flagged[key] = now.Add(tarpitFlag)
+	}
+	infof("tarpit: caught %s, which %s", key, why)
+	return true
+}
+
+// close lets go of the clients held.
+func (t *tarpit) close() {
+	close(t.done)
+}
+
+// handler returns next with the requests of scanners held in the tarpit.
+func (t *tarpit) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(clientIP(r))
+		if !t.caught(r, key) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case t.slots <- struct{}{}:
+			defer func() { <-t.slots }()
+		default:
+			debugf("tarpit: full, refusing %s", key)
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		t.hold(w, r)
+	})
+}
+
+// hold sends r a page a byte at a time until tarpitHold passes, the
+// client gives up or the tarpit is closed.
+func (t *tarpit) hold(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(tarpitHold + tarpitDrip))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "close")
+	end := time.After(tarpitHold)
+	if r.Method == http.MethodHead {
+		// No body to trickle: hold back the headers instead.
+		select {
+		case <-end:
+		case <-r.Context().Done():
+		case <-t.done:
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	tick := time.NewTicker(tarpitDrip)
+	defer tick.Stop()
+	for {
+		if _, err := w.Write([]byte{' '}); err != nil || rc.Flush() != nil {
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-end:
+			return
+		case <-r.Context().Done():
+			return
+		case <-t.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package staticserver
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateHandler executes .tmpl files as html/template pages and
+// passes every other request to next. A request for a directory is
+// served its index.tmpl when it has one. The page is sent with the type
+// of the extension before .tmpl, or as HTML if there is none, so that
+// feed.xml.tmpl is served as XML. Pages can call asset with the path of
+// an asset to get the path of its fingerprinted copy, if there is one,
+// and integrity with the path of a file to get its Subresource Integrity
+// hash.
+type templateHandler struct {
+	fs       http.FileSystem
+	data     *siteData
+	manifest *assetManifest // what the asset function looks paths up in, if any
+	next     http.Handler
+}
+
+// TemplateRequest is the data a .tmpl page is executed with: the request
+// it answers and the site data file, if there is one.
+type TemplateRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Site   any // the decoded site data, nil if there is none
+}
+
+// ServeHTTP serves the requested page executed, or serves r with next.
+func (h templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.tmpl")
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || path.Ext(name) != ".tmpl" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	src, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	page, err := template.New(path.Base(name)).Funcs(template.FuncMap{
+		"asset":     h.manifest.lookup,
+		"integrity": func(p string) (string, error) { return integrity(h.fs, p) },
+	}).Parse(string(src))
+	if err != nil {
+		infof("warning: template: %v", err)
+		http.Error(w, "Error rendering file", http.StatusInternalServerError)
+		return
+	}
+	var out bytes.Buffer
+	err = page.Execute(&out, TemplateRequest{
+		Method: r.Method,
+		Host:   r.Host,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+		Site:   h.data.get(),
+	})
+	if err != nil {
+		infof("warning: template: %v", err)
+		http.Error(w, "Error rendering file", http.StatusInternalServerError)
+		return
+	}
+	ct := mime.TypeByExtension(path.Ext(strings.TrimSuffix(name, ".tmpl")))
+	if ct == "" {
+		ct = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	// The page depends on the request and the data, so there is no
+	// Last-Modified.
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(out.Bytes()))
+}
+
+// siteData is the JSON, YAML or TOML file whose content pages are executed
+// with as .Site. It is read again when it changes; a version that does
+// not parse is reported and the last good one kept.
+type siteData struct {
+	name string
+	kind string // what the file is, for warnings
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   any
+}
+
+// loadSiteData reads the site data file name.
+func loadSiteData(name string) (*siteData, error) {
+	d := &siteData{name: name, kind: "template data"}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.load(fi); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// load reads the file, which fi describes.
+func (d *siteData) load(fi os.FileInfo) error {
+	v, err := ReadDataFile(d.name)
+	if err != nil {
+		return err
+	}
+	d.modTime, d.size, d.value = fi.ModTime(), fi.Size(), v
+	return nil
+}
+
+// get returns the data, read again first if the file has changed. It
+// returns nil for a nil siteData.
+func (d *siteData) get() any {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := os.Stat(d.name)
+	if err != nil || fi.ModTime().Equal(d.modTime) && fi.Size() == d.size {
+		return d.value
+	}
+	if err := d.load(fi); err != nil {
+		infof("warning: %s: %v", d.kind, err)
+		// Do not report it again until the file changes again.
+		d.modTime, d.size = fi.ModTime(), fi.Size()
+	}
+	return d.value
+}
@@ -0,0 +1,154 @@
+package staticserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NetProfile describes a network to emulate: the latency added before
+// each response and the bandwidth, in bytes per second, that request
+// and response bodies are limited to.
+type NetProfile struct {
+	latency time.Duration
+	rate    int64
+}
+
+// netProfiles are the networks -simulate knows by name, after the
+// presets of browser developer tools.
+var netProfiles = map[string]NetProfile{
+	"2g":      {800 * time.Millisecond, 250_000 / 8},
+	"slow-3g": {2000 * time.Millisecond, 400_000 / 8},
+	"3g":      {560 * time.Millisecond, 1_600_000 / 8},
+	"4g":      {100 * time.Millisecond, 9_000_000 / 8},
+	"dsl":     {50 * time.Millisecond, 2_000_000 / 8},
+}
+
+// Set parses a -simulate profile, by name or as latency/rate
+// with the rate a size per second, like 300ms/64K.
+func (p *NetProfile) Set(s string) error {
+	if named, ok := netProfiles[strings.ToLower(s)]; ok {
+		*p = named
+		return nil
+	}
+	lat, rate, ok := strings.Cut(s, "/")
+	var err error
+	if ok {
+		if p.latency, err = time.ParseDuration(lat); err == nil {
+			p.rate, err = ParseSize(rate)
+		}
+	}
+	if !ok || err != nil || p.latency < 0 || p.rate <= 0 {
+		return fmt.Errorf("invalid network profile %q, expected %s or latency/rate like 300ms/64K", s, strings.Join(NetProfileNames(), ", "))
+	}
+	return nil
+}
+
+// NetProfileNames returns the names of the networks a NetProfile can be
+// set to, in order.
+func NetProfileNames() []string {
+	names := make([]string, 0, len(netProfiles))
+	for name := range netProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// throttleHandler serves requests with next as if over the network
+// described by profile.
+type throttleHandler struct {
+	profile NetProfile
+	next    http.Handler
+}
+
+// ServeHTTP waits out the latency and serves r at the profile's rate.
+func (h throttleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := time.NewTimer(h.profile.latency)
+	select {
+	case <-r.Context().Done():
+		t.Stop()
+		return
+	case <-t.C:
+	}
+	if r.Body != nil {
+		r.Body = &throttleReader{ReadCloser: r.Body, pace: newPace(h.profile.rate)}
+	}
+	h.next.ServeHTTP(&throttleWriter{ResponseWriter: w, pace: newPace(h.profile.rate)}, r)
+}
+
+// pace spaces out bytes to keep to a rate.
+type pace struct {
+	rate  int64
+	start time.Time
+	done  int64
+}
+
+func newPace(rate int64) *pace {
+	return &pace{rate: rate, start: time.Now()}
+}
+
+// chunk is how many bytes to move before the next wait, a tenth of a
+// second's worth.
+func (p *pace) chunk() int {
+	return int(max(p.rate/10, 1))
+}
+
+// wait sleeps until n more bytes are due.
+func (p *pace) wait(n int) {
+	p.done += int64(n)
+	due := p.start.Add(time.Duration(p.done * int64(time.Second) / p.rate))
+	time.Sleep(time.Until(due))
+}
+
+// throttleWriter sends the body of a response at a limited rate.
+type throttleWriter struct {
+	http.ResponseWriter
+	pace *pace
+}
+
+// Write sends b a chunk at a time, flushing each one so the client sees
+// it arrive at the rate.
+func (w *throttleWriter) Write(b []byte) (int, error) {
+	sent := 0
+	for len(b) > 0 {
+		n := min(len(b), w.pace.chunk())
+		n, err := w.ResponseWriter.Write(b[:n])
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		http.NewResponseController(w.ResponseWriter).Flush()
+		w.pace.wait(n)
+		b = b[n:]
+	}
+	return sent, nil
+}
+
+// ReadFrom copies through Write, so the body keeps to the rate.
+func (w *throttleWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, r)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *throttleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttleReader reads a request body at a limited rate.
+type throttleReader struct {
+	io.ReadCloser
+	pace *pace
+}
+
+func (r *throttleReader) Read(b []byte) (int, error) {
+	if len(b) > r.pace.chunk() {
+		b = b[:r.pace.chunk()]
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.pace.wait(n)
+	return n, err
+}
@@ -0,0 +1,402 @@
+package staticserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReadDataFile reads the JSON, YAML or TOML file name, by its extension,
+// and decodes it into an any. Tables and mappings are map[string]any and
+// arrays []any, as encoding/json decodes them, but where the numbers of
+// JSON are all float64 the integers of YAML and TOML are int64.
+func ReadDataFile(name string) (any, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		v, err = parseYAML(b)
+	case ".toml":
+		v, err = parseTOML(b)
+	default:
+		err = json.Unmarshal(b, &v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return v, nil
+}
+
+// The numbers of TOML, with the underscores between their digits
+// removed.
+var (
+	tomlInt   = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+	tomlPre   = regexp.MustCompile(`^(0x[0-9a-fA-F]+|0o[0-7]+|0b[01]+)$`)
+	tomlFloat = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// parseTOML parses the subset of TOML configuration files are usually
+// written in: tables, dotted keys, strings, numbers, booleans, arrays
+// and inline tables. Multi-line strings, dates and arrays of tables are
+// not supported.
+func parseTOML(src []byte) (map[string]any, error) {
+	if !utf8.Valid(src) {
+		return nil, fmt.Errorf("toml: not UTF-8")
+	}
+	p := &tomlParser{s: string(src), line: 1}
+	root := map[string]any{}
+	table := root
+	for {
+		p.skip(true)
+		if p.done() {
+			return root, nil
+		}
+		switch {
+		case strings.HasPrefix(p.s[p.i:], "[["):
+			return nil, p.errorf("arrays of tables are not supported")
+		case p.s[p.i] == '[':
+			p.i++
+			p.skip(false)
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if p.skip(false); p.done() || p.s[p.i] != ']' {
+				return nil, p.errorf("expected ] after the table name")
+			}
+			p.i++
+			if table, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		default:
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	s    string
+	i    int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("toml: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) done() bool { return p.i >= len(p.s) }
+
+// skip skips spaces and comments, and newlines too if newlines is set.
+func (p *tomlParser) skip(newlines bool) {
+	for !p.done() {
+		switch c := p.s[p.i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.i++
+		case c == '\n' && newlines:
+			p.i++
+			p.line++
+		case c == '#':
+			for !p.done() && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine expects the end of a line.
+func (p *tomlParser) endLine() error {
+	p.skip(false)
+	if !p.done() && p.s[p.i] != '\n' {
+		return p.errorf("unexpected %q", p.rest())
+	}
+	return nil
+}
+
+// rest returns what is left of the line, for errors.
+func (p *tomlParser) rest() string {
+	rest, _, _ := strings.Cut(p.s[p.i:], "\n")
+	return strings.TrimSpace(rest)
+}
+
+// table returns the table of root at the dotted keys, made if need be.
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	t := root
+	for _, k := range keys {
+		switch v := t[k].(type) {
+		case nil:
+			next := map[string]any{}
+			t[k] = next
+			t = next
+		case map[string]any:
+			t = v
+		default:
+			return nil, p.errorf("%s is not a table", strings.Join(keys, "."))
+		}
+	}
+	return t, nil
+}
+
+// key parses a key, which may be dotted.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skip(false)
+		if p.done() {
+			return nil, p.errorf("expected a key")
+		}
+		var k string
+		switch p.s[p.i] {
+		case '"', '\'':
+			v, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			k = v
+		default:
+			start := p.i
+			for !p.done() && isTOMLBare(p.s[p.i]) {
+				p.i++
+			}
+			if p.i == start {
+				return nil, p.errorf("expected a key, found %q", p.rest())
+			}
+			k = p.s[start:p.i]
+		}
+		keys = append(keys, k)
+		p.skip(false)
+		if p.done() || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func isTOMLBare(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keyValue parses key = value into t.
+func (p *tomlParser) keyValue(t map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.skip(false); p.done() || p.s[p.i] != '=' {
+		return p.errorf("expected = after %s", strings.Join(keys, "."))
+	}
+	p.i++
+	p.skip(false)
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	t, err = p.table(t, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := t[last]; ok {
+		return p.errorf("%s is defined twice", strings.Join(keys, "."))
+	}
+	t[last] = v
+	return nil
+}
+
+// value parses a value.
+func (p *tomlParser) value() (any, error) {
+	if p.done() {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.s[p.i]; {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	}
+	start := p.i
+	for !p.done() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.s[p.i])) {
+		p.i++
+	}
+	s := p.s[start:p.i]
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	if n, ok := tomlDigits(s); ok {
+		var v any
+		var err error
+		switch {
+		case tomlPre.MatchString(n):
+			v, err = strconv.ParseInt(n, 0, 64)
+		case tomlInt.MatchString(n):
+			v, err = strconv.ParseInt(n, 10, 64)
+		case tomlFloat.MatchString(n):
+			v, err = strconv.ParseFloat(n, 64)
+		default:
+			err = strconv.ErrSyntax
+		}
+		if err == nil {
+			return v, nil
+		}
+	}
+	if s == "" {
+		return nil, p.errorf("expected a value, found %q", p.rest())
+	}
+	return nil, p.errorf("unsupported value %q", s)
+}
+
+// tomlDigits returns s without the underscores of a number, and whether
+// each of them was between two digits, as TOML requires.
+func tomlDigits(s string) (string, bool) {
+	hex := strings.HasPrefix(s, "0x")
+	digit := func(i int) bool {
+		if i < 0 || i >= len(s) {
+			return false
+		}
+		c := s[i]
+		return '0' <= c && c <= '9' || hex && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F')
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && !(digit(i-1) && digit(i+1)) {
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(s, "_", ""), true
+}
+
+// str parses a basic or a literal string.
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.i]
+	if strings.HasPrefix(p.s[p.i:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	p.i++
+	var b strings.Builder
+	for {
+		if p.done() || p.s[p.i] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if p.done() {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.s[p.i]
+			p.i++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.i+n > len(p.s) {
+					return "", p.errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.i:p.i+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("invalid escape \\%c%s", e, p.s[p.i:p.i+n])
+				}
+				b.WriteRune(rune(r))
+				p.i += n
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// array parses an array, which may span lines.
+func (p *tomlParser) array() ([]any, error) {
+	p.i++
+	a := []any{}
+	for {
+		p.skip(true)
+		if p.done() {
+			return nil, p.errorf("unclosed array")
+		}
+		if p.s[p.i] == ']' {
+			p.i++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		p.skip(true)
+		if !p.done() && p.s[p.i] == ',' {
+			p.i++
+		} else if p.done() || p.s[p.i] != ']' {
+			return nil, p.errorf("expected , or ] in an array")
+		}
+	}
+}
+
+// inlineTable parses an inline table.
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.i++
+	t := map[string]any{}
+	for {
+		p.skip(false)
+		if p.done() || p.s[p.i] == '\n' {
+			return nil, p.errorf("unclosed inline table")
+		}
+		if p.s[p.i] == '}' {
+			p.i++
+			return t, nil
+		}
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if !p.done() && p.s[p.i] == ',' {
+			p.i++
+		} else if p.done() || p.s[p.i] != '}' {
+			return nil, p.errorf("expected , or } in an inline table")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package staticserver
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]any
+	}{
+		{"empty", "", map[string]any{}},
+		{"comments", "# one\n  # two\n", map[string]any{}},
+		{"key values", "a = 1\nb = \"two\"\nc = true\n", map[string]any{"a": int64(1), "b": "two", "c": true}},
+		{"trailing comment", "a = 'x' # note\n", map[string]any{"a": "x"}},
+		{"table", "[t]\na = 1\n[u.v]\nb = 2\n", map[string]any{"t": map[string]any{"a": int64(1)}, "u": map[string]any{"v": map[string]any{"b": int64(2)}}}},
+		{"dotted keys", "a.b = 1\na.c = 2\n", map[string]any{"a": map[string]any{"b": int64(1), "c": int64(2)}}},
+		{"quoted key", "\"a b\" = 1\n'c.d' = 2\n", map[string]any{"a b": int64(1), "c.d": int64(2)}},
+		{"array", "a = [1, \"b\", [2]]\n", map[string]any{"a": []any{int64(1), "b", []any{int64(2)}}}},
+		{"array across lines", "a = [\n  1, # one\n  2,\n]\n", map[string]any{"a": []any{int64(1), int64(2)}}},
+		{"inline table", "a = {b = 1, c.d = 'e'}\n", map[string]any{"a": map[string]any{"b": int64(1), "c": map[string]any{"d": "e"}}}},
+		{"escapes", `a = "tab\tquote\"\u00e9\U0001F600"` + "\n", map[string]any{"a": "tab\tquote\"é😀"}},
+		{"literal string", `a = 'C:\dir'` + "\n", map[string]any{"a": `C:\dir`}},
+		{"crlf", "a = 1\r\nb = 2\r\n", map[string]any{"a": int64(1), "b": int64(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(tt.src))
+			if err != nil {
+				t.Fatalf("parseTOML(%q): %v", tt.src, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML(%q) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"not utf-8", "a = \"\xff\"\n"},
+		{"defined twice", "a = 1\na = 2\n"},
+		{"value as table", "a = 1\n[a]\n"},
+		{"dotted through value", "a = 1\na.b = 2\n"},
+		{"missing equals", "a 1\n"},
+		{"missing value", "a =\n"},
+		{"two on a line", "a = 1 b = 2\n"},
+		{"unterminated string", "a = \"b\n"},
+		{"invalid escape", `a = "\q"` + "\n"},
+		{"invalid unicode escape", `a = "\uD800"` + "\n"},
+		{"short unicode escape", `a = "\u00"`},
+		{"multi-line string", "a = \"\"\"b\"\"\"\n"},
+		{"unclosed array", "a = [1, 2\n"},
+		{"array without commas", "a = [1 2]\n"},
+		{"unclosed inline table", "a = {b = 1\n"},
+		{"unclosed table header", "[a\n"},
+		{"array of tables", "[[a]]\n"},
+		{"bare word", "a = yes\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, err := parseTOML([]byte(tt.src)); err == nil {
+				t.Errorf("parseTOML(%q) = %#v, want an error", tt.src, v)
+			}
+		})
+	}
+}
+
+func TestParseTOMLNumbers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any // nil for an error
+	}{
+		{"0", int64(0)},
+		{"42", int64(42)},
+		{"+42", int64(42)},
+		{"-17", int64(-17)},
+		{"1_000", int64(1000)},
+		{"0xdead_BEEF", int64(0xdeadbeef)},
+		{"0o755", int64(0o755)},
+		{"0b1010", int64(10)},
+		{"3.5", 3.5},
+		{"-0.25", -0.25},
+		{"1e3", 1000.0},
+		{"6.25E-2", 0.0625},
+		{"1_0.5", 10.5},
+		{"inf", math.Inf(1)},
+		{"-inf", math.Inf(-1)},
+		{"0777", nil},
+		{"_1", nil},
+		{"1_", nil},
+		{"1__0", nil},
+		{"0x_1", nil},
+		{"+0x1", nil},
+		{"0o8", nil},
+		{"0b2", nil},
+		{"1.", nil},
+		{".5", nil},
+		{"1e", nil},
+		{"9223372036854775808", nil},
+		{"Inf", nil},
+	}
+	for _, tt := range tests {
+		src := "a = " + tt.in + "\n"
+		got, err := parseTOML([]byte(src))
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("parseTOML(%q) = %#v, want an error", src, got)
+		case tt.want != nil && err != nil:
+			t.Errorf("parseTOML(%q): %v", src, err)
+		case tt.want != nil && !reflect.DeepEqual(got["a"], tt.want):
+			t.Errorf("parseTOML(%q) = %#v, want %#v", src, got["a"], tt.want)
+		}
+	}
+	got, err := parseTOML([]byte("a = nan\n"))
+	if f, _ := got["a"].(float64); err != nil || !math.IsNaN(f) {
+		t.Errorf("parseTOML(%q) = %#v, %v, want NaN", "a = nan", got, err)
+	}
+}
@@ -0,0 +1,240 @@
+package staticserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceHandler records a span for every request and exports them to an
+// OpenTelemetry collector over OTLP/HTTP. It continues the trace named
+// by an incoming W3C traceparent header and passes its own span on as
+// the parent to proxied backends.
+type traceHandler struct {
+	exporter *spanExporter
+	next     http.Handler
+}
+
+// ServeHTTP serves r with h.next inside a span.
+func (h traceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traceID, parentID, sampled := parseTraceparent(r.Header.Get("Traceparent"))
+	if traceID == "" {
+		traceID, sampled = randomHex(16), true
+	}
+	spanID := randomHex(8)
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	r.Header.Set("Traceparent", "00-"+traceID+"-"+spanID+"-"+flags)
+
+	rec := &responseRecorder{ResponseWriter: w}
+	start := time.Now()
+	h.next.ServeHTTP(rec, r)
+	if !sampled {
+		return
+	}
+
+	status := rec.Status()
+	s := otlpSpan{
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentID,
+		Name:         r.Method,
+		Kind:         2, // server
+		Start:        strconv.FormatInt(start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttribute("http.request.method", r.Method),
+			stringAttribute("url.path", normalizePath(r.URL.Path)),
+			stringAttribute("server.address", r.Host),
+			stringAttribute("client.address", clientIP(r)),
+			stringAttribute("user_agent.original", r.UserAgent()),
+			intAttribute("http.response.status_code", int64(status)),
+			intAttribute("http.response.body.size", rec.bytes),
+		},
+	}
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		s.Attributes = append(s.Attributes, stringAttribute("http.request.id", id))
+	}
+	if status >= 500 {
+		s.Status.Code = 2 // error
+	}
+	h.exporter.add(s)
+}
+
+// parseTraceparent returns the trace and parent span IDs from a version
+// 00 traceparent header, and whether the caller sampled the trace. It
+// returns empty IDs if the header is missing or malformed.
+func parseTraceparent(v string) (traceID, parentID string, sampled bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || parts[0] != "00" || !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	return parts[1], parts[2], flags&1 == 1
+}
+
+// isHex reports whether s is n lower case hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// The otlp types are the parts of the OTLP/JSON trace encoding the
+// server sends. IDs are hex and 64 bit integers are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes"`
+		Status       struct {
+			Code int `json:"code,omitempty"`
+		} `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			String *string `json:"stringValue,omitempty"`
+			Int    *string `json:"intValue,omitempty"`
+		} `json:"value"`
+	}
+)
+
+func stringAttribute(key, v string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.String = &v
+	return a
+}
+
+func intAttribute(key string, v int64) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	s := strconv.FormatInt(v, 10)
+	a.Value.Int = &s
+	return a
+}
+
+// spanExporter batches spans and POSTs them to an OTLP/HTTP traces
+// endpoint every few seconds, or sooner when a batch fills up. Spans
+// are dropped rather than queued without bound when the collector
+// cannot keep up.
+type spanExporter struct {
+	endpoint string
+	service  string
+
+	mu    sync.Mutex
+	spans []otlpSpan
+	full  chan struct{}
+}
+
+const spanBatchSize = 512
+
+// newSpanExporter returns an exporter for endpoint and starts sending.
+func newSpanExporter(endpoint, service string) *spanExporter {
+	e := &spanExporter{endpoint: endpoint, service: service, full: make(chan struct{}, 1)}
+	go e.run()
+	return e
+}
+
+// add queues s for export.
+func (e *spanExporter) add(s otlpSpan) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) >= 4*spanBatchSize {
+		return
+	}
+	e.spans = append(e.spans, s)
+	if len(e.spans) == spanBatchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run sends the queued spans until the process exits.
+func (e *spanExporter) run() {
+	tick := time.NewTicker(5 * time.Second)
+	for {
+		select {
+		case <-tick.C:
+		case <-e.full:
+		}
+		e.flush()
+	}
+}
+
+// flush sends the queued spans.
+func (e *spanExporter) flush() {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpAttribute{stringAttribute("service.name", e.service)}
+	scope := otlpScopeSpans{Spans: spans}
+	scope.Scope.Name = "github.com/henderjon/static-server"
+	rs.ScopeSpans = []otlpScopeSpans{scope}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		log.Println("trace export:", err)
+		return
+	}
+	resp, err := webhookClient.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("trace export:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("trace export: %s responded %s", e.endpoint, resp.Status)
+	}
+}
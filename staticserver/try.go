@@ -0,0 +1,113 @@
+package staticserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// tryRule is an nginx style try_files chain. For requests under prefix
+// each candidate is tried in order, with $path replaced by the request
+// path, and the first one that exists is served in its place. A
+// candidate ending in a slash matches only a directory; any other
+// matches only a file. A final candidate of the form =code responds
+// with that status instead.
+type tryRule struct {
+	prefix     string
+	candidates []string
+}
+
+// TryRules is the set of rules given by repeated -try flags.
+type TryRules []tryRule
+
+// Set parses a rule of the form "prefix=candidate ..." and adds it.
+// It has the signature expected by flag.Func.
+func (t *TryRules) Set(s string) error {
+	prefix, chain, ok := strings.Cut(s, "=")
+	candidates := strings.Fields(chain)
+	if !ok || !strings.HasPrefix(prefix, "/") || len(candidates) == 0 {
+		return fmt.Errorf("invalid try rule %q, expected /prefix=candidate ...", s)
+	}
+	for i, c := range candidates {
+		if code, isCode := strings.CutPrefix(c, "="); isCode {
+			if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 || i != len(candidates)-1 {
+				return fmt.Errorf("invalid try rule %q, =code must be a status from 100 to 599 and come last", s)
+			}
+		}
+	}
+	*t = append(*t, tryRule{prefix: prefix, candidates: candidates})
+	return nil
+}
+
+// match returns the rule with the longest prefix matching p, or nil.
+func (t TryRules) match(p string) *tryRule {
+	var best *tryRule
+	for i, rule := range t {
+		if strings.HasPrefix(p, rule.prefix) && (best == nil || len(rule.prefix) > len(best.prefix)) {
+			best = &t[i]
+		}
+	}
+	return best
+}
+
+// tryHandler resolves requests through the matching tryRule, passing
+// them to next as requests for the first candidate found in fs, so that
+// a script is run and a page rendered as if it had been asked for.
+// Requests that match no rule, or for which no candidate exists, are
+// passed on to next unchanged.
+type tryHandler struct {
+	fs    http.FileSystem
+	rules TryRules
+	next  http.Handler
+}
+
+// ServeHTTP serves the first candidate of the matching rule that exists.
+func (h tryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	rule := h.rules.match(p)
+	if rule == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	for _, c := range rule.candidates {
+		if code, isCode := strings.CutPrefix(c, "="); isCode {
+			status, _ := strconv.Atoi(code)
+			debugf("try: %s: responding %d", p, status)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		wantDir := strings.HasSuffix(c, "/")
+		name := path.Clean("/" + strings.ReplaceAll(c, "$path", p))
+		f, err := h.fs.Open(name)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		f.Close()
+		if err != nil || fi.IsDir() != wantDir {
+			continue
+		}
+
+		debugf("try: %s: serving %s", p, name)
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = name
+		switch {
+		case wantDir && name != "/":
+			// Let the file server handle the index or listing.
+			r2.URL.Path = name + "/"
+		case path.Base(name) == "index.html":
+			// The file server redirects requests for index.html to
+			// their dir, which would be tried again.
+			r2.URL.Path = strings.TrimSuffix(name, "index.html")
+		}
+		r2.URL.RawPath = ""
+		h.next.ServeHTTP(w, r2)
+		return
+	}
+	debugf("try: %s: no candidate exists", p)
+	h.next.ServeHTTP(w, r)
+}
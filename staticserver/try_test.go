@@ -0,0 +1,67 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryRulesSet(t *testing.T) {
+	tests := []struct {
+		rule string
+		ok   bool
+	}{
+		{"/=$path $path/ /index.html", true},
+		{"/api/=$path =404", true},
+		{"/=$path =100", true},
+		{"/=$path =599", true},
+		{"/=$path =0", false},
+		{"/=$path =99", false},
+		{"/=$path =600", false},
+		{"/=$path =1000", false},
+		{"/=$path =x", false},
+		{"/==404 $path", false},
+		{"/=", false},
+		{"api=$path", false},
+		{"/api", false},
+	}
+	for _, tt := range tests {
+		var rules TryRules
+		if err := rules.Set(tt.rule); (err == nil) != tt.ok {
+			t.Errorf("Set(%q) = %v, want ok %v", tt.rule, err, tt.ok)
+		}
+	}
+}
+
+func TestTryHandler(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "docs"), 0o755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("app"), 0o644)
+	os.WriteFile(filepath.Join(dir, "page.html"), []byte("page"), 0o644)
+	var rules TryRules
+	rules.Set("/=$path $path.html $path/ /index.html")
+	rules.Set("/api/=$path =404")
+	tests := []struct {
+		path, want string // the path next is asked for, or "" for none
+		status     int
+	}{
+		{"/page.html", "/page.html", 200},
+		{"/page", "/page.html", 200},
+		{"/docs", "/docs/", 200},
+		{"/blog/hello", "/", 200},
+		{"/api/missing", "", 404},
+	}
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.URL.Path })
+	h := tryHandler{fs: http.Dir(dir), rules: rules, next: next}
+	for _, tt := range tests {
+		got = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got != tt.want || w.Code != tt.status {
+			t.Errorf("GET %s passed on %q with %d, want %q with %d", tt.path, got, w.Code, tt.want, tt.status)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package staticserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tusVersion is the version of the tus resumable upload protocol served.
+const tusVersion = "1.0.0"
+
+// tusExpiry is how long an upload in progress is kept after the last
+// byte of it arrived, and tusSweep how often expired ones are removed.
+const (
+	tusExpiry = 24 * time.Hour
+	tusSweep  = time.Hour
+)
+
+// tusHandler implements the core tus protocol, with the creation,
+// termination and expiration extensions, at prefix so that large
+// uploads interrupted by a flaky connection can resume where they left
+// off. Uploads in progress are kept in the hidden directory .tus in the
+// root of the store and moved into place under the filename from their
+// metadata, renamed if such a file already exists, once the last byte
+// arrives. Those abandoned are removed tusExpiry after they were last
+// written to.
+type tusHandler struct {
+	prefix string   // the URL path of the endpoint, with a trailing slash
+	store  *store   // where completed uploads are written
+	auth   Accounts // required of uploaders, if any are configured
+	done   chan struct{}
+
+	mu     sync.Mutex
+	active map[string]bool // uploads with a PATCH in progress
+}
+
+// newTusHandler returns the handler of tus uploads at prefix into
+// store, keeping the rest of the uploads left in progress reserved.
+func newTusHandler(prefix string, store *store, auth Accounts) *tusHandler {
+	h := &tusHandler{prefix: prefix, store: store, auth: auth, done: make(chan struct{}), active: map[string]bool{}}
+	names, _ := filepath.Glob(filepath.Join(store.root, ".tus", "*.json"))
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		info, err := h.info(id)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(h.file(id)); err == nil && fi.Size() <= info.Length {
+			store.release(fi.Size() - info.Length)
+		}
+	}
+	return h
+}
+
+// start begins removing expired uploads in the background.
+func (h *tusHandler) start() {
+	go h.run()
+}
+
+// close stops removing expired uploads.
+func (h *tusHandler) close() {
+	close(h.done)
+}
+
+// run removes the expired uploads every tusSweep until h is closed.
+func (h *tusHandler) run() {
+	tick := time.NewTicker(tusSweep)
+	defer tick.Stop()
+	for {
+		h.sweep()
+		select {
+		case <-h.done:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// sweep removes the uploads last written to over tusExpiry ago, and
+// the files of those half created.
+func (h *tusHandler) sweep() {
+	entries, err := os.ReadDir(filepath.Join(h.store.root, ".tus"))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || time.Since(fi.ModTime()) < tusExpiry {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".json")
+		if !h.begin(id) {
+			continue
+		}
+		if id == e.Name() {
+			if err := h.discard(id); err != nil {
+				// Without its info, what it held is counted afresh.
+				os.Remove(h.file(id))
+				h.store.recount()
+			}
+			debugf("tus: removed the expired upload %s", id)
+		} else if _, err := os.Stat(h.file(id)); errors.Is(err, fs.ErrNotExist) {
+			os.Remove(h.file(id) + ".json")
+		}
+		h.end(id)
+	}
+}
+
+// expires returns when the upload whose partial file is fi expires.
+func expires(fi fs.FileInfo) time.Time {
+	return fi.ModTime().Add(tusExpiry)
+}
+
+// tusInfo is what is known about an upload in progress. It is saved
+// beside the partial file as JSON.
+type tusInfo struct {
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// ServeHTTP serves the tus protocol.
+func (h *tusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination,expiration")
+		if h.store.maxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.store.maxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(h.auth) > 0 && !h.auth.authorize(w, r) {
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported Tus-Resumable version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, h.prefix)
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		h.create(w, r)
+	case id == "" || strings.ContainsAny(id, "/."):
+		http.NotFound(w, r)
+	case r.Method == http.MethodHead:
+		h.head(w, id)
+	case r.Method == http.MethodPatch:
+		h.patch(w, r, id)
+	case r.Method == http.MethodDelete:
+		if !h.begin(id) {
+			http.Error(w, "upload is being written to", http.StatusConflict)
+			return
+		}
+		defer h.end(id)
+		if err := h.discard(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// file returns the file system path of the partial file of upload id.
+func (h *tusHandler) file(id string) string {
+	return filepath.Join(h.store.root, ".tus", id)
+}
+
+// begin marks upload id as being written to, and reports whether it
+// was not already.
+func (h *tusHandler) begin(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active[id] {
+		return false
+	}
+	h.active[id] = true
+	return true
+}
+
+// end marks upload id as no longer being written to.
+func (h *tusHandler) end(id string) {
+	h.mu.Lock()
+	delete(h.active, id)
+	h.mu.Unlock()
+}
+
+// discard removes upload id, giving back the bytes it used and those
+// still reserved for it.
+func (h *tusHandler) discard(id string) error {
+	info, err := h.info(id)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(h.file(id))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(h.file(id)); err != nil {
+		return err
+	}
+	os.Remove(h.file(id) + ".json")
+	h.store.grow(-fi.Size())
+	h.store.release(info.Length - fi.Size())
+	return nil
+}
+
+// info reads the saved tusInfo of upload id.
+func (h *tusHandler) info(id string) (tusInfo, error) {
+	var info tusInfo
+	b, err := os.ReadFile(h.file(id) + ".json")
+	if err != nil {
+		return info, err
+	}
+	return info, json.Unmarshal(b, &info)
+}
+
+// create starts a new upload of the length given by Upload-Length.
+func (h *tusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	info := tusInfo{Length: length, Metadata: parseTusMetadata(r.Header.Get("Upload-Metadata"))}
+	name := info.Metadata["filename"]
+	if name == "" || isDotF(name) {
+		http.Error(w, "Upload-Metadata must include a filename", http.StatusBadRequest)
+		return
+	}
+	if h.store.exts != nil && !h.store.exts[strings.ToLower(path.Ext(name))] {
+		writeError(w, errExtension)
+		return
+	}
+	// The whole upload is reserved now, so that uploads started together
+	// cannot each fit and then fill the quota between them.
+	if err := h.store.reserve(length); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	meta, _ := json.Marshal(info)
+	err = os.MkdirAll(filepath.Dir(h.file(id)), 0o755)
+	if err == nil {
+		err = os.WriteFile(h.file(id)+".json", meta, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(h.file(id), nil, 0o644)
+	}
+	if err != nil {
+		os.Remove(h.file(id) + ".json")
+		h.store.release(length)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", h.prefix+id)
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Expires", time.Now().Add(tusExpiry).UTC().Format(http.TimeFormat))
+	if length == 0 {
+		if err := h.finish(id, info, clientIP(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports how much of upload id has been received.
+func (h *tusHandler) head(w http.ResponseWriter, id string) {
+	info, err := h.info(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fi, err := os.Stat(h.file(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if time.Now().After(expires(fi)) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.Header().Set("Upload-Expires", expires(fi).UTC().Format(http.TimeFormat))
+	w.Header().Set("Upload-Offset", strconv.FormatInt(fi.Size(), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends the request body to upload id at the offset given by
+// Upload-Offset, which must be the number of bytes received so far.
+func (h *tusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !h.begin(id) {
+		http.Error(w, "upload is already being written to", http.StatusConflict)
+		return
+	}
+	defer h.end(id)
+
+	info, err := h.info(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := os.OpenFile(h.file(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		writeError(w, err)
+		return
+	}
+	if time.Now().After(expires(fi)) {
+		f.Close()
+		h.discard(id)
+		http.Error(w, "upload has expired", http.StatusGone)
+		return
+	}
+	if offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64); err != nil || offset != fi.Size() {
+		f.Close()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(fi.Size(), 10))
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+
+	// Keep whatever arrived even if the connection drops part way, so
+	// the client can resume from there.
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, info.Length-fi.Size()))
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	h.store.grow(n)
+	h.store.release(n)
+	offset := fi.Size() + n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if offset < info.Length {
+		w.Header().Set("Upload-Expires", time.Now().Add(tusExpiry).UTC().Format(http.TimeFormat))
+	}
+	if copyErr != nil {
+		writeError(w, copyErr)
+		return
+	}
+	if offset == info.Length {
+		if err := h.finish(id, info, clientIP(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish moves the completed upload id, sent by client, into place.
+// A complete upload cannot be resumed, so it is discarded if it cannot
+// be moved.
+func (h *tusHandler) finish(id string, info tusInfo, client string) error {
+	name := h.store.unique("/" + path.Base(info.Metadata["filename"]))
+	_, err := h.store.adopt(h.file(id), name, client)
+	if err != nil && os.Remove(h.file(id)) == nil {
+		h.store.grow(-info.Length)
+	}
+	os.Remove(h.file(id) + ".json")
+	return err
+}
+
+// parseTusMetadata parses an Upload-Metadata header, a comma separated
+// list of keys each optionally followed by a space and a base64 value.
+func parseTusMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
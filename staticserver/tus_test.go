@@ -0,0 +1,139 @@
+package staticserver
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusRequest returns a tus request of method to target with body.
+func tusRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	return r
+}
+
+// tusCreate starts an upload of length bytes named name with h, and
+// returns its status and URL.
+func tusCreate(h http.Handler, name string, length int) (int, string) {
+	r := tusRequest("POST", "/files/", "")
+	r.Header.Set("Upload-Length", strconv.Itoa(length))
+	r.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Header().Get("Location")
+}
+
+// tusPatch sends body to the upload at loc from offset with h.
+func tusPatch(h http.Handler, loc string, offset int, body string) int {
+	r := tusRequest("PATCH", loc, body)
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestTusQuota(t *testing.T) {
+	s := &store{root: t.TempDir(), quota: 100}
+	h := newTusHandler("/files/", s, nil)
+
+	// The first upload reserves its length, which the second does not fit.
+	code, a := tusCreate(h, "a.bin", 60)
+	if code != http.StatusCreated {
+		t.Fatalf("create a = %d, want 201", code)
+	}
+	if code, _ := tusCreate(h, "b.bin", 60); code == http.StatusCreated {
+		t.Fatal("created b, which does not fit beside the reservation of a")
+	}
+	if code := tusPatch(h, a, 0, strings.Repeat("a", 30)); code != http.StatusNoContent {
+		t.Fatalf("patch a = %d, want 204", code)
+	}
+	if code := tusPatch(h, a, 30, strings.Repeat("a", 30)); code != http.StatusNoContent {
+		t.Fatalf("patch a = %d, want 204", code)
+	}
+	if !s.exists("/a.bin") {
+		t.Fatal("a.bin was not moved into place")
+	}
+	if used, _ := s.usage(); used != 60 || s.reserved != 0 {
+		t.Errorf("used %d and reserved %d after a, want 60 and 0", used, s.reserved)
+	}
+	if code, _ := tusCreate(h, "c.bin", 50); code == http.StatusCreated {
+		t.Fatal("created c, which does not fit beside a")
+	}
+
+	// Ending an upload gives back what it wrote and what it reserved.
+	code, d := tusCreate(h, "d.bin", 40)
+	if code != http.StatusCreated {
+		t.Fatalf("create d = %d, want 201", code)
+	}
+	tusPatch(h, d, 0, "dddd")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, tusRequest("DELETE", d, ""))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete d = %d, want 204", w.Code)
+	}
+	if used, _ := s.usage(); used != 60 || s.reserved != 0 {
+		t.Errorf("used %d and reserved %d after d, want 60 and 0", used, s.reserved)
+	}
+
+	// A restart keeps the rest of an upload in progress reserved.
+	if code, e := tusCreate(h, "e.bin", 40); code != http.StatusCreated {
+		t.Fatalf("create e = %d, want 201", code)
+	} else {
+		tusPatch(h, e, 0, "eeee")
+	}
+	s2 := &store{root: s.root, quota: 100}
+	newTusHandler("/files/", s2, nil)
+	if s2.reserved != 36 {
+		t.Errorf("reserved %d after a restart, want 36", s2.reserved)
+	}
+}
+
+func TestTusExpiry(t *testing.T) {
+	s := &store{root: t.TempDir(), quota: 100}
+	h := newTusHandler("/files/", s, nil)
+	_, a := tusCreate(h, "a.bin", 50)
+	_, b := tusCreate(h, "b.bin", 50)
+	tusPatch(h, a, 0, "aaaa")
+	id := strings.TrimPrefix(a, "/files/")
+	old := time.Now().Add(-tusExpiry - time.Minute)
+	os.Chtimes(h.file(id), old, old)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, tusRequest("HEAD", a, ""))
+	if w.Code != http.StatusGone {
+		t.Errorf("HEAD of an expired upload = %d, want 410", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, tusRequest("HEAD", b, ""))
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Expires") == "" {
+		t.Errorf("HEAD of an upload = %d with Upload-Expires %q, want 200 and a time", w.Code, w.Header().Get("Upload-Expires"))
+	}
+
+	h.sweep()
+	if _, err := os.Stat(h.file(id)); !os.IsNotExist(err) {
+		t.Errorf("expired upload still there: %v", err)
+	}
+	if _, err := os.Stat(h.file(id) + ".json"); !os.IsNotExist(err) {
+		t.Errorf("info of the expired upload still there: %v", err)
+	}
+	if _, err := os.Stat(h.file(strings.TrimPrefix(b, "/files/"))); err != nil {
+		t.Errorf("upload in progress removed: %v", err)
+	}
+	if used, _ := s.usage(); used != 0 || s.reserved != 50 {
+		t.Errorf("used %d and reserved %d after the sweep, want 0 and 50", used, s.reserved)
+	}
+}
+
+func TestTusNeedsAuth(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(Config{Dir: dir, Upload: dir, Tus: "/files/"}); err == nil {
+		t.Error("New accepted tus uploads without an auth account")
+	}
+}
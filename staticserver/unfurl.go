@@ -0,0 +1,336 @@
+package staticserver
+
+import (
+	"bytes"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // thumbnails of GIFs
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// thumbSize is the most width and height of the thumbnails of
+	// images in previews.
+	thumbSize = 600
+	// maxThumbPixels is the largest image a thumbnail is made of, beyond
+	// which its preview gets an icon. Decoding one takes four bytes a
+	// pixel.
+	maxThumbPixels = 16 << 20
+	// maxThumbs is how many thumbnails an unfurlHandler keeps.
+	maxThumbs = 256
+	// iconSize is the width and height of the icons of other files.
+	iconSize = 256
+)
+
+// unfurlers are the User-Agent words of the bots that fetch a link to
+// show a preview of it in a chat or a post.
+var unfurlers = []string{
+	"slackbot", "facebookexternalhit", "facebot", "twitterbot", "discordbot", "whatsapp",
+	"telegrambot", "linkedinbot", "skypeuripreview", "mattermost", "mastodon", "redditbot",
+	"embedly", "iframely", "pinterestbot", "vkshare", "microsoftpreview", "google-pagerenderer",
+}
+
+// isUnfurler reports whether ua is the User-Agent of a bot fetching a
+// preview.
+func isUnfurler(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, bot := range unfurlers {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// unfurlPage is the Open Graph page of a shared file.
+var unfurlPage = template.Must(template.New("unfurl").Parse(`<!doctype html>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="{{if .Thumbnail}}summary_large_image{{else}}summary{{end}}">
+<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>
+<p><a href="{{.URL}}">Open</a></p>
+`))
+
+// unfurlHandler answers the bots that fetch a share link to preview it
+// with a small page of Open Graph metadata, the file's name, type and
+// size, and an image: a thumbnail of an image or the icon of its type.
+// The image is at the link with ?og=image. Every other client gets
+// the file from next.
+type unfurlHandler struct {
+	fs     http.FileSystem
+	thumbs *thumbCache
+	next   http.Handler
+}
+
+// thumbing is held while a thumbnail is made, so that the images of
+// many requests are not decoded at once.
+var thumbing sync.Mutex
+
+// thumbCache holds the JPEG thumbnails of the images of an FS, so that
+// an image is decoded once until it changes. A nil thumbnail records
+// an image that has none.
+type thumbCache struct {
+	max    int
+	mu     sync.Mutex
+	thumbs map[thumbKey][]byte
+}
+
+type thumbKey struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+func newThumbCache(max int) *thumbCache {
+	return &thumbCache{max: max, thumbs: map[thumbKey][]byte{}}
+}
+
+// purge forgets every thumbnail.
+func (c *thumbCache) purge() {
+	c.mu.Lock()
+	clear(c.thumbs)
+	c.mu.Unlock()
+}
+
+// get returns the JPEG thumbnail of the image f at name, or nil if it
+// has none, making it unless it is held.
+func (c *thumbCache) get(name string, f http.File) []byte {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+	key := thumbKey{name, fi.ModTime(), fi.Size()}
+	c.mu.Lock()
+	thumb, ok := c.thumbs[key]
+	c.mu.Unlock()
+	if ok {
+		return thumb
+	}
+	thumbing.Lock()
+	defer thumbing.Unlock()
+	// Another request may have made it while this one waited.
+	c.mu.Lock()
+	thumb, ok = c.thumbs[key]
+	c.mu.Unlock()
+	if ok {
+		return thumb
+	}
+	if img := thumbnail(f); img != nil {
+		var buf bytes.Buffer
+		if jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}) == nil {
+			thumb = buf.Bytes()
+		}
+	}
+	c.mu.Lock()
+	if len(c.thumbs) >= c.max {
+		// Start over rather than track which thumbnails are used.
+		clear(c.thumbs)
+	}
+	c.thumbs[key] = thumb
+	c.mu.Unlock()
+	return thumb
+}
+
+// ServeHTTP serves the preview of r's shared file to bots, or serves r
+// with next.
+func (h unfurlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isShared(r) || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "User-Agent")
+	og := r.URL.Query().Get("og") == "image"
+	if !og && !isUnfurler(r.UserAgent()) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	ct := "inode/directory"
+	if !fi.IsDir() {
+		if ct = mime.TypeByExtension(path.Ext(name)); ct == "" {
+			ct = "application/octet-stream"
+		}
+	}
+	if og {
+		h.image(w, r, name, f, ct)
+		return
+	}
+	// The link asked for, before the share link was mapped to its path.
+	link := name
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		link = u.EscapedPath()
+	}
+	base := requestBase(r)
+	page := base.Scheme + "://" + base.Host + link
+	title, desc := path.Base(name), "Folder"
+	if fi.IsDir() {
+		if title == "/" {
+			title = r.Host
+		}
+	} else {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		desc = mediaType + ", " + formatSize(fi.Size())
+		if fi.Size() < 1024 {
+			desc += " bytes"
+		}
+	}
+	debugf("unfurl: previewing %s for %s", name, r.UserAgent())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	unfurlPage.Execute(w, struct {
+		Title, Description, URL, Image string
+		Thumbnail                      bool
+	}{title, desc, page, page + "?og=image", thumbnailable(ct)})
+}
+
+// thumbnailable reports whether the files of type ct get thumbnails.
+func thumbnailable(ct string) bool {
+	return ct == "image/jpeg" || ct == "image/png" || ct == "image/gif"
+}
+
+// image sends the image of the preview of f, of type ct: a JPEG
+// thumbnail if it is an image small enough to decode, or else a PNG
+// icon.
+func (h unfurlHandler) image(w http.ResponseWriter, r *http.Request, name string, f http.File, ct string) {
+	w.Header().Set("Cache-Control", "max-age=3600")
+	if thumbnailable(ct) {
+		if thumb := h.thumbs.get(name, f); thumb != nil {
+			w.Header().Set("Content-Type", "image/jpeg")
+			if r.Method != http.MethodHead {
+				w.Write(thumb)
+			}
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	if r.Method != http.MethodHead {
+		png.Encode(w, fileIcon(ct))
+	}
+}
+
+// thumbnail returns a copy of the image in f scaled to fit thumbSize,
+// over white, or nil if it cannot be decoded or is too large to.
+func thumbnail(f http.File) image.Image {
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbPixels {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbSize || h > thumbSize {
+		if w >= h {
+			w, h = thumbSize, max(1, h*thumbSize/b.Dx())
+		} else {
+			w, h = max(1, w*thumbSize/b.Dy()), thumbSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			dst.Set(x, y, averageColor(src, x0, y0, max(x1, x0+1), max(y1, y0+1)))
+		}
+	}
+	return dst
+}
+
+// averageColor returns the average over white of up to 4×4 pixels of
+// src spread over the rectangle x0,y0 to x1,y1.
+func averageColor(src image.Image, x0, y0, x1, y1 int) color.RGBA {
+	var r, g, b, n uint32
+	stepX, stepY := max(1, (x1-x0)/4), max(1, (y1-y0)/4)
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			cr, cg, cb, ca := src.At(x, y).RGBA()
+			r += cr + 0xffff - ca
+			g += cg + 0xffff - ca
+			b += cb + 0xffff - ca
+			n++
+		}
+	}
+	return color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), 0xff}
+}
+
+// iconColor returns the color of the icons of the files of type ct.
+func iconColor(ct string) color.RGBA {
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	switch {
+	case mediaType == "inode/directory":
+		return color.RGBA{0xe8, 0xb3, 0x39, 0xff}
+	case strings.HasPrefix(mediaType, "image/"):
+		return color.RGBA{0x3b, 0xa5, 0x5c, 0xff}
+	case strings.HasPrefix(mediaType, "video/"):
+		return color.RGBA{0xd6, 0x45, 0x45, 0xff}
+	case strings.HasPrefix(mediaType, "audio/"):
+		return color.RGBA{0x8e, 0x5b, 0xd4, 0xff}
+	case mediaType == "application/pdf":
+		return color.RGBA{0xc0, 0x39, 0x2b, 0xff}
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "json"), strings.HasSuffix(mediaType, "xml"), strings.HasSuffix(mediaType, "javascript"):
+		return color.RGBA{0x35, 0x7a, 0xd6, 0xff}
+	case strings.Contains(mediaType, "zip"), strings.Contains(mediaType, "tar"), strings.Contains(mediaType, "compress"), strings.Contains(mediaType, "zstd"), strings.Contains(mediaType, "rar"), strings.Contains(mediaType, "7z"), strings.Contains(mediaType, "xz"):
+		return color.RGBA{0xa0, 0x6a, 0x3c, 0xff}
+	}
+	return color.RGBA{0x7a, 0x85, 0x93, 0xff}
+}
+
+// fileIcon draws the icon of the files of type ct: a folder for a dir,
+// else a page with a band of the color of its type.
+func fileIcon(ct string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, iconSize, iconSize))
+	fill := func(x0, y0, x1, y1 int, c color.Color) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	fill(0, 0, iconSize, iconSize, color.RGBA{0xf3, 0xf4, 0xf6, 0xff})
+	c := iconColor(ct)
+	if ct == "inode/directory" {
+		fill(40, 64, 112, 88, c)
+		fill(40, 80, 216, 200, c)
+		return img
+	}
+	fill(68, 36, 188, 220, c)
+	fill(72, 40, 184, 164, color.White)
+	gray := color.RGBA{0xd1, 0xd5, 0xdb, 0xff}
+	fill(92, 68, 164, 78, gray)
+	fill(92, 92, 164, 102, gray)
+	fill(92, 116, 140, 126, gray)
+	return img
+}
@@ -0,0 +1,69 @@
+package staticserver
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+//go:generate go run gen_unicode.go UnicodeData.txt
+
+// Hangul syllables decompose algorithmically rather than by table.
+const (
+	hangulBase  = 0xAC00
+	hangulL     = 0x1100
+	hangulV     = 0x1161
+	hangulT     = 0x11A7
+	hangulVN    = 21
+	hangulTN    = 28
+	hangulCount = 19 * hangulVN * hangulTN
+)
+
+// nfd returns s in Unicode Normalization Form D, in which precomposed
+// characters such as é are decomposed into a base character followed by
+// its combining marks in canonical order. Macs name files in a form close
+// to this while most other systems and browsers use the composed form,
+// so comparing names in NFD finds a file however its name was composed.
+func nfd(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	rs := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= hangulBase && r < hangulBase+hangulCount:
+			i := r - hangulBase
+			rs = append(rs, hangulL+i/(hangulVN*hangulTN), hangulV+i%(hangulVN*hangulTN)/hangulTN)
+			if t := i % hangulTN; t != 0 {
+				rs = append(rs, hangulT+t)
+			}
+		case decompositions[r] != "":
+			rs = append(rs, []rune(decompositions[r])...)
+		default:
+			rs = append(rs, r)
+		}
+	}
+
+	// Put each run of combining marks into canonical order.
+	for i := 0; i < len(rs); {
+		if combiningClasses[rs[i]] == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && combiningClasses[rs[j]] != 0 {
+			j++
+		}
+		run := rs[i:j]
+		sort.SliceStable(run, func(a, b int) bool { return combiningClasses[run[a]] < combiningClasses[run[b]] })
+		i = j
+	}
+	return string(rs)
+}
@@ -0,0 +1,163 @@
+package staticserver
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// uploadForm is the page served for GET requests to the upload endpoint,
+// and again after a POST with the outcome of each file.
+var uploadForm = template.Must(template.New("upload").Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>Upload</title>
+<h1>Upload files</h1>
+{{range .}}<p>{{.}}</p>
+{{end}}<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple>
+<button type="submit">Upload</button>
+</form>
+`))
+
+// uploadHandler accepts files uploaded from a browser as multipart form
+// data and writes them into the root of its store, or into the dir of it
+// named by the dir query parameter, as listings send it. When a file of the
+// same name already exists, overwrite says whether to "replace" it,
+// "rename" the upload with a numbered suffix, or "deny" the upload.
+// Uploads into a dir must give what its password and config files ask
+// for, as requests for its files do.
+type uploadHandler struct {
+	store     *store
+	auth      Accounts // required of uploaders, if any are configured
+	overwrite string
+	configs   *dirConfigs   // the config files of the dirs of the store, if they apply
+	passwords *dirPasswords // the password files of the dirs of the store, if they apply
+}
+
+// UploadOverwrites are the values of Config.UploadOverwrite.
+var UploadOverwrites = []string{"replace", "rename", "deny"}
+
+// setOverwrite validates and stores the -upload-overwrite policy.
+func (h *uploadHandler) setOverwrite(s string) error {
+	if slices.Contains(UploadOverwrites, s) {
+		h.overwrite = s
+		return nil
+	}
+	return fmt.Errorf("invalid overwrite policy %q, expected replace, rename or deny", s)
+}
+
+// ServeHTTP serves the upload form and stores the files POSTed to it.
+func (h *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(h.auth) > 0 && !h.auth.authorize(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		uploadForm.Execute(w, nil)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dir := path.Clean("/" + r.URL.Query().Get("dir"))
+	if !h.store.isDir(dir) {
+		http.Error(w, "no dir "+dir+" to upload to", http.StatusNotFound)
+		return
+	}
+	if !h.admit(w, r, dir) {
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var results []string
+	status := http.StatusOK
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		name, err := h.save(part, dir, clientIP(r))
+		part.Close()
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				status = http.StatusConflict
+			} else if status = storeErrorStatus(err); status == http.StatusInternalServerError {
+				log.Println("upload:", err)
+			}
+			results = append(results, fmt.Sprintf("%s: %s", part.FileName(), err))
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s: saved as %s", part.FileName(), name))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	uploadForm.Execute(w, results)
+}
+
+// admit reports whether r gave the passwords and accounts the password
+// and config files of dir ask for, answering it if it did not.
+func (h *uploadHandler) admit(w http.ResponseWriter, r *http.Request, dir string) bool {
+	if h.passwords != nil {
+		admitted := false
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = dir
+		dirPasswordHandler{passwords: h.passwords, next: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			admitted = true
+		})}.ServeHTTP(w, r2)
+		if !admitted {
+			return false
+		}
+	}
+	if h.configs != nil {
+		c, err := h.configs.lookup(dir, true)
+		if err != nil {
+			dirFileError(w, "upload", DirConfigName, err)
+			return false
+		}
+		if len(c.auth) > 0 && !c.auth.authorize(w, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the file in part, sent by client, to dir in the store
+// under its base name, applying the overwrite policy, and returns the
+// name it used, relative to dir.
+func (h *uploadHandler) save(part *multipart.Part, dir, client string) (string, error) {
+	base := path.Base(strings.ReplaceAll(part.FileName(), `\`, "/"))
+	if base == "." || base == ".." || base == "/" {
+		return "", errOutsideRoot
+	}
+	name := path.Join(dir, base)
+	switch {
+	case h.overwrite == "deny" && h.store.exists(name):
+		return "", os.ErrExist
+	case h.overwrite == "rename":
+		name = h.store.unique(name)
+	}
+	_, err := h.store.put(name, part, client)
+	return path.Base(name), err
+}
@@ -0,0 +1,108 @@
+package staticserver
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploadRequest returns a POST of a file named name to the upload
+// endpoint, into the dir dir.
+func uploadRequest(t *testing.T, dir, name string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("uploaded"))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/upload?dir="+url.QueryEscape(dir), &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUploadDirGuards(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"open", "locked/sub", "private"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash, err := bcryptHash([]byte("secret"), make([]byte, 16), bcryptMinCost, "2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"locked/" + DirPasswordName: hash + "\n",
+		"private/" + DirConfigName:  `auth = ["bob:pw"]` + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(Config{Dir: root, Upload: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		dir    string
+		user   string
+		status int
+	}{
+		{"/open", "", http.StatusOK},
+		{"/locked", "", http.StatusUnauthorized},
+		{"/locked/sub", "", http.StatusUnauthorized},
+		{"/private", "", http.StatusUnauthorized},
+		{"/private", "bob:wrong", http.StatusUnauthorized},
+		{"/private", "bob:pw", http.StatusOK},
+	}
+	for i, tt := range tests {
+		name := "f" + string(rune('a'+i)) + ".txt"
+		r := uploadRequest(t, tt.dir, name)
+		if user, pass, ok := strings.Cut(tt.user, ":"); ok {
+			r.SetBasicAuth(user, pass)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("upload to %s as %q = %d, want %d", tt.dir, tt.user, w.Code, tt.status)
+		}
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(tt.dir), name))
+		if saved := err == nil; saved != (tt.status == http.StatusOK) {
+			t.Errorf("upload to %s as %q: saved = %v, want %v", tt.dir, tt.user, saved, !saved)
+		}
+	}
+
+	// The password of the dir, given on the page the upload is refused
+	// with, lets the uploads after it in.
+	r := httptest.NewRequest("POST", "/upload?dir=/locked", strings.NewReader("password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("password for /locked = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	r = uploadRequest(t, "/locked/sub", "in.txt")
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("upload to /locked/sub with the password = %d, want 200", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "locked", "sub", "in.txt")); err != nil {
+		t.Error(err)
+	}
+}
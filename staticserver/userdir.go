@@ -0,0 +1,128 @@
+package staticserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultUserDirName is the dir in a user's dir that is served as their
+// site.
+const defaultUserDirName = "public_html"
+
+// userDirs serves the sites of the users whose dirs are under base: the
+// public_html dir of base/alice at /~alice/ and, with a host, at
+// alice.host too. Each site is served apart from the others, as a tree
+// of its own: its DirConfigName files set its listing, index names,
+// headers and accounts, its dot files are hidden, and symlinks out of it
+// are not followed. Users are discovered as their requests arrive, so
+// that a new one is served without a restart.
+type userDirs struct {
+	base  string
+	name  string // the dir in a user's dir served
+	host  string // the host whose subdomains serve the sites, if any
+	opts  serveOptions
+	next  http.Handler // serves every other request
+	mu    sync.Mutex
+	sites map[string]*userSite
+}
+
+type userSite struct {
+	root    *os.Root
+	handler http.Handler
+}
+
+func newUserDirs(base, name, host string, opts serveOptions, next http.Handler) *userDirs {
+	if name == "" {
+		name = defaultUserDirName
+	}
+	// Sites are read-only and link to nothing outside themselves.
+	opts.search, opts.upload = "", ""
+	opts.put, opts.delete, opts.php, opts.shareKey = false, false, nil, nil
+	opts.dirConf, opts.dirPass = true, true
+	return &userDirs{base: base, name: name, host: canonicalHost(host), opts: opts, next: next, sites: map[string]*userSite{}}
+}
+
+// ServeHTTP serves r from the site of the user it is for, if any.
+func (u *userDirs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, rest, ok := "", "", false
+	if host := canonicalHost(r.Host); u.host != "" && strings.HasSuffix(host, "."+u.host) {
+		user, rest, ok = strings.TrimSuffix(host, "."+u.host), r.URL.Path, true
+	} else if after, found := strings.CutPrefix(r.URL.Path, "/~"); found {
+		user, rest, ok = after, "/", true
+		if i := strings.IndexByte(after, '/'); i >= 0 {
+			user, rest = after[:i], after[i:]
+		} else {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+	}
+	if !ok {
+		u.next.ServeHTTP(w, r)
+		return
+	}
+	site := u.site(user)
+	if site == nil {
+		debugf("userdir: no site for %q", user)
+		http.NotFound(w, r)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path, r2.URL.RawPath = rest, ""
+	site.handler.ServeHTTP(w, r2)
+}
+
+// site returns the site of user, or nil if the user has none.
+func (u *userDirs) site(user string) *userSite {
+	if user == "" || strings.HasPrefix(user, ".") || strings.ContainsAny(user, `/\:`) {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if s, ok := u.sites[user]; ok {
+		return s
+	}
+	dir := filepath.Join(u.base, user, u.name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil
+	}
+	debugf("userdir: serving %s for %q", dir, user)
+	s := &userSite{root: root, handler: u.opts.files(userFS{http.FS(root.FS())})}
+	u.sites[user] = s
+	return s
+}
+
+// userFS is the file system of a site, which refuses the files it
+// cannot open, such as those of symlinks out of the site, with 403
+// Forbidden rather than failing with 500 Internal Server Error.
+type userFS struct {
+	http.FileSystem
+}
+
+// Open opens name, refusing it if it cannot be opened but exists.
+func (fsys userFS) Open(name string) (http.File, error) {
+	f, err := fsys.FileSystem.Open(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) {
+		debugf("userdir: refusing %s: %v", name, err)
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f, err
+}
+
+// close closes the dirs of the sites.
+func (u *userDirs) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for user, s := range u.sites {
+		s.root.Close()
+		delete(u.sites, user)
+	}
+}
@@ -0,0 +1,107 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUserDirs(t *testing.T) {
+	site, base, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	hash, err := bcryptHash([]byte("secret"), make([]byte, 16), bcryptMinCost, "2b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(site, "main.txt"):                                        "main site",
+		filepath.Join(base, "alice", "notes.txt"):                              "not public",
+		filepath.Join(base, "alice", "public_html", "index.html"):              "alice's site",
+		filepath.Join(base, "alice", "public_html", "page.txt"):                "alice's page",
+		filepath.Join(base, "alice", "public_html", ".secret"):                 "hidden",
+		filepath.Join(base, "alice", "public_html", "priv", DirConfigName):     `auth = ["bob:pw"]` + "\n",
+		filepath.Join(base, "alice", "public_html", "priv", "a.txt"):           "private",
+		filepath.Join(base, "alice", "public_html", "locked", DirPasswordName): hash + "\n",
+		filepath.Join(base, "alice", "public_html", "locked", "a.txt"):         "locked",
+		filepath.Join(base, "bob", "notes.txt"):                                "no site",
+		filepath.Join(outside, "secret.txt"):                                   "outside",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlinks := os.Symlink(outside, filepath.Join(base, "alice", "public_html", "out")) == nil
+	if symlinks {
+		os.Symlink(filepath.Join(base, "alice", "notes.txt"), filepath.Join(base, "alice", "public_html", "notes.txt"))
+	}
+	auth := Accounts{}
+	if err := auth.Set("admin:pw"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Dir: site, UserDirs: base, UserHost: "users.example.com", Auth: auth, Put: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		host    string
+		path    string
+		symlink bool
+		status  int
+		body    string
+	}{
+		{"", "/main.txt", false, http.StatusOK, "main site"},
+		{"", "/~alice", false, http.StatusMovedPermanently, ""},
+		{"", "/~alice/", false, http.StatusOK, "alice's site"},
+		{"", "/~alice/page.txt", false, http.StatusOK, "alice's page"},
+		{"alice.users.example.com", "/page.txt", false, http.StatusOK, "alice's page"},
+		{"ALICE.Users.Example.com.", "/page.txt", false, http.StatusOK, "alice's page"},
+		{"", "/~alice/.secret", false, http.StatusForbidden, ""},
+		{"", "/~alice/../alice/notes.txt", false, http.StatusNotFound, ""},
+		{"", "/~alice/%2e%2e/notes.txt", false, http.StatusNotFound, ""},
+		{"", "/~alice/out/secret.txt", true, http.StatusForbidden, ""},
+		{"", "/~alice/notes.txt", true, http.StatusForbidden, ""},
+		{"", "/~alice/priv/a.txt", false, http.StatusUnauthorized, ""},
+		{"", "/~alice/locked/a.txt", false, http.StatusUnauthorized, ""},
+		{"", "/~bob/", false, http.StatusNotFound, ""},
+		{"", "/~carol/", false, http.StatusNotFound, ""},
+		{"", "/~.alice/", false, http.StatusNotFound, ""},
+		{"", "/~../", false, http.StatusNotFound, ""},
+		{"bob.users.example.com", "/notes.txt", false, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		if tt.symlink && !symlinks {
+			continue
+		}
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.host != "" {
+			r.Host = tt.host
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("GET %s%s = %d, want %d", tt.host, tt.path, w.Code, tt.status)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("GET %s%s: body %q, want %q", tt.host, tt.path, w.Body.String(), tt.body)
+		}
+		if strings.Contains(w.Body.String(), "outside") || strings.Contains(w.Body.String(), "not public") {
+			t.Errorf("GET %s%s served a file outside the site: %q", tt.host, tt.path, w.Body.String())
+		}
+	}
+
+	// Sites are read-only, even when the main site takes writes.
+	r := httptest.NewRequest("PUT", "/~alice/new.txt", strings.NewReader("written"))
+	r.SetBasicAuth("admin", "pw")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	if _, err := os.Stat(filepath.Join(base, "alice", "public_html", "new.txt")); err == nil {
+		t.Error("PUT wrote into a site")
+	}
+}
@@ -0,0 +1,200 @@
+package staticserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// versionsPrefix is the path prefix the versions are served under,
+	// by id, and listed at.
+	versionsPrefix = "/_v/"
+	// versionsCheck is how long the list of versions is kept before the
+	// dir is read again.
+	versionsCheck = 2 * time.Second
+)
+
+// isVersion reports whether name is the name of a version dir: it
+// begins with a digit, as dates, timestamps and release numbers do, or
+// with a v and a digit.
+func isVersion(name string) bool {
+	name = strings.TrimPrefix(name, "v")
+	return name != "" && '0' <= name[0] && name[0] <= '9'
+}
+
+// compareVersions orders version names with their runs of digits
+// compared as numbers, so that 1.10 comes after 1.9.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+		if da != db {
+			return cmp.Compare(a[0], b[0])
+		}
+		i, j := runEnd(a, da), runEnd(b, db)
+		if da {
+			na, nb := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(a[:i], b[:j]); c != 0 {
+			return c
+		}
+		a, b = a[i:], b[j:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// runEnd returns the end of the run of digits, or of other bytes, that
+// s begins with.
+func runEnd(s string, digits bool) int {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return i
+}
+
+// versionsHandler serves the newest of the version dirs of a root, such
+// as dated releases of docs, and every other one at versionsPrefix and
+// its id, or with ?version= and its id, so that old versions stay at
+// addresses of their own. versionsPrefix itself lists the versions as
+// JSON. The dir is read again once versionsCheck has passed, so a new
+// version is served as soon as it is there.
+type versionsHandler struct {
+	root  string
+	serve func(dir string) http.Handler
+
+	mu       sync.Mutex
+	checked  time.Time
+	ids      []string // newest first
+	handlers map[string]http.Handler
+}
+
+func newVersionsHandler(root string, serve func(dir string) http.Handler) *versionsHandler {
+	return &versionsHandler{root: root, serve: serve, handlers: map[string]http.Handler{}}
+}
+
+// purge forgets the list of versions, so that the dir is read again.
+func (h *versionsHandler) purge() {
+	h.mu.Lock()
+	h.checked = time.Time{}
+	h.mu.Unlock()
+}
+
+// versions returns the ids of the versions, newest first.
+func (h *versionsHandler) versions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) < versionsCheck {
+		return h.ids
+	}
+	h.checked = time.Now()
+	entries, err := os.ReadDir(h.root)
+	if err != nil {
+		infof("warning: versions: %v", err)
+		return h.ids
+	}
+	var ids []string
+	for _, e := range entries {
+		if isVersion(e.Name()) && (e.IsDir() || e.Type()&os.ModeSymlink != 0) {
+			ids = append(ids, e.Name())
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int { return compareVersions(b, a) })
+	for id := range h.handlers {
+		if !slices.Contains(ids, id) {
+			delete(h.handlers, id)
+		}
+	}
+	h.ids = ids
+	return ids
+}
+
+// handler returns the handler of the version id, or nil if there is no
+// such version.
+func (h *versionsHandler) handler(id string) http.Handler {
+	if !slices.Contains(h.versions(), id) {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	vh, ok := h.handlers[id]
+	if !ok {
+		vh = h.serve(filepath.Join(h.root, id))
+		h.handlers[id] = vh
+	}
+	return vh
+}
+
+// ServeHTTP serves r from the version it asks for, or the newest.
+func (h *versionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == versionsPrefix || r.URL.Path == strings.TrimSuffix(versionsPrefix, "/") {
+		h.list(w, r)
+		return
+	}
+	id, pinned, p := "", false, r.URL.Path
+	r2 := r.Clone(r.Context())
+	if rest, ok := strings.CutPrefix(r.URL.Path, versionsPrefix); ok {
+		var sub string
+		id, sub, pinned = strings.Cut(rest, "/")
+		if !pinned {
+			http.Redirect(w, r, id+"/", http.StatusMovedPermanently)
+			return
+		}
+		p = "/" + sub
+		r2.URL.Path, r2.URL.RawPath = p, ""
+	} else if q := r.URL.Query(); q.Has("version") {
+		id, pinned = q.Get("version"), true
+		q.Del("version")
+		r2.URL.RawQuery = q.Encode()
+	} else if ids := h.versions(); len(ids) > 0 {
+		id = ids[0]
+	}
+	vh := h.handler(id)
+	if vh == nil {
+		if pinned {
+			http.Error(w, "no version "+strconv.Quote(id), http.StatusNotFound)
+		} else {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	// Where this version of the resource stays.
+	w.Header().Set("Content-Location", (&url.URL{Path: versionsPrefix + id + p}).EscapedPath())
+	vh.ServeHTTP(w, r2)
+}
+
+// list answers r with the JSON list of the versions, newest first.
+func (h *versionsHandler) list(w http.ResponseWriter, r *http.Request) {
+	ids := h.versions()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	out := struct {
+		Latest   string   `json:"latest"`
+		Versions []string `json:"versions"`
+	}{Versions: ids}
+	if out.Versions == nil {
+		out.Versions = []string{}
+	}
+	if len(ids) > 0 {
+		out.Latest = ids[0]
+	}
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,48 @@
+package staticserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// VHosts maps a host name to the document root served for it.
+// It is populated by repeated -vhost flags of the form host=dir.
+type VHosts map[string]string
+
+// Set parses a single host=dir pair and adds it to the map.
+// It has the signature expected by flag.Func.
+func (v VHosts) Set(s string) error {
+	host, dir, ok := strings.Cut(s, "=")
+	if !ok || host == "" || dir == "" {
+		return fmt.Errorf("invalid vhost %q, expected host=dir", s)
+	}
+	v[canonicalHost(host)] = dir
+	return nil
+}
+
+// vhostHandler is an http.Handler that selects the handler for a request
+// by its Host header, falling back to def for hosts it does not know.
+type vhostHandler struct {
+	hosts map[string]http.Handler
+	def   http.Handler
+}
+
+// ServeHTTP dispatches r to the handler registered for its host.
+func (v vhostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := v.hosts[canonicalHost(r.Host)]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	v.def.ServeHTTP(w, r)
+}
+
+// canonicalHost lowercases host and strips any port and trailing dot
+// so that "Example.com:8080" and "example.com." select the same vhost.
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,148 @@
+package staticserver
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileChange is a change to a file or directory under a watched dir.
+type fileChange struct {
+	Event string `json:"event"` // created, modified or deleted
+	Dir   string `json:"-"`     // the watched dir
+	Path  string `json:"path"`  // the slash separated path within Dir, with a leading slash
+}
+
+// dirWatcher reports the changes made to the files under some dirs to
+// its subscribers. There is no portable file notification API in the
+// standard library, so the dirs are polled. Dot files, which are never
+// served, are not watched.
+type dirWatcher struct {
+	dirs     []string
+	interval time.Duration
+	done     chan struct{}
+
+	mu          sync.Mutex
+	subscribers []func([]fileChange)
+}
+
+// fileKey identifies a file by its watched dir and path within it.
+type fileKey struct {
+	dir, path string
+}
+
+// fileStamp is what a scan notes about a file to detect changes.
+type fileStamp struct {
+	size  int64
+	mod   time.Time
+	isDir bool
+}
+
+// newDirWatcher returns a watcher of dirs that polls every interval
+// once it is started.
+func newDirWatcher(dirs []string, interval time.Duration) *dirWatcher {
+	return &dirWatcher{dirs: dirs, interval: interval, done: make(chan struct{})}
+}
+
+// subscribe has f called with every batch of changes found.
+func (w *dirWatcher) subscribe(f func([]fileChange)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, f)
+}
+
+// start begins polling in the background.
+func (w *dirWatcher) start() {
+	go w.run()
+}
+
+// close stops polling.
+func (w *dirWatcher) close() {
+	close(w.done)
+}
+
+// run polls the dirs until w is closed.
+func (w *dirWatcher) run() {
+	last := w.scan()
+	tick := time.NewTicker(w.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick.C:
+		}
+		current := w.scan()
+		changes := diffScans(last, current)
+		last = current
+		if len(changes) == 0 {
+			continue
+		}
+		debugf("watch: %d changes", len(changes))
+		w.mu.Lock()
+		subscribers := w.subscribers
+		w.mu.Unlock()
+		for _, f := range subscribers {
+			f(changes)
+		}
+	}
+}
+
+// scan notes every file under the dirs.
+func (w *dirWatcher) scan() map[fileKey]fileStamp {
+	files := map[fileKey]fileStamp{}
+	for _, dir := range w.dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || p == dir {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, p)
+			files[fileKey{dir, "/" + filepath.ToSlash(rel)}] = fileStamp{fi.Size(), fi.ModTime(), fi.IsDir()}
+			return nil
+		})
+	}
+	return files
+}
+
+// diffScans returns the changes from one scan to the next, in path
+// order. Directories are reported created or deleted but not modified,
+// as their modification time changes with their entries.
+func diffScans(old, current map[fileKey]fileStamp) []fileChange {
+	var changes []fileChange
+	for k, now := range current {
+		was, ok := old[k]
+		switch {
+		case !ok:
+			changes = append(changes, fileChange{"created", k.dir, k.path})
+		case was.isDir != now.isDir:
+			changes = append(changes, fileChange{"deleted", k.dir, k.path}, fileChange{"created", k.dir, k.path})
+		case !now.isDir && (was.size != now.size || !was.mod.Equal(now.mod)):
+			changes = append(changes, fileChange{"modified", k.dir, k.path})
+		}
+	}
+	for k := range old {
+		if _, ok := current[k]; !ok {
+			changes = append(changes, fileChange{"deleted", k.dir, k.path})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Dir != changes[j].Dir {
+			return changes[i].Dir < changes[j].Dir
+		}
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
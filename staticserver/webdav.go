@@ -0,0 +1,354 @@
+package staticserver
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// davHandler serves a directory tree over WebDAV so that it can be
+// mounted by Finder, Explorer and other clients. It implements the
+// methods of RFC 4918 those clients rely on, on top of the file server
+// for reads and the store for writes: dot files stay hidden and every
+// write is subject to the store's limits.
+//
+// Locks are advisory only: LOCK hands out a token that is never checked,
+// which is enough for clients that insist on locking before they write.
+// Likewise PROPPATCH reports success without storing dead properties,
+// so clients that set them carry on. A read-only handler advertises
+// WebDAV class 1 alone, which clients mount without write access.
+type davHandler struct {
+	prefix   string          // the URL path of the tree, without a trailing slash
+	fs       http.FileSystem // the tree, for reads
+	store    *store          // the tree, for writes
+	auth     Accounts        // required of every request, if any are configured
+	readOnly bool
+}
+
+// ServeHTTP dispatches r by its WebDAV method.
+func (h *davHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(h.auth) > 0 && !h.auth.authorize(w, r) {
+		return
+	}
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, h.prefix))
+
+	switch r.Method {
+	case http.MethodOptions:
+		h.options(w)
+		return
+	case http.MethodGet, http.MethodHead:
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = name, ""
+		if h.store != nil {
+			h.store.setETag(w, name)
+		}
+		http.FileServer(h.fs).ServeHTTP(w, r2)
+		return
+	case "PROPFIND":
+		h.propfind(w, r, name)
+		return
+	}
+
+	if h.readOnly {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	ok, done := h.store.precondition(w, r, name)
+	defer done()
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		created, err := h.store.put(name, r.Body, clientIP(r))
+		if err == nil {
+			h.store.setETag(w, name)
+		}
+		h.respond(w, err, created)
+	case http.MethodDelete:
+		h.respond(w, h.store.remove(name, true, clientIP(r)), false)
+	case "MKCOL":
+		h.mkcol(w, r, name)
+	case "MOVE", "COPY":
+		h.moveOrCopy(w, r, name)
+	case "PROPPATCH":
+		h.proppatch(w, r, name)
+	case "LOCK":
+		h.lock(w, r, name)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.options(w)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// options advertises the methods and WebDAV class the handler supports.
+func (h *davHandler) options(w http.ResponseWriter) {
+	if h.readOnly {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		w.Header().Set("DAV", "1")
+	} else {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND, PROPPATCH, PUT, DELETE, MKCOL, MOVE, COPY, LOCK, UNLOCK")
+		w.Header().Set("DAV", "1, 2")
+	}
+	w.Header().Set("MS-Author-Via", "DAV")
+}
+
+// respond reports the outcome of a write, err, to the client.
+func (h *davHandler) respond(w http.ResponseWriter, err error, created bool) {
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case created:
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// davMultistatus and the types below it are the XML bodies of PROPFIND
+// responses. Element names carry the D prefix bound to the DAV: namespace.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	NS        string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+	CreationDate  string          `xml:"D:creationdate"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+	SupportedLock *davRaw         `xml:"D:supportedlock,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+type davRaw struct {
+	XML string `xml:",innerxml"`
+}
+
+// davWriteLock is the one kind of lock the handler claims to support.
+const davWriteLock = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`
+
+// propfind reports the properties of name and, for a Depth of 1, of
+// each entry in it. All properties are reported whichever are asked for.
+func (h *davHandler) propfind(w http.ResponseWriter, r *http.Request, name string) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		// RFC 4918 section 9.1 lets servers refuse infinite depth.
+		http.Error(w, "Depth must be 0 or 1", http.StatusForbidden)
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.respond(w, err, false)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		h.respond(w, err, false)
+		return
+	}
+
+	ms := davMultistatus{NS: "DAV:", Responses: []davResponse{h.entry(name, fi)}}
+	if depth == "1" && fi.IsDir() {
+		entries, err := f.Readdir(-1)
+		if err != nil {
+			h.respond(w, err, false)
+			return
+		}
+		for _, e := range entries {
+			ms.Responses = append(ms.Responses, h.entry(path.Join(name, e.Name()), e))
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(ms); err != nil {
+		log.Println("webdav:", err)
+	}
+}
+
+// entry returns the PROPFIND response describing name.
+func (h *davHandler) entry(name string, fi fs.FileInfo) davResponse {
+	href := h.prefix + name
+	prop := davProp{
+		DisplayName:  fi.Name(),
+		LastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+		CreationDate: fi.ModTime().UTC().Format(time.RFC3339),
+	}
+	if fi.IsDir() {
+		if !strings.HasSuffix(href, "/") {
+			href += "/"
+		}
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := fi.Size()
+		prop.ContentLength = &size
+		prop.ContentType = mime.TypeByExtension(path.Ext(name))
+		prop.ETag = fileETag(fi)
+	}
+	if !h.readOnly {
+		prop.SupportedLock = &davRaw{davWriteLock}
+	}
+	return davResponse{
+		Href:     (&url.URL{Path: href}).EscapedPath(),
+		Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+// mkcol creates the collection name.
+func (h *davHandler) mkcol(w http.ResponseWriter, r *http.Request, name string) {
+	if r.ContentLength > 0 {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	switch err := h.store.mkdir(name); {
+	case errors.Is(err, os.ErrExist):
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	default:
+		h.respond(w, err, true)
+	}
+}
+
+// moveOrCopy moves or copies name to the path named by the Destination
+// header, which must lie within the tree.
+func (h *davHandler) moveOrCopy(w http.ResponseWriter, r *http.Request, name string) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		http.Error(w, "invalid Destination", http.StatusBadRequest)
+		return
+	}
+	if (u.Host != "" && u.Host != r.Host) || !strings.HasPrefix(u.Path, h.prefix+"/") {
+		http.Error(w, "Destination is not on this server", http.StatusBadGateway)
+		return
+	}
+	dest := path.Clean("/" + strings.TrimPrefix(u.Path, h.prefix))
+	if dest == name || strings.HasPrefix(dest, strings.TrimSuffix(name, "/")+"/") {
+		// Onto itself, or into its own subtree.
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	exists := false
+	if f, err := h.fs.Open(dest); err == nil {
+		f.Close()
+		exists = true
+	}
+	if exists && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	if r.Method == "MOVE" {
+		err = h.store.move(name, dest, clientIP(r))
+	} else {
+		err = h.store.copy(h.fs, name, dest, r.Header.Get("Depth") != "0", clientIP(r))
+	}
+	h.respond(w, err, !exists)
+}
+
+// proppatch claims to have set or removed each property in the request.
+func (h *davHandler) proppatch(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		h.respond(w, err, false)
+		return
+	}
+	f.Close()
+
+	var props strings.Builder
+	dec := xml.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	for depth := 0; ; {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 4 { // propertyupdate > set or remove > prop > property
+				fmt.Fprintf(&props, `<x:%s xmlns:x="%s"/>`, t.Name.Local, escapeXML(t.Name.Space))
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprintf(w, `%s<D:multistatus xmlns:D="DAV:"><D:response><D:href>%s</D:href><D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`,
+		xml.Header, escapeXML((&url.URL{Path: h.prefix + name}).EscapedPath()), props.String())
+}
+
+// lock grants an advisory exclusive write lock on name, creating it as an
+// empty file if it does not exist yet.
+func (h *davHandler) lock(w http.ResponseWriter, r *http.Request, name string) {
+	created := false
+	if f, err := h.fs.Open(name); err == nil {
+		f.Close()
+	} else if errors.Is(err, fs.ErrNotExist) {
+		if _, err := h.store.put(name, strings.NewReader(""), clientIP(r)); err != nil {
+			h.respond(w, err, false)
+			return
+		}
+		created = true
+	} else {
+		h.respond(w, err, false)
+		return
+	}
+
+	var b [16]byte
+	rand.Read(b[:])
+	token := fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	href := escapeXML((&url.URL{Path: h.prefix + name}).EscapedPath())
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	fmt.Fprintf(w, `%s<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope><D:depth>infinity</D:depth><D:timeout>Second-3600</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock></D:lockdiscovery></D:prop>`,
+		xml.Header, token, href)
+}
+
+// escapeXML returns s with the characters special to XML escaped.
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
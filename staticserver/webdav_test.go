@@ -0,0 +1,131 @@
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// davRequest returns a WebDAV request of method for target with body and
+// the headers given as name, value pairs.
+func davRequest(method, target, body string, headers ...string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	return r
+}
+
+func TestDavHandler(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".secret"), []byte("hidden"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := &davHandler{prefix: "/dav", fs: noDotFS{http.Dir(root)}, store: &store{root: root}}
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+		body   string // that the response must contain, if any
+	}{
+		{"put", davRequest("PUT", "/dav/a.txt", "hello"), http.StatusCreated, ""},
+		{"put again", davRequest("PUT", "/dav/a.txt", "hello again"), http.StatusNoContent, ""},
+		{"get", davRequest("GET", "/dav/a.txt", ""), http.StatusOK, "hello again"},
+		{"put dot file", davRequest("PUT", "/dav/.htaccess", "x"), http.StatusForbidden, ""},
+		{"get dot file", davRequest("GET", "/dav/.secret", ""), http.StatusForbidden, ""},
+		{"mkcol", davRequest("MKCOL", "/dav/d", ""), http.StatusCreated, ""},
+		{"mkcol existing", davRequest("MKCOL", "/dav/d", ""), http.StatusMethodNotAllowed, ""},
+		{"mkcol without parent", davRequest("MKCOL", "/dav/x/y", ""), http.StatusConflict, ""},
+		{"propfind", davRequest("PROPFIND", "/dav/", "", "Depth", "1"), http.StatusMultiStatus, "<D:href>/dav/a.txt</D:href>"},
+		{"propfind infinity", davRequest("PROPFIND", "/dav/", "", "Depth", "infinity"), http.StatusForbidden, ""},
+		{"propfind missing", davRequest("PROPFIND", "/dav/missing", "", "Depth", "0"), http.StatusNotFound, ""},
+		{"copy", davRequest("COPY", "/dav/a.txt", "", "Destination", "/dav/d/b.txt"), http.StatusCreated, ""},
+		{"copy no overwrite", davRequest("COPY", "/dav/a.txt", "", "Destination", "/dav/d/b.txt", "Overwrite", "F"), http.StatusPreconditionFailed, ""},
+		{"move", davRequest("MOVE", "/dav/d/b.txt", "", "Destination", "/dav/c.txt"), http.StatusCreated, ""},
+		{"move off the tree", davRequest("MOVE", "/dav/c.txt", "", "Destination", "/elsewhere/c.txt"), http.StatusBadGateway, ""},
+		{"move off the server", davRequest("MOVE", "/dav/c.txt", "", "Destination", "http://other.example/dav/c.txt"), http.StatusBadGateway, ""},
+		{"move into itself", davRequest("MOVE", "/dav/d", "", "Destination", "/dav/d/e"), http.StatusForbidden, ""},
+		{"move to a dot file", davRequest("MOVE", "/dav/c.txt", "", "Destination", "/dav/.c.txt"), http.StatusForbidden, ""},
+		{"move climbing", davRequest("MOVE", "/dav/c.txt", "", "Destination", "/dav/../../e.txt"), http.StatusCreated, ""},
+		{"lock", davRequest("LOCK", "/dav/locked.txt", ""), http.StatusCreated, "opaquelocktoken:"},
+		{"delete dir", davRequest("DELETE", "/dav/d", ""), http.StatusNoContent, ""},
+		{"delete missing", davRequest("DELETE", "/dav/d", ""), http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tt.r)
+		if w.Code != tt.status {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.r.Method, tt.r.URL.Path, w.Code, tt.status)
+		}
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: body %q, want it to contain %q", tt.name, w.Body.String(), tt.body)
+		}
+		if strings.Contains(w.Body.String(), ".secret") {
+			t.Errorf("%s: body shows the dot file: %q", tt.name, w.Body.String())
+		}
+	}
+	for name, want := range map[string]bool{"a.txt": true, "c.txt": false, "e.txt": true, "locked.txt": true, "d": false} {
+		if _, err := os.Stat(filepath.Join(root, name)); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "e.txt")); err == nil {
+		t.Error("e.txt was moved above the root")
+	}
+}
+
+func TestDavReadOnly(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := &davHandler{prefix: "/dav", fs: noDotFS{http.Dir(root)}, readOnly: true}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, davRequest("OPTIONS", "/dav/", ""))
+	if got := w.Header().Get("DAV"); got != "1" {
+		t.Errorf("DAV = %q, want %q", got, "1")
+	}
+	for _, method := range []string{"PUT", "DELETE", "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, davRequest(method, "/dav/a.txt", "changed", "Destination", "/dav/b.txt"))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s = %d, want 403", method, w.Code)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "a.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("a.txt is %q, %v, want %q", b, err, "hello")
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, davRequest("PROPFIND", "/dav/a.txt", "", "Depth", "0"))
+	if w.Code != http.StatusMultiStatus || strings.Contains(w.Body.String(), "supportedlock") {
+		t.Errorf("PROPFIND = %d with %q, want 207 without locks", w.Code, w.Body.String())
+	}
+}
+
+func TestDavNeedsAuth(t *testing.T) {
+	root := t.TempDir()
+	auth := Accounts{}
+	if err := auth.Set("alice:pw"); err != nil {
+		t.Fatal(err)
+	}
+	h := &davHandler{prefix: "/dav", fs: noDotFS{http.Dir(root)}, store: &store{root: root}, auth: auth}
+	for _, method := range []string{"PROPFIND", "PUT"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, davRequest(method, "/dav/a.txt", "x", "Depth", "0"))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without credentials = %d, want 401", method, w.Code)
+		}
+	}
+	r := davRequest("PUT", "/dav/a.txt", "x")
+	r.SetBasicAuth("alice", "pw")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("PUT as alice = %d, want 201", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package staticserver
+
+import (
+	"log"
+	"net/http"
+)
+
+// writeHandler writes the body of authorized PUT requests to the file at
+// the request path and removes the file or empty directory at the path
+// of authorized DELETE requests, when each is enabled. Both honor the
+// If-Match, If-None-Match and If-Unmodified-Since preconditions, and the
+// ETag they are checked against is sent with the files served. Every
+// other request is passed on to next. Each write is logged with the IP
+// of the client, only the network part of it when anonymize is set.
+type writeHandler struct {
+	store     *store
+	auth      Accounts
+	put       bool
+	delete    bool
+	anonymize bool
+	next      http.Handler
+}
+
+// ServeHTTP performs enabled writes and serves the rest with next.
+func (h writeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && h.put:
+	case r.Method == http.MethodDelete && h.delete:
+	default:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h.store.setETag(w, r.URL.Path)
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if !h.auth.authorize(w, r) {
+		return
+	}
+	ok, done := h.store.precondition(w, r, r.URL.Path)
+	defer done()
+	if !ok {
+		return
+	}
+
+	user, _ := h.auth.user(r)
+	from := clientIP(r)
+	if h.anonymize {
+		from = anonymizeIP(from)
+	}
+	if r.Method == http.MethodDelete {
+		err := h.store.remove(r.URL.Path, false, clientIP(r))
+		if err != nil {
+			log.Printf("delete %s by %s from %s failed: %v", r.URL.Path, user, from, err)
+			writeError(w, err)
+			return
+		}
+		log.Printf("delete %s by %s from %s", r.URL.Path, user, from)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	created, err := h.store.put(r.URL.Path, r.Body, clientIP(r))
+	if err != nil {
+		log.Printf("put %s by %s from %s failed: %v", r.URL.Path, user, from, err)
+		writeError(w, err)
+		return
+	}
+	log.Printf("put %s by %s from %s", r.URL.Path, user, from)
+	h.store.setETag(w, r.URL.Path)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError reports err from a store to the client, logging any
+// error that is not the client's doing.
+func writeError(w http.ResponseWriter, err error) {
+	status := storeErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println("write:", err)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,196 @@
+package staticserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRequest returns a request of method for the path p, with body,
+// as the account alice:pw.
+func writeRequest(method, p string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, "/", body)
+	r.URL.Path = p
+	r.SetBasicAuth("alice", "pw")
+	return r
+}
+
+// newWriteHandler returns a writeHandler for PUT and DELETE into root,
+// for the account alice:pw.
+func newWriteHandler(t *testing.T, root string) writeHandler {
+	t.Helper()
+	auth := Accounts{}
+	if err := auth.Set("alice:pw"); err != nil {
+		t.Fatal(err)
+	}
+	return writeHandler{store: &store{root: root}, auth: auth, put: true, delete: true, next: http.NotFoundHandler()}
+}
+
+func TestWriteContainment(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	symlinks := true
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		symlinks = false
+	}
+	h := newWriteHandler(t, root)
+
+	tests := []struct {
+		method  string
+		path    string
+		symlink bool
+		status  int
+	}{
+		{"PUT", "/a.txt", false, http.StatusCreated},
+		{"PUT", "/a.txt", false, http.StatusNoContent},
+		{"PUT", "/new/sub/b.txt", false, http.StatusCreated},
+		{"PUT", "/../climbed.txt", false, http.StatusCreated},
+		{"PUT", "/dir/../../../climbed2.txt", false, http.StatusCreated},
+		{"PUT", "/", false, http.StatusForbidden},
+		{"PUT", "/dir", false, http.StatusForbidden},
+		{"PUT", "/.htaccess", false, http.StatusForbidden},
+		{"PUT", "/dir/.git/config", false, http.StatusForbidden},
+		{"PUT", "/link/secret", true, http.StatusForbidden},
+		{"PUT", "/link/new/c.txt", true, http.StatusForbidden},
+		{"DELETE", "/link/secret", true, http.StatusForbidden},
+		{"DELETE", "/missing.txt", false, http.StatusNotFound},
+		{"DELETE", "/.htaccess", false, http.StatusForbidden},
+		{"DELETE", "/a.txt", false, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if tt.symlink && !symlinks {
+			continue
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, writeRequest(tt.method, tt.path, strings.NewReader("written")))
+		if w.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+	}
+
+	// The writes that climbed were kept in the root.
+	for _, name := range []string{"new/sub/b.txt", "climbed.txt", "climbed2.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"climbed.txt", "climbed2.txt"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(root), name)); err == nil {
+			t.Errorf("%s was written above the root", name)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(outside, "secret")); err != nil || string(b) != "keep" {
+		t.Errorf("file behind the symlink is %q, %v, want %q", b, err, "keep")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); err == nil {
+		t.Error("a dir was made behind the symlink")
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); err == nil {
+		t.Error("a.txt was not deleted")
+	}
+}
+
+func TestWriteNeedsAuth(t *testing.T) {
+	root := t.TempDir()
+	h := newWriteHandler(t, root)
+	for _, method := range []string{"PUT", "DELETE"} {
+		r := writeRequest(method, "/a.txt", strings.NewReader("written"))
+		r.SetBasicAuth("alice", "wrong")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s with a wrong password = %d, want 401", method, w.Code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); err == nil {
+		t.Error("a.txt was written without the password")
+	}
+}
+
+// failingReader returns its data and then err.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// tempFiles returns the names of the temporary files writes left in dir.
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestWriteTempThenRename(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(name, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := newWriteHandler(t, root)
+
+	// While the body comes in, the old file is still served whole.
+	pr, pw := io.Pipe()
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, writeRequest("PUT", "/a.txt", pr))
+		done <- w.Code
+	}()
+	pw.Write([]byte("part of the new file"))
+	if b, _ := os.ReadFile(name); string(b) != "old" {
+		t.Errorf("a.txt is %q during the write, want %q", b, "old")
+	}
+	if len(tempFiles(t, root)) != 1 {
+		t.Error("the write is not going to a temporary file")
+	}
+	pw.Write([]byte(", and the rest"))
+	pw.Close()
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("PUT = %d, want 204", code)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "part of the new file, and the rest" {
+		t.Errorf("a.txt is %q after the write", b)
+	}
+
+	// A body that fails, or is too large, leaves the old file as it was.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, writeRequest("PUT", "/a.txt", &failingReader{data: "half", err: errors.New("connection reset")}))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("PUT of a failing body = %d, want 500", w.Code)
+	}
+	h.store.maxSize = 4
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, writeRequest("PUT", "/a.txt", strings.NewReader("far too large")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT of a large body = %d, want 413", w.Code)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "part of the new file, and the rest" {
+		t.Errorf("a.txt is %q after the failed writes", b)
+	}
+	if tmp := tempFiles(t, root); len(tmp) != 0 {
+		t.Errorf("failed writes left %v", tmp)
+	}
+}
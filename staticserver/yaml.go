@@ -0,0 +1,382 @@
+package staticserver
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The numbers of the YAML 1.2 core schema. 0777 is the base 10 777, as
+// octal needs 0o, and anything else, 1_000 or 0b101 among them, is a
+// string.
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOct   = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlInf   = regexp.MustCompile(`^[-+]?\.(inf|Inf|INF)$`)
+	yamlNaN   = regexp.MustCompile(`^\.(nan|NaN|NAN)$`)
+)
+
+// parseYAML parses the subset of YAML data files are usually written
+// in: mappings and sequences nested by indentation, plain and quoted
+// scalars, flow sequences, block scalars and comments. Anchors, tags
+// and documents after the first are not supported. Mappings decode as
+// map[string]any and sequences as []any, integers as int64 and other
+// numbers as float64.
+func parseYAML(src []byte) (any, error) {
+	p := &yamlParser{}
+	for n, raw := range strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n") {
+		text := strings.TrimSpace(stripYAMLComment(raw))
+		if strings.HasPrefix(raw, "---") || strings.HasPrefix(raw, "%") {
+			if len(p.lines) > 0 && strings.HasPrefix(raw, "---") {
+				break // the next document
+			}
+			continue
+		}
+		if strings.HasPrefix(raw, "...") {
+			break
+		}
+		p.lines = append(p.lines, yamlLine{n: n + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, raw: raw})
+	}
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	v, err := p.value(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.i < len(p.lines) {
+		return nil, p.errorf("unexpected %q", p.lines[p.i].text)
+	}
+	return v, nil
+}
+
+// yamlLine is a line of a document, with its comment stripped from text.
+type yamlLine struct {
+	n      int // the line number
+	indent int
+	text   string
+	raw    string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	n := 0
+	if p.i < len(p.lines) {
+		n = p.lines[p.i].n
+	}
+	return fmt.Errorf("yaml: line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// skipBlank moves past lines with nothing but comments or spaces.
+func (p *yamlParser) skipBlank() {
+	for p.i < len(p.lines) && p.lines[p.i].text == "" {
+		p.i++
+	}
+}
+
+// isYAMLItem reports whether text is an entry of a block sequence.
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// value parses the node whose first line is the next, at indent.
+func (p *yamlParser) value(indent int) (any, error) {
+	l := p.lines[p.i]
+	if isYAMLItem(l.text) {
+		return p.sequence(l.indent)
+	}
+	if _, _, ok := splitYAMLKey(l.text); ok {
+		return p.mapping(l.indent)
+	}
+	p.i++
+	return yamlScalar(l.text)
+}
+
+// sequence parses the entries of a block sequence at indent.
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	seq := []any{}
+	for p.skipBlank(); p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLItem(p.lines[p.i].text); p.skipBlank() {
+		l := p.lines[p.i]
+		rest := strings.TrimSpace(l.text[1:])
+		if rest == "" {
+			p.i++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		// The entry's content is a node of its own, at the column it
+		// starts at, so that a mapping can carry on below it.
+		col := l.indent + strings.Index(l.raw[l.indent+1:], rest) + 1
+		p.lines[p.i] = yamlLine{n: l.n, indent: col, text: rest, raw: strings.Repeat(" ", col) + rest}
+		v, err := p.value(col)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+// mapping parses the entries of a block mapping at indent.
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.skipBlank(); p.i < len(p.lines) && p.lines[p.i].indent == indent; p.skipBlank() {
+		l := p.lines[p.i]
+		if isYAMLItem(l.text) {
+			break
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected key: value, found %q", l.text)
+		}
+		p.i++
+		var v any
+		var err error
+		switch {
+		case rest == "":
+			v, err = p.nested(indent, true)
+		case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			v = p.blockScalar(indent, rest)
+		default:
+			v, err = yamlScalar(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// nested parses the node under an entry at indent that has nothing
+// after it on its line, or returns nil if there is none. The entries of
+// a sequence may be at the same indent as the key they belong to.
+func (p *yamlParser) nested(indent int, key bool) (any, error) {
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	l := p.lines[p.i]
+	if l.indent > indent || key && l.indent == indent && isYAMLItem(l.text) {
+		return p.value(l.indent)
+	}
+	return nil, nil
+}
+
+// blockScalar reads the literal (|) or folded (>) scalar under an entry
+// at indent.
+func (p *yamlParser) blockScalar(indent int, header string) string {
+	var lines []string
+	col := -1
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.lines[p.i]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if l.indent <= indent {
+			break
+		}
+		if col < 0 {
+			col = l.indent
+		}
+		lines = append(lines, l.raw[min(col, l.indent):])
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var s string
+	if header[0] == '|' {
+		s = strings.Join(lines, "\n")
+	} else {
+		s = strings.ReplaceAll(strings.Join(lines, "\n"), "\n\n", "\x00")
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\n", " "), "\x00", "\n")
+	}
+	if !strings.Contains(header, "-") && len(lines) > 0 {
+		s += "\n"
+	}
+	return s
+}
+
+// splitYAMLKey splits "key: value" into its key and value.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	if q := text[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(text[1:], q)
+		if end < 0 || !strings.HasPrefix(text[end+2:], ":") {
+			return "", "", false
+		}
+		after := text[end+3:]
+		if after != "" && after[0] != ' ' {
+			return "", "", false
+		}
+		k, err := yamlScalar(text[:end+2])
+		if err != nil {
+			return "", "", false
+		}
+		return fmt.Sprint(k), strings.TrimSpace(after), true
+	}
+	if i := strings.Index(text, ": "); i > 0 {
+		return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes the comment from line: a # at its start or
+// after a space, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" :-[{,", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar parses a scalar or a flow sequence or mapping.
+func yamlScalar(s string) (any, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return nil, nil
+	case s == "true" || s == "True" || s == "TRUE":
+		return true, nil
+	case s == "false" || s == "False" || s == "FALSE":
+		return false, nil
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: invalid string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("yaml: invalid string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		seq := []any{}
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			v, err := yamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}"):
+		m := map[string]any{}
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			k, rest, ok := splitYAMLKey(item)
+			if !ok {
+				return nil, fmt.Errorf("yaml: invalid mapping %s", s)
+			}
+			v, err := yamlScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("yaml: unclosed flow collection %s", s)
+	}
+	if n, ok := yamlNumber(s); ok {
+		return n, nil
+	}
+	return s, nil
+}
+
+// yamlNumber parses s as a number of the core schema: an int64 if it is
+// a base 10 integer or one with a 0o or 0x prefix, and a float64 if it
+// is another number, .inf or .nan.
+func yamlNumber(s string) (any, bool) {
+	var n int64
+	var err error
+	switch {
+	case yamlInt.MatchString(s):
+		n, err = strconv.ParseInt(s, 10, 64)
+	case yamlOct.MatchString(s):
+		n, err = strconv.ParseInt(s[2:], 8, 64)
+	case yamlHex.MatchString(s):
+		n, err = strconv.ParseInt(s[2:], 16, 64)
+	case yamlInf.MatchString(s):
+		if s[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	case yamlNaN.MatchString(s):
+		return math.NaN(), true
+	case yamlFloat.MatchString(s):
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	default:
+		return nil, false
+	}
+	if err != nil {
+		// Too large for an int64, which a float64 can still hold.
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil && s[0] != '0'
+	}
+	return n, true
+}
+
+// splitFlow splits the entries of a flow collection at the commas that
+// are not nested or quoted.
+func splitFlow(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		items = append(items, s[start:])
+	}
+	return items
+}
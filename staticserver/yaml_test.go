@@ -0,0 +1,118 @@
+package staticserver
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want any
+	}{
+		{"empty", "", nil},
+		{"comment only", "# nothing\n", nil},
+		{"mapping", "a: 1\nb: two\n", map[string]any{"a": int64(1), "b": "two"}},
+		{"nested mapping", "a:\n  b:\n    c: true\n", map[string]any{"a": map[string]any{"b": map[string]any{"c": true}}}},
+		{"sequence", "- 1\n- x\n- ~\n", []any{int64(1), "x", nil}},
+		{"sequence under key at same indent", "a:\n- 1\n- 2\n", map[string]any{"a": []any{int64(1), int64(2)}}},
+		{"mapping in sequence", "- a: 1\n  b: 2\n- c: 3\n", []any{map[string]any{"a": int64(1), "b": int64(2)}, map[string]any{"c": int64(3)}}},
+		{"flow sequence", "a: [1, 'b', \"c, d\"]\n", map[string]any{"a": []any{int64(1), "b", "c, d"}}},
+		{"flow mapping", "a: {b: 1, c: [2, 3]}\n", map[string]any{"a": map[string]any{"b": int64(1), "c": []any{int64(2), int64(3)}}}},
+		{"quoted key", "\"a b\": 1\n'c': 2\n", map[string]any{"a b": int64(1), "c": int64(2)}},
+		{"single quote escape", "a: 'it''s'\n", map[string]any{"a": "it's"}},
+		{"double quote escape", "a: \"tab\\there\"\n", map[string]any{"a": "tab\there"}},
+		{"trailing comment", "a: b # note\nc: 'd # kept'\n", map[string]any{"a": "b", "c": "d # kept"}},
+		{"hash in word", "a: b#c\n", map[string]any{"a": "b#c"}},
+		{"literal block", "a: |\n  one\n  two\nb: 1\n", map[string]any{"a": "one\ntwo\n", "b": int64(1)}},
+		{"folded block", "a: >-\n  one\n  two\n\n  three\n", map[string]any{"a": "one two\nthree"}},
+		{"document marker", "---\na: 1\n...\nb: 2\n", map[string]any{"a": int64(1)}},
+		{"second document ignored", "a: 1\n---\nb: 2\n", map[string]any{"a": int64(1)}},
+		{"crlf", "a: 1\r\nb: 2\r\n", map[string]any{"a": int64(1), "b": int64(2)}},
+		{"booleans and nulls", "a: True\nb: FALSE\nc: null\nd:\n", map[string]any{"a": true, "b": false, "c": nil, "d": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.src))
+			if err != nil {
+				t.Fatalf("parseYAML(%q): %v", tt.src, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML(%q) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"unclosed flow sequence", "a: [1, 2\n"},
+		{"unclosed flow mapping", "a: {b: 1\n"},
+		{"unterminated double quote", "a: \"b\n"},
+		{"unterminated single quote", "a: 'b\n"},
+		{"not a mapping entry", "a: 1\nb\n"},
+		{"bad flow mapping entry", "a: {b}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, err := parseYAML([]byte(tt.src)); err == nil {
+				t.Errorf("parseYAML(%q) = %#v, want an error", tt.src, v)
+			}
+		})
+	}
+}
+
+func TestYAMLScalarNumbers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"0", int64(0)},
+		{"42", int64(42)},
+		{"-17", int64(-17)},
+		{"+3", int64(3)},
+		{"0777", int64(777)},
+		{"0o17", int64(15)},
+		{"0x1F", int64(31)},
+		{"9223372036854775807", int64(math.MaxInt64)},
+		{"99999999999999999999", 1e20},
+		{"1.5", 1.5},
+		{"-.5", -0.5},
+		{"1.", 1.0},
+		{"1e3", 1000.0},
+		{"2.5E-1", 0.25},
+		{".inf", math.Inf(1)},
+		{"-.Inf", math.Inf(-1)},
+		// Not numbers of the core schema, so strings.
+		{"1_000", "1_000"},
+		{"0b101", "0b101"},
+		{"-0x1", "-0x1"},
+		{"0o8", "0o8"},
+		{"0x", "0x"},
+		{"Inf", "Inf"},
+		{"INF", "INF"},
+		{"NaN", "NaN"},
+		{"1e", "1e"},
+		{"0x1p-2", "0x1p-2"},
+		{"0xFFFFFFFFFFFFFFFFFF", "0xFFFFFFFFFFFFFFFFFF"},
+		{"1.2.3", "1.2.3"},
+	}
+	for _, tt := range tests {
+		got, err := yamlScalar(tt.in)
+		if err != nil {
+			t.Errorf("yamlScalar(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("yamlScalar(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+	if got, _ := yamlScalar(".nan"); !math.IsNaN(got.(float64)) {
+		t.Errorf("yamlScalar(%q) = %#v, want NaN", ".nan", got)
+	}
+}
@@ -0,0 +1,147 @@
+package staticserver
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ZipFS is an fs.FS of the files in a zip archive, served without
+// extracting them. The archive's directory is indexed in memory when
+// it is opened, and files are read from the archive as they are served.
+type ZipFS struct {
+	f     *os.File
+	r     *zip.Reader
+	files map[string]*zip.File // the regular files, by name
+}
+
+// OpenZip opens the named zip archive as a ZipFS, to be set as the FS
+// of a Config.
+func OpenZip(name string) (*ZipFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	z := &ZipFS{f: f, r: r, files: map[string]*zip.File{}}
+	for _, zf := range r.File {
+		if name := strings.TrimPrefix(zf.Name, "/"); !strings.HasSuffix(name, "/") {
+			z.files[name] = zf
+		}
+	}
+	return z, nil
+}
+
+// Open opens the named file. Directories are served by the zip.Reader,
+// which also lists the ones implied by the names of the files in them.
+func (z *ZipFS) Open(name string) (fs.File, error) {
+	zf, ok := z.files[name]
+	if !ok {
+		return z.r.Open(name)
+	}
+	f := &zipFile{zf: zf, size: int64(zf.UncompressedSize64)}
+	if zf.Method == zip.Store {
+		// Stored files are seekable in place.
+		off, err := zf.DataOffset()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		f.section = io.NewSectionReader(z.f, off, f.size)
+	}
+	return f, nil
+}
+
+// Close closes the archive.
+func (z *ZipFS) Close() error {
+	return z.f.Close()
+}
+
+// zipFile is a file in a ZipFS. It can seek, as http.FileServer needs
+// it to: stored files seek in place, and compressed ones decompress
+// again from the start when they seek backwards.
+type zipFile struct {
+	zf      *zip.File
+	size    int64
+	pos     int64
+	section *io.SectionReader // the data of a stored file, if non-nil
+
+	rc     io.ReadCloser // decompresses a compressed file, once read
+	rcPos  int64         // where rc has read to
+	closed bool
+}
+
+func (f *zipFile) Stat() (fs.FileInfo, error) {
+	return f.zf.FileInfo(), nil
+}
+
+func (f *zipFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.section != nil {
+		n, err := f.section.ReadAt(p[:min(int64(len(p)), f.size-f.pos)], f.pos)
+		f.pos += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	if f.rc == nil || f.rcPos > f.pos {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, err := f.zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.rcPos = rc, 0
+	}
+	if f.rcPos < f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, f.pos-f.rcPos)
+		f.rcPos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.rc.Read(p)
+	f.rcPos += int64(n)
+	f.pos = f.rcPos
+	return n, err
+}
+
+func (f *zipFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *zipFile) Close() error {
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
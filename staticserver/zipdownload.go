@@ -0,0 +1,161 @@
+package staticserver
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxZipFiles is the most files a zip download may hold.
+	maxZipFiles = 1000
+	// maxZipRequest is the largest body a request for one may have.
+	maxZipRequest = 1 << 20
+)
+
+// zipDownloadHandler answers POST requests for ?zip=1 on a dir with a
+// zip of the files of the dir they name, and passes every other request
+// to next. The names are sent as a JSON list, or as the path values of
+// a form, as listings send them. The zip is written as it is sent, with
+// no temporary file, and files that are compressed already are stored
+// as they are. Only files right in the dir may be named, so that a dir
+// under it with passwords of its own is never read from.
+type zipDownloadHandler struct {
+	fs   http.FileSystem
+	next http.Handler
+}
+
+// ServeHTTP answers r with a zip, or serves it with next.
+func (h zipDownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Query().Get("zip") != "1" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	dir := path.Clean("/" + r.URL.Path)
+	fi, err := h.stat(dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !fi.IsDir() {
+		http.Error(w, "zip downloads are of the files of a dir", http.StatusBadRequest)
+		return
+	}
+	names, err := zipNames(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Check every file before the response starts, as an error after it
+	// can only break off the download.
+	for _, name := range names {
+		fi, err := h.stat(path.Join(dir, name))
+		if err != nil {
+			http.Error(w, "no file "+name, http.StatusNotFound)
+			return
+		}
+		if !fi.Mode().IsRegular() {
+			http.Error(w, name+" is not a file", http.StatusBadRequest)
+			return
+		}
+	}
+
+	base := path.Base(dir)
+	if base == "/" {
+		base = "files"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base + ".zip"}))
+	w.Header().Set("Cache-Control", "no-store")
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		if err := h.add(zw, dir, name); err != nil {
+			// Break the download off rather than end it as if it were
+			// whole.
+			debugf("zip: %s: %v", path.Join(dir, name), err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		debugf("zip: %s: %v", dir, err)
+	}
+}
+
+// stat describes the file name.
+func (h zipDownloadHandler) stat(name string) (os.FileInfo, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// add writes the file name of dir to zw.
+func (h zipDownloadHandler) add(zw *zip.Writer, dir, name string) error {
+	f, err := h.fs.Open(path.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fh, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	fh.Name, fh.Method = name, zip.Store
+	if compressibleExts[strings.ToLower(path.Ext(name))] {
+		fh.Method = zip.Deflate
+	}
+	fw, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// zipNames returns the names of the files r asks for a zip of, as a JSON
+// list or a form, each once, in the order they were asked for.
+func zipNames(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxZipRequest)
+	var names []string
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+			return nil, errors.New("expected a JSON list of the names of files")
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		names = r.PostForm["path"]
+	}
+	var unique []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, errors.New("invalid name " + strconv.Quote(name) + ", expected the name of a file in the dir")
+		}
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	switch {
+	case len(unique) == 0:
+		return nil, errors.New("no files chosen")
+	case len(unique) > maxZipFiles:
+		return nil, errors.New("too many files chosen")
+	}
+	return unique, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogFacilityNames returns the names of the facilities, in the order
+// of their codes.
+func syslogFacilityNames() []string {
+	names := slices.Collect(maps.Keys(syslogFacilities))
+	slices.SortFunc(names, func(a, b string) int { return syslogFacilities[a] - syslogFacilities[b] })
+	return names
+}
+
+// Syslog severities used by the server.
+const (
+	syslogNotice = 5
+	syslogInfo   = 6
+)
+
+// syslogConfig says where and how to send log messages to syslog.
+type syslogConfig struct {
+	addr     string // "local", or udp://host:port or tcp://host:port
+	facility int
+	tag      string
+}
+
+// setFacility validates and stores the -syslog-facility.
+// It has the signature expected by flag.Func.
+func (c *syslogConfig) setFacility(s string) error {
+	f, ok := syslogFacilities[strings.ToLower(s)]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility %q", s)
+	}
+	c.facility = f
+	return nil
+}
+
+// syslogWriter is an io.Writer that sends each Write to syslog as one
+// RFC 5424 message of the given severity. Messages to a TCP collector
+// are framed by octet counting as RFC 6587 describes. A broken
+// connection is redialled on the next write.
+type syslogWriter struct {
+	cfg      syslogConfig
+	severity int
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+	tcp  bool
+}
+
+// newSyslogWriter returns a syslogWriter for cfg, connecting immediately
+// so that a bad address is reported at startup.
+func newSyslogWriter(cfg syslogConfig, severity int) (*syslogWriter, error) {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-" // the NILVALUE of RFC 5424
+	}
+	w := &syslogWriter{cfg: cfg, severity: severity, hostname: host}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// dial connects to the configured syslog. It must be called with w.mu held.
+func (w *syslogWriter) dial() error {
+	if w.cfg.addr == "local" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			for _, network := range []string{"unixgram", "unix"} {
+				if c, err := net.Dial(network, path); err == nil {
+					w.conn, w.tcp = c, false
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no local syslog socket found")
+	}
+
+	network, addr, ok := strings.Cut(w.cfg.addr, "://")
+	if !ok || (network != "udp" && network != "tcp") {
+		return fmt.Errorf("invalid syslog address %q, expected local, udp://host:port or tcp://host:port", w.cfg.addr)
+	}
+	c, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	w.conn, w.tcp = c, network == "tcp"
+	return nil
+}
+
+// Write sends b to syslog as a single message.
+func (w *syslogWriter) Write(b []byte) (int, error) {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		w.cfg.facility*8+w.severity, time.Now().Format(time.RFC3339Nano),
+		w.hostname, w.cfg.tag, os.Getpid(), strings.TrimRight(string(b), "\n"))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err := w.dial(); err != nil {
+				return 0, err
+			}
+		}
+		frame := msg
+		if w.tcp {
+			frame = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := w.conn.Write([]byte(frame)); err == nil {
+			return len(b), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return 0, fmt.Errorf("syslog: cannot send to %s", w.cfg.addr)
+}
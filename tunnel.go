@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tunnelProviders are the -tunnel values besides ssh:// URLs.
+var tunnelProviders = []string{"ngrok", "cloudflared", "localhost.run"}
+
+// tunnelURLs match the public URLs in the output of the commands of the
+// providers, as their first group, and any URL of an SSH server.
+var tunnelURLs = map[string]*regexp.Regexp{
+	"ngrok":         regexp.MustCompile(`\burl=(https://\S+)`),
+	"cloudflared":   regexp.MustCompile(`(https://[a-z0-9-]+\.trycloudflare\.com)`),
+	"localhost.run": regexp.MustCompile(`tunneled with tls termination, (https://\S+)`),
+	"ssh":           regexp.MustCompile(`(https?://[^\s"']+)`),
+}
+
+// tunnelCommand returns the command that tunnels to the listener on the
+// local port for the provider spec: ngrok, cloudflared, localhost.run,
+// or ssh://[user@]host[:port] for an SSH remote forward of the same port
+// on host. It also returns the pattern of the public URL in the output
+// of the command.
+func tunnelCommand(spec string, port int) (*exec.Cmd, *regexp.Regexp, error) {
+	local := scheme + "://localhost:" + strconv.Itoa(port)
+	forward := func(remote int) string { return fmt.Sprintf("%d:localhost:%d", remote, port) }
+	ssh := []string{"-N", "-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=30"}
+	switch spec {
+	case "ngrok":
+		return exec.Command("ngrok", "http", local, "--log", "stdout", "--log-format", "logfmt"), tunnelURLs[spec], nil
+	case "cloudflared":
+		args := []string{"tunnel", "--url", local}
+		if scheme == "https" {
+			args = append(args, "--no-tls-verify")
+		}
+		return exec.Command("cloudflared", args...), tunnelURLs[spec], nil
+	case "localhost.run":
+		return exec.Command("ssh", append(ssh, "-R", forward(80), "nokey@localhost.run")...), tunnelURLs[spec], nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, nil, fmt.Errorf("tunnel: unknown provider %q, expected %s or ssh://[user@]host[:port]", spec, strings.Join(tunnelProviders, ", "))
+	}
+	if u.Port() != "" {
+		ssh = append(ssh, "-p", u.Port())
+	}
+	target := u.Hostname()
+	if u.User != nil {
+		target = u.User.Username() + "@" + target
+	}
+	return exec.Command("ssh", append(ssh, "-R", forward(port), target)...), tunnelURLs["ssh"], nil
+}
+
+// tunnel is a tunnel command running until it is closed.
+type tunnel struct {
+	cmd       *exec.Cmd
+	closeOnce sync.Once
+}
+
+// startTunnel starts the tunnel of spec to the listener at addr, and
+// announces the public URLs it prints, or the forward of an SSH one.
+func startTunnel(spec string, addr net.Addr) (*tunnel, error) {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("tunnel: not a TCP listener")
+	}
+	cmd, pattern, err := tunnelCommand(spec, tcp.Port)
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("tunnel: %v", err)
+	}
+	if strings.HasPrefix(spec, "ssh://") {
+		u, _ := url.Parse(spec)
+		announcef("forwarding port %d of %s to the server", tcp.Port, u.Hostname())
+	}
+	go func() {
+		seen := map[string]bool{}
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			if m := pattern.FindStringSubmatch(sc.Text()); m != nil && !seen[m[1]] {
+				seen[m[1]] = true
+				announcef("public URL: %s", m[1])
+			}
+		}
+		io.Copy(io.Discard, out)
+		if err := cmd.Wait(); err != nil {
+			log.Printf("tunnel: %s exited: %v", cmd.Path, err)
+		}
+	}()
+	return &tunnel{cmd: cmd}, nil
+}
+
+// Close stops the tunnel.
+func (t *tunnel) Close() {
+	t.closeOnce.Do(func() {
+		if err := t.cmd.Process.Signal(os.Interrupt); err != nil {
+			t.cmd.Process.Kill()
+		}
+	})
+}
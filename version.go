@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// These identify the build. Release builds set them with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.date=2024-01-02T03:04:05Z"
+//
+// and otherwise they are filled in from the module and VCS information
+// the go command embeds.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version  string `json:"version"`
+	Commit   string `json:"commit,omitempty"`
+	Date     string `json:"date,omitempty"`
+	Modified bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	Go       string `json:"go"`
+}
+
+// currentBuild returns the buildInfo of the running binary.
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, Date: date, Go: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = s.Value
+			case s.Key == "vcs.time" && b.Date == "":
+				b.Date = s.Value
+			case s.Key == "vcs.modified" && commit == "":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "(devel)"
+	}
+	return b
+}
+
+// String formats b for -version.
+func (b buildInfo) String() string {
+	s := "static-server " + b.Version
+	if b.Commit != "" {
+		s += " " + b.Commit
+		if b.Modified {
+			s += "-dirty"
+		}
+	}
+	if b.Date != "" {
+		s += " " + b.Date
+	}
+	return fmt.Sprintf("%s (%s)", s, b.Go)
+}
+
+// versionHandler serves the buildInfo as JSON.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentBuild())
+}